@@ -0,0 +1,595 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package api provides a Go client for the Coordinator's client API.
+//
+// The client attests the Coordinator when it is created and only talks to the attested Coordinator afterwards.
+package api
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/edgelesssys/marblerun/attestation"
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+)
+
+// timeout is the timeout of requests to the Coordinator.
+const timeout = 30 * time.Second
+
+// Client is a client for the client API of a Coordinator.
+//
+// Errors reported by the Coordinator wrap a *clientapi.Error, whose Code can be used to handle them programmatically.
+type Client struct {
+	addr      string
+	tlsConfig *tls.Config
+	http      *http.Client
+}
+
+// NewClient attests the Coordinator at addr and returns a client that only trusts the attested Coordinator.
+//
+// clientCert authenticates the client as one of the manifest's Users, which is required for manifest updates and secrets. It may be nil.
+func NewClient(addr string, validator quote.Validator, config attestation.Config, clientCert *tls.Certificate) (*Client, error) {
+	cert, err := attestation.VerifyCoordinator(addr, validator, config)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(addr, cert, clientCert), nil
+}
+
+// NewInsecureClient returns a client for the Coordinator at addr without attesting it.
+//
+// This must only be used for Coordinators that run in simulation mode.
+func NewInsecureClient(addr string, clientCert *tls.Certificate) (*Client, error) {
+	cert, _, err := attestation.FetchCertificate(addr)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(addr, cert, clientCert), nil
+}
+
+func newClient(addr string, cert *x509.Certificate, clientCert *tls.Certificate) *Client {
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	tlsConfig := &tls.Config{RootCAs: roots}
+	if clientCert != nil {
+		tlsConfig.Certificates = []tls.Certificate{*clientCert}
+	}
+	return &Client{
+		addr:      addr,
+		tlsConfig: tlsConfig,
+		http: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}
+}
+
+// SetManifest sets the manifest of the Coordinator.
+//
+// Returns the state encryption key encrypted with each of the manifest's RecoveryKeys, if any.
+func (c *Client) SetManifest(manifest []byte) (map[string][]byte, error) {
+	return c.SetSignedManifest(manifest, nil)
+}
+
+// SetSignedManifest sets the manifest of the Coordinator together with its detached signature by one of the signers the Coordinator trusts.
+//
+// ECDSA and RSA signatures are over the SHA-256 hash of the manifest, e.g., created with "openssl dgst -sha256 -sign", Ed25519 signatures over the manifest itself.
+// Returns the state encryption key encrypted with each of the manifest's RecoveryKeys, if any.
+func (c *Client) SetSignedManifest(manifest []byte, signature []byte) (map[string][]byte, error) {
+	header := http.Header{}
+	if len(signature) > 0 {
+		header.Set(clientapi.ManifestSignatureHeader, base64.StdEncoding.EncodeToString(signature))
+	}
+	var resp struct{ EncryptionKeys map[string]string }
+	if err := c.doWithHeader(http.MethodPost, "/manifest", header, manifest, &resp); err != nil {
+		return nil, fmt.Errorf("setting manifest failed: %w", err)
+	}
+	if resp.EncryptionKeys == nil {
+		return nil, nil
+	}
+	recoveryData := make(map[string][]byte, len(resp.EncryptionKeys))
+	for name, data := range resp.EncryptionKeys {
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recovery data for %v: %v", name, err)
+		}
+		recoveryData[name] = decoded
+	}
+	return recoveryData, nil
+}
+
+// SetRootCA replaces the Coordinator's self-generated root certificate with the given CA before the manifest is set.
+//
+// certChain contains the PEM-encoded CA certificate, optionally followed by the certificates that issued it. key is its PEM-encoded ECDSA private key.
+// The manifest must pin the CA certificate in its RootCA.
+// The client trusts the previous root certificate, so a new client must be created to talk to the Coordinator afterwards.
+func (c *Client) SetRootCA(certChain, key []byte) error {
+	body, err := json.Marshal(struct{ Certificate, Key string }{string(certChain), string(key)})
+	if err != nil {
+		return err
+	}
+	if err := c.do(http.MethodPost, "/root-ca", body, nil); err != nil {
+		return fmt.Errorf("setting root CA failed: %w", err)
+	}
+	return nil
+}
+
+// GetManifestSignature returns the SHA256 hash of the Coordinator's active manifest and the name of the trusted signer that signed it, if any.
+//
+// Returns an empty hash if no manifest has been set yet.
+func (c *Client) GetManifestSignature() (signature []byte, signer string, err error) {
+	var resp struct{ ManifestSignature, Signer string }
+	if err := c.do(http.MethodGet, "/manifest", nil, &resp); err != nil {
+		return nil, "", fmt.Errorf("getting manifest failed: %w", err)
+	}
+	if signature, err = hex.DecodeString(resp.ManifestSignature); err != nil {
+		return nil, "", err
+	}
+	return signature, resp.Signer, nil
+}
+
+// GetManifestHistory returns the versions of the manifest in the order they have been set and updated.
+//
+// The client must authenticate as one of the manifest's Users.
+func (c *Client) GetManifestHistory() ([]clientapi.ManifestVersion, error) {
+	var resp struct {
+		Versions []clientapi.ManifestVersion
+	}
+	if err := c.do(http.MethodGet, "/manifest/history", nil, &resp); err != nil {
+		return nil, fmt.Errorf("getting manifest history failed: %w", err)
+	}
+	return resp.Versions, nil
+}
+
+// GetManifestDiff returns the changes between two versions of the manifest history, sorted by path.
+//
+// The client must authenticate as one of the manifest's Users.
+func (c *Client) GetManifestDiff(from, to uint) ([]clientapi.ManifestChange, error) {
+	var resp struct {
+		Changes []clientapi.ManifestChange
+	}
+	route := "/manifest/diff?" + url.Values{"from": {fmt.Sprint(from)}, "to": {fmt.Sprint(to)}}.Encode()
+	if err := c.do(http.MethodGet, route, nil, &resp); err != nil {
+		return nil, fmt.Errorf("getting manifest diff failed: %w", err)
+	}
+	return resp.Changes, nil
+}
+
+// UpdateManifest proposes or acknowledges a manifest update. The client must have been created with the certificate of one of the manifest's Users.
+//
+// Returns the number of acknowledgements that are still missing before the update takes effect.
+func (c *Client) UpdateManifest(update []byte) (int, error) {
+	var resp struct{ Remaining int }
+	if err := c.do(http.MethodPost, "/update", update, &resp); err != nil {
+		return -1, fmt.Errorf("updating manifest failed: %w", err)
+	}
+	return resp.Remaining, nil
+}
+
+// GetPendingUpdate returns the manifest update that is waiting for acknowledgements, the users who already acknowledged it, and the number of acknowledgements that are still missing.
+//
+// Returns an empty update if there is none.
+func (c *Client) GetPendingUpdate() ([]byte, []string, int, error) {
+	var resp struct {
+		Update         string
+		AcknowledgedBy []string
+		Remaining      int
+	}
+	if err := c.do(http.MethodGet, "/update", nil, &resp); err != nil {
+		return nil, nil, -1, fmt.Errorf("getting pending update failed: %w", err)
+	}
+	return []byte(resp.Update), resp.AcknowledgedBy, resp.Remaining, nil
+}
+
+// CancelPendingUpdate discards the manifest update that is waiting for acknowledgements.
+func (c *Client) CancelPendingUpdate() error {
+	if err := c.do(http.MethodDelete, "/update", nil, nil); err != nil {
+		return fmt.Errorf("canceling pending update failed: %w", err)
+	}
+	return nil
+}
+
+// GetStatus returns the status code and a description of the Coordinator's state.
+func (c *Client) GetStatus() (int, string, error) {
+	var resp struct {
+		Code   int
+		Status string
+	}
+	if err := c.do(http.MethodGet, "/status", nil, &resp); err != nil {
+		return -1, "", fmt.Errorf("getting status failed: %w", err)
+	}
+	return resp.Code, resp.Status, nil
+}
+
+// GetMarbleStatus returns the activation statistics of each Marble type of the active manifest.
+func (c *Client) GetMarbleStatus() (map[string]clientapi.MarbleStatus, error) {
+	var resp struct {
+		Marbles map[string]clientapi.MarbleStatus
+	}
+	if err := c.do(http.MethodGet, "/marbles", nil, &resp); err != nil {
+		return nil, fmt.Errorf("getting marble status failed: %w", err)
+	}
+	return resp.Marbles, nil
+}
+
+// GetActivatedMarbles returns the activated Marbles, or only the Marble with the UUID if it isn't empty.
+//
+// The client must authenticate as one of the manifest's Users who is permitted to list Marbles.
+func (c *Client) GetActivatedMarbles(marbleUUID string) ([]clientapi.Marble, error) {
+	var resp struct {
+		Marbles []clientapi.Marble
+	}
+	route := "/marbles/inventory"
+	if marbleUUID != "" {
+		route += "?" + url.Values{"uuid": {marbleUUID}}.Encode()
+	}
+	if err := c.do(http.MethodGet, route, nil, &resp); err != nil {
+		return nil, fmt.Errorf("getting activated marbles failed: %w", err)
+	}
+	return resp.Marbles, nil
+}
+
+// GetAuditLog returns the entries of the Coordinator's audit log.
+//
+// The client must authenticate as one of the manifest's Users who is permitted to read the audit log.
+// Returns an error if the entries are not correctly chained.
+func (c *Client) GetAuditLog() ([]clientapi.AuditEntry, error) {
+	var resp struct {
+		Entries []clientapi.AuditEntry
+	}
+	if err := c.do(http.MethodGet, "/audit", nil, &resp); err != nil {
+		return nil, fmt.Errorf("getting audit log failed: %w", err)
+	}
+	if err := clientapi.VerifyAuditLog(resp.Entries); err != nil {
+		return nil, fmt.Errorf("invalid audit log: %w", err)
+	}
+	return resp.Entries, nil
+}
+
+// QuoteUserData returns a quote of the Coordinator over user data, e.g., a session public key. The client must authenticate as one of
+// the manifest's Users who is permitted to quote user data.
+//
+// Verify the quote with attestation.VerifyUserDataQuote. The quote is empty if the Coordinator runs in simulation mode.
+func (c *Client) QuoteUserData(userData []byte) ([]byte, error) {
+	body, err := json.Marshal(struct{ UserData []byte }{userData})
+	if err != nil {
+		return nil, err
+	}
+	var resp struct{ Quote []byte }
+	if err := c.do(http.MethodPost, "/quote/userdata", body, &resp); err != nil {
+		return nil, fmt.Errorf("quoting user data failed: %w", err)
+	}
+	return resp.Quote, nil
+}
+
+// GetTopology returns the marble types of the mesh with their activations and the TLS tags that connect them.
+//
+// Like the other dashboard methods, it requires a client certificate of a user who is permitted to view the dashboard.
+func (c *Client) GetTopology() (clientapi.Topology, error) {
+	var topology clientapi.Topology
+	if err := c.do(http.MethodGet, "/dashboard/topology", nil, &topology); err != nil {
+		return clientapi.Topology{}, fmt.Errorf("getting topology failed: %w", err)
+	}
+	return topology, nil
+}
+
+// GetRecentActivations returns the latest activations of marbles, the most recent first. A limit of 0 returns the Coordinator's default number.
+func (c *Client) GetRecentActivations(limit uint) ([]clientapi.Activation, error) {
+	var resp struct {
+		Activations []clientapi.Activation
+	}
+	if err := c.do(http.MethodGet, "/dashboard/activations?"+url.Values{"limit": {fmt.Sprint(limit)}}.Encode(), nil, &resp); err != nil {
+		return nil, fmt.Errorf("getting recent activations failed: %w", err)
+	}
+	return resp.Activations, nil
+}
+
+// GetManifestSummary returns a summary of the active manifest with the measurements of its packages.
+func (c *Client) GetManifestSummary() (clientapi.ManifestSummary, error) {
+	var summary clientapi.ManifestSummary
+	if err := c.do(http.MethodGet, "/dashboard/manifest", nil, &summary); err != nil {
+		return clientapi.ManifestSummary{}, fmt.Errorf("getting manifest summary failed: %w", err)
+	}
+	return summary, nil
+}
+
+// GetTCBStatus returns the TCB requirements of the manifest, the activations rejected because of their TCB, and the status of the collateral.
+func (c *Client) GetTCBStatus() (clientapi.TCBStatus, error) {
+	var status clientapi.TCBStatus
+	if err := c.do(http.MethodGet, "/dashboard/tcb", nil, &status); err != nil {
+		return clientapi.TCBStatus{}, fmt.Errorf("getting TCB status failed: %w", err)
+	}
+	return status, nil
+}
+
+// Recover sends a recovery key or a recovery share to a Coordinator in recovery mode.
+//
+// Returns the number of recovery shares that are still required to recover the state.
+func (c *Client) Recover(secret []byte) (int, error) {
+	var resp struct{ Remaining int }
+	if err := c.do(http.MethodPost, "/recover", secret, &resp); err != nil {
+		return -1, fmt.Errorf("recovery failed: %w", err)
+	}
+	return resp.Remaining, nil
+}
+
+// AcceptRollback recovers like Recover, but accepts a sealed state that is older than the Coordinator's rollback counter.
+//
+// The client must authenticate as one of the manifest's Users who is permitted to recover, if the manifest defines any.
+func (c *Client) AcceptRollback(secret []byte) (int, error) {
+	var resp struct{ Remaining int }
+	if err := c.do(http.MethodPost, "/recover?acceptRollback=1", secret, &resp); err != nil {
+		return -1, fmt.Errorf("recovery failed: %w", err)
+	}
+	return resp.Remaining, nil
+}
+
+// ExportBackup returns an encrypted backup of the Coordinator's state.
+//
+// The client must authenticate as one of the manifest's Users who is permitted to back up the state.
+// The backup key is encrypted with each of the manifest's RecoveryKeys, see clientapi.Backup.DecryptKey.
+func (c *Client) ExportBackup() (clientapi.Backup, error) {
+	var backup clientapi.Backup
+	if err := c.do(http.MethodGet, "/backup", nil, &backup); err != nil {
+		return clientapi.Backup{}, fmt.Errorf("exporting backup failed: %w", err)
+	}
+	return backup, nil
+}
+
+// RestoreBackup restores a backup into a Coordinator that has no manifest yet or is in recovery mode.
+//
+// key is the backup key, or a share of it, decrypted with a recovery key. The client must authenticate as one of the manifest's Users
+// who is permitted to recover, if the manifest of the backup defines any. Returns the number of shares that are still required and,
+// once the backup has been restored, the new state encryption key encrypted with each of the manifest's RecoveryKeys.
+func (c *Client) RestoreBackup(backup clientapi.Backup, key []byte) (int, map[string][]byte, error) {
+	body, err := json.Marshal(struct {
+		Backup clientapi.Backup
+		Key    []byte
+	}{backup, key})
+	if err != nil {
+		return -1, nil, err
+	}
+	var resp struct {
+		Remaining      int
+		EncryptionKeys map[string]string
+	}
+	if err := c.do(http.MethodPost, "/backup/restore", body, &resp); err != nil {
+		return -1, nil, fmt.Errorf("restoring backup failed: %w", err)
+	}
+	if resp.EncryptionKeys == nil {
+		return resp.Remaining, nil, nil
+	}
+	recoveryData := make(map[string][]byte, len(resp.EncryptionKeys))
+	for name, data := range resp.EncryptionKeys {
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return -1, nil, fmt.Errorf("invalid recovery data for %v: %v", name, err)
+		}
+		recoveryData[name] = decoded
+	}
+	return resp.Remaining, recoveryData, nil
+}
+
+// HandOff requests the state of the Coordinator for the new Coordinator that has created req, see clientapi.HandoffRequest.
+//
+// The client must authenticate as one of the manifest's Users who is permitted to hand off the state.
+// The returned state can only be decrypted by the new Coordinator. The Coordinator stops changing its state afterwards.
+func (c *Client) HandOff(req clientapi.HandoffRequest) (clientapi.Handoff, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return clientapi.Handoff{}, err
+	}
+	var handoff clientapi.Handoff
+	if err := c.do(http.MethodPost, "/handoff", body, &handoff); err != nil {
+		return clientapi.Handoff{}, fmt.Errorf("handoff failed: %w", err)
+	}
+	return handoff, nil
+}
+
+// WriteSecrets sets the values of user-defined secrets.
+//
+// The client must authenticate as one of the manifest's Users who is permitted to write all of the secrets.
+func (c *Client) WriteSecrets(secrets map[string]clientapi.Secret) error {
+	body, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	if err := c.do(http.MethodPost, "/secrets", body, nil); err != nil {
+		return fmt.Errorf("writing secrets failed: %w", err)
+	}
+	return nil
+}
+
+// ReadSecrets returns the values of the named secrets.
+//
+// The client must authenticate as one of the manifest's Users who is permitted to read all of the secrets.
+func (c *Client) ReadSecrets(names []string) (map[string]clientapi.Secret, error) {
+	var resp struct {
+		Secrets map[string]clientapi.Secret
+	}
+	query := url.Values{"s": names}
+	if err := c.do(http.MethodGet, "/secrets?"+query.Encode(), nil, &resp); err != nil {
+		return nil, fmt.Errorf("reading secrets failed: %w", err)
+	}
+	return resp.Secrets, nil
+}
+
+// RotateSecrets rotates the named secrets to a new version. Marbles receive the new versions on their next activation.
+//
+// The client must authenticate as one of the manifest's Users who is permitted to rotate all of the secrets.
+// Returns the new version of each secret.
+func (c *Client) RotateSecrets(names []string) (map[string]uint, error) {
+	body, err := json.Marshal(names)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Versions map[string]uint
+	}
+	if err := c.do(http.MethodPost, "/secrets/rotate", body, &resp); err != nil {
+		return nil, fmt.Errorf("rotating secrets failed: %w", err)
+	}
+	return resp.Versions, nil
+}
+
+// RevokeMarble revokes the certificates of the marble with the given UUID, or of all marbles of the type if marbleUUID is empty.
+//
+// The client must authenticate as one of the manifest's Users who is permitted to revoke marbles.
+// Returns the number of revoked certificates.
+func (c *Client) RevokeMarble(marbleType, marbleUUID string) (int, error) {
+	body, err := json.Marshal(struct{ MarbleType, UUID string }{marbleType, marbleUUID})
+	if err != nil {
+		return 0, err
+	}
+	var resp struct{ Revoked int }
+	if err := c.do(http.MethodPost, "/revoke", body, &resp); err != nil {
+		return 0, fmt.Errorf("revoking marble failed: %w", err)
+	}
+	return resp.Revoked, nil
+}
+
+// ResetActivations sets the activation counter of the marble type, or of its activations on the infrastructure if it isn't empty.
+//
+// The client must authenticate as one of the manifest's Users who is permitted to reset activations.
+// Setting the counter to 0 permits MaxActivations new activations of the type, e.g., after marbles have crashed.
+func (c *Client) ResetActivations(marbleType, infrastructure string, activations uint) error {
+	body, err := json.Marshal(struct {
+		MarbleType, Infrastructure string
+		Activations                uint
+	}{marbleType, infrastructure, activations})
+	if err != nil {
+		return err
+	}
+	if err := c.do(http.MethodPost, "/marbles/activations", body, nil); err != nil {
+		return fmt.Errorf("resetting activations failed: %w", err)
+	}
+	return nil
+}
+
+// GetMaintenance returns whether the activations of Marbles are paused.
+func (c *Client) GetMaintenance() (clientapi.Maintenance, error) {
+	var resp clientapi.Maintenance
+	if err := c.do(http.MethodGet, "/maintenance", nil, &resp); err != nil {
+		return clientapi.Maintenance{}, fmt.Errorf("getting maintenance mode failed: %w", err)
+	}
+	return resp, nil
+}
+
+// SetMaintenance pauses the activations of Marbles with an optional reason, or resumes them if paused is false.
+//
+// The client must authenticate as one of the manifest's Users who is permitted to pause activations.
+func (c *Client) SetMaintenance(paused bool, reason string) error {
+	body, err := json.Marshal(struct {
+		Paused bool
+		Reason string `json:",omitempty"`
+	}{paused, reason})
+	if err != nil {
+		return err
+	}
+	if err := c.do(http.MethodPost, "/maintenance", body, nil); err != nil {
+		return fmt.Errorf("setting maintenance mode failed: %w", err)
+	}
+	return nil
+}
+
+// IssueCertificate issues a certificate for the PEM-encoded CSR to one of the manifest's ExternalServices.
+//
+// The client must authenticate as one of the manifest's Users who is permitted to issue certificates for the service.
+// validFor is the requested validity, 0 requests the maximum validity of the service.
+// Returns the PEM-encoded certificate chain and the PEM-encoded root certificate of the Coordinator.
+func (c *Client) IssueCertificate(service string, csr []byte, validFor time.Duration) ([]byte, []byte, error) {
+	body, err := json.Marshal(struct {
+		Service, CSR string
+		ValidFor     uint64
+	}{service, string(csr), uint64(validFor / time.Second)})
+	if err != nil {
+		return nil, nil, err
+	}
+	var resp struct{ Certificate, CA string }
+	if err := c.do(http.MethodPost, "/certificates", body, &resp); err != nil {
+		return nil, nil, fmt.Errorf("issuing certificate failed: %w", err)
+	}
+	return []byte(resp.Certificate), []byte(resp.CA), nil
+}
+
+// GetCertificateChain returns the certificate chain the Coordinator serves.
+//
+// The first certificate is the Coordinator's RA-TLS certificate, the last one is its root certificate.
+func (c *Client) GetCertificateChain() ([]*x509.Certificate, error) {
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", c.addr, c.tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, errors.New("Coordinator did not send a certificate")
+	}
+	return certs, nil
+}
+
+// do sends a request to the given route of the client API and decodes the data of the response into result, if result is not nil.
+func (c *Client) do(method, route string, body []byte, result interface{}) error {
+	return c.doWithHeader(method, route, nil, body, result)
+}
+
+// doWithHeader is like do, but adds the header to the request.
+func (c *Client) doWithHeader(method, route string, header http.Header, body []byte, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, "https://"+c.addr+clientapi.BasePath+route, reader)
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Accept", clientapi.ContentType)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var envelope struct {
+		Status string
+		Data   json.RawMessage
+		Error  *clientapi.Error
+	}
+	if err := json.Unmarshal(respBody, &envelope); err != nil {
+		return fmt.Errorf("%v: invalid response: %v", resp.Status, err)
+	}
+	if envelope.Status != clientapi.StatusSuccess {
+		if envelope.Error == nil {
+			return fmt.Errorf("%v: request failed", resp.Status)
+		}
+		return envelope.Error
+	}
+	if result == nil || len(envelope.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(envelope.Data, result)
+}
@@ -0,0 +1,200 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package api
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/edgelesssys/marblerun/attestation"
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+	"github.com/edgelesssys/marblerun/coordinator/core"
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/coordinator/server"
+	"github.com/edgelesssys/marblerun/test"
+	"github.com/edgelesssys/marblerun/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, addr, cleanup := setupCoordinator(t)
+	defer cleanup()
+
+	rootCert, coordinatorQuote, err := attestation.FetchCertificate(addr)
+	require.NoError(err)
+	config := attestation.Config{Package: quote.PackageProperties{SignerID: "1234"}}
+	validator := quote.NewMockValidator()
+	validator.AddValidQuote(coordinatorQuote, rootCert.Raw, config.Package, config.Infrastructure)
+
+	// attestation fails
+	_, err = NewClient(addr, quote.NewFailValidator(), config, nil)
+	assert.Error(err)
+
+	adminCert := util.TLSCertFromDER(test.AdminCert.Raw, test.AdminPrivateKey)
+	client, err := NewClient(addr, validator, config, adminCert)
+	require.NoError(err)
+
+	chain, err := client.GetCertificateChain()
+	require.NoError(err)
+	require.Len(chain, 2)
+	assert.Equal(rootCert.Raw, chain[1].Raw)
+	assert.Equal(coordinatorQuote, quote.GetRATLSQuote(chain[0]))
+
+	code, _, err := client.GetStatus()
+	require.NoError(err)
+	assert.Equal(2, code)
+
+	signature, signer, err := client.GetManifestSignature()
+	require.NoError(err)
+	assert.Empty(signature)
+	assert.Empty(signer)
+
+	// set a manifest that allows two admins to update it together
+	var manifest core.Manifest
+	require.NoError(json.Unmarshal([]byte(test.ManifestJSONWithRecoveryKey), &manifest))
+	manifest.Users = map[string]core.User{
+		"admin":  {Certificate: test.CertPEM(test.AdminCert), Roles: []string{"updater"}},
+		"admin2": {Certificate: test.CertPEM(test.SecondAdminCert), Roles: []string{"updater"}},
+	}
+	manifest.Roles = map[string]core.Role{
+		"updater": {ResourceType: "Manifest", Actions: []string{"ProposeUpdate", "AcknowledgeUpdate", "CancelUpdate"}},
+		"tokens":  {ResourceType: "Secrets", Actions: []string{"ReadSecret", "WriteSecret", "RotateSecret"}},
+		"auditor": {ResourceType: "AuditLog", Actions: []string{"ReadAuditLog"}},
+	}
+	manifest.Users["admin"] = core.User{Certificate: test.CertPEM(test.AdminCert), Roles: []string{"updater", "tokens", "auditor"}}
+	if manifest.Secrets == nil {
+		manifest.Secrets = map[string]core.Secret{}
+	}
+	manifest.Secrets["api_token"] = core.Secret{Type: "plain", UserDefined: true}
+	manifest.Secrets["shared_key"] = core.Secret{Type: "symmetric-key", Size: 128, Shared: true}
+	manifest.UpdateThreshold = 2
+	rawManifest, err := json.Marshal(manifest)
+	require.NoError(err)
+
+	// the Coordinator only accepts manifests signed by a trusted signer
+	signerPub, signerPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(err)
+	encodedPub, err := x509.MarshalPKIXPublicKey(signerPub)
+	require.NoError(err)
+	require.NoError(c.SetManifestSigners(map[string]string{"release": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: encodedPub}))}))
+	_, err = client.SetManifest(rawManifest)
+	assert.Error(err)
+	recoveryData, err := client.SetSignedManifest(rawManifest, ed25519.Sign(signerPriv, rawManifest))
+	require.NoError(err)
+	assert.Len(recoveryData, 1)
+	_, err = client.SetManifest(rawManifest)
+	assert.Error(err)
+
+	signature, signer, err = client.GetManifestSignature()
+	require.NoError(err)
+	hash := sha256.Sum256(rawManifest)
+	assert.Equal(hash[:], signature)
+	assert.Equal("release", signer)
+
+	// the JSON mapping of the marble status must match the server's
+	marbles, err := client.GetMarbleStatus()
+	require.NoError(err)
+	expectedMarbles, err := c.GetMarbleStatus(context.TODO())
+	require.NoError(err)
+	assert.Equal(expectedMarbles, marbles)
+
+	// the audit log is verified by the client
+	entries, err := client.GetAuditLog()
+	require.NoError(err)
+	require.Len(entries, 1)
+	assert.Equal(clientapi.AuditEventSetManifest, entries[0].Event)
+
+	update, _, _, err := client.GetPendingUpdate()
+	require.NoError(err)
+	assert.Empty(update)
+	remaining, err := client.UpdateManifest([]byte(test.UpdateManifestJSON))
+	require.NoError(err)
+	assert.Equal(1, remaining)
+	update, acknowledgedBy, remaining, err := client.GetPendingUpdate()
+	require.NoError(err)
+	assert.Equal(test.UpdateManifestJSON, string(update))
+	assert.Equal([]string{"admin"}, acknowledgedBy)
+	assert.Equal(1, remaining)
+	assert.NoError(client.CancelPendingUpdate())
+	assert.Error(client.CancelPendingUpdate())
+
+	// updates require a client certificate
+	anonymous, err := NewClient(addr, validator, config, nil)
+	require.NoError(err)
+	_, err = anonymous.UpdateManifest([]byte(test.UpdateManifestJSON))
+	var apiErr *clientapi.Error
+	require.True(errors.As(err, &apiErr))
+	assert.Equal(clientapi.ErrorUnauthorized, apiErr.Code)
+	_, _, _, err = anonymous.GetPendingUpdate()
+	assert.Error(err)
+
+	// write and read a user-defined secret
+	require.NoError(client.WriteSecrets(map[string]clientapi.Secret{"api_token": {Private: []byte("token")}}))
+	secrets, err := client.ReadSecrets([]string{"api_token"})
+	require.NoError(err)
+	assert.Equal(clientapi.Secret{Type: "plain", Private: []byte("token"), Public: []byte("token"), Version: 1}, secrets["api_token"])
+	_, err = anonymous.ReadSecrets([]string{"api_token"})
+	require.True(errors.As(err, &apiErr))
+	assert.Equal(clientapi.ErrorUnauthorized, apiErr.Code)
+	versions, err := client.RotateSecrets([]string{"shared_key"})
+	require.NoError(err)
+	assert.Equal(map[string]uint{"shared_key": 2}, versions)
+
+	// the Coordinator is not in recovery mode
+	_, err = client.Recover([]byte("key"))
+	assert.Error(err)
+
+	// the admin is not permitted to revoke or list marbles
+	_, err = client.RevokeMarble("frontend", "")
+	require.True(errors.As(err, &apiErr))
+	assert.Equal(clientapi.ErrorUnauthorized, apiErr.Code)
+	_, err = client.GetActivatedMarbles("")
+	require.True(errors.As(err, &apiErr))
+	assert.Equal(clientapi.ErrorUnauthorized, apiErr.Code)
+}
+
+func TestNewInsecureClient(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	_, addr, cleanup := setupCoordinator(t)
+	defer cleanup()
+
+	client, err := NewInsecureClient(addr, nil)
+	require.NoError(err)
+	_, status, err := client.GetStatus()
+	assert.NoError(err)
+	assert.NotEmpty(status)
+
+	_, err = NewInsecureClient("localhost:0", &tls.Certificate{})
+	assert.Error(err)
+}
+
+// setupCoordinator starts a Coordinator client API server and returns the Coordinator and the server's address.
+func setupCoordinator(t *testing.T) (*core.Core, string, func()) {
+	c := core.NewCoreWithMocks()
+	tlsConfig, err := c.GetTLSConfig()
+	require.NoError(t, err)
+	s := httptest.NewUnstartedServer(server.CreateServeMux(c))
+	// serve the Coordinator's certificate chain instead of httptest's default certificate
+	s.Listener = tls.NewListener(s.Listener, tlsConfig)
+	s.Start()
+	return c, s.Listener.Addr().String(), s.Close
+}
@@ -0,0 +1,94 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package attestation provides functions for clients to establish trust in the Coordinator through remote attestation.
+package attestation
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/coordinator/quote/dcapvalidator"
+)
+
+// Config contains the expected properties of the Coordinator's quote.
+type Config struct {
+	Package        quote.PackageProperties
+	Infrastructure quote.InfrastructureProperties
+}
+
+// FetchCertificate fetches the Coordinator's root certificate and the quote over it from the TLS handshake with addr.
+//
+// The Coordinator serves an RA-TLS certificate that is signed by its root certificate and embeds the quote.
+// Only the signature of the RA-TLS certificate is verified, but neither the root certificate nor the quote.
+func FetchCertificate(addr string) (*x509.Certificate, []byte, error) {
+	// the Coordinator's certificate is not trusted yet, it must be verified through the quote
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 30 * time.Second}, "tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) != 2 {
+		return nil, nil, fmt.Errorf("Coordinator sent %d certificates instead of an RA-TLS certificate and its root certificate", len(certs))
+	}
+	raTLSCert, rootCert := certs[0], certs[1]
+	if err := raTLSCert.CheckSignatureFrom(rootCert); err != nil {
+		return nil, nil, fmt.Errorf("RA-TLS certificate is not signed by the root certificate: %v", err)
+	}
+	return rootCert, quote.GetRATLSQuote(raTLSCert), nil
+}
+
+// VerifyCoordinator fetches the Coordinator's root certificate from addr and verifies the quote over it with the given validator.
+//
+// Returns the Coordinator's certificate if the quote complies with config.
+func VerifyCoordinator(addr string, validator quote.Validator, config Config) (*x509.Certificate, error) {
+	cert, certQuote, err := FetchCertificate(addr)
+	if err != nil {
+		return nil, err
+	}
+	if len(certQuote) == 0 {
+		return nil, errors.New("Coordinator runs in simulation mode and cannot be attested")
+	}
+	if err := validator.Validate(certQuote, cert.Raw, config.Package, config.Infrastructure); err != nil {
+		return nil, fmt.Errorf("attesting the Coordinator failed: %v", err)
+	}
+	return cert, nil
+}
+
+// VerifyUserDataQuote verifies a quote of the Coordinator over user data with the given validator, e.g., a quote that has been obtained with
+// api.Client.QuoteUserData.
+//
+// Returns an error if the quote doesn't comply with config or hasn't been issued for the user data.
+func VerifyUserDataQuote(userDataQuote []byte, userData []byte, validator quote.Validator, config Config) error {
+	if len(userDataQuote) == 0 {
+		return errors.New("Coordinator runs in simulation mode and cannot be attested")
+	}
+	if err := validator.Validate(userDataQuote, clientapi.UserDataQuoteMessage(userData), config.Package, config.Infrastructure); err != nil {
+		return fmt.Errorf("verifying the quote over the user data failed: %v", err)
+	}
+	return nil
+}
+
+// GetCertPool verifies the SGX DCAP quote of the Coordinator at addr and returns a pool that only contains the Coordinator's certificate.
+//
+// The pool can be used as RootCAs of a tls.Config to connect to the Coordinator and the Marbles of its mesh.
+func GetCertPool(addr string, config Config) (*x509.CertPool, error) {
+	cert, err := VerifyCoordinator(addr, dcapvalidator.NewDCAPValidator(), config)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return pool, nil
+}
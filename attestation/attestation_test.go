@@ -0,0 +1,83 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package attestation
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+	"github.com/edgelesssys/marblerun/coordinator/core"
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/coordinator/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyCoordinator(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c := core.NewCoreWithMocks()
+	tlsConfig, err := c.GetTLSConfig()
+	require.NoError(err)
+	s := httptest.NewUnstartedServer(server.CreateServeMux(c))
+	// serve the Coordinator's certificate chain instead of httptest's default certificate
+	s.Listener = tls.NewListener(s.Listener, tlsConfig)
+	s.Start()
+	defer s.Close()
+	addr := s.Listener.Addr().String()
+
+	cert, coordinatorQuote, err := FetchCertificate(addr)
+	require.NoError(err)
+	assert.Equal(core.CoordinatorName, cert.Subject.CommonName)
+
+	config := Config{Package: quote.PackageProperties{SignerID: "1234"}}
+	validator := quote.NewMockValidator()
+	validator.AddValidQuote(coordinatorQuote, cert.Raw, config.Package, config.Infrastructure)
+
+	verifiedCert, err := VerifyCoordinator(addr, validator, config)
+	require.NoError(err)
+	assert.Equal(cert, verifiedCert)
+
+	// wrong package
+	_, err = VerifyCoordinator(addr, validator, Config{Package: quote.PackageProperties{SignerID: "5678"}})
+	assert.Error(err)
+
+	// the mock quote is not a valid DCAP quote
+	_, err = GetCertPool(addr, config)
+	assert.Error(err)
+
+	// no Coordinator
+	_, _, err = FetchCertificate("localhost:0")
+	assert.Error(err)
+}
+
+func TestVerifyUserDataQuote(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	issuer := quote.NewMockIssuer()
+	userData := []byte("session public key")
+	userDataQuote, err := issuer.Issue(clientapi.UserDataQuoteMessage(userData))
+	require.NoError(err)
+	config := Config{Package: quote.PackageProperties{SignerID: "1234"}}
+	validator := quote.NewMockValidator()
+	validator.AddValidQuote(userDataQuote, clientapi.UserDataQuoteMessage(userData), config.Package, config.Infrastructure)
+
+	assert.NoError(VerifyUserDataQuote(userDataQuote, userData, validator, config))
+	assert.Error(VerifyUserDataQuote(userDataQuote, []byte("other key"), validator, config))
+	assert.Error(VerifyUserDataQuote(userDataQuote, userData, validator, Config{Package: quote.PackageProperties{SignerID: "5678"}}))
+	assert.Error(VerifyUserDataQuote(nil, userData, validator, config))
+
+	// a quote over the data itself, e.g., the Coordinator's quote over its root certificate, isn't a quote over user data
+	certQuote, err := issuer.Issue(userData)
+	require.NoError(err)
+	validator.AddValidQuote(certQuote, userData, config.Package, config.Infrastructure)
+	assert.Error(VerifyUserDataQuote(certQuote, userData, validator, config))
+}
@@ -0,0 +1,595 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package cli implements the marblerun command line interface for interacting with the Coordinator.
+package cli
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/edgelesssys/marblerun/api"
+	"github.com/edgelesssys/marblerun/attestation"
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+	"github.com/edgelesssys/marblerun/coordinator/core"
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/coordinator/quote/dcapvalidator"
+	"github.com/edgelesssys/marblerun/coordinator/quote/sigstruct"
+)
+
+const usage = `Usage: marblerun <command> [flags]
+
+Commands:
+  certificate              print the Coordinator's attested root certificate in PEM format
+  status                   print the state of the Coordinator and the activations of each Marble type
+  audit                    verify and print the Coordinator's audit log
+                           (requires -cert and -key of a User permitted to read the audit log)
+  root-ca set <certificate file> <key file>
+                           replace the Coordinator's root certificate with a CA of an existing PKI
+                           before the manifest is set
+  manifest set <file> [<signature file>]
+                           upload a manifest to the Coordinator, with its detached signature if the
+                           Coordinator only accepts manifests of trusted signers
+  manifest get             print the hash of the Coordinator's active manifest and its signer, if any
+  manifest verify <file> [<update file>...]
+                           verify that a local manifest, with the given updates applied in order,
+                           matches the Coordinator's active manifest
+  manifest history         print the versions of the Coordinator's manifest with their hashes
+                           (requires -cert and -key of one of the manifest's Users)
+  manifest canonical <file>
+                           print the JSON form of a YAML or TOML manifest, which is what trusted
+                           signers sign
+  manifest package <enclave or SIGSTRUCT file> [<package name> <manifest file>]
+                           print the manifest's Package entry for a signed enclave, or the manifest
+                           with the entry merged into its Packages
+  manifest preview <file> <marble type>
+                           print the Files, Env and Argv of a Marble of the type with fake secrets,
+                           without a Coordinator, to debug the templates of its Parameters
+  backup export <file>     write an encrypted backup of the Coordinator's state to the file
+                           (requires -cert and -key of a User permitted to back up the state)
+  backup restore <file> <recovery key file>
+                           decrypt the backup key with the PEM-encoded RSA recovery key and restore
+                           the backup into a Coordinator that has no manifest or is in recovery mode
+
+Flags:
+`
+
+// cli contains the state shared by all commands.
+type cli struct {
+	out       io.Writer
+	validator quote.Validator
+	flags     *flag.FlagSet
+
+	addr       string
+	configFile string
+	insecure   bool
+	uniqueID   bool
+	certFile   string
+	keyFile    string
+}
+
+// Run executes the command given by args and writes its output to out.
+func Run(args []string, out io.Writer) error {
+	return run(args, out, dcapvalidator.NewDCAPValidator())
+}
+
+func run(args []string, out io.Writer, validator quote.Validator) error {
+	c := &cli{out: out, validator: validator}
+	c.flags = flag.NewFlagSet("marblerun", flag.ContinueOnError)
+	c.flags.SetOutput(out)
+	c.flags.StringVar(&c.addr, "coordinator", "localhost:4433", "address of the Coordinator's client API")
+	c.flags.StringVar(&c.configFile, "config", "", "JSON file with the expected Package and Infrastructure properties of the Coordinator")
+	c.flags.BoolVar(&c.insecure, "insecure", false, "do not attest the Coordinator (only for development)")
+	c.flags.BoolVar(&c.uniqueID, "unique-id", false, "identify the package by its UniqueID instead of its SignerID, ProductID and SecurityVersion")
+	c.flags.StringVar(&c.certFile, "cert", "", "PEM-encoded client certificate of one of the manifest's Users")
+	c.flags.StringVar(&c.keyFile, "key", "", "PEM-encoded private key of the client certificate")
+	c.flags.Usage = func() {
+		fmt.Fprint(out, usage)
+		c.flags.PrintDefaults()
+	}
+
+	// the command comes first, flags may follow
+	var command []string
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command = append(command, args[0])
+		args = args[1:]
+	}
+	if err := c.flags.Parse(args); err != nil {
+		return err
+	}
+	command = append(command, c.flags.Args()...)
+
+	if len(command) == 0 {
+		c.flags.Usage()
+		return errors.New("no command given")
+	}
+	switch command[0] {
+	case "manifest":
+		return c.manifest(command[1:])
+	case "backup":
+		return c.backup(command[1:])
+	case "root-ca":
+		if len(command) != 4 || command[1] != "set" {
+			return errors.New("usage: root-ca set <certificate file> <key file>")
+		}
+		return c.rootCASet(command[2], command[3])
+	case "certificate":
+		if len(command) != 1 {
+			return errors.New("usage: certificate")
+		}
+		return c.certificate()
+	case "status":
+		if len(command) != 1 {
+			return errors.New("usage: status")
+		}
+		return c.status()
+	case "audit":
+		if len(command) != 1 {
+			return errors.New("usage: audit")
+		}
+		return c.audit()
+	}
+	c.flags.Usage()
+	return fmt.Errorf("unknown command: %v", command[0])
+}
+
+func (c *cli) certificate() error {
+	client, err := c.newClient()
+	if err != nil {
+		return err
+	}
+	chain, err := client.GetCertificateChain()
+	if err != nil {
+		return err
+	}
+	return pem.Encode(c.out, &pem.Block{Type: "CERTIFICATE", Bytes: chain[len(chain)-1].Raw})
+}
+
+func (c *cli) rootCASet(certFile, keyFile string) error {
+	certChain, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return err
+	}
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return err
+	}
+	client, err := c.newClient()
+	if err != nil {
+		return err
+	}
+	if err := client.SetRootCA(certChain, key); err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, "Root CA successfully set")
+	return nil
+}
+
+func (c *cli) status() error {
+	client, err := c.newClient()
+	if err != nil {
+		return err
+	}
+	_, status, err := client.GetStatus()
+	if err != nil {
+		return err
+	}
+	marbles, err := client.GetMarbleStatus()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(c.out, status)
+	if len(marbles) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(marbles))
+	for name := range marbles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(c.out)
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MARBLE TYPE\tACTIVATIONS\tREMAINING")
+	for _, name := range names {
+		marble := marbles[name]
+		remaining := "unlimited"
+		if marble.Remaining >= 0 {
+			remaining = fmt.Sprintf("%d/%d", marble.Remaining, marble.MaxActivations)
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\n", name, marble.Activations, remaining)
+	}
+	return w.Flush()
+}
+
+func (c *cli) audit() error {
+	client, err := c.newClient()
+	if err != nil {
+		return err
+	}
+	entries, err := client.GetAuditLog()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SEQ\tTIME\tEVENT\tUSER\tDETAILS")
+	for _, entry := range entries {
+		details := make([]string, 0, len(entry.Details))
+		for key, value := range entry.Details {
+			details = append(details, key+"="+value)
+		}
+		sort.Strings(details)
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", entry.Sequence, entry.Time.Format(time.RFC3339), entry.Event, entry.User, strings.Join(details, " "))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		fmt.Fprintf(c.out, "\nAudit log verified, hash of the last entry: %v\n", hex.EncodeToString(entries[len(entries)-1].Hash))
+	}
+	return nil
+}
+
+func (c *cli) backup(args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing backup subcommand")
+	}
+	switch args[0] {
+	case "export":
+		if len(args) != 2 {
+			return errors.New("usage: backup export <file>")
+		}
+		return c.backupExport(args[1])
+	case "restore":
+		if len(args) != 3 {
+			return errors.New("usage: backup restore <file> <recovery key file>")
+		}
+		return c.backupRestore(args[1], args[2])
+	}
+	return fmt.Errorf("unknown backup subcommand: %v", args[0])
+}
+
+func (c *cli) backupExport(file string) error {
+	client, err := c.newClient()
+	if err != nil {
+		return err
+	}
+	backup, err := client.ExportBackup()
+	if err != nil {
+		return err
+	}
+	rawBackup, err := json.Marshal(backup)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(file, rawBackup, 0600); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "Backup of manifest %v written to %v\n", hex.EncodeToString(backup.ManifestSignature), file)
+	return nil
+}
+
+func (c *cli) backupRestore(file string, recoveryKeyFile string) error {
+	rawBackup, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var backup clientapi.Backup
+	if err := json.Unmarshal(rawBackup, &backup); err != nil {
+		return fmt.Errorf("%v: invalid backup: %v", file, err)
+	}
+	recoveryKey, err := readRSAPrivateKey(recoveryKeyFile)
+	if err != nil {
+		return err
+	}
+	name, key, err := backup.DecryptKey(recoveryKey)
+	if err != nil {
+		return err
+	}
+	client, err := c.newClient()
+	if err != nil {
+		return err
+	}
+	remaining, recoveryData, err := client.RestoreBackup(backup, key)
+	if err != nil {
+		return err
+	}
+	if remaining > 0 {
+		fmt.Fprintf(c.out, "Backup key share of %v uploaded, %v more shares required\n", name, remaining)
+		return nil
+	}
+	fmt.Fprintln(c.out, "Backup successfully restored")
+	if len(recoveryData) > 0 {
+		fmt.Fprintln(c.out, "Recovery data:")
+		encoder := json.NewEncoder(c.out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(struct{ EncryptionKeys map[string][]byte }{recoveryData})
+	}
+	return nil
+}
+
+// readRSAPrivateKey reads a PEM-encoded RSA private key in PKCS #1 or PKCS #8 form.
+func readRSAPrivateKey(file string) (*rsa.PrivateKey, error) {
+	rawKey, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(rawKey)
+	if block == nil {
+		return nil, fmt.Errorf("%v: no PEM-encoded key found", file)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%v: invalid private key: %v", file, err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%v: not an RSA private key", file)
+	}
+	return rsaKey, nil
+}
+
+func (c *cli) manifest(args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing manifest subcommand")
+	}
+	switch args[0] {
+	case "set":
+		if len(args) != 2 && len(args) != 3 {
+			return errors.New("usage: manifest set <file> [<signature file>]")
+		}
+		signatureFile := ""
+		if len(args) == 3 {
+			signatureFile = args[2]
+		}
+		return c.manifestSet(args[1], signatureFile)
+	case "get":
+		if len(args) != 1 {
+			return errors.New("usage: manifest get")
+		}
+		return c.manifestGet()
+	case "verify":
+		if len(args) < 2 {
+			return errors.New("usage: manifest verify <file> [<update file>...]")
+		}
+		return c.manifestVerify(args[1], args[2:])
+	case "history":
+		if len(args) != 1 {
+			return errors.New("usage: manifest history")
+		}
+		return c.manifestHistory()
+	case "canonical":
+		if len(args) != 2 {
+			return errors.New("usage: manifest canonical <file>")
+		}
+		manifest, err := readManifest(args[1])
+		if err != nil {
+			return err
+		}
+		_, err = c.out.Write(manifest)
+		return err
+	case "package":
+		if len(args) != 2 && len(args) != 4 {
+			return errors.New("usage: manifest package <enclave or SIGSTRUCT file> [<package name> <manifest file>]")
+		}
+		return c.manifestPackage(args[1], args[2:])
+	case "preview":
+		if len(args) != 3 {
+			return errors.New("usage: manifest preview <file> <marble type>")
+		}
+		return c.manifestPreview(args[1], args[2])
+	}
+	return fmt.Errorf("unknown manifest subcommand: %v", args[0])
+}
+
+func (c *cli) manifestSet(file string, signatureFile string) error {
+	manifest, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var signature []byte
+	if signatureFile != "" {
+		if signature, err = ioutil.ReadFile(signatureFile); err != nil {
+			return err
+		}
+	}
+	client, err := c.newClient()
+	if err != nil {
+		return err
+	}
+	recoveryData, err := client.SetSignedManifest(manifest, signature)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, "Manifest successfully set")
+	if len(recoveryData) > 0 {
+		fmt.Fprintln(c.out, "Recovery data:")
+		encoder := json.NewEncoder(c.out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(struct{ EncryptionKeys map[string][]byte }{recoveryData})
+	}
+	return nil
+}
+
+func (c *cli) manifestGet() error {
+	signature, signer, err := c.getManifestSignature()
+	if err != nil {
+		return err
+	}
+	if signature == "" {
+		return errors.New("no manifest has been set yet")
+	}
+	fmt.Fprintln(c.out, signature)
+	if signer != "" {
+		fmt.Fprintln(c.out, "Signed by", signer)
+	}
+	return nil
+}
+
+func (c *cli) manifestVerify(file string, updateFiles []string) error {
+	manifest, err := readManifest(file)
+	if err != nil {
+		return err
+	}
+	updates := make([][]byte, 0, len(updateFiles))
+	for _, updateFile := range updateFiles {
+		update, err := readManifest(updateFile)
+		if err != nil {
+			return err
+		}
+		updates = append(updates, update)
+	}
+	signature, _, err := c.getManifestSignature()
+	if err != nil {
+		return err
+	}
+	if localSignature := hex.EncodeToString(clientapi.ManifestSignature(manifest, updates)); localSignature != signature {
+		return fmt.Errorf("manifest does not match: local %v, Coordinator %v", localSignature, signature)
+	}
+	fmt.Fprintln(c.out, "Manifest matches the Coordinator's active manifest")
+	return nil
+}
+
+func (c *cli) manifestHistory() error {
+	client, err := c.newClient()
+	if err != nil {
+		return err
+	}
+	versions, err := client.GetManifestHistory()
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		return errors.New("no manifest has been set yet")
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tTIME\tEVENT\tMANIFEST SIGNATURE\tSIGNED OR ACKNOWLEDGED BY")
+	for _, version := range versions {
+		by := version.Signer
+		if len(version.AcknowledgedBy) > 0 {
+			by = strings.Join(version.AcknowledgedBy, ",")
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", version.Version, version.Time.Format(time.RFC3339), version.Event, version.ManifestSignature, by)
+	}
+	return w.Flush()
+}
+
+// readManifest reads a manifest or manifest update in JSON, YAML or TOML and returns its JSON form, which is what the Coordinator hashes.
+func readManifest(file string) ([]byte, error) {
+	rawManifest, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := clientapi.CanonicalManifest(rawManifest, "")
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", file, err)
+	}
+	return manifest, nil
+}
+
+// manifestPackage prints the Package entry of a signed enclave. If a package name and manifest file are given, the manifest with the
+// entry merged into it is printed instead.
+func (c *cli) manifestPackage(file string, merge []string) error {
+	properties, err := sigstruct.LoadFile(file)
+	if err != nil {
+		return fmt.Errorf("%v: %v", file, err)
+	}
+	pkg := sigstruct.ManifestPackage(properties, c.uniqueID)
+	if len(merge) == 0 {
+		rawPackage, err := sigstruct.MarshalPackage(pkg)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(c.out, string(rawPackage))
+		return nil
+	}
+	rawManifest, err := ioutil.ReadFile(merge[1])
+	if err != nil {
+		return err
+	}
+	merged, err := sigstruct.MergePackage(rawManifest, merge[0], pkg)
+	if err != nil {
+		return err
+	}
+	_, err = c.out.Write(merged)
+	return err
+}
+
+// manifestPreview prints the parameters of a Marble of the type evaluated with fake secrets.
+func (c *cli) manifestPreview(file string, marbleType string) error {
+	manifest, err := readManifest(file)
+	if err != nil {
+		return err
+	}
+	params, err := core.PreviewParameters(manifest, marbleType)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, "WARNING: the secrets are fake values generated for the preview")
+	encoder := json.NewEncoder(c.out)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(params)
+}
+
+func (c *cli) getManifestSignature() (signature string, signer string, err error) {
+	client, err := c.newClient()
+	if err != nil {
+		return "", "", err
+	}
+	rawSignature, signer, err := client.GetManifestSignature()
+	if err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(rawSignature), signer, nil
+}
+
+// newClient creates a client for the Coordinator according to the flags.
+func (c *cli) newClient() (*api.Client, error) {
+	var clientCert *tls.Certificate
+	if c.certFile != "" || c.keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %v", err)
+		}
+		clientCert = &cert
+	}
+	if c.insecure {
+		fmt.Fprintln(c.out, "WARNING: the Coordinator is not attested")
+		return api.NewInsecureClient(c.addr, clientCert)
+	}
+	if c.configFile == "" {
+		return nil, errors.New("either -config or -insecure must be given")
+	}
+	rawConfig, err := ioutil.ReadFile(c.configFile)
+	if err != nil {
+		return nil, err
+	}
+	var config attestation.Config
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, fmt.Errorf("invalid config: %v", err)
+	}
+	return api.NewClient(c.addr, c.validator, config, clientCert)
+}
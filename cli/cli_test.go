@@ -0,0 +1,296 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto/elliptic"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/edgelesssys/marblerun/attestation"
+	"github.com/edgelesssys/marblerun/coordinator/core"
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/coordinator/quote/quotetest"
+	"github.com/edgelesssys/marblerun/coordinator/server"
+	"github.com/edgelesssys/marblerun/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifest(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	addr, validator, configFile, cleanup := setupCoordinator(t)
+	defer cleanup()
+
+	tempDir, err := ioutil.TempDir("", "")
+	require.NoError(err)
+	defer os.RemoveAll(tempDir)
+	certFile, keyFile := writeAdminCredentials(t, tempDir)
+	var manifest core.Manifest
+	require.NoError(json.Unmarshal([]byte(test.ManifestJSON), &manifest))
+	manifest.Users = map[string]core.User{"admin": {Certificate: test.CertPEM(test.AdminCert)}}
+	rawManifest, err := json.Marshal(manifest)
+	require.NoError(err)
+	manifestFile := filepath.Join(tempDir, "manifest.json")
+	require.NoError(ioutil.WriteFile(manifestFile, rawManifest, 0600))
+	otherManifestFile := filepath.Join(tempDir, "other.json")
+	require.NoError(ioutil.WriteFile(otherManifestFile, []byte(test.IntegrationManifestJSON), 0600))
+
+	runCLI := func(args ...string) (string, error) {
+		var out bytes.Buffer
+		err := run(append(args, "-coordinator", addr, "-config", configFile), &out, validator)
+		return out.String(), err
+	}
+
+	// no manifest set yet
+	_, err = runCLI("manifest", "get")
+	assert.Error(err)
+	_, err = runCLI("manifest", "history")
+	assert.Error(err)
+
+	out, err := runCLI("manifest", "set", manifestFile)
+	require.NoError(err)
+	assert.Contains(out, "Manifest successfully set")
+
+	out, err = runCLI("manifest", "get")
+	require.NoError(err)
+	assert.Len(strings.TrimSpace(out), 64)
+
+	// the history can only be read by the manifest's users
+	_, err = runCLI("manifest", "history")
+	assert.Error(err)
+	out, err = runCLI("manifest", "history", "-cert", certFile, "-key", keyFile)
+	require.NoError(err)
+	assert.Contains(out, "SetManifest")
+
+	_, err = runCLI("manifest", "verify", manifestFile)
+	assert.NoError(err)
+	_, err = runCLI("manifest", "verify", otherManifestFile)
+	assert.Error(err)
+	// no update has been applied
+	_, err = runCLI("manifest", "verify", manifestFile, otherManifestFile)
+	assert.Error(err)
+
+	// setting the manifest twice fails
+	_, err = runCLI("manifest", "set", manifestFile)
+	assert.Error(err)
+
+	// the preview doesn't need the Coordinator
+	var out bytes.Buffer
+	require.NoError(run([]string{"manifest", "preview", manifestFile, "backend_first"}, &out, validator))
+	assert.Contains(out.String(), `"IS_FIRST": "true"`)
+	_, err = runCLI("manifest", "preview", manifestFile, "unknown")
+	assert.Error(err)
+
+	// invalid commands
+	_, err = runCLI()
+	assert.Error(err)
+	_, err = runCLI("foo")
+	assert.Error(err)
+	_, err = runCLI("manifest", "foo")
+	assert.Error(err)
+	_, err = runCLI("manifest", "verify")
+	assert.Error(err)
+	_, err = runCLI("manifest", "package", manifestFile, "backend")
+	assert.Error(err)
+	// not an enclave
+	_, err = runCLI("manifest", "package", manifestFile)
+	assert.Error(err)
+}
+
+func TestStatus(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	addr, validator, configFile, cleanup := setupCoordinator(t)
+	defer cleanup()
+
+	var out bytes.Buffer
+	require.NoError(run([]string{"status", "-coordinator", addr, "-config", configFile}, &out, validator))
+	assert.Contains(out.String(), "ready to accept a manifest")
+	assert.NotContains(out.String(), "MARBLE TYPE")
+
+	manifestFile, err := ioutil.TempFile("", "")
+	require.NoError(err)
+	defer os.Remove(manifestFile.Name())
+	_, err = manifestFile.WriteString(test.ManifestJSON)
+	require.NoError(err)
+	require.NoError(manifestFile.Close())
+	require.NoError(run([]string{"manifest", "set", manifestFile.Name(), "-coordinator", addr, "-config", configFile}, &out, validator))
+
+	out.Reset()
+	require.NoError(run([]string{"status", "-coordinator", addr, "-config", configFile}, &out, validator))
+	assert.Contains(out.String(), "ready to accept marbles")
+	assert.Regexp(`backend_first\s+0\s+1/1`, out.String())
+	assert.Regexp(`frontend\s+0\s+unlimited`, out.String())
+}
+
+func TestAudit(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	addr, validator, configFile, cleanup := setupCoordinator(t)
+	defer cleanup()
+
+	tempDir, err := ioutil.TempDir("", "")
+	require.NoError(err)
+	defer os.RemoveAll(tempDir)
+	certFile, keyFile := writeAdminCredentials(t, tempDir)
+
+	// no manifest set yet
+	var out bytes.Buffer
+	assert.Error(run([]string{"audit", "-coordinator", addr, "-config", configFile, "-cert", certFile, "-key", keyFile}, &out, validator))
+
+	var manifest core.Manifest
+	require.NoError(json.Unmarshal([]byte(test.ManifestJSON), &manifest))
+	manifest.Users = map[string]core.User{"auditor": {Certificate: test.CertPEM(test.AdminCert), Roles: []string{"auditor"}}}
+	manifest.Roles = map[string]core.Role{"auditor": {ResourceType: "AuditLog", Actions: []string{"ReadAuditLog"}}}
+	rawManifest, err := json.Marshal(manifest)
+	require.NoError(err)
+	manifestFile := filepath.Join(tempDir, "manifest.json")
+	require.NoError(ioutil.WriteFile(manifestFile, rawManifest, 0600))
+	require.NoError(run([]string{"manifest", "set", manifestFile, "-coordinator", addr, "-config", configFile}, &out, validator))
+
+	// the audit log can only be read by permitted users
+	assert.Error(run([]string{"audit", "-coordinator", addr, "-config", configFile}, &out, validator))
+
+	out.Reset()
+	require.NoError(run([]string{"audit", "-coordinator", addr, "-config", configFile, "-cert", certFile, "-key", keyFile}, &out, validator))
+	assert.Regexp(`0\s+\S+\s+SetManifest\s+ManifestSignature=[0-9a-f]{64}`, out.String())
+	assert.Contains(out.String(), "Audit log verified")
+}
+
+func TestCertificate(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	addr, validator, configFile, cleanup := setupCoordinator(t)
+	defer cleanup()
+
+	var out bytes.Buffer
+	require.NoError(run([]string{"certificate", "-coordinator", addr, "-config", configFile}, &out, validator))
+	block, _ := pem.Decode(out.Bytes())
+	require.NotNil(block)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(err)
+	assert.Equal(core.CoordinatorName, cert.Subject.CommonName)
+
+	assert.Error(run([]string{"certificate", "-coordinator", addr, "-config", configFile}, &out, quote.NewFailValidator()))
+}
+
+func TestRootCA(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	addr, validator, configFile, cleanup := setupCoordinator(t)
+	defer cleanup()
+
+	tempDir, err := ioutil.TempDir("", "")
+	require.NoError(err)
+	defer os.RemoveAll(tempDir)
+	cert, key := quotetest.MustCreateCert(t, elliptic.P256(), "Marblerun CA", true, nil, nil)
+	rawKey, err := x509.MarshalECPrivateKey(key)
+	require.NoError(err)
+	certFile := filepath.Join(tempDir, "ca.crt")
+	require.NoError(ioutil.WriteFile(certFile, quotetest.ToPEM(cert), 0600))
+	keyFile := filepath.Join(tempDir, "ca.key")
+	require.NoError(ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rawKey}), 0600))
+
+	var out bytes.Buffer
+	assert.Error(run([]string{"root-ca", "set", certFile, "-coordinator", addr, "-config", configFile}, &out, validator))
+	assert.Error(run([]string{"root-ca", "set", keyFile, certFile, "-coordinator", addr, "-config", configFile}, &out, validator))
+	require.NoError(run([]string{"root-ca", "set", certFile, keyFile, "-coordinator", addr, "-config", configFile}, &out, validator))
+	assert.Contains(out.String(), "Root CA successfully set")
+
+	// the Coordinator serves the new root certificate, whose quote the validator doesn't know
+	out.Reset()
+	require.NoError(run([]string{"certificate", "-coordinator", addr, "-insecure"}, &out, validator))
+	assert.Contains(out.String(), string(quotetest.ToPEM(cert)))
+}
+
+func TestAttestation(t *testing.T) {
+	assert := assert.New(t)
+
+	addr, validator, configFile, cleanup := setupCoordinator(t)
+	defer cleanup()
+
+	manifestFile, err := ioutil.TempFile("", "")
+	assert.NoError(err)
+	defer os.Remove(manifestFile.Name())
+	_, err = manifestFile.WriteString(test.ManifestJSON)
+	assert.NoError(err)
+	assert.NoError(manifestFile.Close())
+
+	var out bytes.Buffer
+
+	// quote does not match the expected properties
+	assert.Error(run([]string{"manifest", "set", manifestFile.Name(), "-coordinator", addr, "-config", configFile}, &out, quote.NewFailValidator()))
+
+	// neither config nor insecure
+	assert.Error(run([]string{"manifest", "set", manifestFile.Name(), "-coordinator", addr}, &out, validator))
+
+	// insecure skips attestation
+	out.Reset()
+	assert.NoError(run([]string{"-insecure", "-coordinator", addr, "manifest", "set", manifestFile.Name()}, &out, quote.NewFailValidator()))
+	assert.Contains(out.String(), "WARNING: the Coordinator is not attested")
+}
+
+// writeAdminCredentials writes the certificate and private key of test.AdminCert to the directory and returns the file names.
+func writeAdminCredentials(t *testing.T, dir string) (string, string) {
+	require := require.New(t)
+
+	rawKey, err := x509.MarshalECPrivateKey(test.AdminPrivateKey)
+	require.NoError(err)
+	certFile := filepath.Join(dir, "admin.crt")
+	require.NoError(ioutil.WriteFile(certFile, []byte(test.CertPEM(test.AdminCert)), 0600))
+	keyFile := filepath.Join(dir, "admin.key")
+	require.NoError(ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rawKey}), 0600))
+	return certFile, keyFile
+}
+
+// setupCoordinator starts a Coordinator client API server and returns its address, a validator that accepts its quote, and a matching attestation config file.
+func setupCoordinator(t *testing.T) (string, quote.Validator, string, func()) {
+	require := require.New(t)
+
+	c := core.NewCoreWithMocks()
+	tlsConfig, err := c.GetTLSConfig()
+	require.NoError(err)
+	s := httptest.NewUnstartedServer(server.CreateServeMux(c))
+	// serve the Coordinator's certificate chain instead of httptest's default certificate
+	s.Listener = tls.NewListener(s.Listener, tlsConfig)
+	s.Start()
+
+	// the mock validator accepts the Coordinator's quote for the given properties
+	certPEM, coordinatorQuote, err := c.GetCertQuote(context.TODO())
+	require.NoError(err)
+	block, _ := pem.Decode([]byte(certPEM))
+	config := attestation.Config{Package: quote.PackageProperties{SignerID: "1234"}}
+	validator := quote.NewMockValidator()
+	validator.AddValidQuote(coordinatorQuote, block.Bytes, config.Package, config.Infrastructure)
+
+	configFile, err := ioutil.TempFile("", "")
+	require.NoError(err)
+	require.NoError(json.NewEncoder(configFile).Encode(config))
+	require.NoError(configFile.Close())
+
+	return s.Listener.Addr().String(), validator, configFile.Name(), func() {
+		s.Close()
+		os.Remove(configFile.Name())
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/config"
+	"github.com/edgelesssys/marblerun/coordinator/core"
+	"go.uber.org/zap"
+)
+
+// dnsNamesInterval is the interval at which the file of the DNS names is reread.
+const dnsNamesInterval = 30 * time.Second
+
+// readDNSNames returns the DNS names and IP addresses of the Coordinator's certificate from the environment and the file, if one is given.
+func readDNSNames(file string) ([]string, error) {
+	var names []string
+	if value := os.Getenv(config.DNSNames); value != "" {
+		names = strings.Split(value, ",")
+	}
+	if file != "" {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, strings.FieldsFunc(string(content), func(r rune) bool { return r == ',' || r == '\n' || r == '\r' })...)
+	}
+	if len(names) == 0 {
+		return nil, errors.New(config.DNSNames + " or " + config.DNSNamesFile + " must be set")
+	}
+	return names, nil
+}
+
+// watchDNSNames rereads the DNS names at the interval until the context is done and issues the Coordinator's TLS certificate anew when they change.
+func watchDNSNames(ctx context.Context, file string, interval time.Duration, c *core.Core, zapLogger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		names, err := readDNSNames(file)
+		if err != nil {
+			zapLogger.Warn("Cannot read the DNS names of the certificate, keeping the current ones.", zap.Error(err))
+			continue
+		}
+		if _, err := c.SetSubjectAltNames(names); err != nil {
+			zapLogger.Error("Cannot issue the TLS certificate for the new DNS names.", zap.Error(err))
+		}
+	}
+}
@@ -9,20 +9,97 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"log"
+	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/edgelesssys/marblerun/coordinator/config"
 	"github.com/edgelesssys/marblerun/coordinator/core"
+	"github.com/edgelesssys/marblerun/coordinator/core/store"
+	"github.com/edgelesssys/marblerun/coordinator/pkcs11/proxy"
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/coordinator/quote/dcapvalidator"
 	"github.com/edgelesssys/marblerun/coordinator/quote/ertvalidator"
-	"github.com/edgelesssys/marblerun/util"
+	"github.com/edgelesssys/marblerun/coordinator/quote/maavalidator"
+	"github.com/edgelesssys/marblerun/coordinator/quote/nitrovalidator"
+	"github.com/edgelesssys/marblerun/coordinator/quote/snpvalidator"
 )
 
 func main() {
-	validator := ertvalidator.NewERTValidator()
+	// infrastructures without a Type are validated by EdgelessRT, the other validators are opt-in
+	ert := ertvalidator.NewERTValidator()
+	validator := quote.NewRegistry(ert)
+	validator.Register(ertvalidator.InfrastructureType, ert)
+	hostfsPrefix := filepath.Join(filepath.FromSlash("/edg"), "hostfs")
+	st, err := newStore(hostfsPrefix)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dcap := dcapvalidator.NewDCAPValidator()
+	// the collateral is signed by Intel, so it may be read from the host and cached in the unsealed store
+	if pccs := os.Getenv(config.PCCSURL); pccs != "" {
+		cache, err := newCollateralCache(pccs, store.WithPrefix(st, "collateral_"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		dcap.SetTCBInfoProvider(cache)
+	} else if dir := os.Getenv(config.TCBInfoDir); dir != "" {
+		dcap.SetTCBInfoProvider(dcapvalidator.TCBInfoDir(filepath.Join(hostfsPrefix, dir)))
+	}
+	validator.Register(dcapvalidator.InfrastructureType, dcap)
+	validator.Register(snpvalidator.InfrastructureType, snpvalidator.NewSNPValidator())
+	validator.Register(nitrovalidator.InfrastructureType, nitrovalidator.NewNitroValidator())
+	validator.Register(maavalidator.InfrastructureType, maavalidator.NewMAAValidator())
 	issuer := ertvalidator.NewERTIssuer()
-	sealDirPrefix := filepath.Join(filepath.FromSlash("/edg"), "hostfs")
-	sealDir := util.MustGetenv(config.SealDir)
-	sealDir = filepath.Join(sealDirPrefix, sealDir)
-	sealer := core.NewAESGCMSealer(sealDir)
-	run(validator, issuer, sealDir, sealer)
+	sealer, err := wrapWithKMS(st, core.NewAESGCMSealer(st))
+	if err != nil {
+		log.Fatal(err)
+	}
+	clusterStore := store.WithPrefix(st, "cluster_")
+	clusterSealer, err := wrapWithKMS(clusterStore, core.NewAESGCMSealer(clusterStore))
+	if err != nil {
+		log.Fatal(err)
+	}
+	counter, err := newCounter(hostfsPrefix)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// the enclave can't load the PKCS#11 module of the host, it uses the HSM through the proxy on the host
+	if os.Getenv(config.PKCS11Module) != "" {
+		log.Fatalf("The enclave can't load a PKCS#11 module. Run pkcs11-proxy on the host and set %v instead.", config.PKCS11Proxy)
+	}
+	var rootKeys core.RootKeyStore
+	if addr := os.Getenv(config.PKCS11Proxy); addr != "" {
+		rootKeys = proxy.NewClient(addr)
+	}
+	// simulation mode must not be controllable by the untrusted host
+	if err := run(validator, issuer, sealer, clusterSealer, counter, rootKeys, false, hostfsPrefix); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// newCollateralCache creates a cache of the DCAP collateral of the PCCS at baseURL and refreshes it in the background.
+func newCollateralCache(baseURL string, st store.Store) (*dcapvalidator.CollateralCache, error) {
+	interval := 24 * time.Hour
+	if value := os.Getenv(config.TCBRefreshInterval); value != "" {
+		var err error
+		if interval, err = time.ParseDuration(value); err != nil || interval <= 0 {
+			return nil, fmt.Errorf("%v: invalid duration: %v", config.TCBRefreshInterval, value)
+		}
+	}
+	var gracePeriod time.Duration
+	if value := os.Getenv(config.TCBGracePeriod); value != "" {
+		var err error
+		if gracePeriod, err = time.ParseDuration(value); err != nil || gracePeriod < 0 {
+			return nil, fmt.Errorf("%v: invalid duration: %v", config.TCBGracePeriod, value)
+		}
+	}
+	cache := dcapvalidator.NewCollateralCache(baseURL, st, gracePeriod)
+	go cache.Run(context.Background(), interval, func(err error) {
+		log.Printf("Refreshing the DCAP collateral failed: %v", err)
+	})
+	return cache, nil
 }
@@ -0,0 +1,65 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"path/filepath"
+
+	"github.com/edgelesssys/marblerun/api"
+	"github.com/edgelesssys/marblerun/attestation"
+	"github.com/edgelesssys/marblerun/coordinator/cluster"
+	"github.com/edgelesssys/marblerun/coordinator/config"
+	"github.com/edgelesssys/marblerun/coordinator/core"
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/util"
+	"go.uber.org/zap"
+)
+
+// takeOverState takes over the state of the running Coordinator at addr, unless the Core already has a state.
+//
+// The running Coordinator is attested to run the same package as this one. If the package is identified by its signer,
+// an older version of it is accepted, too. It attests this Coordinator in turn before it hands off the state.
+func takeOverState(ctx context.Context, addr string, hostfsPrefix string, validator quote.Validator, issuer quote.Issuer, c *core.Core, simulation bool, zapLogger *zap.Logger) error {
+	if ready, _ := c.IsReady(ctx); ready {
+		zapLogger.Info("coordinator already has a state, skipping the handoff", zap.String("address", addr))
+		return nil
+	}
+
+	clientCert, err := tls.LoadX509KeyPair(filepath.Join(hostfsPrefix, util.MustGetenv(config.HandoffCert)), filepath.Join(hostfsPrefix, util.MustGetenv(config.HandoffKey)))
+	if err != nil {
+		return err
+	}
+	var client *api.Client
+	if simulation {
+		client, err = api.NewInsecureClient(addr, &clientCert)
+	} else {
+		var props quote.PackageProperties
+		if props, err = cluster.SelfPackageProperties(issuer, validator, quote.InfrastructureProperties{}); err != nil {
+			return err
+		}
+		if props.SignerID != "" {
+			props.UniqueID = ""
+			props.SecurityVersion = nil
+		}
+		client, err = api.NewClient(addr, validator, attestation.Config{Package: props}, &clientCert)
+	}
+	if err != nil {
+		return err
+	}
+
+	req, err := c.HandoffRequest()
+	if err != nil {
+		return err
+	}
+	handoff, err := client.HandOff(req)
+	if err != nil {
+		return err
+	}
+	return c.AcceptHandoff(ctx, handoff, addr)
+}
@@ -0,0 +1,42 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/edgelesssys/marblerun/coordinator/config"
+	"github.com/edgelesssys/marblerun/coordinator/core"
+	"github.com/edgelesssys/marblerun/coordinator/core/store"
+	"github.com/edgelesssys/marblerun/coordinator/kms"
+	"github.com/edgelesssys/marblerun/util"
+)
+
+// wrapWithKMS returns a sealer that wraps the encryption key with the KMS selected by the configuration, if any.
+func wrapWithKMS(st store.Store, sealer core.Sealer) (core.Sealer, error) {
+	var driver core.KMS
+	switch kind := os.Getenv(config.KMS); kind {
+	case "":
+		return sealer, nil
+	case "aws":
+		var err error
+		if driver, err = kms.NewAWS(util.MustGetenv(config.KMSKey), util.MustGetenv(config.KMSRegion)); err != nil {
+			return nil, err
+		}
+	case "azure":
+		driver = kms.NewAzure(util.MustGetenv(config.KMSKey))
+	case "gcp":
+		driver = kms.NewGCP(util.MustGetenv(config.KMSKey))
+	default:
+		return nil, fmt.Errorf("unknown KMS: %v", kind)
+	}
+	if os.Getenv(config.KMSOnly) == "1" {
+		sealer = nil
+	}
+	return core.NewKMSSealer(st, driver, sealer), nil
+}
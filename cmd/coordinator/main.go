@@ -9,8 +9,13 @@
 package main
 
 import (
+	"log"
+	"os"
+
 	"github.com/edgelesssys/marblerun/coordinator/config"
 	"github.com/edgelesssys/marblerun/coordinator/core"
+	"github.com/edgelesssys/marblerun/coordinator/core/store"
+	"github.com/edgelesssys/marblerun/coordinator/pkcs11"
 	"github.com/edgelesssys/marblerun/coordinator/quote"
 	"github.com/edgelesssys/marblerun/util"
 )
@@ -18,7 +23,34 @@ import (
 func main() {
 	validator := quote.NewFailValidator()
 	issuer := quote.NewFailIssuer()
-	sealDir := util.MustGetenv(config.SealDir)
-	sealer := core.NewNoEnclaveSealer(sealDir)
-	run(validator, issuer, sealDir, sealer)
+	st, err := newStore("")
+	if err != nil {
+		log.Fatal(err)
+	}
+	sealer, err := wrapWithKMS(st, core.NewNoEnclaveSealer(st))
+	if err != nil {
+		log.Fatal(err)
+	}
+	clusterStore := store.WithPrefix(st, "cluster_")
+	clusterSealer, err := wrapWithKMS(clusterStore, core.NewNoEnclaveSealer(clusterStore))
+	if err != nil {
+		log.Fatal(err)
+	}
+	counter, err := newCounter("")
+	if err != nil {
+		log.Fatal(err)
+	}
+	var rootKeys core.RootKeyStore
+	if module := os.Getenv(config.PKCS11Module); module != "" {
+		token, err := pkcs11.Open(module, util.MustGetenv(config.PKCS11Token), os.Getenv(config.PKCS11Pin))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer token.Close()
+		rootKeys = token
+	}
+	simulation := os.Getenv(config.Simulation) == "1"
+	if err := run(validator, issuer, sealer, clusterSealer, counter, rootKeys, simulation, ""); err != nil {
+		log.Fatal(err)
+	}
 }
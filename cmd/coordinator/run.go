@@ -7,31 +7,42 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/edgelesssys/marblerun/coordinator/audit"
+	"github.com/edgelesssys/marblerun/coordinator/cluster"
 	"github.com/edgelesssys/marblerun/coordinator/config"
 	"github.com/edgelesssys/marblerun/coordinator/core"
+	"github.com/edgelesssys/marblerun/coordinator/kubernetes"
 	"github.com/edgelesssys/marblerun/coordinator/quote"
 	"github.com/edgelesssys/marblerun/coordinator/server"
+	"github.com/edgelesssys/marblerun/coordinator/webhook"
 	"github.com/edgelesssys/marblerun/util"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
 
-func run(validator quote.Validator, issuer quote.Issuer, sealDir string, sealer core.Sealer) {
+// hostfsPrefix is prepended to the paths of files on the host.
+//
+// run returns when the Coordinator is stopped by SIGINT or SIGTERM, or with an error if one of its servers fails.
+func run(validator quote.Validator, issuer quote.Issuer, sealer core.Sealer, clusterSealer core.Sealer, counter core.MonotonicCounter, rootKeys core.RootKeyStore, simulation bool, hostfsPrefix string) error {
 	// Setup logging with Zap Logger
-	var zapLogger *zap.Logger
-	var err error
-
-	// Development Logger shows a stacktrace for warnings & errors, Production Logger only for errors
-	devMode := os.Getenv(config.DevMode)
-	if devMode == "1" {
-		zapLogger, err = zap.NewDevelopment()
-	} else {
-		zapLogger, err = zap.NewProduction()
-	}
-
+	// Development Logger writes console output and shows a stacktrace for warnings & errors, Production Logger writes JSON and shows stacktraces only for errors
+	zapLogger, err := util.NewLogger(os.Getenv(config.LogLevel), os.Getenv(config.DevMode) == "1")
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -39,51 +50,390 @@ func run(validator quote.Validator, issuer quote.Issuer, sealDir string, sealer
 
 	zapLogger.Info("starting coordinator")
 
+	// the servers are shut down when run returns
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	// fetching env vars
-	dnsNamesString := util.MustGetenv(config.DNSNames)
-	dnsNames := strings.Split(dnsNamesString, ",")
-	clientServerAddr := util.MustGetenv(config.ClientAddr)
-	meshServerAddr := util.MustGetenv(config.MeshAddr)
+	dnsNamesFile := os.Getenv(config.DNSNamesFile)
+	if dnsNamesFile != "" {
+		dnsNamesFile = filepath.Join(hostfsPrefix, dnsNamesFile)
+	}
+	dnsNames, err := readDNSNames(dnsNamesFile)
+	if err != nil {
+		zapLogger.Fatal("Cannot read the DNS names of the certificate.", zap.Error(err))
+	}
+	clientServerEnabled := os.Getenv(config.ClientDisabled) != "1"
+	meshServerEnabled := os.Getenv(config.MeshDisabled) != "1"
+	if !clientServerEnabled && !meshServerEnabled {
+		zapLogger.Fatal("Both the client server and the marble server are disabled.")
+	}
+	var clientServerAddr, meshServerAddr string
+	if clientServerEnabled {
+		clientServerAddr = util.MustGetenv(config.ClientAddr)
+	}
+	if meshServerEnabled {
+		meshServerAddr = util.MustGetenv(config.MeshAddr)
+	}
 	promServerAddr := os.Getenv(config.PromAddr)
 
 	// creating core
 	zapLogger.Info("creating the Core object")
-	if err := os.MkdirAll(sealDir, 0700); err != nil {
-		zapLogger.Fatal("Cannot create or access sealdir. Please check the permissions for the specified path.", zap.Error(err))
-	}
-	core, err := core.NewCore(dnsNames, validator, issuer, sealer, zapLogger)
+	core, err := core.NewCore(dnsNames, validator, issuer, sealer, rootKeys, simulation, zapLogger)
 	if err != nil {
 		panic(err)
 	}
+	if counter != nil {
+		if err := core.SetMonotonicCounter(counter); err != nil {
+			zapLogger.Fatal("Cannot verify the sealed state with the rollback counter.", zap.Error(err))
+		}
+	}
+
+	limits, err := activationLimits()
+	if err != nil {
+		zapLogger.Fatal("Invalid activation limits.", zap.Error(err))
+	}
+	if err := core.SetActivationLimits(limits); err != nil {
+		zapLogger.Fatal("Invalid activation limits.", zap.Error(err))
+	}
+
+	listenerOpts, err := listenerOptions()
+	if err != nil {
+		zapLogger.Fatal("Invalid listener options.", zap.Error(err))
+	}
+	clientListenerOpts, err := serverListenerOptions(listenerOpts, config.ClientTLSMinVersion, config.ClientTLSCipherSuites)
+	if err != nil {
+		zapLogger.Fatal("Invalid listener options of the client server.", zap.Error(err))
+	}
+	clientListenerOpts.Authenticate = core.AuthenticateClient
+	meshListenerOpts, err := serverListenerOptions(listenerOpts, config.MeshTLSMinVersion, config.MeshTLSCipherSuites)
+	if err != nil {
+		zapLogger.Fatal("Invalid listener options of the marble server.", zap.Error(err))
+	}
+
+	if crlURL := os.Getenv(config.CRLURL); crlURL != "" {
+		core.SetCRLURL(crlURL)
+	}
+
+	if rawSigners := os.Getenv(config.ManifestSigners); rawSigners != "" {
+		var signers map[string]string
+		if err := json.Unmarshal([]byte(rawSigners), &signers); err != nil {
+			zapLogger.Fatal("Invalid manifest signers.", zap.Error(err))
+		}
+		if err := core.SetManifestSigners(signers); err != nil {
+			zapLogger.Fatal("Invalid manifest signers.", zap.Error(err))
+		}
+	}
+
+	if err := setupSecretsBackend(core, hostfsPrefix); err != nil {
+		zapLogger.Fatal("Cannot set up the secrets backend.", zap.Error(err))
+	}
 
 	// start the prometheus server
 	if promServerAddr != "" {
-		go server.RunPrometheusServer(promServerAddr, zapLogger)
+		prometheus.MustRegister(core.MetricsCollector())
+		promServer, err := server.StartPrometheusServer(ctx, promServerAddr, zapLogger)
+		if err != nil {
+			zapLogger.Fatal("Cannot start the prometheus server.", zap.Error(err))
+		}
+		defer promServer.Shutdown(context.Background())
 	}
 
-	// start client server
-	zapLogger.Info("starting the client server")
-	mux := server.CreateServeMux(core)
-	clientServerTLSConfig, err := core.GetTLSConfig()
-	if err != nil {
-		panic(err)
+	// serve the profiles for debugging
+	if debugAddr := os.Getenv(config.DebugAddr); debugAddr != "" {
+		debugServer, err := server.StartDebugServer(ctx, debugAddr, zapLogger)
+		if err != nil {
+			zapLogger.Fatal("Cannot start the debug server.", zap.Error(err))
+		}
+		defer debugServer.Shutdown(context.Background())
 	}
-	go server.RunClientServer(mux, clientServerAddr, clientServerTLSConfig, zapLogger)
 
-	// run marble server
-	zapLogger.Info("starting the marble server")
-	addrChan := make(chan string)
-	errChan := make(chan error)
-	go server.RunMarbleServer(core, meshServerAddr, addrChan, errChan, zapLogger)
-	for {
-		select {
-		case err := <-errChan:
+	// export traces
+	if otlpAddr := os.Getenv(config.OTLPAddr); otlpAddr != "" {
+		shutdownTracing, err := server.SetupTracing(otlpAddr, os.Getenv(config.OTLPInsecure) == "1", zapLogger)
+		if err != nil {
+			zapLogger.Fatal("Cannot set up tracing.", zap.Error(err))
+		}
+		defer shutdownTracing()
+	}
+
+	// stream the audit log
+	if auditAddr := os.Getenv(config.AuditAddr); auditAddr != "" {
+		format := os.Getenv(config.AuditFormat)
+		if format == "" {
+			format = audit.FormatSyslog
+		}
+		exporter, err := audit.NewExporter(auditAddr, format, zapLogger)
+		if err != nil {
+			zapLogger.Fatal("Cannot set up the audit export.", zap.Error(err))
+		}
+		defer exporter.Close()
+		core.AddAuditSink(exporter)
+	}
+
+	// notify the webhooks
+	if rawWebhooks := os.Getenv(config.Webhooks); rawWebhooks != "" {
+		webhooks, err := webhook.ParseConfig([]byte(rawWebhooks))
+		if err != nil {
+			zapLogger.Fatal("Cannot set up the webhooks.", zap.Error(err))
+		}
+		for _, webhookConfig := range webhooks {
+			hook, err := webhook.New(webhookConfig, zapLogger)
 			if err != nil {
-				panic(err)
+				zapLogger.Fatal("Cannot set up the webhooks.", zap.Error(err))
 			}
-			return
-		case grpcAddr := <-addrChan:
-			zapLogger.Info("started gRPC server", zap.String("grpcAddr", grpcAddr))
+			defer hook.Close()
+			core.AddNotifier(hook)
+		}
+	}
+
+	// publish the CA certificates into Kubernetes
+	if namespaces := os.Getenv(config.KubernetesCANamespaces); namespaces != "" {
+		publisher, err := newCAPublisher(namespaces, core, zapLogger)
+		if err != nil {
+			zapLogger.Fatal("Cannot set up the publication of the CA certificates.", zap.Error(err))
+		}
+		go publisher.Run(ctx)
+	}
+
+	// issue the TLS certificate anew when the names in the file change
+	if dnsNamesFile != "" {
+		go watchDNSNames(ctx, dnsNamesFile, dnsNamesInterval, core, zapLogger)
+	}
+
+	// replicate the state among the cluster
+	if clusterAddr := os.Getenv(config.ClusterAddr); clusterAddr != "" {
+		node, err := setupCluster(clusterAddr, hostfsPrefix, clusterSealer, validator, issuer, core, zapLogger)
+		if err != nil {
+			zapLogger.Fatal("Cannot set up the cluster.", zap.Error(err))
+		}
+		defer node.Stop()
+	}
+
+	// take over the state of the running coordinator
+	if handoffAddr := os.Getenv(config.HandoffAddr); handoffAddr != "" {
+		if err := takeOverState(ctx, handoffAddr, hostfsPrefix, validator, issuer, core, simulation, zapLogger); err != nil {
+			zapLogger.Fatal("Cannot take over the state of the running coordinator.", zap.String("address", handoffAddr), zap.Error(err))
+		}
+	}
+
+	var servers []*server.Server
+
+	// start client server
+	if clientServerEnabled {
+		zapLogger.Info("starting the client server")
+		mux := server.CreateServeMux(core)
+		clientServerTLSConfig, err := core.GetTLSConfig()
+		if err != nil {
+			panic(err)
+		}
+		clientServer, err := server.StartClientServer(ctx, mux, clientServerAddr, clientServerTLSConfig, clientListenerOpts, zapLogger)
+		if err != nil {
+			zapLogger.Fatal("Cannot start the client server.", zap.Error(err))
+		}
+		servers = append(servers, clientServer)
+	} else {
+		zapLogger.Info("the client server is disabled")
+	}
+
+	// run marble server
+	if meshServerEnabled {
+		zapLogger.Info("starting the marble server")
+		marbleServer, err := server.StartMarbleServer(ctx, core, meshServerAddr, meshListenerOpts, zapLogger)
+		if err != nil {
+			zapLogger.Fatal("Cannot start the marble server.", zap.Error(err))
+		}
+		zapLogger.Info("started gRPC server", zap.String("grpcAddr", marbleServer.Addr()))
+		servers = append(servers, marbleServer)
+	} else {
+		zapLogger.Info("the marble server is disabled")
+	}
+
+	// run until the Coordinator is stopped or one of the servers fails
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	var failed *server.Server
+	select {
+	case sig := <-signals:
+		zapLogger.Info("stopping coordinator", zap.String("signal", sig.String()))
+	case failed = <-server.FirstDone(servers...):
+	}
+
+	// the in-flight requests are drained before the state is closed by the deferred functions
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), server.ShutdownTimeout)
+	defer cancelShutdown()
+	for _, s := range servers {
+		if err := s.Shutdown(shutdownCtx); err != nil {
+			zapLogger.Warn("server did not shut down gracefully", zap.String("address", s.Addr()), zap.Error(err))
+		}
+	}
+	if failed != nil {
+		return fmt.Errorf("server at %v failed: %v", failed.Addr(), failed.Err())
+	}
+	return nil
+}
+
+// setupCluster starts the cluster node that replicates the state of the Core.
+//
+// The peers are attested with the validator: their quotes must report the same package properties as a quote of this Coordinator.
+func setupCluster(addr string, hostfsPrefix string, sealer core.Sealer, validator quote.Validator, issuer quote.Issuer, c *core.Core, zapLogger *zap.Logger) (*cluster.Node, error) {
+	var files [3][]byte
+	for i, name := range []string{config.ClusterCert, config.ClusterKey, config.ClusterCA} {
+		var err error
+		if files[i], err = ioutil.ReadFile(filepath.Join(hostfsPrefix, util.MustGetenv(name))); err != nil {
+			return nil, err
+		}
+	}
+	selfProps, err := cluster.SelfPackageProperties(issuer, validator, quote.InfrastructureProperties{})
+	if err != nil {
+		return nil, err
+	}
+	attestation := cluster.Attestation{Issuer: issuer, Validator: validator, Package: selfProps}
+	tlsConfig, err := cluster.NewTLSConfig(files[0], files[1], files[2], attestation)
+	if err != nil {
+		return nil, err
+	}
+	var peers []string
+	if rawPeers := os.Getenv(config.ClusterPeers); rawPeers != "" {
+		peers = strings.Split(rawPeers, ",")
+	}
+
+	node, err := cluster.NewNode(cluster.Config{Addr: addr, Peers: peers, TLSConfig: tlsConfig}, cluster.NewSealedStorage(sealer), c.ApplyReplicatedState, zapLogger)
+	if err != nil {
+		return nil, err
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := tls.Listen("tcp", ":"+port, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		if err := http.Serve(listener, node.Handler()); err != nil {
+			zapLogger.Error("cluster server stopped", zap.Error(err))
+		}
+	}()
+	c.SetReplicator(node)
+	node.Start()
+	return node, nil
+}
+
+// newCAPublisher creates the publisher of the CA certificates into the namespaces, see config.KubernetesCANamespaces.
+func newCAPublisher(namespaces string, c *core.Core, zapLogger *zap.Logger) (*kubernetes.CAPublisher, error) {
+	parsed, err := kubernetes.ParseCANamespaces(namespaces)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", config.KubernetesCANamespaces, err)
+	}
+	name := os.Getenv(config.KubernetesCAName)
+	if name == "" {
+		name = "marblerun-ca"
+	}
+	client, _, err := kubernetes.NewInClusterClient()
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewCAPublisher(client, c, name, parsed, zapLogger), nil
+}
+
+// activationLimits returns the limits of activation requests set by the configuration.
+func activationLimits() (core.ActivationLimits, error) {
+	var limits core.ActivationLimits
+	var err error
+	if rate := os.Getenv(config.ActivationRate); rate != "" {
+		if limits.ClientRate, err = strconv.ParseFloat(rate, 64); err != nil {
+			return core.ActivationLimits{}, fmt.Errorf("%v: %v", config.ActivationRate, err)
+		}
+	}
+	if rate := os.Getenv(config.ActivationTypeRate); rate != "" {
+		if limits.MarbleTypeRate, err = strconv.ParseFloat(rate, 64); err != nil {
+			return core.ActivationLimits{}, fmt.Errorf("%v: %v", config.ActivationTypeRate, err)
+		}
+	}
+	if burst := os.Getenv(config.ActivationBurst); burst != "" {
+		if limits.Burst, err = strconv.Atoi(burst); err != nil {
+			return core.ActivationLimits{}, fmt.Errorf("%v: %v", config.ActivationBurst, err)
+		}
+	}
+	if verifications := os.Getenv(config.QuoteVerifications); verifications != "" {
+		if limits.Verifications, err = strconv.Atoi(verifications); err != nil {
+			return core.ActivationLimits{}, fmt.Errorf("%v: %v", config.QuoteVerifications, err)
+		}
+	}
+	return limits, nil
+}
+
+// listenerOptions returns the options of the listeners of the marble server and the client server set by the configuration.
+func listenerOptions() (server.ListenerOptions, error) {
+	var opts server.ListenerOptions
+	var err error
+	if opts.MinTLSVersion, opts.CipherSuites, err = tlsOptions(config.TLSMinVersion, config.TLSCipherSuites); err != nil {
+		return server.ListenerOptions{}, err
+	}
+	if connections := os.Getenv(config.MaxConnections); connections != "" {
+		if opts.MaxConnections, err = strconv.Atoi(connections); err != nil {
+			return server.ListenerOptions{}, fmt.Errorf("%v: %v", config.MaxConnections, err)
+		}
+	}
+	opts.ProxyProtocol = os.Getenv(config.ProxyProtocol) == "1"
+	if size := os.Getenv(config.GRPCMaxMessageSize); size != "" {
+		if opts.MaxMessageSize, err = strconv.Atoi(size); err != nil {
+			return server.ListenerOptions{}, fmt.Errorf("%v: %v", config.GRPCMaxMessageSize, err)
+		}
+	}
+	if keepalive := os.Getenv(config.GRPCKeepaliveTime); keepalive != "" {
+		if opts.KeepaliveTime, err = time.ParseDuration(keepalive); err != nil {
+			return server.ListenerOptions{}, fmt.Errorf("%v: %v", config.GRPCKeepaliveTime, err)
+		}
+	}
+	if timeout := os.Getenv(config.GRPCKeepaliveTimeout); timeout != "" {
+		if opts.KeepaliveTimeout, err = time.ParseDuration(timeout); err != nil {
+			return server.ListenerOptions{}, fmt.Errorf("%v: %v", config.GRPCKeepaliveTimeout, err)
+		}
+	}
+	if size := os.Getenv(config.ClientMaxBodySize); size != "" {
+		if opts.MaxRequestBodySize, err = strconv.ParseInt(size, 10, 64); err != nil {
+			return server.ListenerOptions{}, fmt.Errorf("%v: %v", config.ClientMaxBodySize, err)
+		}
+	}
+	if timeout := os.Getenv(config.ClientIdleTimeout); timeout != "" {
+		if opts.IdleTimeout, err = time.ParseDuration(timeout); err != nil {
+			return server.ListenerOptions{}, fmt.Errorf("%v: %v", config.ClientIdleTimeout, err)
+		}
+	}
+	if origins := os.Getenv(config.ClientCORSOrigins); origins != "" {
+		if opts.CORSOrigins, err = server.ParseCORSOrigins(origins); err != nil {
+			return server.ListenerOptions{}, fmt.Errorf("%v: %v", config.ClientCORSOrigins, err)
+		}
+	}
+	return opts, nil
+}
+
+// serverListenerOptions returns the options of the listener of a single server: the shared options with the server's own
+// TLS version and cipher suites, if they are set by the configuration.
+func serverListenerOptions(shared server.ListenerOptions, versionEnv string, suitesEnv string) (server.ListenerOptions, error) {
+	version, suites, err := tlsOptions(versionEnv, suitesEnv)
+	if err != nil {
+		return server.ListenerOptions{}, err
+	}
+	return shared.WithTLS(version, suites), nil
+}
+
+// tlsOptions returns the minimum TLS version and the cipher suites set by the environment variables. They are zero if the variables are not set.
+func tlsOptions(versionEnv string, suitesEnv string) (uint16, []uint16, error) {
+	var version uint16
+	var suites []uint16
+	var err error
+	if rawVersion := os.Getenv(versionEnv); rawVersion != "" {
+		if version, err = server.ParseTLSVersion(rawVersion); err != nil {
+			return 0, nil, fmt.Errorf("%v: %v", versionEnv, err)
+		}
+	}
+	if rawSuites := os.Getenv(suitesEnv); rawSuites != "" {
+		if suites, err = server.ParseCipherSuites(rawSuites); err != nil {
+			return 0, nil, fmt.Errorf("%v: %v", suitesEnv, err)
 		}
 	}
+	return version, suites, nil
 }
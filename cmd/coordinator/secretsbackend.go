@@ -0,0 +1,62 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/config"
+	"github.com/edgelesssys/marblerun/coordinator/core"
+	"github.com/edgelesssys/marblerun/coordinator/vault"
+	"github.com/edgelesssys/marblerun/util"
+)
+
+// defaultSecretsCacheTTL is the time for which values from the secrets backend are cached if the configuration doesn't set it.
+const defaultSecretsCacheTTL = 5 * time.Minute
+
+// setupSecretsBackend sets the secrets backend selected by the configuration, if any.
+//
+// hostfsPrefix is prepended to the path of the CA certificate of the backend.
+func setupSecretsBackend(c *core.Core, hostfsPrefix string) error {
+	var backend core.SecretsBackend
+	switch kind := os.Getenv(config.SecretsBackend); kind {
+	case "":
+		return nil
+	case "vault":
+		var rootCAs *x509.CertPool
+		if caFile := os.Getenv(config.VaultCACert); caFile != "" {
+			caPEM, err := ioutil.ReadFile(filepath.Join(hostfsPrefix, caFile))
+			if err != nil {
+				return err
+			}
+			rootCAs = x509.NewCertPool()
+			if !rootCAs.AppendCertsFromPEM(caPEM) {
+				return fmt.Errorf("%v: no PEM-encoded certificate found", config.VaultCACert)
+			}
+		}
+		backend = vault.New(util.MustGetenv(config.VaultAddr), os.Getenv(config.VaultAuthMount), os.Getenv(config.VaultRole), rootCAs, c.GetClientCertificate)
+	default:
+		return fmt.Errorf("unknown secrets backend: %v", kind)
+	}
+
+	cacheTTL := defaultSecretsCacheTTL
+	if rawTTL := os.Getenv(config.SecretsCacheTTL); rawTTL != "" {
+		seconds, err := strconv.ParseUint(rawTTL, 10, 32)
+		if err != nil {
+			return fmt.Errorf("%v: %v", config.SecretsCacheTTL, err)
+		}
+		cacheTTL = time.Duration(seconds) * time.Second
+	}
+	c.SetSecretsBackend(backend, cacheTTL)
+	return nil
+}
@@ -0,0 +1,95 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/edgelesssys/marblerun/coordinator/config"
+	"github.com/edgelesssys/marblerun/coordinator/core"
+	"github.com/edgelesssys/marblerun/coordinator/core/store"
+	"github.com/edgelesssys/marblerun/util"
+)
+
+// newStore creates the store of the sealed state selected by the configuration.
+// hostfsPrefix is prepended to the paths of files on the host.
+func newStore(hostfsPrefix string) (store.Store, error) {
+	switch kind := os.Getenv(config.Store); kind {
+	case "", "file":
+		sealDir := filepath.Join(hostfsPrefix, util.MustGetenv(config.SealDir))
+		if err := os.MkdirAll(sealDir, 0700); err != nil {
+			return nil, fmt.Errorf("cannot create or access sealdir, please check the permissions for the specified path: %v", err)
+		}
+		return store.NewFileStore(sealDir), nil
+	case "etcd":
+		return newEtcdStore(hostfsPrefix)
+	case "kubernetes":
+		name := os.Getenv(config.KubernetesSecret)
+		if name == "" {
+			name = "marblerun-coordinator-state"
+		}
+		return store.NewInClusterKubernetesStore(name)
+	default:
+		return nil, fmt.Errorf("unknown store: %v", kind)
+	}
+}
+
+// newCounter creates the rollback counter selected by the configuration, if any.
+func newCounter(hostfsPrefix string) (core.MonotonicCounter, error) {
+	switch kind := os.Getenv(config.RollbackCounter); kind {
+	case "":
+		return nil, nil
+	case "etcd":
+		etcdStore, err := newEtcdStore(hostfsPrefix)
+		if err != nil {
+			return nil, err
+		}
+		return store.NewEtcdCounter(etcdStore, "counter"), nil
+	default:
+		return nil, fmt.Errorf("unknown rollback counter: %v", kind)
+	}
+}
+
+func newEtcdStore(hostfsPrefix string) (*store.EtcdStore, error) {
+	tlsConfig, err := etcdTLSConfig(hostfsPrefix)
+	if err != nil {
+		return nil, err
+	}
+	prefix, ok := os.LookupEnv(config.EtcdPrefix)
+	if !ok {
+		prefix = "marblerun/"
+	}
+	return store.NewEtcdStore(util.MustGetenv(config.EtcdEndpoint), prefix, tlsConfig), nil
+}
+
+func etcdTLSConfig(hostfsPrefix string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile := os.Getenv(config.EtcdCA); caFile != "" {
+		caPEM, err := ioutil.ReadFile(filepath.Join(hostfsPrefix, caFile))
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("invalid etcd CA certificate")
+		}
+	}
+	if certFile := os.Getenv(config.EtcdCert); certFile != "" {
+		cert, err := tls.LoadX509KeyPair(filepath.Join(hostfsPrefix, certFile), filepath.Join(hostfsPrefix, util.MustGetenv(config.EtcdKey)))
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
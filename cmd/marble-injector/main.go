@@ -0,0 +1,68 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// marble-injector serves the mutating admission webhook that prepares pods labeled with marblerun/marbletype to run as Marbles.
+//
+// Kubernetes only calls webhooks over HTTPS, so the webhook needs a certificate that is trusted by the caBundle of its
+// MutatingWebhookConfiguration.
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/edgelesssys/marblerun/injector"
+	"github.com/edgelesssys/marblerun/util"
+	"go.uber.org/zap"
+)
+
+const (
+	// addrEnv is the address the webhook listens on, :8443 by default
+	addrEnv = "EDG_INJECTOR_ADDR"
+	// certEnv is the path of the PEM-encoded TLS certificate of the webhook
+	certEnv = "EDG_INJECTOR_CERT"
+	// keyEnv is the path of the PEM-encoded TLS key of the webhook
+	keyEnv = "EDG_INJECTOR_KEY"
+	// coordinatorAddrEnv is the address of the Coordinator's marble API that is injected into the Marbles
+	coordinatorAddrEnv = "EDG_INJECTOR_COORDINATOR_ADDR"
+	// sgxResourcesEnv are the resource limits that are injected, e.g., "sgx.intel.com/epc=10Mi,sgx.intel.com/enclave=1"
+	sgxResourcesEnv = "EDG_INJECTOR_SGX_RESOURCES"
+	// dnsDomainEnv is the domain of the cluster, cluster.local by default
+	dnsDomainEnv = "EDG_INJECTOR_DNS_DOMAIN"
+	// logLevelEnv is the minimum level of log messages
+	logLevelEnv = "EDG_INJECTOR_LOG_LEVEL"
+)
+
+func main() {
+	zapLogger, err := util.NewLogger(os.Getenv(logLevelEnv), false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer zapLogger.Sync()
+
+	inj := &injector.Injector{
+		CoordinatorAddr: util.MustGetenv(coordinatorAddrEnv),
+		SGXResources:    injector.DefaultSGXResources,
+		DNSDomain:       os.Getenv(dnsDomainEnv),
+	}
+	if resources := os.Getenv(sgxResourcesEnv); resources != "" {
+		if inj.SGXResources, err = injector.ParseResources(resources); err != nil {
+			zapLogger.Fatal("invalid "+sgxResourcesEnv, zap.Error(err))
+		}
+	}
+
+	addr := os.Getenv(addrEnv)
+	if addr == "" {
+		addr = ":8443"
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/mutate", inj.Handler(zapLogger))
+	zapLogger.Info("serving the marble injector", zap.String("addr", addr))
+	if err := http.ListenAndServeTLS(addr, util.MustGetenv(certEnv), util.MustGetenv(keyEnv), mux); err != nil {
+		zapLogger.Error("injector stopped", zap.Error(err))
+	}
+}
@@ -13,7 +13,7 @@ import (
 	"net/url"
 	"os"
 
-	"github.com/edgelesssys/ertgolib/marble"
+	"github.com/edgelesssys/marblerun/marble"
 	"github.com/edgelesssys/marblerun/util"
 )
 
@@ -29,8 +29,8 @@ func main() {
 }
 
 func runServer(addr string) {
-	// Retrieve server TLS config from ertgolib
-	tlsConfig, err := marble.GetTLSConfig(true)
+	// Retrieve server TLS config, which renews the marble's certificate before it expires
+	tlsConfig, err := marble.GetServerTLSConfig()
 	if err != nil {
 		panic(err)
 	}
@@ -52,8 +52,8 @@ func runServer(addr string) {
 }
 
 func runClient(addr string) error {
-	// Retrieve client TLS config from ertgolib
-	tlsConfig, err := marble.GetTLSConfig(false)
+	// Retrieve client TLS config, which renews the marble's certificate before it expires
+	tlsConfig, err := marble.GetClientTLSConfig()
 	if err != nil {
 		panic(err)
 	}
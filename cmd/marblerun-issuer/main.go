@@ -0,0 +1,94 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// marblerun-issuer is a cert-manager external issuer that forwards CertificateRequests for the manifest's ExternalServices to the Coordinator.
+//
+// It runs in a pod whose service account may list CertificateRequests and update their status,
+// and authenticates to the Coordinator with the certificate of a User who is permitted to issue certificates.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/edgelesssys/marblerun/api"
+	"github.com/edgelesssys/marblerun/attestation"
+	"github.com/edgelesssys/marblerun/coordinator/kubernetes"
+	"github.com/edgelesssys/marblerun/coordinator/quote/dcapvalidator"
+	"github.com/edgelesssys/marblerun/issuer"
+	"github.com/edgelesssys/marblerun/util"
+	"go.uber.org/zap"
+)
+
+const (
+	// coordinatorAddrEnv is the address of the Coordinator's client API
+	coordinatorAddrEnv = "EDG_ISSUER_COORDINATOR_ADDR"
+	// certEnv and keyEnv are the paths of the PEM-encoded certificate and key of the manifest's User that the issuer authenticates as
+	certEnv = "EDG_ISSUER_CERT"
+	keyEnv  = "EDG_ISSUER_KEY"
+	// attestationConfigEnv is the path of the JSON-encoded attestation.Config the Coordinator's quote is verified with
+	attestationConfigEnv = "EDG_ISSUER_ATTESTATION_CONFIG"
+	// insecureEnv skips the attestation of the Coordinator if set to "1". It must only be used for Coordinators in simulation mode.
+	insecureEnv = "EDG_ISSUER_INSECURE"
+	// namespaceEnv restricts the issuer to the CertificateRequests of a namespace, all namespaces are handled by default
+	namespaceEnv = "EDG_ISSUER_NAMESPACE"
+	// groupEnv and kindEnv are the group and kind of the issuerRef of the handled CertificateRequests, see issuer.Group and issuer.Kind
+	groupEnv = "EDG_ISSUER_GROUP"
+	kindEnv  = "EDG_ISSUER_KIND"
+	// logLevelEnv is the minimum level of log messages
+	logLevelEnv = "EDG_ISSUER_LOG_LEVEL"
+)
+
+func main() {
+	zapLogger, err := util.NewLogger(os.Getenv(logLevelEnv), false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer zapLogger.Sync()
+
+	clientCert, err := tls.LoadX509KeyPair(util.MustGetenv(certEnv), util.MustGetenv(keyEnv))
+	if err != nil {
+		zapLogger.Fatal("Cannot load the client certificate.", zap.Error(err))
+	}
+	coordinator, err := newCoordinatorClient(util.MustGetenv(coordinatorAddrEnv), &clientCert)
+	if err != nil {
+		zapLogger.Fatal("Cannot connect to the Coordinator.", zap.Error(err))
+	}
+	client, _, err := kubernetes.NewInClusterClient()
+	if err != nil {
+		zapLogger.Fatal("Cannot connect to Kubernetes.", zap.Error(err))
+	}
+
+	group, kind := os.Getenv(groupEnv), os.Getenv(kindEnv)
+	if group == "" {
+		group = issuer.Group
+	}
+	if kind == "" {
+		kind = issuer.Kind
+	}
+	zapLogger.Info("handling CertificateRequests", zap.String("group", group), zap.String("kind", kind))
+	issuer.New(client, coordinator, os.Getenv(namespaceEnv), group, kind, zapLogger).Run(context.Background())
+}
+
+// newCoordinatorClient attests the Coordinator, unless insecureEnv is set, and returns a client that authenticates with clientCert.
+func newCoordinatorClient(addr string, clientCert *tls.Certificate) (*api.Client, error) {
+	if os.Getenv(insecureEnv) == "1" {
+		return api.NewInsecureClient(addr, clientCert)
+	}
+	rawConfig, err := ioutil.ReadFile(util.MustGetenv(attestationConfigEnv))
+	if err != nil {
+		return nil, err
+	}
+	var config attestation.Config
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+	return api.NewClient(addr, dcapvalidator.NewDCAPValidator(), config, clientCert)
+}
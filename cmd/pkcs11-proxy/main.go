@@ -0,0 +1,42 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// pkcs11-proxy runs on the host of an enclave Coordinator and serves the HSM that holds its root key.
+//
+// It is configured with the same EDG_COORDINATOR_PKCS11_* variables as the Coordinator and listens at EDG_COORDINATOR_PKCS11_PROXY.
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/edgelesssys/marblerun/coordinator/config"
+	"github.com/edgelesssys/marblerun/coordinator/pkcs11"
+	"github.com/edgelesssys/marblerun/coordinator/pkcs11/proxy"
+	"github.com/edgelesssys/marblerun/util"
+	"go.uber.org/zap"
+)
+
+func main() {
+	zapLogger, err := util.NewLogger(os.Getenv(config.LogLevel), os.Getenv(config.DevMode) == "1")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer zapLogger.Sync()
+
+	token, err := pkcs11.Open(util.MustGetenv(config.PKCS11Module), util.MustGetenv(config.PKCS11Token), os.Getenv(config.PKCS11Pin))
+	if err != nil {
+		zapLogger.Fatal("Cannot open the PKCS#11 token.", zap.Error(err))
+	}
+	defer token.Close()
+
+	addr := util.MustGetenv(config.PKCS11Proxy)
+	zapLogger.Info("serving the PKCS#11 token", zap.String("addr", addr))
+	if err := http.ListenAndServe(addr, proxy.NewHandler(token, zapLogger)); err != nil {
+		zapLogger.Error("proxy stopped", zap.Error(err))
+	}
+}
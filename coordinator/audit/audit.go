@@ -0,0 +1,158 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package audit streams the entries of the Coordinator's audit log to a remote endpoint, e.g., the syslog or CEF input of a SIEM.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+	"go.uber.org/zap"
+)
+
+// Formats of the exported entries.
+const (
+	// FormatSyslog sends RFC 5424 syslog messages whose message is the entry in JSON format
+	FormatSyslog = "syslog"
+	// FormatCEF sends ArcSight Common Event Format lines
+	FormatCEF = "cef"
+	// FormatJSON sends the entries in JSON format, one per line
+	FormatJSON = "json"
+)
+
+// queueSize is the number of entries that are buffered while the endpoint is slow or unreachable.
+const queueSize = 1024
+
+// dialTimeout is the timeout of connecting to the endpoint.
+const dialTimeout = 5 * time.Second
+
+// Exporter sends audit entries to a remote endpoint in the background. It implements the core.AuditSink interface.
+//
+// Entries are sent in order. If the endpoint is unreachable, the connection is re-established for the next entry.
+// Entries that can't be sent or that don't fit into the queue are dropped, they remain available in the Coordinator's audit log.
+type Exporter struct {
+	network string
+	addr    string
+	format  func(clientapi.AuditEntry) ([]byte, error)
+	logger  *zap.Logger
+
+	entries chan clientapi.AuditEntry
+	done    chan struct{}
+	conn    net.Conn
+}
+
+// NewExporter creates an exporter that sends entries in the given format to endpoint.
+//
+// endpoint is a URL of the form udp://host:port or tcp://host:port.
+func NewExporter(endpoint string, format string, logger *zap.Logger) (*Exporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid audit endpoint: %v", err)
+	}
+	if u.Scheme != "udp" && u.Scheme != "tcp" {
+		return nil, fmt.Errorf("unsupported scheme of audit endpoint: %q, expected udp or tcp", u.Scheme)
+	}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		return nil, fmt.Errorf("invalid audit endpoint: %v", err)
+	}
+
+	e := &Exporter{
+		network: u.Scheme,
+		addr:    u.Host,
+		logger:  logger,
+		entries: make(chan clientapi.AuditEntry, queueSize),
+		done:    make(chan struct{}),
+	}
+	switch format {
+	case FormatSyslog:
+		hostname, err := os.Hostname()
+		if err != nil || hostname == "" {
+			hostname = "-"
+		}
+		e.format = func(entry clientapi.AuditEntry) ([]byte, error) {
+			return formatSyslog(entry, hostname)
+		}
+	case FormatCEF:
+		e.format = formatCEF
+	case FormatJSON:
+		e.format = formatJSON
+	default:
+		return nil, fmt.Errorf("unsupported audit format: %q, expected %v, %v or %v", format, FormatSyslog, FormatCEF, FormatJSON)
+	}
+
+	go e.run()
+	return e, nil
+}
+
+// Send queues the entry for sending. It does not block.
+func (e *Exporter) Send(entry clientapi.AuditEntry) {
+	select {
+	case e.entries <- entry:
+	default:
+		e.logger.Warn("audit export queue is full, dropping entry", zap.Uint64("sequence", entry.Sequence))
+	}
+}
+
+// Close sends the queued entries and closes the connection to the endpoint.
+//
+// Send must not be called after Close.
+func (e *Exporter) Close() {
+	close(e.entries)
+	<-e.done
+}
+
+func (e *Exporter) run() {
+	defer close(e.done)
+	for entry := range e.entries {
+		msg, err := e.format(entry)
+		if err != nil {
+			e.logger.Error("failed to format audit entry", zap.Uint64("sequence", entry.Sequence), zap.Error(err))
+			continue
+		}
+		if err := e.write(msg); err != nil {
+			e.logger.Error("failed to export audit entry", zap.Uint64("sequence", entry.Sequence), zap.String("endpoint", e.addr), zap.Error(err))
+		}
+	}
+	if e.conn != nil {
+		e.conn.Close()
+	}
+}
+
+// write sends msg, reconnecting once if the connection has been closed.
+func (e *Exporter) write(msg []byte) error {
+	for attempt := 0; ; attempt++ {
+		if e.conn == nil {
+			conn, err := net.DialTimeout(e.network, e.addr, dialTimeout)
+			if err != nil {
+				return err
+			}
+			e.conn = conn
+		}
+		_, err := e.conn.Write(msg)
+		if err == nil {
+			return nil
+		}
+		e.conn.Close()
+		e.conn = nil
+		if attempt > 0 {
+			return err
+		}
+	}
+}
+
+func formatJSON(entry clientapi.AuditEntry) ([]byte, error) {
+	msg, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	return append(msg, '\n'), nil
+}
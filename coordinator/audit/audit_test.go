@@ -0,0 +1,126 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package audit
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func testEntries() []clientapi.AuditEntry {
+	now := time.Date(2021, 3, 4, 5, 6, 7, 890123000, time.UTC)
+	var log []clientapi.AuditEntry
+	log = clientapi.AppendAuditEntry(log, clientapi.AuditEntry{Time: now, Event: clientapi.AuditEventSetManifest, Details: map[string]string{"ManifestSignature": "abcd"}})
+	log = clientapi.AppendAuditEntry(log, clientapi.AuditEntry{Time: now, Event: clientapi.AuditEventReadSecrets, User: "admin", ClientIP: "192.0.2.1", Details: map[string]string{"Secrets": "a=b|c"}})
+	return log
+}
+
+func TestFormatSyslog(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	entry := testEntries()[0]
+	msg, err := formatSyslog(entry, "host")
+	require.NoError(err)
+	header := "<85>1 2021-03-04T05:06:07.890123Z host marblerun-coordinator - SetManifest - "
+	require.True(strings.HasPrefix(string(msg), header))
+	assert.True(strings.HasSuffix(string(msg), "\n"))
+
+	var decoded clientapi.AuditEntry
+	require.NoError(json.Unmarshal(msg[len(header):], &decoded))
+	assert.Equal(entry.Hash, decoded.Hash)
+}
+
+func TestFormatCEF(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	entries := testEntries()
+	msg, err := formatCEF(entries[1])
+	require.NoError(err)
+	assert.Equal("CEF:0|Edgeless Systems|MarbleRun Coordinator|1|ReadSecrets|Secrets read|5|"+
+		`rt=1614834367890 externalId=1 suser=admin src=192.0.2.1 cs1Label=Secrets cs1=a\=b|c cs6Label=Hash cs6=`+hex.EncodeToString(entries[1].Hash)+"\n", string(msg))
+
+	assert.Equal(`a\|b\\`, escapeCEFHeader(`a|b\`))
+	assert.Equal(`a\=b\nc`, escapeCEFValue("a=b\nc"))
+
+	// there are not enough fields for too many details
+	entry := entries[0]
+	entry.Details = map[string]string{"1": "", "2": "", "3": "", "4": "", "5": "", "6": ""}
+	_, err = formatCEF(entry)
+	assert.Error(err)
+}
+
+func TestNewExporter(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, endpoint := range []string{"", "localhost:514", "http://localhost:514", "udp://localhost", "udp://%"} {
+		_, err := NewExporter(endpoint, FormatJSON, zap.NewNop())
+		assert.Error(err, endpoint)
+	}
+	_, err := NewExporter("udp://localhost:514", "xml", zap.NewNop())
+	assert.Error(err)
+}
+
+func TestExporterTCP(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(err)
+	defer listener.Close()
+
+	exporter, err := NewExporter("tcp://"+listener.Addr().String(), FormatJSON, zap.NewNop())
+	require.NoError(err)
+	entries := testEntries()
+	for _, entry := range entries {
+		exporter.Send(entry)
+	}
+
+	conn, err := listener.Accept()
+	require.NoError(err)
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for _, entry := range entries {
+		require.True(scanner.Scan())
+		var decoded clientapi.AuditEntry
+		require.NoError(json.Unmarshal(scanner.Bytes(), &decoded))
+		assert.Equal(entry.Sequence, decoded.Sequence)
+		assert.Equal(entry.Hash, decoded.Hash)
+	}
+	exporter.Close()
+}
+
+func TestExporterUDP(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conn, err := net.ListenPacket("udp", "localhost:0")
+	require.NoError(err)
+	defer conn.Close()
+
+	exporter, err := NewExporter("udp://"+conn.LocalAddr().String(), FormatCEF, zap.NewNop())
+	require.NoError(err)
+	exporter.Send(testEntries()[0])
+	exporter.Close()
+
+	require.NoError(conn.SetReadDeadline(time.Now().Add(5 * time.Second)))
+	buf := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(err)
+	assert.True(strings.HasPrefix(string(buf[:n]), "CEF:0|Edgeless Systems|MarbleRun Coordinator|1|SetManifest|"))
+}
@@ -0,0 +1,104 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package audit
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+)
+
+// appName identifies the Coordinator in syslog messages.
+const appName = "marblerun-coordinator"
+
+// syslogPriority is the priority of syslog messages: facility authpriv (10), severity notice (5).
+const syslogPriority = 10*8 + 5
+
+// syslogTimestamp is the RFC 5424 timestamp format, which allows at most microsecond precision.
+const syslogTimestamp = "2006-01-02T15:04:05.000000Z07:00"
+
+// eventInfo describes an event type in CEF.
+type eventInfo struct {
+	name string
+	// severity is the CEF severity from 0 (lowest) to 10 (highest)
+	severity int
+}
+
+var events = map[string]eventInfo{
+	clientapi.AuditEventSetManifest:       {"Manifest set", 6},
+	clientapi.AuditEventUpdateManifest:    {"Manifest updated", 6},
+	clientapi.AuditEventActivate:          {"Marble activated", 3},
+	clientapi.AuditEventReadSecrets:       {"Secrets read", 5},
+	clientapi.AuditEventRecover:           {"State recovered", 8},
+	clientapi.AuditEventResetActivations:  {"Activations reset", 6},
+	clientapi.AuditEventPauseActivations:  {"Activations paused", 6},
+	clientapi.AuditEventResumeActivations: {"Activations resumed", 6},
+	clientapi.AuditEventExportBackup:      {"Backup exported", 7},
+	clientapi.AuditEventRestoreBackup:     {"Backup restored", 8},
+	clientapi.AuditEventHandOff:           {"State handed off", 8},
+	clientapi.AuditEventIssueCertificate:  {"Certificate issued", 5},
+}
+
+// cefDetailFields is the number of custom string fields of a CEF event that can hold details. The last one holds the hash of the entry.
+const cefDetailFields = 5
+
+// formatSyslog formats the entry as RFC 5424 syslog message, using non-transparent framing.
+func formatSyslog(entry clientapi.AuditEntry, hostname string) ([]byte, error) {
+	msg, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	header := fmt.Sprintf("<%d>1 %s %s %s - %s - ", syslogPriority, entry.Time.Format(syslogTimestamp), hostname, appName, entry.Event)
+	return append(append([]byte(header), msg...), '\n'), nil
+}
+
+// formatCEF formats the entry as Common Event Format line.
+//
+// The details of the entry are mapped to the custom string fields cs1 to cs5, labeled with their keys. cs6 holds the hash of the entry.
+func formatCEF(entry clientapi.AuditEntry) ([]byte, error) {
+	if len(entry.Details) > cefDetailFields {
+		return nil, fmt.Errorf("audit entry has %v details, but CEF can only hold %v", len(entry.Details), cefDetailFields)
+	}
+	info, ok := events[entry.Event]
+	if !ok {
+		info = eventInfo{entry.Event, 5}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "CEF:0|Edgeless Systems|MarbleRun Coordinator|1|%s|%s|%d|", escapeCEFHeader(entry.Event), escapeCEFHeader(info.name), info.severity)
+	fmt.Fprintf(&b, "rt=%d externalId=%d", entry.Time.UnixNano()/1e6, entry.Sequence)
+	if entry.User != "" {
+		fmt.Fprintf(&b, " suser=%s", escapeCEFValue(entry.User))
+	}
+	if entry.ClientIP != "" {
+		fmt.Fprintf(&b, " src=%s", escapeCEFValue(entry.ClientIP))
+	}
+	keys := make([]string, 0, len(entry.Details))
+	for key := range entry.Details {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for i, key := range keys {
+		field := "cs" + strconv.Itoa(i+1)
+		fmt.Fprintf(&b, " %sLabel=%s %s=%s", field, escapeCEFValue(key), field, escapeCEFValue(entry.Details[key]))
+	}
+	fmt.Fprintf(&b, " cs6Label=Hash cs6=%s\n", hex.EncodeToString(entry.Hash))
+	return []byte(b.String()), nil
+}
+
+func escapeCEFHeader(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `|`, `\|`).Replace(s)
+}
+
+func escapeCEFValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`).Replace(s)
+}
@@ -0,0 +1,105 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package clientapi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Events recorded in the audit log.
+const (
+	AuditEventSetManifest    = "SetManifest"
+	AuditEventUpdateManifest = "UpdateManifest"
+	AuditEventActivate       = "Activate"
+	AuditEventReadSecrets    = "ReadSecrets"
+	AuditEventRecover        = "Recover"
+	AuditEventRevokeMarble   = "RevokeMarble"
+	// AuditEventResetActivations records that a User has set the activation counter of a marble type
+	AuditEventResetActivations = "ResetActivations"
+	// AuditEventPauseActivations and AuditEventResumeActivations record that a User has paused or resumed the activations of marbles
+	AuditEventPauseActivations  = "PauseActivations"
+	AuditEventResumeActivations = "ResumeActivations"
+	// AuditEventExportBackup and AuditEventRestoreBackup record that a User has exported a backup of the state and that a backup has been restored
+	AuditEventExportBackup  = "ExportBackup"
+	AuditEventRestoreBackup = "RestoreBackup"
+	// AuditEventHandOff records that a User has handed off the state to a new Coordinator, which continues the log
+	AuditEventHandOff = "HandOff"
+	// AuditEventIssueCertificate records that a User has obtained a certificate for one of the manifest's ExternalServices
+	AuditEventIssueCertificate = "IssueCertificate"
+	// AuditEventQuoteUserData records that a User has obtained a quote of the Coordinator over user data
+	AuditEventQuoteUserData = "QuoteUserData"
+)
+
+// AuditEntry is an entry of the Coordinator's audit log.
+//
+// The entries are chained by their hashes: each entry contains the hash of its predecessor,
+// so that any modification, insertion or removal of an entry changes the hashes of all subsequent entries.
+type AuditEntry struct {
+	// Sequence is the position of the entry in the log, starting at 0
+	Sequence uint64
+	Time     time.Time
+	// Event is one of the AuditEvent* constants
+	Event string
+	// User is the name of the manifest's User who caused the event, if any
+	User string `json:",omitempty"`
+	// ClientIP is the IP address of the client whose request caused the event, if any
+	ClientIP string `json:",omitempty"`
+	// Details describe the event, e.g., the MarbleType and UUID of an activated marble
+	Details map[string]string `json:",omitempty"`
+	// PrevHash is the Hash of the previous entry, or empty for the first entry
+	PrevHash []byte `json:",omitempty"`
+	// Hash is the SHA256 hash of the JSON encoding of the entry without its Hash
+	Hash []byte `json:",omitempty"`
+}
+
+func (e AuditEntry) computeHash() []byte {
+	e.Hash = nil
+	raw, err := json.Marshal(e)
+	if err != nil {
+		panic(err)
+	}
+	hash := sha256.Sum256(raw)
+	return hash[:]
+}
+
+// AppendAuditEntry chains entry to the end of log and returns the extended log.
+//
+// The Sequence, PrevHash and Hash of entry are set accordingly.
+func AppendAuditEntry(log []AuditEntry, entry AuditEntry) []AuditEntry {
+	entry.Sequence = uint64(len(log))
+	entry.PrevHash = nil
+	if len(log) > 0 {
+		entry.PrevHash = log[len(log)-1].Hash
+	}
+	entry.Hash = entry.computeHash()
+	return append(log, entry)
+}
+
+// VerifyAuditLog checks that the entries of log are correctly chained.
+//
+// Removing entries from the end of the log can't be detected this way. Auditors should compare the Hash of the
+// last entry they have seen with the log they retrieve later.
+func VerifyAuditLog(log []AuditEntry) error {
+	var prevHash []byte
+	for i, entry := range log {
+		if entry.Sequence != uint64(i) {
+			return fmt.Errorf("audit entry %v has sequence number %v", i, entry.Sequence)
+		}
+		if !bytes.Equal(entry.PrevHash, prevHash) {
+			return fmt.Errorf("audit entry %v is not chained to its predecessor", i)
+		}
+		if !bytes.Equal(entry.Hash, entry.computeHash()) {
+			return fmt.Errorf("audit entry %v has been modified", i)
+		}
+		prevHash = entry.Hash
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package clientapi
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+)
+
+// BackupVersion is the version of the backup format and the sealed state it contains.
+//
+// Coordinators restore backups up to the version they have been built with, so that a state is never restored by a release that doesn't understand it.
+const BackupVersion = 1
+
+// Backup is an encrypted snapshot of the Coordinator's state, which can be restored into a fresh Coordinator.
+//
+// The state is encrypted with a random backup key by AES-GCM, which also authenticates the other fields.
+// The backup key is encrypted with each of the manifest's RecoveryKeys, or split into shares if the manifest requires multiple recovery key holders.
+type Backup struct {
+	Version int
+	Created time.Time
+	// ManifestSignature is the hash of the manifest and its updates, like the signature returned by the /manifest endpoint
+	ManifestSignature []byte
+	// Threshold is the number of recovery key holders who must provide their share of the backup key, if it has been split
+	Threshold int `json:",omitempty"`
+	// Package contains the package properties of the exporting Coordinator, which the quote of the restoring Coordinator must satisfy.
+	// It is nil if the backup has been exported in simulation mode.
+	Package *quote.PackageProperties `json:",omitempty"`
+	// EncryptionKeys contains the backup key or its shares, RSA-OAEP encrypted with the RecoveryKey of each name
+	EncryptionKeys map[string][]byte
+	// State is the encrypted state
+	State []byte
+}
+
+// AdditionalData returns the data that is authenticated together with the encrypted state.
+func (b Backup) AdditionalData() ([]byte, error) {
+	b.State = nil
+	return json.Marshal(b)
+}
+
+// DecryptKey decrypts the backup key, or the share of it, that has been encrypted with the public key of the recovery key holder.
+//
+// Returns the name of the RecoveryKey whose entry could be decrypted.
+func (b Backup) DecryptKey(recoveryKey *rsa.PrivateKey) (name string, key []byte, err error) {
+	for name, data := range b.EncryptionKeys {
+		if key, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, recoveryKey, data, nil); err == nil {
+			return name, key, nil
+		}
+	}
+	return "", nil, errors.New("the backup key has not been encrypted with the given recovery key")
+}
@@ -0,0 +1,125 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package clientapi contains the types and functions of the Coordinator's client API that are shared with its clients.
+//
+// It must not depend on packages that can only be built for enclaves.
+package clientapi
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+)
+
+// MarbleStatus contains the activation statistics of a Marble type.
+type MarbleStatus struct {
+	// Number of activated Marbles of this type
+	Activations uint
+	// Maximum number of activations allowed by the manifest (0 means unlimited)
+	MaxActivations uint
+	// Number of activations that are still possible, or -1 if unlimited
+	Remaining int
+}
+
+// Marble describes an activated Marble.
+type Marble struct {
+	UUID       string
+	MarbleType string
+	// Package of the Marble and the properties its quote reported on its last activation, e.g., UniqueID, SignerID, ProductID, SecurityVersion and Debug.
+	// The properties are empty if the Marble has been activated in simulation mode.
+	Package           string
+	PackageProperties quote.PackageProperties
+	// Infrastructure the Marble's quote has been validated for on its last activation, or "simulation"
+	Infrastructure string
+	// Number of activations with the Marble's UUID and the time of the last one
+	Activations uint
+	ActivatedAt time.Time
+	// Time of the Marble's last heartbeat or activation
+	LastSeen time.Time
+	// Serial number (decimal) and expiry of the Marble's most recently issued certificate and whether the Marble has been revoked
+	CertificateSerial   string
+	CertificateNotAfter time.Time
+	Revoked             bool
+}
+
+// Maintenance describes whether the activations of Marbles are paused.
+type Maintenance struct {
+	Paused bool
+	// Time the activations have been paused, the User who paused them and the given reason, if they are paused
+	Since  time.Time `json:",omitempty"`
+	User   string    `json:",omitempty"`
+	Reason string    `json:",omitempty"`
+}
+
+// Secret is the value of a secret as it is uploaded to and read from the Coordinator.
+type Secret struct {
+	// Type is the type of the secret as defined in the manifest. It is ignored on upload.
+	Type string `json:",omitempty"`
+	// Cert is the DER-encoded certificate of cert-* secrets.
+	Cert []byte `json:",omitempty"`
+	// Private is the PKCS #8 DER-encoded private key of cert-* secrets, or the value of symmetric-key and plain secrets.
+	Private []byte `json:",omitempty"`
+	// Public is the PKIX DER-encoded public key of cert-* secrets, or the value of symmetric-key and plain secrets. It is ignored on upload.
+	Public []byte `json:",omitempty"`
+	// Version is incremented whenever the secret is rotated. It is ignored on upload.
+	Version uint `json:",omitempty"`
+}
+
+// MaxUserDataSize is the maximum size of the user data that the Coordinator issues a quote over, see UserDataQuoteMessage.
+const MaxUserDataSize = 1024
+
+// userDataQuotePrefix separates the quotes over user data from the Coordinator's other quotes, e.g., over its root certificate.
+const userDataQuotePrefix = "MarbleRun Coordinator user data\x00"
+
+// UserDataQuoteMessage returns the message that the Coordinator's quote over user data is issued for, e.g., over a session public key.
+//
+// The user data is prefixed, so that such a quote can't be passed off as a quote over the Coordinator's root certificate or over a handoff key.
+// Validate the quote for this message instead of the user data.
+func UserDataQuoteMessage(userData []byte) []byte {
+	return append([]byte(userDataQuotePrefix), userData...)
+}
+
+// ManifestSignatureHeader is the HTTP header that carries the base64-encoded detached signature of a manifest by a trusted signer when it is set.
+const ManifestSignatureHeader = "Marblerun-Manifest-Signature"
+
+// ManifestSignature returns the signature of a manifest with the given updates applied in order
+//
+// The signature is the SHA256 hash of the manifest, which is chained with each update: SHA256(signature || update).
+func ManifestSignature(rawManifest []byte, rawUpdates [][]byte) []byte {
+	hash := sha256.Sum256(rawManifest)
+	for _, rawUpdate := range rawUpdates {
+		hash = sha256.Sum256(append(hash[:], rawUpdate...))
+	}
+	return hash[:]
+}
+
+// ManifestVersion is an entry of the Coordinator's manifest history.
+type ManifestVersion struct {
+	// Version is 0 for the manifest that has been set and n after its n-th update has been applied
+	Version uint
+	Time    time.Time
+	// Event is AuditEventSetManifest or AuditEventUpdateManifest
+	Event string
+	// ManifestSignature is the hex-encoded hash of the manifest at this version, see ManifestSignature
+	ManifestSignature string
+	// Signer is the name of the trusted signer of the manifest, if any
+	Signer string `json:",omitempty"`
+	// AcknowledgedBy are the Users who acknowledged the update
+	AcknowledgedBy []string `json:",omitempty"`
+}
+
+// ManifestChange is a difference between two versions of the manifest.
+type ManifestChange struct {
+	// Path is the JSON path of the changed value, e.g., $.Packages.backend.SecurityVersion
+	Path string
+	// Old is the value in the older version, or empty if the value has been added
+	Old json.RawMessage `json:",omitempty"`
+	// New is the value in the newer version, or empty if the value has been removed
+	New json.RawMessage `json:",omitempty"`
+}
@@ -0,0 +1,69 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package clientapi
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifestSignature(t *testing.T) {
+	assert := assert.New(t)
+
+	manifest := []byte("manifest")
+	hash := sha256.Sum256(manifest)
+	assert.Equal(hash[:], ManifestSignature(manifest, nil))
+
+	update := []byte("update")
+	chained := sha256.Sum256(append(hash[:], update...))
+	assert.Equal(chained[:], ManifestSignature(manifest, [][]byte{update}))
+
+	// the order of the updates matters
+	other := []byte("other")
+	assert.NotEqual(ManifestSignature(manifest, [][]byte{update, other}), ManifestSignature(manifest, [][]byte{other, update}))
+}
+
+func TestAuditLog(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	assert.NoError(VerifyAuditLog(nil))
+
+	var log []AuditEntry
+	log = AppendAuditEntry(log, AuditEntry{Time: time.Now().UTC(), Event: AuditEventSetManifest})
+	log = AppendAuditEntry(log, AuditEntry{Time: time.Now().UTC(), Event: AuditEventActivate, Details: map[string]string{"MarbleType": "frontend"}})
+	log = AppendAuditEntry(log, AuditEntry{Time: time.Now().UTC(), Event: AuditEventReadSecrets, User: "admin"})
+	require.Len(log, 3)
+	assert.EqualValues(2, log[2].Sequence)
+	assert.Empty(log[0].PrevHash)
+	assert.Equal(log[1].Hash, log[2].PrevHash)
+	require.NoError(VerifyAuditLog(log))
+
+	// the log survives the round trip through the client API
+	raw, err := json.Marshal(log)
+	require.NoError(err)
+	var decoded []AuditEntry
+	require.NoError(json.Unmarshal(raw, &decoded))
+	assert.NoError(VerifyAuditLog(decoded))
+
+	// modified entry
+	tampered := append([]AuditEntry{}, log...)
+	tampered[1].Details = map[string]string{"MarbleType": "backend"}
+	assert.Error(VerifyAuditLog(tampered))
+
+	// modified entry with recomputed hash
+	tampered[1].Hash = tampered[1].computeHash()
+	assert.Error(VerifyAuditLog(tampered))
+
+	// removed entry
+	assert.Error(VerifyAuditLog([]AuditEntry{log[0], log[2]}))
+}
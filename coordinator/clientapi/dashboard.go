@@ -0,0 +1,102 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package clientapi
+
+import (
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+)
+
+// Topology describes the marble types of the mesh and how their connections are wrapped, e.g., for a dashboard.
+type Topology struct {
+	MarbleTypes map[string]TopologyMarbleType
+	// TLSTags describes the entries of the manifest's TLS section by tag
+	TLSTags map[string]TopologyTLSTag `json:",omitempty"`
+}
+
+// TopologyMarbleType describes a marble type of the mesh and its activations.
+type TopologyMarbleType struct {
+	Package string
+	// Number of activations of this type and the maximum number allowed by the manifest (0 means unlimited)
+	Activations    uint
+	MaxActivations uint
+	// ActiveMarbles is the number of marbles whose activations haven't been released
+	ActiveMarbles uint
+	// Infrastructures counts the activations of this type per infrastructure
+	Infrastructures map[string]uint `json:",omitempty"`
+	// TLSTags are the tags of the TLS section whose connections the marbles wrap in mTLS
+	TLSTags []string `json:",omitempty"`
+}
+
+// TopologyTLSTag describes an entry of the manifest's TLS section, which connects the marbles of its types.
+type TopologyTLSTag struct {
+	MarbleTypes []string
+	// Outgoing are the addresses the marbles connect to, Incoming the ports on which they accept connections
+	Outgoing []string `json:",omitempty"`
+	Incoming []uint16 `json:",omitempty"`
+}
+
+// Activation is an activation of a marble as recorded in the audit log.
+type Activation struct {
+	// Sequence is the position of the activation's entry in the audit log
+	Sequence       uint64
+	Time           time.Time
+	MarbleType     string
+	UUID           string
+	Infrastructure string
+	// Resumed is set if the marble already held its activation, e.g., after a restart with its UUID
+	Resumed bool `json:",omitempty"`
+}
+
+// ManifestSummary summarizes the active manifest without the parameters of the marbles and the values of secrets, e.g., for a dashboard.
+type ManifestSummary struct {
+	// Version, ManifestSignature and Signer identify the manifest, see ManifestVersion
+	Version           uint
+	ManifestSignature string
+	Signer            string `json:",omitempty"`
+	// Packages contains the measurements of the packages, e.g., UniqueID or SignerID, ProductID and SecurityVersion
+	Packages        map[string]quote.PackageProperties
+	Infrastructures map[string]quote.InfrastructureProperties
+	// MarbleTypes maps the marble types to their packages
+	MarbleTypes map[string]string
+	// Secrets maps the names of the secrets to their types
+	Secrets map[string]string `json:",omitempty"`
+	// Users maps the names of the users to their roles
+	Users map[string][]string `json:",omitempty"`
+	// RecoveryKeys are the names of the recovery keys
+	RecoveryKeys    []string `json:",omitempty"`
+	UpdateThreshold uint     `json:",omitempty"`
+}
+
+// TCBStatus describes the TCB requirements of the manifest, the activations that have been rejected because of the TCB of their platform,
+// and the collateral that quotes are validated with, e.g., for a dashboard.
+type TCBStatus struct {
+	Infrastructures map[string]InfrastructureTCB
+	// Rejections counts the rejected activations of each marble type since the Coordinator started
+	Rejections map[string]TCBRejections `json:",omitempty"`
+	// Collateral is the collateral of the vendors that has been used so far, e.g., Intel's TCB info
+	Collateral []quote.CollateralStatus `json:",omitempty"`
+}
+
+// InfrastructureTCB describes the TCB requirements of an infrastructure and the activations on it.
+type InfrastructureTCB struct {
+	Type string `json:",omitempty"`
+	// AcceptedTCBStatuses and AcceptedAdvisories are the TCB statuses and Intel security advisories the infrastructure accepts
+	AcceptedTCBStatuses []string `json:",omitempty"`
+	AcceptedAdvisories  []string `json:",omitempty"`
+	// Activations is the number of activations on the infrastructure
+	Activations uint
+}
+
+// TCBRejections counts the activations of a marble type that have been rejected because of the TCB of their platform.
+type TCBRejections struct {
+	Count uint
+	// Time and Reason of the latest rejection
+	Last   time.Time
+	Reason string
+}
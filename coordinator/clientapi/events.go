@@ -0,0 +1,35 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package clientapi
+
+import "time"
+
+// The types of the events of the mesh.
+const (
+	// EventActivation is emitted when a marble has been activated
+	EventActivation = "Activation"
+	// EventActivationFailure is emitted when the activation of a marble has failed
+	EventActivationFailure = "ActivationFailure"
+	// EventRevocation is emitted when the certificates of marbles have been revoked
+	EventRevocation = "Revocation"
+	// EventManifestChange is emitted when the manifest has been set or updated
+	EventManifestChange = "ManifestChange"
+)
+
+// Event is something that happened in the mesh, which is streamed to the clients that watch the mesh.
+type Event struct {
+	Type string
+	Time time.Time
+	// MarbleType is the type of the marble the event concerns. It is empty for events that don't concern marbles, and it is "unknown"
+	// for failed activations of types that the manifest doesn't define.
+	MarbleType     string `json:",omitempty"`
+	UUID           string `json:",omitempty"`
+	Infrastructure string `json:",omitempty"`
+	// Reason is the reason of the error a failed activation has been rejected with, if any
+	Reason  string `json:",omitempty"`
+	Message string `json:",omitempty"`
+}
@@ -0,0 +1,23 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package clientapi
+
+// HandoffRequest is sent by a new Coordinator to the running one to take over its state, e.g., to upgrade the Coordinator without downtime.
+type HandoffRequest struct {
+	// PublicKey is the PKIX-encoded RSA public key the state is handed off with
+	PublicKey []byte
+	// Quote is the quote of the new Coordinator over the PublicKey
+	Quote []byte
+}
+
+// Handoff contains the state that the running Coordinator hands off to a new one.
+//
+// The state and its encryption key are encrypted with a random key by AES-GCM. This key is RSA-OAEP encrypted with the PublicKey of the HandoffRequest.
+type Handoff struct {
+	Key   []byte
+	State []byte
+}
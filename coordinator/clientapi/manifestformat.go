@@ -0,0 +1,122 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package clientapi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// Formats in which a manifest or manifest update may be written.
+const (
+	ManifestFormatJSON = "json"
+	ManifestFormatYAML = "yaml"
+	ManifestFormatTOML = "toml"
+)
+
+// ManifestFormat returns the format of a manifest. It is given by contentType if that is a JSON, YAML or TOML media type,
+// otherwise it is detected from the content.
+func ManifestFormat(rawManifest []byte, contentType string) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		switch mediaType {
+		case "application/json":
+			return ManifestFormatJSON
+		case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+			return ManifestFormatYAML
+		case "application/toml", "text/toml":
+			return ManifestFormatTOML
+		}
+	}
+
+	// both YAML and TOML use # for comments, so the first other line tells them apart
+	scanner := bufio.NewScanner(bytes.NewReader(rawManifest))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "{") {
+			return ManifestFormatJSON
+		}
+		// a TOML table header or key/value pair
+		if strings.HasPrefix(line, "[") {
+			return ManifestFormatTOML
+		}
+		if equals := strings.Index(line, "="); equals >= 0 {
+			if colon := strings.Index(line, ":"); colon < 0 || equals < colon {
+				return ManifestFormatTOML
+			}
+		}
+		return ManifestFormatYAML
+	}
+	return ManifestFormatJSON
+}
+
+// CanonicalManifest returns the JSON form of a manifest or manifest update that is written in JSON, YAML or TOML.
+//
+// The format is determined by ManifestFormat. JSON is returned unchanged. YAML and TOML are converted to compact JSON with sorted keys,
+// which is the form the Coordinator hashes and trusted signers sign, so that the same document always yields the same manifest signature.
+func CanonicalManifest(rawManifest []byte, contentType string) ([]byte, error) {
+	var manifest interface{}
+	switch ManifestFormat(rawManifest, contentType) {
+	case ManifestFormatJSON:
+		return rawManifest, nil
+	case ManifestFormatYAML:
+		if err := yaml.Unmarshal(rawManifest, &manifest); err != nil {
+			return nil, fmt.Errorf("invalid YAML manifest: %v", err)
+		}
+		var err error
+		if manifest, err = convertYAML(manifest); err != nil {
+			return nil, fmt.Errorf("invalid YAML manifest: %v", err)
+		}
+	case ManifestFormatTOML:
+		var table map[string]interface{}
+		if _, err := toml.Decode(string(rawManifest), &table); err != nil {
+			return nil, fmt.Errorf("invalid TOML manifest: %v", err)
+		}
+		manifest = table
+	}
+	if _, ok := manifest.(map[string]interface{}); !ok {
+		return nil, errors.New("the manifest is not a mapping")
+	}
+	return json.Marshal(manifest)
+}
+
+// convertYAML converts the mappings decoded by the yaml package to maps with string keys, which can be encoded to JSON.
+func convertYAML(value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			var err error
+			if converted[fmt.Sprint(key)], err = convertYAML(item); err != nil {
+				return nil, err
+			}
+		}
+		if len(converted) != len(value) {
+			return nil, errors.New("keys of a mapping are ambiguous")
+		}
+		return converted, nil
+	case []interface{}:
+		for i, item := range value {
+			var err error
+			if value[i], err = convertYAML(item); err != nil {
+				return nil, err
+			}
+		}
+		return value, nil
+	}
+	return value, nil
+}
@@ -0,0 +1,89 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package clientapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const yamlManifest = `# the backend of the demo
+Packages:
+  backend:
+    SignerID: "0102"
+    ProductID: 1
+    Debug: true
+Marbles:
+  server:
+    Package: backend
+    Parameters:
+      Argv: [./marble, serve]
+      Env:
+        ROOT_CA: "{{ pem .Marblerun.RootCA.Cert }}"
+`
+
+const tomlManifest = `# the backend of the demo
+[Packages.backend]
+SignerID = "0102"
+ProductID = 1
+Debug = true
+
+[Marbles.server]
+Package = "backend"
+
+[Marbles.server.Parameters]
+Argv = ["./marble", "serve"]
+Env = { ROOT_CA = "{{ pem .Marblerun.RootCA.Cert }}" }
+`
+
+const canonicalManifest = `{"Marbles":{"server":{"Package":"backend","Parameters":{"Argv":["./marble","serve"],"Env":{"ROOT_CA":"{{ pem .Marblerun.RootCA.Cert }}"}}}},"Packages":{"backend":{"Debug":true,"ProductID":1,"SignerID":"0102"}}}`
+
+func TestManifestFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(ManifestFormatJSON, ManifestFormat([]byte(canonicalManifest), ""))
+	assert.Equal(ManifestFormatJSON, ManifestFormat([]byte("\n  {}"), "text/plain"))
+	assert.Equal(ManifestFormatYAML, ManifestFormat([]byte(yamlManifest), ""))
+	assert.Equal(ManifestFormatYAML, ManifestFormat([]byte(`Packages: {"backend": {"SignerID": "a=b"}}`), ""))
+	assert.Equal(ManifestFormatTOML, ManifestFormat([]byte(tomlManifest), ""))
+	assert.Equal(ManifestFormatTOML, ManifestFormat([]byte(`RecoveryThreshold = 1`), ""))
+
+	// the content type takes precedence
+	assert.Equal(ManifestFormatYAML, ManifestFormat([]byte(canonicalManifest), "application/yaml; charset=utf-8"))
+	assert.Equal(ManifestFormatTOML, ManifestFormat([]byte(yamlManifest), "application/toml"))
+}
+
+func TestCanonicalManifest(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	for _, rawManifest := range []string{yamlManifest, tomlManifest} {
+		canonical, err := CanonicalManifest([]byte(rawManifest), "")
+		require.NoError(err, rawManifest)
+		assert.Equal(canonicalManifest, string(canonical))
+	}
+
+	// JSON is kept as it is, so that the signatures of existing manifests don't change
+	rawManifest := []byte(`{"Packages": {}}`)
+	canonical, err := CanonicalManifest(rawManifest, "application/json")
+	require.NoError(err)
+	assert.Equal(rawManifest, canonical)
+
+	for _, rawManifest := range []string{
+		"Packages: {}\nPackages: {}",
+		"- Packages",
+		"1: a\n\"1\": b",
+		"Packages: [",
+	} {
+		_, err := CanonicalManifest([]byte(rawManifest), "application/yaml")
+		assert.Error(err, rawManifest)
+	}
+	_, err = CanonicalManifest([]byte("RecoveryThreshold = 1\nRecoveryThreshold = 2"), "application/toml")
+	assert.Error(err)
+}
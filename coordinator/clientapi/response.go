@@ -0,0 +1,51 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package clientapi
+
+// BasePath is the path prefix of all routes of version 1 of the client API.
+const BasePath = "/api/v1"
+
+// ContentType is the content type of all responses of the client API.
+const ContentType = "application/json"
+
+// Status of a Response.
+const (
+	StatusSuccess = "success"
+	StatusError   = "error"
+)
+
+// Error codes of a failed request. They are stable and can be used to handle errors programmatically.
+const (
+	ErrorBadRequest       = "BadRequest"
+	ErrorUnauthorized     = "Unauthorized"
+	ErrorNotFound         = "NotFound"
+	ErrorMethodNotAllowed = "MethodNotAllowed"
+	ErrorNotAcceptable    = "NotAcceptable"
+	ErrorInternal         = "InternalError"
+)
+
+// Response is the envelope of all responses of the client API.
+type Response struct {
+	// Status is StatusSuccess or StatusError
+	Status string
+	// Data contains the result of a successful request. It is null if the request has no result.
+	Data interface{}
+	// Error describes why the request failed. It is omitted on success.
+	Error *Error `json:",omitempty"`
+}
+
+// Error describes why a request to the client API failed.
+type Error struct {
+	// Code is one of the Error* constants
+	Code string
+	// Message is a human-readable description of the error
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
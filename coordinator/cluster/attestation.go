@@ -0,0 +1,128 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package cluster
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+)
+
+// Attestation attests the nodes of a cluster to each other.
+//
+// Each node presents a certificate whose key has been generated in the enclave and that contains a quote of the key (RA-TLS).
+// Only peers whose quotes report the Package are accepted, so the replicated state is only exchanged between attested Coordinators.
+type Attestation struct {
+	Issuer    quote.Issuer
+	Validator quote.Validator
+	// Package contains the properties that the quotes of peers must report, see SelfPackageProperties
+	Package quote.PackageProperties
+	// Infrastructure is the infrastructure the quotes of peers are validated for
+	Infrastructure quote.InfrastructureProperties
+}
+
+// SelfPackageProperties returns the package properties reported by a quote of this Coordinator, i.e., its own measurement.
+//
+// Requiring them from the peers ensures that the cluster only consists of Coordinators that run the same enclave.
+func SelfPackageProperties(issuer quote.Issuer, validator quote.Validator, ip quote.InfrastructureProperties) (quote.PackageProperties, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return quote.PackageProperties{}, err
+	}
+	selfQuote, err := issuer.Issue(data)
+	if err != nil {
+		return quote.PackageProperties{}, fmt.Errorf("failed to issue a quote of the Coordinator: %v", err)
+	}
+	props, err := quote.ValidateReport(validator, selfQuote, data, quote.PackageProperties{}, ip)
+	if err != nil {
+		return quote.PackageProperties{}, fmt.Errorf("failed to validate the quote of the Coordinator: %v", err)
+	}
+	if reflect.DeepEqual(props, quote.PackageProperties{}) {
+		return quote.PackageProperties{}, errors.New("the quote of the Coordinator does not report its package properties")
+	}
+	return props, nil
+}
+
+// newAttestedCertificate creates the RA-TLS certificate of a node. It is issued with the cluster certificate, which is appended to the chain.
+func newAttestedCertificate(clusterCert tls.Certificate, issuer quote.Issuer) (tls.Certificate, error) {
+	parent, err := x509.ParseCertificate(clusterCert.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	rawPub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	nodeQuote, err := issuer.Issue(rawPub)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to issue a quote of the cluster certificate: %v", err)
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:    serialNumber,
+		Subject:         pkix.Name{CommonName: "MarbleRun Coordinator cluster node"},
+		NotBefore:       time.Now().Add(-time.Minute),
+		NotAfter:        parent.NotAfter,
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		ExtraExtensions: []pkix.Extension{{Id: quote.OIDRATLSQuote, Value: nodeQuote}},
+	}
+	certRaw, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, clusterCert.PrivateKey)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to issue the RA-TLS certificate with the cluster certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: append([][]byte{certRaw}, clusterCert.Certificate...), PrivateKey: key}, nil
+}
+
+// verifyPeer verifies that the certificate chain of a peer has been issued by the cluster CA and that its quote is valid.
+func verifyPeer(rawCerts [][]byte, roots *x509.CertPool, attestation Attestation) error {
+	if len(rawCerts) == 0 {
+		return errors.New("peer did not send a certificate")
+	}
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certs[i] = cert
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+		return err
+	}
+
+	peerQuote := quote.GetRATLSQuote(certs[0])
+	if peerQuote == nil {
+		return errors.New("peer certificate does not contain a quote")
+	}
+	if err := attestation.Validator.Validate(peerQuote, certs[0].RawSubjectPublicKeyInfo, attestation.Package, attestation.Infrastructure); err != nil {
+		return fmt.Errorf("invalid quote of peer: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,501 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package cluster replicates the state of the Coordinator among multiple instances with the Raft consensus algorithm.
+//
+// The state of the Coordinator is replicated as a whole, so each log entry supersedes all previous ones and the log is compacted to
+// its last entry. The leader commits new states, which may have been derived by any node: a node proposes a state together with the
+// index of the state it was derived from, and the leader rejects it if another state has been committed in the meantime.
+// This way, every node can serve activations and client requests, and concurrent changes are serialized by the leader.
+package cluster
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/core"
+	"go.uber.org/zap"
+)
+
+// Timing of the Raft protocol. An election is started if a follower hasn't heard from the leader for a random duration between electionTimeout and 2*electionTimeout.
+var (
+	heartbeatInterval = 100 * time.Millisecond
+	electionTimeout   = time.Second
+	replicateTimeout  = 5 * time.Second
+)
+
+// ErrNoLeader occurs if a state is proposed while the cluster has no leader.
+var ErrNoLeader = errors.New("the cluster has no leader")
+
+type role int
+
+const (
+	follower role = iota
+	candidate
+	leader
+)
+
+// Entry is the last entry of the replicated log. It holds a complete state of the Coordinator.
+type Entry struct {
+	Index uint64
+	Term  uint64
+	Data  []byte `json:",omitempty"`
+}
+
+// Config configures a cluster node.
+type Config struct {
+	// Addr is the address at which the node is reachable by its peers. It identifies the node.
+	Addr string
+	// Peers contains the addresses of the other nodes
+	Peers []string
+	// TLSConfig authenticates the nodes to each other, see NewTLSConfig
+	TLSConfig *tls.Config
+}
+
+// ApplyFunc applies a committed state with the given index to the Coordinator.
+type ApplyFunc func(state []byte, index uint64) error
+
+// Node is a member of a Coordinator cluster. It implements the core.Replicator interface.
+type Node struct {
+	id      string
+	peers   []string
+	storage Storage
+	apply   ApplyFunc
+	client  *transport
+	logger  *zap.Logger
+
+	mux         sync.Mutex
+	role        role
+	term        uint64
+	votedFor    string
+	entry       Entry
+	commitIndex uint64
+	leader      string
+	lastContact time.Time
+	timeout     time.Duration
+	// peerEntries contains the Index and Term of the entry each peer has reported, so that the data is only sent to peers that need it
+	peerEntries map[string]Entry
+
+	// proposeMux serializes the replication of entries on the leader
+	proposeMux   sync.Mutex
+	appliedIndex uint64
+	applySignal  chan struct{}
+	stop         chan struct{}
+	stopOnce     sync.Once
+	wg           sync.WaitGroup
+}
+
+// NewNode creates a cluster node. The persisted Raft state is loaded from storage.
+//
+// Committed states are passed to apply in order, but states that are superseded before they are applied may be skipped.
+// The node serves its peers with the handler returned by Handler.
+func NewNode(config Config, storage Storage, apply ApplyFunc, logger *zap.Logger) (*Node, error) {
+	hardState, err := storage.Load()
+	if err != nil {
+		return nil, err
+	}
+	return &Node{
+		id:          config.Addr,
+		peers:       config.Peers,
+		storage:     storage,
+		apply:       apply,
+		client:      newTransport(config.TLSConfig),
+		logger:      logger,
+		term:        hardState.Term,
+		votedFor:    hardState.VotedFor,
+		entry:       hardState.Entry,
+		lastContact: time.Now(),
+		timeout:     randomElectionTimeout(),
+		peerEntries: make(map[string]Entry),
+		applySignal: make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+	}, nil
+}
+
+// Start runs the Raft protocol in the background until Stop is called.
+func (n *Node) Start() {
+	n.wg.Add(2)
+	go n.run()
+	go n.runApply()
+}
+
+// Stop stops the node.
+func (n *Node) Stop() {
+	n.stopOnce.Do(func() { close(n.stop) })
+	n.wg.Wait()
+}
+
+// IsLeader returns true if the node is the leader of the cluster.
+func (n *Node) IsLeader() bool {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	return n.role == leader
+}
+
+// Leader returns the address of the current leader, or an empty string if it is unknown.
+func (n *Node) Leader() string {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	return n.leader
+}
+
+// Replicate commits a state that has been derived from the state with index baseIndex and returns the index of the new state.
+//
+// If this node is not the leader, the state is forwarded to the leader.
+// Returns core.ErrStateConflict if another state has been committed after baseIndex.
+func (n *Node) Replicate(state []byte, baseIndex uint64) (uint64, error) {
+	n.mux.Lock()
+	isLeader, leaderAddr := n.role == leader, n.leader
+	n.mux.Unlock()
+	if isLeader {
+		return n.propose(state, baseIndex)
+	}
+	if leaderAddr == "" {
+		return 0, ErrNoLeader
+	}
+	return n.client.propose(leaderAddr, proposeReq{Data: state, BaseIndex: baseIndex})
+}
+
+// propose commits a state on the leader.
+func (n *Node) propose(state []byte, baseIndex uint64) (uint64, error) {
+	n.proposeMux.Lock()
+	defer n.proposeMux.Unlock()
+
+	n.mux.Lock()
+	if n.role != leader {
+		n.mux.Unlock()
+		return 0, ErrNoLeader
+	}
+	if baseIndex != n.commitIndex {
+		n.mux.Unlock()
+		return 0, core.ErrStateConflict
+	}
+	entry := Entry{Index: n.entry.Index + 1, Term: n.term, Data: state}
+	if err := n.setEntry(entry); err != nil {
+		n.mux.Unlock()
+		return 0, err
+	}
+	n.mux.Unlock()
+
+	if err := n.replicate(entry); err != nil {
+		return 0, err
+	}
+	return entry.Index, nil
+}
+
+// replicate sends the entry to the peers and commits it once a majority of the cluster has stored it. proposeMux must be locked.
+func (n *Node) replicate(entry Entry) error {
+	results := make(chan bool, len(n.peers))
+	for _, peer := range n.peers {
+		go func(peer string) {
+			results <- n.sendAppendEntries(peer, entry.Term, true)
+		}(peer)
+	}
+
+	acks := 1 // the leader has stored the entry
+	timeout := time.After(replicateTimeout)
+	for received := 0; acks < n.quorum() && received < len(n.peers); received++ {
+		select {
+		case ok := <-results:
+			if ok {
+				acks++
+			}
+		case <-timeout:
+			received = len(n.peers)
+		}
+	}
+	if acks < n.quorum() {
+		return fmt.Errorf("failed to replicate the state to a majority of the cluster: %v of %v nodes stored it", acks, len(n.peers)+1)
+	}
+
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	if n.role != leader || n.term != entry.Term {
+		return ErrNoLeader
+	}
+	if entry.Index > n.commitIndex {
+		n.commitIndex = entry.Index
+		n.signalApply()
+	}
+	return nil
+}
+
+func (n *Node) quorum() int {
+	return (len(n.peers)+1)/2 + 1
+}
+
+func (n *Node) run() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+		}
+
+		n.mux.Lock()
+		currentRole, term, elapsed, timeout := n.role, n.term, time.Since(n.lastContact), n.timeout
+		n.mux.Unlock()
+		if currentRole == leader {
+			for _, peer := range n.peers {
+				go n.sendAppendEntries(peer, term, false)
+			}
+			// like on the followers, a committed entry that could not be applied is retried
+			n.signalApply()
+		} else if elapsed > timeout {
+			n.startElection()
+		}
+	}
+}
+
+// startElection makes the node a candidate for the next term and requests the votes of its peers.
+func (n *Node) startElection() {
+	n.mux.Lock()
+	n.role = candidate
+	n.term++
+	n.votedFor = n.id
+	n.leader = ""
+	n.lastContact = time.Now()
+	n.timeout = randomElectionTimeout()
+	if err := n.saveHardState(); err != nil {
+		n.mux.Unlock()
+		n.logger.Error("failed to persist Raft state", zap.Error(err))
+		return
+	}
+	req := voteReq{Term: n.term, CandidateID: n.id, LastIndex: n.entry.Index, LastTerm: n.entry.Term}
+	n.mux.Unlock()
+	n.logger.Info("starting election", zap.Uint64("term", req.Term))
+
+	votes := 1
+	if votes >= n.quorum() {
+		n.becomeLeader(req.Term)
+		return
+	}
+	results := make(chan bool, len(n.peers))
+	for _, peer := range n.peers {
+		go func(peer string) {
+			resp, err := n.client.requestVote(peer, req)
+			if err != nil {
+				results <- false
+				return
+			}
+			n.mux.Lock()
+			n.observeTerm(resp.Term)
+			n.mux.Unlock()
+			results <- resp.VoteGranted
+		}(peer)
+	}
+	for range n.peers {
+		if <-results {
+			votes++
+		}
+		if votes >= n.quorum() {
+			n.becomeLeader(req.Term)
+			return
+		}
+	}
+}
+
+// becomeLeader makes the node the leader of the term if it is still a candidate in this term.
+func (n *Node) becomeLeader(term uint64) {
+	n.mux.Lock()
+	if n.role != candidate || n.term != term {
+		n.mux.Unlock()
+		return
+	}
+	n.role = leader
+	n.leader = n.id
+	n.peerEntries = make(map[string]Entry)
+	last := n.entry
+	n.mux.Unlock()
+	n.logger.Info("elected as leader", zap.Uint64("term", term))
+
+	// Entries of previous terms are only committed together with an entry of the current term.
+	// The state is unchanged, so the last entry is appended again in the current term.
+	if last.Index == 0 {
+		return
+	}
+	go func() {
+		n.proposeMux.Lock()
+		defer n.proposeMux.Unlock()
+		n.mux.Lock()
+		if n.role != leader || n.term != term {
+			n.mux.Unlock()
+			return
+		}
+		entry := Entry{Index: last.Index + 1, Term: term, Data: last.Data}
+		err := n.setEntry(entry)
+		n.mux.Unlock()
+		if err == nil {
+			err = n.replicate(entry)
+		}
+		if err != nil {
+			n.logger.Error("failed to commit the state of the previous leader", zap.Error(err))
+		}
+	}()
+}
+
+// sendAppendEntries sends the last entry to a peer and returns true if the peer has stored it.
+//
+// The data of the entry is only sent if the peer doesn't have it yet, or if force is set.
+func (n *Node) sendAppendEntries(peer string, term uint64, force bool) bool {
+	n.mux.Lock()
+	if n.role != leader || n.term != term {
+		n.mux.Unlock()
+		return false
+	}
+	req := appendReq{Term: term, LeaderID: n.id, Entry: n.entry, CommitIndex: n.commitIndex}
+	if known := n.peerEntries[peer]; !force && known.Index == req.Entry.Index && known.Term == req.Entry.Term {
+		req.Entry.Data = nil
+	}
+	n.mux.Unlock()
+
+	resp, err := n.client.appendEntries(peer, req)
+	if err != nil {
+		return false
+	}
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	if n.observeTerm(resp.Term) {
+		return false
+	}
+	n.peerEntries[peer] = Entry{Index: resp.Index, Term: resp.EntryTerm}
+	return resp.Success
+}
+
+// handleAppendEntries stores the leader's entry and applies it once it has been committed.
+func (n *Node) handleAppendEntries(req appendReq) appendResp {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	if req.Term < n.term {
+		return n.appendResp(false)
+	}
+	n.observeTerm(req.Term)
+	n.role = follower
+	n.leader = req.LeaderID
+	n.lastContact = time.Now()
+
+	if req.Entry.Index != n.entry.Index || req.Entry.Term != n.entry.Term {
+		// the data is omitted if the leader believes this node already has the entry
+		if req.Entry.Data == nil && req.Entry.Index > 0 {
+			return n.appendResp(false)
+		}
+		if err := n.setEntry(req.Entry); err != nil {
+			n.logger.Error("failed to persist Raft state", zap.Error(err))
+			return n.appendResp(false)
+		}
+	}
+	if req.CommitIndex >= n.entry.Index && n.entry.Index > n.commitIndex {
+		n.commitIndex = n.entry.Index
+	}
+	// a committed entry that could not be applied, e.g., because the Coordinator was in recovery mode, is retried with each heartbeat
+	if n.commitIndex > 0 {
+		n.signalApply()
+	}
+	return n.appendResp(true)
+}
+
+func (n *Node) appendResp(success bool) appendResp {
+	return appendResp{Term: n.term, Success: success, Index: n.entry.Index, EntryTerm: n.entry.Term}
+}
+
+// handleRequestVote grants the vote if the node hasn't voted for another candidate in the term and the candidate's entry is at least as recent.
+func (n *Node) handleRequestVote(req voteReq) voteResp {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	if req.Term < n.term {
+		return voteResp{Term: n.term}
+	}
+	n.observeTerm(req.Term)
+	upToDate := req.LastTerm > n.entry.Term || (req.LastTerm == n.entry.Term && req.LastIndex >= n.entry.Index)
+	if !upToDate || (n.votedFor != "" && n.votedFor != req.CandidateID) {
+		return voteResp{Term: n.term}
+	}
+	n.votedFor = req.CandidateID
+	if err := n.saveHardState(); err != nil {
+		n.logger.Error("failed to persist Raft state", zap.Error(err))
+		return voteResp{Term: n.term}
+	}
+	n.lastContact = time.Now()
+	return voteResp{Term: n.term, VoteGranted: true}
+}
+
+// observeTerm steps down to a follower if term is newer than the node's term and returns true in that case. n.mux must be locked.
+func (n *Node) observeTerm(term uint64) bool {
+	if term <= n.term {
+		return false
+	}
+	n.term = term
+	n.votedFor = ""
+	n.role = follower
+	n.leader = ""
+	if err := n.saveHardState(); err != nil {
+		n.logger.Error("failed to persist Raft state", zap.Error(err))
+	}
+	return true
+}
+
+// setEntry replaces the last entry and persists it. n.mux must be locked.
+func (n *Node) setEntry(entry Entry) error {
+	old := n.entry
+	n.entry = entry
+	if err := n.saveHardState(); err != nil {
+		n.entry = old
+		return err
+	}
+	return nil
+}
+
+func (n *Node) saveHardState() error {
+	return n.storage.Save(HardState{Term: n.term, VotedFor: n.votedFor, Entry: n.entry})
+}
+
+func (n *Node) signalApply() {
+	select {
+	case n.applySignal <- struct{}{}:
+	default:
+	}
+}
+
+// runApply applies the committed entries. It runs separately from the protocol, because the Coordinator may propose a new state while an entry is being applied.
+func (n *Node) runApply() {
+	defer n.wg.Done()
+	// failed applications are retried, but the same error is only logged once
+	var lastErr string
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-n.applySignal:
+		}
+
+		n.mux.Lock()
+		entry, commitIndex := n.entry, n.commitIndex
+		n.mux.Unlock()
+		if entry.Index > commitIndex || entry.Index <= n.appliedIndex {
+			continue
+		}
+		if err := n.apply(entry.Data, entry.Index); err != nil {
+			if err.Error() != lastErr {
+				n.logger.Error("failed to apply replicated state", zap.Uint64("index", entry.Index), zap.Error(err))
+				lastErr = err.Error()
+			}
+			continue
+		}
+		lastErr = ""
+		n.appliedIndex = entry.Index
+	}
+}
+
+func randomElectionTimeout() time.Duration {
+	return electionTimeout + time.Duration(rand.Int63n(int64(electionTimeout)))
+}
@@ -0,0 +1,506 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package cluster
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/core"
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/coordinator/quote/quotetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// testIssuer issues quotes that report its measurement as the UniqueID.
+type testIssuer struct {
+	measurement string
+}
+
+func (i testIssuer) Issue(cert []byte) ([]byte, error) {
+	hash := sha256.Sum256(cert)
+	return append([]byte(i.measurement+":"), hash[:]...), nil
+}
+
+// testValidator validates the quotes of a testIssuer.
+type testValidator struct{}
+
+func (v testValidator) Validate(givenQuote []byte, cert []byte, pp quote.PackageProperties, ip quote.InfrastructureProperties) error {
+	_, err := v.ValidateReport(givenQuote, cert, pp, ip)
+	return err
+}
+
+func (testValidator) ValidateReport(givenQuote []byte, cert []byte, pp quote.PackageProperties, ip quote.InfrastructureProperties) (quote.PackageProperties, error) {
+	hash := sha256.Sum256(cert)
+	sep := bytes.IndexByte(givenQuote, ':')
+	if sep < 0 || !bytes.Equal(givenQuote[sep+1:], hash[:]) {
+		return quote.PackageProperties{}, errors.New("invalid quote")
+	}
+	reportedProps := quote.PackageProperties{UniqueID: string(givenQuote[:sep])}
+	if !pp.IsCompliant(reportedProps) {
+		return quote.PackageProperties{}, fmt.Errorf("PackageProperties not compliant:\n%v\n%v", reportedProps, pp)
+	}
+	return reportedProps, nil
+}
+
+// newTestTLSConfig creates the TLS configuration of a node with the given measurement that accepts peers with the measurement "coordinator".
+func newTestTLSConfig(t *testing.T, caCert *x509.Certificate, caKey *ecdsa.PrivateKey, measurement string) *tls.Config {
+	cert, key := quotetest.MustCreateCert(t, elliptic.P256(), "node", true, caCert, caKey)
+	rawKey, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	attestation := Attestation{Issuer: testIssuer{measurement}, Validator: testValidator{}, Package: quote.PackageProperties{UniqueID: "coordinator"}}
+	tlsConfig, err := NewTLSConfig(quotetest.ToPEM(cert), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rawKey}), quotetest.ToPEM(caCert), attestation)
+	require.NoError(t, err)
+	return tlsConfig
+}
+
+// testNode is a node of a test cluster that records the states it applies.
+//
+// A node can be isolated from its peers and restarted with its storage.
+type testNode struct {
+	*Node
+	addr      string
+	peers     []string
+	tlsConfig *tls.Config
+	storage   Storage
+	server    *httptest.Server
+
+	mux      sync.Mutex
+	isolated map[string]bool
+	state    []byte
+	index    uint64
+}
+
+func (n *testNode) apply(state []byte, index uint64) error {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.state, n.index = state, index
+	return nil
+}
+
+func (n *testNode) applied() ([]byte, uint64) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	return n.state, n.index
+}
+
+// isolate drops the requests between the node and its peers until heal is called.
+func (n *testNode) isolate(nodes []*testNode) {
+	for _, node := range nodes {
+		if node != n {
+			node.setIsolated(n.tlsConfig.Certificates[0].Certificate[0], true)
+			n.setIsolated(node.tlsConfig.Certificates[0].Certificate[0], true)
+		}
+	}
+}
+
+func (n *testNode) heal(nodes []*testNode) {
+	for _, node := range nodes {
+		node.setIsolated(n.tlsConfig.Certificates[0].Certificate[0], false)
+		n.setIsolated(node.tlsConfig.Certificates[0].Certificate[0], false)
+	}
+}
+
+func (n *testNode) setIsolated(peerCert []byte, isolated bool) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.isolated[string(peerCert)] = isolated
+}
+
+// start starts a new Node with the storage of the test node, as if the process had been restarted.
+func (n *testNode) start(t *testing.T) {
+	node, err := NewNode(Config{Addr: n.addr, Peers: n.peers, TLSConfig: n.tlsConfig}, n.storage, n.apply, zap.NewNop())
+	require.NoError(t, err)
+	handler := node.Handler()
+	listener, err := net.Listen("tcp", n.addr)
+	require.NoError(t, err)
+	n.server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n.mux.Lock()
+		isolated := r.TLS != nil && len(r.TLS.PeerCertificates) > 0 && n.isolated[string(r.TLS.PeerCertificates[0].Raw)]
+		n.mux.Unlock()
+		if isolated {
+			http.Error(w, "isolated", http.StatusServiceUnavailable)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	n.server.Listener.Close()
+	n.server.Listener = listener
+	n.server.TLS = n.tlsConfig
+	n.server.StartTLS()
+	n.Node = node
+	node.Start()
+}
+
+func (n *testNode) stop() {
+	n.Stop()
+	n.server.Close()
+}
+
+func setupCluster(t *testing.T, size int) []*testNode {
+	heartbeatInterval = 20 * time.Millisecond
+	electionTimeout = 200 * time.Millisecond
+	replicateTimeout = time.Second
+	requestTimeout = 500 * time.Millisecond
+
+	caCert, caKey := quotetest.MustCreateCert(t, elliptic.P256(), "cluster CA", true, nil, nil)
+	nodes := make([]*testNode, size)
+	for i := range nodes {
+		// reserve an address for the node
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		nodes[i] = &testNode{
+			addr:      listener.Addr().String(),
+			tlsConfig: newTestTLSConfig(t, caCert, caKey, "coordinator"),
+			storage:   NewSealedStorage(&core.MockSealer{}),
+			isolated:  make(map[string]bool),
+		}
+		listener.Close()
+	}
+
+	for i, node := range nodes {
+		for j, peer := range nodes {
+			if i != j {
+				node.peers = append(node.peers, peer.addr)
+			}
+		}
+		node.start(t)
+	}
+	return nodes
+}
+
+// waitForLeader returns the leader among the nodes once there is exactly one.
+func waitForLeader(t *testing.T, nodes []*testNode) *testNode {
+	var found *testNode
+	require.Eventually(t, func() bool {
+		found = nil
+		for _, node := range nodes {
+			if node.IsLeader() {
+				if found != nil {
+					return false
+				}
+				found = node
+			}
+		}
+		return found != nil
+	}, 10*time.Second, 10*time.Millisecond)
+	return found
+}
+
+func TestCluster(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	nodes := setupCluster(t, 3)
+	defer func() {
+		for _, node := range nodes {
+			node.stop()
+		}
+	}()
+	leader := waitForLeader(t, nodes)
+	var follower *testNode
+	for _, node := range nodes {
+		if node != leader {
+			follower = node
+			break
+		}
+	}
+	require.Eventually(func() bool { return follower.Leader() == leader.id }, 5*time.Second, 10*time.Millisecond)
+
+	// a follower forwards the state to the leader, which replicates it to all nodes
+	index, err := follower.Replicate([]byte("state1"), 0)
+	require.NoError(err)
+	assert.EqualValues(1, index)
+	for _, node := range nodes {
+		require.Eventually(func() bool {
+			state, applied := node.applied()
+			return applied == index && string(state) == "state1"
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+
+	// a state derived from an outdated state is rejected
+	_, err = leader.Replicate([]byte("stale"), 0)
+	assert.Equal(core.ErrStateConflict, err)
+	_, err = follower.Replicate([]byte("stale"), 0)
+	assert.Equal(core.ErrStateConflict, err)
+
+	index, err = leader.Replicate([]byte("state2"), index)
+	require.NoError(err)
+	assert.EqualValues(2, index)
+
+	// the remaining nodes elect a new leader, which has the latest state
+	leader.stop()
+	remaining := make([]*testNode, 0, 2)
+	for _, node := range nodes {
+		if node != leader {
+			remaining = append(remaining, node)
+		}
+	}
+	newLeader := waitForLeader(t, remaining)
+	require.Eventually(func() bool {
+		var state []byte
+		state, index = newLeader.applied()
+		return string(state) == "state2" && index > 2
+	}, 5*time.Second, 10*time.Millisecond, "the new leader commits the state of the previous term with an entry of its term")
+
+	index, err = newLeader.Replicate([]byte("state3"), index)
+	require.NoError(err)
+	for _, node := range remaining {
+		require.Eventually(func() bool {
+			state, applied := node.applied()
+			return applied == index && string(state) == "state3"
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+
+	// without a majority, no state can be committed
+	for _, node := range remaining {
+		if node != newLeader {
+			node.stop()
+		}
+	}
+	_, err = newLeader.Replicate([]byte("state4"), index)
+	assert.Error(err)
+}
+
+func TestPartition(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	nodes := setupCluster(t, 3)
+	defer func() {
+		for _, node := range nodes {
+			node.stop()
+		}
+	}()
+	oldLeader := waitForLeader(t, nodes)
+	index, err := oldLeader.Replicate([]byte("state1"), 0)
+	require.NoError(err)
+
+	// the isolated leader can't commit, while the majority elects a new leader in a newer term
+	oldLeader.isolate(nodes)
+	var majority []*testNode
+	for _, node := range nodes {
+		if node != oldLeader {
+			majority = append(majority, node)
+		}
+	}
+	newLeader := waitForLeader(t, majority)
+	_, err = oldLeader.Replicate([]byte("stale"), index)
+	assert.Error(err)
+	require.Eventually(func() bool {
+		// the new leader commits the last state again in its term, so states derived from it may conflict until then
+		_, index = newLeader.applied()
+		index, err = newLeader.Replicate([]byte("state2"), index)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// once the partition heals, the old leader steps down and its uncommitted entry is replaced
+	oldLeader.heal(nodes)
+	require.Eventually(func() bool {
+		state, applied := oldLeader.applied()
+		return !oldLeader.IsLeader() && applied == index && string(state) == "state2"
+	}, 5*time.Second, 10*time.Millisecond)
+	for _, node := range nodes {
+		state, _ := node.applied()
+		assert.NotEqual("stale", string(state))
+	}
+}
+
+func TestRestart(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	nodes := setupCluster(t, 3)
+	defer func() {
+		for _, node := range nodes {
+			node.stop()
+		}
+	}()
+	leader := waitForLeader(t, nodes)
+	index, err := leader.Replicate([]byte("state1"), 0)
+	require.NoError(err)
+	var follower *testNode
+	for _, node := range nodes {
+		if node != leader {
+			follower = node
+			break
+		}
+	}
+	require.Eventually(func() bool {
+		_, applied := follower.applied()
+		return applied == index
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the cluster commits without the crashed follower
+	follower.stop()
+	follower.Node.mux.Lock()
+	term, votedFor, entry := follower.term, follower.votedFor, follower.entry
+	follower.Node.mux.Unlock()
+	index, err = leader.Replicate([]byte("state2"), index)
+	require.NoError(err)
+
+	// the restarted follower continues with its persisted state and catches up
+	follower.start(t)
+	follower.Node.mux.Lock()
+	assert.LessOrEqual(term, follower.term)
+	if follower.term == term {
+		assert.Equal(votedFor, follower.votedFor)
+	}
+	assert.LessOrEqual(entry.Index, follower.entry.Index)
+	follower.Node.mux.Unlock()
+	require.Eventually(func() bool {
+		state, applied := follower.applied()
+		return applied == index && string(state) == "state2"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// after the leader has been restarted, the cluster elects a leader and commits again
+	leader.stop()
+	leader.start(t)
+	waitForLeader(t, nodes)
+	require.Eventually(func() bool {
+		// the new leader commits the last state again in its term, so states derived from it may conflict until then
+		_, index = follower.applied()
+		index, err = follower.Replicate([]byte("state3"), index)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	for _, node := range nodes {
+		require.Eventually(func() bool {
+			state, applied := node.applied()
+			return applied == index && string(state) == "state3"
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+}
+
+func TestTermConflicts(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	storage := NewSealedStorage(&core.MockSealer{})
+	apply := func([]byte, uint64) error { return nil }
+	node, err := NewNode(Config{Addr: "node", Peers: []string{"a", "b"}}, storage, apply, zap.NewNop())
+	require.NoError(err)
+
+	// the vote of a term is granted to a single candidate
+	assert.True(node.handleRequestVote(voteReq{Term: 2, CandidateID: "a"}).VoteGranted)
+	assert.False(node.handleRequestVote(voteReq{Term: 2, CandidateID: "b"}).VoteGranted)
+	assert.True(node.handleRequestVote(voteReq{Term: 2, CandidateID: "a"}).VoteGranted)
+
+	// the entry of the leader of the term is stored
+	resp := node.handleAppendEntries(appendReq{Term: 2, LeaderID: "a", Entry: Entry{Index: 1, Term: 2, Data: []byte("a")}})
+	assert.True(resp.Success)
+
+	// a leader of an older term is rejected and learns the current term
+	resp = node.handleAppendEntries(appendReq{Term: 1, LeaderID: "b", Entry: Entry{Index: 2, Term: 1, Data: []byte("stale")}, CommitIndex: 2})
+	assert.False(resp.Success)
+	assert.EqualValues(2, resp.Term)
+	assert.Equal(Entry{Index: 1, Term: 2, Data: []byte("a")}, node.entry)
+	assert.Zero(node.commitIndex)
+
+	// candidates whose entries are older don't get the vote, even in a newer term
+	vote := node.handleRequestVote(voteReq{Term: 3, CandidateID: "b", LastIndex: 5, LastTerm: 1})
+	assert.False(vote.VoteGranted)
+	assert.EqualValues(3, vote.Term)
+	assert.False(node.handleRequestVote(voteReq{Term: 3, CandidateID: "b"}).VoteGranted)
+
+	// the uncommitted entry is replaced by the conflicting entry of a newer leader
+	resp = node.handleAppendEntries(appendReq{Term: 4, LeaderID: "b", Entry: Entry{Index: 1, Term: 4, Data: []byte("b")}, CommitIndex: 1})
+	assert.True(resp.Success)
+	assert.Equal(Entry{Index: 1, Term: 4, Data: []byte("b")}, node.entry)
+	assert.EqualValues(1, node.commitIndex)
+
+	// the leader learns that the node doesn't have an entry whose data it omitted
+	resp = node.handleAppendEntries(appendReq{Term: 4, LeaderID: "b", Entry: Entry{Index: 2, Term: 4}, CommitIndex: 1})
+	assert.False(resp.Success)
+	assert.EqualValues(1, resp.Index)
+	assert.EqualValues(4, resp.EntryTerm)
+
+	// the term, the vote and the entry survive a restart
+	assert.True(node.handleRequestVote(voteReq{Term: 5, CandidateID: "b", LastIndex: 1, LastTerm: 4}).VoteGranted)
+	restarted, err := NewNode(Config{Addr: "node", Peers: []string{"a", "b"}}, storage, apply, zap.NewNop())
+	require.NoError(err)
+	assert.EqualValues(5, restarted.term)
+	assert.Equal("b", restarted.votedFor)
+	assert.Equal(Entry{Index: 1, Term: 4, Data: []byte("b")}, restarted.entry)
+	assert.False(restarted.handleRequestVote(voteReq{Term: 5, CandidateID: "a", LastIndex: 1, LastTerm: 4}).VoteGranted)
+}
+
+func TestAttestation(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	props, err := SelfPackageProperties(testIssuer{"coordinator"}, testValidator{}, quote.InfrastructureProperties{})
+	require.NoError(err)
+	assert.Equal("coordinator", props.UniqueID)
+	// the measurement can't be derived from validators that don't report it
+	_, err = SelfPackageProperties(testIssuer{"coordinator"}, struct{ quote.Validator }{testValidator{}}, quote.InfrastructureProperties{})
+	assert.Error(err)
+	_, err = SelfPackageProperties(testIssuer{"coordinator"}, quote.NewFailValidator(), quote.InfrastructureProperties{})
+	assert.Error(err)
+
+	caCert, caKey := quotetest.MustCreateCert(t, elliptic.P256(), "cluster CA", true, nil, nil)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeResponse(w, voteResp{Term: 1})
+	}))
+	server.TLS = newTestTLSConfig(t, caCert, caKey, "coordinator")
+	server.StartTLS()
+	defer server.Close()
+	addr := server.Listener.Addr().String()
+
+	// a peer with the same measurement is accepted
+	resp, err := newTransport(newTestTLSConfig(t, caCert, caKey, "coordinator")).requestVote(addr, voteReq{})
+	require.NoError(err)
+	assert.EqualValues(1, resp.Term)
+
+	// a peer with another measurement is refused
+	_, err = newTransport(newTestTLSConfig(t, caCert, caKey, "other")).requestVote(addr, voteReq{})
+	assert.Error(err)
+
+	// a peer that isn't a member of the cluster is refused
+	otherCACert, otherCAKey := quotetest.MustCreateCert(t, elliptic.P256(), "other CA", true, nil, nil)
+	_, err = newTransport(newTestTLSConfig(t, otherCACert, otherCAKey, "coordinator")).requestVote(addr, voteReq{})
+	assert.Error(err)
+
+	// a peer that only presents its cluster certificate is refused
+	cert, key := quotetest.MustCreateCert(t, elliptic.P256(), "node", true, caCert, caKey)
+	_, err = newTransport(&tls.Config{
+		Certificates:       []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}},
+		InsecureSkipVerify: true,
+	}).requestVote(addr, voteReq{})
+	assert.Error(err)
+}
+
+func TestSealedStorage(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	sealer := &core.MockSealer{}
+	storage := NewSealedStorage(sealer)
+	state, err := storage.Load()
+	require.NoError(err)
+	assert.Equal(HardState{}, state)
+
+	expected := HardState{Term: 2, VotedFor: "node", Entry: Entry{Index: 3, Term: 2, Data: []byte("state")}}
+	require.NoError(storage.Save(expected))
+	state, err = storage.Load()
+	require.NoError(err)
+	assert.Equal(expected, state)
+}
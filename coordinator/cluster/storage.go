@@ -0,0 +1,74 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package cluster
+
+import (
+	"encoding/json"
+
+	"github.com/edgelesssys/marblerun/coordinator/core"
+)
+
+// HardState is the state of a node that Raft requires to be persisted before the node responds to its peers.
+type HardState struct {
+	Term     uint64
+	VotedFor string
+	Entry    Entry
+}
+
+// Storage persists the HardState of a node.
+type Storage interface {
+	Load() (HardState, error)
+	Save(state HardState) error
+}
+
+// sealedStorage persists the HardState with a core.Sealer.
+//
+// The entry contains the state of the Coordinator, so its data is sealed. The remaining fields are stored in plaintext.
+type sealedStorage struct {
+	sealer core.Sealer
+}
+
+// NewSealedStorage creates a Storage that persists the HardState with sealer. It must not share its directory with the sealer of the Core.
+func NewSealedStorage(sealer core.Sealer) Storage {
+	return sealedStorage{sealer: sealer}
+}
+
+// Load implements the Storage interface
+//
+// If the data can't be unsealed, e.g., because the node runs on another CPU, the node starts with an empty log and receives the state from the leader.
+func (s sealedStorage) Load() (HardState, error) {
+	rawMeta, data, err := s.sealer.Unseal()
+	if err != nil && err != core.ErrEncryptionKey {
+		return HardState{}, err
+	}
+	var state HardState
+	if len(rawMeta) > 0 {
+		if err := json.Unmarshal(rawMeta, &state); err != nil {
+			return HardState{}, err
+		}
+	}
+	if err == core.ErrEncryptionKey {
+		state.Entry = Entry{}
+		if err := s.sealer.GenerateNewEncryptionKey(); err != nil {
+			return HardState{}, err
+		}
+	}
+	state.Entry.Data = data
+	return state, nil
+}
+
+// Save implements the Storage interface
+func (s sealedStorage) Save(state HardState) error {
+	data := state.Entry.Data
+	state.Entry.Data = nil
+	rawMeta, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	_, err = s.sealer.Seal(rawMeta, data)
+	return err
+}
@@ -0,0 +1,207 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package cluster
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/core"
+)
+
+// requestTimeout is the timeout of requests to peers. Proposals may take longer, as they wait for the replication.
+var requestTimeout = time.Second
+
+type voteReq struct {
+	Term        uint64
+	CandidateID string
+	LastIndex   uint64
+	LastTerm    uint64
+}
+
+type voteResp struct {
+	Term        uint64
+	VoteGranted bool
+}
+
+type appendReq struct {
+	Term     uint64
+	LeaderID string
+	// Entry is the leader's last entry. Its Data is omitted if the leader believes the follower already has it.
+	Entry       Entry
+	CommitIndex uint64
+}
+
+type appendResp struct {
+	Term    uint64
+	Success bool
+	// Index and EntryTerm identify the entry the follower has stored
+	Index     uint64
+	EntryTerm uint64
+}
+
+type proposeReq struct {
+	Data      []byte
+	BaseIndex uint64
+}
+
+type proposeResp struct {
+	Index uint64
+}
+
+// NewTLSConfig creates the TLS configuration with which the nodes of a cluster authenticate and attest each other.
+//
+// Each node presents an RA-TLS certificate, which it issues with its cluster certificate, so the cluster certificate must be allowed to issue certificates.
+// A node only accepts peers whose certificates have been issued by the cluster CA and whose quotes are valid according to attestation.
+// Nodes are identified by their addresses, so the host names of the certificates are not checked.
+// The cluster certificates control which Coordinators may form a cluster, but the replicated state is only protected by the attestation of the peers.
+func NewTLSConfig(certPEM, keyPEM, caPEM []byte, attestation Attestation) (*tls.Config, error) {
+	clusterCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cluster certificate: %v", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("invalid cluster CA")
+	}
+	cert, err := newAttestedCertificate(clusterCert, attestation.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    roots,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		// the server certificate is verified against the cluster CA below, without a host name
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyPeer(rawCerts, roots, attestation)
+		},
+		MinVersion: tls.VersionTLS12,
+	}, nil
+}
+
+// Handler returns the handler that serves the requests of the node's peers.
+func (n *Node) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cluster/vote", func(w http.ResponseWriter, r *http.Request) {
+		var req voteReq
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		writeResponse(w, n.handleRequestVote(req))
+	})
+	mux.HandleFunc("/cluster/append", func(w http.ResponseWriter, r *http.Request) {
+		var req appendReq
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		writeResponse(w, n.handleAppendEntries(req))
+	})
+	mux.HandleFunc("/cluster/propose", func(w http.ResponseWriter, r *http.Request) {
+		var req proposeReq
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		index, err := n.propose(req.Data, req.BaseIndex)
+		if err == core.ErrStateConflict {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		writeResponse(w, proposeResp{index})
+	})
+	return mux
+}
+
+func decodeRequest(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	// the peer has been attested during the TLS handshake, see NewTLSConfig
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		http.Error(w, "peer is not attested", http.StatusUnauthorized)
+		return false
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeResponse(w http.ResponseWriter, resp interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// transport sends the requests of a node to its peers.
+type transport struct {
+	client        *http.Client
+	proposeClient *http.Client
+}
+
+func newTransport(tlsConfig *tls.Config) *transport {
+	return &transport{
+		client:        &http.Client{Timeout: requestTimeout, Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+		proposeClient: &http.Client{Timeout: replicateTimeout + requestTimeout, Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+	}
+}
+
+func (t *transport) requestVote(peer string, req voteReq) (voteResp, error) {
+	var resp voteResp
+	err := t.post(t.client, peer, "/cluster/vote", req, &resp)
+	return resp, err
+}
+
+func (t *transport) appendEntries(peer string, req appendReq) (appendResp, error) {
+	var resp appendResp
+	err := t.post(t.client, peer, "/cluster/append", req, &resp)
+	return resp, err
+}
+
+func (t *transport) propose(leader string, req proposeReq) (uint64, error) {
+	var resp proposeResp
+	if err := t.post(t.proposeClient, leader, "/cluster/propose", req, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Index, nil
+}
+
+func (t *transport) post(client *http.Client, peer string, route string, req interface{}, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpResp, err := client.Post("https://"+peer+route, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	respBody, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return err
+	}
+	switch httpResp.StatusCode {
+	case http.StatusOK:
+		return json.Unmarshal(respBody, resp)
+	case http.StatusConflict:
+		return core.ErrStateConflict
+	}
+	return fmt.Errorf("%v: %s", httpResp.Status, bytes.TrimSpace(respBody))
+}
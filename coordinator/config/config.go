@@ -7,20 +7,236 @@
 // Package config defines the environment variables expected by the Coordinator for configuration settings.
 package config
 
-// MeshAddr is the coordinator's address for the gRPC server to listen on
+// MeshAddr is the coordinator's address for the gRPC server to listen on: a TCP address, a Unix socket like unix:///run/marblerun.sock
+// or a vsock address like vsock://<CID>:<port>. It is required unless the gRPC server is disabled
 const MeshAddr = "EDG_COORDINATOR_MESH_ADDR"
 
-// ClientAddr is the coordinator's address for the HTTP-REST server to listen on
+// MeshDisabled disables the gRPC server for the marbles if set to "1", e.g., for a coordinator that only serves the client API
+const MeshDisabled = "EDG_COORDINATOR_MESH_DISABLED"
+
+// ClientAddr is the coordinator's address for the HTTP-REST server to listen on, e.g., on an internal admin network.
+// It is required unless the HTTP-REST server is disabled
 const ClientAddr = "EDG_COORDINATOR_CLIENT_ADDR"
 
+// ClientDisabled disables the HTTP-REST server for the clients if set to "1"
+const ClientDisabled = "EDG_COORDINATOR_CLIENT_DISABLED"
+
 // PromAddr is the coordinator's address for the prometheus endpoint server to listen on
 const PromAddr = "EDG_COORDINATOR_PROMETHEUS_ADDR"
 
-// DNSNames are the alternative dns names for the coordinator's certificate
+// DebugAddr is the loopback address the coordinator serves the pprof profiles and expvar variables on, e.g., localhost:6060. The debug endpoints are disabled if it is not set
+const DebugAddr = "EDG_COORDINATOR_DEBUG_ADDR"
+
+// OTLPAddr is the address of the OpenTelemetry collector the coordinator exports traces to. Tracing is disabled if it is not set
+const OTLPAddr = "EDG_COORDINATOR_OTLP_ADDR"
+
+// OTLPInsecure disables TLS for the connection to the OpenTelemetry collector if set to "1"
+const OTLPInsecure = "EDG_COORDINATOR_OTLP_INSECURE"
+
+// AuditAddr is the endpoint the coordinator streams its audit log to, e.g., udp://siem:514 or tcp://siem:601. The export is disabled if it is not set
+const AuditAddr = "EDG_COORDINATOR_AUDIT_ADDR"
+
+// AuditFormat is the format of the exported audit entries: syslog (default), cef or json
+const AuditFormat = "EDG_COORDINATOR_AUDIT_FORMAT"
+
+// Webhooks configures the webhooks the coordinator notifies of repeated activation failures, manifest changes and recovery mode,
+// as JSON list of objects with the fields URL, AuthHeader and Events
+const Webhooks = "EDG_COORDINATOR_WEBHOOKS"
+
+// ManifestSigners restricts the manifests the coordinator accepts to those signed by one of the signers,
+// as JSON object that maps the name of each signer to its PEM-encoded public key
+const ManifestSigners = "EDG_COORDINATOR_MANIFEST_SIGNERS"
+
+// ClusterAddr is the address at which the coordinator is reachable by the other coordinators of its cluster. Replication is disabled if it is not set
+const ClusterAddr = "EDG_COORDINATOR_CLUSTER_ADDR"
+
+// ClusterPeers are the comma-separated cluster addresses of the other coordinators of the cluster
+const ClusterPeers = "EDG_COORDINATOR_CLUSTER_PEERS"
+
+// ClusterCert is the path to the PEM-encoded CA certificate with which the coordinator issues its RA-TLS certificate for its cluster
+const ClusterCert = "EDG_COORDINATOR_CLUSTER_CERT"
+
+// ClusterKey is the path to the PEM-encoded private key of the cluster certificate
+const ClusterKey = "EDG_COORDINATOR_CLUSTER_KEY"
+
+// ClusterCA is the path to the PEM-encoded CA certificate that issued the cluster certificates of all coordinators
+const ClusterCA = "EDG_COORDINATOR_CLUSTER_CA"
+
+// HandoffAddr is the client API address of a running coordinator whose state this coordinator takes over on startup, e.g., to upgrade the coordinator.
+// The handoff is only attempted if the coordinator has neither a manifest nor a state it can unseal
+const HandoffAddr = "EDG_COORDINATOR_HANDOFF_ADDR"
+
+// HandoffCert is the path to the PEM-encoded certificate of a User of the manifest who is permitted to hand off the state
+const HandoffCert = "EDG_COORDINATOR_HANDOFF_CERT"
+
+// HandoffKey is the path to the PEM-encoded private key of the HandoffCert
+const HandoffKey = "EDG_COORDINATOR_HANDOFF_KEY"
+
+// ActivationRate is the number of activation requests per second that the coordinator accepts from a client IP. Unlimited if it is not set
+const ActivationRate = "EDG_COORDINATOR_ACTIVATION_RATE"
+
+// ActivationTypeRate is the number of activation requests per second that the coordinator accepts for a marble type. Unlimited if it is not set
+const ActivationTypeRate = "EDG_COORDINATOR_ACTIVATION_TYPE_RATE"
+
+// ActivationBurst is the number of activation requests that are accepted at once before the activation rates apply, 1 by default
+const ActivationBurst = "EDG_COORDINATOR_ACTIVATION_BURST"
+
+// QuoteVerifications is the maximum number of marble quotes that the coordinator verifies concurrently, the number of CPUs by default
+const QuoteVerifications = "EDG_COORDINATOR_QUOTE_VERIFICATIONS"
+
+// TLSMinVersion is the minimum TLS version that the coordinator's servers accept: 1.2 (default) or 1.3
+const TLSMinVersion = "EDG_COORDINATOR_TLS_MIN_VERSION"
+
+// TLSCipherSuites are the comma-separated TLS 1.2 cipher suites that the coordinator's servers accept, e.g., TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256.
+// Go's default cipher suites are accepted if it is not set
+const TLSCipherSuites = "EDG_COORDINATOR_TLS_CIPHER_SUITES"
+
+// MeshTLSMinVersion is the minimum TLS version that the gRPC server accepts. It overrides TLSMinVersion if it is set
+const MeshTLSMinVersion = "EDG_COORDINATOR_MESH_TLS_MIN_VERSION"
+
+// MeshTLSCipherSuites are the TLS 1.2 cipher suites that the gRPC server accepts. It overrides TLSCipherSuites if it is set
+const MeshTLSCipherSuites = "EDG_COORDINATOR_MESH_TLS_CIPHER_SUITES"
+
+// ClientTLSMinVersion is the minimum TLS version that the HTTP-REST server accepts. It overrides TLSMinVersion if it is set
+const ClientTLSMinVersion = "EDG_COORDINATOR_CLIENT_TLS_MIN_VERSION"
+
+// ClientTLSCipherSuites are the TLS 1.2 cipher suites that the HTTP-REST server accepts. It overrides TLSCipherSuites if it is set
+const ClientTLSCipherSuites = "EDG_COORDINATOR_CLIENT_TLS_CIPHER_SUITES"
+
+// MaxConnections is the maximum number of open connections of each of the coordinator's servers. Unlimited if it is not set
+const MaxConnections = "EDG_COORDINATOR_MAX_CONNECTIONS"
+
+// ProxyProtocol requires the connections of the coordinator's servers to start with a PROXY protocol header if set to "1",
+// so that the client addresses are preserved behind load balancers
+const ProxyProtocol = "EDG_COORDINATOR_PROXY_PROTOCOL"
+
+// GRPCMaxMessageSize is the maximum size in bytes of a message that the gRPC server receives, 4 MiB by default
+const GRPCMaxMessageSize = "EDG_COORDINATOR_GRPC_MAX_MESSAGE_SIZE"
+
+// GRPCKeepaliveTime is the duration after which the gRPC server pings an idle connection, e.g., 5m. 2h by default
+const GRPCKeepaliveTime = "EDG_COORDINATOR_GRPC_KEEPALIVE_TIME"
+
+// GRPCKeepaliveTimeout is the duration after which the gRPC server closes a connection whose ping has not been answered, 20s by default
+const GRPCKeepaliveTimeout = "EDG_COORDINATOR_GRPC_KEEPALIVE_TIMEOUT"
+
+// ClientMaxBodySize is the maximum size in bytes of a request body that the HTTP-REST server reads. Unlimited if it is not set
+const ClientMaxBodySize = "EDG_COORDINATOR_CLIENT_MAX_BODY_SIZE"
+
+// ClientIdleTimeout is the duration after which the HTTP-REST server closes an idle keep-alive connection, e.g., 2m. No timeout if it is not set
+const ClientIdleTimeout = "EDG_COORDINATOR_CLIENT_IDLE_TIMEOUT"
+
+// ClientCORSOrigins are the comma-separated origins of web applications that may call the HTTP-REST server from a browser,
+// e.g., https://dashboard.example.com, or "*" for all origins. Cross-origin requests are not allowed if it is not set
+const ClientCORSOrigins = "EDG_COORDINATOR_CLIENT_CORS_ORIGINS"
+
+// DNSNames are the comma-separated alternative dns names and IP addresses for the coordinator's certificate
 const DNSNames = "EDG_COORDINATOR_DNS_NAMES"
 
-// SealDir is the coordinator's file location to store the sealed state
+// DNSNamesFile is the path to a file with further comma- or newline-separated dns names and IP addresses for the coordinator's certificate.
+// The file is reread periodically and the certificate is issued anew when the names change
+const DNSNamesFile = "EDG_COORDINATOR_DNS_NAMES_FILE"
+
+// SealDir is the coordinator's file location to store the sealed state if Store is file
 const SealDir = "EDG_COORDINATOR_SEAL_DIR"
 
+// Store selects the backend of the sealed state: file (default) stores it in SealDir, etcd in an etcd cluster and kubernetes in a Kubernetes secret
+const Store = "EDG_COORDINATOR_STORE"
+
+// EtcdEndpoint is the URL of the etcd server that stores the sealed state, e.g., https://etcd:2379
+const EtcdEndpoint = "EDG_COORDINATOR_ETCD_ENDPOINT"
+
+// EtcdPrefix is the prefix of the etcd keys of the sealed state, "marblerun/" by default
+const EtcdPrefix = "EDG_COORDINATOR_ETCD_PREFIX"
+
+// EtcdCert is the path to the PEM-encoded client certificate for etcd, if the server requires one
+const EtcdCert = "EDG_COORDINATOR_ETCD_CERT"
+
+// EtcdKey is the path to the PEM-encoded private key of the etcd client certificate
+const EtcdKey = "EDG_COORDINATOR_ETCD_KEY"
+
+// EtcdCA is the path to the PEM-encoded CA certificate of the etcd server. The system roots are used if it is not set
+const EtcdCA = "EDG_COORDINATOR_ETCD_CA"
+
+// RollbackCounter enables the protection of the sealed state against rollback if set to "etcd". The counter is stored
+// under the key "counter" in the etcd server configured with EtcdEndpoint
+const RollbackCounter = "EDG_COORDINATOR_ROLLBACK_COUNTER"
+
+// KubernetesSecret is the name of the Kubernetes secret that stores the sealed state, "marblerun-coordinator-state" by default
+const KubernetesSecret = "EDG_COORDINATOR_KUBERNETES_SECRET"
+
+// KubernetesCANamespaces are the namespaces into which the Coordinator publishes its CA certificates once the manifest has been set, e.g.,
+// "ingress-nginx,emojivoto=web+emoji" publishes the intermediate CAs of all packages into ingress-nginx and those of the packages web and emoji into emojivoto
+const KubernetesCANamespaces = "EDG_COORDINATOR_KUBERNETES_CA_NAMESPACES"
+
+// KubernetesCAName is the name of the Secret and the ConfigMap that contain the published CA certificates, "marblerun-ca" by default
+const KubernetesCAName = "EDG_COORDINATOR_KUBERNETES_CA_NAME"
+
+// KMS selects the key management service that wraps the state encryption key: aws, azure or gcp. The key is not wrapped if it is not set
+const KMS = "EDG_COORDINATOR_KMS"
+
+// KMSKey identifies the key of the KMS: the key ID, ARN or alias for aws, the key URL for azure and the key resource name for gcp
+const KMSKey = "EDG_COORDINATOR_KMS_KEY"
+
+// KMSRegion is the region of the AWS KMS key
+const KMSRegion = "EDG_COORDINATOR_KMS_REGION"
+
+// KMSOnly protects the state encryption key only with the KMS instead of sealing it in addition if set to "1"
+const KMSOnly = "EDG_COORDINATOR_KMS_ONLY"
+
+// SecretsBackend selects the backend from which the secrets of the manifest with a Backend are fetched: vault. Such secrets can't be used if it is not set
+const SecretsBackend = "EDG_COORDINATOR_SECRETS_BACKEND"
+
+// SecretsCacheTTL is the number of seconds for which the values from the secrets backend are cached in memory, 300 by default. 0 disables the cache
+const SecretsCacheTTL = "EDG_COORDINATOR_SECRETS_CACHE_TTL"
+
+// VaultAddr is the address of the HashiCorp Vault server, e.g., https://vault.example.com:8200
+const VaultAddr = "EDG_COORDINATOR_VAULT_ADDR"
+
+// VaultCACert is the path to the PEM-encoded CA certificate of the Vault server's certificate. The system roots are used if it is not set
+const VaultCACert = "EDG_COORDINATOR_VAULT_CA_CERT"
+
+// VaultAuthMount is the path at which the TLS certificate auth method is mounted in Vault, "cert" by default
+const VaultAuthMount = "EDG_COORDINATOR_VAULT_AUTH_MOUNT"
+
+// VaultRole is the name of the certificate role in Vault the Coordinator logs in with. Vault tries all roles if it is not set
+const VaultRole = "EDG_COORDINATOR_VAULT_ROLE"
+
+// CRLURL is the URL of the Coordinator's CRL endpoint that is embedded in marble certificates, e.g., https://coordinator.example.com:4433/api/v1/crl
+const CRLURL = "EDG_COORDINATOR_CRL_URL"
+
+// PKCS11Module is the path to the PKCS#11 module of the HSM that holds the root key. The root key is sealed with the state if it is not set
+const PKCS11Module = "EDG_COORDINATOR_PKCS11_MODULE"
+
+// PKCS11Token is the label of the HSM token that holds the root key
+const PKCS11Token = "EDG_COORDINATOR_PKCS11_TOKEN"
+
+// PKCS11Pin is the user PIN of the HSM token
+const PKCS11Pin = "EDG_COORDINATOR_PKCS11_PIN"
+
+// PKCS11Proxy is the address of the pkcs11-proxy on the host, through which the enclave Coordinator uses the HSM. The proxy listens at this address
+const PKCS11Proxy = "EDG_COORDINATOR_PKCS11_PROXY"
+
+// TCBInfoDir is the directory with Intel's TCB info that the DCAP validator determines the TCB status of platforms with.
+// It contains the TCB info of each FMSPC in <fmspc>.json and the issuer chain in tcb-info-issuer-chain.pem
+const TCBInfoDir = "EDG_COORDINATOR_TCB_INFO_DIR"
+
+// PCCSURL is the base URL of the Intel PCS or a PCCS, e.g., https://api.trustedservices.intel.com, from which the DCAP validator
+// fetches the TCB info and QE identity. The collateral is cached in the Coordinator's store. It replaces TCBInfoDir
+const PCCSURL = "EDG_COORDINATOR_PCCS_URL"
+
+// TCBRefreshInterval is the interval in which the collateral is fetched anew from the PCCS, e.g., 12h. Defaults to 24h
+const TCBRefreshInterval = "EDG_COORDINATOR_TCB_REFRESH_INTERVAL"
+
+// TCBGracePeriod is how long the collateral of the previous TCB evaluation stays in effect after a TCB recovery, e.g., 720h.
+// Newer collateral takes effect immediately if it is not set
+const TCBGracePeriod = "EDG_COORDINATOR_TCB_GRACE_PERIOD"
+
 // DevMode enables more verbose logging
 const DevMode = "EDG_COORDINATOR_DEV_MODE"
+
+// LogLevel is the minimum level of log messages: debug, info, warn or error
+const LogLevel = "EDG_COORDINATOR_LOG_LEVEL"
+
+// Simulation explicitly disables the generation and validation of quotes if set to "1". Never use this in production.
+// It is only honoured by Coordinators that are not built as an enclave.
+const Simulation = "EDG_COORDINATOR_SIMULATION"
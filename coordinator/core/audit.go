@@ -0,0 +1,46 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"context"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+)
+
+// AuditSink receives the entries of the audit log once they have been sealed, e.g., to stream them into a SIEM
+//
+// Send is called while the Core is locked and must not block.
+type AuditSink interface {
+	Send(entry clientapi.AuditEntry)
+}
+
+// AddAuditSink registers a sink that receives all audit entries that are sealed from now on.
+func (c *Core) AddAuditSink(sink AuditSink) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.auditSinks = append(c.auditSinks, sink)
+}
+
+// appendAuditEntry records an event in the audit log. The entry is persisted with the next sealing of the state.
+//
+// user is the name of the manifest's User who caused the event, or empty. The IP address of the client is taken from ctx.
+func (c *Core) appendAuditEntry(ctx context.Context, event string, user string, details map[string]string) {
+	entry := clientapi.AuditEntry{Time: time.Now().UTC(), Event: event, User: user, ClientIP: clientIP(ctx), Details: details}
+	c.auditLog = clientapi.AppendAuditEntry(c.auditLog, entry)
+}
+
+// exportAuditEntries sends the entries that have been sealed since the last export to the audit sinks.
+func (c *Core) exportAuditEntries() {
+	for _, entry := range c.auditLog[c.exportedAuditEntries:] {
+		for _, sink := range c.auditSinks {
+			sink.Send(entry)
+		}
+	}
+	c.exportedAuditEntries = len(c.auditLog)
+}
@@ -0,0 +1,242 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/coordinator/recovery"
+	"go.uber.org/zap"
+)
+
+// backupKeySize is the size of the AES-256 key a backup is encrypted with.
+const backupKeySize = 32
+
+// ExportBackup returns an encrypted snapshot of the state, which can be restored into a fresh Coordinator with RestoreBackup.
+//
+// clientCert is the TLS certificate of the client, which must belong to one of the manifest's Users who is permitted to back up the state.
+// The backup key is protected by the manifest's RecoveryKeys like the state encryption key, so the manifest must define some.
+// The backup records the package properties of this Coordinator, so that it can only be restored by a Coordinator that could take over
+// the state in a handoff. The export is recorded in the audit log, which is part of the backup.
+func (c *Core) ExportBackup(ctx context.Context, clientCert *x509.Certificate) (clientapi.Backup, error) {
+	defer c.mux.Unlock()
+	if err := c.requireState(stateAcceptingMarbles); err != nil {
+		return clientapi.Backup{}, err
+	}
+	user, ok := c.manifest.getUser(clientCert)
+	if !ok || !c.manifest.isPermitted(user, resourceRecovery, "", actionBackup) {
+		return clientapi.Backup{}, ErrNotAuthorized
+	}
+	recoveryKeys, err := parseRecoveryKeys(c.manifest.recoveryKeys())
+	if err != nil {
+		return clientapi.Backup{}, err
+	}
+	if len(recoveryKeys) == 0 {
+		return clientapi.Backup{}, errors.New("the manifest defines no RecoveryKeys to protect the backup")
+	}
+	var pkg *quote.PackageProperties
+	if !c.inSimulationMode() {
+		props, err := c.ownPackageProperties()
+		if err != nil {
+			return clientapi.Backup{}, err
+		}
+		pkg = &props
+	}
+
+	oldAuditLog := c.auditLog
+	c.appendAuditEntry(ctx, clientapi.AuditEventExportBackup, user, nil)
+	if _, err := c.sealState(); err != nil {
+		c.auditLog = oldAuditLog
+		c.zaplogger.Error("sealState failed", zap.Error(err))
+		return clientapi.Backup{}, err
+	}
+	stateRaw, err := c.marshalState()
+	if err != nil {
+		return clientapi.Backup{}, err
+	}
+
+	key := make([]byte, backupKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return clientapi.Backup{}, err
+	}
+	encryptionKeys, err := generateRecoveryData(recoveryKeys, c.manifest.RecoveryThreshold, key)
+	if err != nil {
+		return clientapi.Backup{}, err
+	}
+	backup := clientapi.Backup{
+		Version:           clientapi.BackupVersion,
+		Created:           time.Now().UTC(),
+		ManifestSignature: clientapi.ManifestSignature(c.rawManifest, c.rawUpdates),
+		Package:           pkg,
+		EncryptionKeys:    encryptionKeys,
+	}
+	if c.manifest.RecoveryThreshold > 1 {
+		backup.Threshold = int(c.manifest.RecoveryThreshold)
+	}
+	additionalData, err := backup.AdditionalData()
+	if err != nil {
+		return clientapi.Backup{}, err
+	}
+	if backup.State, err = encryptBackupState(key, stateRaw, additionalData); err != nil {
+		return clientapi.Backup{}, err
+	}
+
+	c.requestLogger(ctx).Info("backup exported", zap.String("user", user))
+	return backup, nil
+}
+
+// RestoreBackup restores a backup that has been exported with ExportBackup into a Coordinator that has neither a manifest nor a recovered state.
+//
+// secret is the backup key decrypted with a RecoveryKey. If the manifest requires multiple recovery key holders, each of them uploads the backup
+// with their share of the key. Returns the number of shares that are still missing, and, once the backup has been restored, the new state
+// encryption key encrypted with each of the manifest's RecoveryKeys.
+//
+// The restored state is authorized like a recovery: if the manifest permits any of its Users to recover, clientCert must belong to such a user.
+// The quote of this Coordinator must satisfy the package properties of the Coordinator that exported the backup.
+// The restored Coordinator continues with the root certificate, the secrets and the audit log of the backup. Backups of a newer version than
+// clientapi.BackupVersion are rejected, so that an older release can't misinterpret the state.
+func (c *Core) RestoreBackup(ctx context.Context, backup clientapi.Backup, secret []byte, clientCert *x509.Certificate) (int, map[string][]byte, error) {
+	defer c.mux.Unlock()
+	if err := c.requireState(stateAcceptingManifest, stateRecovery); err != nil {
+		return -1, nil, err
+	}
+	if backup.Version < 1 || backup.Version > clientapi.BackupVersion {
+		return -1, nil, fmt.Errorf("unsupported backup version %v, this Coordinator restores backups up to version %v", backup.Version, clientapi.BackupVersion)
+	}
+	additionalData, err := backup.AdditionalData()
+	if err != nil {
+		return -1, nil, err
+	}
+
+	key := secret
+	if recovery.IsShare(secret) {
+		// the shares are collected per backup, so that shares of different backups aren't combined
+		backupHash := sha256.Sum256(append(additionalData, backup.State...))
+		if c.restoreShares == nil || c.restoreBackup != backupHash {
+			c.restoreShares = recovery.NewCollector(backup.Threshold, len(backup.EncryptionKeys))
+			c.restoreBackup = backupHash
+		}
+		remaining, restoredKey, err := c.restoreShares.Add(secret)
+		if err != nil {
+			return -1, nil, err
+		}
+		if remaining > 0 {
+			c.zaplogger.Info("received backup key share", zap.Int("remaining", remaining))
+			return remaining, nil, nil
+		}
+		key = restoredKey
+	}
+	// start over with a fresh set of shares if the restore fails
+	c.restoreShares = nil
+
+	// the key, the Threshold and the metadata of the backup are authenticated by the decryption
+	stateRaw, err := decryptBackupState(key, backup.State, additionalData)
+	if err != nil {
+		return -1, nil, errors.New("cannot decrypt the backup: the backup key is wrong or the backup has been modified")
+	}
+	if err := c.validateBackupPackage(backup.Package); err != nil {
+		return -1, nil, err
+	}
+
+	var user string
+	var recoveryKeys map[string]*rsa.PublicKey
+	encryptionKey, err := c.adoptState(stateRaw, nil, func() error {
+		if c.manifest.hasPermittedUser(resourceRecovery, "", actionRecover) {
+			var ok bool
+			user, ok = c.manifest.getUser(clientCert)
+			if !ok || !c.manifest.isPermitted(user, resourceRecovery, "", actionRecover) {
+				return ErrNotAuthorized
+			}
+		}
+		var err error
+		if recoveryKeys, err = parseRecoveryKeys(c.manifest.recoveryKeys()); err != nil {
+			return err
+		}
+		c.appendAuditEntry(ctx, clientapi.AuditEventRestoreBackup, user, map[string]string{
+			"Created":           backup.Created.Format(time.RFC3339),
+			"ManifestSignature": hex.EncodeToString(backup.ManifestSignature),
+		})
+		return nil
+	})
+	if err != nil {
+		return -1, nil, err
+	}
+	recoveryData, err := generateRecoveryData(recoveryKeys, c.manifest.RecoveryThreshold, encryptionKey)
+	if err != nil {
+		// the backup has been restored and sealed, the recovery data of the state can be obtained by recovering it
+		c.zaplogger.Error("Creation of recovery data failed.", zap.Error(err))
+		return -1, nil, err
+	}
+
+	c.requestLogger(ctx).Info("backup restored", zap.String("user", user), zap.Time("Created", backup.Created))
+	return 0, recoveryData, nil
+}
+
+// validateBackupPackage validates that the Coordinator's own quote satisfies the package properties of the Coordinator that exported a backup,
+// like the quote of a new Coordinator in a handoff.
+func (c *Core) validateBackupPackage(required *quote.PackageProperties) error {
+	if required == nil {
+		// the state of the backup hasn't been protected by an enclave
+		return nil
+	}
+	if c.inSimulationMode() {
+		return errors.New("the backup has been exported by a Coordinator in an enclave and can't be restored in simulation mode")
+	}
+	if _, err := quote.ValidateReport(c.qv, c.quote, c.cert.Raw, *required, quote.InfrastructureProperties{}); err != nil {
+		return fmt.Errorf("this Coordinator doesn't run the package the backup has been exported from: %v", err)
+	}
+	return nil
+}
+
+// encryptBackupState encrypts the state with AES-GCM and authenticates the additional data with it. The nonce is prepended to the ciphertext.
+func encryptBackupState(key, stateRaw, additionalData []byte) ([]byte, error) {
+	gcm, err := newBackupCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, stateRaw, additionalData), nil
+}
+
+// decryptBackupState decrypts a state that has been encrypted by encryptBackupState.
+func decryptBackupState(key, ciphertext, additionalData []byte) ([]byte, error) {
+	gcm, err := newBackupCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("backup state is too short")
+	}
+	nonce, ciphertext := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newBackupCipher(key []byte) (cipher.AEAD, error) {
+	if len(key) != backupKeySize {
+		return nil, fmt.Errorf("backup key must be %v bytes", backupKeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
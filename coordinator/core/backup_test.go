@@ -0,0 +1,186 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"testing"
+
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackup(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, manifest := mustSetup()
+	manifest.RecoveryKeys = map[string]string{"first": string(test.RecoveryPublicKey)}
+	manifest.Users = map[string]User{
+		"admin":  {Certificate: test.CertPEM(test.AdminCert), Roles: []string{"backup"}},
+		"admin2": {Certificate: test.CertPEM(test.SecondAdminCert)},
+	}
+	manifest.Roles = map[string]Role{"backup": {ResourceType: "Recovery", Actions: []string{"Backup"}}}
+	rawManifest, err := json.Marshal(manifest)
+	require.NoError(err)
+	_, err = c.SetManifest(context.TODO(), rawManifest, nil)
+	require.NoError(err)
+
+	// only permitted users can export backups
+	_, err = c.ExportBackup(context.TODO(), test.SecondAdminCert)
+	assert.Equal(ErrNotAuthorized, err)
+	_, err = c.ExportBackup(context.TODO(), nil)
+	assert.Equal(ErrNotAuthorized, err)
+
+	// the backup records the package that the Coordinator's quote reports
+	_, err = c.ExportBackup(context.TODO(), test.AdminCert)
+	assert.Error(err)
+	productID := uint64(3)
+	securityVersion := uint(2)
+	addOwnQuote(c, quote.PackageProperties{UniqueID: "old", SignerID: "signer", ProductID: &productID, SecurityVersion: &securityVersion})
+
+	backup, err := c.ExportBackup(context.TODO(), test.AdminCert)
+	require.NoError(err)
+	assert.Equal(&quote.PackageProperties{SignerID: "signer", ProductID: &productID, SecurityVersion: &securityVersion}, backup.Package)
+	assert.Equal(clientapi.BackupVersion, backup.Version)
+	assert.Equal(clientapi.ManifestSignature(rawManifest, nil), backup.ManifestSignature)
+	assert.Zero(backup.Threshold)
+	entries, err := c.verifiedAuditLog()
+	require.NoError(err)
+	assert.Equal(clientapi.AuditEventExportBackup, entries[len(entries)-1].Event)
+	assert.Equal("admin", entries[len(entries)-1].User)
+
+	name, key, err := backup.DecryptKey(test.RecoveryPrivateKey)
+	require.NoError(err)
+	assert.Equal("first", name)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(err)
+	_, _, err = backup.DecryptKey(otherKey)
+	assert.Error(err)
+
+	// the backup can't be restored into a Coordinator that has a manifest
+	_, _, err = c.RestoreBackup(context.TODO(), backup, key, nil)
+	assert.Error(err)
+
+	// the backup is authenticated
+	c2, _ := mustSetup()
+	modified := backup
+	modified.ManifestSignature = []byte("other")
+	_, _, err = c2.RestoreBackup(context.TODO(), modified, key, nil)
+	assert.Error(err)
+	wrongKey := append([]byte{}, key...)
+	wrongKey[0] ^= 1
+	_, _, err = c2.RestoreBackup(context.TODO(), backup, wrongKey, nil)
+	assert.Error(err)
+	newer := backup
+	newer.Version = clientapi.BackupVersion + 1
+	_, _, err = c2.RestoreBackup(context.TODO(), newer, key, nil)
+	assert.Error(err)
+	noPackage := backup
+	noPackage.Package = nil
+	_, _, err = c2.RestoreBackup(context.TODO(), noPackage, key, nil)
+	assert.Error(err)
+
+	// the restoring Coordinator must run the same package in the same or a newer version
+	_, _, err = c2.RestoreBackup(context.TODO(), backup, key, nil)
+	assert.Error(err)
+	olderVersion := uint(1)
+	addOwnQuote(c2, quote.PackageProperties{UniqueID: "older", SignerID: "signer", ProductID: &productID, SecurityVersion: &olderVersion})
+	_, _, err = c2.RestoreBackup(context.TODO(), backup, key, nil)
+	assert.Error(err)
+	otherProduct := uint64(4)
+	addOwnQuote(c2, quote.PackageProperties{UniqueID: "new", SignerID: "signer", ProductID: &otherProduct, SecurityVersion: &securityVersion})
+	_, _, err = c2.RestoreBackup(context.TODO(), backup, key, nil)
+	assert.Error(err)
+	assert.Equal(stateAcceptingManifest, c2.state)
+
+	newerVersion := uint(3)
+	addOwnQuote(c2, quote.PackageProperties{UniqueID: "new", SignerID: "signer", ProductID: &productID, SecurityVersion: &newerVersion})
+	remaining, recoveryData, err := c2.RestoreBackup(context.TODO(), backup, key, nil)
+	require.NoError(err)
+	assert.Equal(0, remaining)
+	assert.Contains(recoveryData, "first")
+	assert.Equal(stateAcceptingMarbles, c2.state)
+	assert.Equal(c.cert.Raw, c2.cert.Raw)
+	assert.Equal(c.rawManifest, c2.rawManifest)
+	assert.Equal(c.secrets["symmetric_key_shared"].Private, c2.secrets["symmetric_key_shared"].Private)
+	assert.Equal(c2.cert.Raw, c2.tlsCert.Certificate[1])
+
+	// the audit log continues the one of the backup
+	restored, err := c2.verifiedAuditLog()
+	require.NoError(err)
+	require.Len(restored, len(entries)+1)
+	assert.Equal(entries, restored[:len(entries)])
+	assert.Equal(clientapi.AuditEventRestoreBackup, restored[len(entries)].Event)
+}
+
+func TestBackupShares(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, manifest := mustSetup()
+	secondKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(err)
+	pkixPublicKey, err := x509.MarshalPKIXPublicKey(&secondKey.PublicKey)
+	require.NoError(err)
+	manifest.RecoveryKeys = map[string]string{
+		"first":  string(test.RecoveryPublicKey),
+		"second": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkixPublicKey})),
+	}
+	manifest.RecoveryThreshold = 2
+	manifest.Users = map[string]User{
+		"admin": {Certificate: test.CertPEM(test.AdminCert), Roles: []string{"recovery"}},
+	}
+	manifest.Roles = map[string]Role{"recovery": {ResourceType: "Recovery", Actions: []string{"Recover", "Backup"}}}
+	rawManifest, err := json.Marshal(manifest)
+	require.NoError(err)
+	_, err = c.SetManifest(context.TODO(), rawManifest, nil)
+	require.NoError(err)
+	props := quote.PackageProperties{UniqueID: "unique"}
+	addOwnQuote(c, props)
+
+	backup, err := c.ExportBackup(context.TODO(), test.AdminCert)
+	require.NoError(err)
+	assert.Equal(2, backup.Threshold)
+	_, firstShare, err := backup.DecryptKey(test.RecoveryPrivateKey)
+	require.NoError(err)
+	_, secondShare, err := backup.DecryptKey(secondKey)
+	require.NoError(err)
+
+	c2, _ := mustSetup()
+	addOwnQuote(c2, props)
+	remaining, recoveryData, err := c2.RestoreBackup(context.TODO(), backup, firstShare, test.AdminCert)
+	require.NoError(err)
+	assert.Equal(1, remaining)
+	assert.Nil(recoveryData)
+
+	// the manifest of the backup permits a user to recover, so the restore must be authorized by them
+	_, _, err = c2.RestoreBackup(context.TODO(), backup, secondShare, nil)
+	assert.Equal(ErrNotAuthorized, err)
+	assert.Equal(stateAcceptingManifest, c2.state)
+
+	_, _, err = c2.RestoreBackup(context.TODO(), backup, firstShare, test.AdminCert)
+	require.NoError(err)
+	remaining, recoveryData, err = c2.RestoreBackup(context.TODO(), backup, secondShare, test.AdminCert)
+	require.NoError(err)
+	assert.Equal(0, remaining)
+	assert.Len(recoveryData, 2)
+	assert.Equal(stateAcceptingMarbles, c2.state)
+}
+
+// addOwnQuote makes the mock validator of the Core accept its own quote, which reports the package properties.
+func addOwnQuote(c *Core, props quote.PackageProperties) {
+	c.qv.(*quote.MockValidator).AddValidQuote(c.quote, c.cert.Raw, props, quote.InfrastructureProperties{})
+}
@@ -7,37 +7,105 @@
 package core
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+	"github.com/edgelesssys/marblerun/coordinator/recovery"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
+// ErrNotAuthorized occurs if a client is not authorized to perform an operation.
+var ErrNotAuthorized = errors.New("client is not authorized")
+
 // ClientCore provides the core functionality for the client. It can be used by e.g. a http server
 type ClientCore interface {
-	SetManifest(ctx context.Context, rawManifest []byte) (recoveryDataBytes []byte, err error)
+	SetManifest(ctx context.Context, rawManifest []byte, signature []byte) (recoveryData map[string][]byte, err error)
+	SetRootCA(ctx context.Context, certChain []byte, key []byte) error
+	UpdateManifest(ctx context.Context, rawUpdate []byte, clientCert *x509.Certificate) (remaining int, err error)
+	GetPendingUpdate(ctx context.Context, clientCert *x509.Certificate) (rawUpdate []byte, acknowledgedBy []string, remaining int, err error)
+	CancelPendingUpdate(ctx context.Context, clientCert *x509.Certificate) error
 	GetCertQuote(ctx context.Context) (cert string, certQuote []byte, err error)
-	GetManifestSignature(ctx context.Context) (manifestSignature []byte)
+	QuoteUserData(ctx context.Context, userData []byte, clientCert *x509.Certificate) ([]byte, error)
+	GetManifestSignature(ctx context.Context) (manifestSignature []byte, signer string)
 	GetStatus(ctx context.Context) (statusCode int, status string, err error)
-	Recover(ctx context.Context, encryptionKey []byte) error
+	IsReady(ctx context.Context) (ready bool, status string)
+	GetMarbleStatus(ctx context.Context) (marbles map[string]clientapi.MarbleStatus, err error)
+	GetActivatedMarbles(ctx context.Context, marbleUUID string, clientCert *x509.Certificate) ([]clientapi.Marble, error)
+	SubscribeEvents(ctx context.Context, marbleTypes []string, clientCert *x509.Certificate) (events <-chan clientapi.Event, unsubscribe func(), err error)
+	ResetActivations(ctx context.Context, marbleType string, infrastructure string, activations uint, clientCert *x509.Certificate) error
+	SetMaintenance(ctx context.Context, paused bool, reason string, clientCert *x509.Certificate) error
+	GetMaintenance(ctx context.Context) clientapi.Maintenance
+	Recover(ctx context.Context, secret []byte, clientCert *x509.Certificate) (remaining int, err error)
+	AcceptRollback(ctx context.Context, secret []byte, clientCert *x509.Certificate) (remaining int, err error)
+	ExportBackup(ctx context.Context, clientCert *x509.Certificate) (clientapi.Backup, error)
+	RestoreBackup(ctx context.Context, backup clientapi.Backup, secret []byte, clientCert *x509.Certificate) (remaining int, recoveryData map[string][]byte, err error)
+	HandOff(ctx context.Context, req clientapi.HandoffRequest, clientCert *x509.Certificate) (clientapi.Handoff, error)
+	WriteSecrets(ctx context.Context, secrets map[string]clientapi.Secret, clientCert *x509.Certificate) error
+	ReadSecrets(ctx context.Context, names []string, clientCert *x509.Certificate) (map[string]clientapi.Secret, error)
+	RotateSecrets(ctx context.Context, names []string, clientCert *x509.Certificate) (versions map[string]uint, err error)
+	GetAuditLog(ctx context.Context, clientCert *x509.Certificate) (entries []clientapi.AuditEntry, err error)
+	GetManifestHistory(ctx context.Context, clientCert *x509.Certificate) (history []clientapi.ManifestVersion, err error)
+	GetManifestDiff(ctx context.Context, from uint, to uint, clientCert *x509.Certificate) (changes []clientapi.ManifestChange, err error)
+	RevokeMarble(ctx context.Context, marbleType string, marbleUUID string, clientCert *x509.Certificate) (revoked int, err error)
+	GetCRL(ctx context.Context, pkg string) (crl []byte, err error)
+	IssueCertificate(ctx context.Context, service string, csr []byte, validFor time.Duration, clientCert *x509.Certificate) (cert []byte, ca []byte, err error)
+	GetTopology(ctx context.Context, clientCert *x509.Certificate) (clientapi.Topology, error)
+	GetRecentActivations(ctx context.Context, limit uint, clientCert *x509.Certificate) ([]clientapi.Activation, error)
+	GetManifestSummary(ctx context.Context, clientCert *x509.Certificate) (clientapi.ManifestSummary, error)
+	GetTCBStatus(ctx context.Context, clientCert *x509.Certificate) (clientapi.TCBStatus, error)
+}
+
+// AuthenticateClient returns the user of the manifest that the client certificate belongs to, or an empty string if it belongs to none.
+//
+// It is the client server's Authenticator, which adds the user to the requests for logging. The methods of ClientCore still authorize the client themselves.
+func (c *Core) AuthenticateClient(ctx context.Context, clientCert *x509.Certificate) (string, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	user, _ := c.manifest.getUser(clientCert)
+	return user, nil
 }
 
 // SetManifest sets the manifest, once and for all
 //
-// rawManifest is the manifest of type Manifest in JSON format.
-func (c *Core) SetManifest(ctx context.Context, rawManifest []byte) ([]byte, error) {
+// rawManifest is the manifest of type Manifest in JSON format. signature is its detached signature by one of the trusted manifest signers,
+// which is required if signers have been set with SetManifestSigners and ignored otherwise.
+//
+// Returns the state encryption key encrypted with each of the manifest's RecoveryKeys, if any.
+func (c *Core) SetManifest(ctx context.Context, rawManifest []byte, signature []byte) (map[string][]byte, error) {
 	defer c.mux.Unlock()
 	if err := c.requireState(stateAcceptingManifest, stateRecovery); err != nil {
 		return nil, err
 	}
 
+	var signer string
+	if len(c.manifestSigners) > 0 {
+		var err error
+		if signer, err = c.verifyManifestSignature(rawManifest, signature); err != nil {
+			c.zaplogger.Warn("Rejected a manifest that is not signed by a trusted signer.")
+			return nil, err
+		}
+	} else {
+		signature = nil
+	}
+
+	// json.Unmarshal silently takes the last value of duplicate keys, so the manifest may not be what its author reviewed
+	if errs := checkDuplicateKeys(rawManifest); len(errs) > 0 {
+		return nil, errs
+	}
 	var manifest Manifest
 	if err := json.Unmarshal(rawManifest, &manifest); err != nil {
 		return nil, err
@@ -45,6 +113,16 @@ func (c *Core) SetManifest(ctx context.Context, rawManifest []byte) ([]byte, err
 	if err := manifest.Check(ctx, c.zaplogger); err != nil {
 		return nil, err
 	}
+	if err := c.checkPinnedRootCA(manifest.RootCA); err != nil {
+		return nil, err
+	}
+	if c.secretsBackend == nil {
+		for name, secret := range manifest.Secrets {
+			if secret.Backend != nil {
+				return nil, fmt.Errorf("secret %s is stored in a secrets backend, but the Coordinator has none", name)
+			}
+		}
+	}
 
 	// Generate shared secrets specified in manifest
 	secrets, err := c.generateSecrets(ctx, manifest.Secrets, uuid.Nil)
@@ -53,58 +131,464 @@ func (c *Core) SetManifest(ctx context.Context, rawManifest []byte) ([]byte, err
 		return nil, err
 	}
 
-	var recoveryk *rsa.PublicKey
-
-	// Retrieve RSA public key for potential key recovery
-	if manifest.RecoveryKey != "" {
-		block, _ := pem.Decode([]byte(manifest.RecoveryKey))
-
-		if block == nil || block.Type != "PUBLIC KEY" {
-			c.zaplogger.Error("Manifest supplied a key which does not appear to be a public key.")
-			return nil, errors.New("invalid public key in manifest")
-		}
-		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
-		if err != nil {
-			c.zaplogger.Error("Could not parse public key specified in manifest.", zap.Error(err))
-			return nil, err
-		}
-		var ok bool
-		if recoveryk, ok = pub.(*rsa.PublicKey); !ok {
-			c.zaplogger.Error("Public Key specified in manifest is not a RSA public key.")
-			return nil, errors.New("unsupported type of public key")
-		}
+	// Retrieve RSA public keys for potential key recovery
+	recoveryKeys, err := parseRecoveryKeys(manifest.recoveryKeys())
+	if err != nil {
+		c.zaplogger.Error("Could not parse recovery keys specified in manifest.", zap.Error(err))
+		return nil, err
 	}
 
 	// Generate a new encryption key for a new manifest, as the old one might be broken
 	if err := c.sealer.GenerateNewEncryptionKey(); err != nil {
 		return nil, err
 	}
+	// a state that could not be recovered is overwritten, so the new state is bound to the current counter
+	if c.counter != nil && c.state == stateRecovery {
+		if err := c.syncCounter(); err != nil {
+			return nil, err
+		}
+	}
 
+	prevState := c.state
 	c.manifest = manifest
 	c.rawManifest = rawManifest
+	c.manifestSigner = signer
+	c.manifestSignerSignature = signature
 	c.secrets = secrets
+	details := map[string]string{
+		"ManifestSignature": hex.EncodeToString(clientapi.ManifestSignature(rawManifest, nil)),
+	}
+	if signer != "" {
+		details["Signer"] = signer
+	}
+	c.appendAuditEntry(ctx, clientapi.AuditEventSetManifest, "", details)
+	c.recordManifestVersion(clientapi.AuditEventSetManifest, signer, nil)
 
 	c.advanceState(stateAcceptingMarbles)
 	encryptionKey, err := c.sealState()
 	if err != nil {
 		c.zaplogger.Error("sealState failed", zap.Error(err))
+		c.resetManifest(prevState)
+		return nil, err
 	}
 
-	var recoveryData []byte
-	if recoveryk != nil {
-		recoveryData, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, recoveryk, encryptionKey, nil)
-		if err != nil {
-			c.zaplogger.Error("Creation of recovery data failed.", zap.Error(err))
+	recoveryData, err := generateRecoveryData(recoveryKeys, manifest.RecoveryThreshold, encryptionKey)
+	if err != nil {
+		c.zaplogger.Error("Creation of recovery data failed.", zap.Error(err))
+		c.resetManifest(prevState)
+		return nil, err
+	}
+
+	c.notify(newNotification(NotificationManifestChanged, "The manifest has been set", map[string]string{
+		"ManifestSignature": hex.EncodeToString(clientapi.ManifestSignature(rawManifest, nil)),
+	}))
+	c.publishEvent(clientapi.Event{Type: clientapi.EventManifestChange, Message: "The manifest has been set"})
+	return recoveryData, nil
+}
+
+// resetManifest discards a manifest that could not be set, so that it can be set again.
+func (c *Core) resetManifest(prevState state) {
+	c.manifest = Manifest{}
+	c.rawManifest = nil
+	c.manifestSigner = ""
+	c.manifestSignerSignature = nil
+	c.manifestHistory = nil
+	c.secrets = nil
+	c.secretVersions = nil
+	c.packageCAs = nil
+	c.auditLog = nil
+	c.exportedAuditEntries = 0
+	c.setState(prevState)
+}
+
+// generateRecoveryData encrypts the state encryption key for every recovery key holder.
+// If more than one holder is required for recovery, each holder only gets a share of the key.
+func generateRecoveryData(recoveryKeys map[string]*rsa.PublicKey, threshold uint, encryptionKey []byte) (map[string][]byte, error) {
+	if len(recoveryKeys) == 0 {
+		return nil, nil
+	}
+
+	// sort the names so that the assignment of shares is deterministic
+	names := make([]string, 0, len(recoveryKeys))
+	for name := range recoveryKeys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	secrets := make([][]byte, len(names))
+	if threshold > 1 {
+		var err error
+		if secrets, err = recovery.Split(encryptionKey, len(names), int(threshold)); err != nil {
+			return nil, err
+		}
+	} else {
+		for i := range secrets {
+			secrets[i] = encryptionKey
 		}
 	}
 
+	recoveryData := make(map[string][]byte, len(names))
+	for i, name := range names {
+		data, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, recoveryKeys[name], secrets[i], nil)
+		if err != nil {
+			return nil, fmt.Errorf("encrypting recovery data for %s: %v", name, err)
+		}
+		recoveryData[name] = data
+	}
 	return recoveryData, nil
 }
 
+// parseRecoveryKeys parses the PEM-encoded RSA public keys of the manifest's RecoveryKeys section
+func parseRecoveryKeys(rawKeys map[string]string) (map[string]*rsa.PublicKey, error) {
+	recoveryKeys := make(map[string]*rsa.PublicKey, len(rawKeys))
+	for name, rawKey := range rawKeys {
+		block, _ := pem.Decode([]byte(rawKey))
+		if block == nil || block.Type != "PUBLIC KEY" {
+			return nil, fmt.Errorf("invalid public key for recovery key %s", name)
+		}
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing recovery key %s: %v", name, err)
+		}
+		rsaPub, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("unsupported type of public key for recovery key %s", name)
+		}
+		recoveryKeys[name] = rsaPub
+	}
+	return recoveryKeys, nil
+}
+
+// UpdateManifest proposes or acknowledges an update of the packages and marbles of the active manifest
+//
+// rawUpdate is a manifest of type Manifest in JSON format that only contains Packages and Marbles.
+// New packages and marbles can be added, and the SecurityVersion of existing packages can be raised.
+// clientCert is the TLS certificate of the client, which must belong to one of the manifest's Users.
+//
+// The first call proposes the update, which requires the ProposeUpdate action. Other users acknowledge it by submitting the identical update,
+// which requires the AcknowledgeUpdate action. Proposing an update also acknowledges it if the user is permitted to acknowledge updates.
+// The update takes effect once UpdateThreshold distinct users have acknowledged it.
+// Returns the number of acknowledgements that are still missing.
+func (c *Core) UpdateManifest(ctx context.Context, rawUpdate []byte, clientCert *x509.Certificate) (int, error) {
+	defer c.mux.Unlock()
+	if err := c.requireState(stateAcceptingMarbles); err != nil {
+		return -1, err
+	}
+	user, ok := c.manifest.getUser(clientCert)
+	if !ok {
+		return -1, ErrNotAuthorized
+	}
+
+	if c.pendingUpdate == nil {
+		if !c.manifest.isPermitted(user, resourceManifest, "", actionProposeUpdate) {
+			return -1, ErrNotAuthorized
+		}
+		if errs := checkDuplicateKeys(rawUpdate); len(errs) > 0 {
+			return -1, errs
+		}
+		var update Manifest
+		if err := json.Unmarshal(rawUpdate, &update); err != nil {
+			return -1, err
+		}
+		updatedManifest, err := c.manifest.applyUpdate(update)
+		if err != nil {
+			return -1, err
+		}
+		if err := updatedManifest.Check(ctx, c.zaplogger); err != nil {
+			return -1, err
+		}
+		c.pendingUpdate = &pendingUpdate{
+			raw:              rawUpdate,
+			manifest:         updatedManifest,
+			acknowledgements: make(map[string]bool),
+		}
+		c.zaplogger.Info("manifest update proposed", zap.String("user", user))
+	} else if !bytes.Equal(c.pendingUpdate.raw, rawUpdate) {
+		return -1, errors.New("a different manifest update is pending")
+	} else if !c.manifest.isPermitted(user, resourceManifest, "", actionAcknowledgeUpdate) {
+		return -1, ErrNotAuthorized
+	}
+
+	if c.manifest.isPermitted(user, resourceManifest, "", actionAcknowledgeUpdate) {
+		c.pendingUpdate.acknowledgements[user] = true
+	}
+	if remaining := c.pendingUpdate.remaining(c.manifest.UpdateThreshold); remaining > 0 {
+		c.zaplogger.Info("manifest update acknowledged", zap.String("user", user), zap.Int("remaining", remaining))
+		return remaining, nil
+	}
+
+	// enough users acknowledged the update, apply it
+	update := c.pendingUpdate
+	c.pendingUpdate = nil
+	oldManifest, oldAuditLog, oldHistory := c.manifest, c.auditLog, c.manifestHistory
+	c.manifest = update.manifest
+	c.rawUpdates = append(c.rawUpdates, update.raw)
+	c.appendAuditEntry(ctx, clientapi.AuditEventUpdateManifest, "", map[string]string{
+		"ManifestSignature": hex.EncodeToString(clientapi.ManifestSignature(c.rawManifest, c.rawUpdates)),
+		"AcknowledgedBy":    strings.Join(update.acknowledgedBy(), ","),
+	})
+	c.recordManifestVersion(clientapi.AuditEventUpdateManifest, "", update.acknowledgedBy())
+	if _, err := c.sealState(); err != nil {
+		c.manifest, c.auditLog, c.manifestHistory = oldManifest, oldAuditLog, oldHistory
+		c.rawUpdates = c.rawUpdates[:len(c.rawUpdates)-1]
+		c.zaplogger.Error("sealState failed", zap.Error(err))
+		return -1, err
+	}
+
+	c.zaplogger.Info("manifest updated", zap.Strings("acknowledgedBy", update.acknowledgedBy()))
+	c.notify(newNotification(NotificationManifestChanged, "The manifest has been updated", map[string]string{
+		"ManifestSignature": hex.EncodeToString(clientapi.ManifestSignature(c.rawManifest, c.rawUpdates)),
+		"AcknowledgedBy":    strings.Join(update.acknowledgedBy(), ","),
+	}))
+	c.publishEvent(clientapi.Event{Type: clientapi.EventManifestChange, Message: "The manifest has been updated"})
+	c.notifyParameterWatchers(nil)
+	return 0, nil
+}
+
+// GetPendingUpdate returns the manifest update that is waiting for acknowledgements, if any
+//
+// clientCert is the TLS certificate of the client, which must belong to one of the manifest's Users.
+// Returns the update, the users who already acknowledged it, and the number of acknowledgements that are still missing.
+func (c *Core) GetPendingUpdate(ctx context.Context, clientCert *x509.Certificate) ([]byte, []string, int, error) {
+	defer c.mux.Unlock()
+	if err := c.requireState(stateAcceptingMarbles); err != nil {
+		return nil, nil, -1, err
+	}
+	if _, ok := c.manifest.getUser(clientCert); !ok {
+		return nil, nil, -1, ErrNotAuthorized
+	}
+	if c.pendingUpdate == nil {
+		return nil, nil, 0, nil
+	}
+	return c.pendingUpdate.raw, c.pendingUpdate.acknowledgedBy(), c.pendingUpdate.remaining(c.manifest.UpdateThreshold), nil
+}
+
+// CancelPendingUpdate discards the manifest update that is waiting for acknowledgements
+//
+// clientCert is the TLS certificate of the client, which must belong to one of the manifest's Users who is permitted to cancel updates.
+func (c *Core) CancelPendingUpdate(ctx context.Context, clientCert *x509.Certificate) error {
+	defer c.mux.Unlock()
+	if err := c.requireState(stateAcceptingMarbles); err != nil {
+		return err
+	}
+	user, ok := c.manifest.getUser(clientCert)
+	if !ok || !c.manifest.isPermitted(user, resourceManifest, "", actionCancelUpdate) {
+		return ErrNotAuthorized
+	}
+	if c.pendingUpdate == nil {
+		return errors.New("no manifest update is pending")
+	}
+	c.pendingUpdate = nil
+	c.zaplogger.Info("manifest update cancelled", zap.String("user", user))
+	return nil
+}
+
+// WriteSecrets sets the values of user-defined secrets
+//
+// clientCert is the TLS certificate of the client, which must belong to one of the manifest's Users who is permitted to write all of the secrets.
+// Either all secrets are written or none. Secrets that have already been set are overwritten, which rotates them to a new version.
+func (c *Core) WriteSecrets(ctx context.Context, secrets map[string]clientapi.Secret, clientCert *x509.Certificate) error {
+	defer c.mux.Unlock()
+	if err := c.requireState(stateAcceptingMarbles); err != nil {
+		return err
+	}
+	user, ok := c.manifest.getUser(clientCert)
+	if !ok {
+		return ErrNotAuthorized
+	}
+	for name := range secrets {
+		if !c.manifest.isPermitted(user, resourceSecrets, name, actionWriteSecret) {
+			return ErrNotAuthorized
+		}
+	}
+
+	newSecrets := c.copySecrets()
+	newVersions := c.copySecretVersions()
+	for name, value := range secrets {
+		definition := c.manifest.Secrets[name]
+		if !definition.UserDefined {
+			return fmt.Errorf("secret %s is not user-defined", name)
+		}
+		secret, err := definition.withValue(value)
+		if err != nil {
+			return fmt.Errorf("invalid value of secret %s: %v", name, err)
+		}
+		secret.Version = 1
+		if _, ok := c.secrets[name]; ok {
+			secret.Version = c.secretVersion(name) + 1
+			newVersions[name] = secret.Version
+		}
+		newSecrets[name] = secret
+	}
+
+	if err := c.setSecrets(newSecrets, newVersions); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	c.zaplogger.Info("secrets written", zap.String("user", user), zap.Strings("secrets", names))
+	c.notifyParameterWatchers(c.marbleTypesReferencing(names))
+	return nil
+}
+
+// ReadSecrets returns the values of the named secrets
+//
+// clientCert is the TLS certificate of the client, which must belong to one of the manifest's Users who is permitted to read all of the secrets.
+// Only shared and user-defined secrets that have been set can be read, as all others are generated per marble.
+func (c *Core) ReadSecrets(ctx context.Context, names []string, clientCert *x509.Certificate) (map[string]clientapi.Secret, error) {
+	defer c.mux.Unlock()
+	if err := c.requireState(stateAcceptingMarbles); err != nil {
+		return nil, err
+	}
+	user, ok := c.manifest.getUser(clientCert)
+	if !ok {
+		return nil, ErrNotAuthorized
+	}
+	for _, name := range names {
+		if !c.manifest.isPermitted(user, resourceSecrets, name, actionReadSecret) {
+			return nil, ErrNotAuthorized
+		}
+	}
+
+	secrets := make(map[string]clientapi.Secret, len(names))
+	for _, name := range names {
+		secret, ok := c.secrets[name]
+		if !ok {
+			if c.manifest.Secrets[name].UserDefined {
+				return nil, fmt.Errorf("secret %s has not been set yet", name)
+			}
+			if c.manifest.Secrets[name].Backend != nil {
+				return nil, fmt.Errorf("secret %s is stored in the secrets backend and cannot be read", name)
+			}
+			return nil, fmt.Errorf("secret %s is generated per marble and cannot be read", name)
+		}
+		secrets[name] = clientapi.Secret{Type: secret.Type, Cert: secret.Cert.Raw, Private: secret.Private, Public: secret.Public, Version: secret.Version}
+	}
+
+	// the secrets are only returned once the read has been recorded
+	sortedNames := append([]string{}, names...)
+	sort.Strings(sortedNames)
+	oldAuditLog := c.auditLog
+	c.appendAuditEntry(ctx, clientapi.AuditEventReadSecrets, user, map[string]string{"Secrets": strings.Join(sortedNames, ",")})
+	if _, err := c.sealState(); err != nil {
+		c.auditLog = oldAuditLog
+		c.zaplogger.Error("sealState failed", zap.Error(err))
+		return nil, err
+	}
+	return secrets, nil
+}
+
+// RotateSecrets rotates the named secrets to a new version
+//
+// clientCert is the TLS certificate of the client, which must belong to one of the manifest's Users who is permitted to rotate all of the secrets.
+// Shared secrets are generated anew. Secrets that are generated per marble are derived anew from their version on activation.
+// User-defined secrets cannot be rotated this way, their users write a new value instead.
+// Marbles receive the new versions on their next activation. Marbles that watch their parameters receive them immediately.
+// Returns the new version of each secret.
+func (c *Core) RotateSecrets(ctx context.Context, names []string, clientCert *x509.Certificate) (map[string]uint, error) {
+	defer c.mux.Unlock()
+	if err := c.requireState(stateAcceptingMarbles); err != nil {
+		return nil, err
+	}
+	user, ok := c.manifest.getUser(clientCert)
+	if !ok {
+		return nil, ErrNotAuthorized
+	}
+	for _, name := range names {
+		if !c.manifest.isPermitted(user, resourceSecrets, name, actionRotateSecret) {
+			return nil, ErrNotAuthorized
+		}
+	}
+
+	newVersions := c.copySecretVersions()
+	toGenerate := make(map[string]Secret)
+	for _, name := range names {
+		definition, ok := c.manifest.Secrets[name]
+		if !ok {
+			return nil, fmt.Errorf("secret %s is not defined", name)
+		}
+		if definition.UserDefined {
+			return nil, fmt.Errorf("secret %s is user-defined and is rotated by writing a new value", name)
+		}
+		if definition.Backend != nil {
+			return nil, fmt.Errorf("secret %s is stored in the secrets backend and is rotated there", name)
+		}
+		newVersions[name] = c.secretVersion(name) + 1
+		if definition.Shared {
+			toGenerate[name] = definition
+		}
+	}
+
+	// generate the shared secrets with their new versions
+	oldVersions := c.secretVersions
+	c.secretVersions = newVersions
+	generated, err := c.generateSecrets(ctx, toGenerate, uuid.Nil)
+	c.secretVersions = oldVersions
+	if err != nil {
+		c.zaplogger.Error("Could not generate rotated secrets.", zap.Error(err))
+		return nil, err
+	}
+	newSecrets := c.copySecrets()
+	for name, secret := range generated {
+		newSecrets[name] = secret
+	}
+
+	if err := c.setSecrets(newSecrets, newVersions); err != nil {
+		return nil, err
+	}
+
+	versions := make(map[string]uint, len(names))
+	for _, name := range names {
+		versions[name] = newVersions[name]
+	}
+	c.zaplogger.Info("secrets rotated", zap.String("user", user), zap.Strings("secrets", names))
+	c.notifyParameterWatchers(c.marbleTypesReferencing(names))
+	return versions, nil
+}
+
+func (c *Core) copySecrets() map[string]Secret {
+	secrets := make(map[string]Secret, len(c.secrets))
+	for name, secret := range c.secrets {
+		secrets[name] = secret
+	}
+	return secrets
+}
+
+func (c *Core) copySecretVersions() map[string]uint {
+	versions := make(map[string]uint, len(c.secretVersions))
+	for name, version := range c.secretVersions {
+		versions[name] = version
+	}
+	return versions
+}
+
+// setSecrets replaces the secrets and their versions and seals the state. They are left unchanged if the state cannot be sealed.
+func (c *Core) setSecrets(secrets map[string]Secret, versions map[string]uint) error {
+	oldSecrets, oldVersions := c.secrets, c.secretVersions
+	c.secrets, c.secretVersions = secrets, versions
+	if _, err := c.sealState(); err != nil {
+		c.secrets, c.secretVersions = oldSecrets, oldVersions
+		c.zaplogger.Error("sealState failed", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
 // GetCertQuote gets the Coordinators certificate and corresponding quote (containing the cert)
 //
 // Returns the a remote attestation quote of its own certificate alongside this certificate that allows to verify the Coordinator's integrity and authentication for use of the ClientAPI.
+// If the root CA has been provided by the operator, the certificate is followed by the certificates that issued it.
 func (c *Core) GetCertQuote(ctx context.Context) (string, []byte, error) {
+	// the certificate and quote are replaced when the state is recovered
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if chain := c.issuerChainPEM(); chain != "" {
+		return chain, c.quote, nil
+	}
 	pemCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})
 	if len(pemCert) <= 0 {
 		return "", nil, errors.New("pem.EncodeToMemory failed")
@@ -113,45 +597,250 @@ func (c *Core) GetCertQuote(ctx context.Context) (string, []byte, error) {
 	return strCert, c.quote, nil
 }
 
+// QuoteUserData issues a quote of the Coordinator over user data, e.g., a session public key, so that protocols on top of the client API
+// can verify that they talk to the Coordinator's enclave.
+//
+// clientCert is the TLS certificate of the client, which must belong to one of the manifest's Users who is permitted to quote user data.
+// The quote is issued for clientapi.UserDataQuoteMessage(userData), which is at most clientapi.MaxUserDataSize bytes of user data,
+// and it is only returned once it has been recorded in the audit log. In simulation mode, the quote is empty.
+func (c *Core) QuoteUserData(ctx context.Context, userData []byte, clientCert *x509.Certificate) ([]byte, error) {
+	defer c.mux.Unlock()
+	if err := c.requireState(stateAcceptingMarbles); err != nil {
+		return nil, err
+	}
+	user, ok := c.manifest.getUser(clientCert)
+	if !ok || !c.manifest.isPermitted(user, resourceAttestation, "", actionQuoteUserData) {
+		return nil, ErrNotAuthorized
+	}
+	if len(userData) == 0 {
+		return nil, errors.New("no user data")
+	}
+	if len(userData) > clientapi.MaxUserDataSize {
+		return nil, fmt.Errorf("user data exceeds %d bytes", clientapi.MaxUserDataSize)
+	}
+
+	userDataQuote := []byte{}
+	if !c.inSimulationMode() {
+		var err error
+		if userDataQuote, err = c.qi.Issue(clientapi.UserDataQuoteMessage(userData)); err != nil {
+			return nil, fmt.Errorf("failed to issue a quote over the user data: %v", err)
+		}
+	}
+
+	oldAuditLog := c.auditLog
+	userDataHash := sha256.Sum256(userData)
+	c.appendAuditEntry(ctx, clientapi.AuditEventQuoteUserData, user, map[string]string{"UserDataHash": hex.EncodeToString(userDataHash[:])})
+	if _, err := c.sealState(); err != nil {
+		c.auditLog = oldAuditLog
+		c.zaplogger.Error("sealState failed", zap.Error(err))
+		return nil, err
+	}
+	return userDataQuote, nil
+}
+
 // GetManifestSignature returns the hash of the manifest
 //
-// Returns a SHA256 hash of the active manifest.
-func (c *Core) GetManifestSignature(ctx context.Context) []byte {
+// Returns a SHA256 hash of the active manifest, which covers all updates that have been applied to it,
+// and the name of the trusted signer that signed the manifest, if any.
+func (c *Core) GetManifestSignature(ctx context.Context) (manifestSignature []byte, signer string) {
 	c.mux.Lock()
 	rawManifest := c.rawManifest
+	rawUpdates := c.rawUpdates
+	signer = c.manifestSigner
 	c.mux.Unlock()
 	if rawManifest == nil {
-		return nil
+		return nil, ""
 	}
-	hash := sha256.Sum256(rawManifest)
-	return hash[:]
+	return clientapi.ManifestSignature(rawManifest, rawUpdates), signer
 }
 
 // Recover sets an encryption key (ideally decrypted from the recovery data) and tries to unseal and load a saved state again.
-func (c *Core) Recover(ctx context.Context, encryptionKey []byte) error {
+//
+// If the manifest requires multiple recovery key holders, each of them uploads its share of the key.
+// Returns the number of shares that are still missing before the state can be recovered.
+//
+// The manifest is sealed, so clients can only be authorized once the state has been decrypted. If the manifest permits any of its Users
+// to recover, clientCert must belong to such a user when it completes the recovery. Otherwise, the decrypted state is discarded again.
+//
+// If the Coordinator protects the state with a rollback counter, a state that is older than the counter is discarded again with ErrRollback,
+// see AcceptRollback.
+func (c *Core) Recover(ctx context.Context, secret []byte, clientCert *x509.Certificate) (int, error) {
+	return c.recover(ctx, secret, clientCert, false)
+}
+
+// AcceptRollback recovers the sealed state like Recover, but accepts it if it is older than the rollback counter.
+//
+// This restores an older state on purpose, e.g., after the latest state has been lost. It requires the same authorization as Recover,
+// i.e., the recovery key and, if the manifest permits any of its Users to recover, clientCert of such a user. The acceptance is recorded in the audit log.
+func (c *Core) AcceptRollback(ctx context.Context, secret []byte, clientCert *x509.Certificate) (int, error) {
+	return c.recover(ctx, secret, clientCert, true)
+}
+
+func (c *Core) recover(ctx context.Context, secret []byte, clientCert *x509.Certificate, acceptRollback bool) (int, error) {
 	defer c.mux.Unlock()
 	if err := c.requireState(stateRecovery); err != nil {
-		return err
+		return -1, err
+	}
+
+	encryptionKey := secret
+	if recovery.IsShare(secret) {
+		remaining, key, err := c.recoveryShares.Add(secret)
+		if err != nil {
+			return -1, err
+		}
+		if remaining > 0 {
+			c.zaplogger.Info("received recovery share", zap.Int("remaining", remaining))
+			return remaining, nil
+		}
+		encryptionKey = key
 	}
+	// start over with a fresh set of shares if the recovery fails
+	c.recoveryShares.Reset()
 
 	if err := c.sealer.SetEncryptionKey(encryptionKey); err != nil {
-		return err
+		return -1, err
 	}
 
 	cert, privk, err := c.loadState()
 	if err != nil {
-		return err
+		return -1, err
+	}
+	var user string
+	if c.manifest.hasPermittedUser(resourceRecovery, "", actionRecover) {
+		var ok bool
+		user, ok = c.manifest.getUser(clientCert)
+		if !ok || !c.manifest.isPermitted(user, resourceRecovery, "", actionRecover) {
+			c.discardRecoveredState()
+			return -1, ErrNotAuthorized
+		}
+		c.zaplogger.Info("state recovered", zap.String("user", user))
+	}
+	var details map[string]string
+	if c.counter != nil {
+		counterValue := c.counterValue
+		if err := c.checkCounter(acceptRollback); err != nil {
+			c.discardRecoveredState()
+			return -1, err
+		}
+		// the recovered state is older than the counter and the client accepted the rollback
+		if c.counterValue != counterValue {
+			details = map[string]string{"StateCounter": fmt.Sprint(counterValue), "Counter": fmt.Sprint(c.counterValue)}
+		}
 	}
 
 	c.cert = cert
 	c.privk = privk
 
 	c.quote = c.generateQuote()
+	if c.tlsCert, err = c.generateTLSCertificate(); err != nil {
+		return -1, err
+	}
 
-	return nil
+	// the state has been recovered at this point, so a failure to persist the entry doesn't undo the recovery
+	oldAuditLog := c.auditLog
+	c.appendAuditEntry(ctx, clientapi.AuditEventRecover, user, details)
+	if _, err := c.sealState(); err != nil {
+		c.auditLog = oldAuditLog
+		c.zaplogger.Error("failed to record the recovery in the audit log", zap.Error(err))
+	}
+
+	return 0, nil
+}
+
+// discardRecoveredState discards a state that has been loaded by an unauthorized client, so that the Coordinator stays in recovery mode.
+func (c *Core) discardRecoveredState() {
+	c.manifest = Manifest{}
+	c.rawManifest = nil
+	c.rawUpdates = nil
+	c.manifestSigner = ""
+	c.manifestSignerSignature = nil
+	c.manifestHistory = nil
+	c.secrets = nil
+	c.secretVersions = nil
+	c.packageCAs = nil
+	c.rootChain = nil
+	c.marbleCerts = nil
+	c.seenQuotes = nil
+	c.maintenance = nil
+	c.auditLog = nil
+	c.exportedAuditEntries = 0
+	c.activations = make(map[string]uint)
+	c.infrastructureActivations = nil
+	c.activeMarbles = make(map[string]activeMarble)
+	c.setState(stateRecovery)
+}
+
+// GetAuditLog returns the entries of the audit log
+//
+// The log records the manifest and its updates, marble activations, reads of secrets by users and recoveries of the state.
+// clientCert is the TLS certificate of the client, which must belong to one of the manifest's Users who is permitted to read the audit log.
+// It is verified before it is returned, clients can verify it again with clientapi.VerifyAuditLog.
+func (c *Core) GetAuditLog(ctx context.Context, clientCert *x509.Certificate) ([]clientapi.AuditEntry, error) {
+	c.mux.RLock()
+	if c.state != stateAcceptingMarbles {
+		c.mux.RUnlock()
+		return nil, errors.New("server is not in expected state")
+	}
+	user, ok := c.manifest.getUser(clientCert)
+	permitted := ok && c.manifest.isPermitted(user, resourceAuditLog, "", actionReadAuditLog)
+	c.mux.RUnlock()
+	if !permitted {
+		return nil, ErrNotAuthorized
+	}
+	return c.verifiedAuditLog()
+}
+
+// verifiedAuditLog returns a copy of the audit log after verifying its chain.
+//
+// The log grows with every activation, so it is verified without holding the lock of the Core. Its entries are never modified.
+func (c *Core) verifiedAuditLog() ([]clientapi.AuditEntry, error) {
+	c.mux.RLock()
+	entries := append([]clientapi.AuditEntry{}, c.auditLog...)
+	c.mux.RUnlock()
+	if err := clientapi.VerifyAuditLog(entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
 }
 
 // GetStatus returns status information about the state of the mesh.
 func (c *Core) GetStatus(ctx context.Context) (statusCode int, status string, err error) {
 	return c.getStatus(ctx)
 }
+
+// IsReady returns true if the Coordinator accepts marbles, i.e., its manifest is set, its state is unsealed and it hasn't handed off its state.
+// It also returns the status message of the Coordinator's state, which tells what is missing otherwise.
+func (c *Core) IsReady(ctx context.Context) (bool, string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	_, status, _ := c.getStatus(ctx)
+	return c.isReady(), status
+}
+
+// isReady returns true if the Coordinator accepts marbles. The Core must be locked.
+func (c *Core) isReady() bool {
+	return c.state == stateAcceptingMarbles && !c.handedOff
+}
+
+// GetMarbleStatus returns the activation statistics of each Marble type defined in the manifest.
+//
+// Returns an empty map if no manifest has been set yet.
+func (c *Core) GetMarbleStatus(ctx context.Context) (map[string]clientapi.MarbleStatus, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	marbles := make(map[string]clientapi.MarbleStatus, len(c.manifest.Marbles))
+	for name, marble := range c.manifest.Marbles {
+		// activations that will be released on the next activation of the type are already omitted
+		activations := c.activations[name] - c.silentActivations(name)
+		remaining := -1
+		if marble.MaxActivations > 0 {
+			remaining = 0
+			if activations < marble.MaxActivations {
+				remaining = int(marble.MaxActivations - activations)
+			}
+		}
+		marbles[name] = clientapi.MarbleStatus{Activations: activations, MaxActivations: marble.MaxActivations, Remaining: remaining}
+	}
+	return marbles, nil
+}
@@ -15,6 +15,7 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
@@ -22,10 +23,16 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"net"
+	"runtime"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
 	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/coordinator/recovery"
 	"github.com/edgelesssys/marblerun/util"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
@@ -37,17 +44,125 @@ import (
 type Core struct {
 	cert        *x509.Certificate
 	quote       []byte
-	privk       *ecdsa.PrivateKey
+	privk       crypto.Signer
+	tlsCert     *tls.Certificate
 	sealer      Sealer
+	rootKeys    RootKeyStore
 	manifest    Manifest
 	rawManifest []byte
+	rawUpdates  [][]byte
 	secrets     map[string]Secret
 	state       state
 	qv          quote.Validator
 	qi          quote.Issuer
 	activations map[string]uint
-	mux         sync.Mutex
-	zaplogger   *zap.Logger
+	// mux is only read-locked by operations that don't modify the Core, e.g., while activations generate the keys of marbles
+	mux        sync.RWMutex
+	zaplogger  *zap.Logger
+	simulation bool
+
+	recoveryShares *recovery.Collector
+	pendingUpdate  *pendingUpdate
+	// restoreShares collects the shares of the backup key of the backup with the hash restoreBackup
+	restoreShares *recovery.Collector
+	restoreBackup [sha256.Size]byte
+	// secretVersions contains the current version of each secret that has been rotated, all others are at version 1
+	secretVersions map[string]uint
+	// packageCAs contains the intermediate CAs that issue the marble certificates, one per package
+	packageCAs map[string]packageCA
+	// auditLog records the security-relevant events since the manifest has been set
+	auditLog []clientapi.AuditEntry
+	// exportedAuditEntries is the number of entries of the audit log that have been sent to the auditSinks
+	exportedAuditEntries int
+	auditSinks           []AuditSink
+	notifiers            []Notifier
+	// replicator replicates the state to the other Coordinators of a cluster, if any
+	replicator Replicator
+	// stateIndex is the index of the replicated state the Core is based on
+	stateIndex uint64
+	// activationFailures counts the consecutive failed activations of each marble type
+	activationFailures map[string]uint
+	// tcbRejections counts the activations of each marble type that have been rejected because of the TCB of their platform
+	tcbRejections map[string]clientapi.TCBRejections
+	// clientLimiter and marbleTypeLimiter limit the rate of activation requests, verifications bounds the number of concurrent quote verifications
+	clientLimiter     *rateLimiter
+	marbleTypeLimiter *rateLimiter
+	verifications     chan struct{}
+	// counter protects the sealed state against rollback, if set
+	counter MonotonicCounter
+	// counterValue is the value of the counter the sealed state is bound to
+	counterValue uint64
+	// rootChain contains the certificates that issued the root certificate if it has been provided by the operator
+	rootChain []*x509.Certificate
+	// providedRootCA is set if the root certificate has been provided by the operator and must be pinned by the manifest
+	providedRootCA bool
+	// dnsNames are the DNS names of the Coordinator's TLS certificate
+	dnsNames []string
+	// ipAddrs are the IP addresses of the Coordinator's TLS certificate in addition to the loopback addresses
+	ipAddrs []net.IP
+	// marbleCerts records the certificates that have been issued to marbles and whether they are revoked
+	marbleCerts []marbleCert
+	// crlURL is the URL of the CRL endpoint that is embedded in marble certificates, if any
+	crlURL string
+	// infrastructureActivations counts the activations of each marble type per infrastructure
+	infrastructureActivations map[string]map[string]uint
+	// activeMarbles contains the activated marbles by UUID, except for those whose activations have been released
+	activeMarbles map[string]activeMarble
+	// parameterWatchers are the marbles whose parameters are pushed to them when they change
+	parameterWatchers map[*parameterWatcher]struct{}
+	// eventSubscribers are the clients that watch the events of the mesh
+	eventSubscribers map[*eventSubscriber]struct{}
+	// maintenance is set while the activations of marbles are paused
+	maintenance *maintenance
+	// handedOff is set once the state has been handed off to a new Coordinator, after which the state must not change anymore
+	handedOff bool
+	// handoffKey is the key a new Coordinator receives the state of the running one with, see HandoffRequest
+	handoffKey *rsa.PrivateKey
+	// readinessListener is told whether the Core accepts marbles whenever its state changes
+	readinessListener func(ready bool)
+	// secretsBackend provides the secrets of the manifest that are stored outside of the Coordinator, if any
+	secretsBackend *secretsBackendCache
+	// manifestSigners contains the public keys of the signers that are trusted to sign the manifest by name. Unsigned manifests are accepted if it is empty.
+	manifestSigners map[string]crypto.PublicKey
+	// manifestSigner is the name of the signer of the manifest and manifestSignerSignature its detached signature, if the manifest has been signed
+	manifestSigner          string
+	manifestSignerSignature []byte
+	// manifestHistory contains the versions of the manifest in the order they have been set and updated
+	manifestHistory []clientapi.ManifestVersion
+	// nonces are the nonces that have been issued to marbles for their activations
+	nonces *nonceStore
+	// seenQuotes contains the hashes of the quotes of the latest activations, which are rejected if they are replayed
+	seenQuotes []string
+}
+
+// packageCA is an intermediate CA, signed by the root certificate, that issues the certificates of the marbles of a package.
+type packageCA struct {
+	RawCert []byte
+	Privk   []byte
+}
+
+// pendingUpdate is a manifest update that has not been acknowledged by enough users yet.
+// It is not sealed, i.e., it is discarded if the Coordinator restarts.
+type pendingUpdate struct {
+	raw              []byte
+	manifest         Manifest
+	acknowledgements map[string]bool
+}
+
+func (u *pendingUpdate) remaining(threshold uint) int {
+	if threshold == 0 {
+		threshold = 1
+	}
+	return int(threshold) - len(u.acknowledgements)
+}
+
+func (u *pendingUpdate) acknowledgedBy() []string {
+	users := make([]string, 0, len(u.acknowledgements))
+	for user := range u.acknowledgements {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	return users
 }
 
 // The sequence of states a Coordinator may be in
@@ -63,17 +178,59 @@ const (
 
 // sealedState represents the state information, required for persistence, that gets sealed to the filesystem
 type sealedState struct {
+	// Version is the version of the format of the state, see stateVersion. States sealed before the format has been versioned don't have one.
+	Version     int `json:",omitempty"`
 	Privk       []byte
 	RawManifest []byte
+	RawUpdates  [][]byte
 	RawCert     []byte
 	Secrets     map[string]Secret
 	State       state
 	Activations map[string]uint
+	// SecretVersions contains the current version of each secret that has been rotated
+	SecretVersions map[string]uint
+	// PackageCAs contains the intermediate CAs of the packages
+	PackageCAs map[string]packageCA
+	// AuditLog contains the hash-chained audit log
+	AuditLog []clientapi.AuditEntry
+	// RootKeyID identifies the root key in the RootKeyStore if it is held there. Privk is empty then.
+	RootKeyID string `json:",omitempty"`
+	// DerivationKey replaces the root key for the derivation of secrets if the root key is held by a RootKeyStore
+	DerivationKey []byte `json:",omitempty"`
+	// Counter is the value of the rollback counter after the state has been sealed
+	Counter uint64 `json:",omitempty"`
+	// RootChain contains the certificates that issued RawCert if the root CA has been provided by the operator
+	RootChain [][]byte `json:",omitempty"`
+	// MarbleCerts contains the certificates that have been issued to marbles
+	MarbleCerts []marbleCert `json:",omitempty"`
+	// ActiveMarbles contains the activated marbles by UUID
+	ActiveMarbles map[string]activeMarble `json:",omitempty"`
+	// InfrastructureActivations counts the activations of each marble type per infrastructure
+	InfrastructureActivations map[string]map[string]uint `json:",omitempty"`
+	// ManifestSigner is the name of the trusted signer of RawManifest and ManifestSignerSignature its detached signature, if the manifest has been signed
+	ManifestSigner          string `json:",omitempty"`
+	ManifestSignerSignature []byte `json:",omitempty"`
+	// ManifestHistory contains the versions of the manifest in the order they have been set and updated
+	ManifestHistory []clientapi.ManifestVersion `json:",omitempty"`
+	// SeenQuotes contains the hashes of the quotes of the latest activations
+	SeenQuotes []string `json:",omitempty"`
+	// Maintenance is set while the activations of marbles are paused
+	Maintenance *maintenance `json:",omitempty"`
+}
+
+// recoveryInfo describes how the state encryption key has been split among the recovery key holders.
+// It is stored unencrypted next to the sealed state, because it is needed to collect the shares before the state can be decrypted.
+type recoveryInfo struct {
+	Threshold int
+	Shares    int
 }
 
 // CoordinatorName is the name of the Coordinator. It is used as CN of the root certificate.
 const CoordinatorName string = "Marblerun Coordinator"
 
+// SimulationOrganizationalUnit is set as OU of all certificates created in simulation mode to mark them as insecure.
+const SimulationOrganizationalUnit string = "INSECURE: Marblerun Simulation Mode"
+
 // Needs to be paired with `defer c.mux.Unlock()`
 func (c *Core) requireState(states ...state) error {
 	c.mux.Lock()
@@ -89,18 +246,63 @@ func (c *Core) advanceState(newState state) {
 	if !(c.state < newState && newState < stateMax) {
 		panic(fmt.Errorf("cannot advance from %d to %d", c.state, newState))
 	}
+	c.setState(newState)
+}
+
+// requestLogger returns the logger of the Core with the ID of the request that is handled in ctx.
+func (c *Core) requestLogger(ctx context.Context) *zap.Logger {
+	if id := util.RequestID(ctx); id != "" {
+		return c.zaplogger.With(zap.String("request_id", id))
+	}
+	return c.zaplogger
+}
+
+// setState sets the state of the Core and reports it in the metrics and to the readiness listener.
+func (c *Core) setState(newState state) {
 	c.state = newState
+	coordinatorState.Set(float64(newState))
+	if c.readinessListener != nil {
+		c.readinessListener(c.isReady())
+	}
+}
+
+// SetReadinessListener makes the Core report to listener whether it accepts marbles, at once and whenever its state changes.
+// The listener is called while the Core is locked, so it must not call the Core.
+func (c *Core) SetReadinessListener(listener func(ready bool)) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.readinessListener = listener
+	listener(c.isReady())
 }
 
 // NewCore creates and initializes a new Core object
-func NewCore(dnsNames []string, qv quote.Validator, qi quote.Issuer, sealer Sealer, zapLogger *zap.Logger) (*Core, error) {
+//
+// If rootKeys is not nil, the root key is generated in and used from the RootKeyStore.
+// If simulation is true, quotes are neither generated nor validated and all certificates are marked as insecure.
+// The names of the Coordinator's certificates may contain IP addresses, which are added as such.
+func NewCore(dnsNames []string, qv quote.Validator, qi quote.Issuer, sealer Sealer, rootKeys RootKeyStore, simulation bool, zapLogger *zap.Logger) (*Core, error) {
+	dnsNames, ipAddrs := splitSubjectAltNames(dnsNames)
 	c := &Core{
-		state:       stateUninitialized,
-		activations: make(map[string]uint),
-		qv:          qv,
-		qi:          qi,
-		sealer:      sealer,
-		zaplogger:   zapLogger,
+		state:         stateUninitialized,
+		activations:   make(map[string]uint),
+		activeMarbles: make(map[string]activeMarble),
+		qv:            qv,
+		qi:            qi,
+		sealer:        sealer,
+		rootKeys:      rootKeys,
+		simulation:    simulation,
+		zaplogger:     zapLogger,
+		dnsNames:      dnsNames,
+		ipAddrs:       ipAddrs,
+
+		recoveryShares:     recovery.NewCollector(0, 0),
+		activationFailures: make(map[string]uint),
+		tcbRejections:      make(map[string]clientapi.TCBRejections),
+		verifications:      make(chan struct{}, runtime.NumCPU()),
+		nonces:             newNonceStore(),
+	}
+	if simulation {
+		zapLogger.Warn("Running in simulation mode. Quotes are neither generated nor validated. DO NOT USE IN PRODUCTION!")
 	}
 
 	zapLogger.Info("loading state")
@@ -127,6 +329,9 @@ func NewCore(dnsNames []string, qv quote.Validator, qi quote.Issuer, sealer Seal
 	c.cert = cert
 	c.privk = privk
 	c.quote = c.generateQuote()
+	if c.tlsCert, err = c.generateTLSCertificate(); err != nil {
+		return nil, err
+	}
 
 	return c, nil
 }
@@ -141,35 +346,51 @@ func NewCoreWithMocks() *Core {
 	validator := quote.NewMockValidator()
 	issuer := quote.NewMockIssuer()
 	sealer := &MockSealer{}
-	core, err := NewCore([]string{"localhost"}, validator, issuer, sealer, zapLogger)
+	core, err := NewCore([]string{"localhost"}, validator, issuer, sealer, nil, false, zapLogger)
 	if err != nil {
 		panic(err)
 	}
 	return core
 }
 
-// inSimulationMode returns true if we operate in OE_SIMULATION mode
+// inSimulationMode returns true if we operate in simulation mode, either explicitly or because no quote could be generated (OE_SIMULATION)
 func (c *Core) inSimulationMode() bool {
-	return len(c.quote) == 0
+	return c.simulation || len(c.quote) == 0
 }
 
 // GetTLSConfig gets the core's TLS configuration
 func (c *Core) GetTLSConfig() (*tls.Config, error) {
 	return &tls.Config{
 		GetCertificate: c.GetTLSCertificate,
+		// clients authenticate with their certificate for privileged operations like manifest updates
+		ClientAuth: tls.RequestClientCert,
 	}, nil
 }
 
 // GetTLSCertificate creates a TLS certificate for the Coordinators self-signed x509 certificate
+//
+// The chain consists of the RA-TLS certificate, which embeds the Coordinator's quote, and the root certificate.
+// This allows clients to attest the Coordinator within the TLS handshake.
 func (c *Core) GetTLSCertificate(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	// the certificate is replaced when the state is recovered
+	c.mux.Lock()
+	defer c.mux.Unlock()
 	if c.state == stateUninitialized {
 		return nil, errors.New("don't have a cert yet")
 	}
-	return util.TLSCertFromDER(c.cert.Raw, c.privk), nil
+	return c.tlsCert, nil
 }
 
-func (c *Core) loadState() (*x509.Certificate, *ecdsa.PrivateKey, error) {
-	stateRaw, err := c.sealer.Unseal()
+func (c *Core) loadState() (*x509.Certificate, crypto.Signer, error) {
+	recoveryRaw, stateRaw, err := c.sealer.Unseal()
+	// the recovery info is needed to recover a state that cannot be decrypted
+	if len(recoveryRaw) > 0 {
+		var info recoveryInfo
+		if err := json.Unmarshal(recoveryRaw, &info); err != nil {
+			return nil, nil, err
+		}
+		c.recoveryShares = recovery.NewCollector(info.Threshold, info.Shares)
+	}
 	if err != nil {
 		return nil, nil, err
 	}
@@ -177,8 +398,19 @@ func (c *Core) loadState() (*x509.Certificate, *ecdsa.PrivateKey, error) {
 		return nil, nil, nil
 	}
 
-	// load state
 	c.zaplogger.Info("applying sealed state")
+	return c.applyState(stateRaw)
+}
+
+// applyState sets the Core to the state in stateRaw and returns the root certificate and key of the state.
+func (c *Core) applyState(stateRaw []byte) (*x509.Certificate, crypto.Signer, error) {
+	stateRaw, version, err := migrateState(stateRaw)
+	if err != nil {
+		return nil, nil, err
+	}
+	if version != stateVersion {
+		c.zaplogger.Info("migrated the state to the current format", zap.Int("from", version), zap.Int("to", stateVersion))
+	}
 	var loadedState sealedState
 	if err := json.Unmarshal(stateRaw, &loadedState); err != nil {
 		return nil, nil, err
@@ -189,52 +421,215 @@ func (c *Core) loadState() (*x509.Certificate, *ecdsa.PrivateKey, error) {
 	if err != nil {
 		return nil, nil, err
 	}
-	privk, err := x509.ParseECPrivateKey(loadedState.Privk)
+	var privk crypto.Signer
+	if loadedState.RootKeyID != "" {
+		privk, err = c.getRootKey(loadedState.RootKeyID, loadedState.DerivationKey, cert)
+	} else {
+		privk, err = x509.ParseECPrivateKey(loadedState.Privk)
+	}
 	if err != nil {
 		return nil, nil, err
 	}
 
-	if err := json.Unmarshal(loadedState.RawManifest, &c.manifest); err != nil {
+	var rootChain []*x509.Certificate
+	for _, rawCert := range loadedState.RootChain {
+		issuer, err := x509.ParseCertificate(rawCert)
+		if err != nil {
+			return nil, nil, err
+		}
+		rootChain = append(rootChain, issuer)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(loadedState.RawManifest, &manifest); err != nil {
 		return nil, nil, err
 	}
+	c.manifest = manifest
 	c.rawManifest = loadedState.RawManifest
+	c.manifestSigner = loadedState.ManifestSigner
+	c.manifestSignerSignature = loadedState.ManifestSignerSignature
+
+	// apply the manifest updates in the order they have been made
+	for _, rawUpdate := range loadedState.RawUpdates {
+		var update Manifest
+		if err := json.Unmarshal(rawUpdate, &update); err != nil {
+			return nil, nil, err
+		}
+		if c.manifest, err = c.manifest.applyUpdate(update); err != nil {
+			return nil, nil, err
+		}
+	}
+	c.rawUpdates = loadedState.RawUpdates
 
-	c.state = loadedState.State
+	c.setState(loadedState.State)
 	c.activations = loadedState.Activations
+	c.infrastructureActivations = loadedState.InfrastructureActivations
 	c.secrets = loadedState.Secrets
+	c.secretVersions = loadedState.SecretVersions
+	c.packageCAs = loadedState.PackageCAs
+	c.auditLog = loadedState.AuditLog
+	c.exportedAuditEntries = len(c.auditLog)
+	c.manifestHistory = loadedState.ManifestHistory
+	c.seenQuotes = loadedState.SeenQuotes
+	c.maintenance = loadedState.Maintenance
+	c.counterValue = loadedState.Counter
+	c.rootChain = rootChain
+	c.marbleCerts = loadedState.MarbleCerts
+	c.activeMarbles = make(map[string]activeMarble, len(loadedState.ActiveMarbles))
+	now := time.Now()
+	for marbleUUID, marble := range loadedState.ActiveMarbles {
+		marble.LastSeen = now
+		c.activeMarbles[marbleUUID] = marble
+	}
 	return cert, privk, err
 }
 
+// adoptState makes the Core continue with stateRaw, which has been exported by another Coordinator, e.g., in a backup or by a handoff.
+//
+// The Core must be locked and must neither have a manifest nor a recovered state. accept is called once the state has been applied.
+// It may reject the state, e.g., if the client isn't authorized by the state's manifest, and records the adoption in the audit log. It may be nil.
+// The state is sealed with encryptionKey, or with a new key if it is nil, which is returned. If the state can't be adopted, the Core is reset.
+func (c *Core) adoptState(stateRaw []byte, encryptionKey []byte, accept func() error) ([]byte, error) {
+	prevState := c.state
+	prevCert, prevPrivk, prevQuote, prevTLSCert := c.cert, c.privk, c.quote, c.tlsCert
+	prevRootChain, prevCounterValue := c.rootChain, c.counterValue
+	fail := func(err error) ([]byte, error) {
+		c.discardRecoveredState()
+		c.setState(prevState)
+		c.cert, c.privk, c.quote, c.tlsCert = prevCert, prevPrivk, prevQuote, prevTLSCert
+		c.rootChain, c.counterValue = prevRootChain, prevCounterValue
+		return nil, err
+	}
+
+	cert, privk, err := c.applyState(stateRaw)
+	if err != nil {
+		return fail(err)
+	}
+	if accept != nil {
+		if err := accept(); err != nil {
+			return fail(err)
+		}
+	}
+	// the adopted state replaces whatever has been sealed by this Coordinator, so it is bound to the current counter
+	if c.counter != nil {
+		if err := c.syncCounter(); err != nil {
+			return fail(err)
+		}
+	}
+	if encryptionKey == nil {
+		err = c.sealer.GenerateNewEncryptionKey()
+	} else {
+		err = c.sealer.SetEncryptionKey(encryptionKey)
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	c.cert = cert
+	c.privk = privk
+	c.quote = c.generateQuote()
+	if c.tlsCert, err = c.generateTLSCertificate(); err != nil {
+		return fail(err)
+	}
+	if encryptionKey, err = c.sealState(); err != nil {
+		c.zaplogger.Error("sealState failed", zap.Error(err))
+		return fail(err)
+	}
+	return encryptionKey, nil
+}
+
 func (c *Core) sealState() ([]byte, error) {
-	// marshal private key
-	x509Encoded, err := x509.MarshalECPrivateKey(c.privk)
+	if c.handedOff {
+		return nil, ErrHandedOff
+	}
+	stateRaw, err := c.marshalState()
 	if err != nil {
 		return nil, err
 	}
 
-	// seal with manifest set
+	// the state is committed by the cluster first, so that the local state never gets ahead of it
+	if c.replicator != nil {
+		index, err := c.replicator.Replicate(stateRaw, c.stateIndex)
+		if err != nil {
+			return nil, err
+		}
+		c.stateIndex = index
+	}
+
+	var info recoveryInfo
+	if c.manifest.RecoveryThreshold > 1 {
+		info = recoveryInfo{Threshold: int(c.manifest.RecoveryThreshold), Shares: len(c.manifest.recoveryKeys())}
+	}
+	recoveryRaw, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	encryptionKey, err := c.sealer.Seal(recoveryRaw, stateRaw)
+	if err != nil {
+		return nil, err
+	}
+	if c.counter != nil {
+		c.incrementCounter()
+	}
+	c.exportAuditEntries()
+	return encryptionKey, nil
+}
+
+// marshalState returns the state of the Core in the form it is sealed in.
+func (c *Core) marshalState() ([]byte, error) {
 	state := sealedState{
-		Privk:       x509Encoded,
+		Version:     stateVersion,
 		RawManifest: c.rawManifest,
+		RawUpdates:  c.rawUpdates,
 		RawCert:     c.cert.Raw,
 		State:       c.state,
 		Secrets:     c.secrets,
 		Activations: c.activations,
+
+		SecretVersions: c.secretVersions,
+		PackageCAs:     c.packageCAs,
+		AuditLog:       c.auditLog,
+		MarbleCerts:    c.marbleCerts,
+		ActiveMarbles:  c.activeMarbles,
+
+		InfrastructureActivations: c.infrastructureActivations,
+		ManifestSigner:            c.manifestSigner,
+		ManifestSignerSignature:   c.manifestSignerSignature,
+		ManifestHistory:           c.manifestHistory,
+		SeenQuotes:                c.seenQuotes,
+		Maintenance:               c.maintenance,
+	}
+	if c.counter != nil {
+		state.Counter = c.counterValue + 1
+	}
+	for _, issuer := range c.rootChain {
+		state.RootChain = append(state.RootChain, issuer.Raw)
+	}
+	// marshal private key, unless it is held by the key store
+	if key, ok := c.privk.(*externalRootKey); ok {
+		state.RootKeyID = key.id
+		state.DerivationKey = key.derivationKey
+	} else {
+		x509Encoded, err := x509.MarshalECPrivateKey(c.privk.(*ecdsa.PrivateKey))
+		if err != nil {
+			return nil, err
+		}
+		state.Privk = x509Encoded
 	}
-	stateRaw, err := json.Marshal(state)
-	if err != nil {
-		return nil, err
-	}
-	return c.sealer.Seal(stateRaw)
+	return json.Marshal(state)
 }
 
-func (c *Core) generateCert(dnsNames []string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+func (c *Core) generateCert(dnsNames []string) (*x509.Certificate, crypto.Signer, error) {
 	defer c.mux.Unlock()
 	if err := c.requireState(stateUninitialized); err != nil {
 		return nil, nil, err
 	}
+	return c.newRootCert(dnsNames)
+}
 
-	privk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+// newRootCert creates a new root certificate and its key. The Core must be locked.
+func (c *Core) newRootCert(dnsNames []string) (*x509.Certificate, crypto.Signer, error) {
+	privk, err := c.generateRootKey()
 	if err != nil {
 		return nil, nil, err
 	}
@@ -255,7 +650,7 @@ func (c *Core) generateCert(dnsNames []string) (*x509.Certificate, *ecdsa.Privat
 			CommonName: CoordinatorName,
 		},
 		DNSNames:    dnsNames,
-		IPAddresses: util.DefaultCertificateIPAddresses,
+		IPAddresses: c.certificateIPAddresses(),
 		NotBefore:   notBefore,
 		NotAfter:    notAfter,
 
@@ -264,8 +659,11 @@ func (c *Core) generateCert(dnsNames []string) (*x509.Certificate, *ecdsa.Privat
 		BasicConstraintsValid: true,
 		IsCA:                  true,
 	}
+	if c.simulation {
+		template.Subject.OrganizationalUnit = []string{SimulationOrganizationalUnit}
+	}
 
-	certRaw, err := x509.CreateCertificate(rand.Reader, &template, &template, &privk.PublicKey, privk)
+	certRaw, err := x509.CreateCertificate(rand.Reader, &template, &template, privk.Public(), privk)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -277,7 +675,56 @@ func (c *Core) generateCert(dnsNames []string) (*x509.Certificate, *ecdsa.Privat
 	return cert, privk, nil
 }
 
+// generateTLSCertificate creates the TLS certificate of the Coordinator from the root certificate and a new RA-TLS certificate.
+//
+// The RA-TLS certificate is signed by the root certificate and embeds the quote of the root certificate.
+// It is not sealed, but recreated with the current quote whenever the Coordinator starts.
+// Its subject and names are set explicitly, because a root CA provided by the operator may not contain them.
+func (c *Core) generateTLSCertificate() (*tls.Certificate, error) {
+	privk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serialNumber, err := util.GenerateCertificateSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkix.Name{CommonName: CoordinatorName},
+		DNSNames:     c.dnsNames,
+		IPAddresses:  c.certificateIPAddresses(),
+		NotBefore:    time.Now(),
+		NotAfter:     c.cert.NotAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if c.simulation {
+		template.Subject.OrganizationalUnit = []string{SimulationOrganizationalUnit}
+	}
+	if len(c.quote) > 0 {
+		template.ExtraExtensions = []pkix.Extension{{Id: quote.OIDRATLSQuote, Value: c.quote}}
+	}
+
+	certRaw, err := x509.CreateCertificate(rand.Reader, &template, c.cert, &privk.PublicKey, c.privk)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(certRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{Certificate: [][]byte{cert.Raw, c.cert.Raw}, PrivateKey: privk, Leaf: cert}, nil
+}
+
 func (c *Core) generateQuote() []byte {
+	if c.simulation {
+		c.zaplogger.Warn("Simulation mode: not generating a quote.")
+		return []byte{}
+	}
 	c.zaplogger.Info("generating quote")
 	quote, err := c.qi.Issue(c.cert.Raw)
 	if err != nil {
@@ -313,6 +760,9 @@ func (c *Core) getStatus(ctx context.Context) (int, string, error) {
 		status = "Coordinator is ready to accept a manifest."
 	case stateAcceptingMarbles:
 		status = "Coordinator is running correctly and ready to accept marbles."
+		if c.handedOff {
+			status = "Coordinator has handed off its state to a new Coordinator and can be stopped."
+		}
 	default:
 		return -1, "Cannot determine coordinator status.", errors.New("cannot determine coordinator status")
 	}
@@ -331,16 +781,20 @@ func (c *Core) generateSecrets(ctx context.Context, secrets map[string]Secret, i
 		if secret.Shared != (id == uuid.Nil) {
 			continue
 		}
+		// User-defined secrets are uploaded by users, others are fetched from the secrets backend on activation
+		if secret.UserDefined || secret.Backend != nil {
+			continue
+		}
+
+		if err := secret.check(); err != nil {
+			return nil, fmt.Errorf("invalid secret %s: %v", name, err)
+		}
 
-		c.zaplogger.Info("generating secret", zap.String("name", name), zap.String("type", secret.Type), zap.Uint("size", secret.Size))
+		secret.Version = c.secretVersion(name)
+		c.zaplogger.Info("generating secret", zap.String("name", name), zap.String("type", secret.Type), zap.Uint("size", secret.Size), zap.Uint("version", secret.Version))
 		switch secret.Type {
 		// Raw = Symmetric Key
 		case "symmetric-key":
-			// Check secret size
-			if secret.Size == 0 || secret.Size%8 != 0 {
-				return nil, fmt.Errorf("invalid secret size: %v", name)
-			}
-
 			var generatedValue []byte
 			// If a secret is shared, we generate a completely random key. If a secret is constrained to a marble, we derive a key from the core's private key.
 			if secret.Shared {
@@ -351,7 +805,11 @@ func (c *Core) generateSecrets(ctx context.Context, secrets map[string]Secret, i
 				}
 			} else {
 				salt := id.String() + name
-				secretKeyDerive := c.privk.D.Bytes()
+				// rotated secrets are derived anew
+				if secret.Version > 1 {
+					salt += "/" + strconv.FormatUint(uint64(secret.Version), 10)
+				}
+				secretKeyDerive := c.derivationKey()
 				var err error
 				generatedValue, err = util.DeriveKey(secretKeyDerive, []byte(salt), secret.Size/8)
 				if err != nil {
@@ -380,10 +838,6 @@ func (c *Core) generateSecrets(ctx context.Context, secrets map[string]Secret, i
 			}
 
 		case "cert-ed25519":
-			if secret.Size != 0 {
-				return nil, fmt.Errorf("invalid secret size for cert-ed25519, none is expected. given: %v", name)
-			}
-
 			// Generate keys
 			pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
 			if err != nil {
@@ -409,9 +863,6 @@ func (c *Core) generateSecrets(ctx context.Context, secrets map[string]Secret, i
 				curve = elliptic.P384()
 			case 521:
 				curve = elliptic.P521()
-			default:
-				c.zaplogger.Error("ECDSA secrets only support P224, P256, P384 and P521 as curve. Check the supplied size.", zap.String("name", name), zap.String("type", secret.Type), zap.Uint("size", secret.Size))
-				return nil, fmt.Errorf("unsupported size %d: does not map to a supported curve", secret.Size)
 			}
 
 			// Generate keys
@@ -427,14 +878,20 @@ func (c *Core) generateSecrets(ctx context.Context, secrets map[string]Secret, i
 				return nil, err
 			}
 
-		default:
-			return nil, fmt.Errorf("unsupported secret of type %s", secret.Type)
 		}
 	}
 
 	return newSecrets, nil
 }
 
+// secretVersion returns the current version of the named secret.
+func (c *Core) secretVersion(name string) uint {
+	if version := c.secretVersions[name]; version > 0 {
+		return version
+	}
+	return 1
+}
+
 func (c *Core) generateCertificateForSecret(secret Secret, privKey crypto.PrivateKey, pubKey crypto.PublicKey) (Secret, error) {
 	// Load given information from manifest as template
 	template := x509.Certificate(secret.Cert)
@@ -469,7 +926,7 @@ func (c *Core) generateCertificateForSecret(secret Secret, privKey crypto.Privat
 	template.BasicConstraintsValid = true
 	template.NotBefore = time.Now()
 
-	// If NotAfter is not set, we will use ValidFor for the end of the certificate lifetime. If it set, we will use it (-> do not adjust it, it's already loaded). If both are set, we will throw an error as this will create ambiguity.
+	// If NotAfter is not set, we will use ValidFor for the end of the certificate lifetime. If it set, we will use it (-> do not adjust it, it's already loaded). Setting both is rejected by Secret.check.
 	if template.NotAfter.IsZero() {
 		// User can specify a duration in days, otherwise it's one year by default
 		if secret.ValidFor == 0 {
@@ -477,8 +934,6 @@ func (c *Core) generateCertificateForSecret(secret Secret, privKey crypto.Privat
 		}
 
 		template.NotAfter = time.Now().AddDate(0, 0, int(secret.ValidFor))
-	} else if secret.ValidFor != 0 {
-		return Secret{}, errors.New("ambigious certificate validity duration, both NotAfter and ValidFor are specified")
 	}
 
 	// Generate certificate with given public key
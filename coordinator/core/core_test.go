@@ -8,9 +8,23 @@ package core
 
 import (
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
 	"testing"
 
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
 	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/coordinator/recovery"
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
 	"github.com/edgelesssys/marblerun/test"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
@@ -35,13 +49,13 @@ func TestCore(t *testing.T) {
 
 	manifest := []byte(test.ManifestJSON)
 	// try to set broken manifest
-	_, err = c.SetManifest(context.TODO(), manifest[:len(manifest)-1])
+	_, err = c.SetManifest(context.TODO(), manifest[:len(manifest)-1], nil)
 	assert.Error(err)
 	// set manifest
-	_, err = c.SetManifest(context.TODO(), manifest)
+	_, err = c.SetManifest(context.TODO(), manifest, nil)
 	assert.NoError(err)
 	// set manifest a second time
-	_, err = c.SetManifest(context.TODO(), manifest)
+	_, err = c.SetManifest(context.TODO(), manifest, nil)
 	assert.Error(err)
 }
 
@@ -58,37 +72,120 @@ func TestSeal(t *testing.T) {
 	issuer := quote.NewMockIssuer()
 	sealer := &MockSealer{}
 
-	c, err := NewCore([]string{"localhost"}, validator, issuer, sealer, zapLogger)
+	c, err := NewCore([]string{"localhost"}, validator, issuer, sealer, nil, false, zapLogger)
 	require.NoError(err)
 
 	// Set manifest. This will seal the state.
-	_, err = c.SetManifest(context.TODO(), []byte(test.ManifestJSON))
+	_, err = c.SetManifest(context.TODO(), []byte(test.ManifestJSON), nil)
 	require.NoError(err)
 
 	// Get certificate and signature.
 	cert, err := c.GetTLSCertificate(nil)
 	assert.NoError(err)
-	signature := c.GetManifestSignature(context.TODO())
+	signature, _ := c.GetManifestSignature(context.TODO())
 
 	// Check sealing with a new core initialized with the sealed state.
-	c2, err := NewCore([]string{"localhost"}, validator, issuer, sealer, zapLogger)
+	c2, err := NewCore([]string{"localhost"}, validator, issuer, sealer, nil, false, zapLogger)
 	require.NoError(err)
 	assert.Equal(stateAcceptingMarbles, c2.state)
 
+	// the root certificate is sealed, the RA-TLS certificate is recreated
 	cert2, err := c2.GetTLSCertificate(nil)
 	assert.NoError(err)
-	assert.Equal(cert, cert2)
+	assert.Equal(cert.Certificate[1], cert2.Certificate[1])
 
-	_, err = c2.SetManifest(context.TODO(), []byte(test.ManifestJSON))
+	_, err = c2.SetManifest(context.TODO(), []byte(test.ManifestJSON), nil)
 	assert.Error(err)
 
 	// Check if the secret specified in the test manifest is unsealed correctly
 	assert.Equal(c.secrets, c2.secrets)
 
-	signature2 := c2.GetManifestSignature(context.TODO())
+	signature2, _ := c2.GetManifestSignature(context.TODO())
 	assert.Equal(signature, signature2, "manifest signature differs after restart")
 }
 
+func TestRATLSCertificate(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c := NewCoreWithMocks()
+	tlsCert, err := c.GetTLSCertificate(nil)
+	require.NoError(err)
+	require.Len(tlsCert.Certificate, 2)
+	assert.Equal(c.cert.Raw, tlsCert.Certificate[1])
+
+	// the RA-TLS certificate is signed by the root certificate and embeds the quote of the root certificate
+	raTLSCert, err := x509.ParseCertificate(tlsCert.Certificate[0])
+	require.NoError(err)
+	assert.NoError(raTLSCert.CheckSignatureFrom(c.cert))
+	assert.Equal(c.quote, quote.GetRATLSQuote(raTLSCert))
+	roots := x509.NewCertPool()
+	roots.AddCert(c.cert)
+	_, err = raTLSCert.Verify(x509.VerifyOptions{DNSName: "localhost", Roots: roots})
+	assert.NoError(err)
+
+	// no quote is embedded in simulation mode
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(err)
+	c, err = NewCore([]string{"localhost"}, quote.NewFailValidator(), quote.NewFailIssuer(), &MockSealer{}, nil, true, zapLogger)
+	require.NoError(err)
+	assert.Nil(quote.GetRATLSQuote(c.tlsCert.Leaf))
+}
+
+func TestSetSubjectAltNames(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c := NewCoreWithMocks()
+	roots := x509.NewCertPool()
+	roots.AddCert(c.cert)
+	verify := func(name string) error {
+		tlsCert, err := c.GetTLSCertificate(nil)
+		require.NoError(err)
+		_, err = tlsCert.Leaf.Verify(x509.VerifyOptions{DNSName: name, Roots: roots})
+		return err
+	}
+	assert.Error(verify("coordinator.example.com"))
+
+	// IP addresses are added as such and the certificate is only issued anew if the names change
+	changed, err := c.SetSubjectAltNames([]string{"localhost", "coordinator.example.com", "192.0.2.1"})
+	require.NoError(err)
+	assert.True(changed)
+	assert.NoError(verify("coordinator.example.com"))
+	assert.NoError(verify("192.0.2.1"))
+	assert.NoError(verify("127.0.0.1"))
+	assert.Equal([]string{"localhost", "coordinator.example.com"}, c.tlsCert.Leaf.DNSNames)
+	changed, err = c.SetSubjectAltNames([]string{"192.0.2.1", "coordinator.example.com", "localhost", ""})
+	require.NoError(err)
+	assert.False(changed)
+
+	changed, err = c.SetSubjectAltNames([]string{"localhost"})
+	require.NoError(err)
+	assert.True(changed)
+	assert.Error(verify("coordinator.example.com"))
+	assert.Error(verify("192.0.2.1"))
+}
+
+func TestSimulationMode(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(err)
+	defer zapLogger.Sync()
+
+	c, err := NewCore([]string{"localhost"}, quote.NewFailValidator(), quote.NewFailIssuer(), &MockSealer{}, nil, true, zapLogger)
+	require.NoError(err)
+	assert.True(c.inSimulationMode())
+	assert.Empty(c.quote)
+	assert.Equal([]string{SimulationOrganizationalUnit}, c.cert.Subject.OrganizationalUnit)
+
+	// a core that does not run in simulation mode does not mark its certificate
+	c = NewCoreWithMocks()
+	assert.False(c.inSimulationMode())
+	assert.Empty(c.cert.Subject.OrganizationalUnit)
+}
+
 func TestRecover(t *testing.T) {
 	assert := assert.New(t)
 	require := require.New(t)
@@ -102,30 +199,106 @@ func TestRecover(t *testing.T) {
 	issuer := quote.NewMockIssuer()
 	sealer := &MockSealer{}
 
-	c, err := NewCore([]string{"localhost"}, validator, issuer, sealer, zapLogger)
+	c, err := NewCore([]string{"localhost"}, validator, issuer, sealer, nil, false, zapLogger)
 	require.NoError(err)
 
 	// new core does not allow recover
 	key := make([]byte, 16)
-	assert.Error(c.Recover(context.TODO(), key))
+	_, err = c.Recover(context.TODO(), key, nil)
+	assert.Error(err)
 
 	// Set manifest. This will seal the state.
-	_, err = c.SetManifest(context.TODO(), []byte(test.ManifestJSON))
+	_, err = c.SetManifest(context.TODO(), []byte(test.ManifestJSON), nil)
 	require.NoError(err)
 
 	// core does not allow recover after manifest has been set
-	assert.Error(c.Recover(context.TODO(), key))
+	_, err = c.Recover(context.TODO(), key, nil)
+	assert.Error(err)
 
 	// Initialize new core and let unseal fail
 	sealer.unsealError = ErrEncryptionKey
-	c2, err := NewCore([]string{"localhost"}, validator, issuer, sealer, zapLogger)
+	c2, err := NewCore([]string{"localhost"}, validator, issuer, sealer, nil, false, zapLogger)
 	sealer.unsealError = nil
 	require.NoError(err)
 	require.Equal(stateRecovery, c2.state)
+	recoveryCert, err := c2.GetTLSCertificate(nil)
+	require.NoError(err)
+
+	// TLS handshakes may happen concurrently with the recovery
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_, _ = c2.GetTLSCertificate(nil)
+			_, _, _ = c2.GetCertQuote(context.TODO())
+		}
+	}()
 
 	// recover
-	assert.NoError(c2.Recover(context.TODO(), key))
+	remaining, err := c2.Recover(context.TODO(), key, nil)
+	<-done
+	assert.NoError(err)
+	assert.Equal(0, remaining)
+	assert.Equal(stateAcceptingMarbles, c2.state)
+
+	// the recovered root certificate is served
+	recoveredCert, err := c2.GetTLSCertificate(nil)
+	require.NoError(err)
+	assert.NotEqual(recoveryCert.Certificate[1], recoveredCert.Certificate[1])
+	assert.Equal(c.cert.Raw, recoveredCert.Certificate[1])
+}
+
+func TestRecoverAuthorization(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(err)
+	defer zapLogger.Sync()
+
+	validator := quote.NewMockValidator()
+	issuer := quote.NewMockIssuer()
+	sealer := &MockSealer{}
+
+	c, manifest := mustSetup()
+	c.sealer = sealer
+	manifest.Users = map[string]User{
+		"admin":  {Certificate: test.CertPEM(test.AdminCert), Roles: []string{"recoverer"}},
+		"admin2": {Certificate: test.CertPEM(test.SecondAdminCert), Roles: []string{"updater"}},
+	}
+	manifest.Roles = map[string]Role{
+		"recoverer": {ResourceType: "Recovery", Actions: []string{"Recover"}},
+		"updater":   updaterRole,
+	}
+	rawManifest, err := json.Marshal(manifest)
+	require.NoError(err)
+	_, err = c.SetManifest(context.TODO(), rawManifest, nil)
+	require.NoError(err)
+
+	sealer.unsealError = ErrEncryptionKey
+	c2, err := NewCore([]string{"localhost"}, validator, issuer, sealer, nil, false, zapLogger)
+	sealer.unsealError = nil
+	require.NoError(err)
+	require.Equal(stateRecovery, c2.state)
+
+	// only users permitted to recover may complete the recovery
+	key := make([]byte, 16)
+	for _, cert := range []*x509.Certificate{nil, test.SecondAdminCert} {
+		_, err = c2.Recover(context.TODO(), key, cert)
+		assert.Equal(ErrNotAuthorized, err)
+		assert.Equal(stateRecovery, c2.state)
+		assert.Empty(c2.manifest.Users, "the state must be discarded")
+		signature, _ := c2.GetManifestSignature(context.TODO())
+		assert.Empty(signature)
+	}
+
+	remaining, err := c2.Recover(context.TODO(), key, test.AdminCert)
+	require.NoError(err)
+	assert.Equal(0, remaining)
 	assert.Equal(stateAcceptingMarbles, c2.state)
+	signature, _ := c.GetManifestSignature(context.TODO())
+	signature2, _ := c2.GetManifestSignature(context.TODO())
+	assert.Equal(signature, signature2)
 }
 
 func TestGenerateSecrets(t *testing.T) {
@@ -205,3 +378,371 @@ func TestGenerateSecrets(t *testing.T) {
 	_, err = c.generateSecrets(context.TODO(), secretsECDSAWrongKeySize, uuid.Nil)
 	assert.Error(err)
 }
+
+func TestRecoverMultiParty(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(err)
+	defer zapLogger.Sync()
+
+	validator := quote.NewMockValidator()
+	issuer := quote.NewMockIssuer()
+	sealer := &MockSealer{}
+
+	c, err := NewCore([]string{"localhost"}, validator, issuer, sealer, nil, false, zapLogger)
+	require.NoError(err)
+
+	// set a manifest requiring 2 of 3 recovery key holders
+	privKeys := make(map[string]*rsa.PrivateKey)
+	var manifest Manifest
+	require.NoError(json.Unmarshal([]byte(test.ManifestJSON), &manifest))
+	manifest.RecoveryKeys = make(map[string]string)
+	for _, name := range []string{"alice", "bob", "carol"} {
+		privKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(err)
+		pubKey, err := x509.MarshalPKIXPublicKey(&privKey.PublicKey)
+		require.NoError(err)
+		privKeys[name] = privKey
+		manifest.RecoveryKeys[name] = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubKey}))
+	}
+	manifest.RecoveryThreshold = 2
+	rawManifest, err := json.Marshal(manifest)
+	require.NoError(err)
+
+	recoveryData, err := c.SetManifest(context.TODO(), rawManifest, nil)
+	require.NoError(err)
+	require.Len(recoveryData, 3)
+
+	// the key used by the mock sealer
+	expectedKey := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+	shares := make(map[string][]byte)
+	for name, data := range recoveryData {
+		shares[name], err = rsa.DecryptOAEP(sha256.New(), rand.Reader, privKeys[name], data, nil)
+		require.NoError(err)
+		// a single share must not reveal the key
+		assert.NotContains(string(shares[name]), string(expectedKey))
+	}
+
+	// Initialize new core and let unseal fail
+	sealer.unsealError = ErrEncryptionKey
+	c2, err := NewCore([]string{"localhost"}, validator, issuer, sealer, nil, false, zapLogger)
+	sealer.unsealError = nil
+	require.NoError(err)
+	require.Equal(stateRecovery, c2.state)
+
+	// forged shares cannot dictate the threshold or the number of shares
+	forged, err := recovery.Split(expectedKey, 255, 255)
+	require.NoError(err)
+	_, err = c2.Recover(context.TODO(), forged[0], nil)
+	assert.Error(err)
+	forged, err = recovery.Split(expectedKey, 4, 2)
+	require.NoError(err)
+	_, err = c2.Recover(context.TODO(), forged[3], nil)
+	assert.Error(err)
+
+	// first share is not enough
+	remaining, err := c2.Recover(context.TODO(), shares["carol"], nil)
+	require.NoError(err)
+	assert.Equal(1, remaining)
+	assert.Equal(stateRecovery, c2.state)
+
+	// uploading the same share again does not help
+	remaining, err = c2.Recover(context.TODO(), shares["carol"], nil)
+	require.NoError(err)
+	assert.Equal(1, remaining)
+
+	// second share completes the recovery
+	remaining, err = c2.Recover(context.TODO(), shares["alice"], nil)
+	require.NoError(err)
+	assert.Equal(0, remaining)
+	assert.Equal(stateAcceptingMarbles, c2.state)
+	assert.Equal(expectedKey, sealer.encryptionKey)
+}
+
+type recordingNotifier struct {
+	notifications []Notification
+}
+
+func (n *recordingNotifier) Notify(notification Notification) {
+	n.notifications = append(n.notifications, notification)
+}
+
+func TestNotifications(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, _ := mustSetup()
+	notifier := &recordingNotifier{}
+	c.AddNotifier(notifier)
+	assert.Empty(notifier.notifications)
+
+	_, err := c.SetManifest(context.TODO(), []byte(test.ManifestJSON), nil)
+	require.NoError(err)
+	require.Len(notifier.notifications, 1)
+	assert.Equal(NotificationManifestChanged, notifier.notifications[0].Event)
+	assert.NotEmpty(notifier.notifications[0].Details["ManifestSignature"])
+
+	// repeated activation failures are reported once
+	for i := 0; i < 2*activationFailureThreshold; i++ {
+		_, err = c.Activate(context.TODO(), &rpc.ActivationReq{MarbleType: "frontend"})
+		assert.Error(err)
+	}
+	require.Len(notifier.notifications, 2)
+	assert.Equal(NotificationActivationFailures, notifier.notifications[1].Event)
+	assert.Equal("frontend", notifier.notifications[1].Details["MarbleType"])
+
+	// a successful activation resets the count
+	c.recordActivationResult("frontend", false)
+	for i := 0; i < activationFailureThreshold; i++ {
+		c.recordActivationResult("frontend", true)
+	}
+	assert.Len(notifier.notifications, 3)
+
+	// a Core in recovery mode notifies immediately
+	sealer := c.sealer.(*MockSealer)
+	sealer.unsealError = ErrEncryptionKey
+	c2, err := NewCore([]string{"localhost"}, c.qv, c.qi, sealer, nil, false, c.zaplogger)
+	sealer.unsealError = nil
+	require.NoError(err)
+	c2.AddNotifier(notifier)
+	require.Len(notifier.notifications, 4)
+	assert.Equal(NotificationRecoveryMode, notifier.notifications[3].Event)
+}
+
+// testReplicator commits states like a cluster and applies them to the other Cores.
+type testReplicator struct {
+	states [][]byte
+	cores  []*Core
+}
+
+func (r *testReplicator) Replicate(state []byte, baseIndex uint64) (uint64, error) {
+	if baseIndex != uint64(len(r.states)) {
+		return 0, ErrStateConflict
+	}
+	r.states = append(r.states, state)
+	index := uint64(len(r.states))
+	for _, c := range r.cores {
+		if err := c.ApplyReplicatedState(state, index); err != nil {
+			return 0, err
+		}
+	}
+	return index, nil
+}
+
+func TestReplication(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c := NewCoreWithMocks()
+	c2 := NewCoreWithMocks()
+	replicator := &testReplicator{cores: []*Core{c2}}
+	c.SetReplicator(replicator)
+
+	// the state is replicated to the other Core
+	_, err := c.SetManifest(context.TODO(), []byte(test.ManifestJSON), nil)
+	require.NoError(err)
+	assert.Len(replicator.states, 1)
+	assert.Equal(stateAcceptingMarbles, c2.state)
+	signature, _ := c.GetManifestSignature(context.TODO())
+	signature2, _ := c2.GetManifestSignature(context.TODO())
+	assert.Equal(signature, signature2)
+	assert.Equal(c.cert.Raw, c2.cert.Raw)
+	assert.Equal(c.secrets, c2.secrets)
+	assert.EqualValues(1, c2.stateIndex)
+
+	// applying an old state again is ignored
+	require.NoError(c2.ApplyReplicatedState([]byte("invalid"), 1))
+
+	// a Core that is not based on the latest state cannot commit its changes
+	c3 := NewCoreWithMocks()
+	c3.SetReplicator(replicator)
+	_, err = c3.SetManifest(context.TODO(), []byte(test.ManifestJSON), nil)
+	assert.Error(err)
+	assert.Equal(stateAcceptingManifest, c3.state)
+	assert.Len(replicator.states, 1)
+
+	// it adopts the committed state instead
+	require.NoError(c3.ApplyReplicatedState(replicator.states[0], 1))
+	assert.Equal(stateAcceptingMarbles, c3.state)
+	assert.Equal(c.cert.Raw, c3.cert.Raw)
+
+	// a Core in recovery mode refuses the state of the cluster
+	sealer := &MockSealer{unsealError: ErrEncryptionKey}
+	c4, err := NewCore([]string{"localhost"}, quote.NewMockValidator(), quote.NewMockIssuer(), sealer, nil, false, zap.NewNop())
+	require.NoError(err)
+	require.Equal(stateRecovery, c4.state)
+	assert.Equal(ErrRecoveryMode, c4.ApplyReplicatedState(replicator.states[0], 1))
+	assert.Equal(stateRecovery, c4.state)
+}
+
+type mockCounter struct {
+	value        uint64
+	incrementErr error
+}
+
+func (c *mockCounter) Value() (uint64, error) {
+	return c.value, nil
+}
+
+func (c *mockCounter) Increment() (uint64, error) {
+	if c.incrementErr != nil {
+		return 0, c.incrementErr
+	}
+	c.value++
+	return c.value, nil
+}
+
+func TestRollbackProtection(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	zapLogger := zap.NewNop()
+	validator := quote.NewMockValidator()
+	issuer := quote.NewMockIssuer()
+	sealer := &MockSealer{}
+	counter := &mockCounter{}
+	newCore := func(sealer Sealer) *Core {
+		c, err := NewCore([]string{"localhost"}, validator, issuer, sealer, nil, false, zapLogger)
+		require.NoError(err)
+		require.NoError(c.SetMonotonicCounter(counter))
+		return c
+	}
+
+	c, err := NewCore([]string{"localhost"}, validator, issuer, sealer, nil, false, zapLogger)
+	require.NoError(err)
+	require.NoError(c.SetMonotonicCounter(counter))
+	_, err = c.SetManifest(context.TODO(), []byte(test.ManifestJSON), nil)
+	require.NoError(err)
+	assert.EqualValues(1, counter.value)
+	oldSealer := *sealer
+	oldCert := c.cert
+
+	c.mux.Lock()
+	_, err = c.sealState()
+	c.mux.Unlock()
+	require.NoError(err)
+	assert.EqualValues(2, counter.value)
+
+	// the latest state is accepted
+	assert.Equal(stateAcceptingMarbles, newCore(sealer).state)
+
+	// a state that has been sealed without incrementing the counter is accepted and the counter catches up
+	counter.incrementErr = errors.New("failed")
+	c.mux.Lock()
+	_, err = c.sealState()
+	c.mux.Unlock()
+	require.NoError(err)
+	assert.EqualValues(2, counter.value)
+	counter.incrementErr = nil
+	assert.Equal(stateAcceptingMarbles, newCore(sealer).state)
+	assert.EqualValues(3, counter.value)
+
+	// an older state is discarded and the Coordinator starts in recovery mode with a new root certificate
+	rolledBack := newCore(&oldSealer)
+	assert.Equal(stateRecovery, rolledBack.state)
+	assert.NotEqual(oldCert.Raw, rolledBack.cert.Raw)
+	assert.Nil(rolledBack.rawManifest)
+
+	// recovering the older state fails, unless the client accepts the rollback
+	_, err = rolledBack.Recover(context.TODO(), make([]byte, 16), nil)
+	assert.True(errors.Is(err, ErrRollback))
+	assert.Equal(stateRecovery, rolledBack.state)
+	_, err = rolledBack.AcceptRollback(context.TODO(), make([]byte, 16), nil)
+	require.NoError(err)
+	assert.Equal(stateAcceptingMarbles, rolledBack.state)
+	assert.Equal(oldCert.Raw, rolledBack.cert.Raw)
+	entries, err := rolledBack.verifiedAuditLog()
+	require.NoError(err)
+	last := entries[len(entries)-1]
+	assert.Equal(clientapi.AuditEventRecover, last.Event)
+	assert.Equal(map[string]string{"StateCounter": "1", "Counter": "2"}, last.Details)
+	// the recovered state is bound to the counter again
+	assert.EqualValues(3, counter.value)
+
+}
+
+// mockRootKeyStore holds keys whose private part isn't accessible to the Core.
+type mockRootKeyStore struct {
+	keys map[string]*ecdsa.PrivateKey
+}
+
+type mockStoredKey struct {
+	key *ecdsa.PrivateKey
+}
+
+func (k mockStoredKey) Public() crypto.PublicKey {
+	return k.key.Public()
+}
+
+func (k mockStoredKey) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return k.key.Sign(rand, digest, opts)
+}
+
+func (s *mockRootKeyStore) GenerateKey() (string, crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", nil, err
+	}
+	id := uuid.New().String()
+	s.keys[id] = key
+	return id, mockStoredKey{key}, nil
+}
+
+func (s *mockRootKeyStore) GetKey(id string) (crypto.Signer, error) {
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, errors.New("key not found")
+	}
+	return mockStoredKey{key}, nil
+}
+
+func TestRootKeyStore(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	zapLogger := zap.NewNop()
+	validator := quote.NewMockValidator()
+	issuer := quote.NewMockIssuer()
+	sealer := &MockSealer{}
+	rootKeys := &mockRootKeyStore{keys: map[string]*ecdsa.PrivateKey{}}
+
+	c, err := NewCore([]string{"localhost"}, validator, issuer, sealer, rootKeys, false, zapLogger)
+	require.NoError(err)
+	require.Len(rootKeys.keys, 1)
+	_, err = c.SetManifest(context.TODO(), []byte(test.ManifestJSON), nil)
+	require.NoError(err)
+
+	// only the ID of the root key is sealed
+	var state sealedState
+	require.NoError(json.Unmarshal(sealer.data, &state))
+	assert.Empty(state.Privk)
+	assert.NotEmpty(state.RootKeyID)
+	assert.Len(state.DerivationKey, 32)
+	for _, key := range rootKeys.keys {
+		assert.Equal(key.Public(), c.cert.PublicKey)
+	}
+
+	// the root key is retrieved from the store and the secrets are derived the same
+	c2, err := NewCore([]string{"localhost"}, validator, issuer, sealer, rootKeys, false, zapLogger)
+	require.NoError(err)
+	assert.Equal(c.cert.Raw, c2.cert.Raw)
+	assert.Equal(c.derivationKey(), c2.derivationKey())
+	cert, err := c2.GetTLSCertificate(nil)
+	require.NoError(err)
+	assert.NoError(cert.Leaf.CheckSignatureFrom(c.cert))
+
+	// the state can't be loaded without the store
+	_, err = NewCore([]string{"localhost"}, validator, issuer, sealer, nil, false, zapLogger)
+	assert.Error(err)
+
+	// nor if the store substitutes the root key
+	for id := range rootKeys.keys {
+		rootKeys.keys[id], err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(err)
+	}
+	_, err = NewCore([]string{"localhost"}, validator, issuer, sealer, rootKeys, false, zapLogger)
+	assert.Error(err)
+}
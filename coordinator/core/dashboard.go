@@ -0,0 +1,210 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"context"
+	"crypto/x509"
+	"sort"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+)
+
+// DefaultRecentActivations is the number of activations GetRecentActivations returns if no limit is given.
+const DefaultRecentActivations = 50
+
+// GetTopology returns the marble types of the mesh with their activations and the TLS tags that connect them.
+//
+// Like the other dashboard methods, it returns a read-only view that is tailored to a UI. clientCert is the TLS certificate of the client,
+// which must belong to one of the manifest's Users who is permitted to view the dashboard.
+func (c *Core) GetTopology(ctx context.Context, clientCert *x509.Certificate) (clientapi.Topology, error) {
+	defer c.mux.Unlock()
+	if err := c.requireDashboardViewer(clientCert); err != nil {
+		return clientapi.Topology{}, err
+	}
+
+	activeMarbles := make(map[string]uint)
+	for _, active := range c.activeMarbles {
+		activeMarbles[active.MarbleType]++
+	}
+	topology := clientapi.Topology{MarbleTypes: make(map[string]clientapi.TopologyMarbleType, len(c.manifest.Marbles))}
+	tagTypes := make(map[string][]string)
+	for name, marble := range c.manifest.Marbles {
+		marbleType := clientapi.TopologyMarbleType{
+			Package:        marble.Package,
+			Activations:    c.activations[name] - c.silentActivations(name),
+			MaxActivations: marble.MaxActivations,
+			ActiveMarbles:  activeMarbles[name],
+		}
+		for infrastructure, activations := range c.infrastructureActivations[name] {
+			if activations == 0 {
+				continue
+			}
+			if marbleType.Infrastructures == nil {
+				marbleType.Infrastructures = make(map[string]uint)
+			}
+			marbleType.Infrastructures[infrastructure] = activations
+		}
+		if marble.TLS != nil {
+			marbleType.TLSTags = marble.TLS.Tags
+			for _, tag := range marble.TLS.Tags {
+				tagTypes[tag] = append(tagTypes[tag], name)
+			}
+		}
+		topology.MarbleTypes[name] = marbleType
+	}
+
+	for tag, ttls := range c.manifest.TLS {
+		entry := clientapi.TopologyTLSTag{MarbleTypes: tagTypes[tag]}
+		sort.Strings(entry.MarbleTypes)
+		for _, outgoing := range ttls.Outgoing {
+			entry.Outgoing = append(entry.Outgoing, outgoing.Addr)
+		}
+		for _, incoming := range ttls.Incoming {
+			entry.Incoming = append(entry.Incoming, incoming.Port)
+		}
+		if topology.TLSTags == nil {
+			topology.TLSTags = make(map[string]clientapi.TopologyTLSTag)
+		}
+		topology.TLSTags[tag] = entry
+	}
+	return topology, nil
+}
+
+// GetRecentActivations returns the latest activations of the audit log, the most recent first. At most limit activations are returned,
+// or DefaultRecentActivations if limit is 0.
+func (c *Core) GetRecentActivations(ctx context.Context, limit uint, clientCert *x509.Certificate) ([]clientapi.Activation, error) {
+	defer c.mux.Unlock()
+	if err := c.requireDashboardViewer(clientCert); err != nil {
+		return nil, err
+	}
+	if limit == 0 {
+		limit = DefaultRecentActivations
+	}
+
+	activations := []clientapi.Activation{}
+	for i := len(c.auditLog) - 1; i >= 0 && uint(len(activations)) < limit; i-- {
+		entry := c.auditLog[i]
+		if entry.Event != clientapi.AuditEventActivate {
+			continue
+		}
+		activations = append(activations, clientapi.Activation{
+			Sequence:       entry.Sequence,
+			Time:           entry.Time,
+			MarbleType:     entry.Details["MarbleType"],
+			UUID:           entry.Details["UUID"],
+			Infrastructure: entry.Details["Infrastructure"],
+			Resumed:        entry.Details["Resumed"] == "true",
+		})
+	}
+	return activations, nil
+}
+
+// GetManifestSummary returns a summary of the active manifest with the measurements of its packages, but without the parameters
+// of the marbles and the values of secrets.
+func (c *Core) GetManifestSummary(ctx context.Context, clientCert *x509.Certificate) (clientapi.ManifestSummary, error) {
+	defer c.mux.Unlock()
+	if err := c.requireDashboardViewer(clientCert); err != nil {
+		return clientapi.ManifestSummary{}, err
+	}
+
+	summary := clientapi.ManifestSummary{
+		Packages:        c.manifest.Packages,
+		Infrastructures: c.manifest.Infrastructures,
+		MarbleTypes:     make(map[string]string, len(c.manifest.Marbles)),
+		UpdateThreshold: c.manifest.UpdateThreshold,
+	}
+	if n := len(c.manifestHistory); n > 0 {
+		latest := c.manifestHistory[n-1]
+		summary.Version, summary.ManifestSignature, summary.Signer = latest.Version, latest.ManifestSignature, latest.Signer
+	}
+	for name, marble := range c.manifest.Marbles {
+		summary.MarbleTypes[name] = marble.Package
+	}
+	for name, secret := range c.manifest.Secrets {
+		if summary.Secrets == nil {
+			summary.Secrets = make(map[string]string, len(c.manifest.Secrets))
+		}
+		summary.Secrets[name] = secret.Type
+	}
+	for name, user := range c.manifest.Users {
+		if summary.Users == nil {
+			summary.Users = make(map[string][]string, len(c.manifest.Users))
+		}
+		summary.Users[name] = append([]string{}, user.Roles...)
+	}
+	for name := range c.manifest.recoveryKeys() {
+		summary.RecoveryKeys = append(summary.RecoveryKeys, name)
+	}
+	sort.Strings(summary.RecoveryKeys)
+	return summary, nil
+}
+
+// GetTCBStatus returns the TCB requirements of the manifest's infrastructures, the activations that have been rejected because of
+// the TCB of their platform since the Coordinator started, and the status of the collateral the quotes are validated with.
+func (c *Core) GetTCBStatus(ctx context.Context, clientCert *x509.Certificate) (clientapi.TCBStatus, error) {
+	status, err := func() (clientapi.TCBStatus, error) {
+		defer c.mux.Unlock()
+		if err := c.requireDashboardViewer(clientCert); err != nil {
+			return clientapi.TCBStatus{}, err
+		}
+		status := clientapi.TCBStatus{Infrastructures: make(map[string]clientapi.InfrastructureTCB, len(c.manifest.Infrastructures))}
+		for name, infrastructure := range c.manifest.Infrastructures {
+			var activations uint
+			for _, perInfrastructure := range c.infrastructureActivations {
+				activations += perInfrastructure[name]
+			}
+			status.Infrastructures[name] = clientapi.InfrastructureTCB{
+				Type:                infrastructure.Type,
+				AcceptedTCBStatuses: infrastructure.AcceptedTCBStatuses,
+				AcceptedAdvisories:  infrastructure.AcceptedAdvisories,
+				Activations:         activations,
+			}
+		}
+		for marbleType, rejections := range c.tcbRejections {
+			if status.Rejections == nil {
+				status.Rejections = make(map[string]clientapi.TCBRejections, len(c.tcbRejections))
+			}
+			status.Rejections[marbleType] = rejections
+		}
+		return status, nil
+	}()
+	if err != nil {
+		return clientapi.TCBStatus{}, err
+	}
+	// the validator has its own lock
+	if reporter, ok := c.qv.(quote.CollateralReporter); ok {
+		status.Collateral = reporter.CollateralStatus()
+	}
+	return status, nil
+}
+
+// requireDashboardViewer checks that the Core has a manifest and that the client is permitted to view the dashboard.
+// Like requireState, it locks the Core.
+func (c *Core) requireDashboardViewer(clientCert *x509.Certificate) error {
+	if err := c.requireState(stateAcceptingMarbles); err != nil {
+		return err
+	}
+	user, ok := c.manifest.getUser(clientCert)
+	if !ok || !c.manifest.isPermitted(user, resourceDashboard, "", actionViewDashboard) {
+		return ErrNotAuthorized
+	}
+	return nil
+}
+
+// recordTCBRejection counts an activation of the marble type that has been rejected because of the TCB of its platform.
+func (c *Core) recordTCBRejection(marbleType string, reason string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	rejections := c.tcbRejections[marbleType]
+	rejections.Count++
+	rejections.Last = time.Now().UTC()
+	rejections.Reason = reason
+	c.tcbRejections[marbleType] = rejections
+}
@@ -0,0 +1,128 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"testing"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/edgelesssys/marblerun/test"
+	"github.com/edgelesssys/marblerun/util"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+func TestDashboard(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, manifest := mustSetup()
+
+	// the dashboard requires a manifest
+	_, err := c.GetTopology(context.TODO(), test.AdminCert)
+	assert.Error(err)
+
+	manifest.Users = map[string]User{
+		"viewer": {Certificate: test.CertPEM(test.AdminCert), Roles: []string{"dashboard"}},
+		"admin2": {Certificate: test.CertPEM(test.SecondAdminCert)},
+	}
+	manifest.Roles = map[string]Role{"dashboard": {ResourceType: "Dashboard", Actions: []string{"ViewDashboard"}}}
+	rawManifest, err := json.Marshal(manifest)
+	require.NoError(err)
+	_, err = c.SetManifest(context.TODO(), rawManifest, nil)
+	require.NoError(err)
+
+	// only users permitted to view the dashboard can view it
+	_, err = c.GetTopology(context.TODO(), test.SecondAdminCert)
+	assert.Equal(ErrNotAuthorized, err)
+	_, err = c.GetRecentActivations(context.TODO(), 0, test.SecondAdminCert)
+	assert.Equal(ErrNotAuthorized, err)
+	_, err = c.GetManifestSummary(context.TODO(), test.SecondAdminCert)
+	assert.Equal(ErrNotAuthorized, err)
+	_, err = c.GetTCBStatus(context.TODO(), test.SecondAdminCert)
+	assert.Equal(ErrNotAuthorized, err)
+
+	// a marble is rejected because of its TCB, then activated twice
+	cert, csr, _ := util.MustGenerateTestMarbleCredentials()
+	marbleQuote, err := c.qi.Issue(cert.Raw)
+	require.NoError(err)
+	infra := manifest.Infrastructures["Azure"]
+	lowInfra := infra
+	lowInfra.CPUSVN = append([]byte(nil), infra.CPUSVN...)
+	lowInfra.CPUSVN[5]--
+	c.qv.(*quote.MockValidator).AddValidQuote(marbleQuote, cert.Raw, manifest.Packages["frontend"], lowInfra)
+	ctx := peer.NewContext(context.TODO(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+	})
+	_, err = c.Activate(ctx, &rpc.ActivationReq{CSR: csr, MarbleType: "frontend", Quote: marbleQuote, UUID: uuid.New().String()})
+	require.Error(err)
+
+	uuids := []string{uuid.New().String(), uuid.New().String()}
+	for _, marbleUUID := range uuids {
+		cert, csr, _ := util.MustGenerateTestMarbleCredentials()
+		marbleQuote, err := c.qi.Issue(cert.Raw)
+		require.NoError(err)
+		c.qv.(*quote.MockValidator).AddValidQuote(marbleQuote, cert.Raw, manifest.Packages["frontend"], infra)
+		ctx := peer.NewContext(context.TODO(), &peer.Peer{
+			AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+		})
+		_, err = c.Activate(ctx, &rpc.ActivationReq{CSR: csr, MarbleType: "frontend", Quote: marbleQuote, UUID: marbleUUID})
+		require.NoError(err)
+	}
+
+	topology, err := c.GetTopology(context.TODO(), test.AdminCert)
+	require.NoError(err)
+	assert.Len(topology.MarbleTypes, len(manifest.Marbles))
+	frontend := topology.MarbleTypes["frontend"]
+	assert.Equal(manifest.Marbles["frontend"].Package, frontend.Package)
+	assert.EqualValues(2, frontend.Activations)
+	assert.EqualValues(2, frontend.ActiveMarbles)
+	assert.EqualValues(2, frontend.Infrastructures["Azure"])
+	assert.Empty(topology.MarbleTypes["backend_first"].Activations)
+
+	activations, err := c.GetRecentActivations(context.TODO(), 0, test.AdminCert)
+	require.NoError(err)
+	require.Len(activations, 2)
+	assert.Equal(uuids[1], activations[0].UUID)
+	assert.Equal(uuids[0], activations[1].UUID)
+	assert.Equal("frontend", activations[0].MarbleType)
+	assert.Equal("Azure", activations[0].Infrastructure)
+	assert.Greater(activations[0].Sequence, activations[1].Sequence)
+	activations, err = c.GetRecentActivations(context.TODO(), 1, test.AdminCert)
+	require.NoError(err)
+	require.Len(activations, 1)
+	assert.Equal(uuids[1], activations[0].UUID)
+
+	summary, err := c.GetManifestSummary(context.TODO(), test.AdminCert)
+	require.NoError(err)
+	assert.Zero(summary.Version)
+	assert.NotEmpty(summary.ManifestSignature)
+	assert.Equal(manifest.Packages, summary.Packages)
+	assert.Equal("frontend", summary.MarbleTypes["frontend"])
+	assert.Equal([]string{"dashboard"}, summary.Users["viewer"])
+	for name, secretType := range summary.Secrets {
+		assert.Equal(manifest.Secrets[name].Type, secretType)
+	}
+
+	tcbStatus, err := c.GetTCBStatus(context.TODO(), test.AdminCert)
+	require.NoError(err)
+	assert.EqualValues(2, tcbStatus.Infrastructures["Azure"].Activations)
+	rejections := tcbStatus.Rejections["frontend"]
+	assert.EqualValues(1, rejections.Count)
+	assert.Contains(rejections.Reason, "CPUSVN component 5 too low")
+	assert.False(rejections.Last.IsZero())
+	// the mock validator has no collateral
+	assert.Empty(tcbStatus.Collateral)
+}
@@ -0,0 +1,161 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrEventsDropped occurs if a client doesn't receive the events of the mesh as fast as they happen. The client must watch the events again.
+var ErrEventsDropped = errors.New("the events have not been received fast enough")
+
+// eventBufferSize is the number of events that are buffered for each subscriber. Subscribers that fall further behind are dropped.
+const eventBufferSize = 100
+
+// eventSubscriber is a client that watches the events of the mesh.
+type eventSubscriber struct {
+	// marbleTypes are the marble types whose events the subscriber receives, it receives the events of all types if it is empty
+	marbleTypes map[string]bool
+	// events receives the events. It is closed if the subscriber has been dropped.
+	events chan clientapi.Event
+}
+
+// SubscribeEvents subscribes to the events of the mesh, i.e., the activations, failed activations and revocations of marbles and the changes of the manifest.
+//
+// clientCert is the TLS certificate of the client, which must belong to one of the manifest's Users who is permitted to list marbles.
+// If marbleTypes isn't empty, only the events of these marble types and the events that don't concern marbles are received.
+// The returned channel is closed if the events aren't received fast enough, see ErrEventsDropped. The returned function ends the subscription.
+func (c *Core) SubscribeEvents(ctx context.Context, marbleTypes []string, clientCert *x509.Certificate) (<-chan clientapi.Event, func(), error) {
+	defer c.mux.Unlock()
+	if err := c.requireState(stateAcceptingMarbles); err != nil {
+		return nil, nil, err
+	}
+	user, ok := c.manifest.getUser(clientCert)
+	if !ok || !c.manifest.isPermitted(user, resourceMarbles, "", actionListMarbles) {
+		return nil, nil, ErrNotAuthorized
+	}
+
+	subscriber := &eventSubscriber{marbleTypes: make(map[string]bool), events: make(chan clientapi.Event, eventBufferSize)}
+	for _, marbleType := range marbleTypes {
+		subscriber.marbleTypes[marbleType] = true
+	}
+	if c.eventSubscribers == nil {
+		c.eventSubscribers = make(map[*eventSubscriber]struct{})
+	}
+	c.eventSubscribers[subscriber] = struct{}{}
+	c.requestLogger(ctx).Info("client watches the events", zap.String("user", user), zap.Strings("marbleTypes", marbleTypes))
+
+	unsubscribe := func() {
+		c.mux.Lock()
+		defer c.mux.Unlock()
+		delete(c.eventSubscribers, subscriber)
+	}
+	return subscriber.events, unsubscribe, nil
+}
+
+// publishEvent sends the event to the subscribers that watch it. The Core must be locked.
+//
+// Sending doesn't block. Subscribers whose buffers are full are dropped, so that slow clients can't stall the Core.
+func (c *Core) publishEvent(event clientapi.Event) {
+	event.Time = time.Now().UTC()
+	for subscriber := range c.eventSubscribers {
+		if event.MarbleType != "" && len(subscriber.marbleTypes) > 0 && !subscriber.marbleTypes[event.MarbleType] {
+			continue
+		}
+		select {
+		case subscriber.events <- event:
+		default:
+			delete(c.eventSubscribers, subscriber)
+			close(subscriber.events)
+			c.zaplogger.Warn("dropped a client that doesn't receive the events fast enough")
+		}
+	}
+}
+
+// publishActivationResult publishes the successful or failed activation of a marble.
+func (c *Core) publishActivationResult(marbleType string, marbleUUID string, infrastructure string, err error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	event := clientapi.Event{Type: clientapi.EventActivation, MarbleType: marbleType, UUID: marbleUUID, Infrastructure: infrastructure}
+	if err != nil {
+		event.Type = clientapi.EventActivationFailure
+		event.Reason, _ = rpc.ErrorReason(err)
+		event.Message = status.Convert(err).Message()
+	}
+	c.publishEvent(event)
+}
+
+// publishRevocation publishes the revocation of the certificates of the marbles of a type or of a single marble. The Core must be locked.
+func (c *Core) publishRevocation(marbleType string, marbleUUID string, revoked int) {
+	if marbleType == "" {
+		// the certificates of a single marble have been revoked, which all belong to its type
+		for _, cert := range c.marbleCerts {
+			if cert.UUID == marbleUUID {
+				marbleType = cert.MarbleType
+				break
+			}
+		}
+	}
+	c.publishEvent(clientapi.Event{
+		Type:       clientapi.EventRevocation,
+		MarbleType: marbleType,
+		UUID:       marbleUUID,
+		Message:    fmt.Sprintf("%v certificates have been revoked", revoked),
+	})
+}
+
+// WatchEvents implements the function of the Events service to stream the events of the mesh (implements the EventsServer interface)
+//
+// The client authenticates with the certificate of one of the manifest's Users who is permitted to list marbles, see SubscribeEvents.
+// The stream ends with a ResourceExhausted error if the client doesn't receive the events fast enough.
+func (c *Core) WatchEvents(req *rpc.WatchEventsReq, stream rpc.Events_WatchEventsServer) error {
+	ctx := stream.Context()
+	clientCert := getClientTLSCert(ctx)
+	if clientCert == nil {
+		return status.Error(codes.Unauthenticated, "couldn't get client TLS certificate")
+	}
+	events, unsubscribe, err := c.SubscribeEvents(ctx, req.GetMarbleTypes(), clientCert)
+	if err == ErrNotAuthorized {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err != nil {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, ErrEventsDropped.Error())
+			}
+			if err := stream.Send(&rpc.Event{
+				Type:           event.Type,
+				Time:           event.Time.Format(time.RFC3339Nano),
+				MarbleType:     event.MarbleType,
+				UUID:           event.UUID,
+				Infrastructure: event.Infrastructure,
+				Reason:         event.Reason,
+				Message:        event.Message,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/edgelesssys/marblerun/test"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribeEvents(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, manifest := mustSetup()
+	manifest.Users = map[string]User{
+		"admin":  {Certificate: test.CertPEM(test.AdminCert), Roles: []string{"operator"}},
+		"admin2": {Certificate: test.CertPEM(test.SecondAdminCert)},
+	}
+	manifest.Roles = map[string]Role{"operator": {ResourceType: "Marbles", Actions: []string{"ListMarbles", "RevokeMarble"}}}
+	rawManifest, err := json.Marshal(manifest)
+	require.NoError(err)
+
+	// events can only be watched once the manifest is set
+	_, _, err = c.SubscribeEvents(context.TODO(), nil, test.AdminCert)
+	assert.Error(err)
+	_, err = c.SetManifest(context.TODO(), rawManifest, nil)
+	require.NoError(err)
+
+	// only permitted users can watch the events
+	_, _, err = c.SubscribeEvents(context.TODO(), nil, test.SecondAdminCert)
+	assert.Equal(ErrNotAuthorized, err)
+	all, unsubscribeAll, err := c.SubscribeEvents(context.TODO(), nil, test.AdminCert)
+	require.NoError(err)
+	defer unsubscribeAll()
+	backends, unsubscribeBackends, err := c.SubscribeEvents(context.TODO(), []string{"backend_first"}, test.AdminCert)
+	require.NoError(err)
+	defer unsubscribeBackends()
+
+	spawner := marbleSpawner{
+		assert:     assert,
+		require:    require,
+		issuer:     c.qi,
+		validator:  c.qv.(*quote.MockValidator),
+		manifest:   *manifest,
+		coreServer: c,
+	}
+	spawner.newMarble("frontend", "Azure", true)
+	event := <-all
+	assert.Equal(clientapi.EventActivation, event.Type)
+	assert.Equal("frontend", event.MarbleType)
+	assert.Equal("Azure", event.Infrastructure)
+	_, err = uuid.Parse(event.UUID)
+	assert.NoError(err)
+	assert.False(event.Time.IsZero())
+
+	// failed activations of unknown types are reported with the same label as in the metrics
+	_, err = c.Activate(context.TODO(), &rpc.ActivationReq{MarbleType: "invalid", UUID: uuid.New().String()})
+	require.Error(err)
+	event = <-all
+	assert.Equal(clientapi.EventActivationFailure, event.Type)
+	assert.Equal(unknownMarbleType, event.MarbleType)
+	assert.NotEmpty(event.Message)
+
+	_, err = c.RevokeMarble(context.TODO(), "frontend", "", test.AdminCert)
+	require.NoError(err)
+	event = <-all
+	assert.Equal(clientapi.EventRevocation, event.Type)
+	assert.Equal("frontend", event.MarbleType)
+
+	// the subscriber of the backends only received the events that don't concern other marble types
+	select {
+	case event := <-backends:
+		assert.Fail("unexpected event", event)
+	default:
+	}
+	spawner.newMarble("backend_first", "Azure", true)
+	assert.Equal("backend_first", (<-backends).MarbleType)
+	assert.Equal("backend_first", (<-all).MarbleType)
+
+	// subscribers that don't receive the events fast enough are dropped
+	for i := 0; i <= eventBufferSize; i++ {
+		c.mux.Lock()
+		c.publishEvent(clientapi.Event{Type: clientapi.EventManifestChange})
+		c.mux.Unlock()
+	}
+	received := 0
+	for range all {
+		received++
+	}
+	assert.Equal(eventBufferSize, received)
+	c.mux.Lock()
+	assert.Empty(c.eventSubscribers)
+	c.mux.Unlock()
+}
@@ -0,0 +1,197 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+	"github.com/edgelesssys/marblerun/util"
+	"go.uber.org/zap"
+)
+
+// defaultExternalValidFor is the maximum validity of the certificates of external services in days if the manifest doesn't set one.
+const defaultExternalValidFor = 90
+
+// ExternalService describes a service that is not a marble, but may obtain certificates that chain into the Coordinator's root certificate.
+//
+// The service is not attested. Its certificates are issued to Users who are permitted to issue them, which vouch for the service.
+type ExternalService struct {
+	// DNSNames are the DNS names the certificates may contain. A name that starts with "*." allows any single label in its place, e.g., "*.web.svc" allows "web-0.web.svc".
+	DNSNames []string `json:",omitempty"`
+	// IPAddresses are the IP addresses the certificates may contain.
+	IPAddresses []string `json:",omitempty"`
+	// MaxValidFor is the maximum validity of the certificates in days, 90 by default. Shorter validities may be requested.
+	MaxValidFor uint `json:",omitempty"`
+}
+
+// check verifies that certificates can be issued for the service.
+func (s ExternalService) check() error {
+	if len(s.DNSNames) == 0 && len(s.IPAddresses) == 0 {
+		return errors.New("neither DNSNames nor IPAddresses are set")
+	}
+	for _, name := range s.DNSNames {
+		if strings.TrimPrefix(name, "*.") == "" || strings.Contains(strings.TrimPrefix(name, "*."), "*") {
+			return fmt.Errorf("invalid DNS name %q", name)
+		}
+	}
+	for _, addr := range s.IPAddresses {
+		if net.ParseIP(addr) == nil {
+			return fmt.Errorf("invalid IP address %q", addr)
+		}
+	}
+	return nil
+}
+
+// permitsDNSName returns true if the service's certificates may contain the DNS name.
+func (s ExternalService) permitsDNSName(name string) bool {
+	for _, pattern := range s.DNSNames {
+		if pattern == name {
+			return true
+		}
+		if suffix := strings.TrimPrefix(pattern, "*"); suffix != pattern {
+			label := strings.TrimSuffix(name, suffix)
+			if label != name && label != "" && !strings.Contains(label, ".") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// permitsIPAddress returns true if the service's certificates may contain the IP address.
+func (s ExternalService) permitsIPAddress(ip net.IP) bool {
+	for _, addr := range s.IPAddresses {
+		if net.ParseIP(addr).Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IssueCertificate issues a certificate for the public key and the subject alternative names of the PEM-encoded CSR to one of the manifest's ExternalServices.
+//
+// clientCert is the TLS certificate of the client, which must belong to one of the manifest's Users who is permitted to issue certificates for the service.
+// The CSR may only request DNS names and IP addresses the service permits. validFor is the requested validity; 0 or a longer validity than the service's MaxValidFor yields MaxValidFor.
+// Returns the PEM-encoded certificate, followed by the certificates that issued the root certificate if it has been provided by the operator, and the PEM-encoded root certificate.
+func (c *Core) IssueCertificate(ctx context.Context, service string, csrPEM []byte, validFor time.Duration, clientCert *x509.Certificate) ([]byte, []byte, error) {
+	defer c.mux.Unlock()
+	if err := c.requireState(stateAcceptingMarbles); err != nil {
+		return nil, nil, err
+	}
+	user, ok := c.manifest.getUser(clientCert)
+	if !ok || !c.manifest.isPermitted(user, resourceExternalServices, service, actionIssueCertificate) {
+		return nil, nil, ErrNotAuthorized
+	}
+	externalService, ok := c.manifest.ExternalServices[service]
+	if !ok {
+		return nil, nil, fmt.Errorf("external service %s is not defined in the manifest", service)
+	}
+
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || (block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST") {
+		return nil, nil, errors.New("no PEM-encoded CSR found")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid CSR: %v", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, nil, fmt.Errorf("invalid signature of CSR: %v", err)
+	}
+	if len(csr.DNSNames) == 0 && len(csr.IPAddresses) == 0 {
+		return nil, nil, errors.New("the CSR requests neither DNS names nor IP addresses")
+	}
+	if len(csr.URIs) > 0 || len(csr.EmailAddresses) > 0 {
+		return nil, nil, errors.New("the CSR may only request DNS names and IP addresses")
+	}
+	for _, name := range csr.DNSNames {
+		if !externalService.permitsDNSName(name) {
+			return nil, nil, fmt.Errorf("external service %s doesn't permit DNS name %s", service, name)
+		}
+	}
+	for _, ip := range csr.IPAddresses {
+		if !externalService.permitsIPAddress(ip) {
+			return nil, nil, fmt.Errorf("external service %s doesn't permit IP address %s", service, ip)
+		}
+	}
+
+	maxValidFor := externalService.MaxValidFor
+	if maxValidFor == 0 {
+		maxValidFor = defaultExternalValidFor
+	}
+	notBefore := time.Now()
+	notAfter := notBefore.AddDate(0, 0, int(maxValidFor))
+	if validFor > 0 && notBefore.Add(validFor).Before(notAfter) {
+		notAfter = notBefore.Add(validFor)
+	}
+	if notAfter.After(c.cert.NotAfter) {
+		notAfter = c.cert.NotAfter
+	}
+
+	serialNumber, err := util.GenerateCertificateSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	keyUsage := x509.KeyUsageDigitalSignature
+	if _, ok := csr.PublicKey.(*rsa.PublicKey); ok {
+		keyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName:         service,
+			Organization:       c.cert.Subject.Organization,
+			OrganizationalUnit: c.cert.Subject.OrganizationalUnit,
+		},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              keyUsage,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              csr.DNSNames,
+		IPAddresses:           csr.IPAddresses,
+	}
+	certRaw, err := x509.CreateCertificate(rand.Reader, &template, c.cert, csr.PublicKey, c.privk)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the certificate is only returned once its issuance has been recorded
+	oldAuditLog := c.auditLog
+	details := map[string]string{"Service": service, "SerialNumber": serialNumber.String()}
+	if len(csr.DNSNames) > 0 {
+		details["DNSNames"] = strings.Join(csr.DNSNames, ",")
+	}
+	if len(csr.IPAddresses) > 0 {
+		ips := make([]string, len(csr.IPAddresses))
+		for i, ip := range csr.IPAddresses {
+			ips[i] = ip.String()
+		}
+		details["IPAddresses"] = strings.Join(ips, ",")
+	}
+	c.appendAuditEntry(ctx, clientapi.AuditEventIssueCertificate, user, details)
+	if _, err := c.sealState(); err != nil {
+		c.auditLog = oldAuditLog
+		c.zaplogger.Error("sealState failed", zap.Error(err))
+		return nil, nil, err
+	}
+	c.requestLogger(ctx).Info("certificate issued", zap.String("service", service), zap.String("user", user), zap.Strings("dnsNames", csr.DNSNames))
+
+	certPEM := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certRaw}), c.issuerChainPEM()...)
+	return certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), nil
+}
@@ -0,0 +1,112 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+	"github.com/edgelesssys/marblerun/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIssueCertificate(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, manifest := mustSetup()
+	manifest.ExternalServices = map[string]ExternalService{
+		"web":   {DNSNames: []string{"web.example.com", "*.web.svc"}, IPAddresses: []string{"192.0.2.1"}, MaxValidFor: 10},
+		"other": {DNSNames: []string{"other.example.com"}},
+	}
+	manifest.Users = map[string]User{
+		"issuer": {Certificate: test.CertPEM(test.AdminCert), Roles: []string{"web-issuer"}},
+		"admin2": {Certificate: test.CertPEM(test.SecondAdminCert)},
+	}
+	manifest.Roles = map[string]Role{"web-issuer": {ResourceType: "ExternalServices", ResourceNames: []string{"web"}, Actions: []string{"IssueCertificate"}}}
+	rawManifest, err := json.Marshal(manifest)
+	require.NoError(err)
+	_, err = c.SetManifest(context.TODO(), rawManifest, nil)
+	require.NoError(err)
+
+	privk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+	newCSR := func(template x509.CertificateRequest) []byte {
+		csr, err := x509.CreateCertificateRequest(rand.Reader, &template, privk)
+		require.NoError(err)
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})
+	}
+	csr := newCSR(x509.CertificateRequest{DNSNames: []string{"web.example.com", "web-0.web.svc"}, IPAddresses: []net.IP{net.ParseIP("192.0.2.1")}})
+
+	// only users permitted for the service can issue certificates
+	_, _, err = c.IssueCertificate(context.TODO(), "web", csr, 0, test.SecondAdminCert)
+	assert.Equal(ErrNotAuthorized, err)
+	_, _, err = c.IssueCertificate(context.TODO(), "other", newCSR(x509.CertificateRequest{DNSNames: []string{"other.example.com"}}), 0, test.AdminCert)
+	assert.Equal(ErrNotAuthorized, err)
+
+	certPEM, caPEM, err := c.IssueCertificate(context.TODO(), "web", csr, 24*time.Hour, test.AdminCert)
+	require.NoError(err)
+	block, _ := pem.Decode(certPEM)
+	require.NotNil(block)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(err)
+	block, _ = pem.Decode(caPEM)
+	require.NotNil(block)
+	assert.Equal(c.cert.Raw, block.Bytes)
+	assert.NoError(cert.CheckSignatureFrom(c.cert))
+	assert.Equal([]string{"web.example.com", "web-0.web.svc"}, cert.DNSNames)
+	assert.WithinDuration(time.Now().Add(24*time.Hour), cert.NotAfter, time.Minute)
+
+	// the validity is limited by the manifest
+	certPEM, _, err = c.IssueCertificate(context.TODO(), "web", csr, 0, test.AdminCert)
+	require.NoError(err)
+	block, _ = pem.Decode(certPEM)
+	cert, err = x509.ParseCertificate(block.Bytes)
+	require.NoError(err)
+	assert.WithinDuration(time.Now().AddDate(0, 0, 10), cert.NotAfter, time.Minute)
+
+	// only the names of the service are permitted
+	for _, template := range []x509.CertificateRequest{
+		{DNSNames: []string{"other.example.com"}},
+		{DNSNames: []string{"a.b.web.svc"}},
+		{DNSNames: []string{"web.svc"}},
+		{IPAddresses: []net.IP{net.ParseIP("192.0.2.2")}},
+		{},
+	} {
+		_, _, err = c.IssueCertificate(context.TODO(), "web", newCSR(template), 0, test.AdminCert)
+		assert.Error(err)
+	}
+
+	// issued certificates are recorded in the audit log
+	entries, err := c.verifiedAuditLog()
+	require.NoError(err)
+	last := entries[len(entries)-1]
+	assert.Equal(clientapi.AuditEventIssueCertificate, last.Event)
+	assert.Equal("issuer", last.User)
+	assert.Equal("web", last.Details["Service"])
+}
+
+func TestExternalServiceCheck(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(ExternalService{DNSNames: []string{"*.web.svc"}}.check())
+	assert.NoError(ExternalService{IPAddresses: []string{"2001:db8::1"}}.check())
+	assert.Error(ExternalService{}.check())
+	assert.Error(ExternalService{DNSNames: []string{"*."}}.check())
+	assert.Error(ExternalService{DNSNames: []string{"web.*.svc"}}.check())
+	assert.Error(ExternalService{IPAddresses: []string{"web"}}.check())
+}
@@ -0,0 +1,214 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+)
+
+// ErrHandedOff occurs if the state is changed after it has been handed off to a new Coordinator.
+var ErrHandedOff = errors.New("the state has been handed off to a new Coordinator")
+
+// handoffKeySize is the size of the RSA key a new Coordinator receives the state with.
+const handoffKeySize = 3072
+
+// handoffState is the payload of a clientapi.Handoff.
+type handoffState struct {
+	EncryptionKey []byte
+	State         []byte
+}
+
+// errHandedOff is returned to marbles that try to activate after the state has been handed off.
+func errHandedOff() error {
+	return rpc.ActivationError(codes.Unavailable, rpc.ReasonHandedOff, nil, "the Coordinator has handed off its state to a new Coordinator")
+}
+
+// HandoffRequest returns the request with which this Coordinator takes over the state of a running one, see HandOff and AcceptHandoff.
+//
+// It contains a new RSA key, which the state is encrypted with, and a quote over the key. The key is kept until the next call.
+func (c *Core) HandoffRequest() (clientapi.HandoffRequest, error) {
+	defer c.mux.Unlock()
+	if err := c.requireState(stateAcceptingManifest, stateRecovery); err != nil {
+		return clientapi.HandoffRequest{}, err
+	}
+	key, err := rsa.GenerateKey(rand.Reader, handoffKeySize)
+	if err != nil {
+		return clientapi.HandoffRequest{}, err
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return clientapi.HandoffRequest{}, err
+	}
+	req := clientapi.HandoffRequest{PublicKey: publicKey}
+	if !c.inSimulationMode() {
+		if req.Quote, err = c.qi.Issue(publicKey); err != nil {
+			return clientapi.HandoffRequest{}, fmt.Errorf("failed to issue a quote over the handoff key: %v", err)
+		}
+	}
+	c.handoffKey = key
+	return req, nil
+}
+
+// HandOff hands off the state to the new Coordinator that has created req, e.g., to upgrade the Coordinator without downtime.
+//
+// clientCert is the TLS certificate of the client, which must belong to one of the manifest's Users who is permitted to hand off the state.
+// The quote of req must report the same package as this Coordinator's quote. If the package is identified by its SignerID, a newer
+// SecurityVersion of it is accepted, too, so that the state can be handed off to an upgraded Coordinator.
+// The state and its encryption key are encrypted with the key of req, so that only the attested Coordinator can decrypt them.
+//
+// The handoff is recorded in the audit log. Afterwards, this Coordinator rejects changes of the state, reports that it isn't ready
+// and can be stopped. If the new Coordinator fails to accept the state, restart this Coordinator to continue with it.
+func (c *Core) HandOff(ctx context.Context, req clientapi.HandoffRequest, clientCert *x509.Certificate) (clientapi.Handoff, error) {
+	defer c.mux.Unlock()
+	if err := c.requireState(stateAcceptingMarbles); err != nil {
+		return clientapi.Handoff{}, err
+	}
+	if c.handedOff {
+		return clientapi.Handoff{}, ErrHandedOff
+	}
+	user, ok := c.manifest.getUser(clientCert)
+	if !ok || !c.manifest.isPermitted(user, resourceRecovery, "", actionHandOff) {
+		return clientapi.Handoff{}, ErrNotAuthorized
+	}
+	parsedKey, err := x509.ParsePKIXPublicKey(req.PublicKey)
+	if err != nil {
+		return clientapi.Handoff{}, fmt.Errorf("invalid handoff key: %v", err)
+	}
+	publicKey, ok := parsedKey.(*rsa.PublicKey)
+	if !ok {
+		return clientapi.Handoff{}, errors.New("handoff key is not an RSA key")
+	}
+	var details map[string]string
+	if !c.inSimulationMode() {
+		props, err := c.validateHandoffQuote(req)
+		if err != nil {
+			return clientapi.Handoff{}, err
+		}
+		if props.UniqueID != "" {
+			details = map[string]string{"UniqueID": props.UniqueID}
+		}
+	}
+
+	oldAuditLog := c.auditLog
+	c.appendAuditEntry(ctx, clientapi.AuditEventHandOff, user, details)
+	encryptionKey, err := c.sealState()
+	if err != nil {
+		c.auditLog = oldAuditLog
+		c.zaplogger.Error("sealState failed", zap.Error(err))
+		return clientapi.Handoff{}, err
+	}
+	stateRaw, err := c.marshalState()
+	if err != nil {
+		return clientapi.Handoff{}, err
+	}
+	payload, err := json.Marshal(handoffState{EncryptionKey: encryptionKey, State: stateRaw})
+	if err != nil {
+		return clientapi.Handoff{}, err
+	}
+
+	key := make([]byte, backupKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return clientapi.Handoff{}, err
+	}
+	var handoff clientapi.Handoff
+	if handoff.Key, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, key, nil); err != nil {
+		return clientapi.Handoff{}, err
+	}
+	if handoff.State, err = encryptBackupState(key, payload, req.PublicKey); err != nil {
+		return clientapi.Handoff{}, err
+	}
+
+	// from now on, the new Coordinator continues the state
+	c.handedOff = true
+	if c.readinessListener != nil {
+		c.readinessListener(false)
+	}
+	c.requestLogger(ctx).Info("state handed off", zap.String("user", user))
+	return handoff, nil
+}
+
+// validateHandoffQuote validates the quote of a new Coordinator over its handoff key and returns the package properties it reports.
+func (c *Core) validateHandoffQuote(req clientapi.HandoffRequest) (quote.PackageProperties, error) {
+	// the new Coordinator must run the same package as this one
+	required, err := c.ownPackageProperties()
+	if err != nil {
+		return quote.PackageProperties{}, err
+	}
+	props, err := quote.ValidateReport(c.qv, req.Quote, req.PublicKey, required, quote.InfrastructureProperties{})
+	if err != nil {
+		return quote.PackageProperties{}, fmt.Errorf("attesting the new Coordinator failed: %v", err)
+	}
+	return props, nil
+}
+
+// ownPackageProperties returns the package properties that the Coordinator's own quote reports, which other Coordinators must satisfy
+// to take over its state.
+func (c *Core) ownPackageProperties() (quote.PackageProperties, error) {
+	props, err := quote.ValidateReport(c.qv, c.quote, c.cert.Raw, quote.PackageProperties{}, quote.InfrastructureProperties{})
+	if err != nil {
+		return quote.PackageProperties{}, fmt.Errorf("failed to validate the quote of the Coordinator: %v", err)
+	}
+	if reflect.DeepEqual(props, quote.PackageProperties{}) {
+		return quote.PackageProperties{}, errors.New("the quote of the Coordinator does not report its package properties")
+	}
+	// a package that is identified by its signer may be upgraded, i.e., its UniqueID changes and its SecurityVersion must not decrease
+	if props.SignerID != "" {
+		props.UniqueID = ""
+	}
+	return props, nil
+}
+
+// AcceptHandoff continues with the state that a running Coordinator has handed off to this one in response to HandoffRequest.
+//
+// The state keeps its encryption key, so the recovery data of the running Coordinator stays valid. from is the address of the
+// running Coordinator, which is only logged. The handoff has been recorded in the audit log by the running Coordinator.
+func (c *Core) AcceptHandoff(ctx context.Context, handoff clientapi.Handoff, from string) error {
+	defer c.mux.Unlock()
+	if err := c.requireState(stateAcceptingManifest, stateRecovery); err != nil {
+		return err
+	}
+	if c.handoffKey == nil {
+		return errors.New("no handoff has been requested")
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&c.handoffKey.PublicKey)
+	if err != nil {
+		return err
+	}
+	key, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, c.handoffKey, handoff.Key, nil)
+	if err != nil {
+		return fmt.Errorf("cannot decrypt the handoff key: %v", err)
+	}
+	payload, err := decryptBackupState(key, handoff.State, publicKey)
+	if err != nil {
+		return errors.New("cannot decrypt the handed off state")
+	}
+	var state handoffState
+	if err := json.Unmarshal(payload, &state); err != nil {
+		return err
+	}
+
+	if _, err := c.adoptState(state.State, state.EncryptionKey, nil); err != nil {
+		return err
+	}
+	c.handoffKey = nil
+	c.requestLogger(ctx).Info("took over the state of the running Coordinator", zap.String("from", from))
+	return nil
+}
@@ -0,0 +1,113 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestHandOff(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	validator := quote.NewMockValidator()
+	issuer := quote.NewMockIssuer()
+	c, err := NewCore([]string{"localhost"}, validator, issuer, &MockSealer{}, nil, false, zap.NewNop())
+	require.NoError(err)
+	var manifest Manifest
+	require.NoError(json.Unmarshal([]byte(test.ManifestJSON), &manifest))
+	manifest.Users = map[string]User{
+		"admin":  {Certificate: test.CertPEM(test.AdminCert), Roles: []string{"handoff"}},
+		"admin2": {Certificate: test.CertPEM(test.SecondAdminCert)},
+	}
+	manifest.Roles = map[string]Role{"handoff": {ResourceType: "Recovery", Actions: []string{"HandOff"}}}
+	rawManifest, err := json.Marshal(manifest)
+	require.NoError(err)
+	_, err = c.SetManifest(context.TODO(), rawManifest, nil)
+	require.NoError(err)
+
+	productID := uint64(3)
+	securityVersion := uint(2)
+	oldProps := quote.PackageProperties{UniqueID: "old", SignerID: "signer", ProductID: &productID, SecurityVersion: &securityVersion}
+	validator.AddValidQuote(c.quote, c.cert.Raw, oldProps, quote.InfrastructureProperties{})
+
+	newSealer := &MockSealer{}
+	c2, err := NewCore([]string{"localhost"}, validator, issuer, newSealer, nil, false, zap.NewNop())
+	require.NoError(err)
+	req, err := c2.HandoffRequest()
+	require.NoError(err)
+
+	// only permitted users can hand off the state
+	_, err = c.HandOff(context.TODO(), req, test.SecondAdminCert)
+	assert.Equal(ErrNotAuthorized, err)
+
+	// the new Coordinator must be attested to run the same package in the same or a newer version
+	_, err = c.HandOff(context.TODO(), req, test.AdminCert)
+	assert.Error(err)
+	olderVersion := uint(1)
+	validator.AddValidQuote(req.Quote, req.PublicKey, quote.PackageProperties{UniqueID: "older", SignerID: "signer", ProductID: &productID, SecurityVersion: &olderVersion}, quote.InfrastructureProperties{})
+	_, err = c.HandOff(context.TODO(), req, test.AdminCert)
+	assert.Error(err)
+	otherProduct := uint64(4)
+	validator.AddValidQuote(req.Quote, req.PublicKey, quote.PackageProperties{UniqueID: "new", SignerID: "signer", ProductID: &otherProduct, SecurityVersion: &securityVersion}, quote.InfrastructureProperties{})
+	_, err = c.HandOff(context.TODO(), req, test.AdminCert)
+	assert.Error(err)
+	ready, _ := c.IsReady(context.TODO())
+	assert.True(ready)
+
+	newerVersion := uint(3)
+	validator.AddValidQuote(req.Quote, req.PublicKey, quote.PackageProperties{UniqueID: "new", SignerID: "signer", ProductID: &productID, SecurityVersion: &newerVersion}, quote.InfrastructureProperties{})
+	handoff, err := c.HandOff(context.TODO(), req, test.AdminCert)
+	require.NoError(err)
+	entries, err := c.verifiedAuditLog()
+	require.NoError(err)
+	assert.Equal(clientapi.AuditEventHandOff, entries[len(entries)-1].Event)
+	assert.Equal("admin", entries[len(entries)-1].User)
+	assert.Equal("new", entries[len(entries)-1].Details["UniqueID"])
+
+	// the old Coordinator doesn't change its state anymore
+	ready, _ = c.IsReady(context.TODO())
+	assert.False(ready)
+	_, err = c.sealState()
+	assert.True(errors.Is(err, ErrHandedOff))
+	_, err = c.HandOff(context.TODO(), req, test.AdminCert)
+	assert.True(errors.Is(err, ErrHandedOff))
+
+	// the state can only be decrypted by the Coordinator that requested it
+	c3, err := NewCore([]string{"localhost"}, validator, issuer, &MockSealer{}, nil, false, zap.NewNop())
+	require.NoError(err)
+	assert.Error(c3.AcceptHandoff(context.TODO(), handoff, "old"))
+	_, err = c3.HandoffRequest()
+	require.NoError(err)
+	assert.Error(c3.AcceptHandoff(context.TODO(), handoff, "old"))
+	assert.Equal(stateAcceptingManifest, c3.state)
+
+	require.NoError(c2.AcceptHandoff(context.TODO(), handoff, "old"))
+	assert.Equal(stateAcceptingMarbles, c2.state)
+	assert.Equal(c.cert.Raw, c2.cert.Raw)
+	assert.Equal(c.rawManifest, c2.rawManifest)
+	assert.Equal(c.secrets["symmetric_key_shared"].Private, c2.secrets["symmetric_key_shared"].Private)
+	assert.Equal(c2.cert.Raw, c2.tlsCert.Certificate[1])
+
+	// the new Coordinator continues with the encryption key and the audit log of the old one
+	assert.Equal([]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}, newSealer.encryptionKey)
+	handedOff, err := c2.verifiedAuditLog()
+	require.NoError(err)
+	assert.Equal(entries, handedOff)
+	ready, _ = c2.IsReady(context.TODO())
+	assert.True(ready)
+}
@@ -0,0 +1,101 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"context"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// heartbeatInterval is the interval in which activated marbles send heartbeats to the Coordinator.
+const heartbeatInterval = 30 * time.Second
+
+// Heartbeat implements the MarbleAPI function to report that an activated marble is still alive (implements the MarbleServer interface)
+//
+// The marble authenticates with its marble certificate, which must not be revoked.
+// Returns the interval in which the Coordinator expects the heartbeats.
+// Returns NotFound if the activation of the marble has been released because it has been silent for longer than the HeartbeatTTL of its type.
+func (c *Core) Heartbeat(ctx context.Context, req *rpc.HeartbeatReq) (*rpc.HeartbeatResp, error) {
+	logger := c.requestLogger(ctx)
+	tlsCert := getClientTLSCert(ctx)
+	if tlsCert == nil {
+		return nil, status.Error(codes.Unauthenticated, "couldn't get marble TLS certificate")
+	}
+
+	defer c.mux.Unlock()
+	if err := c.requireState(stateAcceptingMarbles); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, "cannot accept heartbeats in current state")
+	}
+
+	if _, err := c.verifyMarbleCert(tlsCert); err != nil {
+		logger.Info("Rejected heartbeat", zap.Error(err))
+		return nil, status.Error(codes.Unauthenticated, "invalid marble certificate")
+	}
+	marbleUUID, err := uuid.Parse(tlsCert.Subject.CommonName)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid marble certificate")
+	}
+	if c.isRevokedMarble(marbleUUID.String()) {
+		return nil, status.Error(codes.Unauthenticated, "marble certificate has been revoked")
+	}
+
+	marble, ok := c.activeMarbles[marbleUUID.String()]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "the activation of the marble has been released")
+	}
+	marble.LastSeen = time.Now()
+	c.activeMarbles[marbleUUID.String()] = marble
+	heartbeats.WithLabelValues(marble.MarbleType, marble.Infrastructure).Inc()
+	return &rpc.HeartbeatResp{Interval: uint32(heartbeatInterval / time.Second)}, nil
+}
+
+// releaseSilentMarbles releases the activations of the marbles of the type that have been silent for longer than the type's HeartbeatTTL,
+// so that they don't count towards its MaxActivations anymore.
+//
+// The release is persisted with the next sealing of the state. If the Coordinator restarts before, it is repeated once the marbles have been silent for the TTL again.
+func (c *Core) releaseSilentMarbles(marbleType string) {
+	ttl := c.manifest.Marbles[marbleType].heartbeatTTL()
+	if ttl == 0 {
+		return
+	}
+	now := time.Now()
+	for marbleUUID, marble := range c.activeMarbles {
+		if marble.MarbleType != marbleType || now.Sub(marble.LastSeen) <= ttl {
+			continue
+		}
+		if c.activations[marbleType] >= marble.Activations {
+			c.activations[marbleType] -= marble.Activations
+		} else {
+			c.activations[marbleType] = 0
+		}
+		c.releaseInfrastructureActivations(marbleType, marble.Infrastructure, marble.Activations)
+		delete(c.activeMarbles, marbleUUID)
+		c.zaplogger.Info("Released activation of silent marble", zap.String("MarbleType", marbleType), zap.String("UUID", marbleUUID), zap.Time("LastSeen", marble.LastSeen))
+	}
+}
+
+// silentActivations returns the number of activations of the type that belong to marbles that have been silent for longer than the type's HeartbeatTTL.
+func (c *Core) silentActivations(marbleType string) uint {
+	ttl := c.manifest.Marbles[marbleType].heartbeatTTL()
+	if ttl == 0 {
+		return 0
+	}
+	now := time.Now()
+	var silent uint
+	for _, marble := range c.activeMarbles {
+		if marble.MarbleType == marbleType && now.Sub(marble.LastSeen) > ttl {
+			silent += marble.Activations
+		}
+	}
+	return silent
+}
@@ -0,0 +1,207 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+)
+
+// ErrUnknownManifestVersion occurs if a version is not in the manifest history.
+var ErrUnknownManifestVersion = errors.New("no such version of the manifest")
+
+// recordManifestVersion appends the current version of the manifest to the manifest history.
+//
+// It is called after the change has been recorded in the audit log, and the version takes the time of that entry.
+func (c *Core) recordManifestVersion(event string, signer string, acknowledgedBy []string) {
+	c.manifestHistory = append(c.manifestHistory, clientapi.ManifestVersion{
+		Version:           uint(len(c.rawUpdates)),
+		Time:              c.auditLog[len(c.auditLog)-1].Time,
+		Event:             event,
+		ManifestSignature: hex.EncodeToString(clientapi.ManifestSignature(c.rawManifest, c.rawUpdates)),
+		Signer:            signer,
+		AcknowledgedBy:    acknowledgedBy,
+	})
+}
+
+// manifestHistoryFromAuditLog reconstructs the manifest history of a state that has been sealed before the history was kept.
+func manifestHistoryFromAuditLog(auditLog []clientapi.AuditEntry) []clientapi.ManifestVersion {
+	var history []clientapi.ManifestVersion
+	for _, entry := range auditLog {
+		if entry.Event != clientapi.AuditEventSetManifest && entry.Event != clientapi.AuditEventUpdateManifest {
+			continue
+		}
+		version := clientapi.ManifestVersion{
+			Version:           uint(len(history)),
+			Time:              entry.Time,
+			Event:             entry.Event,
+			ManifestSignature: entry.Details["ManifestSignature"],
+			Signer:            entry.Details["Signer"],
+		}
+		if acknowledgedBy := entry.Details["AcknowledgedBy"]; acknowledgedBy != "" {
+			version.AcknowledgedBy = strings.Split(acknowledgedBy, ",")
+		}
+		history = append(history, version)
+	}
+	return history
+}
+
+// GetManifestHistory returns the versions of the manifest in the order they have been set and updated.
+//
+// clientCert is the TLS certificate of the client, which must belong to one of the manifest's Users.
+func (c *Core) GetManifestHistory(ctx context.Context, clientCert *x509.Certificate) ([]clientapi.ManifestVersion, error) {
+	defer c.mux.Unlock()
+	if err := c.requireState(stateAcceptingMarbles); err != nil {
+		return nil, err
+	}
+	if _, ok := c.manifest.getUser(clientCert); !ok {
+		return nil, ErrNotAuthorized
+	}
+	return append([]clientapi.ManifestVersion{}, c.manifestHistory...), nil
+}
+
+// GetManifestDiff returns the changes between two versions of the manifest history, sorted by path.
+//
+// clientCert is the TLS certificate of the client, which must belong to one of the manifest's Users.
+func (c *Core) GetManifestDiff(ctx context.Context, from uint, to uint, clientCert *x509.Certificate) ([]clientapi.ManifestChange, error) {
+	defer c.mux.Unlock()
+	if err := c.requireState(stateAcceptingMarbles); err != nil {
+		return nil, err
+	}
+	if _, ok := c.manifest.getUser(clientCert); !ok {
+		return nil, ErrNotAuthorized
+	}
+	oldManifest, err := c.manifestVersion(from)
+	if err != nil {
+		return nil, err
+	}
+	newManifest, err := c.manifestVersion(to)
+	if err != nil {
+		return nil, err
+	}
+	return diffManifests(oldManifest, newManifest)
+}
+
+// manifestVersion returns the manifest with the first version updates applied.
+func (c *Core) manifestVersion(version uint) (Manifest, error) {
+	if version > uint(len(c.rawUpdates)) {
+		return Manifest{}, ErrUnknownManifestVersion
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(c.rawManifest, &manifest); err != nil {
+		return Manifest{}, err
+	}
+	for _, rawUpdate := range c.rawUpdates[:version] {
+		var update Manifest
+		if err := json.Unmarshal(rawUpdate, &update); err != nil {
+			return Manifest{}, err
+		}
+		var err error
+		if manifest, err = manifest.applyUpdate(update); err != nil {
+			return Manifest{}, err
+		}
+	}
+	return manifest, nil
+}
+
+// diffManifests compares the JSON encodings of two manifests and returns the values that have been added, removed or changed.
+//
+// Objects are compared member by member, all other values, including arrays, as a whole.
+func diffManifests(oldManifest, newManifest Manifest) ([]clientapi.ManifestChange, error) {
+	oldValue, err := genericJSON(oldManifest)
+	if err != nil {
+		return nil, err
+	}
+	newValue, err := genericJSON(newManifest)
+	if err != nil {
+		return nil, err
+	}
+	changes := []clientapi.ManifestChange{}
+	if err := diffJSON("$", oldValue, newValue, &changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+func diffJSON(path string, oldValue, newValue interface{}, changes *[]clientapi.ManifestChange) error {
+	oldObject, oldIsObject := oldValue.(map[string]interface{})
+	newObject, newIsObject := newValue.(map[string]interface{})
+	// an empty object may be encoded as null
+	if oldIsObject && newValue == nil || newIsObject && oldValue == nil {
+		oldIsObject, newIsObject = true, true
+	}
+	if oldIsObject && newIsObject {
+		keys := make([]string, 0, len(oldObject)+len(newObject))
+		for key := range oldObject {
+			keys = append(keys, key)
+		}
+		for key := range newObject {
+			if _, ok := oldObject[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			oldMember, inOld := oldObject[key]
+			newMember, inNew := newObject[key]
+			if !inOld || !inNew {
+				change := clientapi.ManifestChange{Path: jsonPath(path, key)}
+				var err error
+				if inOld {
+					change.Old, err = json.Marshal(oldMember)
+				} else {
+					change.New, err = json.Marshal(newMember)
+				}
+				if err != nil {
+					return err
+				}
+				*changes = append(*changes, change)
+				continue
+			}
+			if err := diffJSON(jsonPath(path, key), oldMember, newMember, changes); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if reflect.DeepEqual(oldValue, newValue) {
+		return nil
+	}
+	rawOld, err := json.Marshal(oldValue)
+	if err != nil {
+		return err
+	}
+	rawNew, err := json.Marshal(newValue)
+	if err != nil {
+		return err
+	}
+	*changes = append(*changes, clientapi.ManifestChange{Path: path, Old: rawOld, New: rawNew})
+	return nil
+}
+
+// genericJSON returns the JSON encoding of value decoded into maps, slices and json.Numbers.
+func genericJSON(value interface{}) (interface{}, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var generic interface{}
+	err = decoder.Decode(&generic)
+	return generic, err
+}
@@ -0,0 +1,93 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+	"github.com/edgelesssys/marblerun/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifestHistory(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, manifest := mustSetup()
+	manifest.Users = map[string]User{"admin": {Certificate: test.CertPEM(test.AdminCert), Roles: []string{"updater"}}}
+	manifest.Roles = map[string]Role{"updater": updaterRole}
+	rawManifest, err := json.Marshal(manifest)
+	require.NoError(err)
+
+	_, err = c.GetManifestHistory(context.TODO(), test.AdminCert)
+	assert.Error(err)
+	_, err = c.GetManifestDiff(context.TODO(), 0, 0, test.AdminCert)
+	assert.Error(err)
+
+	_, err = c.SetManifest(context.TODO(), rawManifest, nil)
+	require.NoError(err)
+	_, err = c.UpdateManifest(context.TODO(), []byte(test.UpdateManifestJSON), test.AdminCert)
+	require.NoError(err)
+
+	history, err := c.GetManifestHistory(context.TODO(), test.AdminCert)
+	require.NoError(err)
+	require.Len(history, 2)
+	assert.EqualValues(0, history[0].Version)
+	assert.Equal(clientapi.AuditEventSetManifest, history[0].Event)
+	assert.Equal(hex.EncodeToString(clientapi.ManifestSignature(rawManifest, nil)), history[0].ManifestSignature)
+	assert.EqualValues(1, history[1].Version)
+	assert.Equal(clientapi.AuditEventUpdateManifest, history[1].Event)
+	assert.Equal([]string{"admin"}, history[1].AcknowledgedBy)
+	signature, _ := c.GetManifestSignature(context.TODO())
+	assert.Equal(hex.EncodeToString(signature), history[1].ManifestSignature)
+
+	// the diff contains the values that the update added or changed
+	changes, err := c.GetManifestDiff(context.TODO(), 0, 1, test.AdminCert)
+	require.NoError(err)
+	paths := make(map[string]clientapi.ManifestChange)
+	for _, change := range changes {
+		paths[change.Path] = change
+	}
+	assert.Contains(paths, "$.Marbles.newmarble")
+	assert.Nil(paths["$.Marbles.newmarble"].Old)
+	assert.Contains(paths, "$.Packages.newpackage")
+	assert.Equal(json.RawMessage("3"), paths["$.Packages.frontend.SecurityVersion"].Old)
+	assert.Equal(json.RawMessage("5"), paths["$.Packages.frontend.SecurityVersion"].New)
+	assert.NotContains(paths, "$.Marbles.frontend")
+
+	// in reverse, the values are removed
+	changes, err = c.GetManifestDiff(context.TODO(), 1, 0, test.AdminCert)
+	require.NoError(err)
+	assert.Len(changes, len(paths))
+	changes, err = c.GetManifestDiff(context.TODO(), 1, 1, test.AdminCert)
+	require.NoError(err)
+	assert.Empty(changes)
+
+	_, err = c.GetManifestDiff(context.TODO(), 0, 2, test.AdminCert)
+	assert.Equal(ErrUnknownManifestVersion, err)
+	_, err = c.GetManifestDiff(context.TODO(), 0, 1, nil)
+	assert.Equal(ErrNotAuthorized, err)
+	_, err = c.GetManifestHistory(context.TODO(), nil)
+	assert.Equal(ErrNotAuthorized, err)
+	_, err = c.GetManifestHistory(context.TODO(), test.SecondAdminCert)
+	assert.Equal(ErrNotAuthorized, err)
+
+	// the history survives a restart
+	c2, err := NewCore([]string{"localhost"}, c.qv, c.qi, c.sealer, nil, false, c.zaplogger)
+	require.NoError(err)
+	restoredHistory, err := c2.GetManifestHistory(context.TODO(), test.AdminCert)
+	require.NoError(err)
+	assert.Equal(history, restoredHistory)
+
+	// states that have been sealed without history derive it from the audit log
+	assert.Equal(history, manifestHistoryFromAuditLog(c.auditLog))
+}
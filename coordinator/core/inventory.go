@@ -0,0 +1,237 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/util"
+	"go.uber.org/zap"
+)
+
+// ErrUnknownMarble occurs if a marble is requested that hasn't been activated or whose activation has been released.
+var ErrUnknownMarble = errors.New("no activated marble with this UUID")
+
+// activeMarble is an activated marble, whose activations count towards the MaxActivations of its type.
+type activeMarble struct {
+	MarbleType string
+	// Activations is the number of times the marble has been activated with its UUID
+	Activations uint
+	// Package, PackageProperties and Infrastructure describe the marble's last activation.
+	// PackageProperties are the properties reported by the marble's quote, which are empty in simulation mode.
+	Package           string
+	PackageProperties quote.PackageProperties
+	Infrastructure    string
+	ActivatedAt       time.Time
+	// LastSeen is the time of the marble's last activation or heartbeat.
+	// It isn't sealed, so that heartbeats don't need to seal the state. Marbles of a loaded state are considered seen at the time of loading.
+	LastSeen time.Time `json:"-"`
+}
+
+// GetActivatedMarbles returns the inventory of the activated marbles, or only the marble with the UUID if it isn't empty.
+//
+// clientCert is the TLS certificate of the client, which must belong to one of the manifest's Users who is permitted to list marbles.
+// Marbles whose activations have been released are omitted. The marbles are sorted by type and UUID.
+func (c *Core) GetActivatedMarbles(ctx context.Context, marbleUUID string, clientCert *x509.Certificate) ([]clientapi.Marble, error) {
+	defer c.mux.Unlock()
+	if err := c.requireState(stateAcceptingMarbles); err != nil {
+		return nil, err
+	}
+	user, ok := c.manifest.getUser(clientCert)
+	if !ok || !c.manifest.isPermitted(user, resourceMarbles, "", actionListMarbles) {
+		return nil, ErrNotAuthorized
+	}
+
+	marbles := []clientapi.Marble{}
+	for id, active := range c.activeMarbles {
+		if marbleUUID != "" && id != marbleUUID {
+			continue
+		}
+		marble := clientapi.Marble{
+			UUID:              id,
+			MarbleType:        active.MarbleType,
+			Package:           active.Package,
+			PackageProperties: active.PackageProperties,
+			Infrastructure:    active.Infrastructure,
+			Activations:       active.Activations,
+			ActivatedAt:       active.ActivatedAt,
+			LastSeen:          active.LastSeen.UTC(),
+		}
+		// the records are in the order the certificates have been issued
+		for _, cert := range c.marbleCerts {
+			if cert.UUID == id {
+				marble.CertificateSerial = cert.Serial.String()
+				marble.CertificateNotAfter = cert.NotAfter
+				marble.Revoked = cert.revoked()
+			}
+		}
+		marbles = append(marbles, marble)
+	}
+	if marbleUUID != "" && len(marbles) == 0 {
+		return nil, ErrUnknownMarble
+	}
+
+	sort.Slice(marbles, func(i, j int) bool {
+		if marbles[i].MarbleType != marbles[j].MarbleType {
+			return marbles[i].MarbleType < marbles[j].MarbleType
+		}
+		return marbles[i].UUID < marbles[j].UUID
+	})
+	return marbles, nil
+}
+
+// recordActivation records the activation of a marble with the UUID and the package properties reported by its quote and returns a function that undoes it.
+func (c *Core) recordActivation(marbleType string, marbleUUID string, infrastructure string, reportedProps quote.PackageProperties, resumed bool) func() {
+	// a resumed marble already holds its activation
+	if !resumed {
+		c.activations[marbleType]++
+		c.addInfrastructureActivations(marbleType, infrastructure, 1)
+	}
+	prev, existed := c.activeMarbles[marbleUUID]
+	now := time.Now()
+	c.activeMarbles[marbleUUID] = activeMarble{
+		MarbleType:        marbleType,
+		Activations:       prev.Activations + 1,
+		Package:           c.manifest.Marbles[marbleType].Package,
+		PackageProperties: reportedProps,
+		Infrastructure:    infrastructure,
+		ActivatedAt:       now.UTC(),
+		LastSeen:          now,
+	}
+
+	return func() {
+		if !resumed {
+			c.activations[marbleType]--
+			c.releaseInfrastructureActivations(marbleType, infrastructure, 1)
+		}
+		if existed {
+			c.activeMarbles[marbleUUID] = prev
+		} else {
+			delete(c.activeMarbles, marbleUUID)
+		}
+	}
+}
+
+// resumptionToken returns the token with which the marble of the type and UUID resumes its activation.
+//
+// The token is derived from the Coordinator's derivation key, so that it stays valid across restarts of the Coordinator.
+func (c *Core) resumptionToken(marbleType string, marbleUUID string) ([]byte, error) {
+	// the salt is longer than a UUID, so the key can't be any marble's sealing key
+	key, err := util.DeriveKey(c.derivationKey(), []byte("marble resumption token"), 32)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(marbleType + "/" + marbleUUID))
+	return mac.Sum(nil), nil
+}
+
+// isResumption returns true if the marble of the type and UUID is still activated on the infrastructure and the token has been issued to it.
+// Such an activation doesn't count towards the MaxActivations of the type, so that restarting marbles don't exhaust them.
+//
+// Needs to be called with the lock held.
+func (c *Core) isResumption(marbleType string, marbleUUID string, infrastructure string, token []byte) bool {
+	if len(token) == 0 {
+		return false
+	}
+	active, ok := c.activeMarbles[marbleUUID]
+	if !ok || active.MarbleType != marbleType || active.Infrastructure != infrastructure {
+		return false
+	}
+	expected, err := c.resumptionToken(marbleType, marbleUUID)
+	return err == nil && hmac.Equal(token, expected)
+}
+
+// addInfrastructureActivations adds n activations of the marble type on the infrastructure.
+func (c *Core) addInfrastructureActivations(marbleType string, infrastructure string, n uint) {
+	if c.infrastructureActivations == nil {
+		c.infrastructureActivations = make(map[string]map[string]uint)
+	}
+	if c.infrastructureActivations[marbleType] == nil {
+		c.infrastructureActivations[marbleType] = make(map[string]uint)
+	}
+	c.infrastructureActivations[marbleType][infrastructure] += n
+}
+
+// releaseInfrastructureActivations releases n activations of the marble type on the infrastructure.
+//
+// The counter may be lower than n if it has been reset, it doesn't drop below 0 then.
+func (c *Core) releaseInfrastructureActivations(marbleType string, infrastructure string, n uint) {
+	activations := c.infrastructureActivations[marbleType]
+	if activations[infrastructure] <= n {
+		delete(activations, infrastructure)
+		return
+	}
+	activations[infrastructure] -= n
+}
+
+// ResetActivations sets the activation counter of the marble type to activations, or the counter of its activations on the infrastructure if it isn't empty.
+//
+// clientCert is the TLS certificate of the client, which must belong to one of the manifest's Users who is permitted to reset activations.
+// Setting the counter to 0 permits MaxActivations (or InfrastructureMaxActivations) new activations, e.g., after marbles have crashed without
+// releasing their activations. A higher value than the current one reduces the number of remaining activations. Activated marbles are not affected.
+func (c *Core) ResetActivations(ctx context.Context, marbleType string, infrastructure string, activations uint, clientCert *x509.Certificate) error {
+	defer c.mux.Unlock()
+	if err := c.requireState(stateAcceptingMarbles); err != nil {
+		return err
+	}
+	user, ok := c.manifest.getUser(clientCert)
+	if !ok || !c.manifest.isPermitted(user, resourceMarbles, "", actionResetActivations) {
+		return ErrNotAuthorized
+	}
+	if _, ok := c.manifest.Marbles[marbleType]; !ok {
+		return fmt.Errorf("unknown marble type: %v", marbleType)
+	}
+	if _, ok := c.manifest.Infrastructures[infrastructure]; infrastructure != "" && !ok {
+		return fmt.Errorf("unknown infrastructure: %v", infrastructure)
+	}
+
+	oldActivations := c.activations[marbleType]
+	oldInfrastructureActivations := c.infrastructureActivations[marbleType][infrastructure]
+	previous := oldActivations
+	if infrastructure == "" {
+		c.activations[marbleType] = activations
+	} else {
+		previous = oldInfrastructureActivations
+		c.releaseInfrastructureActivations(marbleType, infrastructure, oldInfrastructureActivations)
+		c.addInfrastructureActivations(marbleType, infrastructure, activations)
+	}
+	undo := func() {
+		c.activations[marbleType] = oldActivations
+		if infrastructure != "" {
+			c.releaseInfrastructureActivations(marbleType, infrastructure, activations)
+			c.addInfrastructureActivations(marbleType, infrastructure, oldInfrastructureActivations)
+		}
+	}
+
+	oldAuditLog := c.auditLog
+	details := map[string]string{"MarbleType": marbleType, "Activations": strconv.FormatUint(uint64(activations), 10), "Previous": strconv.FormatUint(uint64(previous), 10)}
+	if infrastructure != "" {
+		details["Infrastructure"] = infrastructure
+	}
+	c.appendAuditEntry(ctx, clientapi.AuditEventResetActivations, user, details)
+	if _, err := c.sealState(); err != nil {
+		undo()
+		c.auditLog = oldAuditLog
+		c.zaplogger.Error("sealState failed", zap.Error(err))
+		return err
+	}
+
+	c.requestLogger(ctx).Info("activations reset", zap.String("user", user), zap.String("MarbleType", marbleType), zap.String("Infrastructure", infrastructure),
+		zap.Uint("Activations", activations), zap.Uint("Previous", previous))
+	return nil
+}
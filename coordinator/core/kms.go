@@ -0,0 +1,164 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"bytes"
+	"crypto/rand"
+
+	"github.com/edgelesssys/ertgolib/ertcrypto"
+	"github.com/edgelesssys/marblerun/coordinator/core/store"
+)
+
+// WrappedKeyFname contains the key under which the encryption key is stored in the store, wrapped with a KMS key
+const WrappedKeyFname string = "wrapped_key"
+
+// KMS is a key management service that wraps the state encryption key with a key that never leaves the service.
+type KMS interface {
+	WrapKey(key []byte) ([]byte, error)
+	UnwrapKey(wrappedKey []byte) ([]byte, error)
+}
+
+// KMSSealer implements the Sealer interface by wrapping the encryption key with a KMS
+//
+// If it has another sealer, it uses that sealer for the state and stores the wrapped key in addition.
+// The wrapped key is used if the other sealer can't unseal the encryption key, e.g., because the hardware has been replaced.
+// Otherwise, the encryption key is only protected by the KMS.
+type KMSSealer struct {
+	store         store.Store
+	kms           KMS
+	sealer        Sealer
+	encryptionKey []byte
+	// wrappedKey is the encryption key that has been wrapped and stored last
+	wrappedKey []byte
+}
+
+// NewKMSSealer creates a KMSSealer that stores the wrapped key in store. sealer may be nil.
+func NewKMSSealer(store store.Store, kms KMS, sealer Sealer) *KMSSealer {
+	return &KMSSealer{store: store, kms: kms, sealer: sealer}
+}
+
+// Unseal implements the Sealer interface
+func (s *KMSSealer) Unseal() ([]byte, []byte, error) {
+	if s.sealer != nil {
+		unencryptedData, decryptedData, err := s.sealer.Unseal()
+		if err != ErrEncryptionKey {
+			return unencryptedData, decryptedData, err
+		}
+		encryptionKey, err := s.unwrapEncryptionKey()
+		if err != nil {
+			return unencryptedData, nil, ErrEncryptionKey
+		}
+		if err := s.sealer.SetEncryptionKey(encryptionKey); err != nil {
+			return unencryptedData, nil, err
+		}
+		return s.sealer.Unseal()
+	}
+
+	sealedData, err := s.store.Get(SealedDataFname)
+	if err == store.ErrNotFound {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	unencryptedData, err := readUnencryptedData(s.store)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.encryptionKey == nil {
+		if s.encryptionKey, err = s.unwrapEncryptionKey(); err != nil {
+			return unencryptedData, nil, ErrEncryptionKey
+		}
+	}
+	decryptedData, err := ertcrypto.Decrypt(sealedData, s.encryptionKey)
+	if err != nil {
+		return unencryptedData, nil, err
+	}
+	return unencryptedData, decryptedData, nil
+}
+
+// Seal implements the Sealer interface
+//
+// The encryption key is wrapped when it is used for the first time, so a key set during recovery is only stored once the state has been decrypted with it.
+func (s *KMSSealer) Seal(unencryptedData []byte, toBeEncrypted []byte) ([]byte, error) {
+	var encryptionKey []byte
+	if s.sealer != nil {
+		var err error
+		if encryptionKey, err = s.sealer.Seal(unencryptedData, toBeEncrypted); err != nil {
+			return nil, err
+		}
+	} else {
+		if s.encryptionKey == nil {
+			key, err := s.unwrapEncryptionKey()
+			if err == store.ErrNotFound {
+				err = s.GenerateNewEncryptionKey()
+			} else {
+				s.encryptionKey = key
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		encryptedData, err := ertcrypto.Encrypt(toBeEncrypted, s.encryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.store.Put(UnencryptedDataFname, unencryptedData); err != nil {
+			return nil, err
+		}
+		if err := s.store.Put(SealedDataFname, encryptedData); err != nil {
+			return nil, err
+		}
+		encryptionKey = s.encryptionKey
+	}
+
+	if !bytes.Equal(encryptionKey, s.wrappedKey) {
+		wrappedKey, err := s.kms.WrapKey(encryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.store.Put(WrappedKeyFname, wrappedKey); err != nil {
+			return nil, err
+		}
+		s.wrappedKey = encryptionKey
+	}
+	return encryptionKey, nil
+}
+
+// GenerateNewEncryptionKey implements the Sealer interface
+func (s *KMSSealer) GenerateNewEncryptionKey() error {
+	if s.sealer != nil {
+		return s.sealer.GenerateNewEncryptionKey()
+	}
+	encryptionKey := make([]byte, 16)
+	if _, err := rand.Read(encryptionKey); err != nil {
+		return err
+	}
+	return s.SetEncryptionKey(encryptionKey)
+}
+
+// SetEncryptionKey implements the Sealer interface
+func (s *KMSSealer) SetEncryptionKey(key []byte) error {
+	if s.sealer != nil {
+		return s.sealer.SetEncryptionKey(key)
+	}
+	s.encryptionKey = key
+	return nil
+}
+
+func (s *KMSSealer) unwrapEncryptionKey() ([]byte, error) {
+	wrappedKey, err := s.store.Get(WrappedKeyFname)
+	if err != nil {
+		return nil, err
+	}
+	encryptionKey, err := s.kms.UnwrapKey(wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	s.wrappedKey = encryptionKey
+	return encryptionKey, nil
+}
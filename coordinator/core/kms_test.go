@@ -0,0 +1,102 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/edgelesssys/marblerun/coordinator/core/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockKMS struct {
+	unavailable bool
+}
+
+func (k *mockKMS) WrapKey(key []byte) ([]byte, error) {
+	if k.unavailable {
+		return nil, errors.New("unavailable")
+	}
+	return append([]byte("wrapped:"), key...), nil
+}
+
+func (k *mockKMS) UnwrapKey(wrappedKey []byte) ([]byte, error) {
+	if k.unavailable {
+		return nil, errors.New("unavailable")
+	}
+	return wrappedKey[len("wrapped:"):], nil
+}
+
+func TestKMSSealer(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	st := store.NewFileStore(dir)
+	kms := &mockKMS{}
+
+	sealer := NewKMSSealer(st, kms, nil)
+	_, data, err := sealer.Unseal()
+	require.NoError(err)
+	assert.Nil(data)
+	key, err := sealer.Seal([]byte("recovery"), []byte("state"))
+	require.NoError(err)
+	wrappedKey, err := st.Get(WrappedKeyFname)
+	require.NoError(err)
+	assert.Equal(append([]byte("wrapped:"), key...), wrappedKey)
+
+	// the key is unwrapped by a new sealer
+	recoveryData, data, err := NewKMSSealer(st, kms, nil).Unseal()
+	require.NoError(err)
+	assert.Equal([]byte("recovery"), recoveryData)
+	assert.Equal([]byte("state"), data)
+
+	// the state can't be decrypted without the KMS
+	kms.unavailable = true
+	recoveryData, _, err = NewKMSSealer(st, kms, nil).Unseal()
+	assert.Equal(ErrEncryptionKey, err)
+	assert.Equal([]byte("recovery"), recoveryData)
+}
+
+func TestKMSSealerWithSealer(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	st := store.NewFileStore(dir)
+	kms := &mockKMS{}
+
+	sealer := NewKMSSealer(st, kms, NewNoEnclaveSealer(st))
+	require.NoError(sealer.GenerateNewEncryptionKey())
+	key, err := sealer.Seal(nil, []byte("state"))
+	require.NoError(err)
+	wrappedKey, err := st.Get(WrappedKeyFname)
+	require.NoError(err)
+	assert.Equal(append([]byte("wrapped:"), key...), wrappedKey)
+
+	// the key sealed by the other sealer can't be used anymore, e.g., because the hardware has been replaced
+	require.NoError(st.Put(SealedKeyFname, []byte("0123456789abcdef")))
+	_, data, err := NewKMSSealer(st, kms, NewNoEnclaveSealer(st)).Unseal()
+	require.NoError(err)
+	assert.Equal([]byte("state"), data)
+	restoredKey, err := st.Get(SealedKeyFname)
+	require.NoError(err)
+	assert.Equal(key, restoredKey)
+
+	require.NoError(st.Put(SealedKeyFname, []byte("0123456789abcdef")))
+	kms.unavailable = true
+	_, _, err = NewKMSSealer(st, kms, NewNoEnclaveSealer(st)).Unseal()
+	assert.Equal(ErrEncryptionKey, err)
+}
@@ -0,0 +1,86 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"context"
+	"crypto/x509"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+)
+
+// maintenance describes why and since when the activations of marbles have been paused.
+type maintenance struct {
+	Since  time.Time
+	User   string
+	Reason string `json:",omitempty"`
+}
+
+// errMaintenance is returned to marbles that try to activate while the activations are paused.
+func (m *maintenance) errMaintenance() error {
+	metadata := map[string]string{"Since": m.Since.Format(time.RFC3339)}
+	if m.Reason != "" {
+		metadata["Reason"] = m.Reason
+	}
+	return rpc.ActivationError(codes.Unavailable, rpc.ReasonMaintenance, metadata, "mesh in maintenance: activations are paused")
+}
+
+// SetMaintenance pauses or resumes the activations and certificate renewals of marbles. The client API and the marbles that have been activated are not affected otherwise,
+// so that the operators can, e.g., back up the state or update the manifest without new marbles joining the mesh.
+//
+// clientCert is the TLS certificate of the client, which must belong to one of the manifest's Users who is permitted to pause activations.
+// The pause is sealed, so that it survives restarts of the Coordinator. Pausing paused activations or resuming running ones does nothing.
+func (c *Core) SetMaintenance(ctx context.Context, paused bool, reason string, clientCert *x509.Certificate) error {
+	defer c.mux.Unlock()
+	if err := c.requireState(stateAcceptingMarbles); err != nil {
+		return err
+	}
+	user, ok := c.manifest.getUser(clientCert)
+	if !ok || !c.manifest.isPermitted(user, resourceMarbles, "", actionPauseActivations) {
+		return ErrNotAuthorized
+	}
+	if paused == (c.maintenance != nil) {
+		return nil
+	}
+
+	oldMaintenance := c.maintenance
+	oldAuditLog := c.auditLog
+	if paused {
+		c.maintenance = &maintenance{Since: time.Now().UTC(), User: user, Reason: reason}
+		var details map[string]string
+		if reason != "" {
+			details = map[string]string{"Reason": reason}
+		}
+		c.appendAuditEntry(ctx, clientapi.AuditEventPauseActivations, user, details)
+	} else {
+		c.maintenance = nil
+		c.appendAuditEntry(ctx, clientapi.AuditEventResumeActivations, user, nil)
+	}
+	if _, err := c.sealState(); err != nil {
+		c.maintenance = oldMaintenance
+		c.auditLog = oldAuditLog
+		c.zaplogger.Error("sealState failed", zap.Error(err))
+		return err
+	}
+
+	c.requestLogger(ctx).Info("maintenance mode changed", zap.String("user", user), zap.Bool("Paused", paused), zap.String("Reason", reason))
+	return nil
+}
+
+// GetMaintenance returns whether the activations of marbles are paused, and if so, since when, by whom and why.
+func (c *Core) GetMaintenance(ctx context.Context) clientapi.Maintenance {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.maintenance == nil {
+		return clientapi.Maintenance{}
+	}
+	return clientapi.Maintenance{Paused: true, Since: c.maintenance.Since, User: c.maintenance.User, Reason: c.maintenance.Reason}
+}
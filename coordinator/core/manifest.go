@@ -7,7 +7,14 @@
 package core
 
 import (
+	"bytes"
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/hex"
@@ -15,10 +22,16 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"net"
+	"reflect"
 	"text/template"
+	"time"
 
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
 	"github.com/edgelesssys/marblerun/coordinator/quote"
 	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/edgelesssys/marblerun/marble/config"
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
@@ -30,12 +43,40 @@ type Manifest struct {
 	Infrastructures map[string]quote.InfrastructureProperties
 	// Marbles contains the allowed services with their corresponding enclave and configuration parameters.
 	Marbles map[string]Marble
-	// Clients contains TLS certificates for authenticating clients that use the ClientAPI.
-	Clients map[string][]byte
+	// MarbleGroups contains named groups of marble types. Secrets may be shared with all members of a group.
+	MarbleGroups map[string][]string `json:",omitempty"`
+	// TLS contains tags of connections that are transparently wrapped in mTLS. Marbles reference them in their TLS settings.
+	TLS map[string]config.TTLS `json:",omitempty"`
+	// MarbleCertificate configures the certificates that are issued to marbles during activation.
+	MarbleCertificate MarbleCertificateConfig
+	// PackageCertificates overrides MarbleCertificate for the marbles of the referenced packages.
+	PackageCertificates map[string]MarbleCertificateConfig `json:",omitempty"`
+	// RootCA is the PEM-encoded CA certificate that has been provided to the Coordinator before the manifest is set.
+	// It pins the CA, so that the manifest is only accepted with the CA its author intended. It must be set if and only if a CA has been provided.
+	RootCA string `json:",omitempty"`
+	// ExternalServices contains the services that are not marbles, e.g., conventional pods of a mixed mesh, whose certificates the Coordinator issues
+	// on request of Users who are permitted to, e.g., a cert-manager issuer.
+	ExternalServices map[string]ExternalService `json:",omitempty"`
+	// SPIFFETrustDomain is the trust domain of the mesh, e.g., "marblerun.example.com". If it is set, marble certificates contain the SPIFFE ID
+	// spiffe://<SPIFFETrustDomain>/<marble type>/<UUID> as URI, so that they are X.509-SVIDs, which SPIFFE-aware applications accept.
+	SPIFFETrustDomain string `json:",omitempty"`
+	// Users contains the clients that are allowed to perform privileged operations. They authenticate with their TLS client certificate.
+	Users map[string]User
+	// Roles contains the permissions that can be assigned to Users.
+	Roles map[string]Role
+	// UpdateThreshold is the number of distinct users that must acknowledge a manifest update before it takes effect. Defaults to 1.
+	// Only acknowledgements of users who are permitted to acknowledge updates count.
+	UpdateThreshold uint
 	// Secrets holds user-specified secrets, which should be generated and later on stored in a marble (if not shared) or in the core (if shared).
 	Secrets map[string]Secret
-	// Recovery holds a RSA public key to encrypt the state encryption key, which gets returned over the Client API when setting a manifest.
-	RecoveryKey string
+	// RecoveryKeys holds PEM-encoded RSA public keys to encrypt the state encryption key, which gets returned over the Client API when setting a manifest.
+	RecoveryKeys map[string]string
+	// RecoveryKey is the single recovery key of manifests written before RecoveryKeys was introduced.
+	// Deprecated: Use RecoveryKeys. A RecoveryKey is treated as the entry "RecoveryKey" of RecoveryKeys.
+	RecoveryKey string `json:",omitempty"`
+	// RecoveryThreshold is the number of RecoveryKeys holders required to recover the state.
+	// If it is greater than 1, each holder only receives a share of the state encryption key. Defaults to 1.
+	RecoveryThreshold uint
 }
 
 // Marble describes a service in the mesh that should be handled and verified by the Coordinator
@@ -44,9 +85,312 @@ type Marble struct {
 	Package string
 	// MaxActivations allows to limit the number of marbles of a kind.
 	MaxActivations uint
+	// InfrastructureMaxActivations limits the number of marbles of the kind per infrastructure, in addition to MaxActivations.
+	// The keys reference the manifest's Infrastructures. Activations on infrastructures that aren't listed are only limited by MaxActivations.
+	InfrastructureMaxActivations map[string]uint `json:",omitempty"`
+	// HeartbeatTTL is the number of seconds after which the activation of a marble that hasn't sent a heartbeat is released,
+	// i.e., it doesn't count towards MaxActivations anymore. 0 means that activations are never released.
+	HeartbeatTTL uint `json:",omitempty"`
 	// Parameters contains lists for files, environment variables and commandline arguments that should be passed to the application.
 	// Placeholder variables are supported for specific assets of the marble's activation process.
+	// Files, Env and Argv are Go templates, e.g. "{{ pem .Secrets.mycert.Cert }}" or "{{ hex .Marblerun.SealKey }}".
 	Parameters *rpc.Parameters
+	// TLS contains additional subject alternative names of the marble's certificate and its transparently wrapped connections.
+	TLS *MarbleTLS `json:",omitempty"`
+	// SealKeyScope determines what the marble's .Marblerun.SealKey is derived for: SealKeyScopeUUID (the default) or SealKeyScopeMarbleType.
+	SealKeyScope string `json:",omitempty"`
+}
+
+const (
+	// SealKeyScopeUUID derives a sealing key per marble. A marble gets the same key as long as it keeps its UUID.
+	SealKeyScopeUUID = "UUID"
+	// SealKeyScopeMarbleType derives a sealing key per marble type, which all marbles of the type share.
+	SealKeyScopeMarbleType = "MarbleType"
+)
+
+// heartbeatTTL returns the HeartbeatTTL of the marble as duration.
+func (m Marble) heartbeatTTL() time.Duration {
+	return time.Duration(m.HeartbeatTTL) * time.Second
+}
+
+// MarbleTLS describes subject alternative names that are added to the names requested in the marble's CSR.
+// They are Go templates that can reference the activation metadata .MarbleType, .Package and .UUID, e.g. "{{ .UUID }}.backend.svc".
+// Tags references entries of the manifest's TLS section, whose connections the marble's premain wraps in mTLS.
+type MarbleTLS struct {
+	DNSNames    []string `json:",omitempty"`
+	IPAddresses []string `json:",omitempty"`
+	Tags        []string `json:",omitempty"`
+}
+
+// activationMetadata is available in the templates of MarbleTLS.
+type activationMetadata struct {
+	MarbleType string
+	Package    string
+	UUID       string
+}
+
+// subjectAltNames returns the DNS names and IP addresses of the TLS block for the activation metadata.
+func (t *MarbleTLS) subjectAltNames(metadata activationMetadata) ([]string, []net.IP, error) {
+	if t == nil {
+		return nil, nil, nil
+	}
+	var dnsNames []string
+	for _, data := range t.DNSNames {
+		name, err := executeTemplate(data, metadata)
+		if err != nil {
+			return nil, nil, err
+		}
+		if name == "" {
+			return nil, nil, fmt.Errorf("DNS name %q is empty", data)
+		}
+		dnsNames = append(dnsNames, name)
+	}
+	var ipAddrs []net.IP
+	for _, data := range t.IPAddresses {
+		value, err := executeTemplate(data, metadata)
+		if err != nil {
+			return nil, nil, err
+		}
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, nil, fmt.Errorf("invalid IP address %q", value)
+		}
+		ipAddrs = append(ipAddrs, ip)
+	}
+	return dnsNames, ipAddrs, nil
+}
+
+// ttlsConfig returns the transparent TLS configuration of a marble, which combines the connections of its tags.
+func (m Manifest) ttlsConfig(marble Marble) config.TTLS {
+	var ttls config.TTLS
+	if marble.TLS == nil {
+		return ttls
+	}
+	for _, tag := range marble.TLS.Tags {
+		ttls.Outgoing = append(ttls.Outgoing, m.TLS[tag].Outgoing...)
+		ttls.Incoming = append(ttls.Incoming, m.TLS[tag].Incoming...)
+	}
+	return ttls
+}
+
+// checkTTLS checks that the marble's tags exist and describe valid connections.
+func (m Manifest) checkTTLS(marble Marble) error {
+	if marble.TLS == nil {
+		return nil
+	}
+	for _, tag := range marble.TLS.Tags {
+		if _, ok := m.TLS[tag]; !ok {
+			return fmt.Errorf("undefined TLS tag %s", tag)
+		}
+	}
+	ttls := m.ttlsConfig(marble)
+	ports := map[uint16]bool{}
+	checkPort := func(port uint16) error {
+		if port == 0 {
+			return errors.New("port must be set")
+		}
+		if ports[port] {
+			return fmt.Errorf("port %v is used more than once", port)
+		}
+		ports[port] = true
+		return nil
+	}
+	for _, conn := range ttls.Outgoing {
+		if err := checkPort(conn.Port); err != nil {
+			return err
+		}
+		if _, _, err := net.SplitHostPort(conn.Addr); err != nil {
+			return fmt.Errorf("invalid outgoing address %q: %v", conn.Addr, err)
+		}
+	}
+	for _, conn := range ttls.Incoming {
+		if err := checkPort(conn.Port); err != nil {
+			return err
+		}
+		if _, _, err := net.SplitHostPort(conn.Target); err != nil {
+			return fmt.Errorf("invalid incoming target %q: %v", conn.Target, err)
+		}
+	}
+	return nil
+}
+
+func executeTemplate(data string, value interface{}) (string, error) {
+	tpl, err := template.New("data").Parse(data)
+	if err != nil {
+		return "", err
+	}
+	var result bytes.Buffer
+	if err := tpl.Execute(&result, value); err != nil {
+		return "", err
+	}
+	return result.String(), nil
+}
+
+// MarbleCertificateConfig describes the certificates that the Coordinator issues to marbles.
+// Settings that are not set take their value from the manifest's MarbleCertificate or their default.
+type MarbleCertificateConfig struct {
+	// ValidFor is the validity of the certificates in days. By default, they are valid as long as the Coordinator's root certificate.
+	ValidFor uint `json:",omitempty"`
+	// KeyType is one of ecdsa (default), rsa or ed25519.
+	KeyType string `json:",omitempty"`
+	// KeySize is the key size in bits. For ecdsa it selects the curve, 256 (default) or 384, for rsa it is one of 2048 (default), 3072 or 4096, for ed25519 it must be omitted.
+	// It is only inherited together with KeyType.
+	KeySize uint `json:",omitempty"`
+	// SignatureAlgorithm is the algorithm the certificates are signed with. It is one of ECDSA-SHA256 (default), ECDSA-SHA384 or ECDSA-SHA512.
+	SignatureAlgorithm string `json:",omitempty"`
+}
+
+// marbleCertificateSignatureAlgorithms contains the supported signature algorithms of marble certificates.
+// The certificates are signed by the package CAs, which have ECDSA keys.
+var marbleCertificateSignatureAlgorithms = map[string]x509.SignatureAlgorithm{
+	"ECDSA-SHA256": x509.ECDSAWithSHA256,
+	"ECDSA-SHA384": x509.ECDSAWithSHA384,
+	"ECDSA-SHA512": x509.ECDSAWithSHA512,
+}
+
+// withDefaults returns the configuration with the settings that are not set taken from defaults.
+func (cfg MarbleCertificateConfig) withDefaults(defaults MarbleCertificateConfig) MarbleCertificateConfig {
+	if cfg.ValidFor == 0 {
+		cfg.ValidFor = defaults.ValidFor
+	}
+	if cfg.KeyType == "" {
+		cfg.KeyType = defaults.KeyType
+		cfg.KeySize = defaults.KeySize
+	}
+	if cfg.SignatureAlgorithm == "" {
+		cfg.SignatureAlgorithm = defaults.SignatureAlgorithm
+	}
+	return cfg
+}
+
+// check verifies that certificates can be issued with the configuration.
+func (cfg MarbleCertificateConfig) check() error {
+	switch cfg.KeyType {
+	case "", "ecdsa":
+		if cfg.KeySize != 0 && cfg.KeySize != 256 && cfg.KeySize != 384 {
+			return fmt.Errorf("invalid key size %d for ecdsa, must be one of 256, 384", cfg.KeySize)
+		}
+	case "rsa":
+		if cfg.KeySize != 0 && cfg.KeySize != 2048 && cfg.KeySize != 3072 && cfg.KeySize != 4096 {
+			return fmt.Errorf("invalid key size %d for rsa, must be one of 2048, 3072, 4096", cfg.KeySize)
+		}
+	case "ed25519":
+		if cfg.KeySize != 0 {
+			return fmt.Errorf("invalid key size %d for ed25519, none is expected", cfg.KeySize)
+		}
+	default:
+		return fmt.Errorf("unsupported key type %q", cfg.KeyType)
+	}
+	if _, ok := marbleCertificateSignatureAlgorithms[cfg.SignatureAlgorithm]; cfg.SignatureAlgorithm != "" && !ok {
+		return fmt.Errorf("unsupported signature algorithm %q", cfg.SignatureAlgorithm)
+	}
+	return nil
+}
+
+// generateKey generates a key of the configured type and size.
+func (cfg MarbleCertificateConfig) generateKey() (crypto.Signer, error) {
+	switch cfg.KeyType {
+	case "rsa":
+		size := cfg.KeySize
+		if size == 0 {
+			size = 2048
+		}
+		return rsa.GenerateKey(rand.Reader, int(size))
+	case "ed25519":
+		_, privk, err := ed25519.GenerateKey(rand.Reader)
+		return privk, err
+	}
+	curve := elliptic.P256()
+	if cfg.KeySize == 384 {
+		curve = elliptic.P384()
+	}
+	return ecdsa.GenerateKey(curve, rand.Reader)
+}
+
+// keyUsage returns the key usage of certificates for keys of the configured type.
+func (cfg MarbleCertificateConfig) keyUsage() x509.KeyUsage {
+	switch cfg.KeyType {
+	case "rsa":
+		return x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	case "ed25519":
+		return x509.KeyUsageDigitalSignature
+	}
+	return x509.KeyUsageDigitalSignature | x509.KeyUsageKeyAgreement
+}
+
+// marbleCertificateConfig returns the configuration of the certificates of marbles of the given package.
+func (m Manifest) marbleCertificateConfig(pkg string) MarbleCertificateConfig {
+	return m.PackageCertificates[pkg].withDefaults(m.MarbleCertificate)
+}
+
+// User describes a client of the ClientAPI that is allowed to perform privileged operations
+type User struct {
+	// Certificate is the PEM-encoded TLS client certificate of the user.
+	Certificate string
+	// Roles references the roles of the manifest that are assigned to the user.
+	Roles []string
+}
+
+// Role grants permission to perform the given actions on resources of a type
+type Role struct {
+	// ResourceType is the type of resource the role applies to. It is one of Manifest, Secrets, Recovery, Marbles, ExternalServices, Dashboard, Attestation or AuditLog.
+	ResourceType string
+	// ResourceNames restricts the role to the named resources. It may only be used for Secrets and ExternalServices, where it references the manifest's
+	// Secrets and ExternalServices, respectively.
+	// If it is empty, the role applies to all resources of the type.
+	ResourceNames []string `json:",omitempty"`
+	// Actions contains the permitted actions:
+	// ProposeUpdate, AcknowledgeUpdate and CancelUpdate for the Manifest, ReadSecret, WriteSecret and RotateSecret for Secrets, Recover, Backup and HandOff for Recovery,
+	// RevokeMarble, ListMarbles, ResetActivations and PauseActivations for Marbles, IssueCertificate for ExternalServices, ViewDashboard for the Dashboard,
+	// QuoteUserData for Attestation, and ReadAuditLog for the AuditLog.
+	Actions []string
+}
+
+// Resource types a Role can apply to
+const (
+	resourceManifest = "Manifest"
+	resourceSecrets  = "Secrets"
+	resourceRecovery = "Recovery"
+	resourceMarbles  = "Marbles"
+
+	resourceExternalServices = "ExternalServices"
+	resourceDashboard        = "Dashboard"
+	resourceAttestation      = "Attestation"
+	resourceAuditLog         = "AuditLog"
+)
+
+// Actions that can be granted by a Role
+const (
+	actionProposeUpdate     = "ProposeUpdate"
+	actionAcknowledgeUpdate = "AcknowledgeUpdate"
+	actionCancelUpdate      = "CancelUpdate"
+	actionReadSecret        = "ReadSecret"
+	actionWriteSecret       = "WriteSecret"
+	actionRotateSecret      = "RotateSecret"
+	actionRecover           = "Recover"
+	actionBackup            = "Backup"
+	actionHandOff           = "HandOff"
+	actionRevokeMarble      = "RevokeMarble"
+	actionListMarbles       = "ListMarbles"
+	actionResetActivations  = "ResetActivations"
+	actionPauseActivations  = "PauseActivations"
+	actionIssueCertificate  = "IssueCertificate"
+	actionViewDashboard     = "ViewDashboard"
+	actionQuoteUserData     = "QuoteUserData"
+	actionReadAuditLog      = "ReadAuditLog"
+)
+
+// roleActions maps the resource types to the actions that can be performed on them.
+var roleActions = map[string][]string{
+	resourceManifest: {actionProposeUpdate, actionAcknowledgeUpdate, actionCancelUpdate},
+	resourceSecrets:  {actionReadSecret, actionWriteSecret, actionRotateSecret},
+	resourceRecovery: {actionRecover, actionBackup, actionHandOff},
+	resourceMarbles:  {actionRevokeMarble, actionListMarbles, actionResetActivations, actionPauseActivations},
+
+	resourceExternalServices: {actionIssueCertificate},
+	resourceDashboard:        {actionViewDashboard},
+	resourceAttestation:      {actionQuoteUserData},
+	resourceAuditLog:         {actionReadAuditLog},
 }
 
 // Secret describes a structure for storing certificates and keys, which can be used in combination with the go templating engine.
@@ -59,13 +403,44 @@ type PublicKey []byte
 
 // Secret defines a structure for storing certificates & encryption keys
 type Secret struct {
-	Type     string
-	Size     uint
-	Shared   bool
-	Cert     Certificate
+	// Type is one of symmetric-key, cert-rsa, cert-ed25519 or cert-ecdsa. User-defined secrets may also be plain, i.e., arbitrary data.
+	Type string
+	// Size is the key size in bits. For cert-ecdsa it selects the curve, for cert-ed25519 it must be omitted.
+	// For user-defined secrets, it may only be set for symmetric-key to require keys of that size.
+	Size uint
+	// Shared secrets are generated once when the manifest is set, all others are generated per marble during activation.
+	Shared bool
+	// UserDefined secrets are not generated, but uploaded by Users who are permitted to write them. They are shared by all marbles.
+	UserDefined bool `json:",omitempty"`
+	// Cert is used as template for the generated certificate.
+	Cert Certificate
+	// ValidFor is the validity of the generated certificate in days. It must not be combined with Cert.NotAfter.
 	ValidFor uint
 	Private  PrivateKey
 	Public   PublicKey
+	// Marbles restricts the marble types whose parameters may reference the secret. If it and Groups are empty, all marbles may reference it.
+	Marbles []string `json:",omitempty"`
+	// Groups entitles the members of the referenced MarbleGroups to reference the secret in addition to Marbles.
+	// Secrets of groups must not be generated per marble, so that all members get the same value.
+	Groups []string `json:",omitempty"`
+	// Version is set by the Coordinator. It starts at 1 and is incremented whenever the secret is rotated.
+	Version uint `json:",omitempty"`
+	// Backend references the secret in the secrets backend of the Coordinator, e.g., HashiCorp Vault. Such secrets are not generated,
+	// but fetched when marbles are activated, and they are never stored in the Coordinator's state.
+	Backend *BackendSecret `json:",omitempty"`
+}
+
+// isEntitled returns true if marbles of the given type may reference the secret.
+func (m Manifest) isEntitled(secret Secret, marbleType string) bool {
+	if len(secret.Marbles) == 0 && len(secret.Groups) == 0 || contains(secret.Marbles, marbleType) {
+		return true
+	}
+	for _, group := range secret.Groups {
+		if contains(m.MarbleGroups[group], marbleType) {
+			return true
+		}
+	}
+	return false
 }
 
 // Certificate is an x509.Certificate
@@ -157,20 +532,27 @@ var manifestTemplateFuncMap = template.FuncMap{
 }
 
 // Check checks if the manifest is consistent.
+//
+// Errors in the references between the entries of the manifest and in the ranges of its values are all reported as ManifestErrors.
 func (m Manifest) Check(ctx context.Context, zaplogger *zap.Logger) error {
-	if len(m.Packages) <= 0 {
-		return errors.New("no allowed packages defined")
+	if errs := m.validate(); len(errs) > 0 {
+		return errs
 	}
-	if len(m.Marbles) <= 0 {
-		return errors.New("no allowed marbles defined")
-	}
-	// if len(m.Infrastructures) <= 0 {
-	// 	return errors.New("no allowed infrastructures defined")
-	// }
 	for _, marble := range m.Marbles {
-		singlePackage, ok := m.Packages[marble.Package]
-		if !ok {
-			return errors.New("manifest does not contain marble package " + marble.Package)
+		singlePackage := m.Packages[marble.Package]
+		// Packages of AMD SEV-SNP guests are identified by their launch measurement only
+		if singlePackage.Measurement != "" {
+			if singlePackage.UniqueID != "" || singlePackage.SignerID != "" || singlePackage.ProductID != nil || len(singlePackage.PCRs) > 0 {
+				return fmt.Errorf("manifest specfies both Measurement *and* UniqueID/SignerID/ProductID/PCRs in package %s", marble.Package)
+			}
+			continue
+		}
+		// Packages of AWS Nitro Enclaves are identified by their PCRs only
+		if len(singlePackage.PCRs) > 0 {
+			if singlePackage.UniqueID != "" || singlePackage.SignerID != "" || singlePackage.ProductID != nil || singlePackage.SecurityVersion != nil {
+				return fmt.Errorf("manifest specfies both PCRs *and* UniqueID/SignerID/ProductID/SecurityVersion in package %s", marble.Package)
+			}
+			continue
 		}
 		// Check if package specifies either UniqueID, or values for all, SignerID, ProductID & Security version
 		// Debug mode bypasses this requirement and throws a warning instead
@@ -198,9 +580,163 @@ func (m Manifest) Check(ctx context.Context, zaplogger *zap.Logger) error {
 			}
 		}
 	}
+	if m.SPIFFETrustDomain != "" {
+		if err := checkSPIFFETrustDomain(m.SPIFFETrustDomain); err != nil {
+			return fmt.Errorf("invalid SPIFFETrustDomain: %v", err)
+		}
+		for marbleType := range m.Marbles {
+			if err := checkSPIFFEPathSegment(marbleType); err != nil {
+				return fmt.Errorf("name of marble %s can't be used in SPIFFE IDs: %v", marbleType, err)
+			}
+		}
+	}
+	for name, service := range m.ExternalServices {
+		if err := service.check(); err != nil {
+			return fmt.Errorf("invalid external service %s: %v", name, err)
+		}
+	}
+	for marbleType, marble := range m.Marbles {
+		// the metadata is only known during activation, the check uses placeholders
+		if _, _, err := marble.TLS.subjectAltNames(activationMetadata{MarbleType: marbleType, Package: marble.Package, UUID: uuid.Nil.String()}); err != nil {
+			return fmt.Errorf("invalid TLS settings of marble %s: %v", marbleType, err)
+		}
+		if err := m.checkTTLS(marble); err != nil {
+			return fmt.Errorf("invalid TLS settings of marble %s: %v", marbleType, err)
+		}
+	}
+	return nil
+}
+
+// entitledSecrets returns the definitions of the secrets that marbles of the given type may reference.
+func (m Manifest) entitledSecrets(marbleType string) map[string]Secret {
+	secrets := make(map[string]Secret, len(m.Secrets))
+	for name, secret := range m.Secrets {
+		if m.isEntitled(secret, marbleType) {
+			secrets[name] = secret
+		}
+	}
+	return secrets
+}
+
+// check verifies that the secret definition can be used to generate a secret.
+// It is used by both Manifest.Check and Core.generateSecrets. Non-shared secrets are generated during activation, so checking the manifest catches errors before the first marble is activated.
+func (s Secret) check() error {
+	if s.Backend != nil {
+		return s.checkBackend()
+	}
+	if s.UserDefined {
+		return s.checkUserDefined()
+	}
+	switch s.Type {
+	case "symmetric-key":
+		if s.Size == 0 || s.Size%8 != 0 {
+			return fmt.Errorf("invalid size %d for symmetric-key, must be a multiple of 8", s.Size)
+		}
+	case "cert-rsa":
+		if s.Size == 0 {
+			return errors.New("missing size for cert-rsa")
+		}
+	case "cert-ed25519":
+		if s.Size != 0 {
+			return fmt.Errorf("invalid size %d for cert-ed25519, none is expected", s.Size)
+		}
+	case "cert-ecdsa":
+		switch s.Size {
+		case 224, 256, 384, 521:
+		default:
+			return fmt.Errorf("invalid size %d for cert-ecdsa, must be one of 224, 256, 384, 521", s.Size)
+		}
+	default:
+		return fmt.Errorf("unsupported type %q", s.Type)
+	}
+
+	if s.Type != "symmetric-key" && !s.Cert.NotAfter.IsZero() && s.ValidFor != 0 {
+		return errors.New("ambiguous certificate validity duration, both NotAfter and ValidFor are specified")
+	}
+	return nil
+}
+
+// checkUserDefined verifies that the definition of a user-defined secret can be used to accept uploaded values.
+func (s Secret) checkUserDefined() error {
+	switch s.Type {
+	case "plain":
+		if s.Size != 0 {
+			return errors.New("invalid size for plain, none is expected")
+		}
+	case "symmetric-key":
+		if s.Size%8 != 0 {
+			return fmt.Errorf("invalid size %d for symmetric-key, must be a multiple of 8", s.Size)
+		}
+	case "cert-rsa", "cert-ed25519", "cert-ecdsa":
+		if s.Size != 0 {
+			return fmt.Errorf("invalid size %d for user-defined %s, the size is given by the uploaded key", s.Size, s.Type)
+		}
+	default:
+		return fmt.Errorf("unsupported type %q", s.Type)
+	}
+	if s.ValidFor != 0 {
+		return errors.New("ValidFor cannot be used for user-defined secrets")
+	}
 	return nil
 }
 
+// withValue returns the user-defined secret set to the uploaded value.
+func (s Secret) withValue(value clientapi.Secret) (Secret, error) {
+	switch s.Type {
+	case "plain", "symmetric-key":
+		if len(value.Private) == 0 {
+			return Secret{}, errors.New("missing value")
+		}
+		if len(value.Cert) > 0 {
+			return Secret{}, fmt.Errorf("unexpected certificate for %s", s.Type)
+		}
+		if s.Type == "symmetric-key" && s.Size != 0 && uint(len(value.Private))*8 != s.Size {
+			return Secret{}, fmt.Errorf("invalid key size %d, expected %d", len(value.Private)*8, s.Size)
+		}
+		s.Private = value.Private
+		s.Public = value.Private
+		return s, nil
+	}
+
+	cert, err := x509.ParseCertificate(value.Cert)
+	if err != nil {
+		return Secret{}, fmt.Errorf("invalid certificate: %v", err)
+	}
+	privk, err := x509.ParsePKCS8PrivateKey(value.Private)
+	if err != nil {
+		return Secret{}, fmt.Errorf("invalid private key: %v", err)
+	}
+	var pub crypto.PublicKey
+	switch key := privk.(type) {
+	case *rsa.PrivateKey:
+		if s.Type == "cert-rsa" {
+			pub = key.Public()
+		}
+	case ed25519.PrivateKey:
+		if s.Type == "cert-ed25519" {
+			pub = key.Public()
+		}
+	case *ecdsa.PrivateKey:
+		if s.Type == "cert-ecdsa" {
+			pub = key.Public()
+		}
+	}
+	if pub == nil {
+		return Secret{}, fmt.Errorf("private key of type %T cannot be used for %s", privk, s.Type)
+	}
+	rawPub, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return Secret{}, err
+	}
+	if !bytes.Equal(rawPub, cert.RawSubjectPublicKeyInfo) {
+		return Secret{}, errors.New("private key does not match the certificate")
+	}
+	s.Cert = Certificate(*cert)
+	s.Private = value.Private
+	s.Public = rawPub
+	return s, nil
+}
+
 func warnOrFailForMissingValue(debugMode bool, parameter string, packageName string, zaplogger *zap.Logger) error {
 	if debugMode {
 		zaplogger.Warn("Manifest misses value in package declaration. This is not accepted in non-debug mode, please check your configuration.", zap.String("parameter", parameter), zap.String("packageName", packageName))
@@ -209,3 +745,152 @@ func warnOrFailForMissingValue(debugMode bool, parameter string, packageName str
 
 	return fmt.Errorf("manifest misses value for %s in package %s", parameter, packageName)
 }
+
+// recoveryKeys returns the manifest's RecoveryKeys, including a legacy RecoveryKey.
+func (m Manifest) recoveryKeys() map[string]string {
+	if m.RecoveryKey == "" {
+		return m.RecoveryKeys
+	}
+	return map[string]string{"RecoveryKey": m.RecoveryKey}
+}
+
+// getUser returns the name of the user cert belongs to.
+func (m Manifest) getUser(cert *x509.Certificate) (string, bool) {
+	if cert == nil {
+		return "", false
+	}
+	for name, user := range m.Users {
+		userCert, err := parsePEMCertificate(user.Certificate)
+		if err == nil && userCert.Equal(cert) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// isPermitted returns true if one of the user's roles grants the action on the resource of the given type.
+// Resource types without named resources are passed an empty resourceName.
+func (m Manifest) isPermitted(user string, resourceType string, resourceName string, action string) bool {
+	for _, roleName := range m.Users[user].Roles {
+		role := m.Roles[roleName]
+		if role.ResourceType != resourceType || !contains(role.Actions, action) {
+			continue
+		}
+		if len(role.ResourceNames) == 0 || contains(role.ResourceNames, resourceName) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPermittedUser returns true if one of the manifest's users is permitted to perform the action on the resource of the given type.
+func (m Manifest) hasPermittedUser(resourceType string, resourceName string, action string) bool {
+	for name := range m.Users {
+		if m.isPermitted(name, resourceType, resourceName, action) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func parsePEMCertificate(data string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM-encoded certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// applyUpdate returns a new manifest with the packages and marbles of the update applied.
+//
+// An update may add new packages and marbles, and raise the SecurityVersion of existing packages.
+// All other sections of the manifest cannot be updated.
+func (m Manifest) applyUpdate(update Manifest) (Manifest, error) {
+	if len(update.Infrastructures) > 0 || len(update.Users) > 0 || len(update.Roles) > 0 || update.UpdateThreshold != 0 || len(update.Secrets) > 0 || len(update.RecoveryKeys) > 0 || update.RecoveryKey != "" || update.RecoveryThreshold != 0 ||
+		update.MarbleCertificate != (MarbleCertificateConfig{}) || len(update.PackageCertificates) > 0 || len(update.TLS) > 0 || update.RootCA != "" ||
+		len(update.ExternalServices) > 0 || update.SPIFFETrustDomain != "" {
+		return Manifest{}, errors.New("update may only contain Packages, Marbles and MarbleGroups")
+	}
+	if len(update.Packages) == 0 && len(update.Marbles) == 0 {
+		return Manifest{}, errors.New("update does not contain any Packages or Marbles")
+	}
+
+	updated := m
+	updated.Packages = make(map[string]quote.PackageProperties, len(m.Packages)+len(update.Packages))
+	for name, pkg := range m.Packages {
+		updated.Packages[name] = pkg
+	}
+	updated.Marbles = make(map[string]Marble, len(m.Marbles)+len(update.Marbles))
+	for name, marble := range m.Marbles {
+		updated.Marbles[name] = marble
+	}
+
+	for name, pkg := range update.Packages {
+		currentPkg, ok := m.Packages[name]
+		if !ok {
+			updated.Packages[name] = pkg
+			continue
+		}
+		if err := checkPackageUpdate(currentPkg, pkg); err != nil {
+			return Manifest{}, fmt.Errorf("invalid update of package %s: %v", name, err)
+		}
+		updated.Packages[name] = pkg
+	}
+
+	for name, marble := range update.Marbles {
+		if _, ok := m.Marbles[name]; ok {
+			return Manifest{}, fmt.Errorf("marble %s already exists", name)
+		}
+		updated.Marbles[name] = marble
+	}
+
+	// new marbles may join groups, so that they get the secrets of the groups
+	if len(update.MarbleGroups) > 0 {
+		updated.MarbleGroups = make(map[string][]string, len(m.MarbleGroups)+len(update.MarbleGroups))
+		for name, members := range m.MarbleGroups {
+			updated.MarbleGroups[name] = members
+		}
+		for name, members := range update.MarbleGroups {
+			for _, member := range members {
+				if _, ok := update.Marbles[member]; !ok {
+					return Manifest{}, fmt.Errorf("marble %s can't join group %s, only marbles of the update can", member, name)
+				}
+			}
+			updated.MarbleGroups[name] = append(append([]string{}, m.MarbleGroups[name]...), members...)
+		}
+	}
+
+	return updated, nil
+}
+
+// checkPackageUpdate checks that an update of a package only raises its SecurityVersion.
+func checkPackageUpdate(current, update quote.PackageProperties) error {
+	if current.Debug != update.Debug || current.UniqueID != update.UniqueID || current.SignerID != update.SignerID || current.Measurement != update.Measurement {
+		return errors.New("only SecurityVersion can be updated")
+	}
+	if (current.Policy == nil) != (update.Policy == nil) || (current.Policy != nil && *current.Policy != *update.Policy) {
+		return errors.New("only SecurityVersion can be updated")
+	}
+	if !reflect.DeepEqual(current.PCRs, update.PCRs) {
+		return errors.New("only SecurityVersion can be updated")
+	}
+	if (current.ProductID == nil) != (update.ProductID == nil) || (current.ProductID != nil && *current.ProductID != *update.ProductID) {
+		return errors.New("only SecurityVersion can be updated")
+	}
+	if current.SecurityVersion == nil || update.SecurityVersion == nil {
+		return errors.New("SecurityVersion is not set")
+	}
+	if *update.SecurityVersion < *current.SecurityVersion {
+		return fmt.Errorf("SecurityVersion cannot be lowered from %d to %d", *current.SecurityVersion, *update.SecurityVersion)
+	}
+	return nil
+}
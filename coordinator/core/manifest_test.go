@@ -0,0 +1,214 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/marblerun/marble/config"
+	"github.com/edgelesssys/marblerun/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestManifestCheckSecrets(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(err)
+	defer zapLogger.Sync()
+
+	var manifest Manifest
+	require.NoError(json.Unmarshal([]byte(test.ManifestJSON), &manifest))
+	require.NoError(manifest.Check(context.TODO(), zapLogger))
+	manifest.MarbleGroups = map[string][]string{"backend": {"backend_first", "backend_other"}}
+
+	testCases := map[string]struct {
+		secret Secret
+		valid  bool
+	}{
+		"symmetric-key":              {Secret{Type: "symmetric-key", Size: 128}, true},
+		"symmetric-key no size":      {Secret{Type: "symmetric-key"}, false},
+		"symmetric-key invalid size": {Secret{Type: "symmetric-key", Size: 12}, false},
+		"cert-rsa":                   {Secret{Type: "cert-rsa", Size: 2048}, true},
+		"cert-rsa no size":           {Secret{Type: "cert-rsa"}, false},
+		"cert-ed25519":               {Secret{Type: "cert-ed25519"}, true},
+		"cert-ed25519 with size":     {Secret{Type: "cert-ed25519", Size: 256}, false},
+		"cert-ecdsa":                 {Secret{Type: "cert-ecdsa", Size: 384}, true},
+		"cert-ecdsa invalid curve":   {Secret{Type: "cert-ecdsa", Size: 512}, false},
+		"unknown type":               {Secret{Type: "crap", Size: 128}, false},
+		"plain":                      {Secret{Type: "plain"}, false},
+		"user-defined plain":         {Secret{Type: "plain", UserDefined: true}, true},
+		"user-defined plain size":    {Secret{Type: "plain", Size: 128, UserDefined: true}, false},
+		"user-defined symmetric-key": {Secret{Type: "symmetric-key", UserDefined: true}, true},
+		"user-defined cert-rsa":      {Secret{Type: "cert-rsa", UserDefined: true}, true},
+		"user-defined cert-rsa size": {Secret{Type: "cert-rsa", Size: 2048, UserDefined: true}, false},
+		"user-defined validity":      {Secret{Type: "cert-ecdsa", ValidFor: 7, UserDefined: true}, false},
+		"entitled marbles":           {Secret{Type: "symmetric-key", Size: 128, Marbles: []string{"frontend"}}, true},
+		"undefined entitled marble":  {Secret{Type: "symmetric-key", Size: 128, Marbles: []string{"unknown"}}, false},
+		"group":                      {Secret{Type: "symmetric-key", Size: 128, Shared: true, Groups: []string{"backend"}}, true},
+		"user-defined group":         {Secret{Type: "plain", UserDefined: true, Groups: []string{"backend"}}, true},
+		"undefined group":            {Secret{Type: "symmetric-key", Size: 128, Shared: true, Groups: []string{"unknown"}}, false},
+		"unique group secret":        {Secret{Type: "symmetric-key", Size: 128, Groups: []string{"backend"}}, false},
+		"ambiguous validity": {
+			Secret{Type: "cert-ed25519", ValidFor: 7, Cert: Certificate{NotAfter: time.Now().Add(time.Hour)}},
+			false,
+		},
+	}
+
+	// the secrets that the marbles reference must stay defined
+	definedSecrets := manifest.Secrets
+	for name, tc := range testCases {
+		// non-shared secrets are only generated on activation, so they must be checked as well
+		manifest.Secrets = map[string]Secret{"secret": tc.secret}
+		for name, secret := range definedSecrets {
+			manifest.Secrets[name] = secret
+		}
+		err := manifest.Check(context.TODO(), zapLogger)
+		if tc.valid {
+			assert.NoError(err, name)
+		} else {
+			assert.Error(err, name)
+		}
+	}
+}
+
+func TestManifestCheckMarbleCertificate(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(err)
+	defer zapLogger.Sync()
+
+	var manifest Manifest
+	require.NoError(json.Unmarshal([]byte(test.ManifestJSON), &manifest))
+
+	testCases := map[string]struct {
+		config   MarbleCertificateConfig
+		packages map[string]MarbleCertificateConfig
+		valid    bool
+	}{
+		"default":                    {MarbleCertificateConfig{}, nil, true},
+		"ecdsa":                      {MarbleCertificateConfig{ValidFor: 30, KeyType: "ecdsa", KeySize: 384, SignatureAlgorithm: "ECDSA-SHA384"}, nil, true},
+		"ecdsa invalid curve":        {MarbleCertificateConfig{KeyType: "ecdsa", KeySize: 521}, nil, false},
+		"rsa":                        {MarbleCertificateConfig{KeyType: "rsa", KeySize: 3072}, nil, true},
+		"rsa invalid size":           {MarbleCertificateConfig{KeyType: "rsa", KeySize: 1024}, nil, false},
+		"ed25519":                    {MarbleCertificateConfig{KeyType: "ed25519"}, nil, true},
+		"ed25519 with size":          {MarbleCertificateConfig{KeyType: "ed25519", KeySize: 256}, nil, false},
+		"unknown key type":           {MarbleCertificateConfig{KeyType: "dsa"}, nil, false},
+		"unsupported signature":      {MarbleCertificateConfig{SignatureAlgorithm: "SHA256-RSA"}, nil, false},
+		"package override":           {MarbleCertificateConfig{}, map[string]MarbleCertificateConfig{"frontend": {KeyType: "rsa"}}, true},
+		"undefined package":          {MarbleCertificateConfig{}, map[string]MarbleCertificateConfig{"unknown": {KeyType: "rsa"}}, false},
+		"invalid package override":   {MarbleCertificateConfig{}, map[string]MarbleCertificateConfig{"frontend": {KeyType: "rsa", KeySize: 384}}, false},
+		"key size inherited by type": {MarbleCertificateConfig{KeyType: "ecdsa", KeySize: 384}, map[string]MarbleCertificateConfig{"frontend": {KeyType: "rsa"}}, true},
+	}
+
+	for name, tc := range testCases {
+		manifest.MarbleCertificate = tc.config
+		manifest.PackageCertificates = tc.packages
+		err := manifest.Check(context.TODO(), zapLogger)
+		if tc.valid {
+			assert.NoError(err, name)
+		} else {
+			assert.Error(err, name)
+		}
+	}
+
+	// package settings override the manifest's settings, the key size is only inherited together with the key type
+	manifest.MarbleCertificate = MarbleCertificateConfig{ValidFor: 30, KeyType: "ecdsa", KeySize: 384, SignatureAlgorithm: "ECDSA-SHA384"}
+	manifest.PackageCertificates = map[string]MarbleCertificateConfig{"frontend": {KeyType: "rsa"}, "backend": {ValidFor: 7}}
+	assert.Equal(MarbleCertificateConfig{ValidFor: 30, KeyType: "rsa", SignatureAlgorithm: "ECDSA-SHA384"}, manifest.marbleCertificateConfig("frontend"))
+	assert.Equal(MarbleCertificateConfig{ValidFor: 7, KeyType: "ecdsa", KeySize: 384, SignatureAlgorithm: "ECDSA-SHA384"}, manifest.marbleCertificateConfig("backend"))
+}
+
+func TestManifestCheckMarbleTLS(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(err)
+	defer zapLogger.Sync()
+
+	testCases := map[string]struct {
+		tls   *MarbleTLS
+		valid bool
+	}{
+		"none":               {nil, true},
+		"dns names":          {&MarbleTLS{DNSNames: []string{"backend.svc", "{{ .UUID }}.{{ .MarbleType }}.svc"}}, true},
+		"ip addresses":       {&MarbleTLS{IPAddresses: []string{"10.0.0.1", "::1"}}, true},
+		"invalid template":   {&MarbleTLS{DNSNames: []string{"{{ .UUID }"}}, false},
+		"unknown metadata":   {&MarbleTLS{DNSNames: []string{"{{ .Unknown }}.svc"}}, false},
+		"empty dns name":     {&MarbleTLS{DNSNames: []string{""}}, false},
+		"invalid ip address": {&MarbleTLS{IPAddresses: []string{"10.0.0"}}, false},
+	}
+
+	for name, tc := range testCases {
+		var manifest Manifest
+		require.NoError(json.Unmarshal([]byte(test.ManifestJSON), &manifest))
+		marble := manifest.Marbles["frontend"]
+		marble.TLS = tc.tls
+		manifest.Marbles["frontend"] = marble
+		err := manifest.Check(context.TODO(), zapLogger)
+		if tc.valid {
+			assert.NoError(err, name)
+		} else {
+			assert.Error(err, name)
+		}
+	}
+}
+
+func TestManifestCheckTTLS(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(err)
+	defer zapLogger.Sync()
+
+	web := config.TTLS{Incoming: []config.TTLSIncoming{{Port: 8443, Target: "localhost:8080"}}}
+	db := config.TTLS{Outgoing: []config.TTLSOutgoing{{Port: 5432, Addr: "db.svc:5432"}}}
+
+	testCases := map[string]struct {
+		tags  map[string]config.TTLS
+		tls   *MarbleTLS
+		valid bool
+	}{
+		"none":              {nil, nil, true},
+		"unreferenced tags": {map[string]config.TTLS{"web": web}, nil, true},
+		"tags":              {map[string]config.TTLS{"web": web, "db": db}, &MarbleTLS{Tags: []string{"web", "db"}}, true},
+		"undefined tag":     {map[string]config.TTLS{"web": web}, &MarbleTLS{Tags: []string{"db"}}, false},
+		"missing port":      {map[string]config.TTLS{"db": {Outgoing: []config.TTLSOutgoing{{Addr: "db.svc:5432"}}}}, &MarbleTLS{Tags: []string{"db"}}, false},
+		"invalid address":   {map[string]config.TTLS{"db": {Outgoing: []config.TTLSOutgoing{{Port: 5432, Addr: "db.svc"}}}}, &MarbleTLS{Tags: []string{"db"}}, false},
+		"invalid target":    {map[string]config.TTLS{"web": {Incoming: []config.TTLSIncoming{{Port: 8443, Target: "8080"}}}}, &MarbleTLS{Tags: []string{"web"}}, false},
+		"duplicate port": {
+			map[string]config.TTLS{"web": web, "other": {Outgoing: []config.TTLSOutgoing{{Port: 8443, Addr: "other.svc:8443"}}}},
+			&MarbleTLS{Tags: []string{"web", "other"}},
+			false,
+		},
+	}
+
+	for name, tc := range testCases {
+		var manifest Manifest
+		require.NoError(json.Unmarshal([]byte(test.ManifestJSON), &manifest))
+		manifest.TLS = tc.tags
+		marble := manifest.Marbles["frontend"]
+		marble.TLS = tc.tls
+		manifest.Marbles["frontend"] = marble
+		err := manifest.Check(context.TODO(), zapLogger)
+		if tc.valid {
+			assert.NoError(err, name)
+		} else {
+			assert.Error(err, name)
+		}
+	}
+}
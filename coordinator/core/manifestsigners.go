@@ -0,0 +1,93 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+// ErrManifestSignature is returned by SetManifest if the manifest must be signed by a trusted signer, but the signature is missing or invalid.
+var ErrManifestSignature = errors.New("the manifest is not signed by a trusted signer")
+
+// SetManifestSigners restricts SetManifest to manifests that are signed by one of the signers.
+//
+// signers maps the name of each signer to its PEM-encoded PKIX public key (ECDSA, RSA or Ed25519).
+// It must be set before the manifest is set and should be part of the Coordinator's measured configuration, so that it can't be replaced by the host.
+func (c *Core) SetManifestSigners(signers map[string]string) error {
+	keys := make(map[string]crypto.PublicKey, len(signers))
+	for name, rawKey := range signers {
+		block, _ := pem.Decode([]byte(rawKey))
+		if block == nil || block.Type != "PUBLIC KEY" {
+			return fmt.Errorf("manifest signer %s: expected a PEM-encoded PUBLIC KEY", name)
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("manifest signer %s: %v", name, err)
+		}
+		switch key.(type) {
+		case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		default:
+			return fmt.Errorf("manifest signer %s: unsupported key type %T", name, key)
+		}
+		keys[name] = key
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.manifestSigners = keys
+	return nil
+}
+
+// verifyManifestSignature returns the name of the trusted signer that signed rawManifest.
+//
+// ECDSA signatures are ASN.1-encoded and RSA signatures use PKCS #1 v1.5, both over the SHA-256 hash of the manifest, like those created by
+// "openssl dgst -sha256 -sign". Ed25519 signatures are over the manifest itself.
+func (c *Core) verifyManifestSignature(rawManifest []byte, signature []byte) (string, error) {
+	if len(signature) == 0 {
+		return "", ErrManifestSignature
+	}
+	names := make([]string, 0, len(c.manifestSigners))
+	for name := range c.manifestSigners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hash := sha256.Sum256(rawManifest)
+	for _, name := range names {
+		if verifySignature(c.manifestSigners[name], rawManifest, hash[:], signature) {
+			return name, nil
+		}
+	}
+	return "", ErrManifestSignature
+}
+
+func verifySignature(key crypto.PublicKey, message []byte, hash []byte, signature []byte) bool {
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		var sig struct{ R, S *big.Int }
+		if rest, err := asn1.Unmarshal(signature, &sig); err != nil || len(rest) > 0 {
+			return false
+		}
+		return ecdsa.Verify(key, hash, sig.R, sig.S)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash, signature) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, message, signature)
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"testing"
+
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+	"github.com/edgelesssys/marblerun/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetManifestSigners(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(err)
+	ed25519Pub, ed25519Priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(err)
+	signers := map[string]string{
+		"ecdsa":   mustEncodePublicKey(t, &ecdsaKey.PublicKey),
+		"rsa":     mustEncodePublicKey(t, &rsaKey.PublicKey),
+		"ed25519": mustEncodePublicKey(t, ed25519Pub),
+	}
+
+	c := NewCoreWithMocks()
+	assert.Error(c.SetManifestSigners(map[string]string{"invalid": "not PEM"}))
+	assert.Error(c.SetManifestSigners(map[string]string{"cert": test.CertPEM(test.AdminCert)}))
+	require.NoError(c.SetManifestSigners(signers))
+
+	rawManifest := []byte(test.ManifestJSON)
+	hash := sha256.Sum256(rawManifest)
+	r, s, err := ecdsa.Sign(rand.Reader, ecdsaKey, hash[:])
+	require.NoError(err)
+	ecdsaSignature, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	require.NoError(err)
+	rsaSignature, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, hash[:])
+	require.NoError(err)
+	ed25519Signature := ed25519.Sign(ed25519Priv, rawManifest)
+
+	for signature, expectedSigner := range map[string]string{
+		string(ecdsaSignature):   "ecdsa",
+		string(rsaSignature):     "rsa",
+		string(ed25519Signature): "ed25519",
+	} {
+		signer, err := c.verifyManifestSignature(rawManifest, []byte(signature))
+		require.NoError(err)
+		assert.Equal(expectedSigner, signer)
+	}
+	_, err = c.verifyManifestSignature(append(rawManifest, ' '), rsaSignature)
+	assert.Equal(ErrManifestSignature, err)
+	_, err = c.verifyManifestSignature(rawManifest, nil)
+	assert.Equal(ErrManifestSignature, err)
+
+	// unsigned manifests are rejected
+	_, err = c.SetManifest(context.TODO(), rawManifest, nil)
+	assert.Equal(ErrManifestSignature, err)
+	_, err = c.SetManifest(context.TODO(), rawManifest, ed25519.Sign(ed25519Priv, []byte("other")))
+	assert.Equal(ErrManifestSignature, err)
+	assert.Equal(stateAcceptingManifest, c.state)
+
+	_, err = c.SetManifest(context.TODO(), rawManifest, ed25519Signature)
+	require.NoError(err)
+	manifestSignature, signer := c.GetManifestSignature(context.TODO())
+	assert.Equal(hash[:], manifestSignature)
+	assert.Equal("ed25519", signer)
+	entries, err := c.verifiedAuditLog()
+	require.NoError(err)
+	require.Len(entries, 1)
+	assert.Equal(clientapi.AuditEventSetManifest, entries[0].Event)
+	assert.Equal("ed25519", entries[0].Details["Signer"])
+
+	// the signature survives a restart
+	c2, err := NewCore([]string{"localhost"}, c.qv, c.qi, c.sealer, nil, false, c.zaplogger)
+	require.NoError(err)
+	_, signer = c2.GetManifestSignature(context.TODO())
+	assert.Equal("ed25519", signer)
+	assert.Equal(ed25519Signature, c2.manifestSignerSignature)
+}
+
+func mustEncodePublicKey(t *testing.T, pub crypto.PublicKey) string {
+	encoded, err := x509.MarshalPKIXPublicKey(pub)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: encoded}))
+}
@@ -9,18 +9,36 @@ package core
 import (
 	"bytes"
 	"context"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/x509"
-	"math"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"text/template"
+	"text/template/parse"
 	"time"
 
 	"github.com/edgelesssys/ertgolib/marble"
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+	"github.com/edgelesssys/marblerun/coordinator/quote"
 	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/edgelesssys/marblerun/marble/config"
+	"github.com/edgelesssys/marblerun/marble/identity"
 	"github.com/edgelesssys/marblerun/util"
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -28,14 +46,19 @@ import (
 
 type reservedSecrets struct {
 	RootCA     Secret
+	PackageCA  Secret
 	MarbleCert Secret
 	SealKey    Secret
+	// issuerChain is appended to the marble's certificate chain, see Core.issuerChainPEM
+	issuerChain string
 }
 
 // Defines the "Marblerun" prefix when mentioned in a manifest
 type secretsWrapper struct {
 	Marblerun reservedSecrets
 	Secrets   map[string]Secret
+	// HostEnv maps the host environment variables that the marble passes through to their placeholders, see rpc.HostEnvPlaceholder
+	HostEnv map[string]string
 }
 
 // Activate implements the MarbleAPI function to authenticate a marble (implements the MarbleServer interface)
@@ -46,11 +69,33 @@ type secretsWrapper struct {
 //
 // Returns a signed certificate-key-pair and the application's parameters if the authentication was successful.
 // Returns an error if the authentication failed.
-func (c *Core) Activate(ctx context.Context, req *rpc.ActivationReq) (*rpc.ActivationResp, error) {
-	c.zaplogger.Info("Received activation request", zap.String("MarbleType", req.MarbleType))
-	defer c.mux.Unlock()
-	if err := c.requireState(stateAcceptingMarbles); err != nil {
-		return nil, status.Error(codes.FailedPrecondition, "cannot accept marbles in current state")
+func (c *Core) Activate(ctx context.Context, req *rpc.ActivationReq) (resp *rpc.ActivationResp, err error) {
+	logger := c.requestLogger(ctx).With(zap.String("MarbleType", req.MarbleType))
+	logger.Info("Received activation request")
+
+	marbleTypeLabel := c.marbleTypeLabel(req.GetMarbleType())
+	activationAttempts.WithLabelValues(marbleTypeLabel).Inc()
+	start := time.Now()
+	var infrastructure string
+	defer func() {
+		c.recordActivationResult(marbleTypeLabel, err != nil)
+		c.publishActivationResult(marbleTypeLabel, req.GetUUID(), infrastructure, err)
+		if err != nil {
+			activationFailures.WithLabelValues(marbleTypeLabel).Inc()
+			return
+		}
+		activationSuccesses.WithLabelValues(marbleTypeLabel, infrastructure).Inc()
+		activationDuration.WithLabelValues(marbleTypeLabel, infrastructure).Observe(time.Since(start).Seconds())
+	}()
+
+	// floods of requests must not starve legitimate marbles of the quote verification
+	if err := c.limitActivation(ctx, marbleTypeLabel); err != nil {
+		return nil, err
+	}
+
+	apiVersion, err := negotiateAPIVersion(req.GetAPIVersion())
+	if err != nil {
+		return nil, err
 	}
 
 	// get the marble's TLS cert (used in this connection) and check corresponding quote
@@ -58,7 +103,21 @@ func (c *Core) Activate(ctx context.Context, req *rpc.ActivationReq) (*rpc.Activ
 	if tlsCert == nil {
 		return nil, status.Error(codes.Unauthenticated, "couldn't get marble TLS certificate")
 	}
-	if err := c.verifyManifestRequirement(tlsCert, req.GetQuote(), req.GetMarbleType()); err != nil {
+	quoteMessage, err := c.quoteMessage(tlsCert, req)
+	if err != nil {
+		return nil, err
+	}
+	releaseVerification, err := c.acquireVerification(ctx)
+	if err != nil {
+		return nil, err
+	}
+	spanCtx, span := tracer.Start(ctx, "validateQuote")
+	phaseStart := time.Now()
+	manifestVersion, infrastructure, reportedProps, err := c.validateQuote(quoteMessage, req.GetQuote(), req.GetMarbleType())
+	observePhase(marbleTypeLabel, phaseQuoteVerification, phaseStart)
+	endSpan(spanCtx, span, err)
+	releaseVerification()
+	if err != nil {
 		return nil, err
 	}
 
@@ -66,78 +125,515 @@ func (c *Core) Activate(ctx context.Context, req *rpc.ActivationReq) (*rpc.Activ
 	if err != nil {
 		return nil, err
 	}
+	logger = logger.With(zap.String("UUID", marbleUUID.String()))
 
+	// Only the activation counters and the state are updated exclusively. The keys and certificates of the marble are generated while
+	// the Coordinator is only read-locked, so that activations proceed in parallel. The checks are repeated when the activation is recorded.
+	c.mux.Lock()
+	_, err = c.checkActivation(req, infrastructure, marbleUUID.String(), manifestVersion)
+	var caCert *x509.Certificate
+	var caPrivk *ecdsa.PrivateKey
+	if err == nil {
+		// the package CA is created on first use
+		caCert, caPrivk, err = c.getPackageCA(c.manifest.Marbles[req.GetMarbleType()].Package)
+		if err != nil {
+			err = status.Error(codes.Internal, "failed to get package CA")
+		}
+	}
+	c.mux.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	backendSecrets, err := c.marbleBackendSecrets(ctx, req.GetMarbleType())
+	if err != nil {
+		return nil, err
+	}
+
+	c.mux.RLock()
 	// Generate marble authentication secrets
-	authSecrets, err := c.generateMarbleAuthSecrets(req, marbleUUID)
+	spanCtx, span = tracer.Start(ctx, "generateMarbleCert")
+	phaseStart = time.Now()
+	authSecrets, err := c.generateMarbleAuthSecrets(req.GetCSR(), req.GetMarbleType(), marbleUUID, caCert, caPrivk)
+	observePhase(marbleTypeLabel, phaseCertificateIssuance, phaseStart)
+	endSpan(spanCtx, span, err)
+	var params *rpc.Parameters
+	if err == nil {
+		params, err = c.marbleParameters(ctx, req.GetMarbleType(), marbleUUID, authSecrets, backendSecrets, logger)
+	}
+	c.mux.RUnlock()
 	if err != nil {
 		return nil, err
 	}
 
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	// the parameters have been generated with the manifest at the time of the quote validation if it hasn't been updated since
+	resumed, err := c.checkActivation(req, infrastructure, marbleUUID.String(), manifestVersion)
+	if err != nil {
+		return nil, err
+	}
+	marble := c.manifest.Marbles[req.GetMarbleType()]
+	resumptionToken, err := c.resumptionToken(req.GetMarbleType(), marbleUUID.String())
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to create resumption token")
+	}
+
+	// write response
+	resp = &rpc.ActivationResp{
+		Parameters: params,
+		APIVersion: apiVersion,
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: authSecrets.MarbleCert.Cert.Raw})) +
+			string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: authSecrets.PackageCA.Cert.Raw})) + c.issuerChainPEM(),
+		PrivateKey:      string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: authSecrets.MarbleCert.Private})),
+		ResumptionToken: resumptionToken,
+	}
+
+	// persist the activation counter, otherwise MaxActivations could be bypassed by restarting the Coordinator
+	undoActivation := c.recordActivation(req.GetMarbleType(), marbleUUID.String(), infrastructure, reportedProps, resumed)
+	undoQuote := c.recordQuote(req.GetQuote())
+	oldMarbleCerts := c.marbleCerts
+	securityVersion := attestedSecurityVersion(c.manifest.Packages[marble.Package].SecurityVersion, reportedProps.SecurityVersion)
+	c.recordMarbleCert((*x509.Certificate)(&authSecrets.MarbleCert.Cert), req.GetMarbleType(), marble.Package, securityVersion)
+	oldAuditLog := c.auditLog
+	details := map[string]string{
+		"MarbleType":     req.GetMarbleType(),
+		"UUID":           marbleUUID.String(),
+		"Infrastructure": infrastructure,
+	}
+	if resumed {
+		details["Resumed"] = "true"
+	}
+	c.appendAuditEntry(ctx, clientapi.AuditEventActivate, "", details)
+	spanCtx, span = tracer.Start(ctx, "sealState")
+	phaseStart = time.Now()
+	_, err = c.sealState()
+	observePhase(marbleTypeLabel, phaseStateWrite, phaseStart)
+	endSpan(spanCtx, span, err)
+	if err != nil {
+		undoActivation()
+		undoQuote()
+		c.auditLog = oldAuditLog
+		c.marbleCerts = oldMarbleCerts
+		logger.Error("sealState failed", zap.Error(err))
+		if err == ErrStateConflict {
+			return nil, status.Error(codes.Aborted, err.Error())
+		}
+		return nil, status.Error(codes.Internal, "failed to persist state")
+	}
+
+	logger.Info("Successfully activated new Marble", zap.Bool("Resumed", resumed))
+	return resp, nil
+}
+
+// marbleParameters returns the parameters of the marble with the UUID as defined for its type in the manifest, customized with its authentication secrets and the secrets it is entitled to.
+//
+// backendSecrets are the secrets of the secrets backend the parameters reference, see Core.marbleBackendSecrets.
+func (c *Core) marbleParameters(ctx context.Context, marbleType string, marbleUUID uuid.UUID, authSecrets reservedSecrets, backendSecrets map[string]Secret, logger *zap.Logger) (*rpc.Parameters, error) {
+	// the marble may only reference the secrets it is entitled to
+	marble := c.manifest.Marbles[marbleType]
+	referenced, err := referencedSecrets(marble.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range referenced {
+		if secret, ok := c.manifest.Secrets[name]; ok && !c.manifest.isEntitled(secret, marbleType) {
+			return nil, status.Errorf(codes.PermissionDenied, "marble type %s is not entitled to secret %s", marbleType, name)
+		}
+	}
+	entitledSecrets := c.manifest.entitledSecrets(marbleType)
+
 	// Generate user-defined unique (= per marble) secrets
-	secrets, err := c.generateSecrets(ctx, c.manifest.Secrets, marbleUUID)
+	secrets, err := c.generateSecrets(ctx, entitledSecrets, marbleUUID)
 	if err != nil {
-		c.zaplogger.Error("Could not generate specified secrets for the given manifest.", zap.Error(err))
+		logger.Error("Could not generate specified secrets for the given manifest.", zap.Error(err))
 		return nil, err
 	}
 
 	// Union user-defined unique secrets with user-defined shared secrets
 	for k, v := range c.secrets {
-		secrets[k] = v
+		if _, ok := entitledSecrets[k]; ok {
+			secrets[k] = v
+		}
+	}
+	for k, v := range backendSecrets {
+		if _, ok := entitledSecrets[k]; ok {
+			secrets[k] = v
+		}
 	}
 
-	marble := c.manifest.Marbles[req.GetMarbleType()] // existence has been checked in verifyManifestRequirement
 	params, err := customizeParameters(marble.Parameters, authSecrets, secrets)
 	if err != nil {
-		c.zaplogger.Error("Could not customize parameters.", zap.Error(err))
+		logger.Error("Could not customize parameters.", zap.Error(err))
 		return nil, err
 	}
-
-	// write response
-	resp := &rpc.ActivationResp{
-		Parameters: params,
+	if ttls := c.manifest.ttlsConfig(marble); len(ttls.Outgoing) > 0 || len(ttls.Incoming) > 0 {
+		ttlsConfig, err := json.Marshal(ttls)
+		if err != nil {
+			return nil, err
+		}
+		params.Env[config.TTLSConfig] = string(ttlsConfig)
 	}
+	return params, nil
+}
 
-	c.zaplogger.Info("Successfully activated new Marble", zap.String("MarbleType", req.MarbleType), zap.String("UUID", marbleUUID.String()))
-	c.activations[req.GetMarbleType()]++
-	return resp, nil
+// negotiateAPIVersion returns the highest activation API version supported by both the marble and the Coordinator.
+//
+// Marbles that don't send a version implement version 1.
+func negotiateAPIVersion(requested uint32) (uint32, error) {
+	if requested == 0 {
+		requested = 1
+	}
+	if requested < rpc.MinActivationAPIVersion {
+		return 0, status.Errorf(codes.FailedPrecondition, "activation API version %v is not supported, the Coordinator supports versions %v to %v", requested, rpc.MinActivationAPIVersion, rpc.ActivationAPIVersion)
+	}
+	if requested > rpc.ActivationAPIVersion {
+		return rpc.ActivationAPIVersion, nil
+	}
+	return requested, nil
 }
 
-// verifyManifestRequirement verifies marble attempting to register with respect to manifest
-func (c *Core) verifyManifestRequirement(tlsCert *x509.Certificate, quote []byte, marbleType string) error {
-	marble, ok := c.manifest.Marbles[marbleType]
+// Renew implements the MarbleAPI function to renew the certificate of an activated marble (implements the MarbleServer interface)
+//
+// The marble authenticates with its current certificate, which must be valid and issued by one of the package CAs.
+// The new certificate is issued for the same marble UUID and package with the current certificate configuration of the manifest.
+// Renewals do not count towards the MaxActivations of the marble's type.
+// Marbles that have been revoked cannot renew their certificates, nor can marbles while the activations are paused.
+// As renewals aren't attested, the marble must activate again once the manifest no longer contains its type and package
+// or requires a higher SecurityVersion of its package than the marble has been attested with.
+func (c *Core) Renew(ctx context.Context, req *rpc.RenewalReq) (*rpc.RenewalResp, error) {
+	logger := c.requestLogger(ctx)
+	tlsCert := getClientTLSCert(ctx)
+	if tlsCert == nil {
+		return nil, status.Error(codes.Unauthenticated, "couldn't get marble TLS certificate")
+	}
+
+	defer c.mux.Unlock()
+	if err := c.requireState(stateAcceptingMarbles); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, "cannot renew marble certificates in current state")
+	}
+	if c.maintenance != nil {
+		return nil, c.maintenance.errMaintenance()
+	}
+
+	pkg, err := c.verifyMarbleCert(tlsCert)
+	if err != nil {
+		logger.Info("Rejected renewal request", zap.Error(err))
+		return nil, status.Error(codes.Unauthenticated, "invalid marble certificate")
+	}
+	marbleUUID, err := uuid.Parse(tlsCert.Subject.CommonName)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid marble certificate")
+	}
+	if c.isRevokedMarble(marbleUUID.String()) {
+		logger.Info("Rejected renewal request of revoked marble", zap.String("UUID", marbleUUID.String()))
+		return nil, status.Error(codes.Unauthenticated, "marble certificate has been revoked")
+	}
+
+	current, ok := c.findMarbleCert(tlsCert.SerialNumber)
 	if !ok {
-		return status.Error(codes.InvalidArgument, "unknown marble type requested")
+		logger.Info("Rejected renewal request of unknown certificate", zap.String("UUID", marbleUUID.String()))
+		return nil, status.Error(codes.Unauthenticated, "unknown marble certificate")
+	}
+	if err := c.checkMarbleAttestation(current); err != nil {
+		logger.Info("Rejected renewal request", zap.String("UUID", marbleUUID.String()), zap.Error(err))
+		return nil, err
 	}
 
-	pkg, ok := c.manifest.Packages[marble.Package]
+	// the subject alternative names of the current certificate are kept
+	spanCtx, span := tracer.Start(ctx, "generateMarbleCert")
+	marbleCert, caCert, privk, err := c.generateMarbleCert(req.GetCSR(), current.MarbleType, pkg, marbleUUID.String(), tlsCert.DNSNames, tlsCert.IPAddresses)
+	endSpan(spanCtx, span, err)
+	if err != nil {
+		return nil, err
+	}
+	encodedPrivKey, err := x509.MarshalPKCS8PrivateKey(privk)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encode private key")
+	}
+
+	// the new certificate must be recorded, so that it can be revoked
+	oldMarbleCerts := c.marbleCerts
+	c.recordMarbleCert(marbleCert, current.MarbleType, pkg, current.SecurityVersion)
+	if _, err := c.sealState(); err != nil {
+		c.marbleCerts = oldMarbleCerts
+		logger.Error("sealState failed", zap.Error(err))
+		if err == ErrStateConflict {
+			return nil, status.Error(codes.Aborted, err.Error())
+		}
+		return nil, status.Error(codes.Internal, "failed to persist state")
+	}
+
+	certificateRenewals.WithLabelValues(current.MarbleType).Inc()
+	logger.Info("Renewed marble certificate", zap.String("Package", pkg), zap.String("UUID", marbleUUID.String()))
+	return &rpc.RenewalResp{
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: marbleCert.Raw})) +
+			string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})) + c.issuerChainPEM(),
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: encodedPrivKey})),
+	}, nil
+}
+
+// verifyMarbleCert verifies that the certificate is a valid marble certificate and returns the package of the CA that issued it
+func (c *Core) verifyMarbleCert(cert *x509.Certificate) (string, error) {
+	for pkg := range c.packageCAs {
+		caCert, _, err := c.getPackageCA(pkg)
+		if err != nil {
+			return "", err
+		}
+		roots := x509.NewCertPool()
+		roots.AddCert(caCert)
+		if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err == nil {
+			return pkg, nil
+		}
+	}
+	return "", errors.New("certificate has not been issued by a package CA")
+}
+
+// checkMarbleAttestation checks that the attestation of the marble the recorded certificate has been issued to still satisfies the manifest,
+// i.e., that its marble type and package are still defined and the package doesn't require a higher SecurityVersion.
+// The Core must be locked.
+func (c *Core) checkMarbleAttestation(cert marbleCert) error {
+	marble, ok := c.manifest.Marbles[cert.MarbleType]
+	if !ok || marble.Package != cert.Package {
+		return status.Errorf(codes.PermissionDenied, "marble type %v with package %v is no longer defined by the manifest, the marble must activate again", cert.MarbleType, cert.Package)
+	}
+	pkg, ok := c.manifest.Packages[cert.Package]
 	if !ok {
+		return status.Errorf(codes.PermissionDenied, "package %v is no longer defined by the manifest, the marble must activate again", cert.Package)
+	}
+	if pkg.SecurityVersion != nil && (cert.SecurityVersion == nil || *pkg.SecurityVersion > *cert.SecurityVersion) {
+		return status.Errorf(codes.PermissionDenied, "the manifest requires SecurityVersion %v of package %v, the marble must activate again", *pkg.SecurityVersion, cert.Package)
+	}
+	return nil
+}
+
+// attestedSecurityVersion returns the SecurityVersion a marble has been attested with: the one its quote reported, or the one the
+// manifest required if the validator doesn't report it.
+func attestedSecurityVersion(required *uint, reported *uint) *uint {
+	if reported != nil && (required == nil || *reported > *required) {
+		return reported
+	}
+	return required
+}
+
+// validateQuote validates the quote of a marble attempting to register with respect to the manifest
+//
+// message is the data the quote must have been issued over, see Core.quoteMessage.
+// The Coordinator is not locked during the validation, because validators may contact remote attestation services.
+// Returns the number of updates of the manifest that was used, so that the caller can detect a concurrent update.
+// Also returns the name of the infrastructure the quote was valid for and the package properties reported by the quote.
+func (c *Core) validateQuote(message []byte, marbleQuote []byte, marbleType string) (int, string, quote.PackageProperties, error) {
+	c.mux.Lock()
+	if c.state != stateAcceptingMarbles {
+		err := errNotAcceptingMarbles(c.state)
+		c.mux.Unlock()
+		return 0, "", quote.PackageProperties{}, err
+	}
+	if c.handedOff {
+		c.mux.Unlock()
+		return 0, "", quote.PackageProperties{}, errHandedOff()
+	}
+	if c.maintenance != nil {
+		err := c.maintenance.errMaintenance()
+		c.mux.Unlock()
+		return 0, "", quote.PackageProperties{}, err
+	}
+	manifestVersion := len(c.rawUpdates)
+	marble, marbleOK := c.manifest.Marbles[marbleType]
+	pkg, pkgOK := c.manifest.Packages[marble.Package]
+	infrastructures := c.manifest.Infrastructures
+	simulation := c.inSimulationMode()
+	c.mux.Unlock()
+
+	if !marbleOK {
+		return 0, "", quote.PackageProperties{}, errUnknownMarbleType(marbleType)
+	}
+	if !pkgOK {
 		// can't happen
-		return status.Error(codes.Internal, "undefined package")
+		return 0, "", quote.PackageProperties{}, rpc.ActivationError(codes.Internal, rpc.ReasonPackageUnknown,
+			map[string]string{"MarbleType": marbleType, "Package": marble.Package}, "undefined package")
 	}
 
-	if !c.inSimulationMode() {
-		infraMatch := false
-		for _, infra := range c.manifest.Infrastructures {
-			if c.qv.Validate(quote, tlsCert.Raw, pkg, infra) == nil {
-				infraMatch = true
-				break
-			}
-		}
-		if !infraMatch {
-			return status.Error(codes.Unauthenticated, "invalid quote")
+	if simulation {
+		c.zaplogger.Warn("Simulation mode: activating marble without validating its quote.", zap.String("MarbleType", marbleType))
+		return manifestVersion, "simulation", quote.PackageProperties{}, nil
+	}
+	timer := prometheus.NewTimer(quoteVerificationDuration)
+	defer timer.ObserveDuration()
+	// the reasons for all infrastructures are reported, e.g., which SVN of the platform is too low
+	var reasons []string
+	var tcbRejected bool
+	for name, infra := range infrastructures {
+		reportedProps, err := quote.ValidateReport(c.qv, marbleQuote, message, pkg, infra)
+		if err == nil {
+			return manifestVersion, name, reportedProps, nil
 		}
+		reasons = append(reasons, fmt.Sprintf("%v: %v", name, err))
+		tcbRejected = tcbRejected || isTCBError(err)
+	}
+	metadata := map[string]string{"MarbleType": marbleType}
+	if len(reasons) == 0 {
+		return 0, "", quote.PackageProperties{}, rpc.ActivationError(codes.Unauthenticated, rpc.ReasonQuoteInvalid, metadata, "invalid quote")
+	}
+	sort.Strings(reasons)
+	// the quote matches the package on some infrastructure, but the platform must be updated
+	if tcbRejected {
+		c.recordTCBRejection(marbleType, strings.Join(reasons, "; "))
+		return 0, "", quote.PackageProperties{}, rpc.ActivationError(codes.PermissionDenied, rpc.ReasonTCBRejected, metadata, "TCB rejected: %v", strings.Join(reasons, "; "))
+	}
+	return 0, "", quote.PackageProperties{}, rpc.ActivationError(codes.Unauthenticated, rpc.ReasonQuoteInvalid, metadata, "invalid quote: %v", strings.Join(reasons, "; "))
+}
+
+// isTCBError returns true if err reports that the security versions or the TCB status of a platform are not accepted.
+func isTCBError(err error) bool {
+	var svnErr *quote.SVNError
+	var statusErr *quote.TCBStatusError
+	return errors.As(err, &svnErr) || errors.As(err, &statusErr)
+}
+
+// errNotAcceptingMarbles returns the error for activations in a state other than stateAcceptingMarbles.
+func errNotAcceptingMarbles(s state) error {
+	if s == stateUninitialized || s == stateAcceptingManifest {
+		return rpc.ActivationError(codes.FailedPrecondition, rpc.ReasonManifestMissing, nil, "cannot accept marbles in current state: no manifest has been set")
+	}
+	return status.Error(codes.FailedPrecondition, "cannot accept marbles in current state")
+}
+
+func errUnknownMarbleType(marbleType string) error {
+	return rpc.ActivationError(codes.InvalidArgument, rpc.ReasonMarbleTypeUnknown, map[string]string{"MarbleType": marbleType}, "unknown marble type requested")
+}
+
+// marbleTypeLabel returns the label value of the marble type for metrics.
+func (c *Core) marbleTypeLabel(marbleType string) string {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if _, ok := c.manifest.Marbles[marbleType]; !ok {
+		return unknownMarbleType
+	}
+	return marbleType
+}
+
+// verifyManifestRequirement verifies that a marble of the given type may still be activated on the infrastructure with respect to the manifest
+func (c *Core) verifyManifestRequirement(marbleType string, infrastructure string) error {
+	marble, ok := c.manifest.Marbles[marbleType]
+	if !ok {
+		return errUnknownMarbleType(marbleType)
 	}
 
 	// check activation budget (MaxActivations == 0 means infinite budget)
 	activations := c.activations[marbleType]
 	if marble.MaxActivations > 0 && activations >= marble.MaxActivations {
-		return status.Error(codes.ResourceExhausted, "reached max activations count for marble type")
+		return rpc.ActivationError(codes.ResourceExhausted, rpc.ReasonMaxActivationsReached,
+			map[string]string{"MarbleType": marbleType, "MaxActivations": strconv.FormatUint(uint64(marble.MaxActivations), 10)},
+			"reached max activations count for marble type")
+	}
+	if limit, ok := marble.InfrastructureMaxActivations[infrastructure]; ok && c.infrastructureActivations[marbleType][infrastructure] >= limit {
+		return rpc.ActivationError(codes.ResourceExhausted, rpc.ReasonMaxActivationsReached,
+			map[string]string{"MarbleType": marbleType, "Infrastructure": infrastructure, "MaxActivations": strconv.FormatUint(uint64(limit), 10)},
+			"reached max activations count for marble type on infrastructure")
 	}
 	return nil
 }
 
-// generateCertFromCSR signs the CSR from marble attempting to register
-func (c *Core) generateCertFromCSR(csrReq []byte, pubk ecdsa.PublicKey, marbleType string, marbleUUID string) ([]byte, error) {
+// checkActivation checks that the marble of the request with the UUID may be activated on the infrastructure. The Coordinator must be locked.
+//
+// manifestVersion is the number of updates of the manifest that the marble's quote has been validated against.
+// The marble's quote must not have been used for an earlier activation.
+// Returns true if the marble resumes its activation, which doesn't count towards the MaxActivations of its type.
+func (c *Core) checkActivation(req *rpc.ActivationReq, infrastructure string, marbleUUID string, manifestVersion int) (bool, error) {
+	marbleType := req.GetMarbleType()
+	if c.state != stateAcceptingMarbles {
+		return false, errNotAcceptingMarbles(c.state)
+	}
+	// the state may have been handed off or the activations may have been paused during the activation
+	if c.handedOff {
+		return false, errHandedOff()
+	}
+	if c.maintenance != nil {
+		return false, c.maintenance.errMaintenance()
+	}
+	// the quote has been validated against the manifest at that time
+	if len(c.rawUpdates) != manifestVersion {
+		return false, status.Error(codes.Aborted, "manifest has been updated during activation")
+	}
+	c.releaseSilentMarbles(marbleType)
+	resumed := c.isResumption(marbleType, marbleUUID, infrastructure, req.GetResumptionToken())
+	if resumed {
+		if _, ok := c.manifest.Marbles[marbleType]; !ok {
+			return false, errUnknownMarbleType(marbleType)
+		}
+	} else if err := c.verifyManifestRequirement(marbleType, infrastructure); err != nil {
+		return false, err
+	}
+	if c.isRevokedMarble(marbleUUID) {
+		return false, rpc.ActivationError(codes.PermissionDenied, rpc.ReasonMarbleRevoked, map[string]string{"UUID": marbleUUID}, "marble has been revoked")
+	}
+	return resumed, c.checkReplay(req.GetQuote())
+}
+
+// getPackageCA returns the intermediate CA of the package, which is created on first use
+func (c *Core) getPackageCA(pkg string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	if ca, ok := c.packageCAs[pkg]; ok {
+		cert, err := x509.ParseCertificate(ca.RawCert)
+		if err != nil {
+			return nil, nil, err
+		}
+		privk, err := x509.ParseECPrivateKey(ca.Privk)
+		if err != nil {
+			return nil, nil, err
+		}
+		return cert, privk, nil
+	}
+
+	privk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	encodedPrivk, err := x509.MarshalECPrivateKey(privk)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serialNumber, err := util.GenerateCertificateSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName:         pkg,
+			Organization:       c.cert.Subject.Organization,
+			OrganizationalUnit: c.cert.Subject.OrganizationalUnit,
+		},
+		NotBefore: time.Now(),
+		NotAfter:  c.cert.NotAfter,
+
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		// the package CA may only issue marble certificates
+		MaxPathLenZero: true,
+	}
+
+	certRaw, err := x509.CreateCertificate(rand.Reader, &template, c.cert, &privk.PublicKey, c.privk)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(certRaw)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if c.packageCAs == nil {
+		c.packageCAs = make(map[string]packageCA)
+	}
+	c.packageCAs[pkg] = packageCA{RawCert: certRaw, Privk: encodedPrivk}
+	return cert, privk, nil
+}
+
+// generateCertFromCSR signs the CSR from marble attempting to register with the intermediate CA of the marble's package
+// The CSR's URIs are not taken over, uris are the only URIs of the certificate. The extensions are added to the certificate.
+func (c *Core) generateCertFromCSR(csrReq []byte, pubk crypto.PublicKey, certConfig MarbleCertificateConfig, pkg string, caCert *x509.Certificate, caPrivk *ecdsa.PrivateKey, marbleUUID string, dnsNames []string, ipAddrs []net.IP, uris []*url.URL, extensions []pkix.Extension) ([]byte, error) {
 	// parse and verify CSR
 	csr, err := x509.ParseCertificateRequest(csrReq)
 	if err != nil {
@@ -155,24 +651,33 @@ func (c *Core) generateCertFromCSR(csrReq []byte, pubk ecdsa.PublicKey, marbleTy
 	// create certificate
 	csr.Subject.CommonName = marbleUUID
 	csr.Subject.Organization = c.cert.Issuer.Organization
+	if c.inSimulationMode() {
+		csr.Subject.OrganizationalUnit = []string{SimulationOrganizationalUnit}
+	}
 	notBefore := time.Now()
-	// TODO: produce shorter lived certificates
-	notAfter := notBefore.Add(math.MaxInt64)
+	notAfter := caCert.NotAfter
+	if certConfig.ValidFor != 0 {
+		notAfter = notBefore.AddDate(0, 0, int(certConfig.ValidFor))
+	}
 	template := x509.Certificate{
-		SerialNumber: serialNumber,
-		Subject:      csr.Subject,
-		NotBefore:    notBefore,
-		NotAfter:     notAfter,
+		SerialNumber:       serialNumber,
+		Subject:            csr.Subject,
+		NotBefore:          notBefore,
+		NotAfter:           notAfter,
+		SignatureAlgorithm: marbleCertificateSignatureAlgorithms[certConfig.SignatureAlgorithm],
 
-		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyAgreement,
+		KeyUsage:              certConfig.keyUsage(),
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
 		BasicConstraintsValid: true,
 		IsCA:                  false,
-		DNSNames:              csr.DNSNames,
-		IPAddresses:           csr.IPAddresses,
+		DNSNames:              appendDNSNames(csr.DNSNames, dnsNames),
+		IPAddresses:           appendIPAddresses(csr.IPAddresses, ipAddrs),
+		URIs:                  uris,
+		CRLDistributionPoints: c.crlDistributionPoints(pkg),
+		ExtraExtensions:       extensions,
 	}
 
-	certRaw, err := x509.CreateCertificate(rand.Reader, &template, c.cert, &pubk, c.privk)
+	certRaw, err := x509.CreateCertificate(rand.Reader, &template, caCert, pubk, caPrivk)
 	if err != nil {
 		return nil, status.Error(codes.Internal, "failed to issue certificate")
 	}
@@ -180,10 +685,36 @@ func (c *Core) generateCertFromCSR(csrReq []byte, pubk ecdsa.PublicKey, marbleTy
 	return certRaw, nil
 }
 
+// appendDNSNames appends the names to dnsNames that it does not contain yet
+func appendDNSNames(dnsNames []string, names []string) []string {
+	for _, name := range names {
+		if !contains(dnsNames, name) {
+			dnsNames = append(dnsNames, name)
+		}
+	}
+	return dnsNames
+}
+
+// appendIPAddresses appends the addresses to ipAddrs that it does not contain yet
+func appendIPAddresses(ipAddrs []net.IP, addrs []net.IP) []net.IP {
+	for _, addr := range addrs {
+		found := false
+		for _, ip := range ipAddrs {
+			if ip.Equal(addr) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ipAddrs = append(ipAddrs, addr)
+		}
+	}
+	return ipAddrs
+}
+
 // customizeParameters replaces the placeholders in the manifest's parameters with the actual values
 func customizeParameters(params *rpc.Parameters, specialSecrets reservedSecrets, userSecrets map[string]Secret) (*rpc.Parameters, error) {
 	customParams := rpc.Parameters{
-		Argv:  params.Argv,
 		Files: make(map[string]string),
 		Env:   make(map[string]string),
 	}
@@ -192,7 +723,14 @@ func customizeParameters(params *rpc.Parameters, specialSecrets reservedSecrets,
 	secretsWrapped := secretsWrapper{
 		Marblerun: specialSecrets,
 		Secrets:   userSecrets,
+		HostEnv:   make(map[string]string, len(params.HostEnv)),
+	}
+	// only the marble knows its host environment, so it replaces the placeholders
+	for _, name := range params.HostEnv {
+		secretsWrapped.HostEnv[name] = rpc.HostEnvPlaceholder(name)
 	}
+	customParams.HostEnv = params.HostEnv
+	customParams.ScrubEnv = params.ScrubEnv
 
 	// replace placeholders in files
 	for path, data := range params.Files {
@@ -204,6 +742,25 @@ func customizeParameters(params *rpc.Parameters, specialSecrets reservedSecrets,
 		customParams.Files[path] = newValue
 	}
 
+	// replace placeholders in files with attributes, whose data is decoded afterwards
+	for path, file := range params.FileSpecs {
+		newValue, err := parseSecrets(string(file.GetData()), secretsWrapped)
+		if err != nil {
+			return nil, err
+		}
+		data := []byte(newValue)
+		if file.GetEncoding() == rpc.EncodingBase64 {
+			if data, err = base64.StdEncoding.DecodeString(newValue); err != nil {
+				return nil, fmt.Errorf("file %v: invalid base64 data: %v", path, err)
+			}
+		}
+
+		if customParams.FileSpecs == nil {
+			customParams.FileSpecs = make(map[string]*rpc.File)
+		}
+		customParams.FileSpecs[path] = &rpc.File{Data: data, Mode: file.GetMode(), User: file.GetUser(), Group: file.GetGroup(), NoDisk: file.GetNoDisk()}
+	}
+
 	for name, data := range params.Env {
 		newValue, err := parseSecrets(data, secretsWrapped)
 		if err != nil {
@@ -213,6 +770,16 @@ func customizeParameters(params *rpc.Parameters, specialSecrets reservedSecrets,
 		customParams.Env[name] = newValue
 	}
 
+	// replace placeholders in argv
+	for _, data := range params.Argv {
+		newValue, err := parseSecrets(data, secretsWrapped)
+		if err != nil {
+			return nil, err
+		}
+
+		customParams.Argv = append(customParams.Argv, newValue)
+	}
+
 	// Set as environment variables
 	rootCaPem, err := encodeSecretDataToPem(specialSecrets.RootCA.Cert)
 	if err != nil {
@@ -222,22 +789,125 @@ func customizeParameters(params *rpc.Parameters, specialSecrets reservedSecrets,
 	if err != nil {
 		return nil, err
 	}
+	packageCAPem, err := encodeSecretDataToPem(specialSecrets.PackageCA.Cert)
+	if err != nil {
+		return nil, err
+	}
 	encodedPrivKey, err := encodeSecretDataToPem(specialSecrets.MarbleCert.Private)
 	if err != nil {
 		return nil, err
 	}
 
 	customParams.Env[marble.MarbleEnvironmentRootCA] = rootCaPem
-	customParams.Env[marble.MarbleEnvironmentCertificate] = marbleCertPem
+	// the marble's certificate is followed by the package CA, so that peers can verify the chain up to the root CA,
+	// and by the issuers of the root CA if it has been provided by the operator
+	customParams.Env[marble.MarbleEnvironmentCertificate] = marbleCertPem + packageCAPem + specialSecrets.issuerChain
 	customParams.Env[marble.MarbleEnvironmentPrivateKey] = encodedPrivKey
 
 	return &customParams, nil
 }
 
+// referencedSecrets returns the names of the secrets that the templates of params reference as .Secrets.<name>.
+//
+// Secrets that are accessed dynamically, e.g., with the index function, are not returned.
+func referencedSecrets(params *rpc.Parameters) ([]string, error) {
+	if params == nil {
+		return nil, nil
+	}
+	var data []string
+	for _, value := range params.Files {
+		data = append(data, value)
+	}
+	for _, file := range params.FileSpecs {
+		data = append(data, string(file.GetData()))
+	}
+	for _, value := range params.Env {
+		data = append(data, value)
+	}
+	data = append(data, params.Argv...)
+
+	names := make(map[string]bool)
+	for _, value := range data {
+		tpl, err := template.New("data").Funcs(manifestTemplateFuncMap).Parse(value)
+		if err != nil {
+			return nil, err
+		}
+		collectReferences(tpl.Tree.Root, "Secrets", names)
+	}
+	return sortedNames(names), nil
+}
+
+// templateReferences returns the sorted names referenced by a single template of the parameters as .<field>.<name>,
+// e.g., the secrets for the field Secrets and the host environment variables for the field HostEnv.
+func templateReferences(value string, field string) ([]string, error) {
+	tpl, err := template.New("data").Funcs(manifestTemplateFuncMap).Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	collectReferences(tpl.Tree.Root, field, names)
+	return sortedNames(names), nil
+}
+
+func sortedNames(names map[string]bool) []string {
+	result := make([]string, 0, len(names))
+	for name := range names {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// collectReferences adds the names referenced as .<field>.<name> by the template node and its children to names.
+func collectReferences(node parse.Node, field string, names map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			collectReferences(child, field, names)
+		}
+	case *parse.ActionNode:
+		collectReferences(n.Pipe, field, names)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			collectReferences(cmd, field, names)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			collectReferences(arg, field, names)
+		}
+	case *parse.IfNode:
+		collectReferences(&n.BranchNode, field, names)
+	case *parse.RangeNode:
+		collectReferences(&n.BranchNode, field, names)
+	case *parse.WithNode:
+		collectReferences(&n.BranchNode, field, names)
+	case *parse.BranchNode:
+		collectReferences(n.Pipe, field, names)
+		collectReferences(n.List, field, names)
+		collectReferences(n.ElseList, field, names)
+	case *parse.FieldNode:
+		if len(n.Ident) >= 2 && n.Ident[0] == field {
+			names[n.Ident[1]] = true
+		}
+	case *parse.VariableNode:
+		// $.<field>.<name>
+		if len(n.Ident) >= 3 && n.Ident[0] == "$" && n.Ident[1] == field {
+			names[n.Ident[2]] = true
+		}
+	}
+}
+
 func parseSecrets(data string, secretsWrapped secretsWrapper) (string, error) {
 	var templateResult bytes.Buffer
 
-	tpl, err := template.New("data").Funcs(manifestTemplateFuncMap).Parse(data)
+	// user-defined secrets that have not been set yet are missing
+	tpl, err := template.New("data").Option("missingkey=error").Funcs(manifestTemplateFuncMap).Parse(data)
 	if err != nil {
 		return "", err
 	}
@@ -249,37 +919,32 @@ func parseSecrets(data string, secretsWrapped secretsWrapper) (string, error) {
 	return templateResult.String(), nil
 }
 
-func (c *Core) generateMarbleAuthSecrets(req *rpc.ActivationReq, marbleUUID uuid.UUID) (reservedSecrets, error) {
-	// generate key-pair for marble
-	privk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	if err != nil {
-		return reservedSecrets{}, err
-	}
-	encodedPrivKey, err := x509.MarshalPKCS8PrivateKey(privk)
-	if err != nil {
-		return reservedSecrets{}, err
-	}
-	encodedPubKey, err := x509.MarshalPKIXPublicKey(&privk.PublicKey)
+// generateMarbleAuthSecrets generates the authentication secrets of the marble with a certificate issued by the package CA.
+//
+// It doesn't modify the Core, so that it may be called while the Core is read-locked.
+func (c *Core) generateMarbleAuthSecrets(csr []byte, marbleType string, marbleUUID uuid.UUID, caCert *x509.Certificate, caPrivk *ecdsa.PrivateKey) (reservedSecrets, error) {
+	// the marble type has been checked in verifyManifestRequirement
+	marble := c.manifest.Marbles[marbleType]
+	metadata := activationMetadata{MarbleType: marbleType, Package: marble.Package, UUID: marbleUUID.String()}
+	dnsNames, ipAddrs, err := marble.TLS.subjectAltNames(metadata)
 	if err != nil {
-		return reservedSecrets{}, err
+		c.zaplogger.Error("Could not get subject alternative names of marble certificate.", zap.Error(err))
+		return reservedSecrets{}, status.Error(codes.Internal, "invalid TLS settings of marble")
 	}
-
-	// Derive sealing key for marble
-	uuidBytes, err := marbleUUID.MarshalBinary()
+	marbleCert, privk, err := c.issueMarbleCert(csr, marbleType, marble.Package, caCert, caPrivk, marbleUUID.String(), dnsNames, ipAddrs)
 	if err != nil {
 		return reservedSecrets{}, err
 	}
-	sealKey, err := util.DeriveKey(c.privk.D.Bytes(), uuidBytes, 32)
+	encodedPrivKey, err := x509.MarshalPKCS8PrivateKey(privk)
 	if err != nil {
 		return reservedSecrets{}, err
 	}
-
-	certRaw, err := c.generateCertFromCSR(req.GetCSR(), privk.PublicKey, req.GetMarbleType(), marbleUUID.String())
+	encodedPubKey, err := x509.MarshalPKIXPublicKey(privk.Public())
 	if err != nil {
 		return reservedSecrets{}, err
 	}
 
-	marbleCert, err := x509.ParseCertificate(certRaw)
+	sealKey, err := c.deriveSealKey(marbleType, marble.SealKeyScope, marbleUUID)
 	if err != nil {
 		return reservedSecrets{}, err
 	}
@@ -287,9 +952,73 @@ func (c *Core) generateMarbleAuthSecrets(req *rpc.ActivationReq, marbleUUID uuid
 	// customize marble's parameters
 	authSecrets := reservedSecrets{
 		RootCA:     Secret{Cert: Certificate(*c.cert)},
+		PackageCA:  Secret{Cert: Certificate(*caCert)},
 		MarbleCert: Secret{Cert: Certificate(*marbleCert), Public: encodedPubKey, Private: encodedPrivKey},
 		SealKey:    Secret{Public: sealKey, Private: sealKey},
+
+		issuerChain: c.issuerChainPEM(),
 	}
 
 	return authSecrets, nil
 }
+
+// deriveSealKey derives the sealing key of a marble from the Coordinator's derivation key.
+//
+// The key doesn't depend on the platform, so that marbles can decrypt their persistent data after they have been moved to other hardware.
+func (c *Core) deriveSealKey(marbleType string, scope string, marbleUUID uuid.UUID) ([]byte, error) {
+	var salt []byte
+	if scope == SealKeyScopeMarbleType {
+		// the hash can't collide with the salt of a marble, whose UUID the marble chooses, nor with the salt of a unique secret
+		hash := sha256.Sum256([]byte("MarbleType/" + marbleType))
+		salt = hash[:]
+	} else {
+		var err error
+		if salt, err = marbleUUID.MarshalBinary(); err != nil {
+			return nil, err
+		}
+	}
+	return util.DeriveKey(c.derivationKey(), salt, 32)
+}
+
+// generateMarbleCert generates a key-pair for a marble of the type and package and issues a certificate for it from the CSR.
+// The DNS names and IP addresses are added to the ones of the CSR.
+// Returns the marble's certificate, the package CA that issued it and the marble's private key.
+func (c *Core) generateMarbleCert(csrReq []byte, marbleType string, pkg string, marbleUUID string, dnsNames []string, ipAddrs []net.IP) (*x509.Certificate, *x509.Certificate, crypto.Signer, error) {
+	caCert, caPrivk, err := c.getPackageCA(pkg)
+	if err != nil {
+		return nil, nil, nil, status.Error(codes.Internal, "failed to get package CA")
+	}
+	marbleCert, privk, err := c.issueMarbleCert(csrReq, marbleType, pkg, caCert, caPrivk, marbleUUID, dnsNames, ipAddrs)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return marbleCert, caCert, privk, nil
+}
+
+// issueMarbleCert generates a key-pair for a marble of the type and package and issues a certificate for it from the CSR with the package CA.
+// The certificate contains the marble's identity and, if the manifest sets a SPIFFETrustDomain, its SPIFFE ID.
+func (c *Core) issueMarbleCert(csrReq []byte, marbleType string, pkg string, caCert *x509.Certificate, caPrivk *ecdsa.PrivateKey, marbleUUID string, dnsNames []string, ipAddrs []net.IP) (*x509.Certificate, crypto.Signer, error) {
+	certConfig := c.manifest.marbleCertificateConfig(pkg)
+	privk, err := certConfig.generateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	var uris []*url.URL
+	if spiffeID := c.manifest.spiffeID(marbleType, marbleUUID); spiffeID != nil {
+		uris = append(uris, spiffeID)
+	}
+	id := identity.Identity{MarbleType: marbleType, Package: pkg, ProductID: c.manifest.Packages[pkg].ProductID, UUID: marbleUUID}
+	idExtension, err := id.Extension()
+	if err != nil {
+		return nil, nil, err
+	}
+	certRaw, err := c.generateCertFromCSR(csrReq, privk.Public(), certConfig, pkg, caCert, caPrivk, marbleUUID, dnsNames, ipAddrs, uris, []pkix.Extension{idExtension})
+	if err != nil {
+		return nil, nil, err
+	}
+	marbleCert, err := x509.ParseCertificate(certRaw)
+	if err != nil {
+		return nil, nil, err
+	}
+	return marbleCert, privk, nil
+}
@@ -8,6 +8,9 @@ package core
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
@@ -16,21 +19,36 @@ import (
 	"encoding/json"
 	"encoding/pem"
 	"math/big"
+	"net"
 	"sync"
 	"testing"
 	"time"
 
 	libMarble "github.com/edgelesssys/ertgolib/marble"
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
 	"github.com/edgelesssys/marblerun/coordinator/quote"
 	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/edgelesssys/marblerun/marble/config"
+	"github.com/edgelesssys/marblerun/marble/identity"
 	"github.com/edgelesssys/marblerun/test"
 	"github.com/edgelesssys/marblerun/util"
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/trace"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/export/trace/tracetest"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
 func TestActivate(t *testing.T) {
@@ -50,7 +68,7 @@ func TestActivate(t *testing.T) {
 	validator := quote.NewMockValidator()
 	issuer := quote.NewMockIssuer()
 	sealer := &MockSealer{}
-	coreServer, err := NewCore([]string{"localhost"}, validator, issuer, sealer, zapLogger)
+	coreServer, err := NewCore([]string{"localhost"}, validator, issuer, sealer, nil, false, zapLogger)
 	require.NoError(err)
 	require.NotNil(coreServer)
 
@@ -67,7 +85,7 @@ func TestActivate(t *testing.T) {
 	spawner.newMarble("backend_first", "Azure", false)
 
 	// set manifest
-	_, err = coreServer.SetManifest(context.TODO(), []byte(test.ManifestJSON))
+	_, err = coreServer.SetManifest(context.TODO(), []byte(test.ManifestJSON), nil)
 	require.NoError(err)
 
 	// activate first backend
@@ -97,6 +115,1272 @@ func TestActivate(t *testing.T) {
 	// Check if non-shared secret with the same name is indeed not the same in different marbles
 	assert.EqualValues(spawner.backendFirstSharedCert, spawner.backendOtherSharedCert, "Shared secrets were different across different marbles, but were supposed to be the same.")
 	assert.NotEqualValues(spawner.backendFirstUniqueCert, spawner.backendOtherUniqueCert, "Non-shared secrets were the same across different marbles, but were supposed to be unique.")
+
+	// activation counters must survive a restart of the Coordinator
+	restartedCore, err := NewCore([]string{"localhost"}, validator, issuer, sealer, nil, false, zapLogger)
+	require.NoError(err)
+	assert.Equal(map[string]uint{"backend_first": 1, "backend_other": 10, "frontend": 10}, restartedCore.activations)
+	// the package CAs must survive as well, so that marbles of a package keep sharing the same intermediate CA
+	assert.Len(coreServer.packageCAs, 2)
+	assert.Equal(coreServer.packageCAs, restartedCore.packageCAs)
+	spawner.coreServer = restartedCore
+	spawner.newMarble("backend_first", "Azure", false)
+}
+
+func TestActivateSecretEntitlement(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, manifest := mustSetup()
+	secret := manifest.Secrets["symmetric_key_shared"]
+	secret.Marbles = []string{"backend_first"}
+	manifest.Secrets["symmetric_key_shared"] = secret
+
+	// the frontend cannot reference the secret
+	manifest.Marbles["frontend"].Parameters.Env["KEY"] = "{{ raw .Secrets.symmetric_key_shared }}"
+	rawManifest, err := json.Marshal(manifest)
+	require.NoError(err)
+	_, err = c.SetManifest(context.TODO(), rawManifest, nil)
+	assert.Error(err)
+
+	// secrets that are accessed dynamically are not passed to the frontend
+	manifest.Marbles["frontend"].Parameters.Env["KEY"] = `{{ raw (index .Secrets "symmetric_key_shared") }}`
+	rawManifest, err = json.Marshal(manifest)
+	require.NoError(err)
+	_, err = c.SetManifest(context.TODO(), rawManifest, nil)
+	require.NoError(err)
+
+	spawner := marbleSpawner{
+		assert:     assert,
+		require:    require,
+		issuer:     c.qi,
+		validator:  c.qv.(*quote.MockValidator),
+		manifest:   *manifest,
+		coreServer: c,
+	}
+	params := spawner.newMarble("frontend", "Azure", true)
+	require.NotNil(params)
+	assert.Empty(params.Env["KEY"])
+	params = spawner.newMarble("backend_first", "Azure", true)
+	require.NotNil(params)
+	assert.Len(params.Env["TEST_SECRET_SYMMETRIC_KEY"], 16)
+
+	// activation rejects references even if the manifest has not been checked for them
+	c.manifest.Marbles["backend_other"].Parameters.Env["KEY"] = "{{ raw .Secrets.symmetric_key_shared }}"
+	spawner.newMarble("backend_other", "Azure", false)
+	assert.Zero(c.activations["backend_other"])
+}
+
+func TestActivateGroupSecrets(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, manifest := mustSetup()
+	manifest.MarbleGroups = map[string][]string{"backend": {"backend_first", "backend_other"}}
+	manifest.Secrets["cluster_key"] = Secret{Type: "symmetric-key", Size: 128, Shared: true, Groups: []string{"backend"}}
+	manifest.Users = map[string]User{"admin": {Certificate: test.CertPEM(test.AdminCert), Roles: []string{"rotator"}}}
+	manifest.Roles = map[string]Role{"rotator": {ResourceType: "Secrets", Actions: []string{"RotateSecret"}}}
+
+	// marbles outside of the group cannot reference the secret
+	manifest.Marbles["frontend"].Parameters.Env["CLUSTER_KEY"] = "{{ hex .Secrets.cluster_key }}"
+	rawManifest, err := json.Marshal(manifest)
+	require.NoError(err)
+	_, err = c.SetManifest(context.TODO(), rawManifest, nil)
+	assert.Error(err)
+	delete(manifest.Marbles["frontend"].Parameters.Env, "CLUSTER_KEY")
+
+	manifest.Marbles["backend_first"].Parameters.Env["CLUSTER_KEY"] = "{{ hex .Secrets.cluster_key }}"
+	manifest.Marbles["backend_other"].Parameters.Env["CLUSTER_KEY"] = "{{ hex .Secrets.cluster_key }}"
+	rawManifest, err = json.Marshal(manifest)
+	require.NoError(err)
+	_, err = c.SetManifest(context.TODO(), rawManifest, nil)
+	require.NoError(err)
+
+	// all members get the same key
+	spawner := marbleSpawner{
+		assert:     assert,
+		require:    require,
+		issuer:     c.qi,
+		validator:  c.qv.(*quote.MockValidator),
+		manifest:   *manifest,
+		coreServer: c,
+	}
+	first := spawner.newMarble("backend_first", "Azure", true)
+	require.NotNil(first)
+	other := spawner.newMarble("backend_other", "Azure", true)
+	require.NotNil(other)
+	assert.Len(first.Env["CLUSTER_KEY"], 32)
+	assert.Equal(first.Env["CLUSTER_KEY"], other.Env["CLUSTER_KEY"])
+
+	// members activated after a rotation get the new key
+	_, err = c.RotateSecrets(context.TODO(), []string{"cluster_key"}, test.AdminCert)
+	require.NoError(err)
+	rotated := spawner.newMarble("backend_other", "Azure", true)
+	require.NotNil(rotated)
+	assert.Len(rotated.Env["CLUSTER_KEY"], 32)
+	assert.NotEqual(first.Env["CLUSTER_KEY"], rotated.Env["CLUSTER_KEY"])
+}
+
+func TestActivateMarbleCertificateConfig(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, manifest := mustSetup()
+	manifest.MarbleCertificate = MarbleCertificateConfig{ValidFor: 30, KeyType: "ecdsa", KeySize: 384, SignatureAlgorithm: "ECDSA-SHA384"}
+	manifest.PackageCertificates = map[string]MarbleCertificateConfig{"frontend": {KeyType: "ed25519"}}
+	rawManifest, err := json.Marshal(manifest)
+	require.NoError(err)
+	_, err = c.SetManifest(context.TODO(), rawManifest, nil)
+	require.NoError(err)
+
+	spawner := marbleSpawner{
+		assert:     assert,
+		require:    require,
+		issuer:     c.qi,
+		validator:  c.qv.(*quote.MockValidator),
+		manifest:   *manifest,
+		coreServer: c,
+	}
+	parseCert := func(params *rpc.Parameters) (*x509.Certificate, interface{}) {
+		require.NotNil(params)
+		block, _ := pem.Decode([]byte(params.Env[libMarble.MarbleEnvironmentCertificate]))
+		require.NotNil(block)
+		cert, err := x509.ParseCertificate(block.Bytes)
+		require.NoError(err)
+		block, _ = pem.Decode([]byte(params.Env[libMarble.MarbleEnvironmentPrivateKey]))
+		require.NotNil(block)
+		privk, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		require.NoError(err)
+		return cert, privk
+	}
+
+	cert, privk := parseCert(spawner.newMarble("backend_first", "Azure", true))
+	assert.Equal(x509.ECDSAWithSHA384, cert.SignatureAlgorithm)
+	assert.Equal(elliptic.P384(), cert.PublicKey.(*ecdsa.PublicKey).Curve)
+	assert.Equal(elliptic.P384(), privk.(*ecdsa.PrivateKey).Curve)
+	assert.WithinDuration(time.Now().AddDate(0, 0, 30), cert.NotAfter, time.Minute)
+
+	// the package's settings override the manifest's settings
+	cert, privk = parseCert(spawner.newMarble("frontend", "Azure", true))
+	assert.Equal(x509.ECDSAWithSHA384, cert.SignatureAlgorithm)
+	assert.Equal(x509.Ed25519, cert.PublicKeyAlgorithm)
+	assert.IsType(ed25519.PrivateKey{}, privk)
+	assert.Equal(x509.KeyUsageDigitalSignature, cert.KeyUsage)
+	assert.WithinDuration(time.Now().AddDate(0, 0, 30), cert.NotAfter, time.Minute)
+}
+
+func TestActivateSubjectAltNames(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, manifest := mustSetup()
+	marble := manifest.Marbles["frontend"]
+	marble.TLS = &MarbleTLS{DNSNames: []string{"frontend.svc", "{{ .UUID }}.{{ .Package }}.svc", "localhost"}, IPAddresses: []string{"10.0.0.1"}}
+	manifest.Marbles["frontend"] = marble
+	rawManifest, err := json.Marshal(manifest)
+	require.NoError(err)
+	_, err = c.SetManifest(context.TODO(), rawManifest, nil)
+	require.NoError(err)
+
+	spawner := marbleSpawner{
+		assert:     assert,
+		require:    require,
+		issuer:     c.qi,
+		validator:  c.qv.(*quote.MockValidator),
+		manifest:   *manifest,
+		coreServer: c,
+	}
+	params := spawner.newMarble("frontend", "Azure", true)
+	require.NotNil(params)
+	block, rest := pem.Decode([]byte(params.Env[libMarble.MarbleEnvironmentCertificate]))
+	require.NotNil(block)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(err)
+	block, _ = pem.Decode(rest)
+	require.NotNil(block)
+	packageCA, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(err)
+
+	// the names of the TLS block are added to the ones of the CSR
+	_, csr, _ := util.MustGenerateTestMarbleCredentials()
+	parsedCSR, err := x509.ParseCertificateRequest(csr)
+	require.NoError(err)
+	expectedDNSNames := append(parsedCSR.DNSNames, "frontend.svc", cert.Subject.CommonName+".frontend.svc")
+	assert.Equal(expectedDNSNames, cert.DNSNames)
+	require.Len(cert.IPAddresses, len(parsedCSR.IPAddresses)+1)
+	assert.True(net.ParseIP("10.0.0.1").Equal(cert.IPAddresses[len(cert.IPAddresses)-1]))
+	roots := x509.NewCertPool()
+	roots.AddCert(c.cert)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(packageCA)
+	_, err = cert.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, DNSName: cert.Subject.CommonName + ".frontend.svc"})
+	assert.NoError(err)
+
+	// the names are kept on renewal
+	ctx := peer.NewContext(context.TODO(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+	})
+	resp, err := c.Renew(ctx, &rpc.RenewalReq{CSR: csr})
+	require.NoError(err)
+	block, _ = pem.Decode([]byte(resp.Certificate))
+	require.NotNil(block)
+	renewedCert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(err)
+	assert.Equal(cert.DNSNames, renewedCert.DNSNames)
+	assert.Equal(cert.IPAddresses, renewedCert.IPAddresses)
+}
+
+func TestActivateTTLS(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, manifest := mustSetup()
+	web := config.TTLS{Incoming: []config.TTLSIncoming{{Port: 8443, Target: "localhost:8080"}}}
+	db := config.TTLS{Outgoing: []config.TTLSOutgoing{{Port: 5432, Addr: "db.svc:5432"}}}
+	manifest.TLS = map[string]config.TTLS{"web": web, "db": db}
+	marble := manifest.Marbles["frontend"]
+	marble.TLS = &MarbleTLS{Tags: []string{"web", "db"}}
+	manifest.Marbles["frontend"] = marble
+	rawManifest, err := json.Marshal(manifest)
+	require.NoError(err)
+	_, err = c.SetManifest(context.TODO(), rawManifest, nil)
+	require.NoError(err)
+
+	spawner := marbleSpawner{
+		assert:     assert,
+		require:    require,
+		issuer:     c.qi,
+		validator:  c.qv.(*quote.MockValidator),
+		manifest:   *manifest,
+		coreServer: c,
+	}
+
+	// the connections of all tags are delivered to the marble
+	params := spawner.newMarble("frontend", "Azure", true)
+	require.NotNil(params)
+	var ttls config.TTLS
+	require.NoError(json.Unmarshal([]byte(params.Env[config.TTLSConfig]), &ttls))
+	assert.Equal(web.Incoming, ttls.Incoming)
+	assert.Equal(db.Outgoing, ttls.Outgoing)
+
+	// marbles without tags don't get a configuration
+	params = spawner.newMarble("backend_first", "Azure", true)
+	require.NotNil(params)
+	assert.NotContains(params.Env, config.TTLSConfig)
+}
+
+func TestActivateMetrics(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, manifest := mustSetup()
+	_, err := c.SetManifest(context.TODO(), []byte(test.ManifestJSON), nil)
+	require.NoError(err)
+	assert.EqualValues(stateAcceptingMarbles, testutil.ToFloat64(coordinatorState))
+
+	spawner := marbleSpawner{
+		assert:     assert,
+		require:    require,
+		issuer:     c.qi,
+		validator:  c.qv.(*quote.MockValidator),
+		manifest:   *manifest,
+		coreServer: c,
+	}
+
+	// the metrics are global, so only their changes are checked
+	attempts := testutil.ToFloat64(activationAttempts.WithLabelValues("frontend"))
+	successes := testutil.ToFloat64(activationSuccesses.WithLabelValues("frontend", "Azure"))
+	failures := testutil.ToFloat64(activationFailures.WithLabelValues("frontend"))
+	unknownAttempts := testutil.ToFloat64(activationAttempts.WithLabelValues(unknownMarbleType))
+	verifications := histogramSampleCount(t, quoteVerificationDuration)
+	phaseDurations := make(map[string]uint64)
+	for _, phase := range []string{phaseQuoteVerification, phaseCertificateIssuance, phaseStateWrite} {
+		phaseDurations[phase] = histogramSampleCount(t, activationPhaseDuration.WithLabelValues("frontend", phase).(prometheus.Histogram))
+	}
+	durations := histogramSampleCount(t, activationDuration.WithLabelValues("frontend", "Azure").(prometheus.Histogram))
+	assert.Empty(c.countActiveMarbles())
+
+	spawner.newMarble("frontend", "Azure", true)
+
+	// the quote has not been added to the mock validator
+	cert, csr, _ := util.MustGenerateTestMarbleCredentials()
+	ctx := peer.NewContext(context.TODO(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+	})
+	_, err = c.Activate(ctx, &rpc.ActivationReq{CSR: csr, MarbleType: "frontend", Quote: []byte("invalid"), UUID: uuid.New().String()})
+	assert.Error(err)
+
+	_, err = c.Activate(context.TODO(), &rpc.ActivationReq{MarbleType: "does-not-exist"})
+	assert.Error(err)
+
+	assert.Equal(attempts+2, testutil.ToFloat64(activationAttempts.WithLabelValues("frontend")))
+	assert.Equal(successes+1, testutil.ToFloat64(activationSuccesses.WithLabelValues("frontend", "Azure")))
+	assert.Equal(failures+1, testutil.ToFloat64(activationFailures.WithLabelValues("frontend")))
+	assert.Equal(unknownAttempts+1, testutil.ToFloat64(activationAttempts.WithLabelValues(unknownMarbleType)))
+	assert.Equal(verifications+2, histogramSampleCount(t, quoteVerificationDuration))
+
+	// the quotes of both activations have been verified, but only the valid one has been issued a certificate and recorded
+	assert.Equal(phaseDurations[phaseQuoteVerification]+2, histogramSampleCount(t, activationPhaseDuration.WithLabelValues("frontend", phaseQuoteVerification).(prometheus.Histogram)))
+	assert.Equal(phaseDurations[phaseCertificateIssuance]+1, histogramSampleCount(t, activationPhaseDuration.WithLabelValues("frontend", phaseCertificateIssuance).(prometheus.Histogram)))
+	assert.Equal(phaseDurations[phaseStateWrite]+1, histogramSampleCount(t, activationPhaseDuration.WithLabelValues("frontend", phaseStateWrite).(prometheus.Histogram)))
+	assert.Equal(durations+1, histogramSampleCount(t, activationDuration.WithLabelValues("frontend", "Azure").(prometheus.Histogram)))
+
+	// marbles are active as long as they send heartbeats
+	assert.Equal(map[[2]string]int{{"frontend", "Azure"}: 1}, c.countActiveMarbles())
+	assert.Equal(1, testutil.CollectAndCount(c.MetricsCollector()))
+	c.mux.Lock()
+	for id, marble := range c.activeMarbles {
+		marble.LastSeen = time.Now().Add(-activeMarbleTimeout - time.Second)
+		c.activeMarbles[id] = marble
+	}
+	c.mux.Unlock()
+	assert.Empty(c.countActiveMarbles())
+}
+
+func TestActivateReportsTooLowSVN(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, manifest := mustSetup()
+	_, err := c.SetManifest(context.TODO(), []byte(test.ManifestJSON), nil)
+	require.NoError(err)
+
+	// the platform's CPUSVN is lower than Azure's in one component
+	cert, csr, _ := util.MustGenerateTestMarbleCredentials()
+	marbleQuote, err := c.qi.Issue(cert.Raw)
+	require.NoError(err)
+	infra := manifest.Infrastructures["Azure"]
+	infra.CPUSVN = append([]byte(nil), infra.CPUSVN...)
+	infra.CPUSVN[5]--
+	c.qv.(*quote.MockValidator).AddValidQuote(marbleQuote, cert.Raw, manifest.Packages["frontend"], infra)
+
+	ctx := peer.NewContext(context.TODO(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+	})
+	_, err = c.Activate(ctx, &rpc.ActivationReq{CSR: csr, MarbleType: "frontend", Quote: marbleQuote, UUID: uuid.New().String()})
+	require.Error(err)
+	assert.Equal(codes.PermissionDenied, status.Code(err))
+	assert.Contains(err.Error(), "Azure: infrastructure does not comply: CPUSVN component 5 too low: 4 < 5")
+	reason, _ := rpc.ErrorReason(err)
+	assert.Equal(rpc.ReasonTCBRejected, reason)
+	assert.False(rpc.IsRetryable(err))
+
+	// higher SVNs are accepted
+	infra.CPUSVN[5] += 2
+	c.qv.(*quote.MockValidator).AddValidQuote(marbleQuote, cert.Raw, manifest.Packages["frontend"], infra)
+	_, err = c.Activate(ctx, &rpc.ActivationReq{CSR: csr, MarbleType: "frontend", Quote: marbleQuote, UUID: uuid.New().String()})
+	assert.NoError(err)
+}
+
+func TestActivateErrorReasons(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, manifest := mustSetup()
+	activate := func(marbleType string, validQuote bool) error {
+		cert, csr, _ := util.MustGenerateTestMarbleCredentials()
+		marbleQuote, err := c.qi.Issue(cert.Raw)
+		require.NoError(err)
+		if validQuote {
+			c.qv.(*quote.MockValidator).AddValidQuote(marbleQuote, cert.Raw, manifest.Packages["backend"], manifest.Infrastructures["Azure"])
+		}
+		ctx := peer.NewContext(context.TODO(), &peer.Peer{
+			AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+		})
+		_, err = c.Activate(ctx, &rpc.ActivationReq{CSR: csr, MarbleType: marbleType, Quote: marbleQuote, UUID: uuid.New().String()})
+		return err
+	}
+	assertReason := func(err error, code codes.Code, expectedReason string, retryable bool) {
+		require.Error(err)
+		assert.Equal(code, status.Code(err))
+		reason, _ := rpc.ErrorReason(err)
+		assert.Equal(expectedReason, reason)
+		assert.Equal(retryable, rpc.IsRetryable(err))
+	}
+
+	// marbles started before the manifest is set may retry
+	assertReason(activate("backend_first", true), codes.FailedPrecondition, rpc.ReasonManifestMissing, true)
+
+	_, err := c.SetManifest(context.TODO(), []byte(test.ManifestJSON), nil)
+	require.NoError(err)
+	assertReason(activate("unknown", true), codes.InvalidArgument, rpc.ReasonMarbleTypeUnknown, false)
+	assertReason(activate("backend_first", false), codes.Unauthenticated, rpc.ReasonQuoteInvalid, false)
+	require.NoError(activate("backend_first", true))
+
+	err = activate("backend_first", true)
+	assertReason(err, codes.ResourceExhausted, rpc.ReasonMaxActivationsReached, false)
+	_, metadata := rpc.ErrorReason(err)
+	assert.Equal(map[string]string{"MarbleType": "backend_first", "MaxActivations": "1"}, metadata)
+
+	// errors without a reason are classified by their code
+	assert.True(rpc.IsRetryable(status.Error(codes.Unavailable, "connection refused")))
+	assert.False(rpc.IsRetryable(status.Error(codes.Internal, "failed to persist state")))
+}
+
+func TestActivateWithNonce(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, manifest := mustSetup()
+	_, err := c.SetManifest(context.TODO(), []byte(test.ManifestJSON), nil)
+	require.NoError(err)
+	validator := c.qv.(*quote.MockValidator)
+
+	newMarble := func() (context.Context, *x509.Certificate, []byte) {
+		cert, csr, _ := util.MustGenerateTestMarbleCredentials()
+		ctx := peer.NewContext(context.TODO(), &peer.Peer{
+			AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+		})
+		return ctx, cert, csr
+	}
+	quoteOver := func(message []byte) []byte {
+		marbleQuote, err := c.qi.Issue(message)
+		require.NoError(err)
+		validator.AddValidQuote(marbleQuote, message, manifest.Packages["frontend"], manifest.Infrastructures["Azure"])
+		return marbleQuote
+	}
+
+	// the quote is issued over the nonce and the CSR's public key
+	ctx, _, csr := newMarble()
+	resp, err := c.Nonce(ctx, &rpc.NonceReq{})
+	require.NoError(err)
+	require.Len(resp.GetNonce(), nonceSize)
+	message, err := rpc.QuoteMessage(resp.GetNonce(), csr)
+	require.NoError(err)
+	marbleQuote := quoteOver(message)
+	_, err = c.Activate(ctx, &rpc.ActivationReq{CSR: csr, MarbleType: "frontend", Quote: marbleQuote, Nonce: resp.GetNonce(), UUID: uuid.New().String()})
+	require.NoError(err)
+
+	// the nonce can't be used again
+	_, err = c.Activate(ctx, &rpc.ActivationReq{CSR: csr, MarbleType: "frontend", Quote: marbleQuote, Nonce: resp.GetNonce(), UUID: uuid.New().String()})
+	assert.Equal(codes.Unauthenticated, status.Code(err))
+
+	// nonces are bound to the marble's TLS certificate
+	otherCtx, _, _ := newMarble()
+	resp, err = c.Nonce(otherCtx, &rpc.NonceReq{})
+	require.NoError(err)
+	message, err = rpc.QuoteMessage(resp.GetNonce(), csr)
+	require.NoError(err)
+	_, err = c.Activate(ctx, &rpc.ActivationReq{CSR: csr, MarbleType: "frontend", Quote: quoteOver(message), Nonce: resp.GetNonce(), UUID: uuid.New().String()})
+	assert.Equal(codes.Unauthenticated, status.Code(err))
+
+	// expired nonces are rejected
+	resp, err = c.Nonce(ctx, &rpc.NonceReq{})
+	require.NoError(err)
+	c.nonces.now = func() time.Time { return time.Now().Add(nonceTTL) }
+	message, err = rpc.QuoteMessage(resp.GetNonce(), csr)
+	require.NoError(err)
+	_, err = c.Activate(ctx, &rpc.ActivationReq{CSR: csr, MarbleType: "frontend", Quote: quoteOver(message), Nonce: resp.GetNonce(), UUID: uuid.New().String()})
+	assert.Equal(codes.Unauthenticated, status.Code(err))
+	c.nonces.now = time.Now
+
+	// marbles that don't request a nonce still quote their TLS certificate
+	ctx, cert, csr := newMarble()
+	_, err = c.Activate(ctx, &rpc.ActivationReq{CSR: csr, MarbleType: "frontend", Quote: quoteOver(cert.Raw), UUID: uuid.New().String()})
+	assert.NoError(err)
+}
+
+func TestActivateRejectsReplayedQuote(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, manifest := mustSetup()
+	_, err := c.SetManifest(context.TODO(), []byte(test.ManifestJSON), nil)
+	require.NoError(err)
+
+	cert, csr, _ := util.MustGenerateTestMarbleCredentials()
+	marbleQuote, err := c.qi.Issue(cert.Raw)
+	require.NoError(err)
+	c.qv.(*quote.MockValidator).AddValidQuote(marbleQuote, cert.Raw, manifest.Packages["frontend"], manifest.Infrastructures["Azure"])
+	ctx := peer.NewContext(context.TODO(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+	})
+	_, err = c.Activate(ctx, &rpc.ActivationReq{CSR: csr, MarbleType: "frontend", Quote: marbleQuote, UUID: uuid.New().String()})
+	require.NoError(err)
+	_, err = c.Activate(ctx, &rpc.ActivationReq{CSR: csr, MarbleType: "frontend", Quote: marbleQuote, UUID: uuid.New().String()})
+	assert.Equal(codes.Unauthenticated, status.Code(err))
+
+	// the replay cache is sealed
+	c2, err := NewCore([]string{"localhost"}, c.qv, c.qi, c.sealer, nil, false, zap.NewNop())
+	require.NoError(err)
+	assert.Equal(c.seenQuotes, c2.seenQuotes)
+	_, err = c2.Activate(ctx, &rpc.ActivationReq{CSR: csr, MarbleType: "frontend", Quote: marbleQuote, UUID: uuid.New().String()})
+	assert.Equal(codes.Unauthenticated, status.Code(err))
+
+	// the cache is bounded
+	c.seenQuotes = make([]string, maxSeenQuotes)
+	undo := c.recordQuote(marbleQuote)
+	assert.Len(c.seenQuotes, maxSeenQuotes)
+	assert.Equal(quoteHash(marbleQuote), c.seenQuotes[maxSeenQuotes-1])
+	undo()
+	assert.Len(c.seenQuotes, maxSeenQuotes)
+	assert.Empty(c.seenQuotes[maxSeenQuotes-1])
+}
+
+func TestActivateResumption(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, manifest := mustSetup()
+	_, err := c.SetManifest(context.TODO(), []byte(test.ManifestJSON), nil)
+	require.NoError(err)
+
+	// backend_first may only be activated once
+	activate := func(c *Core, marbleUUID string, token []byte, infra string) (*rpc.ActivationResp, error) {
+		cert, csr, _ := util.MustGenerateTestMarbleCredentials()
+		marbleQuote, err := c.qi.Issue(cert.Raw)
+		require.NoError(err)
+		c.qv.(*quote.MockValidator).AddValidQuote(marbleQuote, cert.Raw, manifest.Packages["backend"], manifest.Infrastructures[infra])
+		ctx := peer.NewContext(context.TODO(), &peer.Peer{
+			AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+		})
+		return c.Activate(ctx, &rpc.ActivationReq{CSR: csr, MarbleType: "backend_first", Quote: marbleQuote, UUID: marbleUUID, ResumptionToken: token})
+	}
+	marbleUUID := uuid.New().String()
+	resp, err := activate(c, marbleUUID, nil, "Azure")
+	require.NoError(err)
+	token := resp.GetResumptionToken()
+	require.NotEmpty(token)
+
+	// the marble resumes its activation with the token, which doesn't count as another activation
+	resp, err = activate(c, marbleUUID, token, "Azure")
+	require.NoError(err)
+	assert.Equal(token, resp.GetResumptionToken())
+	assert.EqualValues(1, c.activations["backend_first"])
+	assert.EqualValues(2, c.activeMarbles[marbleUUID].Activations)
+	auditLog, err := c.verifiedAuditLog()
+	require.NoError(err)
+	assert.Equal("true", auditLog[len(auditLog)-1].Details["Resumed"])
+
+	// the token is bound to the marble's UUID and infrastructure
+	_, err = activate(c, marbleUUID, nil, "Azure")
+	assert.Equal(codes.ResourceExhausted, status.Code(err))
+	_, err = activate(c, marbleUUID, []byte("forged"), "Azure")
+	assert.Equal(codes.ResourceExhausted, status.Code(err))
+	_, err = activate(c, uuid.New().String(), token, "Azure")
+	assert.Equal(codes.ResourceExhausted, status.Code(err))
+	_, err = activate(c, marbleUUID, token, "Alibaba")
+	assert.Equal(codes.ResourceExhausted, status.Code(err))
+
+	// the token stays valid when the Coordinator restarts
+	c2, err := NewCore([]string{"localhost"}, c.qv, c.qi, c.sealer, nil, false, zap.NewNop())
+	require.NoError(err)
+	_, err = activate(c2, marbleUUID, token, "Azure")
+	assert.NoError(err)
+	assert.EqualValues(1, c2.activations["backend_first"])
+}
+
+func TestDeriveSealKey(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, _ := mustSetup()
+	_, err := c.SetManifest(context.TODO(), []byte(test.ManifestJSON), nil)
+	require.NoError(err)
+	derive := func(c *Core, marbleType string, scope string, marbleUUID uuid.UUID) []byte {
+		key, err := c.deriveSealKey(marbleType, scope, marbleUUID)
+		require.NoError(err)
+		require.Len(key, 32)
+		return key
+	}
+	uuid1, uuid2 := uuid.New(), uuid.New()
+
+	// keys are derived per marble by default
+	perMarble := derive(c, "frontend", "", uuid1)
+	assert.Equal(perMarble, derive(c, "frontend", SealKeyScopeUUID, uuid1))
+	assert.Equal(perMarble, derive(c, "backend_first", "", uuid1))
+	assert.NotEqual(perMarble, derive(c, "frontend", "", uuid2))
+
+	// marbles of a type share their key
+	perType := derive(c, "frontend", SealKeyScopeMarbleType, uuid1)
+	assert.Equal(perType, derive(c, "frontend", SealKeyScopeMarbleType, uuid2))
+	assert.NotEqual(perType, derive(c, "backend_first", SealKeyScopeMarbleType, uuid1))
+	assert.NotEqual(perType, perMarble)
+
+	// the keys survive restarts of the Coordinator
+	c2, err := NewCore([]string{"localhost"}, c.qv, c.qi, c.sealer, nil, false, zap.NewNop())
+	require.NoError(err)
+	assert.Equal(perMarble, derive(c2, "frontend", "", uuid1))
+	assert.Equal(perType, derive(c2, "frontend", SealKeyScopeMarbleType, uuid2))
+}
+
+func histogramSampleCount(t *testing.T, histogram prometheus.Histogram) uint64 {
+	var metric dto.Metric
+	require.NoError(t, histogram.Write(&metric))
+	return metric.GetHistogram().GetSampleCount()
+}
+
+// BenchmarkActivate measures the throughput of activations of marbles of different types.
+// Run it with -cpu 1,4 to compare sequential and concurrent activations.
+func BenchmarkActivate(b *testing.B) {
+	c, manifest := mustSetup()
+	_, err := c.SetManifest(context.TODO(), []byte(test.ManifestJSON), nil)
+	require.NoError(b, err)
+	validator := c.qv.(*quote.MockValidator)
+	marbleTypes := []string{"frontend", "backend_other"}
+
+	type activation struct {
+		ctx context.Context
+		req *rpc.ActivationReq
+	}
+	activations := make(chan activation, b.N)
+	for i := 0; i < b.N; i++ {
+		marbleType := marbleTypes[i%len(marbleTypes)]
+		cert, csr, _ := util.MustGenerateTestMarbleCredentials()
+		marbleQuote, err := c.qi.Issue(cert.Raw)
+		require.NoError(b, err)
+		validator.AddValidQuote(marbleQuote, cert.Raw, manifest.Packages[manifest.Marbles[marbleType].Package], manifest.Infrastructures["Azure"])
+		ctx := peer.NewContext(context.TODO(), &peer.Peer{
+			AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+		})
+		activations <- activation{ctx, &rpc.ActivationReq{CSR: csr, MarbleType: marbleType, Quote: marbleQuote, UUID: uuid.New().String()}}
+	}
+	close(activations)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			a := <-activations
+			if _, err := c.Activate(a.ctx, a.req); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
+
+func TestActivateTracing(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	exporter := tracetest.NewInMemoryExporter()
+	global.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	defer global.SetTracerProvider(trace.NoopTracerProvider())
+
+	c, manifest := mustSetup()
+	_, err := c.SetManifest(context.TODO(), []byte(test.ManifestJSON), nil)
+	require.NoError(err)
+	spawner := marbleSpawner{
+		assert:     assert,
+		require:    require,
+		issuer:     c.qi,
+		validator:  c.qv.(*quote.MockValidator),
+		manifest:   *manifest,
+		coreServer: c,
+	}
+
+	// the steps of the activation are traced
+	exporter.Reset()
+	spawner.newMarble("frontend", "Azure", true)
+	var names []string
+	for _, span := range exporter.GetSpans() {
+		names = append(names, span.Name)
+		assert.Equal(otelcodes.Unset, span.StatusCode)
+	}
+	assert.Equal([]string{"validateQuote", "generateMarbleCert", "sealState"}, names)
+
+	// errors are recorded
+	exporter.Reset()
+	cert, csr, _ := util.MustGenerateTestMarbleCredentials()
+	ctx := peer.NewContext(context.TODO(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+	})
+	_, err = c.Activate(ctx, &rpc.ActivationReq{CSR: csr, MarbleType: "frontend", Quote: []byte("invalid"), UUID: uuid.New().String()})
+	assert.Error(err)
+	spans := exporter.GetSpans()
+	require.Len(spans, 1)
+	assert.Equal("validateQuote", spans[0].Name)
+	assert.Equal(otelcodes.Error, spans[0].StatusCode)
+}
+
+func TestRenew(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, manifest := mustSetup()
+	spawner := marbleSpawner{
+		assert:     assert,
+		require:    require,
+		issuer:     c.qi,
+		validator:  c.qv.(*quote.MockValidator),
+		manifest:   *manifest,
+		coreServer: c,
+	}
+	otherCert, csr, _ := util.MustGenerateTestMarbleCredentials()
+	renew := func(cert *x509.Certificate) (*rpc.RenewalResp, error) {
+		ctx := peer.NewContext(context.TODO(), &peer.Peer{
+			AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+		})
+		return c.Renew(ctx, &rpc.RenewalReq{CSR: csr})
+	}
+
+	// renewal is not possible before the manifest is set
+	_, err := renew(otherCert)
+	assert.Error(err)
+
+	_, err = c.SetManifest(context.TODO(), []byte(test.ManifestJSON), nil)
+	require.NoError(err)
+	params := spawner.newMarble("backend_first", "Azure", true)
+	require.NotNil(params)
+	block, _ := pem.Decode([]byte(params.Env[libMarble.MarbleEnvironmentCertificate]))
+	require.NotNil(block)
+	marbleCert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(err)
+
+	// only marble certificates can be renewed
+	_, err = renew(otherCert)
+	assert.Equal(codes.Unauthenticated, status.Code(err))
+
+	resp, err := renew(marbleCert)
+	require.NoError(err)
+	tlsCert, err := tls.X509KeyPair([]byte(resp.Certificate), []byte(resp.PrivateKey))
+	require.NoError(err)
+	require.Len(tlsCert.Certificate, 2)
+	newCert, err := x509.ParseCertificate(tlsCert.Certificate[0])
+	require.NoError(err)
+	assert.Equal(marbleCert.Subject.CommonName, newCert.Subject.CommonName)
+	assert.Equal(marbleCert.Issuer.CommonName, newCert.Issuer.CommonName)
+	assert.NotEqual(marbleCert.SerialNumber, newCert.SerialNumber)
+	packageCA, err := x509.ParseCertificate(tlsCert.Certificate[1])
+	require.NoError(err)
+	roots := x509.NewCertPool()
+	roots.AddCert(c.cert)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(packageCA)
+	_, err = newCert.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, DNSName: "localhost"})
+	assert.NoError(err)
+
+	// renewals do not count as activations, so they are possible when MaxActivations has been reached
+	assert.Equal(uint(1), c.activations["backend_first"])
+	spawner.newMarble("backend_first", "Azure", false)
+	_, err = renew(newCert)
+	assert.NoError(err)
+	assert.Equal(uint(1), c.activations["backend_first"])
+
+	// certificates that have been issued by a package CA, but not recorded, are rejected
+	unrecordedCert, _, _, err := c.generateMarbleCert(csr, "backend_first", "backend", uuid.New().String(), nil, nil)
+	require.NoError(err)
+	_, err = renew(unrecordedCert)
+	assert.Equal(codes.Unauthenticated, status.Code(err))
+
+	// renewals are paused with the activations
+	c.maintenance = &maintenance{Since: time.Now()}
+	_, err = renew(newCert)
+	assert.Equal(codes.Unavailable, status.Code(err))
+	c.maintenance = nil
+
+	// the marble must activate again once its type is removed or its package requires a higher SecurityVersion
+	marble := c.manifest.Marbles["backend_first"]
+	delete(c.manifest.Marbles, "backend_first")
+	_, err = renew(newCert)
+	assert.Equal(codes.PermissionDenied, status.Code(err))
+	c.manifest.Marbles["backend_first"] = marble
+	pkg := c.manifest.Packages["backend"]
+	securityVersion := uint(2)
+	pkg.SecurityVersion = &securityVersion
+	c.manifest.Packages["backend"] = pkg
+	_, err = renew(newCert)
+	assert.Equal(codes.PermissionDenied, status.Code(err))
+}
+
+func TestAttestedSecurityVersion(t *testing.T) {
+	assert := assert.New(t)
+
+	low, high := uint(1), uint(2)
+	assert.Nil(attestedSecurityVersion(nil, nil))
+	assert.Equal(&low, attestedSecurityVersion(&low, nil))
+	assert.Equal(&high, attestedSecurityVersion(&low, &high))
+	assert.Equal(&high, attestedSecurityVersion(&high, &low))
+	assert.Equal(&low, attestedSecurityVersion(nil, &low))
+}
+
+func TestRevokeMarble(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, manifest := mustSetup()
+	manifest.Users = map[string]User{
+		"admin":  {Certificate: test.CertPEM(test.AdminCert), Roles: []string{"revoker"}},
+		"admin2": {Certificate: test.CertPEM(test.SecondAdminCert)},
+	}
+	manifest.Roles = map[string]Role{"revoker": {ResourceType: "Marbles", Actions: []string{"RevokeMarble"}}}
+	rawManifest, err := json.Marshal(manifest)
+	require.NoError(err)
+	_, err = c.SetManifest(context.TODO(), rawManifest, nil)
+	require.NoError(err)
+	c.SetCRLURL("https://coordinator:4433/api/v1/crl")
+
+	spawner := marbleSpawner{
+		assert:     assert,
+		require:    require,
+		issuer:     c.qi,
+		validator:  c.qv.(*quote.MockValidator),
+		manifest:   *manifest,
+		coreServer: c,
+	}
+	activate := func() (*x509.Certificate, *x509.Certificate) {
+		params := spawner.newMarble("frontend", "Azure", true)
+		require.NotNil(params)
+		block, rest := pem.Decode([]byte(params.Env[libMarble.MarbleEnvironmentCertificate]))
+		require.NotNil(block)
+		cert, err := x509.ParseCertificate(block.Bytes)
+		require.NoError(err)
+		block, _ = pem.Decode(rest)
+		require.NotNil(block)
+		caCert, err := x509.ParseCertificate(block.Bytes)
+		require.NoError(err)
+		return cert, caCert
+	}
+	_, csr, _ := util.MustGenerateTestMarbleCredentials()
+	renew := func(cert *x509.Certificate) (*x509.Certificate, error) {
+		ctx := peer.NewContext(context.TODO(), &peer.Peer{
+			AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+		})
+		resp, err := c.Renew(ctx, &rpc.RenewalReq{CSR: csr})
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode([]byte(resp.Certificate))
+		return x509.ParseCertificate(block.Bytes)
+	}
+	getRevoked := func(caCert *x509.Certificate) []*big.Int {
+		rawCRL, err := c.GetCRL(context.TODO(), "frontend")
+		require.NoError(err)
+		crl, err := x509.ParseCRL(rawCRL)
+		require.NoError(err)
+		require.NoError(caCert.CheckCRLSignature(crl))
+		var serials []*big.Int
+		for _, revoked := range crl.TBSCertList.RevokedCertificates {
+			serials = append(serials, revoked.SerialNumber)
+		}
+		return serials
+	}
+
+	cert1, caCert := activate()
+	cert2, _ := activate()
+	assert.Equal([]string{"https://coordinator:4433/api/v1/crl?package=frontend"}, cert1.CRLDistributionPoints)
+	assert.Empty(getRevoked(caCert))
+	_, err = c.GetCRL(context.TODO(), "backend")
+	assert.Equal(ErrUnknownPackage, err)
+
+	// only permitted users can revoke marbles
+	_, err = c.RevokeMarble(context.TODO(), "frontend", "", test.SecondAdminCert)
+	assert.Equal(ErrNotAuthorized, err)
+	_, err = c.RevokeMarble(context.TODO(), "", "", test.AdminCert)
+	assert.Error(err)
+	_, err = c.RevokeMarble(context.TODO(), "", uuid.New().String(), test.AdminCert)
+	assert.Error(err)
+
+	revoked, err := c.RevokeMarble(context.TODO(), "", cert1.Subject.CommonName, test.AdminCert)
+	require.NoError(err)
+	assert.Equal(1, revoked)
+	assert.Equal([]*big.Int{cert1.SerialNumber}, getRevoked(caCert))
+
+	// a revoked marble can neither renew its certificate nor activate again
+	_, err = renew(cert1)
+	assert.Equal(codes.Unauthenticated, status.Code(err))
+	marbleCert, _, _ := util.MustGenerateTestMarbleCredentials()
+	marbleQuote, err := c.qi.Issue(marbleCert.Raw)
+	require.NoError(err)
+	spawner.validator.AddValidQuote(marbleQuote, marbleCert.Raw, manifest.Packages["frontend"], manifest.Infrastructures["Azure"])
+	ctx := peer.NewContext(context.TODO(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{marbleCert}}},
+	})
+	_, err = c.Activate(ctx, &rpc.ActivationReq{CSR: csr, MarbleType: "frontend", Quote: marbleQuote, UUID: cert1.Subject.CommonName})
+	assert.Equal(codes.PermissionDenied, status.Code(err))
+
+	// the renewed certificate of another marble is revoked with its marble type
+	renewed, err := renew(cert2)
+	require.NoError(err)
+	revoked, err = c.RevokeMarble(context.TODO(), "frontend", "", test.AdminCert)
+	require.NoError(err)
+	assert.Equal(2, revoked)
+	assert.ElementsMatch([]*big.Int{cert1.SerialNumber, cert2.SerialNumber, renewed.SerialNumber}, getRevoked(caCert))
+	_, err = renew(renewed)
+	assert.Equal(codes.Unauthenticated, status.Code(err))
+
+	// new marbles of the type can still be activated
+	activate()
+
+	// the revocations are sealed
+	c2, err := NewCore([]string{"localhost"}, c.qv, c.qi, c.sealer, nil, false, zap.NewNop())
+	require.NoError(err)
+	assert.Equal(c.marbleCerts, c2.marbleCerts)
+}
+
+func TestHeartbeat(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, manifest := mustSetup()
+	backend := manifest.Marbles["backend_first"]
+	backend.HeartbeatTTL = 60
+	manifest.Marbles["backend_first"] = backend
+	rawManifest, err := json.Marshal(manifest)
+	require.NoError(err)
+	_, err = c.SetManifest(context.TODO(), rawManifest, nil)
+	require.NoError(err)
+
+	spawner := marbleSpawner{
+		assert:     assert,
+		require:    require,
+		issuer:     c.qi,
+		validator:  c.qv.(*quote.MockValidator),
+		manifest:   *manifest,
+		coreServer: c,
+	}
+	heartbeat := func(cert *x509.Certificate) (*rpc.HeartbeatResp, error) {
+		ctx := peer.NewContext(context.TODO(), &peer.Peer{
+			AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+		})
+		return c.Heartbeat(ctx, &rpc.HeartbeatReq{})
+	}
+
+	params := spawner.newMarble("backend_first", "Azure", true)
+	require.NotNil(params)
+	block, _ := pem.Decode([]byte(params.Env[libMarble.MarbleEnvironmentCertificate]))
+	require.NotNil(block)
+	marbleCert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(err)
+	marbleUUID := marbleCert.Subject.CommonName
+
+	// only marble certificates are accepted
+	otherCert, _, _ := util.MustGenerateTestMarbleCredentials()
+	_, err = heartbeat(otherCert)
+	assert.Equal(codes.Unauthenticated, status.Code(err))
+
+	resp, err := heartbeat(marbleCert)
+	require.NoError(err)
+	assert.EqualValues(30, resp.Interval)
+	marbles, err := c.GetMarbleStatus(context.TODO())
+	require.NoError(err)
+	assert.EqualValues(1, marbles["backend_first"].Activations)
+	assert.False(c.activeMarbles[marbleUUID].LastSeen.IsZero())
+
+	// the activation of a live marble isn't released
+	spawner.newMarble("backend_first", "Azure", false)
+
+	// the activation of a silent marble is released
+	silent := c.activeMarbles[marbleUUID]
+	silent.LastSeen = time.Now().Add(-2 * time.Minute)
+	c.activeMarbles[marbleUUID] = silent
+	marbles, err = c.GetMarbleStatus(context.TODO())
+	require.NoError(err)
+	assert.EqualValues(0, marbles["backend_first"].Activations)
+	assert.EqualValues(1, marbles["backend_first"].Remaining)
+	spawner.newMarble("backend_first", "Azure", true)
+	assert.EqualValues(1, c.activations["backend_first"])
+	_, err = heartbeat(marbleCert)
+	assert.Equal(codes.NotFound, status.Code(err))
+
+	// the active marbles are sealed
+	c2, err := NewCore([]string{"localhost"}, c.qv, c.qi, c.sealer, nil, false, zap.NewNop())
+	require.NoError(err)
+	assert.Len(c2.activeMarbles, 1)
+	assert.NotContains(c2.activeMarbles, marbleUUID)
+}
+
+func TestResetActivations(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, manifest := mustSetup()
+	frontend := manifest.Marbles["frontend"]
+	frontend.InfrastructureMaxActivations = map[string]uint{"Unknown": 1}
+	manifest.Marbles["frontend"] = frontend
+	rawManifest, err := json.Marshal(manifest)
+	require.NoError(err)
+	_, err = c.SetManifest(context.TODO(), rawManifest, nil)
+	assert.Error(err)
+
+	frontend.InfrastructureMaxActivations = map[string]uint{"Azure": 1}
+	manifest.Marbles["frontend"] = frontend
+	manifest.Users = map[string]User{
+		"admin":  {Certificate: test.CertPEM(test.AdminCert), Roles: []string{"resetter"}},
+		"admin2": {Certificate: test.CertPEM(test.SecondAdminCert)},
+	}
+	manifest.Roles = map[string]Role{"resetter": {ResourceType: "Marbles", Actions: []string{"ResetActivations"}}}
+	rawManifest, err = json.Marshal(manifest)
+	require.NoError(err)
+	_, err = c.SetManifest(context.TODO(), rawManifest, nil)
+	require.NoError(err)
+
+	spawner := marbleSpawner{
+		assert:     assert,
+		require:    require,
+		issuer:     c.qi,
+		validator:  c.qv.(*quote.MockValidator),
+		manifest:   *manifest,
+		coreServer: c,
+	}
+
+	// the activations are limited per infrastructure
+	spawner.newMarble("frontend", "Azure", true)
+	spawner.newMarble("frontend", "Azure", false)
+	spawner.newMarble("frontend", "Alibaba", true)
+	spawner.newMarble("backend_first", "Azure", true)
+	spawner.newMarble("backend_first", "Alibaba", false)
+
+	// only permitted users can reset activations
+	assert.Equal(ErrNotAuthorized, c.ResetActivations(context.TODO(), "frontend", "Azure", 0, test.SecondAdminCert))
+	assert.Equal(ErrNotAuthorized, c.ResetActivations(context.TODO(), "frontend", "Azure", 0, nil))
+	assert.Error(c.ResetActivations(context.TODO(), "unknown", "", 0, test.AdminCert))
+	assert.Error(c.ResetActivations(context.TODO(), "frontend", "unknown", 0, test.AdminCert))
+
+	require.NoError(c.ResetActivations(context.TODO(), "frontend", "Azure", 0, test.AdminCert))
+	spawner.newMarble("frontend", "Azure", true)
+	spawner.newMarble("frontend", "Azure", false)
+	require.NoError(c.ResetActivations(context.TODO(), "backend_first", "", 0, test.AdminCert))
+	spawner.newMarble("backend_first", "Alibaba", true)
+	spawner.newMarble("backend_first", "Alibaba", false)
+
+	// a higher value reduces the remaining activations
+	require.NoError(c.ResetActivations(context.TODO(), "frontend", "Alibaba", 5, test.AdminCert))
+	assert.EqualValues(5, c.infrastructureActivations["frontend"]["Alibaba"])
+
+	entries, err := c.verifiedAuditLog()
+	require.NoError(err)
+	last := entries[len(entries)-1]
+	assert.Equal(clientapi.AuditEventResetActivations, last.Event)
+	assert.Equal("admin", last.User)
+	assert.Equal(map[string]string{"MarbleType": "frontend", "Infrastructure": "Alibaba", "Activations": "5", "Previous": "1"}, last.Details)
+
+	// the counters are sealed
+	c2, err := NewCore([]string{"localhost"}, c.qv, c.qi, c.sealer, nil, false, zap.NewNop())
+	require.NoError(err)
+	assert.Equal(map[string]map[string]uint{"frontend": {"Azure": 1, "Alibaba": 5}, "backend_first": {"Azure": 1, "Alibaba": 1}}, c2.infrastructureActivations)
+	assert.EqualValues(1, c2.activations["backend_first"])
+}
+
+func TestMaintenance(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, manifest := mustSetup()
+	manifest.Users = map[string]User{
+		"admin":  {Certificate: test.CertPEM(test.AdminCert), Roles: []string{"maintainer"}},
+		"admin2": {Certificate: test.CertPEM(test.SecondAdminCert)},
+	}
+	manifest.Roles = map[string]Role{"maintainer": {ResourceType: "Marbles", Actions: []string{"PauseActivations"}}}
+	rawManifest, err := json.Marshal(manifest)
+	require.NoError(err)
+	_, err = c.SetManifest(context.TODO(), rawManifest, nil)
+	require.NoError(err)
+
+	spawner := marbleSpawner{
+		assert:     assert,
+		require:    require,
+		issuer:     c.qi,
+		validator:  c.qv.(*quote.MockValidator),
+		manifest:   *manifest,
+		coreServer: c,
+	}
+	activate := func() error {
+		cert, csr, _ := util.MustGenerateTestMarbleCredentials()
+		marbleQuote, err := c.qi.Issue(cert.Raw)
+		require.NoError(err)
+		c.qv.(*quote.MockValidator).AddValidQuote(marbleQuote, cert.Raw, manifest.Packages["frontend"], manifest.Infrastructures["Azure"])
+		ctx := peer.NewContext(context.TODO(), &peer.Peer{
+			AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+		})
+		_, err = c.Activate(ctx, &rpc.ActivationReq{CSR: csr, MarbleType: "frontend", Quote: marbleQuote, UUID: uuid.New().String()})
+		return err
+	}
+
+	// only permitted users can pause activations
+	assert.Equal(ErrNotAuthorized, c.SetMaintenance(context.TODO(), true, "backup", test.SecondAdminCert))
+	assert.Equal(ErrNotAuthorized, c.SetMaintenance(context.TODO(), true, "backup", nil))
+	assert.False(c.GetMaintenance(context.TODO()).Paused)
+
+	require.NoError(c.SetMaintenance(context.TODO(), true, "backup", test.AdminCert))
+	maintenance := c.GetMaintenance(context.TODO())
+	assert.True(maintenance.Paused)
+	assert.Equal("admin", maintenance.User)
+	assert.Equal("backup", maintenance.Reason)
+	assert.False(maintenance.Since.IsZero())
+
+	// marbles can't activate, but may retry
+	err = activate()
+	assert.Equal(codes.Unavailable, status.Code(err))
+	reason, metadata := rpc.ErrorReason(err)
+	assert.Equal(rpc.ReasonMaintenance, reason)
+	assert.Equal("backup", metadata["Reason"])
+	assert.True(rpc.IsRetryable(err))
+	_, err = c.Nonce(peer.NewContext(context.TODO(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{test.AdminCert}}},
+	}), &rpc.NonceReq{})
+	assert.Equal(codes.Unavailable, status.Code(err))
+
+	// the pause is sealed
+	c2, err := NewCore([]string{"localhost"}, c.qv, c.qi, c.sealer, nil, false, zap.NewNop())
+	require.NoError(err)
+	assert.Equal(maintenance, c2.GetMaintenance(context.TODO()))
+
+	// pausing again doesn't change the pause
+	require.NoError(c.SetMaintenance(context.TODO(), true, "other", test.AdminCert))
+	assert.Equal(maintenance, c.GetMaintenance(context.TODO()))
+
+	require.NoError(c.SetMaintenance(context.TODO(), false, "", test.AdminCert))
+	assert.False(c.GetMaintenance(context.TODO()).Paused)
+	assert.NoError(activate())
+	spawner.newMarble("backend_first", "Azure", true)
+
+	entries, err := c.verifiedAuditLog()
+	require.NoError(err)
+	var events []string
+	for _, entry := range entries {
+		events = append(events, entry.Event)
+	}
+	assert.Equal([]string{clientapi.AuditEventSetManifest, clientapi.AuditEventPauseActivations, clientapi.AuditEventResumeActivations,
+		clientapi.AuditEventActivate, clientapi.AuditEventActivate}, events)
+}
+
+func TestWatchParameters(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, manifest := mustSetup()
+	manifest.Users = map[string]User{"admin": {Certificate: test.CertPEM(test.AdminCert), Roles: []string{"rotator"}}}
+	manifest.Roles = map[string]Role{"rotator": {ResourceType: "Secrets", Actions: []string{"RotateSecret"}}}
+	rawManifest, err := json.Marshal(manifest)
+	require.NoError(err)
+	_, err = c.SetManifest(context.TODO(), rawManifest, nil)
+	require.NoError(err)
+
+	spawner := marbleSpawner{
+		assert:     assert,
+		require:    require,
+		issuer:     c.qi,
+		validator:  c.qv.(*quote.MockValidator),
+		manifest:   *manifest,
+		coreServer: c,
+	}
+	params := spawner.newMarble("backend_first", "Azure", true)
+	require.NotNil(params)
+	block, _ := pem.Decode([]byte(params.Env[libMarble.MarbleEnvironmentCertificate]))
+	require.NotNil(block)
+	marbleCert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(err)
+
+	csrKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+	csr, err := util.GenerateCSR([]string{"localhost"}, csrKey)
+	require.NoError(err)
+	watch := func(cert *x509.Certificate) (*stubParametersStream, chan error) {
+		ctx, cancel := context.WithCancel(peer.NewContext(context.Background(), &peer.Peer{
+			AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+		}))
+		stream := &stubParametersStream{ctx: ctx, cancel: cancel, resps: make(chan *rpc.WatchParametersResp, 1)}
+		errs := make(chan error, 1)
+		go func() { errs <- c.WatchParameters(&rpc.WatchParametersReq{CSR: csr.Raw}, stream) }()
+		return stream, errs
+	}
+	watchers := func() int {
+		c.mux.Lock()
+		defer c.mux.Unlock()
+		return len(c.parameterWatchers)
+	}
+
+	// only marble certificates are accepted
+	otherCert, _, _ := util.MustGenerateTestMarbleCredentials()
+	_, errs := watch(otherCert)
+	assert.Equal(codes.Unauthenticated, status.Code(<-errs))
+
+	stream, errs := watch(marbleCert)
+	require.Eventually(func() bool { return watchers() == 1 }, 10*time.Second, 10*time.Millisecond)
+
+	// rotating a secret the marble references pushes the parameters with a new certificate
+	_, err = c.RotateSecrets(context.TODO(), []string{"symmetric_key_shared"}, test.AdminCert)
+	require.NoError(err)
+	var updated *rpc.WatchParametersResp
+	select {
+	case updated = <-stream.resps:
+	case <-time.After(10 * time.Second):
+		t.Fatal("parameters haven't been pushed")
+	}
+	assert.NotEqual(params.Env["TEST_SECRET_SYMMETRIC_KEY"], updated.Parameters.Env["TEST_SECRET_SYMMETRIC_KEY"])
+	assert.Equal(params.Env["SEAL_KEY"], updated.Parameters.Env["SEAL_KEY"])
+	block, _ = pem.Decode([]byte(updated.Parameters.Env[libMarble.MarbleEnvironmentCertificate]))
+	require.NotNil(block)
+	newCert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(err)
+	assert.Equal(marbleCert.Subject.CommonName, newCert.Subject.CommonName)
+	assert.NotEqual(marbleCert.SerialNumber, newCert.SerialNumber)
+	assert.EqualValues(1, c.activations["backend_first"])
+
+	// the stream ends when the marble disconnects
+	stream.cancel()
+	assert.NoError(<-errs)
+	assert.Zero(watchers())
+}
+
+func TestChangedSecrets(t *testing.T) {
+	assert := assert.New(t)
+
+	oldSecrets := map[string]Secret{"shared": {Type: "symmetric-key"}, "written": {Type: "plain"}}
+	oldVersions := map[string]uint{"written": 2}
+	newSecrets := map[string]Secret{"shared": {Type: "symmetric-key"}, "written": {Type: "plain"}, "new": {Type: "plain"}}
+	newVersions := map[string]uint{"written": 3, "private": 2}
+	assert.Equal([]string{"new", "private", "written"}, changedSecrets(oldSecrets, oldVersions, newSecrets, newVersions))
+	assert.Empty(changedSecrets(oldSecrets, oldVersions, oldSecrets, oldVersions))
+}
+
+type stubParametersStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	cancel context.CancelFunc
+	resps  chan *rpc.WatchParametersResp
+}
+
+func (s *stubParametersStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *stubParametersStream) Send(resp *rpc.WatchParametersResp) error {
+	s.resps <- resp
+	return nil
+}
+
+func TestNegotiateAPIVersion(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	version, err := negotiateAPIVersion(0)
+	require.NoError(err)
+	assert.EqualValues(1, version)
+
+	version, err = negotiateAPIVersion(rpc.ActivationAPIVersion)
+	require.NoError(err)
+	assert.EqualValues(rpc.ActivationAPIVersion, version)
+
+	// newer marbles are downgraded to the Coordinator's version
+	version, err = negotiateAPIVersion(rpc.ActivationAPIVersion + 1)
+	require.NoError(err)
+	assert.EqualValues(rpc.ActivationAPIVersion, version)
+}
+
+func TestReferencedSecrets(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	params := &rpc.Parameters{
+		Files: map[string]string{"/cert.pem": "{{ pem .Secrets.cert.Cert }}{{ pem .Marblerun.RootCA.Cert }}"},
+		Env: map[string]string{
+			"KEY":      "{{ if .Secrets.flag }}{{ hex .Secrets.key }}{{ else }}{{ base64 $.Secrets.other }}{{ end }}",
+			"DYNAMIC":  `{{ raw (index .Secrets "dynamic") }}`,
+			"CONSTANT": "Secrets",
+		},
+		Argv: []string{"{{ with .Secrets.argv }}{{ raw . }}{{ end }}"},
+	}
+	referenced, err := referencedSecrets(params)
+	require.NoError(err)
+	assert.Equal([]string{"argv", "cert", "flag", "key", "other"}, referenced)
+
+	referenced, err = referencedSecrets(nil)
+	require.NoError(err)
+	assert.Empty(referenced)
+
+	_, err = referencedSecrets(&rpc.Parameters{Env: map[string]string{"INVALID": "{{ raw .Secrets.key "}})
+	assert.Error(err)
 }
 
 type marbleSpawner struct {
@@ -114,7 +1398,8 @@ type marbleSpawner struct {
 	backendOtherUniqueCert x509.Certificate
 }
 
-func (ms *marbleSpawner) newMarble(marbleType string, infraName string, shouldSucceed bool) {
+// newMarble activates a marble of the given type and returns its parameters if the activation succeeded.
+func (ms *marbleSpawner) newMarble(marbleType string, infraName string, shouldSucceed bool) *rpc.Parameters {
 	cert, csr, _ := util.MustGenerateTestMarbleCredentials()
 
 	// create mock quote using values from the manifest
@@ -149,10 +1434,14 @@ func (ms *marbleSpawner) newMarble(marbleType string, infraName string, shouldSu
 	if !shouldSucceed {
 		ms.assert.Error(err)
 		ms.assert.Nil(resp)
-		return
+		return nil
 	}
 	ms.assert.NoError(err, "Activate failed: %v", err)
 	ms.assert.NotNil(resp)
+	// the spawner doesn't send a version, so it implements version 1
+	ms.assert.EqualValues(1, resp.GetAPIVersion())
+	_, err = tls.X509KeyPair([]byte(resp.GetCertificate()), []byte(resp.GetPrivateKey()))
+	ms.assert.NoError(err)
 
 	// Validate response
 	params := resp.GetParameters()
@@ -174,24 +1463,35 @@ func (ms *marbleSpawner) newMarble(marbleType string, infraName string, shouldSu
 	p, _ := pem.Decode([]byte(params.Env[libMarble.MarbleEnvironmentPrivateKey]))
 	ms.assert.NotNil(p)
 
-	// Validate Cert
-	p, _ = pem.Decode([]byte(params.Env[libMarble.MarbleEnvironmentCertificate]))
+	// Validate Cert, which is followed by the package CA
+	p, rest := pem.Decode([]byte(params.Env[libMarble.MarbleEnvironmentCertificate]))
 	ms.assert.NotNil(p)
 	newCert, err := x509.ParseCertificate(p.Bytes)
 	ms.assert.NoError(err)
-	ms.assert.Equal(CoordinatorName, newCert.Issuer.CommonName)
+	ms.assert.Equal(marble.Package, newCert.Issuer.CommonName)
+	p, _ = pem.Decode(rest)
+	ms.assert.NotNil(p)
+	packageCA, err := x509.ParseCertificate(p.Bytes)
+	ms.assert.NoError(err)
+	ms.assert.Equal(marble.Package, packageCA.Subject.CommonName)
+	ms.assert.True(packageCA.IsCA)
+	ms.assert.NoError(packageCA.CheckSignatureFrom(ms.coreServer.cert))
 	// Check CommonName
 	_, err = uuid.Parse(newCert.Subject.CommonName)
 	ms.assert.NoError(err, "cert.Subject.CommonName is not a valid UUID: %v", err)
-	// Check KeyUusage:
-	ms.assert.Equal(cert.KeyUsage, newCert.KeyUsage)
+	// Check the identity of the marble
+	id, err := identity.FromCertificate(newCert)
+	ms.assert.NoError(err)
+	ms.assert.Equal(identity.Identity{MarbleType: marbleType, Package: marble.Package, ProductID: pkg.ProductID, UUID: newCert.Subject.CommonName}, id)
+	// Check KeyUsage, which depends on the configured key type
+	ms.assert.Equal(ms.manifest.marbleCertificateConfig(marble.Package).keyUsage(), newCert.KeyUsage)
 	// Check ExtKeyUsage
 	ms.assert.Equal(cert.ExtKeyUsage, newCert.ExtKeyUsage)
-	// Check DNSNames
-	ms.assert.Equal(cert.DNSNames, newCert.DNSNames)
-	ms.assert.Equal(cert.IPAddresses, newCert.IPAddresses)
+	// Check DNSNames, the marble's TLS settings may add more
+	ms.assert.Subset(newCert.DNSNames, cert.DNSNames)
+	ms.assert.Subset(newCert.IPAddresses, cert.IPAddresses)
 	// Check Signature
-	ms.assert.NoError(ms.coreServer.cert.CheckSignature(newCert.SignatureAlgorithm, newCert.RawTBSCertificate, newCert.Signature))
+	ms.assert.NoError(newCert.CheckSignatureFrom(packageCA))
 
 	// Validate generated secret (only specified in backend_first)
 	if marbleType == "backend_first" {
@@ -203,10 +1503,13 @@ func (ms *marbleSpawner) newMarble(marbleType string, infraName string, shouldSu
 	// Check cert-chain
 	roots := x509.NewCertPool()
 	ms.assert.True(roots.AppendCertsFromPEM([]byte(params.Env[libMarble.MarbleEnvironmentRootCA])), "cannot parse rootCA")
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(packageCA)
 	opts := x509.VerifyOptions{
-		Roots:     roots,
-		DNSName:   "localhost",
-		KeyUsages: newCert.ExtKeyUsage,
+		Roots:         roots,
+		Intermediates: intermediates,
+		DNSName:       "localhost",
+		KeyUsages:     newCert.ExtKeyUsage,
 	}
 	_, err = newCert.Verify(opts)
 	ms.assert.NoError(err, "failed to verify new certificate: %v", err)
@@ -230,6 +1533,8 @@ func (ms *marbleSpawner) newMarble(marbleType string, infraName string, shouldSu
 		}
 		ms.mutex.Unlock()
 	}
+
+	return params
 }
 
 func (ms *marbleSpawner) newMarbleAsync(marbleType string, infraName string, shouldSucceed bool) {
@@ -368,4 +1673,66 @@ func TestParseSecrets(t *testing.T) {
 	// We should get an error if we try to get a non-existing secret
 	_, err = parseSecrets("{{ hex .Secrets.idontexist }}", testWrappedSecrets)
 	assert.Error(err)
+	_, err = parseSecrets("{{ raw .Secrets.idontexist }}", testWrappedSecrets)
+	assert.Error(err)
+}
+
+func TestCustomizeParameters(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c := NewCoreWithMocks()
+	testReservedSecrets := reservedSecrets{
+		RootCA:     Secret{Cert: Certificate(*c.cert)},
+		MarbleCert: Secret{Cert: Certificate(*c.cert), Private: []byte{7, 0, 0}},
+		SealKey:    Secret{Public: []byte{0, 1, 2, 3}, Private: []byte{0, 1, 2, 3}},
+	}
+	testSecrets := map[string]Secret{
+		"mysecret": {Type: "symmetric-key", Size: 32, Public: []byte{4, 5, 6, 7}, Private: []byte{4, 5, 6, 7}},
+	}
+
+	params := &rpc.Parameters{
+		Files:    map[string]string{"/key": "{{ raw .Secrets.mysecret }}"},
+		Env:      map[string]string{"SEAL_KEY": "{{ hex .Marblerun.SealKey }}", "THREADS": "{{ .HostEnv.OMP_NUM_THREADS }}"},
+		Argv:     []string{"./marble", "--key={{ base64 .Secrets.mysecret }}", "{{ hex .Marblerun.MarbleCert.Private }}"},
+		HostEnv:  []string{"OMP_NUM_THREADS"},
+		ScrubEnv: true,
+		FileSpecs: map[string]*rpc.File{
+			"/key.bin": {Data: []byte("{{ base64 .Secrets.mysecret }}"), Encoding: rpc.EncodingBase64, Mode: 0400, User: "app", NoDisk: true},
+			"/run.sh":  {Data: []byte("#!/bin/sh"), Mode: 0755},
+		},
+	}
+
+	customParams, err := customizeParameters(params, testReservedSecrets, testSecrets)
+	require.NoError(err)
+	assert.Equal(string([]byte{4, 5, 6, 7}), customParams.Files["/key"])
+	// the data of files with attributes is decoded
+	assert.Equal(&rpc.File{Data: []byte{4, 5, 6, 7}, Mode: 0400, User: "app", NoDisk: true}, customParams.FileSpecs["/key.bin"])
+	assert.Equal(&rpc.File{Data: []byte("#!/bin/sh"), Mode: 0755}, customParams.FileSpecs["/run.sh"])
+	assert.Equal("00010203", customParams.Env["SEAL_KEY"])
+	assert.Equal([]string{"./marble", "--key=BAUGBw==", "070000"}, customParams.Argv)
+	// the marble substitutes its host environment
+	assert.Equal(rpc.HostEnvPlaceholder("OMP_NUM_THREADS"), customParams.Env["THREADS"])
+	assert.Equal([]string{"OMP_NUM_THREADS"}, customParams.HostEnv)
+	assert.True(customParams.ScrubEnv)
+
+	// host environment variables that aren't passed through must not be referenced
+	params.Env["HOME"] = "{{ .HostEnv.HOME }}"
+	_, err = customizeParameters(params, testReservedSecrets, testSecrets)
+	assert.Error(err)
+	delete(params.Env, "HOME")
+
+	// the manifest's parameters must not be modified
+	assert.Equal("--key={{ base64 .Secrets.mysecret }}", params.Argv[1])
+
+	// invalid templates in argv must be reported
+	params.Argv = []string{"{{ pem .Secrets.mysecret }}"}
+	_, err = customizeParameters(params, testReservedSecrets, testSecrets)
+	assert.Error(err)
+
+	// invalid base64 data must be reported
+	params.Argv = nil
+	params.FileSpecs["/key.bin"].Data = []byte("{{ hex .Secrets.mysecret }}!")
+	_, err = customizeParameters(params, testReservedSecrets, testSecrets)
+	assert.Error(err)
 }
@@ -0,0 +1,149 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// unknownMarbleType is the label value for activation requests of marble types that are not defined in the manifest.
+// It prevents clients from creating arbitrary label values.
+const unknownMarbleType = "unknown"
+
+// The phases of an activation whose durations are observed by activationPhaseDuration.
+const (
+	phaseQuoteVerification   = "quote_verification"
+	phaseCertificateIssuance = "certificate_issuance"
+	phaseStateWrite          = "state_write"
+)
+
+// activeMarbleTimeout is the time since its last heartbeat after which a marble isn't counted as active anymore. One missed heartbeat is tolerated.
+const activeMarbleTimeout = 2 * heartbeatInterval
+
+var (
+	activationAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "marblerun",
+		Subsystem: "coordinator",
+		Name:      "activation_attempts_total",
+		Help:      "Number of marble activation requests.",
+	}, []string{"marble_type"})
+	activationSuccesses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "marblerun",
+		Subsystem: "coordinator",
+		Name:      "activation_successes_total",
+		Help:      "Number of successful marble activations.",
+	}, []string{"marble_type", "infrastructure"})
+	activationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "marblerun",
+		Subsystem: "coordinator",
+		Name:      "activation_failures_total",
+		Help:      "Number of failed marble activations.",
+	}, []string{"marble_type"})
+	activationsRateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "marblerun",
+		Subsystem: "coordinator",
+		Name:      "activations_rate_limited_total",
+		Help:      "Number of marble activation requests that have been rejected because of the activation rate limits.",
+	}, []string{"marble_type"})
+	quoteVerificationsWaiting = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "marblerun",
+		Subsystem: "coordinator",
+		Name:      "quote_verifications_waiting",
+		Help:      "Number of activation requests that wait for the verification of their quotes.",
+	})
+	quoteVerificationDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "marblerun",
+		Subsystem: "coordinator",
+		Name:      "quote_verification_duration_seconds",
+		Help:      "Duration of the verification of marble quotes.",
+		Buckets:   prometheus.DefBuckets,
+	})
+	activationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "marblerun",
+		Subsystem: "coordinator",
+		Name:      "activation_duration_seconds",
+		Help:      "Duration of successful marble activations.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"marble_type", "infrastructure"})
+	activationPhaseDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "marblerun",
+		Subsystem: "coordinator",
+		Name:      "activation_phase_duration_seconds",
+		Help:      "Duration of the phases of marble activations: quote verification, certificate issuance and state write.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"marble_type", "phase"})
+	heartbeats = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "marblerun",
+		Subsystem: "coordinator",
+		Name:      "heartbeats_total",
+		Help:      "Number of heartbeats of activated marbles.",
+	}, []string{"marble_type", "infrastructure"})
+	certificateRenewals = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "marblerun",
+		Subsystem: "coordinator",
+		Name:      "certificate_renewals_total",
+		Help:      "Number of renewed marble certificates.",
+	}, []string{"marble_type"})
+	revokedCertificates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "marblerun",
+		Subsystem: "coordinator",
+		Name:      "revoked_certificates_total",
+		Help:      "Number of revoked marble certificates.",
+	}, []string{"marble_type"})
+	coordinatorState = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "marblerun",
+		Subsystem: "coordinator",
+		Name:      "state",
+		Help:      "State of the Coordinator: 0 uninitialized, 1 recovery, 2 accepting manifest, 3 accepting marbles.",
+	})
+)
+
+// activeMarblesDesc describes the number of active marbles, which is collected from the Core when the metrics are scraped.
+var activeMarblesDesc = prometheus.NewDesc("marblerun_coordinator_active_marbles",
+	"Number of activated marbles that have sent a heartbeat recently.", []string{"marble_type", "infrastructure"}, nil)
+
+// activeMarblesCollector collects the number of active marbles of each type per infrastructure.
+type activeMarblesCollector struct {
+	core *Core
+}
+
+// MetricsCollector returns the collector of the metrics that are derived from the state of the Core. It must be registered to export them.
+func (c *Core) MetricsCollector() prometheus.Collector {
+	return activeMarblesCollector{core: c}
+}
+
+func (c activeMarblesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- activeMarblesDesc
+}
+
+func (c activeMarblesCollector) Collect(ch chan<- prometheus.Metric) {
+	for labels, count := range c.core.countActiveMarbles() {
+		ch <- prometheus.MustNewConstMetric(activeMarblesDesc, prometheus.GaugeValue, float64(count), labels[0], labels[1])
+	}
+}
+
+// countActiveMarbles returns the number of marbles that have been seen within the activeMarbleTimeout by their types and infrastructures.
+func (c *Core) countActiveMarbles() map[[2]string]int {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	counts := make(map[[2]string]int)
+	now := time.Now()
+	for _, marble := range c.activeMarbles {
+		if now.Sub(marble.LastSeen) <= activeMarbleTimeout {
+			counts[[2]string{marble.MarbleType, marble.Infrastructure}]++
+		}
+	}
+	return counts
+}
+
+// observePhase observes the duration of a phase of an activation of a marble of the type that has started at start.
+func observePhase(marbleType string, phase string, start time.Time) {
+	activationPhaseDuration.WithLabelValues(marbleType, phase).Observe(time.Since(start).Seconds())
+}
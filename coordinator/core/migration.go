@@ -0,0 +1,96 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+)
+
+// stateVersion is the version of the format of the sealedState.
+//
+// Increase it and append a migration to stateMigrations whenever a change of the sealedState struct would break the unmarshaling
+// or the meaning of states sealed by older releases, e.g., if a field is renamed, changes its type or must be derived from other fields.
+const stateVersion = 1
+
+// ErrStateVersion occurs if the state has been sealed by a newer release of the Coordinator, whose format this release doesn't know.
+var ErrStateVersion = errors.New("the state has been sealed by a newer version of the Coordinator")
+
+// stateMigration migrates a sealed state to the next version.
+//
+// The state is passed as JSON object, so that a migration keeps working when the sealedState struct changes later on.
+type stateMigration func(state map[string]json.RawMessage) error
+
+// stateMigrations contains the migration from each version of the sealed state to the next one, i.e., stateMigrations[0] migrates from version 0 to 1.
+// States sealed before the state has been versioned have version 0.
+var stateMigrations = []stateMigration{
+	migrateManifestHistory,
+}
+
+// migrateState migrates the sealed state to stateVersion and returns it together with the version it has been sealed with.
+func migrateState(stateRaw []byte) ([]byte, int, error) {
+	var state map[string]json.RawMessage
+	if err := json.Unmarshal(stateRaw, &state); err != nil {
+		return nil, 0, err
+	}
+	var version int
+	if rawVersion, ok := state["Version"]; ok {
+		if err := json.Unmarshal(rawVersion, &version); err != nil {
+			return nil, 0, fmt.Errorf("invalid state version: %v", err)
+		}
+	}
+	if version > stateVersion {
+		return nil, version, fmt.Errorf("%w: the state has version %v, but this Coordinator supports up to version %v", ErrStateVersion, version, stateVersion)
+	}
+	if version == stateVersion {
+		return stateRaw, version, nil
+	}
+
+	for v := version; v < stateVersion; v++ {
+		if err := stateMigrations[v](state); err != nil {
+			return nil, version, fmt.Errorf("migrating the state from version %v to %v: %v", v, v+1, err)
+		}
+	}
+	rawVersion, err := json.Marshal(stateVersion)
+	if err != nil {
+		return nil, version, err
+	}
+	state["Version"] = rawVersion
+	migrated, err := json.Marshal(state)
+	if err != nil {
+		return nil, version, err
+	}
+	return migrated, version, nil
+}
+
+// migrateManifestHistory reconstructs the manifest history from the audit log for states that have been sealed before the history was kept.
+func migrateManifestHistory(state map[string]json.RawMessage) error {
+	var history []clientapi.ManifestVersion
+	if rawHistory, ok := state["ManifestHistory"]; ok {
+		if err := json.Unmarshal(rawHistory, &history); err != nil {
+			return err
+		}
+	}
+	if len(history) > 0 {
+		return nil
+	}
+	var auditLog []clientapi.AuditEntry
+	if rawAuditLog, ok := state["AuditLog"]; ok {
+		if err := json.Unmarshal(rawAuditLog, &auditLog); err != nil {
+			return err
+		}
+	}
+	rawHistory, err := json.Marshal(manifestHistoryFromAuditLog(auditLog))
+	if err != nil {
+		return err
+	}
+	state["ManifestHistory"] = rawHistory
+	return nil
+}
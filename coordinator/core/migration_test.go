@@ -0,0 +1,68 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/edgelesssys/marblerun/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateState(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// each version has a migration from its predecessor
+	assert.Len(stateMigrations, stateVersion)
+
+	c, _ := mustSetup()
+	_, err := c.SetManifest(context.TODO(), []byte(test.ManifestJSON), nil)
+	require.NoError(err)
+	history := c.manifestHistory
+	require.Len(history, 1)
+	sealer := c.sealer.(*MockSealer)
+
+	var state map[string]json.RawMessage
+	require.NoError(json.Unmarshal(sealer.data, &state))
+	assert.Equal(json.RawMessage(strconv.Itoa(stateVersion)), state["Version"])
+
+	// a state of the current version is loaded as is
+	migrated, version, err := migrateState(sealer.data)
+	require.NoError(err)
+	assert.Equal(stateVersion, version)
+	assert.Equal(sealer.data, migrated)
+
+	// states sealed before the format has been versioned are migrated when they are loaded
+	delete(state, "Version")
+	delete(state, "ManifestHistory")
+	sealer.data, err = json.Marshal(state)
+	require.NoError(err)
+	c2, err := NewCore([]string{"localhost"}, c.qv, c.qi, sealer, nil, false, c.zaplogger)
+	require.NoError(err)
+	assert.Equal(stateAcceptingMarbles, c2.state)
+	assert.Equal(history, c2.manifestHistory)
+
+	// the migrated state is sealed in the current format
+	_, err = c2.sealState()
+	require.NoError(err)
+	_, version, err = migrateState(sealer.data)
+	require.NoError(err)
+	assert.Equal(stateVersion, version)
+
+	// states of newer Coordinators are rejected instead of being misinterpreted
+	state["Version"] = json.RawMessage(strconv.Itoa(stateVersion + 1))
+	sealer.data, err = json.Marshal(state)
+	require.NoError(err)
+	_, err = NewCore([]string{"localhost"}, c.qv, c.qi, sealer, nil, false, c.zaplogger)
+	assert.True(errors.Is(err, ErrStateVersion))
+}
@@ -0,0 +1,81 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Events that are reported to the notifiers.
+const (
+	// NotificationActivationFailures is reported when activations of a marble type fail repeatedly
+	NotificationActivationFailures = "ActivationFailures"
+	// NotificationManifestChanged is reported when the manifest is set or updated
+	NotificationManifestChanged = "ManifestChanged"
+	// NotificationRecoveryMode is reported when the Coordinator starts in recovery mode, e.g., because its sealed state can't be decrypted or has been rolled back
+	NotificationRecoveryMode = "RecoveryMode"
+)
+
+// activationFailureThreshold is the number of consecutive failed activations of a marble type that is reported.
+const activationFailureThreshold = 3
+
+// Notification describes an event that requires the attention of an operator.
+type Notification struct {
+	// Event is one of the Notification* constants
+	Event   string
+	Time    time.Time
+	Message string
+	Details map[string]string `json:",omitempty"`
+}
+
+// Notifier is notified of events that require the attention of an operator, e.g., to alert the on-call rotation
+//
+// Notify is called while the Core is locked and must not block.
+type Notifier interface {
+	Notify(notification Notification)
+}
+
+// AddNotifier registers a notifier for the events from now on.
+//
+// Recovery mode can only be entered on startup, so the notifier is notified immediately if the Core is in recovery mode.
+func (c *Core) AddNotifier(notifier Notifier) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.notifiers = append(c.notifiers, notifier)
+	if c.state == stateRecovery {
+		notifier.Notify(newNotification(NotificationRecoveryMode, "The Coordinator couldn't load its sealed state and is in recovery mode", nil))
+	}
+}
+
+// notify sends the notification to all notifiers. The Core must be locked.
+func (c *Core) notify(notification Notification) {
+	for _, notifier := range c.notifiers {
+		notifier.Notify(notification)
+	}
+}
+
+// recordActivationResult counts the consecutive failed activations of the marble type and notifies if they reach the threshold.
+func (c *Core) recordActivationResult(marbleType string, failed bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if !failed {
+		delete(c.activationFailures, marbleType)
+		return
+	}
+	c.activationFailures[marbleType]++
+	if failures := c.activationFailures[marbleType]; failures == activationFailureThreshold {
+		c.notify(newNotification(NotificationActivationFailures,
+			fmt.Sprintf("%v consecutive activations of marble type %v failed", failures, marbleType),
+			map[string]string{"MarbleType": marbleType, "Failures": strconv.Itoa(int(failures))}))
+	}
+}
+
+func newNotification(event string, message string, details map[string]string) Notification {
+	return Notification{Event: event, Time: time.Now().UTC(), Message: message, Details: details}
+}
@@ -0,0 +1,134 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// previewPlainValue is the value of plain secrets in a preview.
+const previewPlainValue = "preview"
+
+// PreviewParameters returns the parameters that a marble of the type would receive on activation with the manifest, without a Coordinator.
+//
+// The templates are evaluated like on activation, but with a Coordinator that only lives for the preview: its certificates and the generated
+// secrets are created anew, and user-defined secrets and secrets of the secrets backend get fake values of their type. References to the
+// host environment are left as placeholders, see rpc.HostEnvPlaceholder. The values are only meant to debug the templates.
+func PreviewParameters(rawManifest []byte, marbleType string) (*rpc.Parameters, error) {
+	if err := ValidateManifest(rawManifest); err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(rawManifest, &manifest); err != nil {
+		return nil, err
+	}
+	if _, ok := manifest.Marbles[marbleType]; !ok {
+		return nil, fmt.Errorf("unknown marble type %q", marbleType)
+	}
+
+	c, err := NewCore([]string{"localhost"}, quote.NewMockValidator(), quote.NewMockIssuer(), &MockSealer{}, nil, true, zap.NewNop())
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	secrets, err := c.generateSecrets(ctx, manifest.Secrets, uuid.Nil)
+	if err != nil {
+		return nil, err
+	}
+	// the fake values are used as if they had been uploaded by a user
+	previewManifestSecrets := make(map[string]Secret, len(manifest.Secrets))
+	for name, secret := range manifest.Secrets {
+		if secret.UserDefined || secret.Backend != nil {
+			secret.UserDefined = true
+			secret.Backend = nil
+			if secrets[name], err = c.previewSecret(secret); err != nil {
+				return nil, fmt.Errorf("secret %s: %v", name, err)
+			}
+		}
+		previewManifestSecrets[name] = secret
+	}
+	manifest.Secrets = previewManifestSecrets
+	c.manifest = manifest
+	c.secrets = secrets
+
+	// the marble's CSR only provides its public key
+	marbleKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: []string{"localhost"}}, marbleKey)
+	if err != nil {
+		return nil, err
+	}
+	marbleUUID := uuid.New()
+	caCert, caPrivk, err := c.getPackageCA(manifest.Marbles[marbleType].Package)
+	if err != nil {
+		return nil, err
+	}
+	authSecrets, err := c.generateMarbleAuthSecrets(csr, marbleType, marbleUUID, caCert, caPrivk)
+	if err != nil {
+		return nil, err
+	}
+	// the secrets of the secrets backend have been replaced by fake values
+	return c.marbleParameters(ctx, marbleType, marbleUUID, authSecrets, nil, c.zaplogger)
+}
+
+// previewSecret returns the secret with a fake value of its type in place of a value that is uploaded by a user or stored in the secrets backend.
+func (c *Core) previewSecret(secret Secret) (Secret, error) {
+	var privKey crypto.PrivateKey
+	var pubKey crypto.PublicKey
+	switch secret.Type {
+	case "plain":
+		return secret.withValue(clientapi.Secret{Private: []byte(previewPlainValue)})
+	case "symmetric-key":
+		size := secret.Size
+		if size == 0 {
+			size = 256
+		}
+		key := make([]byte, size/8)
+		if _, err := rand.Read(key); err != nil {
+			return Secret{}, err
+		}
+		return secret.withValue(clientapi.Secret{Private: key})
+	case "cert-rsa":
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return Secret{}, err
+		}
+		privKey, pubKey = key, &key.PublicKey
+	case "cert-ed25519":
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return Secret{}, err
+		}
+		privKey, pubKey = priv, pub
+	case "cert-ecdsa":
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return Secret{}, err
+		}
+		privKey, pubKey = key, &key.PublicKey
+	default:
+		return Secret{}, fmt.Errorf("unsupported type %q", secret.Type)
+	}
+	return c.generateCertificateForSecret(secret, privKey, pubKey)
+}
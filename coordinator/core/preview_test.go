@@ -0,0 +1,60 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/edgelesssys/ertgolib/marble"
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/edgelesssys/marblerun/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreviewParameters(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	params, err := PreviewParameters([]byte(test.ManifestJSON), "backend_first")
+	require.NoError(err)
+	assert.Equal("foo", params.Files["/tmp/defg.txt"])
+	assert.Equal("true", params.Env["IS_FIRST"])
+	assert.Len(params.Env["SEAL_KEY"], 64)
+	assert.True(strings.HasPrefix(params.Env["TEST_SECRET_CERT"], "-----BEGIN CERTIFICATE-----"))
+	assert.NotEmpty(params.Env[marble.MarbleEnvironmentCertificate])
+	assert.Equal([]string{"--first", "serve"}, params.Argv)
+
+	// user-defined secrets get fake values and host environment variables stay placeholders
+	var manifest Manifest
+	require.NoError(json.Unmarshal([]byte(test.ManifestJSON), &manifest))
+	manifest.Secrets["api_token"] = Secret{Type: "plain", UserDefined: true}
+	backend := manifest.Marbles["backend_other"]
+	backend.Parameters = &rpc.Parameters{
+		Env:     map[string]string{"API_TOKEN": "{{ raw .Secrets.api_token }}", "THREADS": "{{ .HostEnv.OMP_NUM_THREADS }}"},
+		HostEnv: []string{"OMP_NUM_THREADS"},
+	}
+	manifest.Marbles["backend_other"] = backend
+	rawManifest, err := json.Marshal(manifest)
+	require.NoError(err)
+	params, err = PreviewParameters(rawManifest, "backend_other")
+	require.NoError(err)
+	assert.Equal(previewPlainValue, params.Env["API_TOKEN"])
+	assert.Equal(rpc.HostEnvPlaceholder("OMP_NUM_THREADS"), params.Env["THREADS"])
+
+	// template errors are reported
+	backend.Parameters.Argv = []string{"{{ pem .Secrets.api_token }}"}
+	rawManifest, err = json.Marshal(manifest)
+	require.NoError(err)
+	_, err = PreviewParameters(rawManifest, "backend_other")
+	assert.Error(err)
+
+	_, err = PreviewParameters([]byte(test.ManifestJSON), "unknown")
+	assert.Error(err)
+}
@@ -0,0 +1,180 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"context"
+	"errors"
+	"net"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/edgelesssys/marblerun/util"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// ActivationLimits protect the Coordinator against floods of activation requests, whose quotes are expensive to validate.
+type ActivationLimits struct {
+	// ClientRate is the number of activation requests per second that are accepted from a client IP. 0 means unlimited.
+	ClientRate float64
+	// MarbleTypeRate is the number of activation requests per second that are accepted for a marble type. 0 means unlimited.
+	MarbleTypeRate float64
+	// Burst is the number of requests that are accepted at once before the rates apply. Defaults to 1.
+	Burst int
+	// Verifications is the maximum number of quotes that are validated concurrently, the other requests wait for their turn.
+	// Defaults to the number of CPUs.
+	Verifications int
+}
+
+// SetActivationLimits sets the limits of activation requests. It must be called before the marble API is served.
+func (c *Core) SetActivationLimits(limits ActivationLimits) error {
+	if limits.ClientRate < 0 || limits.MarbleTypeRate < 0 || limits.Burst < 0 || limits.Verifications < 0 {
+		return errors.New("activation limits must not be negative")
+	}
+	if limits.Burst == 0 {
+		limits.Burst = 1
+	}
+	if limits.Verifications == 0 {
+		limits.Verifications = runtime.NumCPU()
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.clientLimiter = newRateLimiter(limits.ClientRate, limits.Burst)
+	c.marbleTypeLimiter = newRateLimiter(limits.MarbleTypeRate, limits.Burst)
+	c.verifications = make(chan struct{}, limits.Verifications)
+	return nil
+}
+
+// limitActivation rejects the activation request if the client or the marble type has exceeded its rate.
+//
+// marbleTypeLabel is used instead of the requested type, so that requests for undefined types share a single limit.
+func (c *Core) limitActivation(ctx context.Context, marbleTypeLabel string) error {
+	c.mux.Lock()
+	clientLimiter, marbleTypeLimiter := c.clientLimiter, c.marbleTypeLimiter
+	c.mux.Unlock()
+	if !clientLimiter.allow(clientIP(ctx)) || !marbleTypeLimiter.allow(marbleTypeLabel) {
+		activationsRateLimited.WithLabelValues(marbleTypeLabel).Inc()
+		return status.Error(codes.ResourceExhausted, "too many activation requests")
+	}
+	return nil
+}
+
+// acquireVerification waits until a quote may be validated and returns a function that releases the slot.
+func (c *Core) acquireVerification(ctx context.Context) (func(), error) {
+	c.mux.Lock()
+	verifications := c.verifications
+	c.mux.Unlock()
+
+	quoteVerificationsWaiting.Inc()
+	defer quoteVerificationsWaiting.Dec()
+	select {
+	case verifications <- struct{}{}:
+		return func() { <-verifications }, nil
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, status.Error(codes.DeadlineExceeded, "timed out waiting for quote verification")
+		}
+		return nil, status.Error(codes.Canceled, "canceled while waiting for quote verification")
+	}
+}
+
+// clientIP returns the IP address of the client of the request, or an empty string if it is unknown.
+//
+// The address of a client API request is set by the server, the address of a marble API request is the gRPC peer.
+// IPv4-mapped IPv6 addresses of dual-stack listeners are returned as IPv4 addresses, so that each client has a single address.
+func clientIP(ctx context.Context) string {
+	addr := util.ClientAddr(ctx)
+	if addr == "" {
+		p, ok := peer.FromContext(ctx)
+		if !ok || p.Addr == nil {
+			return ""
+		}
+		addr = p.Addr.String()
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return host
+}
+
+// rateLimiterPruneInterval is the interval in which the buckets of idle keys are removed.
+const rateLimiterPruneInterval = time.Minute
+
+// rateLimiter limits the rate of events per key with a token bucket for each key. A nil rateLimiter allows all events.
+type rateLimiter struct {
+	mux       sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter creates a rateLimiter that allows rate events per second and burst events at once. It returns nil if rate is 0.
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate == 0 {
+		return nil
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*tokenBucket), now: time.Now}
+}
+
+// allow reports whether an event for the key is allowed and takes a token from its bucket if so.
+func (l *rateLimiter) allow(key string) bool {
+	if l == nil {
+		return true
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastPrune) > rateLimiterPruneInterval {
+		l.prune(now)
+	}
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = l.refill(bucket, now)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// refill returns the tokens of the bucket at the given time.
+func (l *rateLimiter) refill(bucket *tokenBucket, now time.Time) float64 {
+	tokens := bucket.tokens + now.Sub(bucket.last).Seconds()*l.rate
+	if tokens > l.burst {
+		return l.burst
+	}
+	return tokens
+}
+
+// prune removes the buckets that are full again, because they are the same as new ones.
+func (l *rateLimiter) prune(now time.Time) {
+	for key, bucket := range l.buckets {
+		if l.refill(bucket, now) >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastPrune = now
+}
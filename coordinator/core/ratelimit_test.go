@@ -0,0 +1,108 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/edgelesssys/marblerun/test"
+	"github.com/edgelesssys/marblerun/util"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestRateLimiter(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	limiter := newRateLimiter(2, 3)
+	limiter.now = func() time.Time { return now }
+
+	// the burst is allowed at once
+	for i := 0; i < 3; i++ {
+		assert.True(limiter.allow("a"))
+	}
+	assert.False(limiter.allow("a"))
+	// other keys have their own bucket
+	assert.True(limiter.allow("b"))
+
+	// the bucket is refilled with the rate
+	now = now.Add(500 * time.Millisecond)
+	assert.True(limiter.allow("a"))
+	assert.False(limiter.allow("a"))
+
+	// full buckets are pruned
+	now = now.Add(2 * rateLimiterPruneInterval)
+	assert.True(limiter.allow("a"))
+	assert.Len(limiter.buckets, 1)
+
+	// a nil limiter allows everything
+	assert.Nil(newRateLimiter(0, 3))
+	var unlimited *rateLimiter
+	assert.True(unlimited.allow("a"))
+}
+
+func TestActivationLimits(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, manifest := mustSetup()
+	_, err := c.SetManifest(context.TODO(), []byte(test.ManifestJSON), nil)
+	require.NoError(err)
+	spawner := marbleSpawner{
+		assert:     assert,
+		require:    require,
+		issuer:     c.qi,
+		validator:  c.qv.(*quote.MockValidator),
+		manifest:   *manifest,
+		coreServer: c,
+	}
+
+	assert.Error(c.SetActivationLimits(ActivationLimits{ClientRate: -1}))
+	require.NoError(c.SetActivationLimits(ActivationLimits{MarbleTypeRate: 0.001, Burst: 2}))
+
+	rateLimited := testutil.ToFloat64(activationsRateLimited.WithLabelValues("frontend"))
+	spawner.newMarble("frontend", "Azure", true)
+	spawner.newMarble("frontend", "Azure", true)
+	_, err = c.Activate(context.TODO(), &rpc.ActivationReq{MarbleType: "frontend"})
+	assert.Equal(codes.ResourceExhausted, status.Code(err))
+	assert.Equal(rateLimited+1, testutil.ToFloat64(activationsRateLimited.WithLabelValues("frontend")))
+	// other marble types are not affected
+	spawner.newMarble("backend_first", "Azure", true)
+
+	// requests wait for a free verification slot until they are canceled
+	require.NoError(c.SetActivationLimits(ActivationLimits{Verifications: 1}))
+	release, err := c.acquireVerification(context.TODO())
+	require.NoError(err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = c.acquireVerification(ctx)
+	assert.Equal(codes.DeadlineExceeded, status.Code(err))
+	release()
+	spawner.newMarble("frontend", "Azure", true)
+}
+
+func TestClientIP(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Empty(clientIP(context.Background()))
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("::ffff:192.0.2.1"), Port: 1234}})
+	assert.Equal("192.0.2.1", clientIP(ctx))
+	ctx = peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1234}})
+	assert.Equal("2001:db8::1", clientIP(ctx))
+	// the address of a client API request is preferred
+	assert.Equal("192.0.2.2", clientIP(util.WithClientAddr(ctx, "192.0.2.2:4321")))
+}
@@ -0,0 +1,172 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// nonceTTL is the time within which a marble must activate with a nonce
+	nonceTTL = 5 * time.Minute
+	// maxNonces bounds the number of unused nonces, so that marbles can't exhaust the Coordinator's memory
+	maxNonces = 10000
+	// maxSeenQuotes bounds the replay cache. The oldest quotes are dropped first.
+	maxSeenQuotes = 10000
+	nonceSize     = 32
+)
+
+// issuedNonce is a nonce that has been issued to the marble with the TLS certificate of certHash.
+type issuedNonce struct {
+	certHash [sha256.Size]byte
+	expiry   time.Time
+}
+
+// nonceStore holds the nonces that have been issued and not been used yet.
+// It has its own lock, so that requesting nonces doesn't contend with activations.
+type nonceStore struct {
+	mux    sync.Mutex
+	nonces map[string]issuedNonce
+	now    func() time.Time
+}
+
+func newNonceStore() *nonceStore {
+	return &nonceStore{nonces: make(map[string]issuedNonce), now: time.Now}
+}
+
+// issue returns a new nonce for the marble with the TLS certificate.
+func (s *nonceStore) issue(tlsCert *x509.Certificate) ([]byte, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	now := s.now()
+	for key, issued := range s.nonces {
+		if !now.Before(issued.expiry) {
+			delete(s.nonces, key)
+		}
+	}
+	if len(s.nonces) >= maxNonces {
+		return nil, status.Error(codes.ResourceExhausted, "too many pending nonces")
+	}
+	s.nonces[string(nonce)] = issuedNonce{certHash: sha256.Sum256(tlsCert.Raw), expiry: now.Add(nonceTTL)}
+	return nonce, nil
+}
+
+// consume removes the nonce and reports whether it has been issued to the marble with the TLS certificate and is still valid.
+func (s *nonceStore) consume(nonce []byte, tlsCert *x509.Certificate) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	issued, ok := s.nonces[string(nonce)]
+	if !ok {
+		return false
+	}
+	delete(s.nonces, string(nonce))
+	certHash := sha256.Sum256(tlsCert.Raw)
+	return s.now().Before(issued.expiry) && bytes.Equal(issued.certHash[:], certHash[:])
+}
+
+// Nonce implements the MarbleAPI function to get a nonce for an activation (implements the MarbleServer interface)
+//
+// The nonce is bound to the marble's TLS certificate and may be used for a single activation within nonceTTL.
+func (c *Core) Nonce(ctx context.Context, req *rpc.NonceReq) (*rpc.NonceResp, error) {
+	tlsCert := getClientTLSCert(ctx)
+	if tlsCert == nil {
+		return nil, status.Error(codes.Unauthenticated, "couldn't get marble TLS certificate")
+	}
+	c.mux.Lock()
+	var err error
+	if c.state != stateAcceptingMarbles {
+		err = errNotAcceptingMarbles(c.state)
+	} else if c.handedOff {
+		err = errHandedOff()
+	} else if c.maintenance != nil {
+		err = c.maintenance.errMaintenance()
+	}
+	c.mux.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := c.nonces.issue(tlsCert)
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		return nil, status.Error(codes.Internal, "failed to generate nonce")
+	}
+	return &rpc.NonceResp{Nonce: nonce}, nil
+}
+
+// quoteMessage returns the message the marble's quote must have been issued over.
+//
+// Marbles that activate with a nonce issue their quote over the nonce and the public key of their CSR, the others over their TLS certificate.
+func (c *Core) quoteMessage(tlsCert *x509.Certificate, req *rpc.ActivationReq) ([]byte, error) {
+	if len(req.GetNonce()) == 0 {
+		return tlsCert.Raw, nil
+	}
+	if !c.nonces.consume(req.GetNonce(), tlsCert) {
+		return nil, status.Error(codes.Unauthenticated, "unknown or expired nonce")
+	}
+	message, err := rpc.QuoteMessage(req.GetNonce(), req.GetCSR())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid CSR")
+	}
+	return message, nil
+}
+
+// quoteHash identifies a quote in the replay cache.
+func quoteHash(marbleQuote []byte) string {
+	hash := sha256.Sum256(marbleQuote)
+	return hex.EncodeToString(hash[:])
+}
+
+// checkReplay rejects quotes that have already been used for an activation.
+//
+// Needs to be called with the lock held. Quotes aren't checked in simulation mode, where they aren't validated either.
+func (c *Core) checkReplay(marbleQuote []byte) error {
+	if len(marbleQuote) == 0 || c.inSimulationMode() {
+		return nil
+	}
+	hash := quoteHash(marbleQuote)
+	for _, seen := range c.seenQuotes {
+		if seen == hash {
+			return status.Error(codes.Unauthenticated, "quote has already been used")
+		}
+	}
+	return nil
+}
+
+// recordQuote adds the quote to the replay cache and returns a function that undoes it.
+//
+// Needs to be called with the lock held.
+func (c *Core) recordQuote(marbleQuote []byte) func() {
+	oldSeenQuotes := c.seenQuotes
+	if len(marbleQuote) == 0 || c.inSimulationMode() {
+		return func() {}
+	}
+	seenQuotes := append([]string{}, c.seenQuotes...)
+	if len(seenQuotes) >= maxSeenQuotes {
+		seenQuotes = seenQuotes[len(seenQuotes)-maxSeenQuotes+1:]
+	}
+	c.seenQuotes = append(seenQuotes, quoteHash(marbleQuote))
+	return func() { c.seenQuotes = oldSeenQuotes }
+}
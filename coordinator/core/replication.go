@@ -0,0 +1,96 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"bytes"
+	"errors"
+
+	"go.uber.org/zap"
+)
+
+// ErrStateConflict occurs if the state has been changed by another Coordinator of the cluster concurrently.
+// The operation can be retried once the Core has received the new state.
+var ErrStateConflict = errors.New("the state has been changed by another Coordinator, retry the operation")
+
+// Replicator replicates the state of the Core to the other Coordinators of a cluster
+//
+// Replicate commits state, which has been derived from the replicated state with index baseIndex, and returns the index of the new state.
+// It returns ErrStateConflict if another state has been committed after baseIndex.
+// The other Coordinators receive the committed state with ApplyReplicatedState.
+type Replicator interface {
+	Replicate(state []byte, baseIndex uint64) (index uint64, err error)
+}
+
+// SetReplicator makes the Core replicate each change of its state before it is sealed.
+func (c *Core) SetReplicator(replicator Replicator) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.replicator = replicator
+}
+
+// ErrRecoveryMode occurs if a replicated state is applied to a Core in recovery mode.
+// The Core applies the state of the cluster once it has been recovered with the recovery key.
+var ErrRecoveryMode = errors.New("the Coordinator is in recovery mode, recover it before it can apply the state of the cluster")
+
+// ApplyReplicatedState sets the Core to a state that has been committed by the cluster and seals it.
+//
+// States that the Core is already based on are ignored. A Core in recovery mode refuses the state of the cluster, so that
+// peers can't bypass the recovery, and returns ErrRecoveryMode.
+// Marbles that watch their parameters on this Coordinator receive the changes of the manifest and the secrets.
+func (c *Core) ApplyReplicatedState(stateRaw []byte, index uint64) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.state == stateRecovery {
+		return ErrRecoveryMode
+	}
+	if index <= c.stateIndex {
+		return nil
+	}
+	c.zaplogger.Info("applying replicated state", zap.Uint64("index", index))
+
+	oldRawManifest, oldUpdates := c.rawManifest, len(c.rawUpdates)
+	oldSecrets, oldVersions := c.secrets, c.secretVersions
+	// the rollback counter is local to each Coordinator
+	counterValue := c.counterValue
+	cert, privk, err := c.applyState(stateRaw)
+	c.counterValue = counterValue
+	if err != nil {
+		return err
+	}
+	// a pending update is based on the previous manifest
+	manifestChanged := !bytes.Equal(c.rawManifest, oldRawManifest) || len(c.rawUpdates) != oldUpdates
+	if manifestChanged {
+		c.pendingUpdate = nil
+	}
+	c.stateIndex = index
+	if c.cert == nil || !bytes.Equal(c.cert.Raw, cert.Raw) {
+		c.cert = cert
+		c.privk = privk
+		c.quote = c.generateQuote()
+		if c.tlsCert, err = c.generateTLSCertificate(); err != nil {
+			return err
+		}
+	}
+
+	// the state has been committed already, it only needs to be persisted locally
+	replicator := c.replicator
+	c.replicator = nil
+	_, err = c.sealState()
+	c.replicator = replicator
+	if err != nil {
+		return err
+	}
+
+	// the marbles watching this Coordinator receive the changes made on other Coordinators
+	if manifestChanged {
+		c.notifyParameterWatchers(nil)
+	} else if names := changedSecrets(oldSecrets, oldVersions, c.secrets, c.secretVersions); len(names) > 0 {
+		c.notifyParameterWatchers(c.marbleTypesReferencing(names))
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+	"go.uber.org/zap"
+)
+
+// ErrUnknownPackage occurs if a CRL is requested for a package whose CA hasn't issued any certificates.
+var ErrUnknownPackage = errors.New("no marble certificates have been issued for the package")
+
+// crlValidity is the time until the next update of a CRL. CRLs are created on request, so it only bounds how long clients may cache them.
+const crlValidity = time.Hour
+
+// marbleCert records a certificate that has been issued to a marble, so that it can be revoked.
+type marbleCert struct {
+	Serial     *big.Int
+	MarbleType string
+	UUID       string
+	Package    string
+	NotAfter   time.Time
+	// SecurityVersion is the SecurityVersion of the package the marble has been attested with, if any
+	SecurityVersion *uint `json:",omitempty"`
+	// RevokedAt is the time the certificate has been revoked, or zero
+	RevokedAt time.Time
+}
+
+func (m marbleCert) revoked() bool {
+	return !m.RevokedAt.IsZero()
+}
+
+// SetCRLURL sets the URL of the Coordinator's CRL endpoint, which is embedded in the marble certificates that are issued from now on.
+//
+// The package of the certificate is appended as query parameter.
+func (c *Core) SetCRLURL(crlURL string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.crlURL = crlURL
+}
+
+// RevokeMarble revokes the certificates of a marble or of all marbles of a type
+//
+// clientCert is the TLS certificate of the client, which must belong to one of the manifest's Users who is permitted to revoke marbles.
+// All certificates of the marble with the given UUID are revoked, or, if marbleUUID is empty, the certificates of all marbles of the given type.
+// Revoked marbles can neither renew their certificates nor activate again with the same UUID, but new marbles of the type can still be activated.
+// Returns the number of revoked certificates.
+func (c *Core) RevokeMarble(ctx context.Context, marbleType string, marbleUUID string, clientCert *x509.Certificate) (int, error) {
+	defer c.mux.Unlock()
+	if err := c.requireState(stateAcceptingMarbles); err != nil {
+		return 0, err
+	}
+	user, ok := c.manifest.getUser(clientCert)
+	if !ok || !c.manifest.isPermitted(user, resourceMarbles, "", actionRevokeMarble) {
+		return 0, ErrNotAuthorized
+	}
+	if marbleType == "" && marbleUUID == "" {
+		return 0, errors.New("neither a marble type nor a UUID is given")
+	}
+
+	now := time.Now().UTC()
+	oldMarbleCerts := c.marbleCerts
+	c.marbleCerts = make([]marbleCert, len(oldMarbleCerts))
+	revoked := 0
+	revokedTypes := make(map[string]int)
+	for i, cert := range oldMarbleCerts {
+		if !cert.revoked() && (marbleType == "" || cert.MarbleType == marbleType) && (marbleUUID == "" || cert.UUID == marbleUUID) {
+			cert.RevokedAt = now
+			revoked++
+			revokedTypes[cert.MarbleType]++
+		}
+		c.marbleCerts[i] = cert
+	}
+	if revoked == 0 {
+		c.marbleCerts = oldMarbleCerts
+		return 0, errors.New("no certificates to revoke")
+	}
+
+	oldAuditLog := c.auditLog
+	details := map[string]string{"Certificates": strconv.Itoa(revoked)}
+	if marbleType != "" {
+		details["MarbleType"] = marbleType
+	}
+	if marbleUUID != "" {
+		details["UUID"] = marbleUUID
+	}
+	c.appendAuditEntry(ctx, clientapi.AuditEventRevokeMarble, user, details)
+	if _, err := c.sealState(); err != nil {
+		c.marbleCerts = oldMarbleCerts
+		c.auditLog = oldAuditLog
+		c.zaplogger.Error("sealState failed", zap.Error(err))
+		return 0, err
+	}
+
+	for revokedType, count := range revokedTypes {
+		revokedCertificates.WithLabelValues(revokedType).Add(float64(count))
+	}
+	c.publishRevocation(marbleType, marbleUUID, revoked)
+	c.requestLogger(ctx).Info("marble certificates revoked", zap.String("user", user), zap.String("MarbleType", marbleType), zap.String("UUID", marbleUUID), zap.Int("Certificates", revoked))
+	return revoked, nil
+}
+
+// GetCRL returns the DER-encoded CRL of the certificates that have been issued by the CA of the package and are revoked.
+//
+// The CRL is signed by the package CA, which issued the certificates. Expired certificates are omitted.
+func (c *Core) GetCRL(ctx context.Context, pkg string) ([]byte, error) {
+	defer c.mux.Unlock()
+	if err := c.requireState(stateAcceptingMarbles); err != nil {
+		return nil, err
+	}
+	// the package CA must not be created here, as it wouldn't be sealed
+	if _, ok := c.packageCAs[pkg]; !ok {
+		return nil, ErrUnknownPackage
+	}
+	caCert, caPrivk, err := c.getPackageCA(pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var revoked []pkix.RevokedCertificate
+	for _, cert := range c.marbleCerts {
+		if cert.Package == pkg && cert.revoked() && now.Before(cert.NotAfter) {
+			revoked = append(revoked, pkix.RevokedCertificate{SerialNumber: cert.Serial, RevocationTime: cert.RevokedAt})
+		}
+	}
+	return caCert.CreateCRL(rand.Reader, caPrivk, revoked, now, now.Add(crlValidity))
+}
+
+// recordMarbleCert records a certificate that has been issued to a marble. It is persisted with the next sealing of the state.
+func (c *Core) recordMarbleCert(cert *x509.Certificate, marbleType string, pkg string, securityVersion *uint) {
+	c.marbleCerts = append(c.marbleCerts, marbleCert{
+		Serial:          cert.SerialNumber,
+		MarbleType:      marbleType,
+		UUID:            cert.Subject.CommonName,
+		Package:         pkg,
+		NotAfter:        cert.NotAfter,
+		SecurityVersion: securityVersion,
+	})
+}
+
+// findMarbleCert returns the record of the marble certificate with the given serial number.
+func (c *Core) findMarbleCert(serial *big.Int) (marbleCert, bool) {
+	for _, cert := range c.marbleCerts {
+		if cert.Serial.Cmp(serial) == 0 {
+			return cert, true
+		}
+	}
+	return marbleCert{}, false
+}
+
+// isRevokedMarble returns true if a certificate of the marble with the UUID has been revoked.
+func (c *Core) isRevokedMarble(marbleUUID string) bool {
+	for _, cert := range c.marbleCerts {
+		if cert.UUID == marbleUUID && cert.revoked() {
+			return true
+		}
+	}
+	return false
+}
+
+// crlDistributionPoints returns the URL of the CRL of the package, if the CRL URL has been set.
+func (c *Core) crlDistributionPoints(pkg string) []string {
+	if c.crlURL == "" {
+		return nil
+	}
+	return []string{c.crlURL + "?" + url.Values{"package": {pkg}}.Encode()}
+}
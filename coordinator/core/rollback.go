@@ -0,0 +1,114 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// ErrRollback occurs if the sealed state is older than the latest state the Coordinator has sealed.
+var ErrRollback = errors.New("the sealed state has been rolled back")
+
+// MonotonicCounter is a counter that can't be decreased, which protects the sealed state against rollback
+//
+// Increment increases the counter by one and returns its new value. The counter must be kept outside of the control of
+// whoever can replace the sealed state, e.g., in trusted hardware or a separately administered service.
+type MonotonicCounter interface {
+	Value() (uint64, error)
+	Increment() (uint64, error)
+}
+
+// SetMonotonicCounter binds the sealed state to counter.
+//
+// Each sealed state contains the value the counter has after the state has been sealed. If the loaded state contains a smaller
+// value than the counter, it has been replaced with an older state. The state is discarded and the Coordinator enters recovery mode,
+// where the rollback can be accepted with AcceptRollback. A state that can't be decrypted yet is checked once it has been recovered.
+func (c *Core) SetMonotonicCounter(counter MonotonicCounter) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.counter = counter
+	switch c.state {
+	case stateRecovery:
+		return nil
+	case stateAcceptingManifest:
+		// there is no sealed state yet
+		return c.syncCounter()
+	}
+	err := c.checkCounter(false)
+	if !errors.Is(err, ErrRollback) {
+		return err
+	}
+	return c.discardRolledBackState()
+}
+
+// checkCounter compares the value of the counter with the value the loaded state has been sealed with.
+//
+// An older state is only accepted if acceptRollback is set, i.e., if an authorized client accepts the rollback.
+func (c *Core) checkCounter(acceptRollback bool) error {
+	value, err := c.counter.Value()
+	if err != nil {
+		return err
+	}
+	if c.counterValue < value {
+		if !acceptRollback {
+			c.zaplogger.Error("The sealed state is older than the latest state. It may have been replaced by an attacker. Restore the latest state or accept the rollback explicitly.",
+				zap.Uint64("StateCounter", c.counterValue), zap.Uint64("Counter", value))
+			return fmt.Errorf("%w: the state has counter %v, but the counter is at %v", ErrRollback, c.counterValue, value)
+		}
+		c.zaplogger.Warn("accepting a rolled back state", zap.Uint64("StateCounter", c.counterValue), zap.Uint64("Counter", value))
+		c.counterValue = value
+		return nil
+	}
+	// the Coordinator stopped after sealing the state, but before incrementing the counter
+	for value < c.counterValue {
+		if value, err = c.counter.Increment(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// discardRolledBackState discards a loaded state that is older than the counter, so that the Coordinator starts in recovery mode.
+//
+// Like a state that can't be decrypted, the rolled back state can be recovered with its recovery key, and it isn't used until then.
+// The root certificate of the state is replaced, so that the Coordinator can't be mistaken for the one of the rolled back state.
+func (c *Core) discardRolledBackState() error {
+	c.discardRecoveredState()
+	cert, privk, err := c.newRootCert(c.dnsNames)
+	if err != nil {
+		return err
+	}
+	c.cert = cert
+	c.privk = privk
+	c.quote = c.generateQuote()
+	c.tlsCert, err = c.generateTLSCertificate()
+	return err
+}
+
+// syncCounter binds the next sealed state to the current value of the counter.
+func (c *Core) syncCounter() error {
+	value, err := c.counter.Value()
+	if err != nil {
+		return err
+	}
+	c.counterValue = value
+	return nil
+}
+
+// incrementCounter is called after a state that contains the next value of the counter has been sealed.
+func (c *Core) incrementCounter() {
+	value, err := c.counter.Increment()
+	if err != nil {
+		// the sealed state is still accepted, the counter catches up with the next state or when the Coordinator restarts
+		c.zaplogger.Error("failed to increment the rollback counter", zap.Error(err))
+		return
+	}
+	c.counterValue = value
+}
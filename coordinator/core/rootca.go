@@ -0,0 +1,187 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"go.uber.org/zap"
+)
+
+// SetRootCA replaces the Coordinator's self-generated root certificate with a CA certificate and key that are provided by the operator,
+// e.g., an intermediate CA issued by a corporate CA, so that the marble certificates chain into an existing PKI.
+//
+// certChain contains the PEM-encoded CA certificate, optionally followed by the certificates that issued it up to, but not necessarily including, the root.
+// key is the PEM-encoded ECDSA private key of the CA certificate.
+//
+// The root CA can only be set before the manifest. It is sealed together with the manifest, i.e., it is lost if the Coordinator restarts before the manifest has been set.
+// The request is not authenticated, because there are no users yet, so the manifest must pin the CA in its RootCA: a manifest is only accepted
+// if it pins the CA that has been set, and a CA that the manifest doesn't pin prevents the manifest from being set.
+// Note that whoever holds the key can issue certificates that are indistinguishable from the Coordinator's.
+func (c *Core) SetRootCA(ctx context.Context, certChain []byte, key []byte) error {
+	defer c.mux.Unlock()
+	if err := c.requireState(stateAcceptingManifest); err != nil {
+		return err
+	}
+	if c.rootKeys != nil {
+		return errors.New("the root key is held by a key store and cannot be replaced")
+	}
+
+	chain, err := quote.ParsePEMCertificates(certChain)
+	if err != nil {
+		return fmt.Errorf("invalid certificate chain: %v", err)
+	}
+	if len(chain) == 0 {
+		return errors.New("no PEM-encoded certificate found")
+	}
+	privk, err := parseRootCAKey(key)
+	if err != nil {
+		return err
+	}
+	if err := checkRootCA(chain, privk); err != nil {
+		return err
+	}
+
+	oldCert, oldPrivk, oldChain, oldQuote := c.cert, c.privk, c.rootChain, c.quote
+	c.cert = chain[0]
+	c.privk = privk
+	c.rootChain = chain[1:]
+	c.quote = c.generateQuote()
+	tlsCert, err := c.generateTLSCertificate()
+	if err != nil {
+		c.cert, c.privk, c.rootChain, c.quote = oldCert, oldPrivk, oldChain, oldQuote
+		return err
+	}
+	c.tlsCert = tlsCert
+	c.providedRootCA = true
+
+	c.requestLogger(ctx).Info("root CA set", zap.String("Subject", c.cert.Subject.String()), zap.String("Issuer", c.cert.Issuer.String()))
+	return nil
+}
+
+// checkPinnedRootCA verifies that the root CA pinned by a manifest is the one that has been provided, and that no root CA has been provided if the manifest doesn't pin one.
+func (c *Core) checkPinnedRootCA(pinnedPEM string) error {
+	if pinnedPEM == "" {
+		if c.providedRootCA {
+			return errors.New("a root CA has been provided, but the manifest doesn't pin it in its RootCA")
+		}
+		return nil
+	}
+	if !c.providedRootCA {
+		return errors.New("the manifest pins a RootCA, but no root CA has been provided")
+	}
+	pinned, err := quote.ParsePEMCertificates([]byte(pinnedPEM))
+	if err != nil || len(pinned) == 0 {
+		return errors.New("the RootCA of the manifest is not a PEM-encoded certificate")
+	}
+	if !bytes.Equal(pinned[0].Raw, c.cert.Raw) {
+		return errors.New("the root CA that has been provided is not the one pinned by the manifest")
+	}
+	return nil
+}
+
+// parseRootCAKey parses a PEM-encoded ECDSA private key in SEC 1 or PKCS #8 format.
+func parseRootCAKey(data []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM-encoded private key found")
+	}
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		privk, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("the private key of the root CA must be an ECDSA key")
+		}
+		return privk, nil
+	}
+	return nil, fmt.Errorf("unsupported private key type: %v", block.Type)
+}
+
+// checkRootCA verifies that the first certificate of chain can serve as the Coordinator's root CA with privk and that it is issued by the rest of the chain.
+func checkRootCA(chain []*x509.Certificate, privk *ecdsa.PrivateKey) error {
+	cert := chain[0]
+	if !cert.BasicConstraintsValid || !cert.IsCA {
+		return errors.New("the certificate is not a CA certificate")
+	}
+	if cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return errors.New("the certificate is not allowed to sign certificates")
+	}
+	// the Coordinator issues an intermediate CA for each package
+	if cert.MaxPathLen == 0 && cert.MaxPathLenZero {
+		return errors.New("the certificate's path length constraint doesn't allow the package CAs")
+	}
+	if now := time.Now(); now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return errors.New("the certificate is not valid at this time")
+	}
+
+	certPub, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return err
+	}
+	keyPub, err := x509.MarshalPKIXPublicKey(&privk.PublicKey)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(certPub, keyPub) {
+		return errors.New("the private key doesn't match the certificate")
+	}
+
+	for i := 1; i < len(chain); i++ {
+		if err := chain[i-1].CheckSignatureFrom(chain[i]); err != nil {
+			return fmt.Errorf("certificate %d of the chain is not issued by its successor: %v", i-1, err)
+		}
+	}
+	return nil
+}
+
+// issuerChainPEM returns the PEM-encoded root certificate followed by the certificates that issued it, if the root CA has been provided by the operator.
+//
+// Returns an empty string for a self-generated root certificate, which is trusted as is.
+func (c *Core) issuerChainPEM() string {
+	if len(c.rootChain) == 0 {
+		return ""
+	}
+	var chain []byte
+	for _, cert := range append([]*x509.Certificate{c.cert}, c.rootChain...) {
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return string(chain)
+}
+
+// CACertificates returns the PEM-encoded root certificate, followed by the certificates that issued it if the root CA has been provided by the operator,
+// and the PEM-encoded intermediate CA of each package that has issued marble certificates.
+//
+// The certificates are only returned once the manifest has been set, because the root certificate may be replaced before.
+func (c *Core) CACertificates() (string, map[string]string, error) {
+	defer c.mux.Unlock()
+	if err := c.requireState(stateAcceptingMarbles); err != nil {
+		return "", nil, err
+	}
+	root := c.issuerChainPEM()
+	if root == "" {
+		root = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}))
+	}
+	intermediates := make(map[string]string, len(c.packageCAs))
+	for pkg, ca := range c.packageCAs {
+		intermediates[pkg] = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.RawCert}))
+	}
+	return root, intermediates, nil
+}
@@ -0,0 +1,80 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// RootKeyStore holds the root key of the Coordinator outside of the Coordinator, e.g., in an HSM
+//
+// GenerateKey creates a new ECDSA P-256 key and returns its ID, GetKey returns the key with the ID.
+// The private key never leaves the store, the returned signers delegate the signing operations to it.
+type RootKeyStore interface {
+	GenerateKey() (id string, key crypto.Signer, err error)
+	GetKey(id string) (crypto.Signer, error)
+}
+
+// externalRootKey is a root key held by a RootKeyStore.
+// Secrets can't be derived from a key that doesn't leave the store, so it comes with a sealed derivation key.
+type externalRootKey struct {
+	crypto.Signer
+	id            string
+	derivationKey []byte
+}
+
+// generateRootKey creates the root key, in the RootKeyStore if there is one.
+func (c *Core) generateRootKey() (crypto.Signer, error) {
+	if c.rootKeys == nil {
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	id, signer, err := c.rootKeys.GenerateKey()
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate the root key in the key store: %v", err)
+	}
+	derivationKey := make([]byte, 32)
+	if _, err := rand.Read(derivationKey); err != nil {
+		return nil, err
+	}
+	return &externalRootKey{Signer: signer, id: id, derivationKey: derivationKey}, nil
+}
+
+// getRootKey returns the root key held by the RootKeyStore with the sealed id and derivation key.
+//
+// The key must match the root certificate, so that a key store that is not trusted, e.g., one on the host, can't substitute it.
+func (c *Core) getRootKey(id string, derivationKey []byte, cert *x509.Certificate) (crypto.Signer, error) {
+	if c.rootKeys == nil {
+		return nil, errors.New("the root key of the sealed state is held by a key store, but no key store is configured")
+	}
+	signer, err := c.rootKeys.GetKey(id)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get the root key from the key store: %v", err)
+	}
+	rawPub, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(rawPub, cert.RawSubjectPublicKeyInfo) {
+		return nil, errors.New("the root key of the key store doesn't match the root certificate")
+	}
+	return &externalRootKey{Signer: signer, id: id, derivationKey: derivationKey}, nil
+}
+
+// derivationKey returns the key from which the Coordinator derives the secrets that are bound to a marble.
+func (c *Core) derivationKey() []byte {
+	if key, ok := c.privk.(*externalRootKey); ok {
+		return key.derivationKey
+	}
+	return c.privk.(*ecdsa.PrivateKey).D.Bytes()
+}
@@ -9,67 +9,79 @@ package core
 import (
 	"crypto/rand"
 	"errors"
-	"io/ioutil"
-	"os"
-	"path/filepath"
 	"time"
 
 	"github.com/edgelesssys/ertgolib/ertcrypto"
+	"github.com/edgelesssys/marblerun/coordinator/core/store"
 )
 
-// SealedDataFname contains the file name in which the state is sealed on disk in seal_dir
+// SealedDataFname contains the key under which the state is sealed in the store, i.e., the file name in seal_dir
 const SealedDataFname string = "sealed_data"
 
-// SealedKeyFname contains the file name in which the key is sealed with the seal key on disk in seal_dir
+// SealedKeyFname contains the key under which the encryption key is sealed with the seal key in the store
 const SealedKeyFname string = "sealed_key"
 
+// UnencryptedDataFname contains the key under which data that is needed before the state can be decrypted is stored in plaintext in the store
+const UnencryptedDataFname string = "unencrypted_data"
+
 // ErrEncryptionKey occurs if unsealing the encryption key failed.
 var ErrEncryptionKey = errors.New("cannot unseal encryption key")
 
-// Sealer is an interface for the Core object to seal information to the filesystem for persistence
+// Sealer is an interface for the Core object to seal information to a store for persistence
+//
+// Seal stores unencryptedData in plaintext next to the encrypted toBeEncrypted.
+// Unseal returns the unencrypted data even if the encrypted data cannot be decrypted.
 type Sealer interface {
-	Seal(data []byte) ([]byte, error)
-	Unseal() ([]byte, error)
+	Seal(unencryptedData []byte, toBeEncrypted []byte) ([]byte, error)
+	Unseal() (unencryptedData []byte, decryptedData []byte, err error)
 	GenerateNewEncryptionKey() error
 	SetEncryptionKey(key []byte) error
 }
 
 // AESGCMSealer implements the Sealer interface using AES-GCM for confidentiallity and authentication
 type AESGCMSealer struct {
-	sealDir       string
+	store         store.Store
 	encryptionKey []byte
 }
 
-// NewAESGCMSealer creates and initializes a new AESGCMSealer object
-func NewAESGCMSealer(sealDir string) *AESGCMSealer {
-	return &AESGCMSealer{sealDir: sealDir}
+// NewAESGCMSealer creates and initializes a new AESGCMSealer object that seals to store
+func NewAESGCMSealer(store store.Store) *AESGCMSealer {
+	return &AESGCMSealer{store: store}
 }
 
-// Unseal reads and decrypts stored information from the fs
-func (s *AESGCMSealer) Unseal() ([]byte, error) {
-	// load from fs
-	sealedData, err := ioutil.ReadFile(s.getFname(SealedDataFname))
+// Unseal reads and decrypts stored information from the store
+func (s *AESGCMSealer) Unseal() ([]byte, []byte, error) {
+	// load from store
+	sealedData, err := s.store.Get(SealedDataFname)
 
-	if os.IsNotExist(err) {
-		return nil, nil
+	if err == store.ErrNotFound {
+		return nil, nil, nil
 	} else if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	unencryptedData, err := readUnencryptedData(s.store)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// Decrypt generated encryption key with seal key, if needed
 	if err = s.unsealEncryptionKey(); err != nil {
-		return nil, ErrEncryptionKey
+		return unencryptedData, nil, ErrEncryptionKey
 	}
 
 	// Decrypt data with the unsealed encryption key and return it
-	return ertcrypto.Decrypt(sealedData, s.encryptionKey)
+	decryptedData, err := ertcrypto.Decrypt(sealedData, s.encryptionKey)
+	if err != nil {
+		return unencryptedData, nil, err
+	}
+	return unencryptedData, decryptedData, nil
 }
 
-// Seal encrypts and stores information to the fs
-func (s *AESGCMSealer) Seal(data []byte) ([]byte, error) {
+// Seal encrypts and stores information to the store
+func (s *AESGCMSealer) Seal(unencryptedData []byte, toBeEncrypted []byte) ([]byte, error) {
 	// If we don't have an AES key to encrypt the state, generate one
 	if err := s.unsealEncryptionKey(); err != nil {
-		if !os.IsNotExist(err) {
+		if err != store.ErrNotFound {
 			return nil, err
 		}
 		if err := s.GenerateNewEncryptionKey(); err != nil {
@@ -78,21 +90,30 @@ func (s *AESGCMSealer) Seal(data []byte) ([]byte, error) {
 	}
 
 	// Encrypt data to seal with generated encryption key
-	encryptedData, err := ertcrypto.Encrypt(data, s.encryptionKey)
+	encryptedData, err := ertcrypto.Encrypt(toBeEncrypted, s.encryptionKey)
 	if err != nil {
 		return nil, err
 	}
 
-	// store to fs
-	if err := ioutil.WriteFile(s.getFname(SealedDataFname), encryptedData, 0600); err != nil {
+	// store
+	if err := s.store.Put(UnencryptedDataFname, unencryptedData); err != nil {
+		return nil, err
+	}
+	if err := s.store.Put(SealedDataFname, encryptedData); err != nil {
 		return nil, err
 	}
 
 	return s.encryptionKey, nil
 }
 
-func (s *AESGCMSealer) getFname(basename string) string {
-	return filepath.Join(s.sealDir, basename)
+// readUnencryptedData reads the plaintext data stored next to the sealed data.
+// States sealed by older versions do not have any.
+func readUnencryptedData(st store.Store) ([]byte, error) {
+	data, err := st.Get(UnencryptedDataFname)
+	if err == store.ErrNotFound {
+		return nil, nil
+	}
+	return data, err
 }
 
 func (s *AESGCMSealer) unsealEncryptionKey() error {
@@ -100,8 +121,8 @@ func (s *AESGCMSealer) unsealEncryptionKey() error {
 		return nil
 	}
 
-	// Read from fs
-	sealedKeyData, err := ioutil.ReadFile(s.getFname(SealedKeyFname))
+	// Read from store
+	sealedKeyData, err := s.store.Get(SealedKeyFname)
 	if err != nil {
 		return err
 	}
@@ -132,11 +153,10 @@ func (s *AESGCMSealer) GenerateNewEncryptionKey() error {
 
 // SetEncryptionKey sets or restores an encryption key
 func (s *AESGCMSealer) SetEncryptionKey(encryptionKey []byte) error {
-	// If there already is an existing key file stored on disk, save it
-	if sealedKeyData, err := ioutil.ReadFile(s.getFname(SealedKeyFname)); err == nil {
+	// If there already is an existing sealed key in the store, save it
+	if sealedKeyData, err := s.store.Get(SealedKeyFname); err == nil {
 		t := time.Now()
-		newFileName := s.getFname(SealedKeyFname) + "_" + t.Format("20060102150405") + ".bak"
-		ioutil.WriteFile(newFileName, sealedKeyData, 0600)
+		s.store.Put(SealedKeyFname+"_"+t.Format("20060102150405")+".bak", sealedKeyData)
 	}
 
 	// Encrypt encryption key with seal key
@@ -145,8 +165,8 @@ func (s *AESGCMSealer) SetEncryptionKey(encryptionKey []byte) error {
 		return err
 	}
 
-	// Write the sealed encryption key to disk
-	if err = ioutil.WriteFile(s.getFname(SealedKeyFname), encryptedKeyData, 0600); err != nil {
+	// Write the sealed encryption key to the store
+	if err = s.store.Put(SealedKeyFname, encryptedKeyData); err != nil {
 		return err
 	}
 
@@ -157,23 +177,34 @@ func (s *AESGCMSealer) SetEncryptionKey(encryptionKey []byte) error {
 
 // MockSealer is a mockup sealer
 type MockSealer struct {
-	data        []byte
-	unsealError error
+	data            []byte
+	unencryptedData []byte
+	unsealError     error
+	sealError       error
+	encryptionKey   []byte
 }
 
 // Unseal implements the Sealer interface
-func (s *MockSealer) Unseal() ([]byte, error) {
-	return s.data, s.unsealError
+func (s *MockSealer) Unseal() ([]byte, []byte, error) {
+	if s.unsealError != nil {
+		return s.unencryptedData, nil, s.unsealError
+	}
+	return s.unencryptedData, s.data, nil
 }
 
 // Seal implements the Sealer interface
-func (s *MockSealer) Seal(data []byte) ([]byte, error) {
-	s.data = data
+func (s *MockSealer) Seal(unencryptedData []byte, toBeEncrypted []byte) ([]byte, error) {
+	if s.sealError != nil {
+		return nil, s.sealError
+	}
+	s.unencryptedData = unencryptedData
+	s.data = toBeEncrypted
 	return []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}, nil
 }
 
 // SetEncryptionKey implements the Sealer interface
 func (s *MockSealer) SetEncryptionKey(key []byte) error {
+	s.encryptionKey = key
 	return nil
 }
 
@@ -184,64 +215,73 @@ func (s *MockSealer) GenerateNewEncryptionKey() error {
 
 // NoEnclaveSealer is a sealed for a -noenclave instance and does perform encryption with a fixed key
 type NoEnclaveSealer struct {
-	sealDir       string
+	store         store.Store
 	encryptionKey []byte
 }
 
-// NewNoEnclaveSealer creates and initializes a new NoEnclaveSealer object
-func NewNoEnclaveSealer(sealDir string) *NoEnclaveSealer {
-	return &NoEnclaveSealer{sealDir: sealDir}
+// NewNoEnclaveSealer creates and initializes a new NoEnclaveSealer object that seals to store
+func NewNoEnclaveSealer(store store.Store) *NoEnclaveSealer {
+	return &NoEnclaveSealer{store: store}
 }
 
-// Seal writes the given data encrypted and the used key as plaintext to the disk
-func (s *NoEnclaveSealer) Seal(data []byte) ([]byte, error) {
+// Seal writes the given data encrypted and the used key as plaintext to the store
+func (s *NoEnclaveSealer) Seal(unencryptedData []byte, toBeEncrypted []byte) ([]byte, error) {
 	// Encrypt data
-	sealedData, err := ertcrypto.Encrypt(data, s.encryptionKey)
+	sealedData, err := ertcrypto.Encrypt(toBeEncrypted, s.encryptionKey)
 	if err != nil {
 		return nil, err
 	}
 
-	// Write encrypted data to disk
-	if err := ioutil.WriteFile(s.getFname(SealedDataFname), sealedData, 0600); err != nil {
+	// Write unencrypted data to the store
+	if err := s.store.Put(UnencryptedDataFname, unencryptedData); err != nil {
+		return nil, err
+	}
+
+	// Write encrypted data to the store
+	if err := s.store.Put(SealedDataFname, sealedData); err != nil {
 		return nil, err
 	}
 
-	// Write key in plaintext to disk
-	if err := ioutil.WriteFile(s.getFname(SealedKeyFname), s.encryptionKey, 0600); err != nil {
+	// Write key in plaintext to the store
+	if err := s.store.Put(SealedKeyFname, s.encryptionKey); err != nil {
 		return nil, err
 	}
 	return s.encryptionKey, nil
 }
 
-// Unseal reads the plaintext state from disk
-func (s *NoEnclaveSealer) Unseal() ([]byte, error) {
-	// Read sealed data from disk
-	sealedData, err := ioutil.ReadFile(s.getFname(SealedDataFname))
-	if os.IsNotExist(err) {
-		return nil, nil
+// Unseal reads the plaintext state from the store
+func (s *NoEnclaveSealer) Unseal() ([]byte, []byte, error) {
+	// Read sealed data from the store
+	sealedData, err := s.store.Get(SealedDataFname)
+	if err == store.ErrNotFound {
+		return nil, nil, nil
 	} else if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	unencryptedData, err := readUnencryptedData(s.store)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	// Read key in plaintext from disk
-	keyData, err := ioutil.ReadFile(s.getFname(SealedKeyFname))
+	// Read key in plaintext from the store
+	keyData, err := s.store.Get(SealedKeyFname)
 	if err != nil {
-		return nil, err
+		return unencryptedData, nil, err
 	}
 
-	// Decrypt data with key from disk
+	// Decrypt data with key from the store
 	data, err := ertcrypto.Decrypt(sealedData, keyData)
 	if err != nil {
-		return nil, ErrEncryptionKey
+		return unencryptedData, nil, ErrEncryptionKey
 	}
 
-	return data, nil
+	return unencryptedData, data, nil
 }
 
 // SetEncryptionKey implements the Sealer interface
 func (s *NoEnclaveSealer) SetEncryptionKey(key []byte) error {
 	s.encryptionKey = key
-	return ioutil.WriteFile(s.getFname(SealedKeyFname), s.encryptionKey, 0600)
+	return s.store.Put(SealedKeyFname, s.encryptionKey)
 }
 
 // GenerateNewEncryptionKey implements the Sealer interface
@@ -249,7 +289,3 @@ func (s *NoEnclaveSealer) GenerateNewEncryptionKey() error {
 	s.encryptionKey = []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
 	return nil
 }
-
-func (s *NoEnclaveSealer) getFname(basename string) string {
-	return filepath.Join(s.sealDir, basename)
-}
@@ -0,0 +1,225 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/util"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// clientCertificateValidity is the validity of the client certificates the Coordinator authenticates with at external services.
+const clientCertificateValidity = time.Hour
+
+// SecretsBackend stores secrets outside of the Coordinator, e.g., HashiCorp Vault.
+type SecretsBackend interface {
+	// FetchSecret returns the value of the key of the secret at path.
+	FetchSecret(ctx context.Context, path string, key string) (string, error)
+}
+
+// BackendSecret references the value of a secret in the SecretsBackend of the Coordinator.
+type BackendSecret struct {
+	// Path is the path of the secret in the backend, e.g., "secret/data/myapp" for the KV secrets engine of Vault.
+	Path string
+	// Key is the key of the value in the secret.
+	Key string
+}
+
+// secretsBackendCache caches the values fetched from a SecretsBackend in memory. They are never sealed.
+type secretsBackendCache struct {
+	mux     sync.Mutex
+	backend SecretsBackend
+	ttl     time.Duration
+	entries map[BackendSecret]cachedBackendSecret
+	now     func() time.Time
+}
+
+type cachedBackendSecret struct {
+	value   string
+	expires time.Time
+}
+
+// SetSecretsBackend sets the backend from which the secrets of the manifest with a Backend are fetched.
+//
+// The values are cached for cacheTTL, so that they are not fetched anew for each activation. A cacheTTL of 0 disables the cache.
+func (c *Core) SetSecretsBackend(backend SecretsBackend, cacheTTL time.Duration) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.secretsBackend = &secretsBackendCache{backend: backend, ttl: cacheTTL, entries: make(map[BackendSecret]cachedBackendSecret), now: time.Now}
+}
+
+// fetch returns the value of the secret from the cache or the backend.
+func (b *secretsBackendCache) fetch(ctx context.Context, ref BackendSecret) (string, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	now := b.now()
+	if entry, ok := b.entries[ref]; ok && now.Before(entry.expires) {
+		return entry.value, nil
+	}
+	value, err := b.backend.FetchSecret(ctx, ref.Path, ref.Key)
+	if err != nil {
+		return "", err
+	}
+	if b.ttl > 0 {
+		b.entries[ref] = cachedBackendSecret{value: value, expires: now.Add(b.ttl)}
+	}
+	return value, nil
+}
+
+// marbleBackendSecrets returns the secrets of the secrets backend that the parameters of the marble type reference and it is entitled to,
+// with their current values.
+//
+// The Core must not be locked. It is only read-locked to look up the secrets, which are fetched without holding the lock,
+// because the backend is contacted over the network. Callers must detect if the manifest has been updated in the meantime.
+func (c *Core) marbleBackendSecrets(ctx context.Context, marbleType string) (map[string]Secret, error) {
+	c.mux.RLock()
+	referenced, err := referencedSecrets(c.manifest.Marbles[marbleType].Parameters)
+	entitledSecrets := c.manifest.entitledSecrets(marbleType)
+	backend := c.secretsBackend
+	c.mux.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	// only the referenced secrets of the backend are fetched
+	return c.fetchBackendSecrets(ctx, backend, entitledSecrets, referenced)
+}
+
+// fetchBackendSecrets returns the named secrets that are stored in the backend with their current values.
+//
+// It doesn't access the state of the Core, so that it can be called without holding the lock.
+func (c *Core) fetchBackendSecrets(ctx context.Context, backend *secretsBackendCache, secrets map[string]Secret, names []string) (map[string]Secret, error) {
+	fetched := make(map[string]Secret)
+	for _, name := range names {
+		secret, ok := secrets[name]
+		if !ok || secret.Backend == nil {
+			continue
+		}
+		if backend == nil {
+			return nil, status.Errorf(codes.FailedPrecondition, "secret %s is stored in a secrets backend, but none is configured", name)
+		}
+		value, err := backend.fetch(ctx, *secret.Backend)
+		if err != nil {
+			c.zaplogger.Error("Could not fetch secret from the secrets backend.", zap.String("name", name), zap.Error(err))
+			return nil, status.Errorf(codes.Unavailable, "failed to fetch secret %s from the secrets backend", name)
+		}
+		if fetched[name], err = secret.withBackendValue(value); err != nil {
+			return nil, status.Errorf(codes.Internal, "invalid value of secret %s in the secrets backend: %v", name, err)
+		}
+	}
+	return fetched, nil
+}
+
+// checkBackend verifies that the definition of a secret of the secrets backend can be used to accept its values.
+func (s Secret) checkBackend() error {
+	if s.UserDefined {
+		return errors.New("a secret can't be both user-defined and stored in a secrets backend")
+	}
+	if s.Backend.Path == "" || s.Backend.Key == "" {
+		return errors.New("the Path and Key of the secrets backend must be set")
+	}
+	return s.checkUserDefined()
+}
+
+// withBackendValue returns the secret set to the value from the secrets backend.
+//
+// plain values are taken as they are, symmetric-key values are base64-encoded, and the values of cert-* secrets contain the PEM-encoded certificate and PKCS #8 private key.
+func (s Secret) withBackendValue(value string) (Secret, error) {
+	switch s.Type {
+	case "plain":
+		return s.withValue(clientapi.Secret{Private: []byte(value)})
+	case "symmetric-key":
+		key, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return Secret{}, fmt.Errorf("symmetric key is not base64-encoded: %v", err)
+		}
+		return s.withValue(clientapi.Secret{Private: key})
+	}
+	var cert, privk []byte
+	rest := []byte(value)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "CERTIFICATE":
+			// the first certificate is the secret's, further ones may be its chain
+			if cert == nil {
+				cert = block.Bytes
+			}
+		case "PRIVATE KEY":
+			privk = block.Bytes
+		}
+	}
+	if cert == nil || privk == nil {
+		return Secret{}, errors.New("expected a PEM-encoded CERTIFICATE and PRIVATE KEY")
+	}
+	return s.withValue(clientapi.Secret{Cert: cert, Private: privk})
+}
+
+// GetClientCertificate returns a short-lived TLS client certificate of the Coordinator, signed by its root certificate.
+//
+// The Coordinator uses it to authenticate at external services like a SecretsBackend, which trust the Coordinator's root certificate
+// after they attested the Coordinator. Like the RA-TLS certificate, it embeds the quote of the root certificate.
+func (c *Core) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	if c.state == stateUninitialized || c.cert == nil {
+		return nil, errors.New("don't have a cert yet")
+	}
+
+	privk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serialNumber, err := util.GenerateCertificateSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	notBefore := time.Now()
+	notAfter := notBefore.Add(clientCertificateValidity)
+	if notAfter.After(c.cert.NotAfter) {
+		notAfter = c.cert.NotAfter
+	}
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkix.Name{CommonName: CoordinatorName},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if c.simulation {
+		template.Subject.OrganizationalUnit = []string{SimulationOrganizationalUnit}
+	}
+	if len(c.quote) > 0 {
+		template.ExtraExtensions = []pkix.Extension{{Id: quote.OIDRATLSQuote, Value: c.quote}}
+	}
+	certRaw, err := x509.CreateCertificate(rand.Reader, &template, c.cert, &privk.PublicKey, c.privk)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{certRaw, c.cert.Raw}, PrivateKey: privk}, nil
+}
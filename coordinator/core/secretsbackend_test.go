@@ -0,0 +1,163 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"context"
+	"crypto/elliptic"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/coordinator/quote/quotetest"
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/edgelesssys/marblerun/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSecretsBackend struct {
+	values  map[string]string
+	fetches int
+	onFetch func()
+}
+
+func (b *fakeSecretsBackend) FetchSecret(_ context.Context, path string, key string) (string, error) {
+	b.fetches++
+	if b.onFetch != nil {
+		b.onFetch()
+	}
+	value, ok := b.values[path+"#"+key]
+	if !ok {
+		return "", errors.New("not found")
+	}
+	return value, nil
+}
+
+func TestSecretsBackend(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, manifest := mustSetup()
+	manifest.Secrets["password"] = Secret{Type: "plain", Backend: &BackendSecret{Path: "secret/data/app", Key: "password"}}
+	manifest.Secrets["unused"] = Secret{Type: "symmetric-key", Backend: &BackendSecret{Path: "secret/data/app", Key: "unused"}}
+	frontend := manifest.Marbles["frontend"]
+	frontend.Parameters = &rpc.Parameters{Env: map[string]string{"PASSWORD": "{{ raw .Secrets.password }}"}}
+	manifest.Marbles["frontend"] = frontend
+	rawManifest, err := json.Marshal(manifest)
+	require.NoError(err)
+
+	// a manifest with secrets of a backend requires one
+	_, err = c.SetManifest(context.TODO(), rawManifest, nil)
+	assert.Error(err)
+
+	backend := &fakeSecretsBackend{values: map[string]string{"secret/data/app#password": "secret"}}
+	c.SetSecretsBackend(backend, time.Hour)
+	_, err = c.SetManifest(context.TODO(), rawManifest, nil)
+	require.NoError(err)
+	// the values are not stored in the state
+	assert.NotContains(c.secrets, "password")
+
+	spawner := marbleSpawner{
+		assert:     assert,
+		require:    require,
+		issuer:     c.qi,
+		validator:  c.qv.(*quote.MockValidator),
+		manifest:   *manifest,
+		coreServer: c,
+	}
+	params := spawner.newMarble("frontend", "Azure", true)
+	assert.Equal("secret", params.Env["PASSWORD"])
+	// unreferenced secrets aren't fetched and the values are cached
+	spawner.newMarble("frontend", "Azure", true)
+	assert.Equal(1, backend.fetches)
+
+	// the values are fetched without holding the lock of the Core
+	c.SetSecretsBackend(backend, 0)
+	backend.onFetch = func() {
+		acquired := make(chan struct{})
+		go func() {
+			c.mux.Lock()
+			c.mux.Unlock()
+			close(acquired)
+		}()
+		select {
+		case <-acquired:
+		case <-time.After(10 * time.Second):
+			t.Error("the Core is locked while the secret is fetched")
+		}
+	}
+	spawner.newMarble("frontend", "Azure", true)
+	assert.Equal(2, backend.fetches)
+	backend.onFetch = nil
+
+	// activations fail if the backend doesn't provide the secret
+	c.SetSecretsBackend(&fakeSecretsBackend{}, 0)
+	spawner.newMarble("frontend", "Azure", false)
+
+	_, err = c.RotateSecrets(context.TODO(), []string{"password"}, test.AdminCert)
+	assert.Error(err)
+}
+
+func TestSecretWithBackendValue(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	backend := &BackendSecret{Path: "secret", Key: "key"}
+	secret, err := Secret{Type: "plain", Backend: backend}.withBackendValue("value")
+	require.NoError(err)
+	assert.Equal([]byte("value"), []byte(secret.Private))
+
+	secret, err = Secret{Type: "symmetric-key", Size: 32, Backend: backend}.withBackendValue("AAECAw==")
+	require.NoError(err)
+	assert.Equal([]byte{0, 1, 2, 3}, []byte(secret.Private))
+	_, err = Secret{Type: "symmetric-key", Size: 64, Backend: backend}.withBackendValue("AAECAw==")
+	assert.Error(err)
+	_, err = Secret{Type: "symmetric-key", Backend: backend}.withBackendValue("not base64")
+	assert.Error(err)
+
+	cert, key := quotetest.MustCreateCert(t, elliptic.P256(), "secret", false, nil, nil)
+	encodedKey, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(err)
+	value := string(quotetest.ToPEM(cert)) + string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: encodedKey}))
+	secret, err = Secret{Type: "cert-ecdsa", Backend: backend}.withBackendValue(value)
+	require.NoError(err)
+	assert.Equal(cert.Raw, secret.Cert.Raw)
+	_, err = Secret{Type: "cert-rsa", Backend: backend}.withBackendValue(value)
+	assert.Error(err)
+	_, err = Secret{Type: "cert-ecdsa", Backend: backend}.withBackendValue(string(quotetest.ToPEM(cert)))
+	assert.Error(err)
+
+	assert.NoError(Secret{Type: "plain", Backend: backend}.check())
+	assert.Error(Secret{Type: "plain", Backend: &BackendSecret{Path: "secret"}}.check())
+	assert.Error(Secret{Type: "plain", UserDefined: true, Backend: backend}.check())
+	assert.Error(Secret{Type: "cert-rsa", Size: 2048, Backend: backend}.check())
+}
+
+func TestGetClientCertificate(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c := NewCoreWithMocks()
+	tlsCert, err := c.GetClientCertificate(&tls.CertificateRequestInfo{})
+	require.NoError(err)
+	cert, err := x509.ParseCertificate(tlsCert.Certificate[0])
+	require.NoError(err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(c.cert)
+	_, err = cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	assert.NoError(err)
+	_, err = cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}})
+	assert.Error(err)
+	assert.Equal(CoordinatorName, cert.Subject.CommonName)
+}
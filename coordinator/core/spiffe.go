@@ -0,0 +1,47 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// checkSPIFFETrustDomain verifies that the trust domain is valid as defined by the SPIFFE ID specification.
+func checkSPIFFETrustDomain(trustDomain string) error {
+	if len(trustDomain) > 255 {
+		return errors.New("trust domain is longer than 255 characters")
+	}
+	for _, c := range trustDomain {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+			return fmt.Errorf("trust domain %q contains invalid character %q, only lowercase letters, digits, '.', '-' and '_' are allowed", trustDomain, c)
+		}
+	}
+	return nil
+}
+
+// checkSPIFFEPathSegment verifies that the segment is valid in the path of a SPIFFE ID.
+func checkSPIFFEPathSegment(segment string) error {
+	if segment == "" || segment == "." || segment == ".." {
+		return fmt.Errorf("%q is not a valid path segment", segment)
+	}
+	for _, c := range segment {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+			return fmt.Errorf("%q contains invalid character %q, only letters, digits, '.', '-' and '_' are allowed", segment, c)
+		}
+	}
+	return nil
+}
+
+// spiffeID returns the SPIFFE ID spiffe://<trust domain>/<marble type>/<uuid> of a marble, or nil if the manifest doesn't set a SPIFFETrustDomain.
+func (m Manifest) spiffeID(marbleType string, marbleUUID string) *url.URL {
+	if m.SPIFFETrustDomain == "" || marbleType == "" {
+		return nil
+	}
+	return &url.URL{Scheme: "spiffe", Host: m.SPIFFETrustDomain, Path: "/" + marbleType + "/" + marbleUUID}
+}
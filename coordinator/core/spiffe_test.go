@@ -0,0 +1,70 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"testing"
+
+	libMarble "github.com/edgelesssys/ertgolib/marble"
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestManifestCheckSPIFFE(t *testing.T) {
+	assert := assert.New(t)
+
+	_, manifest := mustSetup()
+	manifest.SPIFFETrustDomain = "marblerun.example.com"
+	assert.NoError(manifest.Check(context.TODO(), zap.NewNop()))
+	manifest.SPIFFETrustDomain = "Marblerun.example.com"
+	assert.Error(manifest.Check(context.TODO(), zap.NewNop()))
+	manifest.SPIFFETrustDomain = "marblerun.example.com:443"
+	assert.Error(manifest.Check(context.TODO(), zap.NewNop()))
+
+	// the names of the marbles are part of the SPIFFE IDs
+	manifest.SPIFFETrustDomain = "marblerun.example.com"
+	manifest.Marbles["front end"] = manifest.Marbles["frontend"]
+	assert.Error(manifest.Check(context.TODO(), zap.NewNop()))
+	manifest.SPIFFETrustDomain = ""
+	assert.NoError(manifest.Check(context.TODO(), zap.NewNop()))
+}
+
+func TestSPIFFEID(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, manifest := mustSetup()
+	manifest.SPIFFETrustDomain = "marblerun.example.com"
+	rawManifest, err := json.Marshal(manifest)
+	require.NoError(err)
+	_, err = c.SetManifest(context.TODO(), rawManifest, nil)
+	require.NoError(err)
+	spawner := marbleSpawner{
+		assert:     assert,
+		require:    require,
+		issuer:     c.qi,
+		validator:  c.qv.(*quote.MockValidator),
+		manifest:   *manifest,
+		coreServer: c,
+	}
+
+	params := spawner.newMarble("frontend", "Azure", true)
+	block, _ := pem.Decode([]byte(params.Env[libMarble.MarbleEnvironmentCertificate]))
+	require.NotNil(block)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(err)
+	require.Len(cert.URIs, 1)
+	assert.Equal("spiffe://marblerun.example.com/frontend/"+cert.Subject.CommonName, cert.URIs[0].String())
+
+	assert.Nil(Manifest{}.spiffeID("frontend", cert.Subject.CommonName))
+}
@@ -0,0 +1,168 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package store
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EtcdStore stores the keys in etcd. It uses the JSON gateway of the etcd v3 API.
+type EtcdStore struct {
+	endpoint string
+	prefix   string
+	client   *http.Client
+}
+
+type etcdKeyValue struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value,omitempty"`
+}
+
+type etcdRangeResp struct {
+	Kvs []etcdKeyValue `json:"kvs"`
+}
+
+type etcdCompare struct {
+	Key            []byte `json:"key"`
+	Result         string `json:"result"`
+	Target         string `json:"target"`
+	Value          []byte `json:"value,omitempty"`
+	CreateRevision string `json:"create_revision,omitempty"`
+}
+
+type etcdRequestOp struct {
+	RequestPut etcdKeyValue `json:"request_put"`
+}
+
+type etcdTxnReq struct {
+	Compare []etcdCompare   `json:"compare"`
+	Success []etcdRequestOp `json:"success"`
+}
+
+type etcdTxnResp struct {
+	Succeeded bool `json:"succeeded"`
+}
+
+// NewEtcdStore creates an EtcdStore that stores the keys with prefix at the etcd server at endpoint, e.g., https://etcd:2379.
+// tlsConfig authenticates the connection to the server and may be nil.
+func NewEtcdStore(endpoint string, prefix string, tlsConfig *tls.Config) *EtcdStore {
+	return &EtcdStore{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		prefix:   prefix,
+		client:   &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+	}
+}
+
+// Get implements the Store interface
+func (s *EtcdStore) Get(key string) ([]byte, error) {
+	var resp etcdRangeResp
+	if err := s.post("/v3/kv/range", etcdKeyValue{Key: []byte(s.prefix + key)}, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, ErrNotFound
+	}
+	return resp.Kvs[0].Value, nil
+}
+
+// Put implements the Store interface
+func (s *EtcdStore) Put(key string, value []byte) error {
+	return s.post("/v3/kv/put", etcdKeyValue{Key: []byte(s.prefix + key), Value: value}, nil)
+}
+
+func (s *EtcdStore) post(route string, req interface{}, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpResp, err := s.client.Post(s.endpoint+route, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	respBody, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return err
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcd: %v: %s", httpResp.Status, bytes.TrimSpace(respBody))
+	}
+	if resp == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, resp)
+}
+
+// EtcdCounter is a monotonic counter in etcd. It can protect the sealed state against rollback if the etcd cluster is trusted.
+type EtcdCounter struct {
+	store *EtcdStore
+	key   string
+}
+
+// NewEtcdCounter creates an EtcdCounter that is stored under key in store.
+func NewEtcdCounter(store *EtcdStore, key string) *EtcdCounter {
+	return &EtcdCounter{store: store, key: key}
+}
+
+// Value returns the value of the counter, which is 0 if it has never been incremented.
+func (c *EtcdCounter) Value() (uint64, error) {
+	value, _, err := c.get()
+	return value, err
+}
+
+// Increment increases the counter by one and returns its new value.
+// The counter is only written if it has not been changed concurrently, so it never decreases.
+func (c *EtcdCounter) Increment() (uint64, error) {
+	const attempts = 3
+	for i := 0; i < attempts; i++ {
+		value, raw, err := c.get()
+		if err != nil {
+			return 0, err
+		}
+		key := []byte(c.store.prefix + c.key)
+		compare := etcdCompare{Key: key, Result: "EQUAL", Target: "VALUE", Value: raw}
+		if raw == nil {
+			compare = etcdCompare{Key: key, Result: "EQUAL", Target: "CREATE", CreateRevision: "0"}
+		}
+		req := etcdTxnReq{
+			Compare: []etcdCompare{compare},
+			Success: []etcdRequestOp{{RequestPut: etcdKeyValue{Key: key, Value: []byte(strconv.FormatUint(value+1, 10))}}},
+		}
+		var resp etcdTxnResp
+		if err := c.store.post("/v3/kv/txn", req, &resp); err != nil {
+			return 0, err
+		}
+		if resp.Succeeded {
+			return value + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("etcd: counter %v has been changed concurrently", c.key)
+}
+
+// get returns the value of the counter and its raw representation, which is nil if the counter doesn't exist.
+func (c *EtcdCounter) get() (uint64, []byte, error) {
+	raw, err := c.store.Get(c.key)
+	if err == ErrNotFound {
+		return 0, nil, nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	value, err := strconv.ParseUint(string(raw), 10, 64)
+	if err != nil {
+		return 0, nil, fmt.Errorf("etcd: invalid counter %v: %v", c.key, err)
+	}
+	return value, raw, nil
+}
@@ -0,0 +1,84 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package store
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"github.com/edgelesssys/marblerun/coordinator/kubernetes"
+)
+
+// KubernetesStore stores the keys in the data of a Kubernetes secret.
+type KubernetesStore struct {
+	client     *kubernetes.Client
+	secretPath string
+	name       string
+}
+
+type kubernetesSecret struct {
+	APIVersion string            `json:"apiVersion,omitempty"`
+	Kind       string            `json:"kind,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	Data       map[string][]byte `json:"data"`
+}
+
+// NewKubernetesStore creates a KubernetesStore for the secret name in namespace.
+//
+// apiServer is the URL of the Kubernetes API server. The store authenticates with the bearer token in tokenFile,
+// which is read for each request, as the token may be rotated.
+func NewKubernetesStore(apiServer string, namespace string, name string, tokenFile string, tlsConfig *tls.Config) *KubernetesStore {
+	return newKubernetesStore(kubernetes.NewClient(apiServer, tokenFile, tlsConfig), namespace, name)
+}
+
+// NewInClusterKubernetesStore creates a KubernetesStore for the secret name in the namespace of the pod
+// using the credentials of the pod's service account.
+func NewInClusterKubernetesStore(name string) (*KubernetesStore, error) {
+	client, namespace, err := kubernetes.NewInClusterClient()
+	if err != nil {
+		return nil, err
+	}
+	return newKubernetesStore(client, namespace, name), nil
+}
+
+func newKubernetesStore(client *kubernetes.Client, namespace string, name string) *KubernetesStore {
+	return &KubernetesStore{
+		client:     client,
+		secretPath: fmt.Sprintf("/api/v1/namespaces/%s/secrets/%s", namespace, name),
+		name:       name,
+	}
+}
+
+// Get implements the Store interface
+func (s *KubernetesStore) Get(key string) ([]byte, error) {
+	var secret kubernetesSecret
+	status, err := s.client.Request(http.MethodGet, s.secretPath, "", nil, &secret)
+	if status == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	value, ok := secret.Data[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+// Put implements the Store interface
+//
+// The key is merged into the data of the secret, which is created if it does not exist yet.
+func (s *KubernetesStore) Put(key string, value []byte) error {
+	return s.client.Apply(s.secretPath, kubernetesSecret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata:   map[string]string{"name": s.name},
+		Data:       map[string][]byte{key: value},
+	})
+}
@@ -0,0 +1,71 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package store provides the backends in which the Coordinator persists its sealed state.
+package store
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ErrNotFound occurs if a key has not been stored.
+var ErrNotFound = errors.New("key not found")
+
+// Store is a key-value store for the sealed state of the Coordinator
+//
+// The values are opaque to the store. Confidential data is encrypted before it is stored.
+// Get returns ErrNotFound if the key has not been stored.
+type Store interface {
+	Get(key string) ([]byte, error)
+	Put(key string, value []byte) error
+}
+
+// FileStore stores each key as a file in a local directory.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore creates a FileStore for the directory dir.
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{dir: dir}
+}
+
+// Get implements the Store interface
+func (s *FileStore) Get(key string) ([]byte, error) {
+	value, err := ioutil.ReadFile(filepath.Join(s.dir, key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
+
+// Put implements the Store interface
+func (s *FileStore) Put(key string, value []byte) error {
+	return ioutil.WriteFile(filepath.Join(s.dir, key), value, 0600)
+}
+
+// prefixStore stores its keys with a prefix in another store.
+type prefixStore struct {
+	store  Store
+	prefix string
+}
+
+// WithPrefix returns a Store that stores its keys with a prefix in store.
+// It lets several sealers share a backend.
+func WithPrefix(store Store, prefix string) Store {
+	return &prefixStore{store: store, prefix: prefix}
+}
+
+func (s *prefixStore) Get(key string) ([]byte, error) {
+	return s.store.Get(s.prefix + key)
+}
+
+func (s *prefixStore) Put(key string, value []byte) error {
+	return s.store.Put(s.prefix+key, value)
+}
@@ -0,0 +1,200 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package store
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStore checks the behavior every Store must implement.
+func testStore(t *testing.T, store Store) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	_, err := store.Get("sealed_data")
+	assert.Equal(ErrNotFound, err)
+
+	require.NoError(store.Put("sealed_data", []byte("data")))
+	require.NoError(store.Put("sealed_key", []byte("key")))
+	value, err := store.Get("sealed_data")
+	require.NoError(err)
+	assert.Equal([]byte("data"), value)
+
+	require.NoError(store.Put("sealed_data", []byte("new data")))
+	value, err = store.Get("sealed_data")
+	require.NoError(err)
+	assert.Equal([]byte("new data"), value)
+	value, err = store.Get("sealed_key")
+	require.NoError(err)
+	assert.Equal([]byte("key"), value)
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	testStore(t, NewFileStore(dir))
+	value, err := ioutil.ReadFile(filepath.Join(dir, "sealed_data"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("new data"), value)
+}
+
+func TestWithPrefix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	base := NewFileStore(dir)
+	testStore(t, WithPrefix(base, "cluster_"))
+	_, err = base.Get("sealed_data")
+	assert.Equal(t, ErrNotFound, err)
+	value, err := base.Get("cluster_sealed_data")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("new data"), value)
+}
+
+// fakeEtcd serves the subset of the etcd JSON gateway used by the EtcdStore and EtcdCounter.
+type fakeEtcd struct {
+	mux sync.Mutex
+	kvs map[string][]byte
+}
+
+func (e *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	switch r.URL.Path {
+	case "/v3/kv/range":
+		var req etcdKeyValue
+		json.NewDecoder(r.Body).Decode(&req)
+		var resp etcdRangeResp
+		if value, ok := e.kvs[string(req.Key)]; ok {
+			resp.Kvs = []etcdKeyValue{{Key: req.Key, Value: value}}
+		}
+		json.NewEncoder(w).Encode(resp)
+	case "/v3/kv/put":
+		var req etcdKeyValue
+		json.NewDecoder(r.Body).Decode(&req)
+		e.kvs[string(req.Key)] = req.Value
+		w.Write([]byte("{}"))
+	case "/v3/kv/txn":
+		var req etcdTxnReq
+		json.NewDecoder(r.Body).Decode(&req)
+		succeeded := true
+		for _, compare := range req.Compare {
+			value, ok := e.kvs[string(compare.Key)]
+			switch compare.Target {
+			case "CREATE":
+				succeeded = succeeded && !ok
+			case "VALUE":
+				succeeded = succeeded && ok && string(value) == string(compare.Value)
+			}
+		}
+		if succeeded {
+			for _, op := range req.Success {
+				e.kvs[string(op.RequestPut.Key)] = op.RequestPut.Value
+			}
+		}
+		json.NewEncoder(w).Encode(etcdTxnResp{Succeeded: succeeded})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestEtcdStore(t *testing.T) {
+	etcd := &fakeEtcd{kvs: map[string][]byte{}}
+	server := httptest.NewServer(etcd)
+	defer server.Close()
+
+	testStore(t, NewEtcdStore(server.URL, "marblerun/", nil))
+	assert.Equal(t, []byte("new data"), etcd.kvs["marblerun/sealed_data"])
+}
+
+func TestEtcdCounter(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	etcd := &fakeEtcd{kvs: map[string][]byte{}}
+	server := httptest.NewServer(etcd)
+	defer server.Close()
+	counter := NewEtcdCounter(NewEtcdStore(server.URL, "marblerun/", nil), "counter")
+
+	value, err := counter.Value()
+	require.NoError(err)
+	assert.EqualValues(0, value)
+	for i := 1; i <= 3; i++ {
+		value, err = counter.Increment()
+		require.NoError(err)
+		assert.EqualValues(i, value)
+	}
+	value, err = counter.Value()
+	require.NoError(err)
+	assert.EqualValues(3, value)
+	assert.Equal([]byte("3"), etcd.kvs["marblerun/counter"])
+
+	etcd.kvs["marblerun/counter"] = []byte("invalid")
+	_, err = counter.Increment()
+	assert.Error(err)
+}
+
+func TestKubernetesStore(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	tokenFile, err := ioutil.TempFile("", "")
+	require.NoError(err)
+	defer os.Remove(tokenFile.Name())
+	_, err = tokenFile.WriteString("token\n")
+	require.NoError(err)
+	tokenFile.Close()
+
+	var mux sync.Mutex
+	var secret *kubernetesSecret
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("Bearer token", r.Header.Get("Authorization"))
+		mux.Lock()
+		defer mux.Unlock()
+		const secretPath = "/api/v1/namespaces/marblerun/secrets/state"
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v1/namespaces/marblerun/secrets":
+			var created kubernetesSecret
+			require.NoError(json.NewDecoder(r.Body).Decode(&created))
+			assert.Equal("state", created.Metadata["name"])
+			secret = &created
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(secret)
+		case r.URL.Path != secretPath || secret == nil:
+			http.NotFound(w, r)
+		case r.Method == http.MethodGet:
+			json.NewEncoder(w).Encode(secret)
+		case r.Method == http.MethodPatch:
+			assert.Equal("application/merge-patch+json", r.Header.Get("Content-Type"))
+			var patch kubernetesSecret
+			require.NoError(json.NewDecoder(r.Body).Decode(&patch))
+			for key, value := range patch.Data {
+				secret.Data[key] = value
+			}
+			json.NewEncoder(w).Encode(secret)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer server.Close()
+
+	testStore(t, NewKubernetesStore(server.URL, "marblerun", "state", tokenFile.Name(), nil))
+	assert.Equal([]byte("new data"), secret.Data["sealed_data"])
+}
@@ -0,0 +1,95 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/edgelesssys/marblerun/util"
+	"go.uber.org/zap"
+)
+
+// splitSubjectAltNames splits the names of the Coordinator's certificate into DNS names and IP addresses.
+// Empty names and duplicates are dropped.
+func splitSubjectAltNames(names []string) ([]string, []net.IP) {
+	var dnsNames []string
+	var ipAddrs []net.IP
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		if ip := net.ParseIP(name); ip != nil {
+			ipAddrs = append(ipAddrs, ip)
+		} else {
+			dnsNames = append(dnsNames, name)
+		}
+	}
+	return dnsNames, ipAddrs
+}
+
+// certificateIPAddresses returns the IP addresses of the Coordinator's certificates: the loopback addresses and the configured ones.
+func (c *Core) certificateIPAddresses() []net.IP {
+	ipAddrs := append([]net.IP{}, util.DefaultCertificateIPAddresses...)
+	for _, ip := range c.ipAddrs {
+		if !containsIP(ipAddrs, ip) {
+			ipAddrs = append(ipAddrs, ip)
+		}
+	}
+	return ipAddrs
+}
+
+// SetSubjectAltNames sets the DNS names and IP addresses of the Coordinator's TLS certificate, given like EDG_COORDINATOR_DNS_NAMES.
+//
+// If they changed, the TLS certificate of the client API and the Marble API is issued anew, so that the Coordinator can be reached
+// under new names without a restart. The root certificate keeps the names it has been created with, as clients only verify the names
+// of the TLS certificate. It returns whether the names changed.
+func (c *Core) SetSubjectAltNames(names []string) (bool, error) {
+	dnsNames, ipAddrs := splitSubjectAltNames(names)
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if sameSubjectAltNames(c.dnsNames, c.ipAddrs, dnsNames, ipAddrs) {
+		return false, nil
+	}
+
+	oldDNSNames, oldIPAddrs := c.dnsNames, c.ipAddrs
+	c.dnsNames, c.ipAddrs = dnsNames, ipAddrs
+	tlsCert, err := c.generateTLSCertificate()
+	if err != nil {
+		c.dnsNames, c.ipAddrs = oldDNSNames, oldIPAddrs
+		return false, err
+	}
+	c.tlsCert = tlsCert
+	c.zaplogger.Info("Issued the TLS certificate for new names", zap.Strings("DNSNames", dnsNames), zap.Any("IPAddresses", ipAddrs))
+	return true, nil
+}
+
+// sameSubjectAltNames returns whether two sets of DNS names and IP addresses are equal regardless of their order.
+func sameSubjectAltNames(dnsNames []string, ipAddrs []net.IP, otherDNSNames []string, otherIPAddrs []net.IP) bool {
+	key := func(dnsNames []string, ipAddrs []net.IP) string {
+		names := append([]string{}, dnsNames...)
+		for _, ip := range ipAddrs {
+			names = append(names, ip.String())
+		}
+		sort.Strings(names)
+		return strings.Join(names, ",")
+	}
+	return key(dnsNames, ipAddrs) == key(otherDNSNames, otherIPAddrs)
+}
+
+func containsIP(ipAddrs []net.IP, ip net.IP) bool {
+	for _, addr := range ipAddrs {
+		if addr.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
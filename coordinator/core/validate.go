@@ -0,0 +1,361 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"go.uber.org/zap"
+)
+
+// ManifestError is an error at a location of a manifest, which is given as JSON path, e.g., $.Marbles.frontend.Package.
+type ManifestError struct {
+	Path    string
+	Message string
+}
+
+func (e ManifestError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// ManifestErrors contains all errors that have been found in a manifest, sorted by path.
+type ManifestErrors []ManifestError
+
+func (e ManifestErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// add appends an error at path.
+func (e *ManifestErrors) add(path string, format string, args ...interface{}) {
+	*e = append(*e, ManifestError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// ValidateManifest checks a manifest in JSON format without a Coordinator, e.g., before it is set or in a CI pipeline.
+//
+// It applies the same checks as the Coordinator when the manifest is set. The errors are returned as ManifestErrors.
+// Errors that can't be attributed to a location of the manifest are reported at its root "$".
+func ValidateManifest(rawManifest []byte) error {
+	if errs := checkDuplicateKeys(rawManifest); len(errs) > 0 {
+		return errs
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(rawManifest, &manifest); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return ManifestErrors{{Path: "$." + typeErr.Field, Message: fmt.Sprintf("cannot use %s as %v", typeErr.Value, typeErr.Type)}}
+		}
+		return ManifestErrors{{Path: "$", Message: err.Error()}}
+	}
+	if err := manifest.Check(context.Background(), zap.NewNop()); err != nil {
+		if errs, ok := err.(ManifestErrors); ok {
+			return errs
+		}
+		return ManifestErrors{{Path: "$", Message: err.Error()}}
+	}
+	return nil
+}
+
+// validate checks the references between the entries of the manifest and the ranges of its values.
+// Unlike the rest of Check, it reports all errors instead of the first one.
+func (m Manifest) validate() ManifestErrors {
+	var errs ManifestErrors
+
+	if len(m.Packages) == 0 {
+		errs.add("$.Packages", "no allowed packages defined")
+	}
+	if len(m.Marbles) == 0 {
+		errs.add("$.Marbles", "no allowed marbles defined")
+	}
+	for marbleType, marble := range m.Marbles {
+		path := jsonPath("$.Marbles", marbleType)
+		if _, ok := m.Packages[marble.Package]; !ok {
+			errs.add(path+".Package", "undefined package %q", marble.Package)
+		}
+		for infrastructure := range marble.InfrastructureMaxActivations {
+			if _, ok := m.Infrastructures[infrastructure]; !ok {
+				errs.add(jsonPath(path+".InfrastructureMaxActivations", infrastructure), "undefined infrastructure %q", infrastructure)
+			}
+		}
+		if marble.HeartbeatTTL != 0 && marble.heartbeatTTL() <= heartbeatInterval {
+			errs.add(path+".HeartbeatTTL", "must be longer than the heartbeat interval of %v", heartbeatInterval)
+		}
+		switch marble.SealKeyScope {
+		case "", SealKeyScopeUUID, SealKeyScopeMarbleType:
+		default:
+			errs.add(path+".SealKeyScope", "must be %q or %q", SealKeyScopeUUID, SealKeyScopeMarbleType)
+		}
+		if marble.TLS != nil {
+			for i, tag := range marble.TLS.Tags {
+				if _, ok := m.TLS[tag]; !ok {
+					errs.add(fmt.Sprintf("%s.TLS.Tags[%d]", path, i), "undefined TLS tag %q", tag)
+				}
+			}
+		}
+		m.validateParameters(&errs, path+".Parameters", marbleType, marble.Parameters)
+	}
+
+	for name, pkg := range m.Packages {
+		validateTCBStatuses(&errs, jsonPath("$.Packages", name)+".AcceptedTCBStatuses", pkg.AcceptedTCBStatuses)
+	}
+	for name, infrastructure := range m.Infrastructures {
+		validateTCBStatuses(&errs, jsonPath("$.Infrastructures", name)+".AcceptedTCBStatuses", infrastructure.AcceptedTCBStatuses)
+	}
+
+	if err := m.MarbleCertificate.check(); err != nil {
+		errs.add("$.MarbleCertificate", "%v", err)
+	}
+	for pkg := range m.PackageCertificates {
+		path := jsonPath("$.PackageCertificates", pkg)
+		if _, ok := m.Packages[pkg]; !ok {
+			errs.add(path, "undefined package %q", pkg)
+		} else if err := m.marbleCertificateConfig(pkg).check(); err != nil {
+			errs.add(path, "%v", err)
+		}
+	}
+
+	if m.RecoveryKey != "" && len(m.RecoveryKeys) > 0 {
+		errs.add("$.RecoveryKey", "must not be combined with RecoveryKeys")
+	}
+	if len(m.RecoveryKeys) > 255 {
+		errs.add("$.RecoveryKeys", "too many recovery keys, at most 255 are supported")
+	}
+	if m.RecoveryThreshold > 1 && m.RecoveryThreshold > uint(len(m.recoveryKeys())) {
+		errs.add("$.RecoveryThreshold", "%d exceeds the number of recovery keys %d", m.RecoveryThreshold, len(m.recoveryKeys()))
+	}
+
+	for name, role := range m.Roles {
+		path := jsonPath("$.Roles", name)
+		actions, ok := roleActions[role.ResourceType]
+		if !ok {
+			errs.add(path+".ResourceType", "unsupported resource type %q", role.ResourceType)
+			continue
+		}
+		for i, action := range role.Actions {
+			if !contains(actions, action) {
+				errs.add(fmt.Sprintf("%s.Actions[%d]", path, i), "unsupported action %q for resource type %s", action, role.ResourceType)
+			}
+		}
+		for i, resource := range role.ResourceNames {
+			resourcePath := fmt.Sprintf("%s.ResourceNames[%d]", path, i)
+			switch role.ResourceType {
+			case resourceSecrets:
+				if _, ok := m.Secrets[resource]; !ok {
+					errs.add(resourcePath, "undefined secret %q", resource)
+				}
+			case resourceExternalServices:
+				if _, ok := m.ExternalServices[resource]; !ok {
+					errs.add(resourcePath, "undefined external service %q", resource)
+				}
+			default:
+				errs.add(resourcePath, "resource type %s has no named resources", role.ResourceType)
+			}
+		}
+	}
+	for name, user := range m.Users {
+		path := jsonPath("$.Users", name)
+		if _, err := parsePEMCertificate(user.Certificate); err != nil {
+			errs.add(path+".Certificate", "invalid certificate: %v", err)
+		}
+		for i, role := range user.Roles {
+			if _, ok := m.Roles[role]; !ok {
+				errs.add(fmt.Sprintf("%s.Roles[%d]", path, i), "undefined role %q", role)
+			}
+		}
+	}
+	if m.UpdateThreshold > 1 {
+		acknowledgers := 0
+		for name := range m.Users {
+			if m.isPermitted(name, resourceManifest, "", actionAcknowledgeUpdate) {
+				acknowledgers++
+			}
+		}
+		if m.UpdateThreshold > uint(acknowledgers) {
+			errs.add("$.UpdateThreshold", "%d exceeds the number of users permitted to acknowledge updates %d", m.UpdateThreshold, acknowledgers)
+		}
+	}
+
+	for name, secret := range m.Secrets {
+		path := jsonPath("$.Secrets", name)
+		if err := secret.check(); err != nil {
+			errs.add(path, "%v", err)
+		}
+		for i, marbleType := range secret.Marbles {
+			if _, ok := m.Marbles[marbleType]; !ok {
+				errs.add(fmt.Sprintf("%s.Marbles[%d]", path, i), "undefined marble %q", marbleType)
+			}
+		}
+		for i, group := range secret.Groups {
+			if _, ok := m.MarbleGroups[group]; !ok {
+				errs.add(fmt.Sprintf("%s.Groups[%d]", path, i), "undefined marble group %q", group)
+			}
+		}
+		if len(secret.Groups) > 0 && !secret.Shared && !secret.UserDefined && secret.Backend == nil {
+			errs.add(path+".Groups", "secrets of marble groups must be shared, user-defined or stored in the secrets backend")
+		}
+	}
+	for name, members := range m.MarbleGroups {
+		for i, marbleType := range members {
+			if _, ok := m.Marbles[marbleType]; !ok {
+				errs.add(fmt.Sprintf("%s[%d]", jsonPath("$.MarbleGroups", name), i), "undefined marble %q", marbleType)
+			}
+		}
+	}
+
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Path < errs[j].Path })
+	return errs
+}
+
+// validateParameters checks that the templates of the parameters of a marble can be parsed and only reference secrets that are defined and that the marble is entitled to,
+// and host environment variables that the marble passes through.
+func (m Manifest) validateParameters(errs *ManifestErrors, path string, marbleType string, params *rpc.Parameters) {
+	if params == nil {
+		return
+	}
+	hostEnv := make(map[string]bool, len(params.HostEnv))
+	for i, name := range params.HostEnv {
+		if name == "" || strings.Contains(name, "=") {
+			errs.add(fmt.Sprintf("%s.HostEnv[%d]", path, i), "invalid environment variable name %q", name)
+		}
+		hostEnv[name] = true
+	}
+	check := func(path string, value string) {
+		names, err := templateReferences(value, "Secrets")
+		if err != nil {
+			errs.add(path, "invalid template: %v", err)
+			return
+		}
+		for _, name := range names {
+			if secret, ok := m.Secrets[name]; !ok {
+				errs.add(path, "references undefined secret %q", name)
+			} else if !m.isEntitled(secret, marbleType) {
+				errs.add(path, "references secret %q, which marble %s is not entitled to", name, marbleType)
+			}
+		}
+		// the template has already been parsed
+		names, _ = templateReferences(value, "HostEnv")
+		for _, name := range names {
+			if !hostEnv[name] {
+				errs.add(path, "references host environment variable %q, which is not in HostEnv", name)
+			}
+		}
+	}
+	for name, value := range params.Files {
+		check(jsonPath(path+".Files", name), value)
+	}
+	for name, file := range params.FileSpecs {
+		filePath := jsonPath(path+".Files", name)
+		if _, ok := params.Files[name]; ok {
+			errs.add(filePath, "is defined with and without attributes")
+		}
+		check(filePath+".Data", string(file.GetData()))
+		// the marble substitutes the host environment after the Coordinator has decoded the data
+		if names, _ := templateReferences(string(file.GetData()), "HostEnv"); len(names) > 0 && file.GetEncoding() == rpc.EncodingBase64 {
+			errs.add(filePath+".Data", "base64-encoded data cannot reference the host environment")
+		}
+		if encoding := file.GetEncoding(); encoding != "" && encoding != rpc.EncodingString && encoding != rpc.EncodingBase64 {
+			errs.add(filePath+".Encoding", "unknown encoding %q, must be %q or %q", encoding, rpc.EncodingString, rpc.EncodingBase64)
+		}
+		if file.GetMode()&^0777 != 0 {
+			errs.add(filePath+".Mode", "%04o contains more than the permission bits", file.GetMode())
+		}
+	}
+	for name, value := range params.Env {
+		check(jsonPath(path+".Env", name), value)
+	}
+	for i, value := range params.Argv {
+		check(fmt.Sprintf("%s.Argv[%d]", path, i), value)
+	}
+}
+
+// checkDuplicateKeys reports the keys that occur more than once in an object of a JSON document, which json.Unmarshal would silently overwrite.
+// A syntax error is reported at the root of the document.
+func checkDuplicateKeys(data []byte) ManifestErrors {
+	var errs ManifestErrors
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if err := walkJSON(decoder, "$", &errs); err != nil {
+		return ManifestErrors{{Path: "$", Message: fmt.Sprintf("invalid JSON: %v", err)}}
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return ManifestErrors{{Path: "$", Message: "invalid JSON: unexpected data after the top-level value"}}
+	}
+	return errs
+}
+
+// walkJSON reads the next value from the decoder and reports duplicate keys in its objects.
+func walkJSON(decoder *json.Decoder, path string, errs *ManifestErrors) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	switch token {
+	case json.Delim('{'):
+		keys := make(map[string]bool)
+		for decoder.More() {
+			token, err := decoder.Token()
+			if err != nil {
+				return err
+			}
+			key, ok := token.(string)
+			if !ok {
+				return fmt.Errorf("unexpected %v at %s", token, path)
+			}
+			keyPath := jsonPath(path, key)
+			if keys[key] {
+				errs.add(keyPath, "duplicate key")
+			}
+			keys[key] = true
+			if err := walkJSON(decoder, keyPath, errs); err != nil {
+				return err
+			}
+		}
+	case json.Delim('['):
+		for i := 0; decoder.More(); i++ {
+			if err := walkJSON(decoder, fmt.Sprintf("%s[%d]", path, i), errs); err != nil {
+				return err
+			}
+		}
+	default:
+		return nil
+	}
+	// the closing delimiter
+	_, err = decoder.Token()
+	return err
+}
+
+// validateTCBStatuses checks that the accepted TCB statuses are statuses Intel reports. Revoked platforms are never accepted.
+func validateTCBStatuses(errs *ManifestErrors, path string, statuses []string) {
+	for i, status := range statuses {
+		if !quote.IsTCBStatus(status) || status == quote.TCBStatusRevoked {
+			errs.add(fmt.Sprintf("%s[%d]", path, i), "%q is not a TCB status that can be accepted", status)
+		}
+	}
+}
+
+var jsonIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// jsonPath returns the path of the member key of the object at path.
+func jsonPath(path string, key string) string {
+	if jsonIdentifier.MatchString(key) {
+		return path + "." + key
+	}
+	return path + "[" + strconv.Quote(key) + "]"
+}
@@ -0,0 +1,113 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/edgelesssys/marblerun/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateManifest(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	assert.NoError(ValidateManifest([]byte(test.ManifestJSON)))
+
+	var manifest Manifest
+	require.NoError(json.Unmarshal([]byte(test.ManifestJSON), &manifest))
+	frontend := manifest.Marbles["frontend"]
+	frontend.Package = "frontent"
+	frontend.SealKeyScope = "Host"
+	frontend.Parameters = &rpc.Parameters{
+		Env: map[string]string{
+			"KEY":     "{{ raw .Secrets.symmetric_key_shard }}",
+			"THREADS": "{{ .HostEnv.OMP_NUM_THREADS }}",
+			"HOME":    "{{ .HostEnv.HOME }}",
+		},
+		Argv:    []string{"marble", "{{ raw .Secrets.symmetric_key_shared"},
+		HostEnv: []string{"OMP_NUM_THREADS", "A=B"},
+		FileSpecs: map[string]*rpc.File{
+			"/secret": {Data: []byte("{{ hex .Secrets.symmetric_key_shared }}"), Encoding: "hex", Mode: 04755},
+		},
+	}
+	manifest.Marbles["frontend"] = frontend
+	manifest.Roles = map[string]Role{"reader": {ResourceType: "Secrets", ResourceNames: []string{"missing"}, Actions: []string{"ReadSecret", "Read"}}}
+	manifest.Users = map[string]User{"admin.example.com": {Certificate: test.CertPEM(test.AdminCert), Roles: []string{"reader", "writer"}}}
+	manifest.RecoveryThreshold = 2
+	rawManifest, err := json.Marshal(manifest)
+	require.NoError(err)
+
+	// all errors are reported with their locations, sorted by path
+	err = ValidateManifest(rawManifest)
+	require.IsType(ManifestErrors{}, err)
+	errs := err.(ManifestErrors)
+	paths := make([]string, len(errs))
+	for i, err := range errs {
+		paths[i] = err.Path
+	}
+	assert.Equal([]string{
+		"$.Marbles.frontend.Package",
+		"$.Marbles.frontend.Parameters.Argv[1]",
+		"$.Marbles.frontend.Parameters.Env.HOME",
+		"$.Marbles.frontend.Parameters.Env.KEY",
+		`$.Marbles.frontend.Parameters.Files["/secret"].Encoding`,
+		`$.Marbles.frontend.Parameters.Files["/secret"].Mode`,
+		"$.Marbles.frontend.Parameters.HostEnv[1]",
+		"$.Marbles.frontend.SealKeyScope",
+		"$.RecoveryThreshold",
+		"$.Roles.reader.Actions[1]",
+		"$.Roles.reader.ResourceNames[0]",
+		`$.Users["admin.example.com"].Roles[1]`,
+	}, paths)
+	assert.Equal(`undefined package "frontent"`, errs[0].Message)
+	assert.Equal(`references host environment variable "HOME", which is not in HostEnv`, errs[2].Message)
+	assert.Equal(`references undefined secret "symmetric_key_shard"`, errs[3].Message)
+
+	// the same errors are returned when the manifest is set
+	c := NewCoreWithMocks()
+	_, err = c.SetManifest(context.TODO(), rawManifest, nil)
+	assert.Equal(errs, err)
+}
+
+func TestValidateManifestJSON(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	err := ValidateManifest([]byte(`{"Packages": {"backend": {}, "backend": {"Debug": true}}, "Marbles": {}, "Marbles": {}}`))
+	assert.Equal(ManifestErrors{
+		{Path: "$.Packages.backend", Message: "duplicate key"},
+		{Path: "$.Marbles", Message: "duplicate key"},
+	}, err)
+
+	for _, rawManifest := range []string{``, `{"Packages": `, `{} {}`, `{"Packages": []}`} {
+		err := ValidateManifest([]byte(rawManifest))
+		require.IsType(ManifestErrors{}, err, rawManifest)
+		assert.Len(err, 1, rawManifest)
+	}
+	err = ValidateManifest([]byte(`{"Packages": {"backend": {"AcceptedTCBStatuses": ["OutOfDate", "Revoked"]}}, "Marbles": {"backend": {"Package": "backend"}}, "Infrastructures": {"azure": {"AcceptedTCBStatuses": ["Outdated"]}}}`))
+	require.IsType(ManifestErrors{}, err)
+	paths := make([]string, 0, len(err.(ManifestErrors)))
+	for _, err := range err.(ManifestErrors) {
+		paths = append(paths, err.Path)
+	}
+	assert.Contains(paths, "$.Infrastructures.azure.AcceptedTCBStatuses[0]")
+	assert.Contains(paths, "$.Packages.backend.AcceptedTCBStatuses[1]")
+	assert.NotContains(paths, "$.Packages.backend.AcceptedTCBStatuses[0]")
+
+	err = ValidateManifest([]byte(`{"Packages": {"backend": {}}, "Marbles": {"backend": {"Package": "backend"}}, "MarbleGroups": {"cluster": ["backend", "frontend"]}}`))
+	assert.Equal(ManifestErrors{{Path: "$.MarbleGroups.cluster[1]", Message: `undefined marble "frontend"`}}, err)
+
+	err = ValidateManifest([]byte(`{"Marbles": {"frontend": {"Package": 1}}}`))
+	require.IsType(ManifestErrors{}, err)
+	assert.Contains(err.(ManifestErrors)[0].Path, "Package")
+}
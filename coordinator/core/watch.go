@@ -0,0 +1,261 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"context"
+	"crypto/x509"
+	"sort"
+
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// parameterWatcher is a marble that watches its parameters.
+type parameterWatcher struct {
+	marbleType string
+	marbleUUID uuid.UUID
+	csr        []byte
+	// attested is the record of the certificate the marble authenticated with
+	attested marbleCert
+	// pending is set if the parameters of the marble may have changed and haven't been pushed yet
+	pending bool
+	// updates receives the parameters that are pushed to the marble. It is buffered, so that pushing doesn't block, and only holds the latest update.
+	updates chan parameterUpdate
+}
+
+// parameterUpdate are the parameters that are pushed to a watching marble, or the error that ends its watch.
+type parameterUpdate struct {
+	params *rpc.Parameters
+	err    error
+}
+
+// WatchParameters implements the MarbleAPI function to stream the parameters of an activated marble whenever they change (implements the MarbleServer interface)
+//
+// The marble authenticates with its marble certificate, which must not be revoked.
+// The parameters are sent after a manifest update and after secrets the marble's type references have been written or rotated.
+// Each update contains a new marble certificate that is issued for req.CSR. Updates do not count towards the MaxActivations of the marble's type.
+// The stream ends with an error if the marble has been revoked or its activation has been released.
+func (c *Core) WatchParameters(req *rpc.WatchParametersReq, stream rpc.Marble_WatchParametersServer) error {
+	ctx := stream.Context()
+	logger := c.requestLogger(ctx)
+	tlsCert := getClientTLSCert(ctx)
+	if tlsCert == nil {
+		return status.Error(codes.Unauthenticated, "couldn't get marble TLS certificate")
+	}
+
+	watcher, err := c.addParameterWatcher(tlsCert, req.GetCSR())
+	if err != nil {
+		logger.Info("Rejected parameter watch", zap.Error(err))
+		return err
+	}
+	defer c.removeParameterWatcher(watcher)
+	logger = logger.With(zap.String("MarbleType", watcher.marbleType), zap.String("UUID", watcher.marbleUUID.String()))
+	logger.Info("Marble watches its parameters")
+
+	for {
+		var update parameterUpdate
+		select {
+		case <-ctx.Done():
+			return nil
+		case update = <-watcher.updates:
+		}
+		if update.err != nil {
+			return update.err
+		}
+		if err := stream.Send(&rpc.WatchParametersResp{Parameters: update.params}); err != nil {
+			return err
+		}
+		logger.Info("Pushed updated parameters to marble")
+	}
+}
+
+// addParameterWatcher registers the marble that authenticated with the certificate as a watcher of its parameters.
+func (c *Core) addParameterWatcher(tlsCert *x509.Certificate, csr []byte) (*parameterWatcher, error) {
+	defer c.mux.Unlock()
+	if err := c.requireState(stateAcceptingMarbles); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, "cannot watch parameters in current state")
+	}
+	if _, err := c.verifyMarbleCert(tlsCert); err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid marble certificate")
+	}
+	marbleUUID, err := uuid.Parse(tlsCert.Subject.CommonName)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid marble certificate")
+	}
+	if c.isRevokedMarble(marbleUUID.String()) {
+		return nil, status.Error(codes.Unauthenticated, "marble certificate has been revoked")
+	}
+	attested, ok := c.findMarbleCert(tlsCert.SerialNumber)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "unknown marble certificate")
+	}
+	marble, ok := c.activeMarbles[marbleUUID.String()]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "the activation of the marble has been released")
+	}
+
+	watcher := &parameterWatcher{marbleType: marble.MarbleType, marbleUUID: marbleUUID, csr: csr, attested: attested, updates: make(chan parameterUpdate, 1)}
+	if c.parameterWatchers == nil {
+		c.parameterWatchers = make(map[*parameterWatcher]struct{})
+	}
+	c.parameterWatchers[watcher] = struct{}{}
+	return watcher, nil
+}
+
+func (c *Core) removeParameterWatcher(watcher *parameterWatcher) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	delete(c.parameterWatchers, watcher)
+}
+
+// notifyParameterWatchers pushes the parameters to the watchers of the marble types whose parameters may have changed. All types are notified if marbleTypes is nil.
+//
+// The Core must be locked. The parameters are pushed asynchronously, because the state needs to be sealed to record the new marble certificates.
+func (c *Core) notifyParameterWatchers(marbleTypes map[string]bool) {
+	pending := false
+	for watcher := range c.parameterWatchers {
+		if marbleTypes == nil || marbleTypes[watcher.marbleType] {
+			watcher.pending = true
+			pending = true
+		}
+	}
+	if pending {
+		go c.pushParameters()
+	}
+}
+
+// marbleTypesReferencing returns the marble types whose parameters reference any of the named secrets.
+// The Core must be locked.
+func (c *Core) marbleTypesReferencing(names []string) map[string]bool {
+	marbleTypes := make(map[string]bool)
+	for marbleType, marble := range c.manifest.Marbles {
+		// the parameters have been checked when the manifest was set
+		referenced, _ := referencedSecrets(marble.Parameters)
+		for _, name := range referenced {
+			if contains(names, name) {
+				marbleTypes[marbleType] = true
+				break
+			}
+		}
+	}
+	return marbleTypes
+}
+
+// changedSecrets returns the names of the secrets that have been set or rotated between the old and the new secrets and versions.
+func changedSecrets(oldSecrets map[string]Secret, oldVersions map[string]uint, newSecrets map[string]Secret, newVersions map[string]uint) []string {
+	var names []string
+	for name := range newSecrets {
+		if _, ok := oldSecrets[name]; !ok || oldVersions[name] != newVersions[name] {
+			names = append(names, name)
+		}
+	}
+	// secrets that are generated per marble are only versioned
+	for name, version := range newVersions {
+		if _, ok := newSecrets[name]; !ok && oldVersions[name] != version {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// pushParameters pushes the current parameters to the pending watchers, each with a new marble certificate issued for its CSR.
+//
+// The certificates of all watchers are recorded with a single sealing of the state.
+func (c *Core) pushParameters() {
+	// the secrets of the secrets backend are fetched before the Core is locked
+	c.mux.RLock()
+	manifestVersion := len(c.rawUpdates)
+	marbleTypes := make(map[string]bool)
+	for watcher := range c.parameterWatchers {
+		if watcher.pending {
+			marbleTypes[watcher.marbleType] = true
+		}
+	}
+	c.mux.RUnlock()
+	backendSecrets := make(map[string]map[string]Secret)
+	backendErrs := make(map[string]error)
+	for marbleType := range marbleTypes {
+		backendSecrets[marbleType], backendErrs[marbleType] = c.marbleBackendSecrets(context.Background(), marbleType)
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	// the watchers have been notified again if the manifest has been updated in the meantime
+	if len(c.rawUpdates) != manifestVersion {
+		return
+	}
+
+	updates := make(map[*parameterWatcher]parameterUpdate)
+	oldMarbleCerts := c.marbleCerts
+	for watcher := range c.parameterWatchers {
+		// watchers of other types that have become pending in the meantime are updated by the next push
+		if !watcher.pending || !marbleTypes[watcher.marbleType] {
+			continue
+		}
+		watcher.pending = false
+		if err := backendErrs[watcher.marbleType]; err != nil {
+			updates[watcher] = parameterUpdate{err: err}
+			continue
+		}
+		updates[watcher] = c.updatedParameters(watcher, backendSecrets[watcher.marbleType])
+	}
+	if len(updates) == 0 {
+		return
+	}
+
+	if _, err := c.sealState(); err != nil {
+		c.marbleCerts = oldMarbleCerts
+		c.zaplogger.Error("sealState failed", zap.Error(err))
+		for watcher := range updates {
+			updates[watcher] = parameterUpdate{err: status.Error(codes.Unavailable, "failed to persist state")}
+		}
+	}
+	for watcher, update := range updates {
+		// an update that hasn't been received yet is replaced by the newer one
+		select {
+		case <-watcher.updates:
+		default:
+		}
+		watcher.updates <- update
+	}
+}
+
+// updatedParameters returns the current parameters of the watching marble with a new marble certificate issued for its CSR, which is recorded.
+// backendSecrets are the secrets of the secrets backend its parameters reference. The Core must be locked.
+func (c *Core) updatedParameters(watcher *parameterWatcher, backendSecrets map[string]Secret) parameterUpdate {
+	if c.state != stateAcceptingMarbles {
+		return parameterUpdate{err: status.Error(codes.FailedPrecondition, "cannot push parameters in current state")}
+	}
+	if c.isRevokedMarble(watcher.marbleUUID.String()) {
+		return parameterUpdate{err: status.Error(codes.Unauthenticated, "marble certificate has been revoked")}
+	}
+	if _, ok := c.activeMarbles[watcher.marbleUUID.String()]; !ok {
+		return parameterUpdate{err: status.Error(codes.NotFound, "the activation of the marble has been released")}
+	}
+	// like renewals, the pushed certificates aren't attested
+	if err := c.checkMarbleAttestation(watcher.attested); err != nil {
+		return parameterUpdate{err: err}
+	}
+
+	logger := c.zaplogger.With(zap.String("MarbleType", watcher.marbleType), zap.String("UUID", watcher.marbleUUID.String()))
+	authSecrets, err := c.generateMarbleAuthSecrets(watcher.csr, watcher.marbleType, watcher.marbleUUID)
+	if err != nil {
+		return parameterUpdate{err: err}
+	}
+	params, err := c.marbleParameters(context.Background(), watcher.marbleType, watcher.marbleUUID, authSecrets, backendSecrets, logger)
+	if err != nil {
+		return parameterUpdate{err: err}
+	}
+	// the new certificate must be recorded, so that it can be revoked
+	c.recordMarbleCert((*x509.Certificate)(&authSecrets.MarbleCert.Cert), watcher.marbleType, watcher.attested.Package, watcher.attested.SecurityVersion)
+	return parameterUpdate{params: params}
+}
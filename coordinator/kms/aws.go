@@ -0,0 +1,173 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package kms
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AWS wraps keys with a key of the AWS Key Management Service.
+//
+// It authenticates with the credentials in the environment variables AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+type AWS struct {
+	keyID           string
+	region          string
+	endpoint        string
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+}
+
+type awsEncryptReq struct {
+	KeyID     string `json:"KeyId"`
+	Plaintext []byte
+}
+
+type awsEncryptResp struct {
+	CiphertextBlob []byte
+}
+
+type awsDecryptReq struct {
+	KeyID          string `json:"KeyId"`
+	CiphertextBlob []byte
+}
+
+type awsDecryptResp struct {
+	Plaintext []byte
+}
+
+// NewAWS creates a driver for the AWS KMS key keyID, which is an ID, ARN or alias, in region.
+func NewAWS(keyID string, region string) (*AWS, error) {
+	k := &AWS{
+		keyID:           keyID,
+		region:          region,
+		endpoint:        fmt.Sprintf("https://kms.%s.amazonaws.com/", region),
+		accessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if k.accessKeyID == "" || k.secretAccessKey == "" {
+		return nil, errors.New("AWS credentials are not set")
+	}
+	return k, nil
+}
+
+// WrapKey implements the core.KMS interface
+func (k *AWS) WrapKey(key []byte) ([]byte, error) {
+	var resp awsEncryptResp
+	if err := k.call("Encrypt", awsEncryptReq{KeyID: k.keyID, Plaintext: key}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.CiphertextBlob, nil
+}
+
+// UnwrapKey implements the core.KMS interface
+func (k *AWS) UnwrapKey(wrappedKey []byte) ([]byte, error) {
+	var resp awsDecryptResp
+	if err := k.call("Decrypt", awsDecryptReq{KeyID: k.keyID, CiphertextBlob: wrappedKey}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Plaintext, nil
+}
+
+// call invokes an action of the KMS API with a request signed with AWS Signature Version 4.
+func (k *AWS) call(action string, req interface{}, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, k.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	httpReq.Header.Set("X-Amz-Target", "TrentService."+action)
+	k.sign(httpReq, body, "kms", time.Now().UTC())
+
+	httpResp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	respBody, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return err
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return fmt.Errorf("AWS KMS %v: %v: %s", action, httpResp.Status, bytes.TrimSpace(respBody))
+	}
+	return json.Unmarshal(respBody, resp)
+}
+
+// sign adds the authorization header of AWS Signature Version 4 to req.
+func (k *AWS) sign(req *http.Request, body []byte, service string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if k.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", k.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	bodyHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, canonicalQuery(req.URL.Query()), canonicalHeaders.String(), signedHeaders, hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+
+	scope := date + "/" + k.region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+k.secretAccessKey), date)
+	for _, part := range []string{k.region, service, "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", k.accessKeyID, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(query url.Values) string {
+	// url.Values.Encode sorts by key, but escapes spaces as "+"
+	return strings.Replace(query.Encode(), "+", "%20", -1)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,56 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package kms
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// azureTokenURL is the endpoint of the Azure Instance Metadata Service that issues tokens for the managed identity of the VM.
+var azureTokenURL = "http://169.254.169.254/metadata/identity/oauth2/token?api-version=2018-02-01&resource=https%3A%2F%2Fvault.azure.net"
+
+// Azure wraps keys with an RSA key in Azure Key Vault.
+//
+// It authenticates with the managed identity of the VM or pod.
+type Azure struct {
+	keyURL string
+}
+
+type azureKeyOperation struct {
+	Alg   string `json:"alg,omitempty"`
+	Value string `json:"value"`
+}
+
+// NewAzure creates a driver for the Key Vault key at keyURL, e.g., https://myvault.vault.azure.net/keys/mykey/version.
+func NewAzure(keyURL string) *Azure {
+	return &Azure{keyURL: strings.TrimSuffix(keyURL, "/")}
+}
+
+// WrapKey implements the core.KMS interface
+func (k *Azure) WrapKey(key []byte) ([]byte, error) {
+	return k.call("wrapkey", key)
+}
+
+// UnwrapKey implements the core.KMS interface
+func (k *Azure) UnwrapKey(wrappedKey []byte) ([]byte, error) {
+	return k.call("unwrapkey", wrappedKey)
+}
+
+func (k *Azure) call(operation string, value []byte) ([]byte, error) {
+	token, err := fetchToken(azureTokenURL, http.Header{"Metadata": {"true"}})
+	if err != nil {
+		return nil, err
+	}
+	req := azureKeyOperation{Alg: "RSA-OAEP-256", Value: base64.RawURLEncoding.EncodeToString(value)}
+	var resp azureKeyOperation
+	if err := doJSON(http.MethodPost, k.keyURL+"/"+operation+"?api-version=7.2", http.Header{"Authorization": {"Bearer " + token}}, req, &resp); err != nil {
+		return nil, err
+	}
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(resp.Value, "="))
+}
@@ -0,0 +1,71 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package kms
+
+import (
+	"net/http"
+)
+
+// gcpTokenURL is the endpoint of the GCE metadata server that issues tokens for the service account of the VM.
+var gcpTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// gcpEndpoint is the endpoint of the Cloud KMS API.
+var gcpEndpoint = "https://cloudkms.googleapis.com/v1/"
+
+// GCP wraps keys with a symmetric key of Google Cloud KMS.
+//
+// It authenticates with the service account of the VM or workload.
+type GCP struct {
+	keyName string
+}
+
+type gcpEncryptReq struct {
+	Plaintext []byte `json:"plaintext"`
+}
+
+type gcpEncryptResp struct {
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+type gcpDecryptReq struct {
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+type gcpDecryptResp struct {
+	Plaintext []byte `json:"plaintext"`
+}
+
+// NewGCP creates a driver for the Cloud KMS key keyName, i.e., projects/PROJECT/locations/LOCATION/keyRings/RING/cryptoKeys/KEY.
+func NewGCP(keyName string) *GCP {
+	return &GCP{keyName: keyName}
+}
+
+// WrapKey implements the core.KMS interface
+func (k *GCP) WrapKey(key []byte) ([]byte, error) {
+	var resp gcpEncryptResp
+	if err := k.call("encrypt", gcpEncryptReq{Plaintext: key}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Ciphertext, nil
+}
+
+// UnwrapKey implements the core.KMS interface
+func (k *GCP) UnwrapKey(wrappedKey []byte) ([]byte, error) {
+	var resp gcpDecryptResp
+	if err := k.call("decrypt", gcpDecryptReq{Ciphertext: wrappedKey}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Plaintext, nil
+}
+
+func (k *GCP) call(method string, req interface{}, resp interface{}) error {
+	token, err := fetchToken(gcpTokenURL, http.Header{"Metadata-Flavor": {"Google"}})
+	if err != nil {
+		return err
+	}
+	return doJSON(http.MethodPost, gcpEndpoint+k.keyName+":"+method, http.Header{"Authorization": {"Bearer " + token}}, req, resp)
+}
@@ -0,0 +1,69 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package kms implements the drivers for the key management services that can wrap the state encryption key of the Coordinator.
+//
+// The drivers use the REST APIs of the services and authenticate with the credentials of the cloud environment.
+package kms
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// doJSON sends a request with an optional JSON body and decodes the JSON response into resp.
+func doJSON(method string, url string, header http.Header, req interface{}, resp interface{}) error {
+	var body []byte
+	if req != nil {
+		var err error
+		if body, err = json.Marshal(req); err != nil {
+			return err
+		}
+	}
+	httpReq, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		httpReq.Header[key] = values
+	}
+	if req != nil && httpReq.Header.Get("Content-Type") == "" {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+
+	httpResp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	respBody, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return err
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v: %s", httpResp.Status, bytes.TrimSpace(respBody))
+	}
+	return json.Unmarshal(respBody, resp)
+}
+
+type tokenResp struct {
+	AccessToken string `json:"access_token"`
+}
+
+// fetchToken gets an OAuth access token from the metadata service of a cloud VM.
+func fetchToken(url string, header http.Header) (string, error) {
+	var resp tokenResp
+	if err := doJSON(http.MethodGet, url, header, nil, &resp); err != nil {
+		return "", fmt.Errorf("cannot get an access token: %v", err)
+	}
+	return resp.AccessToken, nil
+}
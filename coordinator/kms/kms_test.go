@@ -0,0 +1,149 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package kms
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// reverse is the "encryption" of the fake services.
+func reverse(data []byte) []byte {
+	result := make([]byte, len(data))
+	for i, b := range data {
+		result[len(data)-1-i] = b
+	}
+	return result
+}
+
+func TestAWSSignature(t *testing.T) {
+	// example from the AWS documentation of Signature Version 4
+	req, err := http.NewRequest(http.MethodGet, "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	k := &AWS{region: "us-east-1", accessKeyID: "AKIDEXAMPLE", secretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	k.sign(req, nil, "iam", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	assert.Equal(t,
+		"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		req.Header.Get("Authorization"))
+}
+
+func TestAWS(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.True(strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=id/"))
+		assert.Equal("token", r.Header.Get("X-Amz-Security-Token"))
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.Encrypt":
+			var req awsEncryptReq
+			require.NoError(json.NewDecoder(r.Body).Decode(&req))
+			assert.Equal("alias/marblerun", req.KeyID)
+			json.NewEncoder(w).Encode(awsEncryptResp{CiphertextBlob: reverse(req.Plaintext)})
+		case "TrentService.Decrypt":
+			var req awsDecryptReq
+			require.NoError(json.NewDecoder(r.Body).Decode(&req))
+			json.NewEncoder(w).Encode(awsDecryptResp{Plaintext: reverse(req.CiphertextBlob)})
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	k := &AWS{keyID: "alias/marblerun", region: "eu-central-1", endpoint: server.URL, accessKeyID: "id", secretAccessKey: "secret", sessionToken: "token"}
+	wrapped, err := k.WrapKey([]byte("key"))
+	require.NoError(err)
+	assert.Equal([]byte("yek"), wrapped)
+	key, err := k.UnwrapKey(wrapped)
+	require.NoError(err)
+	assert.Equal([]byte("key"), key)
+}
+
+func TestAzure(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			assert.Equal("true", r.Header.Get("Metadata"))
+			json.NewEncoder(w).Encode(tokenResp{AccessToken: "token"})
+			return
+		}
+		assert.Equal("Bearer token", r.Header.Get("Authorization"))
+		assert.Equal("7.2", r.URL.Query().Get("api-version"))
+		var req azureKeyOperation
+		require.NoError(json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal("RSA-OAEP-256", req.Alg)
+		value, err := base64.RawURLEncoding.DecodeString(req.Value)
+		require.NoError(err)
+		switch r.URL.Path {
+		case "/keys/marblerun/1/wrapkey", "/keys/marblerun/1/unwrapkey":
+			json.NewEncoder(w).Encode(azureKeyOperation{Value: base64.RawURLEncoding.EncodeToString(reverse(value))})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	defer func(url string) { azureTokenURL = url }(azureTokenURL)
+	azureTokenURL = server.URL + "/token"
+
+	k := NewAzure(server.URL + "/keys/marblerun/1/")
+	wrapped, err := k.WrapKey([]byte("key"))
+	require.NoError(err)
+	assert.Equal([]byte("yek"), wrapped)
+	key, err := k.UnwrapKey(wrapped)
+	require.NoError(err)
+	assert.Equal([]byte("key"), key)
+}
+
+func TestGCP(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	const keyName = "projects/p/locations/global/keyRings/r/cryptoKeys/marblerun"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			assert.Equal("Google", r.Header.Get("Metadata-Flavor"))
+			json.NewEncoder(w).Encode(tokenResp{AccessToken: "token"})
+		case "/v1/" + keyName + ":encrypt":
+			assert.Equal("Bearer token", r.Header.Get("Authorization"))
+			var req gcpEncryptReq
+			require.NoError(json.NewDecoder(r.Body).Decode(&req))
+			json.NewEncoder(w).Encode(gcpEncryptResp{Ciphertext: reverse(req.Plaintext)})
+		case "/v1/" + keyName + ":decrypt":
+			var req gcpDecryptReq
+			require.NoError(json.NewDecoder(r.Body).Decode(&req))
+			json.NewEncoder(w).Encode(gcpDecryptResp{Plaintext: reverse(req.Ciphertext)})
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+	defer func(tokenURL, endpoint string) { gcpTokenURL, gcpEndpoint = tokenURL, endpoint }(gcpTokenURL, gcpEndpoint)
+	gcpTokenURL, gcpEndpoint = server.URL+"/token", server.URL+"/v1/"
+
+	k := NewGCP(keyName)
+	wrapped, err := k.WrapKey([]byte("key"))
+	require.NoError(err)
+	assert.Equal([]byte("yek"), wrapped)
+	key, err := k.UnwrapKey(wrapped)
+	require.NoError(err)
+	assert.Equal([]byte("key"), key)
+
+	_, err = NewGCP("projects/p/other").WrapKey([]byte("key"))
+	assert.Error(err)
+}
@@ -0,0 +1,145 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// CAPublishInterval is the interval in which the CA certificates are published, so that objects that have been deleted or modified are restored.
+const CAPublishInterval = time.Minute
+
+// CASource provides the CA certificates that are published, see core.Core.CACertificates.
+type CASource interface {
+	CACertificates() (root string, intermediates map[string]string, err error)
+}
+
+// validKey matches the valid keys of the data of a Secret or ConfigMap.
+var validKey = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+
+// CAPublisher publishes the Coordinator's CA certificates into a Secret and a ConfigMap in each of a set of namespaces,
+// so that ingress controllers and services that are not marbles can verify marble certificates.
+//
+// Both contain the root certificate as ca.crt, the intermediate CA of each package as <package>.crt and all of them as ca-bundle.crt.
+// The Secret can be referenced by ingress controllers that expect a CA in a Secret, e.g., ingress-nginx's auth-tls-secret.
+type CAPublisher struct {
+	client     *Client
+	source     CASource
+	name       string
+	namespaces map[string][]string
+	zapLogger  *zap.Logger
+}
+
+// NewCAPublisher creates a CAPublisher for the Secret and ConfigMap name in the namespaces.
+//
+// namespaces maps each namespace to the packages whose intermediate CAs are published into it, see ParseCANamespaces.
+// The intermediate CAs of all packages are published into namespaces without packages.
+func NewCAPublisher(client *Client, source CASource, name string, namespaces map[string][]string, zapLogger *zap.Logger) *CAPublisher {
+	return &CAPublisher{client: client, source: source, name: name, namespaces: namespaces, zapLogger: zapLogger}
+}
+
+// ParseCANamespaces parses comma-separated namespaces, each optionally followed by "=" and the packages
+// whose intermediate CAs are published into it separated by "+", e.g., "ingress-nginx,emojivoto=web+emoji".
+func ParseCANamespaces(namespaces string) (map[string][]string, error) {
+	result := make(map[string][]string)
+	for _, namespace := range strings.Split(namespaces, ",") {
+		parts := strings.SplitN(strings.TrimSpace(namespace), "=", 2)
+		if parts[0] == "" {
+			return nil, fmt.Errorf("invalid namespace: %v", namespace)
+		}
+		var packages []string
+		if len(parts) == 2 {
+			packages = strings.Split(parts[1], "+")
+		}
+		result[parts[0]] = packages
+	}
+	return result, nil
+}
+
+// Run publishes the certificates in CAPublishInterval until ctx is done.
+func (p *CAPublisher) Run(ctx context.Context) {
+	ticker := time.NewTicker(CAPublishInterval)
+	defer ticker.Stop()
+	for {
+		p.Publish()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Publish publishes the certificates into all namespaces. Failures are logged, as they are retried by Run.
+//
+// Nothing is published until the manifest has been set.
+func (p *CAPublisher) Publish() {
+	root, intermediates, err := p.source.CACertificates()
+	if err != nil {
+		return
+	}
+	for namespace, packages := range p.namespaces {
+		if err := p.publish(namespace, root, selectPackages(intermediates, packages)); err != nil {
+			p.zapLogger.Warn("cannot publish the CA certificates", zap.String("namespace", namespace), zap.Error(err))
+		}
+	}
+}
+
+func (p *CAPublisher) publish(namespace string, root string, intermediates map[string]string) error {
+	data := map[string]string{"ca.crt": root}
+	bundle := root
+	pkgs := make([]string, 0, len(intermediates))
+	for pkg := range intermediates {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+	for _, pkg := range pkgs {
+		bundle += intermediates[pkg]
+		if key := pkg + ".crt"; validKey.MatchString(key) && key != "ca.crt" && key != "ca-bundle.crt" {
+			data[key] = intermediates[pkg]
+		}
+	}
+	data["ca-bundle.crt"] = bundle
+
+	metadata := map[string]interface{}{
+		"name":      p.name,
+		"namespace": namespace,
+		"labels":    map[string]string{"app.kubernetes.io/managed-by": "marblerun"},
+	}
+	secretData := make(map[string][]byte, len(data))
+	for key, value := range data {
+		secretData[key] = []byte(value)
+	}
+
+	secret := map[string]interface{}{"apiVersion": "v1", "kind": "Secret", "metadata": metadata, "type": "Opaque", "data": secretData}
+	if err := p.client.Apply(fmt.Sprintf("/api/v1/namespaces/%s/secrets/%s", namespace, p.name), secret); err != nil {
+		return err
+	}
+	configMap := map[string]interface{}{"apiVersion": "v1", "kind": "ConfigMap", "metadata": metadata, "data": data}
+	return p.client.Apply(fmt.Sprintf("/api/v1/namespaces/%s/configmaps/%s", namespace, p.name), configMap)
+}
+
+// selectPackages returns the intermediate CAs of the packages, or all of them if packages is empty.
+func selectPackages(intermediates map[string]string, packages []string) map[string]string {
+	if len(packages) == 0 {
+		return intermediates
+	}
+	selected := make(map[string]string)
+	for _, pkg := range packages {
+		if cert, ok := intermediates[pkg]; ok {
+			selected[pkg] = cert
+		}
+	}
+	return selected
+}
@@ -0,0 +1,110 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package kubernetes
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type fakeCASource struct {
+	root          string
+	intermediates map[string]string
+	err           error
+}
+
+func (s fakeCASource) CACertificates() (string, map[string]string, error) {
+	return s.root, s.intermediates, s.err
+}
+
+type object struct {
+	Kind string            `json:"kind"`
+	Data map[string]string `json:"data"`
+}
+
+func TestCAPublisher(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	tokenFile, err := ioutil.TempFile("", "")
+	require.NoError(err)
+	defer os.Remove(tokenFile.Name())
+	_, err = tokenFile.WriteString("token")
+	require.NoError(err)
+	tokenFile.Close()
+
+	var mux sync.Mutex
+	objects := make(map[string]object)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("Bearer token", r.Header.Get("Authorization"))
+		mux.Lock()
+		defer mux.Unlock()
+		var obj object
+		require.NoError(json.NewDecoder(r.Body).Decode(&obj))
+		switch r.Method {
+		case http.MethodPost:
+			// the name is taken from the metadata
+			objects[r.URL.Path] = obj
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodPatch:
+			assert.Equal("application/merge-patch+json", r.Header.Get("Content-Type"))
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	source := fakeCASource{root: "root", intermediates: map[string]string{"web": "web-ca", "emoji": "emoji-ca", "invalid/name": "invalid-ca"}}
+	namespaces, err := ParseCANamespaces("ingress-nginx,emojivoto=web")
+	require.NoError(err)
+	publisher := NewCAPublisher(NewClient(server.URL, tokenFile.Name(), nil), source, "marblerun-ca", namespaces, zap.NewNop())
+	publisher.Publish()
+
+	assert.Len(objects, 4)
+	secret := objects["/api/v1/namespaces/ingress-nginx/secrets"]
+	assert.Equal("Secret", secret.Kind)
+	// the data of a Secret is base64-encoded
+	assert.Equal("cm9vdA==", secret.Data["ca.crt"])
+
+	configMap := objects["/api/v1/namespaces/ingress-nginx/configmaps"]
+	assert.Equal("ConfigMap", configMap.Kind)
+	assert.Equal(map[string]string{
+		"ca.crt":        "root",
+		"emoji.crt":     "emoji-ca",
+		"web.crt":       "web-ca",
+		"ca-bundle.crt": "rootemoji-cainvalid-caweb-ca",
+	}, configMap.Data)
+
+	configMap = objects["/api/v1/namespaces/emojivoto/configmaps"]
+	assert.Equal(map[string]string{"ca.crt": "root", "web.crt": "web-ca", "ca-bundle.crt": "rootweb-ca"}, configMap.Data)
+
+	// nothing is published before the manifest has been set
+	objects = make(map[string]object)
+	publisher.source = fakeCASource{err: errors.New("server is not in expected state")}
+	publisher.Publish()
+	assert.Empty(objects)
+}
+
+func TestParseCANamespaces(t *testing.T) {
+	assert := assert.New(t)
+
+	namespaces, err := ParseCANamespaces("default, emojivoto=web+emoji")
+	assert.NoError(err)
+	assert.Equal(map[string][]string{"default": nil, "emojivoto": {"web", "emoji"}}, namespaces)
+
+	_, err = ParseCANamespaces("default,")
+	assert.Error(err)
+}
@@ -0,0 +1,123 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package kubernetes implements the parts of the Kubernetes API that the Coordinator uses.
+package kubernetes
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// serviceAccountDir contains the credentials of the service account of a pod.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Client sends requests to the Kubernetes API server.
+type Client struct {
+	apiServer string
+	tokenFile string
+	client    *http.Client
+}
+
+// NewClient creates a Client for the API server at the URL apiServer.
+//
+// The client authenticates with the bearer token in tokenFile, which is read for each request, as the token may be rotated.
+func NewClient(apiServer string, tokenFile string, tlsConfig *tls.Config) *Client {
+	return &Client{
+		apiServer: strings.TrimSuffix(apiServer, "/"),
+		tokenFile: tokenFile,
+		client:    &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+	}
+}
+
+// NewInClusterClient creates a Client using the credentials of the pod's service account and returns the namespace of the pod.
+func NewInClusterClient() (*Client, string, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, "", errors.New("not running in a Kubernetes cluster")
+	}
+	namespace, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+	if err != nil {
+		return nil, "", err
+	}
+	caPEM, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, "", err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		return nil, "", errors.New("invalid Kubernetes CA certificate")
+	}
+	apiServer := "https://" + net.JoinHostPort(host, port)
+	tlsConfig := &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+	return NewClient(apiServer, filepath.Join(serviceAccountDir, "token"), tlsConfig), string(bytes.TrimSpace(namespace)), nil
+}
+
+// Request sends a request with the JSON-encoded req to the path of the API server, e.g., /api/v1/namespaces/default/secrets,
+// decodes the response into resp and returns its status code.
+//
+// req and resp may be nil. A status code other than 2xx is returned with an error.
+func (c *Client) Request(method string, path string, contentType string, req interface{}, resp interface{}) (int, error) {
+	var body []byte
+	if req != nil {
+		var err error
+		if body, err = json.Marshal(req); err != nil {
+			return 0, err
+		}
+	}
+	httpReq, err := http.NewRequest(method, c.apiServer+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	if contentType != "" {
+		httpReq.Header.Set("Content-Type", contentType)
+	}
+	token, err := ioutil.ReadFile(c.tokenFile)
+	if err != nil {
+		return 0, err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+string(bytes.TrimSpace(token)))
+
+	httpResp, err := c.client.Do(httpReq)
+	if err != nil {
+		return 0, err
+	}
+	defer httpResp.Body.Close()
+	respBody, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return httpResp.StatusCode, err
+	}
+	if httpResp.StatusCode < 200 || httpResp.StatusCode > 299 {
+		return httpResp.StatusCode, fmt.Errorf("kubernetes: %v: %s", httpResp.Status, bytes.TrimSpace(respBody))
+	}
+	if resp == nil {
+		return httpResp.StatusCode, nil
+	}
+	return httpResp.StatusCode, json.Unmarshal(respBody, resp)
+}
+
+// Apply creates the object at path, e.g., /api/v1/namespaces/default/secrets/name, or merges obj into it if it exists.
+//
+// obj must contain the apiVersion, kind and metadata.name of the object.
+func (c *Client) Apply(path string, obj interface{}) error {
+	status, err := c.Request(http.MethodPatch, path, "application/merge-patch+json", obj, nil)
+	if status != http.StatusNotFound {
+		return err
+	}
+	_, err = c.Request(http.MethodPost, path[:strings.LastIndex(path, "/")], "application/json", obj, nil)
+	return err
+}
@@ -0,0 +1,498 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package pkcs11 holds the root key of the Coordinator in an HSM that is accessed through a PKCS#11 module.
+//
+// The module is loaded at runtime, so the Coordinator doesn't depend on the library of a specific vendor.
+// Only the functions required to generate, find and use ECDSA keys are bound.
+package pkcs11
+
+/*
+#cgo LDFLAGS: -ldl
+#include <dlfcn.h>
+#include <stdlib.h>
+#include <string.h>
+
+typedef unsigned long ck_ulong;
+
+typedef struct {
+	ck_ulong type;
+	void *value;
+	ck_ulong value_len;
+} ck_attribute;
+
+typedef struct {
+	ck_ulong mechanism;
+	void *parameter;
+	ck_ulong parameter_len;
+} ck_mechanism;
+
+typedef struct {
+	void *create_mutex;
+	void *destroy_mutex;
+	void *lock_mutex;
+	void *unlock_mutex;
+	ck_ulong flags;
+	void *reserved;
+} ck_initialize_args;
+
+// ck_function_list mirrors CK_FUNCTION_LIST. The functions are accessed by their index in the list.
+typedef struct {
+	unsigned char major;
+	unsigned char minor;
+	void *fn[68];
+} ck_function_list;
+
+enum {
+	fnInitialize = 0,
+	fnFinalize = 1,
+	fnGetSlotList = 4,
+	fnGetTokenInfo = 6,
+	fnOpenSession = 12,
+	fnCloseSession = 13,
+	fnLogin = 18,
+	fnGetAttributeValue = 24,
+	fnFindObjectsInit = 26,
+	fnFindObjects = 27,
+	fnFindObjectsFinal = 28,
+	fnSignInit = 42,
+	fnSign = 43,
+	fnGenerateKeyPair = 59,
+};
+
+static ck_ulong load(const char *path, void **handle, ck_function_list **list) {
+	*handle = dlopen(path, RTLD_NOW | RTLD_LOCAL);
+	if (!*handle)
+		return (ck_ulong)-1;
+	ck_ulong (*get_function_list)(ck_function_list **) = dlsym(*handle, "C_GetFunctionList");
+	if (!get_function_list) {
+		dlclose(*handle);
+		return (ck_ulong)-1;
+	}
+	return get_function_list(list);
+}
+
+static void unload(void *handle) {
+	dlclose(handle);
+}
+
+static ck_ulong initialize(ck_function_list *l) {
+	ck_initialize_args args;
+	memset(&args, 0, sizeof(args));
+	args.flags = 2; // CKF_OS_LOCKING_OK
+	return ((ck_ulong (*)(void *))l->fn[fnInitialize])(&args);
+}
+
+static ck_ulong finalize(ck_function_list *l) {
+	return ((ck_ulong (*)(void *))l->fn[fnFinalize])(NULL);
+}
+
+static ck_ulong get_slot_list(ck_function_list *l, ck_ulong *slots, ck_ulong *count) {
+	return ((ck_ulong (*)(unsigned char, ck_ulong *, ck_ulong *))l->fn[fnGetSlotList])(1, slots, count);
+}
+
+static ck_ulong get_token_label(ck_function_list *l, ck_ulong slot, char *label) {
+	// CK_TOKEN_INFO starts with the 32 byte label
+	unsigned char info[512];
+	ck_ulong rv = ((ck_ulong (*)(ck_ulong, void *))l->fn[fnGetTokenInfo])(slot, info);
+	memcpy(label, info, 32);
+	return rv;
+}
+
+static ck_ulong open_session(ck_function_list *l, ck_ulong slot, ck_ulong *session) {
+	// CKF_SERIAL_SESSION | CKF_RW_SESSION
+	return ((ck_ulong (*)(ck_ulong, ck_ulong, void *, void *, ck_ulong *))l->fn[fnOpenSession])(slot, 4 | 2, NULL, NULL, session);
+}
+
+static ck_ulong close_session(ck_function_list *l, ck_ulong session) {
+	return ((ck_ulong (*)(ck_ulong))l->fn[fnCloseSession])(session);
+}
+
+static ck_ulong login(ck_function_list *l, ck_ulong session, char *pin, ck_ulong pin_len) {
+	// CKU_USER
+	return ((ck_ulong (*)(ck_ulong, ck_ulong, char *, ck_ulong))l->fn[fnLogin])(session, 1, pin, pin_len);
+}
+
+static ck_ulong get_attribute_value(ck_function_list *l, ck_ulong session, ck_ulong object, ck_attribute *attrs, ck_ulong count) {
+	return ((ck_ulong (*)(ck_ulong, ck_ulong, ck_attribute *, ck_ulong))l->fn[fnGetAttributeValue])(session, object, attrs, count);
+}
+
+static ck_ulong find_objects(ck_function_list *l, ck_ulong session, ck_attribute *attrs, ck_ulong count, ck_ulong *objects, ck_ulong max, ck_ulong *found) {
+	ck_ulong rv = ((ck_ulong (*)(ck_ulong, ck_attribute *, ck_ulong))l->fn[fnFindObjectsInit])(session, attrs, count);
+	if (rv)
+		return rv;
+	rv = ((ck_ulong (*)(ck_ulong, ck_ulong *, ck_ulong, ck_ulong *))l->fn[fnFindObjects])(session, objects, max, found);
+	ck_ulong final_rv = ((ck_ulong (*)(ck_ulong))l->fn[fnFindObjectsFinal])(session);
+	return rv ? rv : final_rv;
+}
+
+static ck_ulong sign(ck_function_list *l, ck_ulong session, ck_mechanism *mech, ck_ulong key, unsigned char *data, ck_ulong data_len, unsigned char *sig, ck_ulong *sig_len) {
+	ck_ulong rv = ((ck_ulong (*)(ck_ulong, ck_mechanism *, ck_ulong))l->fn[fnSignInit])(session, mech, key);
+	if (rv)
+		return rv;
+	return ((ck_ulong (*)(ck_ulong, unsigned char *, ck_ulong, unsigned char *, ck_ulong *))l->fn[fnSign])(session, data, data_len, sig, sig_len);
+}
+
+static ck_ulong generate_key_pair(ck_function_list *l, ck_ulong session, ck_mechanism *mech, ck_attribute *pub, ck_ulong pub_count, ck_attribute *priv, ck_ulong priv_count, ck_ulong *pub_key, ck_ulong *priv_key) {
+	return ((ck_ulong (*)(ck_ulong, ck_mechanism *, ck_attribute *, ck_ulong, ck_attribute *, ck_ulong, ck_ulong *, ck_ulong *))l->fn[fnGenerateKeyPair])(session, mech, pub, pub_count, priv, priv_count, pub_key, priv_key);
+}
+*/
+import "C"
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+	"unsafe"
+)
+
+// PKCS#11 constants
+const (
+	ckaClass       = 0x0
+	ckaToken       = 0x1
+	ckaPrivate     = 0x2
+	ckaLabel       = 0x3
+	ckaKeyType     = 0x100
+	ckaID          = 0x102
+	ckaSensitive   = 0x103
+	ckaSign        = 0x108
+	ckaVerify      = 0x10a
+	ckaExtractable = 0x162
+	ckaECParams    = 0x180
+	ckaECPoint     = 0x181
+
+	ckoPublicKey  = 0x2
+	ckoPrivateKey = 0x3
+	ckkEC         = 0x3
+
+	ckmECKeyPairGen = 0x1040
+	ckmECDSA        = 0x1041
+
+	ckrOK                         = 0x0
+	ckrUserAlreadyLoggedIn        = 0x100
+	ckrCryptokiAlreadyInitialized = 0x191
+	ckrBufferTooSmall             = 0x150
+)
+
+// keyLabel is the label of the keys the Coordinator generates.
+const keyLabel = "marblerun-coordinator-root"
+
+// oidP256 is the DER encoding of the OID of the P-256 curve, which is the CKA_EC_PARAMS of the keys.
+var oidP256 = []byte{0x06, 0x08, 0x2a, 0x86, 0x48, 0xce, 0x3d, 0x03, 0x01, 0x07}
+
+// Error is an error returned by a function of the PKCS#11 module.
+type Error struct {
+	Function string
+	Code     uint
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("pkcs11: %v failed with 0x%x", e.Function, e.Code)
+}
+
+func check(function string, rv C.ck_ulong) error {
+	if rv == ckrOK {
+		return nil
+	}
+	return &Error{Function: function, Code: uint(rv)}
+}
+
+// Token is a session with a token of a PKCS#11 module. It implements the core.RootKeyStore interface.
+//
+// PKCS#11 sessions can't be used concurrently, so all operations are serialized.
+type Token struct {
+	mux     sync.Mutex
+	handle  unsafe.Pointer
+	list    *C.ck_function_list
+	session C.ck_ulong
+}
+
+// Open loads the PKCS#11 module at modulePath and logs in to the token with tokenLabel with the user pin.
+func Open(modulePath string, tokenLabel string, pin string) (*Token, error) {
+	t := &Token{}
+	cPath := C.CString(modulePath)
+	defer C.free(unsafe.Pointer(cPath))
+	if rv := C.load(cPath, &t.handle, &t.list); rv != ckrOK {
+		if t.handle == nil {
+			return nil, fmt.Errorf("pkcs11: cannot load module %v", modulePath)
+		}
+		C.unload(t.handle)
+		return nil, check("C_GetFunctionList", rv)
+	}
+	if rv := C.initialize(t.list); rv != ckrOK && rv != ckrCryptokiAlreadyInitialized {
+		C.unload(t.handle)
+		return nil, check("C_Initialize", rv)
+	}
+
+	slot, err := t.findSlot(tokenLabel)
+	if err == nil {
+		err = check("C_OpenSession", C.open_session(t.list, slot, &t.session))
+	}
+	if err == nil {
+		cPin := C.CString(pin)
+		rv := C.login(t.list, t.session, cPin, C.ck_ulong(len(pin)))
+		C.free(unsafe.Pointer(cPin))
+		if rv != ckrUserAlreadyLoggedIn {
+			err = check("C_Login", rv)
+		}
+		if err != nil {
+			C.close_session(t.list, t.session)
+		}
+	}
+	if err != nil {
+		C.finalize(t.list)
+		C.unload(t.handle)
+		return nil, err
+	}
+	return t, nil
+}
+
+// Close closes the session and unloads the module.
+func (t *Token) Close() {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	C.close_session(t.list, t.session)
+	C.finalize(t.list)
+	C.unload(t.handle)
+}
+
+func (t *Token) findSlot(tokenLabel string) (C.ck_ulong, error) {
+	var count C.ck_ulong
+	if err := check("C_GetSlotList", C.get_slot_list(t.list, nil, &count)); err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, errors.New("pkcs11: no token present")
+	}
+	slots := (*C.ck_ulong)(C.calloc(C.size_t(count), C.size_t(unsafe.Sizeof(C.ck_ulong(0)))))
+	defer C.free(unsafe.Pointer(slots))
+	if err := check("C_GetSlotList", C.get_slot_list(t.list, slots, &count)); err != nil {
+		return 0, err
+	}
+	label := (*C.char)(C.malloc(32))
+	defer C.free(unsafe.Pointer(label))
+	for _, slot := range (*[1 << 20]C.ck_ulong)(unsafe.Pointer(slots))[:count:count] {
+		if C.get_token_label(t.list, slot, label) != ckrOK {
+			continue
+		}
+		if trimLabel(C.GoBytes(unsafe.Pointer(label), 32)) == tokenLabel {
+			return slot, nil
+		}
+	}
+	return 0, fmt.Errorf("pkcs11: token %v not found", tokenLabel)
+}
+
+// GenerateKey implements the core.RootKeyStore interface
+func (t *Token) GenerateKey() (string, crypto.Signer, error) {
+	rawID := make([]byte, 16)
+	if _, err := rand.Read(rawID); err != nil {
+		return "", nil, err
+	}
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	pubTemplate := newTemplate([]attribute{
+		{ckaToken, boolValue(true)},
+		{ckaVerify, boolValue(true)},
+		{ckaECParams, oidP256},
+		{ckaID, rawID},
+		{ckaLabel, []byte(keyLabel)},
+	})
+	defer pubTemplate.free()
+	privTemplate := newTemplate([]attribute{
+		{ckaToken, boolValue(true)},
+		{ckaPrivate, boolValue(true)},
+		{ckaSensitive, boolValue(true)},
+		{ckaExtractable, boolValue(false)},
+		{ckaSign, boolValue(true)},
+		{ckaID, rawID},
+		{ckaLabel, []byte(keyLabel)},
+	})
+	defer privTemplate.free()
+	mech := (*C.ck_mechanism)(C.calloc(1, C.size_t(unsafe.Sizeof(C.ck_mechanism{}))))
+	defer C.free(unsafe.Pointer(mech))
+	mech.mechanism = ckmECKeyPairGen
+
+	var pubKey, privKey C.ck_ulong
+	rv := C.generate_key_pair(t.list, t.session, mech, pubTemplate.attrs, pubTemplate.count, privTemplate.attrs, privTemplate.count, &pubKey, &privKey)
+	if err := check("C_GenerateKeyPair", rv); err != nil {
+		return "", nil, err
+	}
+	public, err := t.publicKey(pubKey)
+	if err != nil {
+		return "", nil, err
+	}
+	return hex.EncodeToString(rawID), &signer{token: t, key: privKey, public: public}, nil
+}
+
+// GetKey implements the core.RootKeyStore interface
+func (t *Token) GetKey(id string) (crypto.Signer, error) {
+	rawID, err := hex.DecodeString(id)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	pubKey, err := t.findObject(ckoPublicKey, rawID)
+	if err != nil {
+		return nil, err
+	}
+	privKey, err := t.findObject(ckoPrivateKey, rawID)
+	if err != nil {
+		return nil, err
+	}
+	public, err := t.publicKey(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	return &signer{token: t, key: privKey, public: public}, nil
+}
+
+func (t *Token) findObject(class uint64, rawID []byte) (C.ck_ulong, error) {
+	template := newTemplate([]attribute{
+		{ckaClass, ulongValue(class)},
+		{ckaKeyType, ulongValue(ckkEC)},
+		{ckaID, rawID},
+	})
+	defer template.free()
+	objects := (*C.ck_ulong)(C.calloc(2, C.size_t(unsafe.Sizeof(C.ck_ulong(0)))))
+	defer C.free(unsafe.Pointer(objects))
+	var found C.ck_ulong
+	if err := check("C_FindObjects", C.find_objects(t.list, t.session, template.attrs, template.count, objects, 2, &found)); err != nil {
+		return 0, err
+	}
+	if found != 1 {
+		return 0, fmt.Errorf("pkcs11: found %v keys with ID %x, expected one", found, rawID)
+	}
+	return *objects, nil
+}
+
+func (t *Token) publicKey(object C.ck_ulong) (*ecdsa.PublicKey, error) {
+	attr := (*C.ck_attribute)(C.calloc(1, C.size_t(unsafe.Sizeof(C.ck_attribute{}))))
+	defer C.free(unsafe.Pointer(attr))
+	attr._type = ckaECPoint
+	if err := check("C_GetAttributeValue", C.get_attribute_value(t.list, t.session, object, attr, 1)); err != nil {
+		return nil, err
+	}
+	attr.value = C.malloc(C.size_t(attr.value_len))
+	defer C.free(attr.value)
+	if err := check("C_GetAttributeValue", C.get_attribute_value(t.list, t.session, object, attr, 1)); err != nil {
+		return nil, err
+	}
+	return parseECPoint(C.GoBytes(attr.value, C.int(attr.value_len)))
+}
+
+// signer signs with a private key of the token.
+type signer struct {
+	token  *Token
+	key    C.ck_ulong
+	public *ecdsa.PublicKey
+}
+
+func (s *signer) Public() crypto.PublicKey {
+	return s.public
+}
+
+// Sign signs the digest with ECDSA and returns the signature in ASN.1 format like ecdsa.PrivateKey.
+func (s *signer) Sign(_ io.Reader, digest []byte, _ crypto.SignerOpts) ([]byte, error) {
+	s.token.mux.Lock()
+	defer s.token.mux.Unlock()
+	mech := (*C.ck_mechanism)(C.calloc(1, C.size_t(unsafe.Sizeof(C.ck_mechanism{}))))
+	defer C.free(unsafe.Pointer(mech))
+	mech.mechanism = ckmECDSA
+	data := C.CBytes(digest)
+	defer C.free(data)
+	const maxSignatureLen = 132
+	sig := C.malloc(maxSignatureLen)
+	defer C.free(sig)
+	sigLen := C.ck_ulong(maxSignatureLen)
+	rv := C.sign(s.token.list, s.token.session, mech, s.key, (*C.uchar)(data), C.ck_ulong(len(digest)), (*C.uchar)(sig), &sigLen)
+	if err := check("C_Sign", rv); err != nil {
+		return nil, err
+	}
+	return encodeSignature(C.GoBytes(sig, C.int(sigLen)))
+}
+
+// attribute is an attribute of an object template.
+type attribute struct {
+	typ   uint64
+	value []byte
+}
+
+// template is an attribute array in C memory, as the module must not get pointers to Go memory.
+type template struct {
+	attrs *C.ck_attribute
+	count C.ck_ulong
+}
+
+func newTemplate(attributes []attribute) template {
+	attrs := (*C.ck_attribute)(C.calloc(C.size_t(len(attributes)), C.size_t(unsafe.Sizeof(C.ck_attribute{}))))
+	slice := (*[1 << 10]C.ck_attribute)(unsafe.Pointer(attrs))[:len(attributes):len(attributes)]
+	for i, attr := range attributes {
+		slice[i]._type = C.ck_ulong(attr.typ)
+		slice[i].value = C.CBytes(attr.value)
+		slice[i].value_len = C.ck_ulong(len(attr.value))
+	}
+	return template{attrs: attrs, count: C.ck_ulong(len(attributes))}
+}
+
+func (t template) free() {
+	for _, attr := range (*[1 << 10]C.ck_attribute)(unsafe.Pointer(t.attrs))[:t.count:t.count] {
+		C.free(attr.value)
+	}
+	C.free(unsafe.Pointer(t.attrs))
+}
+
+func boolValue(value bool) []byte {
+	if value {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+func ulongValue(value uint64) []byte {
+	var ulong C.ck_ulong = C.ck_ulong(value)
+	return C.GoBytes(unsafe.Pointer(&ulong), C.int(unsafe.Sizeof(ulong)))
+}
+
+// trimLabel removes the padding of a token label.
+func trimLabel(label []byte) string {
+	return string(bytes.TrimRight(label, " \x00"))
+}
+
+// parseECPoint parses a CKA_EC_POINT of a P-256 key, which is an uncompressed point wrapped in a DER octet string.
+func parseECPoint(raw []byte) (*ecdsa.PublicKey, error) {
+	var point []byte
+	if rest, err := asn1.Unmarshal(raw, &point); err != nil || len(rest) > 0 {
+		// some modules return the point without the octet string
+		point = raw
+	}
+	x, y := elliptic.Unmarshal(elliptic.P256(), point)
+	if x == nil {
+		return nil, errors.New("pkcs11: invalid EC point")
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+}
+
+// encodeSignature converts a PKCS#11 ECDSA signature, which is the concatenation of r and s, to ASN.1.
+func encodeSignature(sig []byte) ([]byte, error) {
+	if len(sig) == 0 || len(sig)%2 != 0 {
+		return nil, errors.New("pkcs11: invalid signature")
+	}
+	r := new(big.Int).SetBytes(sig[:len(sig)/2])
+	s := new(big.Int).SetBytes(sig[len(sig)/2:])
+	return asn1.Marshal(struct{ R, S *big.Int }{r, s})
+}
@@ -0,0 +1,79 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package pkcs11
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpen(t *testing.T) {
+	_, err := Open("/nonexistent/libpkcs11.so", "token", "1234")
+	assert.Error(t, err)
+}
+
+func TestParseECPoint(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+	point := elliptic.Marshal(elliptic.P256(), key.X, key.Y)
+	wrapped, err := asn1.Marshal(point)
+	require.NoError(err)
+
+	for _, raw := range [][]byte{wrapped, point} {
+		public, err := parseECPoint(raw)
+		require.NoError(err)
+		assert.Equal(&key.PublicKey, public)
+	}
+	_, err = parseECPoint([]byte{4, 1, 2})
+	assert.Error(err)
+}
+
+func TestEncodeSignature(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+	digest := sha256.Sum256([]byte("data"))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	require.NoError(err)
+
+	// PKCS#11 returns r and s with the size of the curve order
+	raw := make([]byte, 64)
+	copy(raw[32-len(r.Bytes()):32], r.Bytes())
+	copy(raw[64-len(s.Bytes()):], s.Bytes())
+	sig, err := encodeSignature(raw)
+	require.NoError(err)
+
+	var decoded struct{ R, S *big.Int }
+	_, err = asn1.Unmarshal(sig, &decoded)
+	require.NoError(err)
+	assert.True(ecdsa.Verify(&key.PublicKey, digest[:], decoded.R, decoded.S))
+
+	_, err = encodeSignature(raw[:63])
+	assert.Error(err)
+}
+
+func TestTrimLabel(t *testing.T) {
+	label := make([]byte, 32)
+	copy(label, "marblerun")
+	for i := len("marblerun"); i < len(label); i++ {
+		label[i] = ' '
+	}
+	assert.Equal(t, "marblerun", trimLabel(label))
+}
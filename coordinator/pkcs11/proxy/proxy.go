@@ -0,0 +1,226 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package proxy makes an HSM that is accessed through a PKCS#11 module on the host available to the Coordinator in the enclave.
+//
+// The enclave can't load the module of the host, so a proxy on the host holds the PKCS#11 session and serves the key operations over HTTP.
+// The Coordinator uses the proxy as its root key store with a Client. The key never leaves the HSM, and the Client verifies each signature
+// of the proxy, so the host can't substitute the key. However, anyone who can reach the proxy can sign with the key, as with the HSM itself,
+// so the proxy must only be reachable by the Coordinator.
+package proxy
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// KeyStore generates and holds the keys that are served by the proxy. It is implemented by pkcs11.Token.
+type KeyStore interface {
+	GenerateKey() (id string, key crypto.Signer, err error)
+	GetKey(id string) (crypto.Signer, error)
+}
+
+type keyResp struct {
+	ID string
+	// PublicKey is the PKIX-encoded public key
+	PublicKey []byte
+}
+
+type signReq struct {
+	Digest []byte
+}
+
+type signResp struct {
+	// Signature is the ASN.1-encoded ECDSA signature
+	Signature []byte
+}
+
+// NewHandler returns the handler of the proxy, which serves the keys of the store.
+//
+// POST /keys generates a key, GET /keys/<id> returns the public key and POST /keys/<id>/sign signs a digest.
+func NewHandler(keys KeyStore, logger *zap.Logger) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, key, err := keys.GenerateKey()
+		if err != nil {
+			logger.Error("failed to generate key", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		logger.Info("generated key", zap.String("ID", id))
+		writeKey(w, id, key)
+	})
+	mux.HandleFunc("/keys/", func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/keys/")
+		id, sign := strings.TrimSuffix(path, "/sign"), strings.HasSuffix(path, "/sign")
+		key, err := keys.GetKey(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		switch {
+		case !sign && r.Method == http.MethodGet:
+			writeKey(w, id, key)
+		case sign && r.Method == http.MethodPost:
+			var req signReq
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			signature, err := key.Sign(nil, req.Digest, crypto.SHA256)
+			if err != nil {
+				logger.Error("failed to sign", zap.String("ID", id), zap.Error(err))
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, signResp{signature})
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	return mux
+}
+
+func writeKey(w http.ResponseWriter, id string, key crypto.Signer) {
+	rawPub, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, keyResp{ID: id, PublicKey: rawPub})
+}
+
+func writeJSON(w http.ResponseWriter, resp interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// requestTimeout is the timeout of requests to the proxy. The HSM may be slow, e.g., if it is a network HSM.
+const requestTimeout = 30 * time.Second
+
+// Client is a root key store of the Coordinator that holds the keys in the store served by a proxy. It implements the core.RootKeyStore interface.
+type Client struct {
+	addr   string
+	client *http.Client
+}
+
+// NewClient creates a Client of the proxy at addr.
+func NewClient(addr string) *Client {
+	return &Client{addr: addr, client: &http.Client{Timeout: requestTimeout}}
+}
+
+// GenerateKey implements the core.RootKeyStore interface
+func (c *Client) GenerateKey() (string, crypto.Signer, error) {
+	var resp keyResp
+	if err := c.do(http.MethodPost, "/keys", nil, &resp); err != nil {
+		return "", nil, err
+	}
+	key, err := c.newSigner(resp)
+	if err != nil {
+		return "", nil, err
+	}
+	return resp.ID, key, nil
+}
+
+// GetKey implements the core.RootKeyStore interface
+//
+// The Core verifies that the public key matches its root certificate, so the proxy can't return another key.
+func (c *Client) GetKey(id string) (crypto.Signer, error) {
+	var resp keyResp
+	if err := c.do(http.MethodGet, "/keys/"+url.PathEscape(id), nil, &resp); err != nil {
+		return nil, err
+	}
+	return c.newSigner(resp)
+}
+
+func (c *Client) newSigner(resp keyResp) (*signer, error) {
+	public, err := x509.ParsePKIXPublicKey(resp.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("proxy: invalid public key: %v", err)
+	}
+	ecdsaPublic, ok := public.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("proxy: the key is not an ECDSA key")
+	}
+	return &signer{client: c, id: resp.ID, public: ecdsaPublic}, nil
+}
+
+func (c *Client) do(method string, path string, req interface{}, resp interface{}) error {
+	var body io.Reader
+	if req != nil {
+		rawReq, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(rawReq)
+	}
+	httpReq, err := http.NewRequest(method, "http://"+c.addr+path, body)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpResp, err := c.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	respBody, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return err
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return fmt.Errorf("proxy: %v: %s", httpResp.Status, bytes.TrimSpace(respBody))
+	}
+	return json.Unmarshal(respBody, resp)
+}
+
+// signer signs with a key served by the proxy.
+type signer struct {
+	client *Client
+	id     string
+	public *ecdsa.PublicKey
+}
+
+func (s *signer) Public() crypto.PublicKey {
+	return s.public
+}
+
+// Sign signs the digest with ECDSA and returns the signature in ASN.1 format like ecdsa.PrivateKey.
+// The signature is verified, so that the host can't make the Coordinator issue invalid certificates.
+func (s *signer) Sign(_ io.Reader, digest []byte, _ crypto.SignerOpts) ([]byte, error) {
+	var resp signResp
+	if err := s.client.do(http.MethodPost, "/keys/"+url.PathEscape(s.id)+"/sign", signReq{Digest: digest}, &resp); err != nil {
+		return nil, err
+	}
+	var sig struct{ R, S *big.Int }
+	if rest, err := asn1.Unmarshal(resp.Signature, &sig); err != nil || len(rest) > 0 {
+		return nil, errors.New("proxy: invalid signature encoding")
+	}
+	if !ecdsa.Verify(s.public, digest, sig.R, sig.S) {
+		return nil, errors.New("proxy: invalid signature")
+	}
+	return resp.Signature, nil
+}
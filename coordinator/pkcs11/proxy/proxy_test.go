@@ -0,0 +1,99 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package proxy
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"errors"
+	"io"
+	"math/big"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type memKeyStore struct {
+	keys []*ecdsa.PrivateKey
+	// substitute makes the store sign with another key
+	substitute bool
+}
+
+func (s *memKeyStore) GenerateKey() (string, crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", nil, err
+	}
+	s.keys = append(s.keys, key)
+	return strconv.Itoa(len(s.keys) - 1), key, nil
+}
+
+func (s *memKeyStore) GetKey(id string) (crypto.Signer, error) {
+	i, err := strconv.Atoi(id)
+	if err != nil || i < 0 || i >= len(s.keys) {
+		return nil, errors.New("key not found")
+	}
+	if s.substitute {
+		return &substitutedKey{s.keys[i]}, nil
+	}
+	return s.keys[i], nil
+}
+
+// substitutedKey returns the public key of the stored key, but signs with another one.
+type substitutedKey struct {
+	*ecdsa.PrivateKey
+}
+
+func (k *substitutedKey) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return other.Sign(rand.Reader, digest, opts)
+}
+
+func TestProxy(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	store := &memKeyStore{}
+	server := httptest.NewServer(NewHandler(store, zap.NewNop()))
+	defer server.Close()
+	client := NewClient(strings.TrimPrefix(server.URL, "http://"))
+
+	id, key, err := client.GenerateKey()
+	require.NoError(err)
+	assert.Equal(&store.keys[0].PublicKey, key.Public())
+
+	key, err = client.GetKey(id)
+	require.NoError(err)
+	assert.Equal(&store.keys[0].PublicKey, key.Public())
+	_, err = client.GetKey("1")
+	assert.Error(err)
+
+	digest := sha256.Sum256([]byte("data"))
+	signature, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	require.NoError(err)
+	var sig struct{ R, S *big.Int }
+	_, err = asn1.Unmarshal(signature, &sig)
+	require.NoError(err)
+	assert.True(ecdsa.Verify(&store.keys[0].PublicKey, digest[:], sig.R, sig.S))
+
+	// a signature with another key is rejected
+	store.substitute = true
+	_, err = key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	assert.Error(err)
+}
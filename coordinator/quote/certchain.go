@@ -0,0 +1,60 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package quote
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// ParsePEMCertificates parses all PEM-encoded certificates contained in data.
+func ParsePEMCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return certs, nil
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+}
+
+// VerifyCertChain verifies leaf up to rootCA using the given intermediate certificates.
+//
+// rootCA may be PEM or DER encoded. It is usually taken from the InfrastructureProperties of the manifest.
+func VerifyCertChain(leaf *x509.Certificate, intermediates []*x509.Certificate, rootCA []byte) error {
+	if len(rootCA) == 0 {
+		return errors.New("no root CA given")
+	}
+	if block, _ := pem.Decode(rootCA); block != nil {
+		rootCA = block.Bytes
+	}
+	root, err := x509.ParseCertificate(rootCA)
+	if err != nil {
+		return fmt.Errorf("invalid root CA: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	intermediatePool := x509.NewCertPool()
+	for _, cert := range intermediates {
+		intermediatePool.AddCert(cert)
+	}
+	_, err = leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediatePool,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
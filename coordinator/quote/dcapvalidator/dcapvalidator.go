@@ -0,0 +1,199 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package dcapvalidator implements the verification of Intel SGX DCAP ECDSA quotes.
+package dcapvalidator
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+)
+
+// InfrastructureType is the Type of infrastructures whose quotes are validated by the DCAPValidator.
+const InfrastructureType = "dcap"
+
+// intelQESigner is the MRSIGNER of Intel's Quoting Enclave.
+var intelQESigner, _ = hex.DecodeString("8c4f5775d796503e96137f77c68a829a0056ac8ded70140b081b094490c57bff")
+
+// intelQEProductID is the ISVPRODID of Intel's Quoting Enclave.
+const intelQEProductID = 1
+
+// DCAPValidator is a Quote validator for SGX DCAP ECDSA quotes
+type DCAPValidator struct {
+	mux     sync.RWMutex
+	tcbInfo TCBInfoProvider
+}
+
+// NewDCAPValidator returns a new DCAPValidator object
+func NewDCAPValidator() *DCAPValidator {
+	return &DCAPValidator{}
+}
+
+// SetTCBInfoProvider sets the source of Intel's TCB info, which is used to check the TCB status of platforms against
+// the AcceptedTCBStatuses and AcceptedAdvisories of packages and infrastructures. If the provider is a QEIdentityProvider,
+// the TCB status of the Quoting Enclave is checked, too.
+func (m *DCAPValidator) SetTCBInfoProvider(provider TCBInfoProvider) {
+	m.mux.Lock()
+	m.tcbInfo = provider
+	m.mux.Unlock()
+}
+
+// CollateralStatus implements the quote.CollateralReporter interface for DCAPValidator. It reports the collateral of the
+// TCB info provider if the provider is a quote.CollateralReporter, e.g., a CollateralCache.
+func (m *DCAPValidator) CollateralStatus() []quote.CollateralStatus {
+	m.mux.RLock()
+	provider := m.tcbInfo
+	m.mux.RUnlock()
+	reporter, ok := provider.(quote.CollateralReporter)
+	if !ok {
+		return nil
+	}
+	return reporter.CollateralStatus()
+}
+
+// Validate implements the Validator interface for DCAPValidator
+func (m *DCAPValidator) Validate(givenQuote []byte, cert []byte, pp quote.PackageProperties, ip quote.InfrastructureProperties) error {
+	_, err := m.ValidateReport(givenQuote, cert, pp, ip)
+	return err
+}
+
+// ValidateReport implements the ReportingValidator interface for DCAPValidator
+func (m *DCAPValidator) ValidateReport(givenQuote []byte, cert []byte, pp quote.PackageProperties, ip quote.InfrastructureProperties) (quote.PackageProperties, error) {
+	q, err := parseQuote(stripOEHeader(givenQuote))
+	if err != nil {
+		return quote.PackageProperties{}, fmt.Errorf("parsing quote failed: %v", err)
+	}
+
+	pckCert, err := verifyCertChain(q, ip.RootCA)
+	if err != nil {
+		return quote.PackageProperties{}, fmt.Errorf("verifying PCK certificate chain failed: %v", err)
+	}
+	if err := verifyQEReport(q, pckCert); err != nil {
+		return quote.PackageProperties{}, fmt.Errorf("verifying QE report failed: %v", err)
+	}
+	if err := verifyEnclaveReport(q); err != nil {
+		return quote.PackageProperties{}, fmt.Errorf("verifying enclave report failed: %v", err)
+	}
+
+	// Check that cert is equal
+	hash := sha256.Sum256(cert)
+	if !bytes.Equal(q.body.reportData[:len(hash)], hash[:]) {
+		return quote.PackageProperties{}, fmt.Errorf("hash(cert) != report.Data: %v != %v", hash, q.body.reportData)
+	}
+
+	// Verify PackageProperties
+	productID := uint64(q.body.isvProdID)
+	securityVersion := uint(q.body.isvSVN)
+	reportedProps := quote.PackageProperties{
+		UniqueID:        hex.EncodeToString(q.body.mrEnclave),
+		SignerID:        hex.EncodeToString(q.body.mrSigner),
+		Debug:           q.body.attributes&attributesDebugFlag != 0,
+		ProductID:       &productID,
+		SecurityVersion: &securityVersion,
+	}
+	if !pp.IsCompliant(reportedProps) {
+		return quote.PackageProperties{}, fmt.Errorf("PackageProperties not compliant:\n%v\n%v", reportedProps, pp)
+	}
+
+	// Verify InfrastructureProperties
+	if err := checkTCBLevel(q, ip); err != nil {
+		return quote.PackageProperties{}, err
+	}
+	if err := m.checkTCBStatus(pckCert, pp, ip); err != nil {
+		return quote.PackageProperties{}, err
+	}
+	if err := m.checkQEIdentity(q.qeReport, pp, ip); err != nil {
+		return quote.PackageProperties{}, err
+	}
+	return reportedProps, nil
+}
+
+// verifyCertChain verifies the PCK certificate chain embedded in the quote up to the given root CA and returns the PCK certificate.
+func verifyCertChain(q *sgxQuote, rootCA []byte) (*x509.Certificate, error) {
+	if q.certDataType != certDataTypePCK {
+		return nil, fmt.Errorf("unsupported certification data type %d", q.certDataType)
+	}
+	chain, err := quote.ParsePEMCertificates(q.certData)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("quote does not contain a PCK certificate")
+	}
+	if err := quote.VerifyCertChain(chain[0], chain[1:], rootCA); err != nil {
+		return nil, err
+	}
+	return chain[0], nil
+}
+
+// verifyQEReport verifies that the Quoting Enclave is genuine and that it vouches for the attestation key.
+func verifyQEReport(q *sgxQuote, pckCert *x509.Certificate) error {
+	pckKey, ok := pckCert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return errors.New("PCK certificate does not contain an ECDSA key")
+	}
+	if !verifySignature(pckKey, q.qeReport.raw, q.qeReportSignature) {
+		return errors.New("invalid QE report signature")
+	}
+
+	// QE identity
+	if !bytes.Equal(q.qeReport.mrSigner, intelQESigner) || q.qeReport.isvProdID != intelQEProductID {
+		return errors.New("quote was not created by Intel's Quoting Enclave")
+	}
+	if q.qeReport.attributes&attributesDebugFlag != 0 {
+		return errors.New("Quoting Enclave runs in debug mode")
+	}
+
+	// the QE binds the attestation key to its report
+	hash := sha256.Sum256(append(append([]byte(nil), q.attestationKey...), q.qeAuthData...))
+	if !bytes.Equal(q.qeReport.reportData[:reportDataHashedLength], hash[:]) ||
+		!bytes.Equal(q.qeReport.reportData[reportDataHashedLength:], make([]byte, len(q.qeReport.reportData)-reportDataHashedLength)) {
+		return errors.New("attestation key does not match QE report")
+	}
+	return nil
+}
+
+// verifyEnclaveReport verifies the signature of the enclave's report with the attestation key.
+func verifyEnclaveReport(q *sgxQuote) error {
+	attestationKey := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(q.attestationKey[:ecdsaKeyLen/2]),
+		Y:     new(big.Int).SetBytes(q.attestationKey[ecdsaKeyLen/2:]),
+	}
+	if !attestationKey.Curve.IsOnCurve(attestationKey.X, attestationKey.Y) {
+		return errors.New("invalid attestation key")
+	}
+	signed := append(append([]byte(nil), q.header...), q.body.raw...)
+	if !verifySignature(attestationKey, signed, q.signature) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// checkTCBLevel checks that the security versions reported in the quote are at least the required ones.
+func checkTCBLevel(q *sgxQuote, ip quote.InfrastructureProperties) error {
+	qeSVN := q.qeReport.isvSVN
+	pceSVN := q.pceSVN
+	return ip.CheckTCB(quote.InfrastructureProperties{CPUSVN: q.body.cpuSVN, QESVN: &qeSVN, PCESVN: &pceSVN})
+}
+
+// verifySignature verifies a raw ECDSA-P256-SHA256 signature (r | s).
+func verifySignature(key *ecdsa.PublicKey, data []byte, signature []byte) bool {
+	hash := sha256.Sum256(data)
+	r := new(big.Int).SetBytes(signature[:ecdsaSignatureLen/2])
+	s := new(big.Int).SetBytes(signature[ecdsaSignatureLen/2:])
+	return ecdsa.Verify(key, hash[:], r, s)
+}
@@ -0,0 +1,229 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dcapvalidator
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/coordinator/quote/quotetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testQuoteParams struct {
+	cert      []byte
+	mrEnclave []byte
+	mrSigner  []byte
+	prodID    uint16
+	isvSVN    uint16
+	debug     bool
+	cpuSVN    []byte
+	pceSVN    uint16
+	qeSVN     uint16
+	qeSigner  []byte
+}
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	rootCert, rootKey := quotetest.MustCreateCert(t, elliptic.P256(), "Root CA", true, nil, nil)
+	intermediateCert, intermediateKey := quotetest.MustCreateCert(t, elliptic.P256(), "Intermediate CA", true, rootCert, rootKey)
+	pckCert, pckKey := quotetest.MustCreateCert(t, elliptic.P256(), "PCK", false, intermediateCert, intermediateKey)
+	chain := append(quotetest.ToPEM(pckCert), quotetest.ToPEM(intermediateCert)...)
+	chain = append(chain, quotetest.ToPEM(rootCert)...)
+
+	cert := []byte("marble certificate")
+	params := testQuoteParams{
+		cert:      cert,
+		mrEnclave: make([]byte, 32),
+		mrSigner:  []byte{0x11, 0x22, 0x33},
+		prodID:    44,
+		isvSVN:    3,
+		cpuSVN:    []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+		pceSVN:    10,
+		qeSVN:     5,
+		qeSigner:  intelQESigner,
+	}
+	validQuote := mustCreateQuote(t, params, pckKey, chain)
+
+	productID := uint64(44)
+	securityVersion := uint(2)
+	pp := quote.PackageProperties{
+		SignerID:        hex.EncodeToString(make32(params.mrSigner)),
+		ProductID:       &productID,
+		SecurityVersion: &securityVersion,
+	}
+	qeSVN := uint16(5)
+	pceSVN := uint16(9)
+	ip := quote.InfrastructureProperties{
+		QESVN:  &qeSVN,
+		PCESVN: &pceSVN,
+		CPUSVN: params.cpuSVN,
+		RootCA: quotetest.ToPEM(rootCert),
+	}
+
+	validator := NewDCAPValidator()
+	require.NoError(validator.Validate(validQuote, cert, pp, ip))
+
+	// DER encoded root CA
+	ipDER := ip
+	ipDER.RootCA = rootCert.Raw
+	assert.NoError(validator.Validate(validQuote, cert, pp, ipDER))
+
+	// quote wrapped in an OpenEnclave report header
+	oeHeader := make([]byte, oeHeaderLen)
+	binary.LittleEndian.PutUint32(oeHeader, oeReportHeaderVersion)
+	binary.LittleEndian.PutUint32(oeHeader[4:], oeReportTypeSGXRemote)
+	binary.LittleEndian.PutUint64(oeHeader[8:], uint64(len(validQuote)))
+	assert.NoError(validator.Validate(append(oeHeader, validQuote...), cert, pp, ip))
+
+	// wrong message
+	assert.Error(validator.Validate(validQuote, []byte("other certificate"), pp, ip))
+
+	// wrong package
+	otherProductID := uint64(45)
+	ppOther := pp
+	ppOther.ProductID = &otherProductID
+	assert.Error(validator.Validate(validQuote, cert, ppOther, ip))
+	ppDebug := pp
+	ppDebug.Debug = true
+	assert.Error(validator.Validate(validQuote, cert, ppDebug, ip))
+
+	// TCB level too low
+	higherSVN := uint16(11)
+	ipHigherPCE := ip
+	ipHigherPCE.PCESVN = &higherSVN
+	err := validator.Validate(validQuote, cert, pp, ipHigherPCE)
+	require.Error(err)
+	assert.Contains(err.Error(), "PCESVN too low")
+	ipHigherQE := ip
+	ipHigherQE.QESVN = &higherSVN
+	assert.Error(validator.Validate(validQuote, cert, pp, ipHigherQE))
+	ipHigherCPU := ip
+	ipHigherCPU.CPUSVN = append([]byte(nil), params.cpuSVN...)
+	ipHigherCPU.CPUSVN[3]++
+	err = validator.Validate(validQuote, cert, pp, ipHigherCPU)
+	require.Error(err)
+	assert.Contains(err.Error(), "CPUSVN component 3 too low")
+
+	// the SVNs are minimums, so that updates of the platform don't break the attestation
+	lowerSVN := uint16(1)
+	ipLower := ip
+	ipLower.QESVN = &lowerSVN
+	ipLower.PCESVN = &lowerSVN
+	ipLower.CPUSVN = make([]byte, 16)
+	assert.NoError(validator.Validate(validQuote, cert, pp, ipLower))
+
+	// wrong root CA
+	otherRootCert, _ := quotetest.MustCreateCert(t, elliptic.P256(), "Root CA", true, nil, nil)
+	ipOtherRoot := ip
+	ipOtherRoot.RootCA = quotetest.ToPEM(otherRootCert)
+	assert.Error(validator.Validate(validQuote, cert, pp, ipOtherRoot))
+	ipNoRoot := ip
+	ipNoRoot.RootCA = nil
+	assert.Error(validator.Validate(validQuote, cert, pp, ipNoRoot))
+
+	// QE not signed by Intel
+	paramsOtherQE := params
+	paramsOtherQE.qeSigner = []byte{1}
+	assert.Error(validator.Validate(mustCreateQuote(t, paramsOtherQE, pckKey, chain), cert, pp, ip))
+
+	// QE report not signed by the PCK key
+	_, otherKey := quotetest.MustCreateCert(t, elliptic.P256(), "PCK", false, intermediateCert, intermediateKey)
+	assert.Error(validator.Validate(mustCreateQuote(t, params, otherKey, chain), cert, pp, ip))
+
+	// tampered report body
+	tampered := append([]byte(nil), validQuote...)
+	tampered[headerLen+64] ^= 1
+	assert.Error(validator.Validate(tampered, cert, pp, ip))
+
+	// truncated quote
+	assert.Error(validator.Validate(validQuote[:len(validQuote)-1], cert, pp, ip))
+	assert.Error(validator.Validate(validQuote[:100], cert, pp, ip))
+}
+
+func mustCreateQuote(t *testing.T, params testQuoteParams, pckKey *ecdsa.PrivateKey, chain []byte) []byte {
+	attestationKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	rawAttestationKey := append(make32(attestationKey.X.Bytes()), make32(attestationKey.Y.Bytes())...)
+
+	header := make([]byte, headerLen)
+	binary.LittleEndian.PutUint16(header[0:], quoteVersion)
+	binary.LittleEndian.PutUint16(header[2:], attestationKeyType)
+	binary.LittleEndian.PutUint16(header[8:], params.qeSVN)
+	binary.LittleEndian.PutUint16(header[10:], params.pceSVN)
+
+	certHash := sha256.Sum256(params.cert)
+	body := createReportBody(params.cpuSVN, params.debug, params.mrEnclave, params.mrSigner, params.prodID, params.isvSVN, certHash[:])
+
+	qeAuthData := []byte("auth data")
+	qeReportData := sha256.Sum256(append(append([]byte(nil), rawAttestationKey...), qeAuthData...))
+	qeReport := createReportBody(nil, false, nil, params.qeSigner, intelQEProductID, params.qeSVN, qeReportData[:])
+
+	sigData := mustSign(t, attestationKey, append(append([]byte(nil), header...), body...))
+	sigData = append(sigData, rawAttestationKey...)
+	sigData = append(sigData, qeReport...)
+	sigData = append(sigData, mustSign(t, pckKey, qeReport)...)
+	sigData = appendUint16(sigData, uint16(len(qeAuthData)))
+	sigData = append(sigData, qeAuthData...)
+	sigData = appendUint16(sigData, certDataTypePCK)
+	sigData = appendUint32(sigData, uint32(len(chain)))
+	sigData = append(sigData, chain...)
+
+	result := append(header, body...)
+	result = appendUint32(result, uint32(len(sigData)))
+	return append(result, sigData...)
+}
+
+func createReportBody(cpuSVN []byte, debug bool, mrEnclave, mrSigner []byte, prodID, isvSVN uint16, reportData []byte) []byte {
+	body := make([]byte, reportBodyLen)
+	copy(body[0:16], cpuSVN)
+	if debug {
+		body[48] = attributesDebugFlag
+	}
+	copy(body[64:96], mrEnclave)
+	copy(body[128:160], make32(mrSigner))
+	binary.LittleEndian.PutUint16(body[256:], prodID)
+	binary.LittleEndian.PutUint16(body[258:], isvSVN)
+	copy(body[320:384], reportData)
+	return body
+}
+
+func mustSign(t *testing.T, key *ecdsa.PrivateKey, data []byte) []byte {
+	hash := sha256.Sum256(data)
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	require.NoError(t, err)
+	return append(make32(r.Bytes()), make32(s.Bytes())...)
+}
+
+// make32 left-pads b with zeros to 32 bytes.
+func make32(b []byte) []byte {
+	if len(b) >= 32 {
+		return b
+	}
+	return append(make([]byte, 32-len(b)), b...)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	buf := make([]byte, 2)
+	binary.LittleEndian.PutUint16(buf, v)
+	return append(b, buf...)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, v)
+	return append(b, buf...)
+}
@@ -0,0 +1,351 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dcapvalidator
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/core/store"
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+)
+
+// PCSURL is the base URL of the Intel Provisioning Certification Service.
+const PCSURL = "https://api.trustedservices.intel.com"
+
+const (
+	tcbInfoKeyPrefix = "tcb_info_"
+	qeIdentityKey    = "qe_identity"
+
+	// maxCollateralSize limits the size of a response of the PCS
+	maxCollateralSize = 1 << 20
+)
+
+// CollateralCache is a TCBInfoProvider and QEIdentityProvider that fetches the collateral from the Intel PCS or a PCCS,
+// which serve the same API. It keeps the collateral in a store, so that it survives restarts and outages of the PCS.
+// The collateral is verified each time a quote is validated with it, so the store needn't be trusted.
+//
+// Intel handles a TCB recovery by publishing collateral of a new TCB evaluation, which may downgrade the TCB status of
+// a whole fleet at once. The collateral of a newer evaluation only takes effect after the grace period, which gives the
+// operators time to update their platforms. Collateral of the same evaluation takes effect immediately.
+type CollateralCache struct {
+	baseURL     string
+	client      *http.Client
+	store       store.Store
+	gracePeriod time.Duration
+	now         func() time.Time
+
+	mux        sync.Mutex
+	collateral map[string]*cachedCollateral
+}
+
+// cachedCollateral is the collateral cached under a key.
+type cachedCollateral struct {
+	Effective *Collateral
+	// Pending is collateral of a newer TCB evaluation, which takes effect at PendingSince plus the grace period
+	Pending      *Collateral `json:",omitempty"`
+	PendingSince time.Time
+}
+
+// NewCollateralCache creates a CollateralCache that fetches the collateral from the PCS or PCCS at baseURL, e.g., PCSURL,
+// and keeps it in st.
+func NewCollateralCache(baseURL string, st store.Store, gracePeriod time.Duration) *CollateralCache {
+	return &CollateralCache{
+		baseURL:     strings.TrimSuffix(baseURL, "/"),
+		client:      &http.Client{Timeout: 30 * time.Second},
+		store:       st,
+		gracePeriod: gracePeriod,
+		now:         time.Now,
+		collateral:  make(map[string]*cachedCollateral),
+	}
+}
+
+// TCBInfo implements the TCBInfoProvider interface for CollateralCache
+func (c *CollateralCache) TCBInfo(fmspc []byte) (*Collateral, error) {
+	return c.get(tcbInfoKeyPrefix + hex.EncodeToString(fmspc))
+}
+
+// QEIdentity implements the QEIdentityProvider interface for CollateralCache
+func (c *CollateralCache) QEIdentity() (*Collateral, error) {
+	return c.get(qeIdentityKey)
+}
+
+// Refresh fetches all collateral that has been requested so far anew. Collateral that can't be fetched is kept.
+func (c *CollateralCache) Refresh() error {
+	c.mux.Lock()
+	keys := make([]string, 0, len(c.collateral))
+	for key := range c.collateral {
+		keys = append(keys, key)
+	}
+	c.mux.Unlock()
+	sort.Strings(keys)
+
+	var errs []string
+	for _, key := range keys {
+		if err := c.refresh(key); err != nil {
+			errs = append(errs, fmt.Sprintf("%v: %v", key, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("refreshing collateral failed: %v", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Run refreshes the collateral every interval until ctx is done and reports failed refreshes to onError.
+func (c *CollateralCache) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Refresh(); err != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// CollateralStatus implements the quote.CollateralReporter interface for CollateralCache. It reports the collateral that has been requested so far.
+func (c *CollateralCache) CollateralStatus() []quote.CollateralStatus {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	keys := make([]string, 0, len(c.collateral))
+	for key := range c.collateral {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var statuses []quote.CollateralStatus
+	for _, key := range keys {
+		cached := c.collateral[key]
+		if cached.Effective == nil {
+			continue
+		}
+		version, err := collateralVersionOf(cached.Effective)
+		if err != nil {
+			continue
+		}
+		status := quote.CollateralStatus{
+			Name:                    key,
+			IssueDate:               version.IssueDate,
+			NextUpdate:              version.NextUpdate,
+			TCBEvaluationDataNumber: version.TCBEvaluationDataNumber,
+		}
+		if cached.Pending != nil {
+			if pendingVersion, err := collateralVersionOf(cached.Pending); err == nil {
+				effectiveAt := cached.PendingSince.Add(c.gracePeriod)
+				status.PendingTCBEvaluationDataNumber = pendingVersion.TCBEvaluationDataNumber
+				status.PendingEffectiveAt = &effectiveAt
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// get returns the effective collateral of the key. Collateral that is neither cached nor stored is fetched.
+func (c *CollateralCache) get(key string) (*Collateral, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	cached, ok := c.collateral[key]
+	if !ok {
+		raw, err := c.store.Get(key)
+		switch err {
+		case nil:
+			cached = &cachedCollateral{}
+			if err := json.Unmarshal(raw, cached); err != nil {
+				return nil, fmt.Errorf("invalid cached collateral: %v", err)
+			}
+		case store.ErrNotFound:
+			fetched, err := c.fetch(key)
+			if err != nil {
+				return nil, err
+			}
+			if fetched == nil {
+				return nil, nil
+			}
+			cached = &cachedCollateral{Effective: fetched}
+			if err := c.save(key, cached); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, err
+		}
+		c.collateral[key] = cached
+	}
+
+	if cached.Pending != nil && !c.now().Before(cached.PendingSince.Add(c.gracePeriod)) {
+		cached.Effective, cached.Pending, cached.PendingSince = cached.Pending, nil, time.Time{}
+		if err := c.save(key, cached); err != nil {
+			return nil, err
+		}
+	}
+	return cached.Effective, nil
+}
+
+// refresh fetches the collateral of the key and caches it.
+func (c *CollateralCache) refresh(key string) error {
+	fetched, err := c.fetch(key)
+	if err != nil {
+		return err
+	}
+	if fetched == nil {
+		return errors.New("collateral is no longer served")
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	cached := c.collateral[key]
+	if err := c.update(cached, fetched); err != nil {
+		return err
+	}
+	return c.save(key, cached)
+}
+
+// update replaces the collateral with fetched collateral of the same TCB evaluation, and defers fetched collateral of a newer
+// evaluation by the grace period. Collateral of an older evaluation is ignored.
+func (c *CollateralCache) update(cached *cachedCollateral, fetched *Collateral) error {
+	fetchedVersion, err := collateralVersionOf(fetched)
+	if err != nil {
+		return err
+	}
+	if cached.Effective == nil {
+		cached.Effective = fetched
+		return nil
+	}
+	effectiveVersion, err := collateralVersionOf(cached.Effective)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case fetchedVersion.TCBEvaluationDataNumber < effectiveVersion.TCBEvaluationDataNumber:
+		return nil
+	case fetchedVersion.TCBEvaluationDataNumber == effectiveVersion.TCBEvaluationDataNumber || c.gracePeriod <= 0:
+		if fetchedVersion.IssueDate.Before(effectiveVersion.IssueDate) {
+			return nil
+		}
+		cached.Effective = fetched
+		if cached.Pending != nil {
+			pendingVersion, err := collateralVersionOf(cached.Pending)
+			if err != nil || pendingVersion.TCBEvaluationDataNumber <= fetchedVersion.TCBEvaluationDataNumber {
+				cached.Pending, cached.PendingSince = nil, time.Time{}
+			}
+		}
+		return nil
+	}
+
+	// a TCB recovery: the grace period starts when the Coordinator first sees the new evaluation
+	if cached.Pending != nil {
+		pendingVersion, err := collateralVersionOf(cached.Pending)
+		if err == nil && pendingVersion.TCBEvaluationDataNumber == fetchedVersion.TCBEvaluationDataNumber {
+			if !fetchedVersion.IssueDate.Before(pendingVersion.IssueDate) {
+				cached.Pending = fetched
+			}
+			return nil
+		}
+		if err == nil && pendingVersion.TCBEvaluationDataNumber > fetchedVersion.TCBEvaluationDataNumber {
+			return nil
+		}
+	}
+	cached.Pending, cached.PendingSince = fetched, c.now()
+	return nil
+}
+
+func (c *CollateralCache) save(key string, cached *cachedCollateral) error {
+	raw, err := json.Marshal(cached)
+	if err != nil {
+		return err
+	}
+	return c.store.Put(key, raw)
+}
+
+// fetch gets the collateral of the key from the PCS. It returns nil if the PCS doesn't know the collateral.
+func (c *CollateralCache) fetch(key string) (*Collateral, error) {
+	var source string
+	var issuerChainHeaders []string
+	if key == qeIdentityKey {
+		source = c.baseURL + "/sgx/certification/v3/qe/identity"
+		issuerChainHeaders = []string{"SGX-Enclave-Identity-Issuer-Chain", "Enclave-Identity-Issuer-Chain"}
+	} else {
+		source = c.baseURL + "/sgx/certification/v3/tcb?fmspc=" + strings.TrimPrefix(key, tcbInfoKeyPrefix)
+		issuerChainHeaders = []string{"SGX-TCB-Info-Issuer-Chain", "TCB-Info-Issuer-Chain"}
+	}
+
+	resp, err := c.client.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v: %v", source, resp.Status)
+	}
+	raw, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxCollateralSize))
+	if err != nil {
+		return nil, err
+	}
+
+	var issuerChain string
+	for _, header := range issuerChainHeaders {
+		if issuerChain = resp.Header.Get(header); issuerChain != "" {
+			break
+		}
+	}
+	if issuerChain == "" {
+		return nil, fmt.Errorf("%v: response lacks the issuer chain", source)
+	}
+	// the issuer chain is URL-encoded; PathUnescape keeps the '+' of the base64 encoding
+	unescaped, err := url.PathUnescape(issuerChain)
+	if err != nil {
+		return nil, fmt.Errorf("%v: invalid issuer chain: %v", source, err)
+	}
+	return &Collateral{Raw: raw, IssuerChain: []byte(unescaped)}, nil
+}
+
+// collateralVersion identifies a version of collateral. It is the same for the TCB info and the QE identity.
+type collateralVersion struct {
+	IssueDate               time.Time
+	NextUpdate              time.Time
+	TCBEvaluationDataNumber uint
+}
+
+// collateralVersionOf returns the version of the collateral without verifying its signature.
+func collateralVersionOf(collateral *Collateral) (collateralVersion, error) {
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(collateral.Raw, &body); err != nil {
+		return collateralVersion{}, fmt.Errorf("invalid collateral: %v", err)
+	}
+	content, ok := body["tcbInfo"]
+	if !ok {
+		if content, ok = body["enclaveIdentity"]; !ok {
+			return collateralVersion{}, errors.New("collateral lacks tcbInfo or enclaveIdentity")
+		}
+	}
+	var version collateralVersion
+	if err := json.Unmarshal(content, &version); err != nil {
+		return collateralVersion{}, fmt.Errorf("invalid collateral: %v", err)
+	}
+	return version, nil
+}
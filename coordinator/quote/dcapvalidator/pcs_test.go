@@ -0,0 +1,185 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dcapvalidator
+
+import (
+	"crypto/elliptic"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/core/store"
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/coordinator/quote/quotetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testPCS serves collateral like the Intel PCS.
+type testPCS struct {
+	mux        sync.Mutex
+	tcbInfo    map[string]*Collateral
+	qeIdentity *Collateral
+}
+
+func (p *testPCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	var collateral *Collateral
+	var header string
+	switch r.URL.Path {
+	case "/sgx/certification/v3/tcb":
+		collateral = p.tcbInfo[r.URL.Query().Get("fmspc")]
+		header = "SGX-TCB-Info-Issuer-Chain"
+	case "/sgx/certification/v3/qe/identity":
+		collateral = p.qeIdentity
+		header = "SGX-Enclave-Identity-Issuer-Chain"
+	}
+	if collateral == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set(header, url.PathEscape(string(collateral.IssuerChain)))
+	w.Write(collateral.Raw)
+}
+
+func (p *testPCS) setTCBInfo(fmspc []byte, collateral *Collateral) {
+	p.mux.Lock()
+	p.tcbInfo[hex.EncodeToString(fmspc)] = collateral
+	p.mux.Unlock()
+}
+
+func TestCollateralCache(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	rootCert, rootKey := quotetest.MustCreateCert(t, elliptic.P256(), "Root CA", true, nil, nil)
+	signingCert, signingKey := quotetest.MustCreateCert(t, elliptic.P256(), "TCB Signing", false, rootCert, rootKey)
+	levels := []testTCBLevel{{svn: 2, pceSVN: 10, status: quote.TCBStatusUpToDate}}
+	tcbInfo := mustCreateTCBInfoOfEvaluation(t, testFMSPC, levels, false, 1, "2021-12-01T00:00:00Z", signingCert, signingKey)
+	qeIdentity := mustCreateQEIdentity(t, []testQELevel{{isvSVN: 5, status: quote.TCBStatusUpToDate}}, 1, "2021-12-01T00:00:00Z", signingCert, signingKey)
+	pcs := &testPCS{tcbInfo: map[string]*Collateral{hex.EncodeToString(testFMSPC): tcbInfo}, qeIdentity: qeIdentity}
+	server := httptest.NewServer(pcs)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	now := time.Date(2021, 12, 2, 0, 0, 0, 0, time.UTC)
+	cache := NewCollateralCache(server.URL+"/", store.NewFileStore(dir), 48*time.Hour)
+	cache.now = func() time.Time { return now }
+
+	// the collateral is fetched on first use
+	info, err := cache.TCBInfo(testFMSPC)
+	require.NoError(err)
+	assert.Equal(tcbInfo, info)
+	_, err = parseTCBInfo(info, rootCert.Raw)
+	assert.NoError(err, "the issuer chain must survive the URL encoding")
+	identity, err := cache.QEIdentity()
+	require.NoError(err)
+	assert.Equal(qeIdentity, identity)
+	info, err = cache.TCBInfo([]byte{1, 2, 3, 4, 5, 6})
+	require.NoError(err)
+	assert.Nil(info)
+	require.NoError(cache.Refresh())
+
+	// collateral of the same evaluation takes effect immediately
+	reissued := mustCreateTCBInfoOfEvaluation(t, testFMSPC, levels, false, 1, "2021-12-02T00:00:00Z", signingCert, signingKey)
+	pcs.setTCBInfo(testFMSPC, reissued)
+	require.NoError(cache.Refresh())
+	info, err = cache.TCBInfo(testFMSPC)
+	require.NoError(err)
+	assert.Equal(reissued, info)
+
+	// collateral of a newer evaluation takes effect after the grace period
+	recovered := mustCreateTCBInfoOfEvaluation(t, testFMSPC, levels, false, 2, "2021-12-03T00:00:00Z", signingCert, signingKey)
+	pcs.setTCBInfo(testFMSPC, recovered)
+	require.NoError(cache.Refresh())
+
+	// the pending collateral is reported with the time it takes effect
+	statuses := cache.CollateralStatus()
+	require.Len(statuses, 2)
+	assert.Equal(qeIdentityKey, statuses[0].Name)
+	assert.Equal(tcbInfoKeyPrefix+hex.EncodeToString(testFMSPC), statuses[1].Name)
+	assert.EqualValues(1, statuses[1].TCBEvaluationDataNumber)
+	assert.True(time.Date(2021, 12, 2, 0, 0, 0, 0, time.UTC).Equal(statuses[1].IssueDate))
+	assert.EqualValues(2, statuses[1].PendingTCBEvaluationDataNumber)
+	require.NotNil(statuses[1].PendingEffectiveAt)
+	assert.True(now.Add(48 * time.Hour).Equal(*statuses[1].PendingEffectiveAt))
+	assert.Len(NewDCAPValidator().CollateralStatus(), 0)
+
+	now = now.Add(24 * time.Hour)
+	require.NoError(cache.Refresh())
+	info, err = cache.TCBInfo(testFMSPC)
+	require.NoError(err)
+	assert.Equal(reissued, info)
+	now = now.Add(24 * time.Hour)
+	info, err = cache.TCBInfo(testFMSPC)
+	require.NoError(err)
+	assert.Equal(recovered, info)
+
+	// collateral of an older evaluation is ignored
+	pcs.setTCBInfo(testFMSPC, tcbInfo)
+	require.NoError(cache.Refresh())
+	info, err = cache.TCBInfo(testFMSPC)
+	require.NoError(err)
+	assert.Equal(recovered, info)
+
+	// the cache is kept in the store and used if the PCS is unavailable
+	server.Close()
+	assert.Error(cache.Refresh())
+	restarted := NewCollateralCache(server.URL, store.NewFileStore(dir), 48*time.Hour)
+	info, err = restarted.TCBInfo(testFMSPC)
+	require.NoError(err)
+	assert.Equal(recovered, info)
+	identity, err = restarted.QEIdentity()
+	require.NoError(err)
+	assert.Equal(qeIdentity, identity)
+	_, err = restarted.TCBInfo([]byte{1, 2, 3, 4, 5, 6})
+	assert.Error(err)
+}
+
+func TestCollateralCacheWithoutGracePeriod(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	rootCert, rootKey := quotetest.MustCreateCert(t, elliptic.P256(), "Root CA", true, nil, nil)
+	signingCert, signingKey := quotetest.MustCreateCert(t, elliptic.P256(), "TCB Signing", false, rootCert, rootKey)
+	levels := []testTCBLevel{{svn: 2, pceSVN: 10, status: quote.TCBStatusUpToDate}}
+	tcbInfo := mustCreateTCBInfoOfEvaluation(t, testFMSPC, levels, false, 1, "2021-12-01T00:00:00Z", signingCert, signingKey)
+	pcs := &testPCS{tcbInfo: map[string]*Collateral{hex.EncodeToString(testFMSPC): tcbInfo}}
+	server := httptest.NewServer(pcs)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	cache := NewCollateralCache(server.URL, store.NewFileStore(dir), 0)
+
+	info, err := cache.TCBInfo(testFMSPC)
+	require.NoError(err)
+	assert.Equal(tcbInfo, info)
+	recovered := mustCreateTCBInfoOfEvaluation(t, testFMSPC, levels, false, 2, "2021-12-03T00:00:00Z", signingCert, signingKey)
+	pcs.setTCBInfo(testFMSPC, recovered)
+	require.NoError(cache.Refresh())
+	info, err = cache.TCBInfo(testFMSPC)
+	require.NoError(err)
+	assert.Equal(recovered, info)
+
+	// collateral that is no longer served is kept
+	pcs.setTCBInfo(testFMSPC, nil)
+	assert.Error(cache.Refresh())
+	info, err = cache.TCBInfo(testFMSPC)
+	require.NoError(err)
+	assert.Equal(recovered, info)
+}
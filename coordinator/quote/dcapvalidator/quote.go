@@ -0,0 +1,144 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dcapvalidator
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Layout of an SGX ECDSA quote (version 3) as specified in the Intel SGX ECDSA Quote Library Reference.
+const (
+	headerLen          = 48
+	reportBodyLen      = 384
+	ecdsaSignatureLen  = 64
+	ecdsaKeyLen        = 64
+	quoteVersion       = 3
+	attestationKeyType = 2 // ECDSA-256-with-P-256 curve
+	certDataTypePCK    = 5 // concatenated PCK certificate chain in PEM format
+
+	attributesDebugFlag    = 0x2
+	reportDataHashedLength = 32 // the QE report data contains a SHA-256 hash followed by zeros
+
+	// OpenEnclave prepends this header to the SGX quote
+	oeHeaderLen           = 16
+	oeReportHeaderVersion = 1
+	oeReportTypeSGXRemote = 2
+)
+
+// reportBody is the SGX report of an enclave that is embedded in a quote.
+type reportBody struct {
+	raw        []byte
+	cpuSVN     []byte
+	miscSelect []byte
+	attributes uint64
+	// attributesAndXFRM are the raw ATTRIBUTES, i.e., the flags followed by the XFRM
+	attributesAndXFRM []byte
+	mrEnclave         []byte
+	mrSigner          []byte
+	isvProdID         uint16
+	isvSVN            uint16
+	reportData        []byte
+}
+
+// sgxQuote is a parsed SGX ECDSA quote.
+type sgxQuote struct {
+	header            []byte
+	pceSVN            uint16
+	body              reportBody
+	signature         []byte
+	attestationKey    []byte
+	qeReport          reportBody
+	qeReportSignature []byte
+	qeAuthData        []byte
+	certDataType      uint16
+	certData          []byte
+}
+
+// stripOEHeader removes the header OpenEnclave adds to its remote reports, if present.
+func stripOEHeader(report []byte) []byte {
+	if len(report) < oeHeaderLen {
+		return report
+	}
+	if binary.LittleEndian.Uint32(report) != oeReportHeaderVersion ||
+		binary.LittleEndian.Uint32(report[4:]) != oeReportTypeSGXRemote ||
+		binary.LittleEndian.Uint64(report[8:]) != uint64(len(report)-oeHeaderLen) {
+		return report
+	}
+	return report[oeHeaderLen:]
+}
+
+func parseReportBody(raw []byte) reportBody {
+	return reportBody{
+		raw:               raw,
+		cpuSVN:            raw[0:16],
+		miscSelect:        raw[16:20],
+		attributes:        binary.LittleEndian.Uint64(raw[48:56]),
+		attributesAndXFRM: raw[48:64],
+		mrEnclave:         raw[64:96],
+		mrSigner:          raw[128:160],
+		isvProdID:         binary.LittleEndian.Uint16(raw[256:258]),
+		isvSVN:            binary.LittleEndian.Uint16(raw[258:260]),
+		reportData:        raw[320:384],
+	}
+}
+
+// parseQuote parses an SGX ECDSA quote. It does not verify anything besides the format.
+func parseQuote(raw []byte) (*sgxQuote, error) {
+	if len(raw) < headerLen+reportBodyLen+4 {
+		return nil, errors.New("quote is too short")
+	}
+	var q sgxQuote
+	q.header = raw[:headerLen]
+	if version := binary.LittleEndian.Uint16(q.header[0:2]); version != quoteVersion {
+		return nil, fmt.Errorf("unsupported quote version %d", version)
+	}
+	if keyType := binary.LittleEndian.Uint16(q.header[2:4]); keyType != attestationKeyType {
+		return nil, fmt.Errorf("unsupported attestation key type %d", keyType)
+	}
+	q.pceSVN = binary.LittleEndian.Uint16(q.header[10:12])
+	q.body = parseReportBody(raw[headerLen : headerLen+reportBodyLen])
+
+	sigData := raw[headerLen+reportBodyLen:]
+	sigDataLen := binary.LittleEndian.Uint32(sigData)
+	sigData = sigData[4:]
+	if uint64(len(sigData)) != uint64(sigDataLen) {
+		return nil, errors.New("invalid signature data length")
+	}
+
+	// signature | attestation key | QE report | QE report signature | QE auth data size | QE auth data | cert data type | cert data size | cert data
+	fixedLen := ecdsaSignatureLen + ecdsaKeyLen + reportBodyLen + ecdsaSignatureLen + 2
+	if len(sigData) < fixedLen {
+		return nil, errors.New("signature data is too short")
+	}
+	q.signature = sigData[:ecdsaSignatureLen]
+	sigData = sigData[ecdsaSignatureLen:]
+	q.attestationKey = sigData[:ecdsaKeyLen]
+	sigData = sigData[ecdsaKeyLen:]
+	q.qeReport = parseReportBody(sigData[:reportBodyLen])
+	sigData = sigData[reportBodyLen:]
+	q.qeReportSignature = sigData[:ecdsaSignatureLen]
+	sigData = sigData[ecdsaSignatureLen:]
+
+	authDataLen := int(binary.LittleEndian.Uint16(sigData))
+	sigData = sigData[2:]
+	if len(sigData) < authDataLen+6 {
+		return nil, errors.New("invalid QE authentication data length")
+	}
+	q.qeAuthData = sigData[:authDataLen]
+	sigData = sigData[authDataLen:]
+
+	q.certDataType = binary.LittleEndian.Uint16(sigData)
+	certDataLen := binary.LittleEndian.Uint32(sigData[2:])
+	sigData = sigData[6:]
+	if uint64(len(sigData)) != uint64(certDataLen) {
+		return nil, errors.New("invalid certification data length")
+	}
+	q.certData = sigData
+	return &q, nil
+}
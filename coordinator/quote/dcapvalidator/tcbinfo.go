@@ -0,0 +1,417 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dcapvalidator
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+)
+
+// tcbComponents is the number of SGX TCB components, which are followed by the PCESVN in the TCB of a platform.
+const tcbComponents = 16
+
+// OIDs of the SGX extensions of a PCK certificate.
+var (
+	oidSGXExtensions = asn1.ObjectIdentifier{1, 2, 840, 113741, 1, 13, 1}
+	oidTCB           = asn1.ObjectIdentifier{1, 2, 840, 113741, 1, 13, 1, 2}
+	oidPCESVN        = asn1.ObjectIdentifier{1, 2, 840, 113741, 1, 13, 1, 2, 17}
+	oidFMSPC         = asn1.ObjectIdentifier{1, 2, 840, 113741, 1, 13, 1, 4}
+)
+
+// Collateral is signed collateral of Intel as served by the Intel PCS or a PCCS, i.e., the TCB info of an FMSPC or the QE identity.
+type Collateral struct {
+	// Raw is the JSON body of the response, i.e., the tcbInfo or enclaveIdentity and its signature
+	Raw []byte
+	// IssuerChain is the PEM-encoded certificate chain of the signing key, i.e., the issuer chain header of the response
+	IssuerChain []byte
+}
+
+// TCBInfoProvider provides Intel's TCB info, which the DCAPValidator uses to determine the TCB status of a platform.
+type TCBInfoProvider interface {
+	// TCBInfo returns the TCB info for the FMSPC, or nil if there is none
+	TCBInfo(fmspc []byte) (*Collateral, error)
+}
+
+// QEIdentityProvider is implemented by TCBInfoProviders that also provide the identity of Intel's Quoting Enclave.
+// The DCAPValidator then determines the TCB status of the QE, too.
+type QEIdentityProvider interface {
+	// QEIdentity returns the QE identity, or nil if there is none
+	QEIdentity() (*Collateral, error)
+}
+
+// TCBInfoDir is a TCBInfoProvider that reads the TCB info from a directory.
+//
+// The directory contains the TCB info of each FMSPC in the file <fmspc>.json, where fmspc is hex-encoded, and the issuer chain
+// in tcb-info-issuer-chain.pem. It may contain the QE identity in qe-identity.json and its issuer chain in qe-identity-issuer-chain.pem.
+type TCBInfoDir string
+
+// TCBInfo implements the TCBInfoProvider interface for TCBInfoDir
+func (d TCBInfoDir) TCBInfo(fmspc []byte) (*Collateral, error) {
+	return d.read(hex.EncodeToString(fmspc)+".json", "tcb-info-issuer-chain.pem")
+}
+
+// QEIdentity implements the QEIdentityProvider interface for TCBInfoDir
+func (d TCBInfoDir) QEIdentity() (*Collateral, error) {
+	return d.read("qe-identity.json", "qe-identity-issuer-chain.pem")
+}
+
+func (d TCBInfoDir) read(name string, issuerChainName string) (*Collateral, error) {
+	raw, err := ioutil.ReadFile(filepath.Join(string(d), name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	issuerChain, err := ioutil.ReadFile(filepath.Join(string(d), issuerChainName))
+	if err != nil {
+		return nil, err
+	}
+	return &Collateral{Raw: raw, IssuerChain: issuerChain}, nil
+}
+
+// tcbInfo is the part of Intel's TCB info that is needed to determine the TCB status of a platform.
+type tcbInfo struct {
+	IssueDate               time.Time
+	NextUpdate              time.Time
+	FMSPC                   string
+	TCBEvaluationDataNumber uint
+	TCBLevels               []tcbLevel
+}
+
+// tcbLevel is a TCB level of Intel's TCB info. Version 2 lists the SGX TCB components as sgxtcbcompNNsvn, version 3 as sgxtcbcomponents.
+type tcbLevel struct {
+	TCB struct {
+		SGXTCBComponents []struct{ SVN uint8 }
+		PCESVN           uint16
+	}
+	TCBDate     time.Time
+	TCBStatus   string
+	AdvisoryIDs []string
+	components  [tcbComponents]uint8
+}
+
+// verifyCollateral verifies the signature of the collateral up to rootCA and returns the signed member of its body, which is called name.
+func verifyCollateral(signed *Collateral, name string, rootCA []byte) (json.RawMessage, error) {
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(signed.Raw, &body); err != nil {
+		return nil, err
+	}
+	content, ok := body[name]
+	if !ok {
+		return nil, fmt.Errorf("collateral lacks %v", name)
+	}
+	var hexSignature string
+	if err := json.Unmarshal(body["signature"], &hexSignature); err != nil {
+		return nil, errors.New("invalid signature")
+	}
+	chain, err := quote.ParsePEMCertificates(signed.IssuerChain)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("no signing certificate")
+	}
+	if err := quote.VerifyCertChain(chain[0], chain[1:], rootCA); err != nil {
+		return nil, fmt.Errorf("verifying signing certificate failed: %v", err)
+	}
+	signingKey, ok := chain[0].PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("signing certificate does not contain an ECDSA key")
+	}
+	signature, err := hex.DecodeString(hexSignature)
+	if err != nil || len(signature) != ecdsaSignatureLen {
+		return nil, errors.New("invalid signature")
+	}
+	// the signature is over the content exactly as it has been served
+	if !verifySignature(signingKey, content, signature) {
+		return nil, errors.New("invalid signature")
+	}
+	return content, nil
+}
+
+// parseTCBInfo verifies the signature of the TCB info up to rootCA and parses it.
+func parseTCBInfo(signed *Collateral, rootCA []byte) (*tcbInfo, error) {
+	rawInfo, err := verifyCollateral(signed, "tcbInfo", rootCA)
+	if err != nil {
+		return nil, err
+	}
+
+	var info tcbInfo
+	if err := json.Unmarshal(rawInfo, &info); err != nil {
+		return nil, err
+	}
+	var levels struct {
+		TCBLevels []struct{ TCB map[string]interface{} }
+	}
+	if err := json.Unmarshal(rawInfo, &levels); err != nil {
+		return nil, err
+	}
+	for i := range info.TCBLevels {
+		level := &info.TCBLevels[i]
+		for j := range level.components {
+			if len(level.TCB.SGXTCBComponents) == tcbComponents {
+				level.components[j] = level.TCB.SGXTCBComponents[j].SVN
+				continue
+			}
+			svn, ok := levels.TCBLevels[i].TCB[fmt.Sprintf("sgxtcbcomp%02dsvn", j+1)].(float64)
+			if !ok {
+				return nil, fmt.Errorf("TCB level %d lacks SGX TCB component %d", i, j+1)
+			}
+			level.components[j] = uint8(svn)
+		}
+	}
+	return &info, nil
+}
+
+// level returns the TCB level of a platform with the given TCB, i.e., the first level of the TCB info that the platform's TCB
+// is at least as high as. Intel sorts the levels from the highest to the lowest.
+func (info *tcbInfo) level(components [tcbComponents]uint8, pceSVN uint16) (tcbLevel, bool) {
+	for _, level := range info.TCBLevels {
+		if pceSVN < level.TCB.PCESVN {
+			continue
+		}
+		higher := true
+		for i := range components {
+			if components[i] < level.components[i] {
+				higher = false
+				break
+			}
+		}
+		if higher {
+			return level, true
+		}
+	}
+	return tcbLevel{}, false
+}
+
+// sgxExtension is an entry of the SGX extensions of a PCK certificate.
+type sgxExtension struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+// pckTCB returns the FMSPC and the TCB of the platform from the SGX extensions of its PCK certificate.
+func pckTCB(pckCert *x509.Certificate) (fmspc []byte, components [tcbComponents]uint8, pceSVN uint16, err error) {
+	var extensions []sgxExtension
+	for _, ext := range pckCert.Extensions {
+		if ext.Id.Equal(oidSGXExtensions) {
+			if _, err := asn1.Unmarshal(ext.Value, &extensions); err != nil {
+				return nil, components, 0, fmt.Errorf("invalid SGX extensions: %v", err)
+			}
+		}
+	}
+	var tcb []sgxExtension
+	for _, ext := range extensions {
+		switch {
+		case ext.ID.Equal(oidFMSPC):
+			if _, err := asn1.Unmarshal(ext.Value.FullBytes, &fmspc); err != nil {
+				return nil, components, 0, fmt.Errorf("invalid FMSPC: %v", err)
+			}
+		case ext.ID.Equal(oidTCB):
+			if _, err := asn1.Unmarshal(ext.Value.FullBytes, &tcb); err != nil {
+				return nil, components, 0, fmt.Errorf("invalid TCB: %v", err)
+			}
+		}
+	}
+	if fmspc == nil || tcb == nil {
+		return nil, components, 0, errors.New("PCK certificate lacks the FMSPC or TCB")
+	}
+
+	found := 0
+	for _, ext := range tcb {
+		// the components are identified by the last arc of their OIDs: 1 to 16 for the SGX TCB components and 17 for the PCESVN
+		if len(ext.ID) != len(oidTCB)+1 || !asn1.ObjectIdentifier(ext.ID[:len(oidTCB)]).Equal(oidTCB) {
+			continue
+		}
+		index := ext.ID[len(oidTCB)]
+		if index < 1 || index > tcbComponents+1 {
+			continue
+		}
+		var svn int
+		if _, err := asn1.Unmarshal(ext.Value.FullBytes, &svn); err != nil {
+			return nil, components, 0, fmt.Errorf("invalid TCB component %d: %v", index, err)
+		}
+		if ext.ID.Equal(oidPCESVN) {
+			pceSVN = uint16(svn)
+		} else {
+			components[index-1] = uint8(svn)
+		}
+		found++
+	}
+	if found != tcbComponents+1 {
+		return nil, components, 0, errors.New("PCK certificate lacks TCB components")
+	}
+	return fmspc, components, pceSVN, nil
+}
+
+// checkTCBStatus determines the TCB status of the platform from Intel's TCB info and checks that it is accepted.
+//
+// If no TCB info is available for the platform, quotes are only rejected if the package or infrastructure restrict the accepted statuses
+// or advisories, because the status can't be determined then.
+func (m *DCAPValidator) checkTCBStatus(pckCert *x509.Certificate, pp quote.PackageProperties, ip quote.InfrastructureProperties) error {
+	restricted := len(pp.AcceptedTCBStatuses) > 0 || len(pp.AcceptedAdvisories) > 0 || len(ip.AcceptedTCBStatuses) > 0 || len(ip.AcceptedAdvisories) > 0
+	m.mux.RLock()
+	provider := m.tcbInfo
+	m.mux.RUnlock()
+	if provider == nil {
+		if restricted {
+			return errors.New("accepted TCB statuses or advisories are set, but the Coordinator has no TCB info")
+		}
+		return nil
+	}
+
+	fmspc, components, pceSVN, err := pckTCB(pckCert)
+	if err != nil {
+		return err
+	}
+	signed, err := provider.TCBInfo(fmspc)
+	if err != nil {
+		return fmt.Errorf("getting TCB info for FMSPC %x failed: %v", fmspc, err)
+	}
+	if signed == nil {
+		if restricted {
+			return fmt.Errorf("accepted TCB statuses or advisories are set, but there is no TCB info for FMSPC %x", fmspc)
+		}
+		return nil
+	}
+	info, err := parseTCBInfo(signed, ip.RootCA)
+	if err != nil {
+		return fmt.Errorf("invalid TCB info for FMSPC %x: %v", fmspc, err)
+	}
+	if !strings.EqualFold(info.FMSPC, hex.EncodeToString(fmspc)) {
+		return fmt.Errorf("TCB info is for FMSPC %v instead of %x", info.FMSPC, fmspc)
+	}
+	level, ok := info.level(components, pceSVN)
+	if !ok {
+		return fmt.Errorf("TCB of the platform is lower than all TCB levels of FMSPC %x", fmspc)
+	}
+	return quote.CheckTCBStatus(level.TCBStatus, level.AdvisoryIDs, pp, ip)
+}
+
+// qeIdentity is the part of Intel's QE identity that is needed to verify the Quoting Enclave and determine its TCB status.
+type qeIdentity struct {
+	IssueDate               time.Time
+	NextUpdate              time.Time
+	TCBEvaluationDataNumber uint
+	MiscSelect              string
+	MiscSelectMask          string
+	Attributes              string
+	AttributesMask          string
+	MRSigner                string
+	ISVProdID               uint16
+	TCBLevels               []struct {
+		TCB struct {
+			ISVSVN uint16
+		}
+		TCBDate     time.Time
+		TCBStatus   string
+		AdvisoryIDs []string
+	}
+}
+
+// parseQEIdentity verifies the signature of the QE identity up to rootCA and parses it.
+func parseQEIdentity(signed *Collateral, rootCA []byte) (*qeIdentity, error) {
+	rawIdentity, err := verifyCollateral(signed, "enclaveIdentity", rootCA)
+	if err != nil {
+		return nil, err
+	}
+	var identity qeIdentity
+	if err := json.Unmarshal(rawIdentity, &identity); err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// verify checks that the QE report matches the identity and returns the TCB status and advisories of the QE.
+func (identity *qeIdentity) verify(report reportBody) (status string, advisories []string, err error) {
+	if !strings.EqualFold(identity.MRSigner, hex.EncodeToString(report.mrSigner)) || identity.ISVProdID != report.isvProdID {
+		return "", nil, errors.New("QE report does not match the QE identity")
+	}
+	if err := checkMasked(report.miscSelect, identity.MiscSelect, identity.MiscSelectMask); err != nil {
+		return "", nil, fmt.Errorf("MISCSELECT of the QE: %v", err)
+	}
+	if err := checkMasked(report.attributesAndXFRM, identity.Attributes, identity.AttributesMask); err != nil {
+		return "", nil, fmt.Errorf("ATTRIBUTES of the QE: %v", err)
+	}
+	// like the TCB levels of a platform, the levels are sorted from the highest to the lowest
+	for _, level := range identity.TCBLevels {
+		if report.isvSVN >= level.TCB.ISVSVN {
+			return level.TCBStatus, level.AdvisoryIDs, nil
+		}
+	}
+	return "", nil, fmt.Errorf("ISVSVN of the QE is lower than all TCB levels: %v", report.isvSVN)
+}
+
+// checkMasked checks that value equals the hex-encoded expected value in all bits that are set in the hex-encoded mask.
+func checkMasked(value []byte, hexExpected string, hexMask string) error {
+	expected, err := hex.DecodeString(hexExpected)
+	if err != nil {
+		return err
+	}
+	mask, err := hex.DecodeString(hexMask)
+	if err != nil {
+		return err
+	}
+	if len(expected) != len(value) || len(mask) != len(value) {
+		return errors.New("invalid length")
+	}
+	for i := range value {
+		if value[i]&mask[i] != expected[i]&mask[i] {
+			return fmt.Errorf("mismatch: %x != %v", value, hexExpected)
+		}
+	}
+	return nil
+}
+
+// checkQEIdentity verifies the Quoting Enclave against Intel's QE identity and checks that its TCB status is accepted.
+//
+// Like checkTCBStatus, it only rejects quotes without a QE identity if the package or infrastructure restrict the accepted
+// statuses or advisories.
+func (m *DCAPValidator) checkQEIdentity(report reportBody, pp quote.PackageProperties, ip quote.InfrastructureProperties) error {
+	restricted := len(pp.AcceptedTCBStatuses) > 0 || len(pp.AcceptedAdvisories) > 0 || len(ip.AcceptedTCBStatuses) > 0 || len(ip.AcceptedAdvisories) > 0
+	m.mux.RLock()
+	provider, ok := m.tcbInfo.(QEIdentityProvider)
+	m.mux.RUnlock()
+	if !ok {
+		return nil
+	}
+	signed, err := provider.QEIdentity()
+	if err != nil {
+		return fmt.Errorf("getting QE identity failed: %v", err)
+	}
+	if signed == nil {
+		if restricted {
+			return errors.New("accepted TCB statuses or advisories are set, but there is no QE identity")
+		}
+		return nil
+	}
+	identity, err := parseQEIdentity(signed, ip.RootCA)
+	if err != nil {
+		return fmt.Errorf("invalid QE identity: %v", err)
+	}
+	status, advisories, err := identity.verify(report)
+	if err != nil {
+		return err
+	}
+	if err := quote.CheckTCBStatus(status, advisories, pp, ip); err != nil {
+		return fmt.Errorf("Quoting Enclave: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,377 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dcapvalidator
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/coordinator/quote/quotetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testFMSPC = []byte{0x00, 0x90, 0x6e, 0xa1, 0x00, 0x00}
+
+// testTCBInfo maps hex-encoded FMSPCs to their TCB info.
+type testTCBInfo map[string]*Collateral
+
+func (p testTCBInfo) TCBInfo(fmspc []byte) (*Collateral, error) {
+	return p[hex.EncodeToString(fmspc)], nil
+}
+
+// testCollateral adds a QE identity to testTCBInfo.
+type testCollateral struct {
+	testTCBInfo
+	qeIdentity *Collateral
+}
+
+func (p testCollateral) QEIdentity() (*Collateral, error) {
+	return p.qeIdentity, nil
+}
+
+func TestTCBStatus(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	rootCert, rootKey := quotetest.MustCreateCert(t, elliptic.P256(), "Root CA", true, nil, nil)
+	signingCert, signingKey := quotetest.MustCreateCert(t, elliptic.P256(), "TCB Signing", false, rootCert, rootKey)
+	pckCert, pckKey := quotetest.MustCreateCert(t, elliptic.P256(), "PCK", false, rootCert, rootKey, mustCreatePCKExtension(t, testFMSPC, 2, 10))
+	chain := append(quotetest.ToPEM(pckCert), quotetest.ToPEM(rootCert)...)
+
+	cert := []byte("marble certificate")
+	params := testQuoteParams{cert: cert, mrSigner: []byte{1}, cpuSVN: make([]byte, 16), qeSigner: intelQESigner}
+	validQuote := mustCreateQuote(t, params, pckKey, chain)
+	pp := quote.PackageProperties{SignerID: hex.EncodeToString(make32(params.mrSigner))}
+	ip := quote.InfrastructureProperties{RootCA: quotetest.ToPEM(rootCert)}
+
+	// the platform's TCB is at the second level
+	levels := []testTCBLevel{
+		{svn: 3, pceSVN: 11, status: quote.TCBStatusUpToDate},
+		{svn: 2, pceSVN: 10, status: quote.TCBStatusSWHardeningNeeded, advisories: []string{"INTEL-SA-00334"}},
+		{svn: 0, pceSVN: 0, status: quote.TCBStatusOutOfDate, advisories: []string{"INTEL-SA-00334", "INTEL-SA-00615"}},
+	}
+	tcbInfo := mustCreateTCBInfo(t, testFMSPC, levels, false, signingCert, signingKey)
+
+	validator := NewDCAPValidator()
+	// without TCB info, the status can only be checked if it is restricted
+	require.NoError(validator.Validate(validQuote, cert, pp, ip))
+	ipRestricted := ip
+	ipRestricted.AcceptedTCBStatuses = []string{quote.TCBStatusUpToDate}
+	assert.Error(validator.Validate(validQuote, cert, pp, ipRestricted))
+	validator.SetTCBInfoProvider(testTCBInfo{})
+	require.NoError(validator.Validate(validQuote, cert, pp, ip))
+	assert.Error(validator.Validate(validQuote, cert, pp, ipRestricted))
+
+	validator.SetTCBInfoProvider(testTCBInfo{hex.EncodeToString(testFMSPC): tcbInfo})
+	// only UpToDate is accepted by default
+	err := validator.Validate(validQuote, cert, pp, ip)
+	require.Error(err)
+	assert.Contains(err.Error(), quote.TCBStatusSWHardeningNeeded)
+
+	// the advisories must be accepted as well
+	ipAccepted := ip
+	ipAccepted.AcceptedTCBStatuses = []string{quote.TCBStatusUpToDate, quote.TCBStatusSWHardeningNeeded}
+	err = validator.Validate(validQuote, cert, pp, ipAccepted)
+	require.Error(err)
+	assert.Contains(err.Error(), "INTEL-SA-00334")
+	ipAccepted.AcceptedAdvisories = []string{"INTEL-SA-00334"}
+	assert.NoError(validator.Validate(validQuote, cert, pp, ipAccepted))
+
+	// the package may restrict the accepted statuses and advisories further
+	ppRestricted := pp
+	ppRestricted.AcceptedTCBStatuses = []string{quote.TCBStatusUpToDate}
+	assert.Error(validator.Validate(validQuote, cert, ppRestricted, ipAccepted))
+	ppRestricted.AcceptedTCBStatuses = []string{quote.TCBStatusSWHardeningNeeded}
+	assert.NoError(validator.Validate(validQuote, cert, ppRestricted, ipAccepted))
+	ppRestricted.AcceptedAdvisories = []string{"INTEL-SA-00615"}
+	assert.Error(validator.Validate(validQuote, cert, ppRestricted, ipAccepted))
+	ppRestricted.AcceptedAdvisories = nil
+	ppRestricted.AcceptedTCBStatuses = []string{quote.TCBStatusOutOfDate}
+	assert.Error(validator.Validate(validQuote, cert, ppRestricted, ip), "the package can't accept more than the infrastructure")
+
+	// TCB info that is not signed by the root CA
+	otherRootCert, otherRootKey := quotetest.MustCreateCert(t, elliptic.P256(), "Root CA", true, nil, nil)
+	otherSigningCert, otherSigningKey := quotetest.MustCreateCert(t, elliptic.P256(), "TCB Signing", false, otherRootCert, otherRootKey)
+	validator.SetTCBInfoProvider(testTCBInfo{hex.EncodeToString(testFMSPC): mustCreateTCBInfo(t, testFMSPC, levels, false, otherSigningCert, otherSigningKey)})
+	assert.Error(validator.Validate(validQuote, cert, pp, ipAccepted))
+	tampered := *tcbInfo
+	tampered.IssuerChain = quotetest.ToPEM(otherSigningCert)
+	validator.SetTCBInfoProvider(testTCBInfo{hex.EncodeToString(testFMSPC): &tampered})
+	assert.Error(validator.Validate(validQuote, cert, pp, ipAccepted))
+
+	// TCB info of another platform
+	validator.SetTCBInfoProvider(testTCBInfo{hex.EncodeToString(testFMSPC): mustCreateTCBInfo(t, []byte{1, 2, 3, 4, 5, 6}, levels, false, signingCert, signingKey)})
+	assert.Error(validator.Validate(validQuote, cert, pp, ipAccepted))
+
+	// the platform's TCB is lower than all levels
+	validator.SetTCBInfoProvider(testTCBInfo{hex.EncodeToString(testFMSPC): mustCreateTCBInfo(t, testFMSPC, levels[:1], false, signingCert, signingKey)})
+	assert.Error(validator.Validate(validQuote, cert, pp, ipAccepted))
+}
+
+func TestQEIdentity(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	rootCert, rootKey := quotetest.MustCreateCert(t, elliptic.P256(), "Root CA", true, nil, nil)
+	signingCert, signingKey := quotetest.MustCreateCert(t, elliptic.P256(), "TCB Signing", false, rootCert, rootKey)
+	pckCert, pckKey := quotetest.MustCreateCert(t, elliptic.P256(), "PCK", false, rootCert, rootKey, mustCreatePCKExtension(t, testFMSPC, 2, 10))
+	chain := append(quotetest.ToPEM(pckCert), quotetest.ToPEM(rootCert)...)
+
+	cert := []byte("marble certificate")
+	params := testQuoteParams{cert: cert, mrSigner: []byte{1}, cpuSVN: make([]byte, 16), qeSigner: intelQESigner, qeSVN: 5}
+	validQuote := mustCreateQuote(t, params, pckKey, chain)
+	pp := quote.PackageProperties{SignerID: hex.EncodeToString(make32(params.mrSigner))}
+	ip := quote.InfrastructureProperties{RootCA: quotetest.ToPEM(rootCert)}
+	tcbInfo := mustCreateTCBInfo(t, testFMSPC, []testTCBLevel{{svn: 2, pceSVN: 10, status: quote.TCBStatusUpToDate}}, false, signingCert, signingKey)
+
+	validator := NewDCAPValidator()
+	// a provider without QE identity only checks the platform
+	validator.SetTCBInfoProvider(testCollateral{testTCBInfo: testTCBInfo{hex.EncodeToString(testFMSPC): tcbInfo}})
+	require.NoError(validator.Validate(validQuote, cert, pp, ip))
+
+	// the QE's ISVSVN is at the second level
+	levels := []testQELevel{
+		{isvSVN: 6, status: quote.TCBStatusUpToDate},
+		{isvSVN: 5, status: quote.TCBStatusOutOfDate, advisories: []string{"INTEL-SA-00615"}},
+	}
+	validator.SetTCBInfoProvider(testCollateral{
+		testTCBInfo: testTCBInfo{hex.EncodeToString(testFMSPC): tcbInfo},
+		qeIdentity:  mustCreateQEIdentity(t, levels, 1, "2021-12-01T00:00:00Z", signingCert, signingKey),
+	})
+	err := validator.Validate(validQuote, cert, pp, ip)
+	require.Error(err)
+	assert.Contains(err.Error(), quote.TCBStatusOutOfDate)
+	ipAccepted := ip
+	ipAccepted.AcceptedTCBStatuses = []string{quote.TCBStatusUpToDate, quote.TCBStatusOutOfDate}
+	ipAccepted.AcceptedAdvisories = []string{"INTEL-SA-00615"}
+	assert.NoError(validator.Validate(validQuote, cert, pp, ipAccepted))
+	paramsUpToDate := params
+	paramsUpToDate.qeSVN = 6
+	assert.NoError(validator.Validate(mustCreateQuote(t, paramsUpToDate, pckKey, chain), cert, pp, ip))
+	paramsTooLow := params
+	paramsTooLow.qeSVN = 4
+	assert.Error(validator.Validate(mustCreateQuote(t, paramsTooLow, pckKey, chain), cert, pp, ipAccepted))
+
+	// QE identity that is not signed by the root CA
+	otherRootCert, otherRootKey := quotetest.MustCreateCert(t, elliptic.P256(), "Root CA", true, nil, nil)
+	otherSigningCert, otherSigningKey := quotetest.MustCreateCert(t, elliptic.P256(), "TCB Signing", false, otherRootCert, otherRootKey)
+	validator.SetTCBInfoProvider(testCollateral{
+		testTCBInfo: testTCBInfo{hex.EncodeToString(testFMSPC): tcbInfo},
+		qeIdentity:  mustCreateQEIdentity(t, levels, 1, "2021-12-01T00:00:00Z", otherSigningCert, otherSigningKey),
+	})
+	assert.Error(validator.Validate(validQuote, cert, pp, ipAccepted))
+
+	// the QE report must match the masked MISCSELECT and ATTRIBUTES
+	identity := &qeIdentity{
+		MiscSelect:     "00000000",
+		MiscSelectMask: "FFFFFFFF",
+		Attributes:     "01000000000000000000000000000000",
+		AttributesMask: "FBFFFFFFFFFFFFFF0000000000000000",
+		MRSigner:       hex.EncodeToString(intelQESigner),
+		ISVProdID:      intelQEProductID,
+	}
+	report := parseReportBody(createReportBody(nil, false, nil, intelQESigner, intelQEProductID, 5, nil))
+	_, _, err = identity.verify(report)
+	assert.Error(err)
+	identity.AttributesMask = "FAFFFFFFFFFFFFFF0000000000000000"
+	_, _, err = identity.verify(report)
+	assert.Error(err, "the ISVSVN is lower than all levels")
+	require.NoError(json.Unmarshal([]byte(`[{"tcb": {"isvsvn": 5}, "tcbStatus": "UpToDate"}]`), &identity.TCBLevels))
+	status, _, err := identity.verify(report)
+	require.NoError(err)
+	assert.Equal(quote.TCBStatusUpToDate, status)
+	identity.MiscSelect = "00000001"
+	_, _, err = identity.verify(report)
+	assert.Error(err)
+}
+
+func TestParseTCBInfo(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	rootCert, rootKey := quotetest.MustCreateCert(t, elliptic.P256(), "Root CA", true, nil, nil)
+	signingCert, signingKey := quotetest.MustCreateCert(t, elliptic.P256(), "TCB Signing", false, rootCert, rootKey)
+	levels := []testTCBLevel{
+		{svn: 3, pceSVN: 11, status: quote.TCBStatusUpToDate},
+		{svn: 2, pceSVN: 10, status: quote.TCBStatusSWHardeningNeeded, advisories: []string{"INTEL-SA-00334"}},
+	}
+
+	// version 2 and 3 of the TCB info list the components differently
+	for _, version3 := range []bool{false, true} {
+		info, err := parseTCBInfo(mustCreateTCBInfo(t, testFMSPC, levels, version3, signingCert, signingKey), rootCert.Raw)
+		require.NoError(err)
+		assert.Equal(hex.EncodeToString(testFMSPC), info.FMSPC)
+
+		var components [tcbComponents]uint8
+		for i := range components {
+			components[i] = 2
+		}
+		level, ok := info.level(components, 10)
+		require.True(ok)
+		assert.Equal(quote.TCBStatusSWHardeningNeeded, level.TCBStatus)
+		assert.Equal([]string{"INTEL-SA-00334"}, level.AdvisoryIDs)
+		_, ok = info.level(components, 9)
+		assert.False(ok)
+		components[tcbComponents-1] = 3
+		level, ok = info.level(components, 11)
+		require.True(ok)
+		assert.Equal(quote.TCBStatusSWHardeningNeeded, level.TCBStatus, "all components must be at least as high as the level's")
+		for i := range components {
+			components[i] = 3
+		}
+		level, ok = info.level(components, 11)
+		require.True(ok)
+		assert.Equal(quote.TCBStatusUpToDate, level.TCBStatus)
+	}
+}
+
+func TestTCBInfoDir(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "00906ea10000.json"), []byte("tcb info"), 0600))
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "tcb-info-issuer-chain.pem"), []byte("chain"), 0600))
+
+	info, err := TCBInfoDir(dir).TCBInfo(testFMSPC)
+	require.NoError(err)
+	assert.Equal(&Collateral{Raw: []byte("tcb info"), IssuerChain: []byte("chain")}, info)
+	info, err = TCBInfoDir(dir).TCBInfo([]byte{1})
+	require.NoError(err)
+	assert.Nil(info)
+
+	identity, err := TCBInfoDir(dir).QEIdentity()
+	require.NoError(err)
+	assert.Nil(identity)
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "qe-identity.json"), []byte("qe identity"), 0600))
+	_, err = TCBInfoDir(dir).QEIdentity()
+	assert.Error(err, "the issuer chain is missing")
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "qe-identity-issuer-chain.pem"), []byte("qe chain"), 0600))
+	identity, err = TCBInfoDir(dir).QEIdentity()
+	require.NoError(err)
+	assert.Equal(&Collateral{Raw: []byte("qe identity"), IssuerChain: []byte("qe chain")}, identity)
+}
+
+type testTCBLevel struct {
+	svn        uint8
+	pceSVN     uint16
+	status     string
+	advisories []string
+}
+
+func mustCreateTCBInfo(t *testing.T, fmspc []byte, levels []testTCBLevel, version3 bool, signingCert *x509.Certificate, signingKey *ecdsa.PrivateKey) *Collateral {
+	return mustCreateTCBInfoOfEvaluation(t, fmspc, levels, version3, 1, "2021-12-01T00:00:00Z", signingCert, signingKey)
+}
+
+func mustCreateTCBInfoOfEvaluation(t *testing.T, fmspc []byte, levels []testTCBLevel, version3 bool, evaluation uint, issueDate string, signingCert *x509.Certificate, signingKey *ecdsa.PrivateKey) *Collateral {
+	var tcbLevels []interface{}
+	for _, level := range levels {
+		tcb := map[string]interface{}{"pcesvn": level.pceSVN}
+		if version3 {
+			var components []interface{}
+			for i := 0; i < tcbComponents; i++ {
+				components = append(components, map[string]interface{}{"svn": level.svn, "category": "BIOS"})
+			}
+			tcb["sgxtcbcomponents"] = components
+		} else {
+			for i := 1; i <= tcbComponents; i++ {
+				tcb[fmt.Sprintf("sgxtcbcomp%02dsvn", i)] = level.svn
+			}
+		}
+		tcbLevels = append(tcbLevels, map[string]interface{}{
+			"tcb":         tcb,
+			"tcbDate":     "2021-11-10T00:00:00Z",
+			"tcbStatus":   level.status,
+			"advisoryIDs": level.advisories,
+		})
+	}
+	rawInfo, err := json.Marshal(map[string]interface{}{
+		"version":                 2,
+		"issueDate":               issueDate,
+		"nextUpdate":              "2022-01-01T00:00:00Z",
+		"fmspc":                   hex.EncodeToString(fmspc),
+		"tcbEvaluationDataNumber": evaluation,
+		"tcbLevels":               tcbLevels,
+	})
+	require.NoError(t, err)
+	raw := []byte(`{"tcbInfo":` + string(rawInfo) + `,"signature":"` + hex.EncodeToString(mustSign(t, signingKey, rawInfo)) + `"}`)
+	return &Collateral{Raw: raw, IssuerChain: quotetest.ToPEM(signingCert)}
+}
+
+type testQELevel struct {
+	isvSVN     uint16
+	status     string
+	advisories []string
+}
+
+func mustCreateQEIdentity(t *testing.T, levels []testQELevel, evaluation uint, issueDate string, signingCert *x509.Certificate, signingKey *ecdsa.PrivateKey) *Collateral {
+	var tcbLevels []interface{}
+	for _, level := range levels {
+		tcbLevels = append(tcbLevels, map[string]interface{}{
+			"tcb":         map[string]interface{}{"isvsvn": level.isvSVN},
+			"tcbDate":     "2021-11-10T00:00:00Z",
+			"tcbStatus":   level.status,
+			"advisoryIDs": level.advisories,
+		})
+	}
+	rawIdentity, err := json.Marshal(map[string]interface{}{
+		"id":                      "QE",
+		"version":                 2,
+		"issueDate":               issueDate,
+		"nextUpdate":              "2022-01-01T00:00:00Z",
+		"tcbEvaluationDataNumber": evaluation,
+		"miscselect":              "00000000",
+		"miscselectMask":          "FFFFFFFF",
+		"attributes":              "00000000000000000000000000000000",
+		"attributesMask":          "FBFFFFFFFFFFFFFF0000000000000000",
+		"mrsigner":                strings.ToUpper(hex.EncodeToString(intelQESigner)),
+		"isvprodid":               intelQEProductID,
+		"tcbLevels":               tcbLevels,
+	})
+	require.NoError(t, err)
+	raw := []byte(`{"enclaveIdentity":` + string(rawIdentity) + `,"signature":"` + hex.EncodeToString(mustSign(t, signingKey, rawIdentity)) + `"}`)
+	return &Collateral{Raw: raw, IssuerChain: quotetest.ToPEM(signingCert)}
+}
+
+// mustCreatePCKExtension returns the SGX extensions of a PCK certificate of a platform whose SGX TCB components are all svn.
+func mustCreatePCKExtension(t *testing.T, fmspc []byte, svn int, pceSVN int) pkix.Extension {
+	var tcb []sgxExtension
+	for i := 1; i <= tcbComponents; i++ {
+		tcb = append(tcb, mustCreateSGXExtension(t, append(append(asn1.ObjectIdentifier{}, oidTCB...), i), svn))
+	}
+	tcb = append(tcb, mustCreateSGXExtension(t, oidPCESVN, pceSVN))
+	tcb = append(tcb, mustCreateSGXExtension(t, append(append(asn1.ObjectIdentifier{}, oidTCB...), 18), make([]byte, 16)))
+	extensions := []sgxExtension{
+		mustCreateSGXExtension(t, asn1.ObjectIdentifier{1, 2, 840, 113741, 1, 13, 1, 1}, []byte("PPID")),
+		mustCreateSGXExtension(t, oidTCB, tcb),
+		mustCreateSGXExtension(t, oidFMSPC, fmspc),
+	}
+	value, err := asn1.Marshal(extensions)
+	require.NoError(t, err)
+	return pkix.Extension{Id: oidSGXExtensions, Value: value}
+}
+
+func mustCreateSGXExtension(t *testing.T, id asn1.ObjectIdentifier, value interface{}) sgxExtension {
+	raw, err := asn1.Marshal(value)
+	require.NoError(t, err)
+	return sgxExtension{ID: id, Value: asn1.RawValue{FullBytes: raw}}
+}
@@ -0,0 +1,143 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package quote
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+)
+
+// measurementSize is the size of the UniqueID (MRENCLAVE) and SignerID (MRSIGNER) of an SGX enclave in bytes.
+const measurementSize = 32
+
+// cpusvnSize is the size of the CPUSVN of an SGX quote in bytes.
+const cpusvnSize = 16
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+//
+// UniqueID and SignerID may be given as hex string, as base64 string of the 32-byte measurement or as JSON array of bytes.
+// They are normalized to hex.
+func (p *PackageProperties) UnmarshalJSON(data []byte) error {
+	type plain PackageProperties
+	raw := struct {
+		*plain
+		UniqueID json.RawMessage
+		SignerID json.RawMessage
+	}{plain: (*plain)(p)}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	var err error
+	if p.UniqueID, err = decodeMeasurement(raw.UniqueID); err != nil {
+		return fmt.Errorf("invalid UniqueID: %v", err)
+	}
+	if p.SignerID, err = decodeMeasurement(raw.SignerID); err != nil {
+		return fmt.Errorf("invalid SignerID: %v", err)
+	}
+	return nil
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+//
+// CPUSVN and RootCA may be given as base64 string, as hex string or as JSON array of bytes. RootCA may also be a PEM-encoded certificate.
+func (p *InfrastructureProperties) UnmarshalJSON(data []byte) error {
+	type plain InfrastructureProperties
+	raw := struct {
+		*plain
+		CPUSVN json.RawMessage
+		RootCA json.RawMessage
+	}{plain: (*plain)(p)}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	var err error
+	if p.CPUSVN, err = decodeBinary(raw.CPUSVN, cpusvnSize); err != nil {
+		return fmt.Errorf("invalid CPUSVN: %v", err)
+	}
+	if p.RootCA, err = decodeBinary(raw.RootCA, 0); err != nil {
+		return fmt.Errorf("invalid RootCA: %v", err)
+	}
+	return nil
+}
+
+// decodeMeasurement returns the hex encoding of a measurement.
+//
+// Strings that aren't the base64 encoding of a 32-byte measurement are taken as they are, so that the properties of quotes survive a round trip.
+func decodeMeasurement(data json.RawMessage) (string, error) {
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		return "", nil
+	}
+	if data[0] == '[' {
+		var value []byte
+		if err := json.Unmarshal(data, &value); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(value), nil
+	}
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return "", err
+	}
+	if !isHex(value) {
+		if decoded, ok := decodeBase64(value); ok && len(decoded) == measurementSize {
+			return hex.EncodeToString(decoded), nil
+		}
+	}
+	return value, nil
+}
+
+// decodeBinary decodes a binary value given as base64 string, hex string or JSON array of bytes.
+//
+// If hexSize is set, a string is only taken as hex if it encodes hexSize bytes, because such a string may also be valid base64.
+// Strings that contain a PEM block are decoded to the bytes of the block.
+func decodeBinary(data json.RawMessage, hexSize int) ([]byte, error) {
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		return nil, nil
+	}
+	if data[0] == '[' {
+		var value []byte
+		err := json.Unmarshal(data, &value)
+		return value, err
+	}
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode([]byte(value)); block != nil {
+		return block.Bytes, nil
+	}
+	if isHex(value) && (hexSize == 0 || len(value) == 2*hexSize) {
+		return hex.DecodeString(value)
+	}
+	if decoded, ok := decodeBase64(value); ok {
+		return decoded, nil
+	}
+	return nil, fmt.Errorf("%q is neither hex nor base64", value)
+}
+
+// isHex returns true if value is a non-empty hex string.
+func isHex(value string) bool {
+	if value == "" || len(value)%2 != 0 {
+		return false
+	}
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
+
+// decodeBase64 decodes value in the standard or URL-safe base64 encoding, with or without padding.
+func decodeBase64(value string) ([]byte, bool) {
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if decoded, err := encoding.DecodeString(value); err == nil {
+			return decoded, true
+		}
+	}
+	return nil, false
+}
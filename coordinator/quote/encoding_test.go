@@ -0,0 +1,99 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package quote
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPackagePropertiesEncoding(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	measurement := make([]byte, 32)
+	for i := range measurement {
+		measurement[i] = byte(i)
+	}
+	hexMeasurement := hex.EncodeToString(measurement)
+	byteArray := strings.Join(strings.Fields(fmt.Sprint(measurement)), ",")
+
+	for _, encoded := range []string{
+		`"` + hexMeasurement + `"`,
+		`"` + strings.ToUpper(hexMeasurement) + `"`,
+		`"` + base64.StdEncoding.EncodeToString(measurement) + `"`,
+		`"` + base64.RawURLEncoding.EncodeToString(measurement) + `"`,
+		byteArray,
+	} {
+		var properties PackageProperties
+		require.NoError(json.Unmarshal([]byte(`{"UniqueID": `+encoded+`, "SignerID": `+encoded+`, "Debug": true}`), &properties), encoded)
+		assert.True(strings.EqualFold(hexMeasurement, properties.UniqueID), encoded)
+		assert.True(strings.EqualFold(hexMeasurement, properties.SignerID), encoded)
+		assert.True(properties.Debug)
+	}
+
+	// other strings are kept, so that the properties survive a round trip
+	properties := PackageProperties{UniqueID: "unique", SignerID: "0304", ProductID: new(uint64)}
+	rawProperties, err := json.Marshal(properties)
+	require.NoError(err)
+	var decoded PackageProperties
+	require.NoError(json.Unmarshal(rawProperties, &decoded))
+	assert.Equal(properties, decoded)
+
+	assert.Error(json.Unmarshal([]byte(`{"UniqueID": 1}`), &decoded))
+}
+
+func TestInfrastructurePropertiesEncoding(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	cpusvn := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	for _, encoded := range []string{
+		`"` + hex.EncodeToString(cpusvn) + `"`,
+		`"` + base64.StdEncoding.EncodeToString(cpusvn) + `"`,
+		`[0,1,2,3,4,5,6,7,8,9,10,11,12,13,14,15]`,
+	} {
+		var properties InfrastructureProperties
+		require.NoError(json.Unmarshal([]byte(`{"Type": "dcap", "CPUSVN": `+encoded+`}`), &properties), encoded)
+		assert.Equal(cpusvn, properties.CPUSVN, encoded)
+		assert.Equal("dcap", properties.Type)
+	}
+
+	rootCA := []byte{0x30, 0x82, 1, 2}
+	pemRootCA, err := json.Marshal(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rootCA})))
+	require.NoError(err)
+	for _, encoded := range []string{
+		string(pemRootCA),
+		`"30820102"`,
+		`"` + base64.StdEncoding.EncodeToString(rootCA) + `"`,
+		`[48,130,1,2]`,
+	} {
+		var properties InfrastructureProperties
+		require.NoError(json.Unmarshal([]byte(`{"RootCA": `+encoded+`}`), &properties), encoded)
+		assert.Equal(rootCA, properties.RootCA, encoded)
+	}
+
+	var properties InfrastructureProperties
+	assert.Error(json.Unmarshal([]byte(`{"RootCA": "not encoded!"}`), &properties))
+
+	// the properties survive a round trip
+	qesvn := uint16(2)
+	properties = InfrastructureProperties{Type: "dcap", CPUSVN: cpusvn, QESVN: &qesvn, RootCA: rootCA}
+	rawProperties, err := json.Marshal(properties)
+	require.NoError(err)
+	var decoded InfrastructureProperties
+	require.NoError(json.Unmarshal(rawProperties, &decoded))
+	assert.Equal(properties, decoded)
+}
@@ -7,6 +7,7 @@
 package quote
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/google/go-cmp/cmp"
@@ -14,6 +15,7 @@ import (
 
 // PackageProperties contains the enclave package-specific properties of an OpenEnclave quote.
 // Either UniqueID or SignerID, ProductID, and SecurityVersion should be specified.
+// Packages running in AMD SEV-SNP guests are identified by Measurement, packages running in AWS Nitro Enclaves by PCRs instead.
 type PackageProperties struct {
 	// Debug Flag of the Attributes
 	Debug bool
@@ -25,19 +27,38 @@ type PackageProperties struct {
 	ProductID *uint64
 	// Security version number of the package
 	SecurityVersion *uint
+	// Launch measurement of an AMD SEV-SNP guest
+	Measurement string
+	// Guest policy of an AMD SEV-SNP guest
+	Policy *uint64
+	// Platform configuration registers of an AWS Nitro Enclave (index to hex-encoded value)
+	PCRs map[uint]string
+	// TCB statuses and Intel security advisories of the platform that the package accepts, in addition to the requirements of the infrastructure
+	AcceptedTCBStatuses []string `json:",omitempty"`
+	AcceptedAdvisories  []string `json:",omitempty"`
 }
 
 // InfrastructureProperties contains the infrastructure-specific properties of a SGX DCAP quote.
 type InfrastructureProperties struct {
-	// Processor model and firmware security version number
-	// NOTE: the Intel manual states that CPUSVN "cannot be compared mathematically"
+	// Type of the infrastructure, which selects the Validator used for quotes of this infrastructure (e.g., "dcap")
+	// If empty, the Coordinator's default Validator (EdgelessRT) is used.
+	Type string
+	// Minimum processor model and firmware security version number. Each of its 16 components is a minimum on its own.
 	CPUSVN []byte
-	// Quoting Enclave security version number
+	// Minimum Quoting Enclave security version number
 	QESVN *uint16
-	// Provisioning Certification Enclave security version number
+	// Minimum Provisioning Certification Enclave security version number
 	PCESVN *uint16
 	// Certificate of the root CA (not optional)
 	RootCA []byte
+	// URL of the attestation provider that validates the quotes, which must also be the issuer of its tokens (only for Type "azure-maa")
+	AttestationURL string
+	// PEM-encoded certificates of the keys the attestation provider signs its tokens with (only for Type "azure-maa")
+	SigningCerts []string
+	// TCB statuses of the platform that are accepted if Intel's TCB info is available (only for Type "dcap"). Only "UpToDate" is accepted by default.
+	AcceptedTCBStatuses []string `json:",omitempty"`
+	// Intel security advisories, e.g., "INTEL-SA-00334", that may affect the platform. TCB levels with other advisories are rejected.
+	AcceptedAdvisories []string `json:",omitempty"`
 }
 
 // IsCompliant checks if the given package properties comply with the requirements
@@ -51,17 +72,88 @@ func (required PackageProperties) IsCompliant(given PackageProperties) bool {
 	if len(required.SignerID) > 0 && !strings.EqualFold(required.SignerID, given.SignerID) {
 		return false
 	}
-	if required.ProductID != nil && *required.ProductID != *given.ProductID {
+	if required.ProductID != nil && (given.ProductID == nil || *required.ProductID != *given.ProductID) {
 		return false
 	}
-	if required.SecurityVersion != nil && *required.SecurityVersion > *given.SecurityVersion {
+	if required.SecurityVersion != nil && (given.SecurityVersion == nil || *required.SecurityVersion > *given.SecurityVersion) {
 		return false
 	}
+	if len(required.Measurement) > 0 && !strings.EqualFold(required.Measurement, given.Measurement) {
+		return false
+	}
+	if required.Policy != nil && (given.Policy == nil || *required.Policy != *given.Policy) {
+		return false
+	}
+	for index, value := range required.PCRs {
+		if !strings.EqualFold(value, given.PCRs[index]) {
+			return false
+		}
+	}
 	return true
 }
 
-// IsCompliant checks if the given infrastructure properties comply with the requirements
+// SVNError occurs if a security version number of a platform is lower than required by the infrastructure.
+type SVNError struct {
+	// Component is "CPUSVN", "QESVN" or "PCESVN"
+	Component string
+	// Index is the index of the CPUSVN component that is too low
+	Index    int
+	Required uint16
+	Given    uint16
+}
+
+func (e *SVNError) Error() string {
+	if e.Component == "CPUSVN" {
+		return fmt.Sprintf("CPUSVN component %d too low: %d < %d", e.Index, e.Given, e.Required)
+	}
+	return fmt.Sprintf("%v too low: %d < %d", e.Component, e.Given, e.Required)
+}
+
+// CheckTCB checks that the security version numbers of the given infrastructure properties, i.e., those reported by a quote,
+// are at least the required ones.
+//
+// The CPUSVN is compared component by component. Intel's manual states that a CPUSVN "cannot be compared mathematically" as a
+// whole, but each component is the SVN of one firmware or microcode element, so that updates only ever raise them.
+// Returns an *SVNError for the first security version number that is too low.
+func (required InfrastructureProperties) CheckTCB(given InfrastructureProperties) error {
+	if len(required.CPUSVN) > 0 {
+		for i, svn := range required.CPUSVN {
+			var givenSVN byte
+			if i < len(given.CPUSVN) {
+				givenSVN = given.CPUSVN[i]
+			}
+			if givenSVN < svn {
+				return &SVNError{Component: "CPUSVN", Index: i, Required: uint16(svn), Given: uint16(givenSVN)}
+			}
+		}
+	}
+	if err := checkSVN("QESVN", required.QESVN, given.QESVN); err != nil {
+		return err
+	}
+	return checkSVN("PCESVN", required.PCESVN, given.PCESVN)
+}
+
+func checkSVN(component string, required *uint16, given *uint16) error {
+	if required == nil {
+		return nil
+	}
+	var givenSVN uint16
+	if given != nil {
+		givenSVN = *given
+	}
+	if givenSVN < *required {
+		return &SVNError{Component: component, Required: *required, Given: givenSVN}
+	}
+	return nil
+}
+
+// IsCompliant checks if the given infrastructure properties comply with the requirements.
+// The security version numbers are minimums, see CheckTCB. All other properties must be equal.
 func (required InfrastructureProperties) IsCompliant(given InfrastructureProperties) bool {
-	// TODO: implement proper logic including SVN comparison
+	if required.CheckTCB(given) != nil {
+		return false
+	}
+	required.CPUSVN, required.QESVN, required.PCESVN = nil, nil, nil
+	given.CPUSVN, given.QESVN, given.PCESVN = nil, nil, nil
 	return cmp.Equal(required, given)
 }
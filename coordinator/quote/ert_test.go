@@ -0,0 +1,68 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package quote
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfrastructurePropertiesCheckTCB(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	svn := func(value uint16) *uint16 { return &value }
+	required := InfrastructureProperties{
+		CPUSVN: []byte{2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2},
+		QESVN:  svn(5),
+		PCESVN: svn(10),
+	}
+
+	// equal and higher SVNs comply
+	assert.NoError(required.CheckTCB(required))
+	higher := InfrastructureProperties{
+		CPUSVN: []byte{3, 2, 9, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 4},
+		QESVN:  svn(6),
+		PCESVN: svn(11),
+	}
+	assert.NoError(required.CheckTCB(higher))
+	assert.True(required.IsCompliant(higher))
+	assert.NoError(InfrastructureProperties{}.CheckTCB(higher), "no SVNs are required")
+
+	// the CPUSVN is compared component by component
+	lowerCPU := higher
+	lowerCPU.CPUSVN = []byte{9, 9, 9, 9, 9, 9, 9, 1, 9, 9, 9, 9, 9, 9, 9, 9}
+	err := required.CheckTCB(lowerCPU)
+	require.IsType(&SVNError{}, err)
+	assert.Equal(SVNError{Component: "CPUSVN", Index: 7, Required: 2, Given: 1}, *err.(*SVNError))
+	assert.Equal("CPUSVN component 7 too low: 1 < 2", err.Error())
+	assert.False(required.IsCompliant(lowerCPU))
+
+	lowerQE := higher
+	lowerQE.QESVN = svn(4)
+	err = required.CheckTCB(lowerQE)
+	require.IsType(&SVNError{}, err)
+	assert.Equal(SVNError{Component: "QESVN", Required: 5, Given: 4}, *err.(*SVNError))
+
+	lowerPCE := higher
+	lowerPCE.PCESVN = svn(9)
+	err = required.CheckTCB(lowerPCE)
+	require.IsType(&SVNError{}, err)
+	assert.Equal("PCESVN too low: 9 < 10", err.Error())
+
+	// missing SVNs are lower than any required one
+	assert.Error(required.CheckTCB(InfrastructureProperties{QESVN: svn(5), PCESVN: svn(10)}))
+	assert.Error(required.CheckTCB(InfrastructureProperties{CPUSVN: required.CPUSVN, PCESVN: svn(10)}))
+
+	// the other properties must be equal
+	otherRoot := higher
+	otherRoot.RootCA = []byte{1}
+	assert.NoError(required.CheckTCB(otherRoot))
+	assert.False(required.IsCompliant(otherRoot))
+}
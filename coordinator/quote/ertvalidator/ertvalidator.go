@@ -17,6 +17,9 @@ import (
 	"github.com/edgelesssys/marblerun/coordinator/quote"
 )
 
+// InfrastructureType is the Type of infrastructures whose quotes are validated by the ERTValidator.
+const InfrastructureType = "ert"
+
 // ERTValidator is a Quote validatior based on EdgelessRT
 type ERTValidator struct {
 }
@@ -28,16 +31,22 @@ func NewERTValidator() *ERTValidator {
 
 // Validate implements the Validator interface for ERTValidator
 func (m *ERTValidator) Validate(givenQuote []byte, cert []byte, pp quote.PackageProperties, ip quote.InfrastructureProperties) error {
+	_, err := m.ValidateReport(givenQuote, cert, pp, ip)
+	return err
+}
+
+// ValidateReport implements the ReportingValidator interface for ERTValidator
+func (m *ERTValidator) ValidateReport(givenQuote []byte, cert []byte, pp quote.PackageProperties, ip quote.InfrastructureProperties) (quote.PackageProperties, error) {
 	// Verify Quote
 	report, err := ertenclave.VerifyRemoteReport(givenQuote)
 	if err != nil {
-		return fmt.Errorf("verifying quote failed: %v", err)
+		return quote.PackageProperties{}, fmt.Errorf("verifying quote failed: %v", err)
 	}
 
 	// Check that cert is equal
 	hash := sha256.Sum256(cert)
 	if !bytes.Equal(report.Data[:len(hash)], hash[:]) {
-		return fmt.Errorf("hash(cert) != report.Data: %v != %v", hash, report.Data)
+		return quote.PackageProperties{}, fmt.Errorf("hash(cert) != report.Data: %v != %v", hash, report.Data)
 	}
 
 	// Verify PackageProperties
@@ -50,11 +59,11 @@ func (m *ERTValidator) Validate(givenQuote []byte, cert []byte, pp quote.Package
 		SecurityVersion: &report.SecurityVersion,
 	}
 	if !pp.IsCompliant(reportedProps) {
-		return fmt.Errorf("PackageProperties not compliant:\n%v\n%v", reportedProps, pp)
+		return quote.PackageProperties{}, fmt.Errorf("PackageProperties not compliant:\n%v\n%v", reportedProps, pp)
 	}
 
 	// TODO Verify InfrastructureProperties with information from OE Quote
-	return nil
+	return reportedProps, nil
 }
 
 // ERTIssuer is a Quote issuer based on EdgelessRT
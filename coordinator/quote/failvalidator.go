@@ -24,6 +24,11 @@ func (m *FailValidator) Validate(quote []byte, cert []byte, pp PackageProperties
 	return fmt.Errorf("cannot validate quote")
 }
 
+// ValidateReport implements the ReportingValidator interface for FailValidator
+func (m *FailValidator) ValidateReport(quote []byte, cert []byte, pp PackageProperties, ip InfrastructureProperties) (PackageProperties, error) {
+	return PackageProperties{}, m.Validate(quote, cert, pp, ip)
+}
+
 // FailIssuer always fails
 type FailIssuer struct{}
 
@@ -13,6 +13,22 @@ type Validator interface {
 	Validate(quote []byte, cert []byte, pp PackageProperties, ip InfrastructureProperties) error
 }
 
+// ReportingValidator is a Validator that also returns the properties of the package that a valid quote reports
+type ReportingValidator interface {
+	Validator
+	// ValidateReport validates a quote like Validate and returns the package properties reported by the quote
+	ValidateReport(quote []byte, cert []byte, pp PackageProperties, ip InfrastructureProperties) (PackageProperties, error)
+}
+
+// ValidateReport validates a quote with the given Validator and returns the package properties reported by the quote.
+// The reported properties are empty if the Validator is not a ReportingValidator.
+func ValidateReport(v Validator, quote []byte, cert []byte, pp PackageProperties, ip InfrastructureProperties) (PackageProperties, error) {
+	if rv, ok := v.(ReportingValidator); ok {
+		return rv.ValidateReport(quote, cert, pp, ip)
+	}
+	return PackageProperties{}, v.Validate(quote, cert, pp, ip)
+}
+
 // Issuer issues quotes
 type Issuer interface {
 	// Issue issues a quote for remote attestation for a given message
@@ -0,0 +1,217 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package maavalidator implements a quote validator that delegates the verification to Microsoft Azure Attestation (MAA).
+package maavalidator
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+)
+
+// InfrastructureType is the Type of infrastructures whose quotes are validated by the MAAValidator.
+const InfrastructureType = "azure-maa"
+
+const apiVersion = "2020-10-01"
+
+// MAAValidator is a Quote validator that sends quotes to an MAA instance and validates the returned token
+//
+// The attestation provider and the certificates of its token signing keys are pinned in the InfrastructureProperties of the manifest.
+type MAAValidator struct {
+	client *http.Client
+}
+
+// NewMAAValidator returns a new MAAValidator object
+func NewMAAValidator() *MAAValidator {
+	return &MAAValidator{
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// claims are the SGX specific claims of an MAA token
+type claims struct {
+	Issuer       string `json:"iss"`
+	Expiry       int64  `json:"exp"`
+	NotBefore    int64  `json:"nbf"`
+	MREnclave    string `json:"x-ms-sgx-mrenclave"`
+	MRSigner     string `json:"x-ms-sgx-mrsigner"`
+	ProductID    uint64 `json:"x-ms-sgx-product-id"`
+	SVN          uint   `json:"x-ms-sgx-svn"`
+	IsDebuggable bool   `json:"x-ms-sgx-is-debuggable"`
+	ReportData   string `json:"x-ms-sgx-report-data"`
+}
+
+// Validate implements the Validator interface for MAAValidator
+func (m *MAAValidator) Validate(givenQuote []byte, cert []byte, pp quote.PackageProperties, ip quote.InfrastructureProperties) error {
+	_, err := m.ValidateReport(givenQuote, cert, pp, ip)
+	return err
+}
+
+// ValidateReport implements the ReportingValidator interface for MAAValidator
+func (m *MAAValidator) ValidateReport(givenQuote []byte, cert []byte, pp quote.PackageProperties, ip quote.InfrastructureProperties) (quote.PackageProperties, error) {
+	// MAA does not report the TCB of the platform, so the requirements could not be enforced
+	if ip.CPUSVN != nil || ip.QESVN != nil || ip.PCESVN != nil || ip.RootCA != nil {
+		return quote.PackageProperties{}, errors.New("CPUSVN, QESVN, PCESVN and RootCA are not supported for MAA, the TCB is verified according to the attestation policy")
+	}
+	if ip.AttestationURL == "" {
+		return quote.PackageProperties{}, errors.New("missing AttestationURL")
+	}
+	keys, err := parseSigningCerts(ip.SigningCerts)
+	if err != nil {
+		return quote.PackageProperties{}, err
+	}
+	url := strings.TrimSuffix(ip.AttestationURL, "/")
+
+	token, err := m.attest(url, givenQuote)
+	if err != nil {
+		return quote.PackageProperties{}, fmt.Errorf("attestation by MAA failed: %v", err)
+	}
+	c, err := verifyToken(token, url, keys)
+	if err != nil {
+		return quote.PackageProperties{}, fmt.Errorf("verifying MAA token failed: %v", err)
+	}
+
+	// Check that cert is equal
+	reportData, err := hex.DecodeString(c.ReportData)
+	if err != nil {
+		return quote.PackageProperties{}, fmt.Errorf("invalid report data: %v", err)
+	}
+	hash := sha256.Sum256(cert)
+	if len(reportData) < len(hash) || !bytes.Equal(reportData[:len(hash)], hash[:]) {
+		return quote.PackageProperties{}, fmt.Errorf("hash(cert) != report.Data: %v != %v", hash, reportData)
+	}
+
+	// Verify PackageProperties
+	reportedProps := quote.PackageProperties{
+		UniqueID:        c.MREnclave,
+		SignerID:        c.MRSigner,
+		Debug:           c.IsDebuggable,
+		ProductID:       &c.ProductID,
+		SecurityVersion: &c.SVN,
+	}
+	if !pp.IsCompliant(reportedProps) {
+		return quote.PackageProperties{}, fmt.Errorf("PackageProperties not compliant:\n%v\n%v", reportedProps, pp)
+	}
+
+	return reportedProps, nil
+}
+
+// parseSigningCerts returns the RSA public keys of the given PEM-encoded certificates.
+func parseSigningCerts(signingCerts []string) ([]*rsa.PublicKey, error) {
+	if len(signingCerts) == 0 {
+		return nil, errors.New("missing SigningCerts")
+	}
+	keys := make([]*rsa.PublicKey, 0, len(signingCerts))
+	for _, signingCert := range signingCerts {
+		block, _ := pem.Decode([]byte(signingCert))
+		if block == nil || block.Type != "CERTIFICATE" {
+			return nil, errors.New("invalid signing certificate")
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.New("signing certificate does not contain an RSA key")
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// attest sends the quote to MAA and returns the resulting token.
+func (m *MAAValidator) attest(url string, givenQuote []byte) (string, error) {
+	reqBody, err := json.Marshal(map[string]string{"report": base64.RawURLEncoding.EncodeToString(givenQuote)})
+	if err != nil {
+		return "", err
+	}
+	resp, err := m.client.Post(url+"/attest/OpenEnclave?api-version="+apiVersion, "application/json", bytes.NewReader(reqBody))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%v: %s", resp.Status, respBody)
+	}
+	var attestResp struct{ Token string }
+	if err := json.Unmarshal(respBody, &attestResp); err != nil {
+		return "", err
+	}
+	return attestResp.Token, nil
+}
+
+// verifyToken verifies that the token has been issued by issuer and signed with one of the keys, and returns its claims.
+func verifyToken(token string, issuer string, keys []*rsa.PublicKey) (*claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	var header struct {
+		Alg string
+	}
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported signing algorithm %v", header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	verified := false
+	for _, key := range keys {
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("token is not signed by any of the signing certificates")
+	}
+
+	rawClaims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	var c claims
+	if err := json.Unmarshal(rawClaims, &c); err != nil {
+		return nil, err
+	}
+	if c.Issuer != issuer {
+		return nil, fmt.Errorf("unexpected issuer %v", c.Issuer)
+	}
+	now := time.Now().Unix()
+	if now >= c.Expiry || now < c.NotBefore {
+		return nil, errors.New("token is expired or not yet valid")
+	}
+	return &c, nil
+}
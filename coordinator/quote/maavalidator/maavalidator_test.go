@@ -0,0 +1,148 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package maavalidator
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "MAA"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certRaw, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(err)
+
+	cert := []byte("marble certificate")
+	certHash := sha256.Sum256(cert)
+	validQuote := []byte("valid quote")
+
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/attest/OpenEnclave", func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ Report string }
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Report != base64.RawURLEncoding.EncodeToString(validQuote) {
+			http.Error(w, "invalid quote", http.StatusBadRequest)
+			return
+		}
+		token := mustCreateToken(t, key, map[string]interface{}{
+			"iss":                    server.URL,
+			"exp":                    time.Now().Add(time.Hour).Unix(),
+			"nbf":                    time.Now().Add(-time.Hour).Unix(),
+			"x-ms-sgx-mrenclave":     "0102",
+			"x-ms-sgx-mrsigner":      "0304",
+			"x-ms-sgx-product-id":    44,
+			"x-ms-sgx-svn":           3,
+			"x-ms-sgx-is-debuggable": false,
+			"x-ms-sgx-report-data":   hex.EncodeToString(append(certHash[:], make([]byte, 32)...)),
+		})
+		json.NewEncoder(w).Encode(map[string]string{"token": token})
+	})
+	server = httptest.NewServer(mux)
+	defer server.Close()
+
+	productID := uint64(44)
+	securityVersion := uint(2)
+	pp := quote.PackageProperties{
+		SignerID:        "0304",
+		ProductID:       &productID,
+		SecurityVersion: &securityVersion,
+	}
+	ip := quote.InfrastructureProperties{
+		Type:           InfrastructureType,
+		AttestationURL: server.URL,
+		SigningCerts:   []string{string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certRaw}))},
+	}
+
+	validator := NewMAAValidator()
+	assert.NoError(validator.Validate(validQuote, cert, pp, ip))
+
+	// quote rejected by MAA
+	assert.Error(validator.Validate([]byte("invalid quote"), cert, pp, ip))
+
+	// wrong message
+	assert.Error(validator.Validate(validQuote, []byte("other certificate"), pp, ip))
+
+	// wrong package
+	higherVersion := uint(4)
+	ppOther := pp
+	ppOther.SecurityVersion = &higherVersion
+	assert.Error(validator.Validate(validQuote, cert, ppOther, ip))
+	ppDebug := pp
+	ppDebug.Debug = true
+	assert.Error(validator.Validate(validQuote, cert, ppDebug, ip))
+
+	// token signed with an unknown key
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(err)
+	otherCertRaw, err := x509.CreateCertificate(rand.Reader, template, template, &otherKey.PublicKey, otherKey)
+	require.NoError(err)
+	ipOther := ip
+	ipOther.SigningCerts = []string{string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: otherCertRaw}))}
+	assert.Error(validator.Validate(validQuote, cert, pp, ipOther))
+
+	// token issued by another provider
+	ipOther = ip
+	ipOther.AttestationURL = server.URL + "/other"
+	assert.Error(validator.Validate(validQuote, cert, pp, ipOther))
+
+	// the provider and its signing certificates must be pinned
+	ipOther = ip
+	ipOther.AttestationURL = ""
+	assert.Error(validator.Validate(validQuote, cert, pp, ipOther))
+	ipOther = ip
+	ipOther.SigningCerts = nil
+	assert.Error(validator.Validate(validQuote, cert, pp, ipOther))
+
+	// TCB requirements cannot be enforced
+	qesvn := uint16(2)
+	ipOther = ip
+	ipOther.QESVN = &qesvn
+	assert.Error(validator.Validate(validQuote, cert, pp, ipOther))
+}
+
+func mustCreateToken(t *testing.T, key *rsa.PrivateKey, claims map[string]interface{}) string {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	require.NoError(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
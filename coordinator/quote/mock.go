@@ -10,6 +10,7 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"errors"
+	"fmt"
 	"sync"
 )
 
@@ -34,22 +35,31 @@ func NewMockValidator() *MockValidator {
 
 // Validate implements the Validator interface
 func (m *MockValidator) Validate(quote []byte, message []byte, pp PackageProperties, ip InfrastructureProperties) error {
+	_, err := m.ValidateReport(quote, message, pp, ip)
+	return err
+}
+
+// ValidateReport implements the ReportingValidator interface. The reported properties are the ones the quote has been added with.
+func (m *MockValidator) ValidateReport(quote []byte, message []byte, pp PackageProperties, ip InfrastructureProperties) (PackageProperties, error) {
 	m.mutex.Lock()
 	entry, found := m.valid[string(quote)]
 	m.mutex.Unlock()
 	if !found {
-		return errors.New("wrong quote")
+		return PackageProperties{}, errors.New("wrong quote")
 	}
 	if !bytes.Equal(entry.message, message) {
-		return errors.New("wrong message")
+		return PackageProperties{}, errors.New("wrong message")
 	}
 	if !pp.IsCompliant(entry.pp) {
-		return errors.New("package does not comply")
+		return PackageProperties{}, errors.New("package does not comply")
+	}
+	if err := ip.CheckTCB(entry.ip); err != nil {
+		return PackageProperties{}, fmt.Errorf("infrastructure does not comply: %w", err)
 	}
 	if !ip.IsCompliant(entry.ip) {
-		return errors.New("infrastructure does not comply")
+		return PackageProperties{}, errors.New("infrastructure does not comply")
 	}
-	return nil
+	return entry.pp, nil
 }
 
 // AddValidQuote adds a valid quote
@@ -0,0 +1,220 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package nitrovalidator
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// CBOR major types (RFC 7049)
+const (
+	majorUint   = 0
+	majorNegInt = 1
+	majorBytes  = 2
+	majorText   = 3
+	majorArray  = 4
+	majorMap    = 5
+	majorTag    = 6
+	majorSimple = 7
+)
+
+// maxNesting limits the recursion depth when decoding untrusted input.
+const maxNesting = 16
+
+// decodeCBOR decodes a single CBOR data item. Only the subset of CBOR used by Nitro attestation documents is supported.
+//
+// Items are decoded to uint64, int64, []byte, string, []interface{}, map[interface{}]interface{}, bool, or nil. Tags are skipped.
+func decodeCBOR(data []byte) (interface{}, error) {
+	item, rest, err := decodeItem(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("trailing data after CBOR item")
+	}
+	return item, nil
+}
+
+func decodeItem(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > maxNesting {
+		return nil, nil, errors.New("CBOR item is nested too deeply")
+	}
+	major, arg, data, err := decodeHead(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case majorUint:
+		return arg, data, nil
+	case majorNegInt:
+		if arg > 1<<63-1 {
+			return nil, nil, errors.New("CBOR negative integer overflows")
+		}
+		return -1 - int64(arg), data, nil
+	case majorBytes, majorText:
+		if uint64(len(data)) < arg {
+			return nil, nil, errors.New("unexpected end of CBOR data")
+		}
+		if major == majorText {
+			return string(data[:arg]), data[arg:], nil
+		}
+		return data[:arg], data[arg:], nil
+	case majorArray:
+		if uint64(len(data)) < arg {
+			return nil, nil, errors.New("unexpected end of CBOR data")
+		}
+		array := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			var item interface{}
+			item, data, err = decodeItem(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			array = append(array, item)
+		}
+		return array, data, nil
+	case majorMap:
+		if uint64(len(data)) < 2*arg {
+			return nil, nil, errors.New("unexpected end of CBOR data")
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			var key, value interface{}
+			key, data, err = decodeItem(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case uint64, int64, string:
+			default:
+				return nil, nil, errors.New("unsupported CBOR map key type")
+			}
+			value, data, err = decodeItem(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			m[key] = value
+		}
+		return m, data, nil
+	case majorTag:
+		return decodeItem(data, depth+1)
+	default:
+		switch arg {
+		case 20:
+			return false, data, nil
+		case 21:
+			return true, data, nil
+		case 22, 23:
+			return nil, data, nil
+		}
+		return nil, nil, fmt.Errorf("unsupported CBOR simple value %d", arg)
+	}
+}
+
+// decodeHead decodes the initial byte and argument of a data item.
+func decodeHead(data []byte) (major byte, arg uint64, rest []byte, err error) {
+	if len(data) == 0 {
+		return 0, 0, nil, errors.New("unexpected end of CBOR data")
+	}
+	major = data[0] >> 5
+	info := data[0] & 0x1f
+	data = data[1:]
+
+	var size int
+	switch {
+	case info < 24:
+		return major, uint64(info), data, nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, 0, nil, errors.New("indefinite-length CBOR items are not supported")
+	}
+	if len(data) < size {
+		return 0, 0, nil, errors.New("unexpected end of CBOR data")
+	}
+	buf := make([]byte, 8)
+	copy(buf[8-size:], data[:size])
+	return major, binary.BigEndian.Uint64(buf), data[size:], nil
+}
+
+// encodeCBOR encodes uint64, int, []byte, string, []interface{}, and map[interface{}]interface{} values.
+// Map keys are sorted by their encoding to produce a canonical representation.
+func encodeCBOR(item interface{}) ([]byte, error) {
+	switch v := item.(type) {
+	case uint64:
+		return encodeHead(majorUint, v), nil
+	case int:
+		if v < 0 {
+			return encodeHead(majorNegInt, uint64(-1-v)), nil
+		}
+		return encodeHead(majorUint, uint64(v)), nil
+	case []byte:
+		return append(encodeHead(majorBytes, uint64(len(v))), v...), nil
+	case string:
+		return append(encodeHead(majorText, uint64(len(v))), v...), nil
+	case []interface{}:
+		result := encodeHead(majorArray, uint64(len(v)))
+		for _, elem := range v {
+			encoded, err := encodeCBOR(elem)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, encoded...)
+		}
+		return result, nil
+	case map[interface{}]interface{}:
+		var entries [][]byte
+		for key, value := range v {
+			encodedKey, err := encodeCBOR(key)
+			if err != nil {
+				return nil, err
+			}
+			encodedValue, err := encodeCBOR(value)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, append(encodedKey, encodedValue...))
+		}
+		sort.Slice(entries, func(i, j int) bool { return string(entries[i]) < string(entries[j]) })
+		result := encodeHead(majorMap, uint64(len(v)))
+		for _, entry := range entries {
+			result = append(result, entry...)
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("cannot encode %T as CBOR", item)
+}
+
+func encodeHead(major byte, arg uint64) []byte {
+	switch {
+	case arg < 24:
+		return []byte{major<<5 | byte(arg)}
+	case arg <= 0xff:
+		return []byte{major<<5 | 24, byte(arg)}
+	case arg <= 0xffff:
+		buf := []byte{major<<5 | 25, 0, 0}
+		binary.BigEndian.PutUint16(buf[1:], uint16(arg))
+		return buf
+	case arg <= 0xffffffff:
+		buf := []byte{major<<5 | 26, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(buf[1:], uint32(arg))
+		return buf
+	}
+	buf := []byte{major<<5 | 27, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint64(buf[1:], arg)
+	return buf
+}
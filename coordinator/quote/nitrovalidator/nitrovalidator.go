@@ -0,0 +1,194 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package nitrovalidator implements the verification of AWS Nitro Enclaves attestation documents.
+package nitrovalidator
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+)
+
+// InfrastructureType is the Type of infrastructures whose quotes are validated by the NitroValidator.
+const InfrastructureType = "aws-nitro"
+
+// algorithm identifier of ECDSA with SHA-384 in COSE (RFC 8152)
+const coseES384 = -35
+
+// attestationDocument contains the fields of a Nitro attestation document that are relevant for validation.
+type attestationDocument struct {
+	digest      string
+	timestamp   time.Time
+	pcrs        map[uint]string
+	certificate *x509.Certificate
+	caBundle    []*x509.Certificate
+	userData    []byte
+}
+
+// NitroValidator is a Quote validator for AWS Nitro Enclaves attestation documents
+type NitroValidator struct {
+}
+
+// NewNitroValidator returns a new NitroValidator object
+func NewNitroValidator() *NitroValidator {
+	return &NitroValidator{}
+}
+
+// Validate implements the Validator interface for NitroValidator
+func (m *NitroValidator) Validate(givenQuote []byte, cert []byte, pp quote.PackageProperties, ip quote.InfrastructureProperties) error {
+	_, err := m.ValidateReport(givenQuote, cert, pp, ip)
+	return err
+}
+
+// ValidateReport implements the ReportingValidator interface for NitroValidator
+//
+// ip.RootCA must contain the AWS Nitro Enclaves root certificate. The hash of cert is expected in the document's user data.
+func (m *NitroValidator) ValidateReport(givenQuote []byte, cert []byte, pp quote.PackageProperties, ip quote.InfrastructureProperties) (quote.PackageProperties, error) {
+	doc, err := verifyDocument(givenQuote, ip.RootCA)
+	if err != nil {
+		return quote.PackageProperties{}, fmt.Errorf("verifying attestation document failed: %v", err)
+	}
+
+	// Check that cert is equal
+	hash := sha256.Sum256(cert)
+	if len(doc.userData) < len(hash) || !bytes.Equal(doc.userData[:len(hash)], hash[:]) {
+		return quote.PackageProperties{}, fmt.Errorf("hash(cert) != user data: %v != %v", hash, doc.userData)
+	}
+
+	// Verify PackageProperties
+	reportedProps := quote.PackageProperties{PCRs: doc.pcrs}
+	if !pp.IsCompliant(reportedProps) {
+		return quote.PackageProperties{}, fmt.Errorf("PackageProperties not compliant:\n%v\n%v", reportedProps, pp)
+	}
+	return reportedProps, nil
+}
+
+// verifyDocument verifies the COSE_Sign1 signature of the attestation document and its certificate chain up to the given root CA.
+func verifyDocument(raw []byte, rootCA []byte) (*attestationDocument, error) {
+	item, err := decodeCBOR(raw)
+	if err != nil {
+		return nil, err
+	}
+	coseSign1, ok := item.([]interface{})
+	if !ok || len(coseSign1) != 4 {
+		return nil, errors.New("document is not a COSE_Sign1 structure")
+	}
+	protected, ok1 := coseSign1[0].([]byte)
+	payload, ok2 := coseSign1[2].([]byte)
+	signature, ok3 := coseSign1[3].([]byte)
+	if !ok1 || !ok2 || !ok3 {
+		return nil, errors.New("invalid COSE_Sign1 structure")
+	}
+
+	// check the signing algorithm
+	protectedHeader, err := decodeCBOR(protected)
+	if err != nil {
+		return nil, err
+	}
+	headerMap, ok := protectedHeader.(map[interface{}]interface{})
+	if !ok || headerMap[uint64(1)] != int64(coseES384) {
+		return nil, errors.New("unsupported signing algorithm")
+	}
+
+	doc, err := parsePayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	// The signing certificates are short-lived, so a document can only be verified shortly after its creation.
+	if err := quote.VerifyCertChain(doc.certificate, doc.caBundle, rootCA); err != nil {
+		return nil, err
+	}
+
+	// verify signature over Sig_structure = ["Signature1", protected, external_aad, payload]
+	sigStructure, err := encodeCBOR([]interface{}{"Signature1", protected, []byte{}, payload})
+	if err != nil {
+		return nil, err
+	}
+	key, ok := doc.certificate.PublicKey.(*ecdsa.PublicKey)
+	if !ok || key.Curve != elliptic.P384() || len(signature) != 96 {
+		return nil, errors.New("unexpected signature format")
+	}
+	hash := sha512.Sum384(sigStructure)
+	r := new(big.Int).SetBytes(signature[:48])
+	s := new(big.Int).SetBytes(signature[48:])
+	if !ecdsa.Verify(key, hash[:], r, s) {
+		return nil, errors.New("invalid signature")
+	}
+	return doc, nil
+}
+
+func parsePayload(payload []byte) (*attestationDocument, error) {
+	item, err := decodeCBOR(payload)
+	if err != nil {
+		return nil, err
+	}
+	fields, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("payload is not a map")
+	}
+
+	var doc attestationDocument
+	if doc.digest, ok = fields["digest"].(string); !ok || doc.digest != "SHA384" {
+		return nil, errors.New("unsupported PCR digest")
+	}
+	timestamp, ok := fields["timestamp"].(uint64)
+	if !ok {
+		return nil, errors.New("missing timestamp")
+	}
+	doc.timestamp = time.Unix(0, int64(timestamp)*int64(time.Millisecond))
+
+	pcrs, ok := fields["pcrs"].(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("missing PCRs")
+	}
+	doc.pcrs = make(map[uint]string, len(pcrs))
+	for index, value := range pcrs {
+		i, ok1 := index.(uint64)
+		v, ok2 := value.([]byte)
+		if !ok1 || !ok2 || i > 31 {
+			return nil, errors.New("invalid PCR")
+		}
+		doc.pcrs[uint(i)] = hex.EncodeToString(v)
+	}
+
+	rawCert, ok := fields["certificate"].([]byte)
+	if !ok {
+		return nil, errors.New("missing certificate")
+	}
+	if doc.certificate, err = x509.ParseCertificate(rawCert); err != nil {
+		return nil, err
+	}
+	caBundle, ok := fields["cabundle"].([]interface{})
+	if !ok {
+		return nil, errors.New("missing CA bundle")
+	}
+	for _, entry := range caBundle {
+		rawCA, ok := entry.([]byte)
+		if !ok {
+			return nil, errors.New("invalid CA bundle")
+		}
+		ca, err := x509.ParseCertificate(rawCA)
+		if err != nil {
+			return nil, err
+		}
+		doc.caBundle = append(doc.caBundle, ca)
+	}
+
+	// user data is optional and may be null
+	doc.userData, _ = fields["user_data"].([]byte)
+	return &doc, nil
+}
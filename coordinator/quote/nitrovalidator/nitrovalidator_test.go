@@ -0,0 +1,156 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package nitrovalidator
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/coordinator/quote/quotetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCBOR(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	item := map[interface{}]interface{}{
+		"text":    "value",
+		"bytes":   []byte{1, 2, 3},
+		uint64(1): []interface{}{uint64(0), uint64(23), uint64(24), uint64(1000), uint64(1 << 40)},
+		"neg":     -35,
+	}
+	encoded, err := encodeCBOR(item)
+	require.NoError(err)
+	decoded, err := decodeCBOR(encoded)
+	require.NoError(err)
+	decodedMap := decoded.(map[interface{}]interface{})
+	assert.Equal("value", decodedMap["text"])
+	assert.Equal([]byte{1, 2, 3}, decodedMap["bytes"])
+	assert.Equal([]interface{}{uint64(0), uint64(23), uint64(24), uint64(1000), uint64(1 << 40)}, decodedMap[uint64(1)])
+	assert.Equal(int64(-35), decodedMap["neg"])
+
+	// tagged item, simple values
+	decoded, err = decodeCBOR([]byte{0xd2, 0x83, 0xf4, 0xf5, 0xf6})
+	require.NoError(err)
+	assert.Equal([]interface{}{false, true, nil}, decoded)
+
+	// invalid input
+	_, err = decodeCBOR(encoded[:len(encoded)-1])
+	assert.Error(err)
+	_, err = decodeCBOR(append(encoded, 0))
+	assert.Error(err)
+	_, err = decodeCBOR([]byte{0x9f})
+	assert.Error(err)
+	_, err = decodeCBOR([]byte{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	assert.Error(err)
+}
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	root, rootKey := quotetest.MustCreateCert(t, elliptic.P384(), "root", true, nil, nil)
+	intermediate, intermediateKey := quotetest.MustCreateCert(t, elliptic.P384(), "intermediate", true, root, rootKey)
+	leaf, leafKey := quotetest.MustCreateCert(t, elliptic.P384(), "leaf", false, intermediate, intermediateKey)
+
+	cert := []byte("marble certificate")
+	certHash := sha256.Sum256(cert)
+	pcr0 := make([]byte, 48)
+	pcr0[0] = 1
+	payload := map[interface{}]interface{}{
+		"module_id":   "i-0123-enc0123",
+		"digest":      "SHA384",
+		"timestamp":   uint64(time.Now().UnixNano() / int64(time.Millisecond)),
+		"pcrs":        map[interface{}]interface{}{uint64(0): pcr0, uint64(1): make([]byte, 48)},
+		"certificate": leaf.Raw,
+		"cabundle":    []interface{}{root.Raw, intermediate.Raw},
+		"user_data":   certHash[:],
+	}
+	validDoc := mustCreateDocument(t, payload, leafKey)
+
+	pp := quote.PackageProperties{PCRs: map[uint]string{0: hex.EncodeToString(pcr0)}}
+	ip := quote.InfrastructureProperties{Type: InfrastructureType, RootCA: quotetest.ToPEM(root)}
+
+	validator := NewNitroValidator()
+	require.NoError(validator.Validate(validDoc, cert, pp, ip))
+
+	// wrong message
+	assert.Error(validator.Validate(validDoc, []byte("other certificate"), pp, ip))
+
+	// wrong package
+	assert.Error(validator.Validate(validDoc, cert, quote.PackageProperties{PCRs: map[uint]string{0: hex.EncodeToString(make([]byte, 48))}}, ip))
+	assert.Error(validator.Validate(validDoc, cert, quote.PackageProperties{PCRs: map[uint]string{2: hex.EncodeToString(pcr0)}}, ip))
+	assert.Error(validator.Validate(validDoc, cert, quote.PackageProperties{UniqueID: "0102"}, ip))
+
+	// wrong root CA
+	otherRoot, _ := quotetest.MustCreateCert(t, elliptic.P384(), "root", true, nil, nil)
+	assert.Error(validator.Validate(validDoc, cert, pp, quote.InfrastructureProperties{RootCA: otherRoot.Raw}))
+	assert.Error(validator.Validate(validDoc, cert, pp, quote.InfrastructureProperties{}))
+
+	// signed by another key
+	_, otherKey := quotetest.MustCreateCert(t, elliptic.P384(), "leaf", false, intermediate, intermediateKey)
+	assert.Error(validator.Validate(mustCreateDocument(t, payload, otherKey), cert, pp, ip))
+
+	// expired signing certificate, even if the document claims to be created while it was valid
+	expiredTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "leaf"},
+		NotBefore:    time.Now().Add(-50 * time.Minute),
+		NotAfter:     time.Now().Add(-10 * time.Minute),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	expiredRaw, err := x509.CreateCertificate(rand.Reader, expiredTemplate, intermediate, &leafKey.PublicKey, intermediateKey)
+	require.NoError(err)
+	expiredPayload := map[interface{}]interface{}{}
+	for k, v := range payload {
+		expiredPayload[k] = v
+	}
+	expiredPayload["certificate"] = expiredRaw
+	expiredPayload["timestamp"] = uint64(time.Now().Add(-30*time.Minute).UnixNano() / int64(time.Millisecond))
+	assert.Error(validator.Validate(mustCreateDocument(t, expiredPayload, leafKey), cert, pp, ip))
+
+	// tampered document
+	tampered := append([]byte(nil), validDoc...)
+	tampered[len(tampered)-100] ^= 1
+	assert.Error(validator.Validate(tampered, cert, pp, ip))
+	assert.Error(validator.Validate(validDoc[:len(validDoc)-1], cert, pp, ip))
+}
+
+func mustCreateDocument(t *testing.T, payload map[interface{}]interface{}, key *ecdsa.PrivateKey) []byte {
+	require := require.New(t)
+
+	protected, err := encodeCBOR(map[interface{}]interface{}{uint64(1): coseES384})
+	require.NoError(err)
+	rawPayload, err := encodeCBOR(payload)
+	require.NoError(err)
+	sigStructure, err := encodeCBOR([]interface{}{"Signature1", protected, []byte{}, rawPayload})
+	require.NoError(err)
+
+	hash := sha512.Sum384(sigStructure)
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	require.NoError(err)
+	signature := make([]byte, 96)
+	rBytes, sBytes := r.Bytes(), s.Bytes()
+	copy(signature[48-len(rBytes):48], rBytes)
+	copy(signature[96-len(sBytes):], sBytes)
+
+	doc, err := encodeCBOR([]interface{}{protected, map[interface{}]interface{}{}, rawPayload, signature})
+	require.NoError(err)
+	return doc
+}
@@ -0,0 +1,54 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package quotetest provides utilities to create certificate chains for testing quote validators.
+package quotetest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// MustCreateCert creates an ECDSA certificate on the given curve that is valid for the next hour.
+//
+// The certificate is signed by parent, or self-signed if parent is nil.
+func MustCreateCert(t *testing.T, curve elliptic.Curve, commonName string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, extensions ...pkix.Extension) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtraExtensions:       extensions,
+	}
+	if parent == nil {
+		parent = template
+		parentKey = key
+	}
+	certRaw, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(certRaw)
+	require.NoError(t, err)
+	return cert, key
+}
+
+// ToPEM returns the PEM encoding of cert.
+func ToPEM(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
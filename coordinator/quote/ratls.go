@@ -0,0 +1,26 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package quote
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+)
+
+// OIDRATLSQuote is the object identifier of the X.509 extension that holds a quote (RA-TLS).
+// It is the same identifier Open Enclave uses for its attested TLS certificates.
+var OIDRATLSQuote = asn1.ObjectIdentifier{1, 2, 840, 113556, 10, 1, 1}
+
+// GetRATLSQuote returns the quote embedded in cert, or nil if cert does not contain one.
+func GetRATLSQuote(cert *x509.Certificate) []byte {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(OIDRATLSQuote) {
+			return ext.Value
+		}
+	}
+	return nil
+}
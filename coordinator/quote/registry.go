@@ -0,0 +1,101 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package quote
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Registry is a Validator that dispatches to the Validator registered for the Type of the given InfrastructureProperties.
+// This allows a single Coordinator to accept Marbles that are attested with different schemes.
+type Registry struct {
+	mutex            sync.RWMutex
+	validators       map[string]Validator
+	defaultValidator Validator
+}
+
+// NewRegistry returns a new Registry object
+//
+// defaultValidator is used for infrastructures that do not specify a Type. It may be nil.
+func NewRegistry(defaultValidator Validator) *Registry {
+	return &Registry{
+		validators:       make(map[string]Validator),
+		defaultValidator: defaultValidator,
+	}
+}
+
+// Register registers a Validator for the given infrastructure type, replacing any previously registered one
+func (r *Registry) Register(infrastructureType string, validator Validator) {
+	r.mutex.Lock()
+	r.validators[infrastructureType] = validator
+	r.mutex.Unlock()
+}
+
+// Validate implements the Validator interface for Registry
+func (r *Registry) Validate(quote []byte, cert []byte, pp PackageProperties, ip InfrastructureProperties) error {
+	validator, err := r.get(ip.Type)
+	if err != nil {
+		return err
+	}
+	return validator.Validate(quote, cert, pp, ip)
+}
+
+// ValidateReport implements the ReportingValidator interface for Registry
+func (r *Registry) ValidateReport(quote []byte, cert []byte, pp PackageProperties, ip InfrastructureProperties) (PackageProperties, error) {
+	validator, err := r.get(ip.Type)
+	if err != nil {
+		return PackageProperties{}, err
+	}
+	return ValidateReport(validator, quote, cert, pp, ip)
+}
+
+// CollateralStatus implements the CollateralReporter interface for Registry. It reports the collateral of the registered
+// Validators that are CollateralReporters, ordered by infrastructure type.
+func (r *Registry) CollateralStatus() []CollateralStatus {
+	r.mutex.RLock()
+	types := make([]string, 0, len(r.validators))
+	for infrastructureType := range r.validators {
+		types = append(types, infrastructureType)
+	}
+	validators := make(map[string]Validator, len(r.validators))
+	for infrastructureType, validator := range r.validators {
+		validators[infrastructureType] = validator
+	}
+	r.mutex.RUnlock()
+	sort.Strings(types)
+
+	var statuses []CollateralStatus
+	for _, infrastructureType := range types {
+		reporter, ok := validators[infrastructureType].(CollateralReporter)
+		if !ok {
+			continue
+		}
+		for _, status := range reporter.CollateralStatus() {
+			status.Type = infrastructureType
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses
+}
+
+func (r *Registry) get(infrastructureType string) (Validator, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if infrastructureType == "" {
+		if r.defaultValidator == nil {
+			return nil, fmt.Errorf("infrastructure does not specify a type and no default validator is set")
+		}
+		return r.defaultValidator, nil
+	}
+	validator, ok := r.validators[infrastructureType]
+	if !ok {
+		return nil, fmt.Errorf("no validator registered for infrastructure type %v", infrastructureType)
+	}
+	return validator, nil
+}
@@ -0,0 +1,87 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package quote
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	assert := assert.New(t)
+
+	message := []byte("message")
+	mockQuote := []byte("quote")
+	mock := NewMockValidator()
+	mock.AddValidQuote(mockQuote, message, PackageProperties{}, InfrastructureProperties{Type: "mock"})
+
+	// without default validator
+	registry := NewRegistry(nil)
+	registry.Register("mock", mock)
+	assert.NoError(registry.Validate(mockQuote, message, PackageProperties{}, InfrastructureProperties{Type: "mock"}))
+	assert.Error(registry.Validate(mockQuote, message, PackageProperties{}, InfrastructureProperties{Type: "other"}))
+	assert.Error(registry.Validate(mockQuote, message, PackageProperties{}, InfrastructureProperties{}))
+
+	// other validators are independent
+	registry.Register("fail", NewFailValidator())
+	assert.Error(registry.Validate(mockQuote, message, PackageProperties{}, InfrastructureProperties{Type: "fail"}))
+	assert.NoError(registry.Validate(mockQuote, message, PackageProperties{}, InfrastructureProperties{Type: "mock"}))
+
+	// with default validator
+	registry = NewRegistry(NewFailValidator())
+	registry.Register("mock", mock)
+	assert.Error(registry.Validate(mockQuote, message, PackageProperties{}, InfrastructureProperties{}))
+	assert.NoError(registry.Validate(mockQuote, message, PackageProperties{}, InfrastructureProperties{Type: "mock"}))
+}
+
+func TestRegistryValidateReport(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	message := []byte("message")
+	mockQuote := []byte("quote")
+	securityVersion := uint(2)
+	reportedProps := PackageProperties{UniqueID: "unique", ProductID: new(uint64), SecurityVersion: &securityVersion}
+	mock := NewMockValidator()
+	mock.AddValidQuote(mockQuote, message, reportedProps, InfrastructureProperties{Type: "mock"})
+
+	registry := NewRegistry(NewFailValidator())
+	registry.Register("mock", mock)
+
+	// the properties reported by the quote are returned, not the required ones
+	props, err := ValidateReport(registry, mockQuote, message, PackageProperties{UniqueID: "unique"}, InfrastructureProperties{Type: "mock"})
+	require.NoError(err)
+	assert.Equal(reportedProps, props)
+
+	_, err = ValidateReport(registry, mockQuote, message, PackageProperties{UniqueID: "other"}, InfrastructureProperties{Type: "mock"})
+	assert.Error(err)
+	_, err = ValidateReport(registry, mockQuote, message, PackageProperties{}, InfrastructureProperties{})
+	assert.Error(err)
+}
+
+// reportingValidator is a Validator that reports collateral.
+type reportingValidator struct {
+	*FailValidator
+	statuses []CollateralStatus
+}
+
+func (v reportingValidator) CollateralStatus() []CollateralStatus {
+	return v.statuses
+}
+
+func TestRegistryCollateralStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	registry := NewRegistry(nil)
+	registry.Register("mock", NewMockValidator())
+	registry.Register("dcap", reportingValidator{FailValidator: NewFailValidator(), statuses: []CollateralStatus{{Name: "qe_identity", TCBEvaluationDataNumber: 12}}})
+
+	// only the collateral of reporting validators is returned, with their infrastructure type
+	assert.Equal([]CollateralStatus{{Type: "dcap", Name: "qe_identity", TCBEvaluationDataNumber: 12}}, registry.CollateralStatus())
+}
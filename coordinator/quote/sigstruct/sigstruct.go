@@ -0,0 +1,202 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package sigstruct derives the package properties of an SGX enclave from its SIGSTRUCT, so that they don't need to be copied into the manifest by hand.
+//
+// The SIGSTRUCT is read from a file that contains it, e.g., created by "oesign dump" or "sgx_sign", or from an OpenEnclave or EGo binary that
+// has been signed.
+package sigstruct
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+)
+
+// Size is the size of a SIGSTRUCT in bytes.
+const Size = 1808
+
+// Offsets of the fields of a SIGSTRUCT, see the Intel SDM, volume 3D, section 38.13.
+const (
+	offsetHeader      = 0
+	offsetHeader2     = 24
+	offsetModulus     = 128
+	offsetAttributes  = 928
+	offsetEnclaveHash = 960
+	offsetISVProdID   = 1024
+	offsetISVSVN      = 1026
+
+	modulusSize = 384
+)
+
+// attributeDebug is the flag of the enclave attributes that marks a debug enclave.
+const attributeDebug = 0x2
+
+var (
+	header  = []byte{0x06, 0, 0, 0, 0xe1, 0, 0, 0, 0, 0, 0x01, 0, 0, 0, 0, 0}
+	header2 = []byte{0x01, 0x01, 0, 0, 0x60, 0, 0, 0, 0x60, 0, 0, 0, 0x01, 0, 0, 0}
+)
+
+// ErrNotSigned is returned if an enclave binary contains no SIGSTRUCT, i.e., it has not been signed.
+var ErrNotSigned = errors.New("the enclave has not been signed")
+
+// Parse returns the package properties of the enclave described by a SIGSTRUCT.
+//
+// UniqueID is the MRENCLAVE and SignerID the MRSIGNER, i.e., the SHA-256 hash of the modulus of the signing key, both hex-encoded.
+func Parse(sigstruct []byte) (quote.PackageProperties, error) {
+	if len(sigstruct) != Size {
+		return quote.PackageProperties{}, fmt.Errorf("invalid SIGSTRUCT size %d, expected %d", len(sigstruct), Size)
+	}
+	if !bytes.Equal(sigstruct[offsetHeader:offsetHeader+len(header)], header) || !bytes.Equal(sigstruct[offsetHeader2:offsetHeader2+len(header2)], header2) {
+		return quote.PackageProperties{}, errors.New("invalid SIGSTRUCT header")
+	}
+	modulus := sigstruct[offsetModulus : offsetModulus+modulusSize]
+	if bytes.Equal(modulus, make([]byte, modulusSize)) {
+		return quote.PackageProperties{}, ErrNotSigned
+	}
+
+	signerID := sha256.Sum256(modulus)
+	productID := uint64(binary.LittleEndian.Uint16(sigstruct[offsetISVProdID:]))
+	securityVersion := uint(binary.LittleEndian.Uint16(sigstruct[offsetISVSVN:]))
+	return quote.PackageProperties{
+		Debug:           binary.LittleEndian.Uint64(sigstruct[offsetAttributes:])&attributeDebug != 0,
+		UniqueID:        hex.EncodeToString(sigstruct[offsetEnclaveHash : offsetEnclaveHash+sha256.Size]),
+		SignerID:        hex.EncodeToString(signerID[:]),
+		ProductID:       &productID,
+		SecurityVersion: &securityVersion,
+	}, nil
+}
+
+// Load returns the package properties of an enclave from a SIGSTRUCT or a signed enclave binary.
+//
+// In an ELF binary, the SIGSTRUCT is looked up in the .oeinfo section, where OpenEnclave and EGo store it when the enclave is signed.
+func Load(data []byte) (quote.PackageProperties, error) {
+	if len(data) == Size {
+		return Parse(data)
+	}
+	file, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return quote.PackageProperties{}, errors.New("neither a SIGSTRUCT nor an ELF binary")
+	}
+	section := file.Section(".oeinfo")
+	if section == nil {
+		return quote.PackageProperties{}, errors.New("the binary has no .oeinfo section, it is not an OpenEnclave or EGo enclave")
+	}
+	sectionData, err := section.Data()
+	if err != nil {
+		return quote.PackageProperties{}, err
+	}
+	sigstruct, ok := find(sectionData)
+	if !ok {
+		return quote.PackageProperties{}, ErrNotSigned
+	}
+	return Parse(sigstruct)
+}
+
+// LoadFile is like Load, but reads the SIGSTRUCT or enclave binary from a file.
+func LoadFile(filename string) (quote.PackageProperties, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return quote.PackageProperties{}, err
+	}
+	return Load(data)
+}
+
+// find returns the first SIGSTRUCT in data.
+func find(data []byte) ([]byte, bool) {
+	for offset := 0; offset+Size <= len(data); offset++ {
+		index := bytes.Index(data[offset:], header)
+		if index < 0 || offset+index+Size > len(data) {
+			return nil, false
+		}
+		offset += index
+		if bytes.Equal(data[offset+offsetHeader2:offset+offsetHeader2+len(header2)], header2) {
+			return data[offset : offset+Size], true
+		}
+	}
+	return nil, false
+}
+
+// ManifestPackage returns the entry of the manifest's Packages that identifies the enclave.
+//
+// By default, the enclave is identified by its SignerID, ProductID and SecurityVersion, so that the manifest accepts updated versions
+// of the enclave that are signed with the same key. If pinUniqueID is set, only the enclave with the exact UniqueID is accepted.
+func ManifestPackage(properties quote.PackageProperties, pinUniqueID bool) quote.PackageProperties {
+	pkg := quote.PackageProperties{Debug: properties.Debug}
+	if pinUniqueID {
+		pkg.UniqueID = properties.UniqueID
+	} else {
+		pkg.SignerID = properties.SignerID
+		pkg.ProductID = properties.ProductID
+		pkg.SecurityVersion = properties.SecurityVersion
+	}
+	return pkg
+}
+
+// MergePackage sets the package name of the manifest in JSON format to pkg and returns the indented manifest.
+//
+// The other entries of the manifest are kept as they are, but the keys of the manifest and its Packages are sorted.
+func MergePackage(rawManifest []byte, name string, pkg quote.PackageProperties) ([]byte, error) {
+	var manifest map[string]json.RawMessage
+	if err := json.Unmarshal(rawManifest, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+	if manifest == nil {
+		manifest = make(map[string]json.RawMessage)
+	}
+	packages := make(map[string]json.RawMessage)
+	if rawPackages, ok := manifest["Packages"]; ok {
+		if err := json.Unmarshal(rawPackages, &packages); err != nil {
+			return nil, fmt.Errorf("invalid Packages of the manifest: %v", err)
+		}
+		if packages == nil {
+			packages = make(map[string]json.RawMessage)
+		}
+	}
+
+	rawPackage, err := MarshalPackage(pkg)
+	if err != nil {
+		return nil, err
+	}
+	packages[name] = rawPackage
+	if manifest["Packages"], err = json.Marshal(packages); err != nil {
+		return nil, err
+	}
+	merged, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append(merged, '\n'), nil
+}
+
+// MarshalPackage encodes a package in JSON format, omitting the properties that are not set.
+func MarshalPackage(pkg quote.PackageProperties) ([]byte, error) {
+	entry := make(map[string]interface{})
+	if pkg.Debug {
+		entry["Debug"] = true
+	}
+	if pkg.UniqueID != "" {
+		entry["UniqueID"] = pkg.UniqueID
+	}
+	if pkg.SignerID != "" {
+		entry["SignerID"] = pkg.SignerID
+	}
+	if pkg.ProductID != nil {
+		entry["ProductID"] = *pkg.ProductID
+	}
+	if pkg.SecurityVersion != nil {
+		entry["SecurityVersion"] = *pkg.SecurityVersion
+	}
+	return json.Marshal(entry)
+}
@@ -0,0 +1,121 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package sigstruct
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSigStruct(debug bool) []byte {
+	sigstruct := make([]byte, Size)
+	copy(sigstruct[offsetHeader:], header)
+	copy(sigstruct[offsetHeader2:], header2)
+	for i := 0; i < modulusSize; i++ {
+		sigstruct[offsetModulus+i] = byte(i)
+	}
+	if debug {
+		sigstruct[offsetAttributes] = attributeDebug
+	}
+	for i := 0; i < sha256.Size; i++ {
+		sigstruct[offsetEnclaveHash+i] = byte(i)
+	}
+	binary.LittleEndian.PutUint16(sigstruct[offsetISVProdID:], 3)
+	binary.LittleEndian.PutUint16(sigstruct[offsetISVSVN:], 258)
+	return sigstruct
+}
+
+func TestParse(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	sigstruct := newTestSigStruct(true)
+	properties, err := Load(sigstruct)
+	require.NoError(err)
+	signerID := sha256.Sum256(sigstruct[offsetModulus : offsetModulus+modulusSize])
+	assert.True(properties.Debug)
+	assert.Equal("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f", properties.UniqueID)
+	assert.Equal(hex.EncodeToString(signerID[:]), properties.SignerID)
+	assert.EqualValues(3, *properties.ProductID)
+	assert.EqualValues(258, *properties.SecurityVersion)
+
+	properties, err = Parse(newTestSigStruct(false))
+	require.NoError(err)
+	assert.False(properties.Debug)
+
+	_, err = Parse(sigstruct[1:])
+	assert.Error(err)
+	invalid := newTestSigStruct(false)
+	invalid[offsetHeader2] = 0
+	_, err = Parse(invalid)
+	assert.Error(err)
+	unsigned := newTestSigStruct(false)
+	copy(unsigned[offsetModulus:], make([]byte, modulusSize))
+	_, err = Parse(unsigned)
+	assert.Equal(ErrNotSigned, err)
+
+	// not an enclave
+	_, err = Load([]byte("not an enclave"))
+	assert.Error(err)
+}
+
+func TestFind(t *testing.T) {
+	assert := assert.New(t)
+
+	sigstruct := newTestSigStruct(false)
+	// the header may also occur elsewhere in the data
+	data := append(append([]byte{1, 2, 3}, header...), sigstruct...)
+	found, ok := find(append(data, 4, 5, 6))
+	assert.True(ok)
+	assert.Equal(sigstruct, found)
+
+	_, ok = find(data[:len(data)-1])
+	assert.False(ok)
+	_, ok = find(make([]byte, 2*Size))
+	assert.False(ok)
+}
+
+func TestMergePackage(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	properties, err := Parse(newTestSigStruct(false))
+	require.NoError(err)
+	pkg := ManifestPackage(properties, false)
+	assert.Empty(pkg.UniqueID)
+	assert.Equal(properties.SignerID, pkg.SignerID)
+	pkg = ManifestPackage(properties, true)
+	assert.Equal(properties.UniqueID, pkg.UniqueID)
+	assert.Empty(pkg.SignerID)
+	assert.Nil(pkg.ProductID)
+
+	rawManifest := []byte(`{"Packages": {"frontend": {"UniqueID": "0102"}, "backend": {"UniqueID": "0304"}}, "Marbles": {"backend": {"Package": "backend"}}}`)
+	merged, err := MergePackage(rawManifest, "backend", ManifestPackage(properties, false))
+	require.NoError(err)
+	var manifest struct {
+		Packages map[string]quote.PackageProperties
+		Marbles  map[string]json.RawMessage
+	}
+	require.NoError(json.Unmarshal(merged, &manifest))
+	assert.Equal("0102", manifest.Packages["frontend"].UniqueID)
+	assert.Equal(ManifestPackage(properties, false), manifest.Packages["backend"])
+	assert.Contains(manifest.Marbles, "backend")
+
+	merged, err = MergePackage([]byte(`{}`), "backend", pkg)
+	require.NoError(err)
+	assert.JSONEq(`{"Packages": {"backend": {"UniqueID": "`+properties.UniqueID+`"}}}`, string(merged))
+
+	_, err = MergePackage([]byte(`{"Packages": []}`), "backend", pkg)
+	assert.Error(err)
+}
@@ -0,0 +1,23 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package quote
+
+// SimulationIssuer is used if the Coordinator or a Marble explicitly runs in simulation mode.
+// It does not issue quotes, so the integrity of the mesh cannot be guaranteed.
+type SimulationIssuer struct{}
+
+// NewSimulationIssuer returns a new SimulationIssuer object
+func NewSimulationIssuer() *SimulationIssuer {
+	return &SimulationIssuer{}
+}
+
+// Issue implements the Issuer interface
+//
+// It returns an empty quote, which is only accepted by a Coordinator that runs in simulation mode as well.
+func (m *SimulationIssuer) Issue(cert []byte) ([]byte, error) {
+	return []byte{}, nil
+}
@@ -0,0 +1,240 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package snpvalidator implements the verification of AMD SEV-SNP attestation reports.
+//
+// A quote of an SEV-SNP guest consists of the raw attestation report followed by the PEM-encoded
+// VCEK certificate of the chip and, optionally, the ASK certificate.
+package snpvalidator
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+)
+
+// InfrastructureType is the Type of infrastructures whose quotes are validated by the SNPValidator.
+const InfrastructureType = "amd-sev-snp"
+
+// Layout of an attestation report as specified in the SEV Secure Nested Paging Firmware ABI Specification.
+const (
+	reportLen          = 0x4a0
+	signedLen          = 0x2a0
+	signatureAlgoECDSA = 1 // ECDSA P-384 with SHA-384
+	policyDebugFlag    = 1 << 19
+	signatureComponent = 72 // r and s are zero-extended little-endian integers
+)
+
+// oidHardwareID is the VCEK certificate extension containing the chip ID.
+var oidHardwareID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 3704, 1, 4}
+
+// tcbComponents are the security patch levels (SPL) of the TCB a VCEK is derived from.
+// Each is stored in a VCEK certificate extension and at the given offset of the report's REPORTED_TCB.
+var tcbComponents = []struct {
+	name   string
+	oid    asn1.ObjectIdentifier
+	offset int
+}{
+	{"boot loader", asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 3704, 1, 3, 1}, 0},
+	{"TEE", asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 3704, 1, 3, 2}, 1},
+	{"SNP", asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 3704, 1, 3, 3}, 6},
+	{"microcode", asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 3704, 1, 3, 8}, 7},
+}
+
+// report is a parsed SEV-SNP attestation report.
+type report struct {
+	raw         []byte
+	version     uint32
+	guestSVN    uint32
+	policy      uint64
+	sigAlgo     uint32
+	reportData  []byte
+	measurement []byte
+	reportedTCB []byte
+	chipID      []byte
+	signature   []byte
+}
+
+func parseReport(raw []byte) (*report, error) {
+	if len(raw) < reportLen {
+		return nil, errors.New("report is too short")
+	}
+	r := &report{
+		raw:         raw[:reportLen],
+		version:     binary.LittleEndian.Uint32(raw[0x00:]),
+		guestSVN:    binary.LittleEndian.Uint32(raw[0x04:]),
+		policy:      binary.LittleEndian.Uint64(raw[0x08:]),
+		sigAlgo:     binary.LittleEndian.Uint32(raw[0x34:]),
+		reportData:  raw[0x50:0x90],
+		measurement: raw[0x90:0xc0],
+		reportedTCB: raw[0x180:0x188],
+		chipID:      raw[0x1a0:0x1e0],
+		signature:   raw[signedLen : signedLen+2*signatureComponent],
+	}
+	if r.version < 2 {
+		return nil, fmt.Errorf("unsupported report version %d", r.version)
+	}
+	if r.sigAlgo != signatureAlgoECDSA {
+		return nil, fmt.Errorf("unsupported signature algorithm %d", r.sigAlgo)
+	}
+	return r, nil
+}
+
+// SNPValidator is a Quote validator for AMD SEV-SNP attestation reports
+type SNPValidator struct {
+}
+
+// NewSNPValidator returns a new SNPValidator object
+func NewSNPValidator() *SNPValidator {
+	return &SNPValidator{}
+}
+
+// Validate implements the Validator interface for SNPValidator
+func (m *SNPValidator) Validate(givenQuote []byte, cert []byte, pp quote.PackageProperties, ip quote.InfrastructureProperties) error {
+	_, err := m.ValidateReport(givenQuote, cert, pp, ip)
+	return err
+}
+
+// ValidateReport implements the ReportingValidator interface for SNPValidator
+//
+// ip.RootCA must contain the AMD Root Key (ARK) certificate.
+func (m *SNPValidator) ValidateReport(givenQuote []byte, cert []byte, pp quote.PackageProperties, ip quote.InfrastructureProperties) (quote.PackageProperties, error) {
+	r, err := parseReport(givenQuote)
+	if err != nil {
+		return quote.PackageProperties{}, fmt.Errorf("parsing report failed: %v", err)
+	}
+
+	vcek, err := verifyCertChain(givenQuote[reportLen:], ip.RootCA)
+	if err != nil {
+		return quote.PackageProperties{}, fmt.Errorf("verifying VCEK certificate chain failed: %v", err)
+	}
+	if err := verifyChipID(vcek, r.chipID); err != nil {
+		return quote.PackageProperties{}, err
+	}
+	if err := verifyReportedTCB(vcek, r.reportedTCB); err != nil {
+		return quote.PackageProperties{}, err
+	}
+	if err := verifySignature(vcek, r); err != nil {
+		return quote.PackageProperties{}, err
+	}
+
+	// Check that cert is equal
+	hash := sha256.Sum256(cert)
+	if !bytes.Equal(r.reportData[:len(hash)], hash[:]) {
+		return quote.PackageProperties{}, fmt.Errorf("hash(cert) != report.Data: %v != %v", hash, r.reportData)
+	}
+
+	// Verify PackageProperties
+	securityVersion := uint(r.guestSVN)
+	reportedProps := quote.PackageProperties{
+		Debug:           r.policy&policyDebugFlag != 0,
+		SecurityVersion: &securityVersion,
+		Measurement:     hex.EncodeToString(r.measurement),
+		Policy:          &r.policy,
+	}
+	if !pp.IsCompliant(reportedProps) {
+		return quote.PackageProperties{}, fmt.Errorf("PackageProperties not compliant:\n%v\n%v", reportedProps, pp)
+	}
+	return reportedProps, nil
+}
+
+// verifyCertChain verifies the certificates appended to the report up to the given ARK and returns the VCEK certificate.
+func verifyCertChain(certData []byte, rootCA []byte) (*x509.Certificate, error) {
+	chain, err := quote.ParsePEMCertificates(certData)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("quote does not contain a VCEK certificate")
+	}
+	if err := quote.VerifyCertChain(chain[0], chain[1:], rootCA); err != nil {
+		return nil, err
+	}
+	return chain[0], nil
+}
+
+// verifyChipID checks that the VCEK belongs to the chip that created the report.
+func verifyChipID(vcek *x509.Certificate, chipID []byte) error {
+	for _, ext := range vcek.Extensions {
+		if !ext.Id.Equal(oidHardwareID) {
+			continue
+		}
+		hwID := ext.Value
+		var octets []byte
+		if _, err := asn1.Unmarshal(ext.Value, &octets); err == nil {
+			hwID = octets
+		}
+		if !bytes.Equal(hwID, chipID) {
+			return errors.New("VCEK does not belong to the chip that created the report")
+		}
+		return nil
+	}
+	return errors.New("VCEK does not contain a hardware ID")
+}
+
+// verifyReportedTCB checks that the VCEK was derived from the TCB the report claims to be created with.
+func verifyReportedTCB(vcek *x509.Certificate, reportedTCB []byte) error {
+	for _, component := range tcbComponents {
+		spl, err := getSPL(vcek, component.oid)
+		if err != nil {
+			return fmt.Errorf("VCEK %v SPL: %v", component.name, err)
+		}
+		if spl != int(reportedTCB[component.offset]) {
+			return fmt.Errorf("VCEK %v SPL does not match the reported TCB: %v != %v", component.name, spl, reportedTCB[component.offset])
+		}
+	}
+	return nil
+}
+
+// getSPL returns the security patch level stored in the VCEK extension with the given OID.
+func getSPL(vcek *x509.Certificate, oid asn1.ObjectIdentifier) (int, error) {
+	for _, ext := range vcek.Extensions {
+		if !ext.Id.Equal(oid) {
+			continue
+		}
+		var spl int
+		if rest, err := asn1.Unmarshal(ext.Value, &spl); err != nil || len(rest) != 0 {
+			return 0, errors.New("invalid extension")
+		}
+		return spl, nil
+	}
+	return 0, errors.New("missing extension")
+}
+
+// verifySignature verifies the report's signature with the VCEK.
+func verifySignature(vcek *x509.Certificate, r *report) error {
+	key, ok := vcek.PublicKey.(*ecdsa.PublicKey)
+	if !ok || key.Curve != elliptic.P384() {
+		return errors.New("VCEK does not contain an ECDSA P-384 key")
+	}
+	sigR := new(big.Int).SetBytes(reverse(r.signature[:signatureComponent]))
+	sigS := new(big.Int).SetBytes(reverse(r.signature[signatureComponent:]))
+	hash := sha512.Sum384(r.raw[:signedLen])
+	if !ecdsa.Verify(key, hash[:], sigR, sigS) {
+		return errors.New("invalid report signature")
+	}
+	return nil
+}
+
+// reverse returns a reversed copy of b to convert between little and big endian.
+func reverse(b []byte) []byte {
+	result := make([]byte, len(b))
+	for i := range b {
+		result[len(b)-1-i] = b[i]
+	}
+	return result
+}
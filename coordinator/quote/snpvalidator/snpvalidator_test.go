@@ -0,0 +1,132 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package snpvalidator
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/coordinator/quote/quotetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	chipID := make([]byte, 64)
+	chipID[0] = 42
+	tcb := []byte{2, 1, 0, 0, 0, 0, 8, 115}
+	ark, arkKey := quotetest.MustCreateCert(t, elliptic.P384(), "ARK", true, nil, nil)
+	ask, askKey := quotetest.MustCreateCert(t, elliptic.P384(), "ASK", true, ark, arkKey)
+	vcek, vcekKey := mustCreateVCEK(t, ask, askKey, chipID, tcb)
+	chain := append(quotetest.ToPEM(vcek), quotetest.ToPEM(ask)...)
+
+	cert := []byte("marble certificate")
+	measurement := make([]byte, 48)
+	measurement[0] = 1
+	policy := uint64(0x30000)
+	validReport := append(mustCreateReport(t, cert, measurement, policy, 3, tcb, chipID, vcekKey), chain...)
+
+	securityVersion := uint(2)
+	pp := quote.PackageProperties{
+		Measurement:     hex.EncodeToString(measurement),
+		Policy:          &policy,
+		SecurityVersion: &securityVersion,
+	}
+	ip := quote.InfrastructureProperties{Type: InfrastructureType, RootCA: quotetest.ToPEM(ark)}
+
+	validator := NewSNPValidator()
+	require.NoError(validator.Validate(validReport, cert, pp, ip))
+
+	// wrong message
+	assert.Error(validator.Validate(validReport, []byte("other certificate"), pp, ip))
+
+	// wrong package
+	ppOther := pp
+	ppOther.Measurement = hex.EncodeToString(make([]byte, 48))
+	assert.Error(validator.Validate(validReport, cert, ppOther, ip))
+	otherPolicy := uint64(0x30000 | policyDebugFlag)
+	ppOther = pp
+	ppOther.Policy = &otherPolicy
+	assert.Error(validator.Validate(validReport, cert, ppOther, ip))
+	higherVersion := uint(4)
+	ppOther = pp
+	ppOther.SecurityVersion = &higherVersion
+	assert.Error(validator.Validate(validReport, cert, ppOther, ip))
+	productID := uint64(1)
+	ppOther = pp
+	ppOther.ProductID = &productID
+	assert.Error(validator.Validate(validReport, cert, ppOther, ip))
+
+	// wrong root CA
+	otherARK, _ := quotetest.MustCreateCert(t, elliptic.P384(), "ARK", true, nil, nil)
+	assert.Error(validator.Validate(validReport, cert, pp, quote.InfrastructureProperties{RootCA: quotetest.ToPEM(otherARK)}))
+	assert.Error(validator.Validate(validReport, cert, pp, quote.InfrastructureProperties{}))
+
+	// VCEK of another chip
+	otherChipID := make([]byte, 64)
+	otherVCEK, otherVCEKKey := mustCreateVCEK(t, ask, askKey, otherChipID, tcb)
+	otherChipReport := append(mustCreateReport(t, cert, measurement, policy, 3, tcb, chipID, otherVCEKKey), quotetest.ToPEM(otherVCEK)...)
+	assert.Error(validator.Validate(append(otherChipReport, quotetest.ToPEM(ask)...), cert, pp, ip))
+
+	// VCEK of another TCB, e.g., an outdated VCEK of a platform that has been updated since
+	otherTCB := []byte{2, 1, 0, 0, 0, 0, 8, 93}
+	otherTCBVCEK, otherTCBVCEKKey := mustCreateVCEK(t, ask, askKey, chipID, otherTCB)
+	otherTCBReport := append(mustCreateReport(t, cert, measurement, policy, 3, tcb, chipID, otherTCBVCEKKey), quotetest.ToPEM(otherTCBVCEK)...)
+	assert.Error(validator.Validate(append(otherTCBReport, quotetest.ToPEM(ask)...), cert, pp, ip))
+
+	// tampered report
+	tampered := append([]byte(nil), validReport...)
+	tampered[0x90] ^= 1
+	assert.Error(validator.Validate(tampered, cert, pp, ip))
+
+	// missing VCEK
+	assert.Error(validator.Validate(validReport[:reportLen], cert, pp, ip))
+	assert.Error(validator.Validate(validReport[:100], cert, pp, ip))
+}
+
+func mustCreateVCEK(t *testing.T, ask *x509.Certificate, askKey *ecdsa.PrivateKey, chipID, tcb []byte) (*x509.Certificate, *ecdsa.PrivateKey) {
+	extensions := []pkix.Extension{{Id: oidHardwareID, Value: chipID}}
+	for _, component := range tcbComponents {
+		spl, err := asn1.Marshal(int(tcb[component.offset]))
+		require.NoError(t, err)
+		extensions = append(extensions, pkix.Extension{Id: component.oid, Value: spl})
+	}
+	return quotetest.MustCreateCert(t, elliptic.P384(), "VCEK", false, ask, askKey, extensions...)
+}
+
+func mustCreateReport(t *testing.T, cert, measurement []byte, policy uint64, guestSVN uint32, tcb, chipID []byte, key *ecdsa.PrivateKey) []byte {
+	raw := make([]byte, reportLen)
+	binary.LittleEndian.PutUint32(raw[0x00:], 2)
+	binary.LittleEndian.PutUint32(raw[0x04:], guestSVN)
+	binary.LittleEndian.PutUint64(raw[0x08:], policy)
+	binary.LittleEndian.PutUint32(raw[0x34:], signatureAlgoECDSA)
+	certHash := sha256.Sum256(cert)
+	copy(raw[0x50:], certHash[:])
+	copy(raw[0x90:0xc0], measurement)
+	copy(raw[0x180:0x188], tcb)
+	copy(raw[0x1a0:0x1e0], chipID)
+
+	hash := sha512.Sum384(raw[:signedLen])
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	require.NoError(t, err)
+	copy(raw[signedLen:], reverse(r.Bytes()))
+	copy(raw[signedLen+signatureComponent:], reverse(s.Bytes()))
+	return raw
+}
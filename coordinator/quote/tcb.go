@@ -0,0 +1,101 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package quote
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TCB statuses of an SGX platform as reported by Intel's TCB info.
+const (
+	TCBStatusUpToDate                          = "UpToDate"
+	TCBStatusSWHardeningNeeded                 = "SWHardeningNeeded"
+	TCBStatusConfigurationNeeded               = "ConfigurationNeeded"
+	TCBStatusConfigurationAndSWHardeningNeeded = "ConfigurationAndSWHardeningNeeded"
+	TCBStatusOutOfDate                         = "OutOfDate"
+	TCBStatusOutOfDateConfigurationNeeded      = "OutOfDateConfigurationNeeded"
+	TCBStatusRevoked                           = "Revoked"
+)
+
+// IsTCBStatus returns true if status is one of the TCB statuses Intel reports.
+func IsTCBStatus(status string) bool {
+	switch status {
+	case TCBStatusUpToDate, TCBStatusSWHardeningNeeded, TCBStatusConfigurationNeeded, TCBStatusConfigurationAndSWHardeningNeeded,
+		TCBStatusOutOfDate, TCBStatusOutOfDateConfigurationNeeded, TCBStatusRevoked:
+		return true
+	}
+	return false
+}
+
+// CheckTCBStatus checks that the TCB status of a platform and the Intel security advisories that affect it are accepted
+// by the infrastructure and the package. The package may only restrict the statuses and advisories the infrastructure accepts.
+// Returns a *TCBStatusError if they aren't.
+func CheckTCBStatus(status string, advisories []string, pp PackageProperties, ip InfrastructureProperties) error {
+	acceptedStatuses := ip.AcceptedTCBStatuses
+	if len(acceptedStatuses) == 0 {
+		acceptedStatuses = []string{TCBStatusUpToDate}
+	}
+	if status == TCBStatusRevoked || !contains(acceptedStatuses, status) || len(pp.AcceptedTCBStatuses) > 0 && !contains(pp.AcceptedTCBStatuses, status) {
+		return &TCBStatusError{Status: status, Advisories: advisories}
+	}
+	for _, advisory := range advisories {
+		if !contains(ip.AcceptedAdvisories, advisory) || len(pp.AcceptedAdvisories) > 0 && !contains(pp.AcceptedAdvisories, advisory) {
+			return &TCBStatusError{Status: status, Advisories: advisories, Advisory: advisory}
+		}
+	}
+	return nil
+}
+
+// TCBStatusError occurs if the TCB status of a platform or an advisory that affects it is not accepted.
+type TCBStatusError struct {
+	Status     string
+	Advisories []string
+	// Advisory is the advisory that is not accepted. It is empty if the status is not accepted.
+	Advisory string
+}
+
+func (e *TCBStatusError) Error() string {
+	if e.Advisory != "" {
+		return fmt.Sprintf("advisory %v that affects the platform (TCB status %v) is not accepted", e.Advisory, e.Status)
+	}
+	if len(e.Advisories) > 0 {
+		return fmt.Sprintf("TCB status %v of the platform is not accepted (advisories %v)", e.Status, strings.Join(e.Advisories, ", "))
+	}
+	return fmt.Sprintf("TCB status %v of the platform is not accepted", e.Status)
+}
+
+// CollateralStatus describes collateral of a vendor that a Validator validates quotes with, e.g., Intel's TCB info of an FMSPC.
+type CollateralStatus struct {
+	// Type is the infrastructure type of the Validator, e.g., "dcap"
+	Type string `json:",omitempty"`
+	// Name identifies the collateral, e.g., "tcb_info_00906ed50000" or "qe_identity"
+	Name string
+	// IssueDate, NextUpdate and TCBEvaluationDataNumber describe the collateral that is in effect
+	IssueDate               time.Time
+	NextUpdate              time.Time
+	TCBEvaluationDataNumber uint
+	// PendingTCBEvaluationDataNumber is the TCB evaluation of newer collateral, which takes effect at PendingEffectiveAt after a grace period.
+	// It is 0 if there is no pending collateral.
+	PendingTCBEvaluationDataNumber uint       `json:",omitempty"`
+	PendingEffectiveAt             *time.Time `json:",omitempty"`
+}
+
+// CollateralReporter is implemented by Validators that validate quotes with collateral of a vendor, so that its state can be monitored.
+type CollateralReporter interface {
+	CollateralStatus() []CollateralStatus
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package recovery
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// Collector collects shares uploaded by the recovery key holders until the secret can be restored.
+//
+// The threshold and the number of shares are fixed when the Collector is created, so that they cannot be dictated by an uploaded share.
+// Shares of different lengths are collected separately, so that a forged share cannot lock out the shares of the key holders.
+type Collector struct {
+	threshold int
+	parts     int
+	shares    map[int][][]byte
+}
+
+// NewCollector creates a Collector for a secret that has been split into parts shares, of which threshold are required to restore it.
+func NewCollector(threshold, parts int) *Collector {
+	return &Collector{threshold: threshold, parts: parts}
+}
+
+// Add adds a share to the collection.
+//
+// Returns the number of shares that are still missing. Once enough shares have been collected, the restored secret is returned as well.
+func (c *Collector) Add(share []byte) (remaining int, secret []byte, err error) {
+	if c.threshold < 2 {
+		return 0, nil, errors.New("the secret has not been split into shares")
+	}
+	threshold, err := Threshold(share)
+	if err != nil {
+		return 0, nil, err
+	}
+	if threshold != c.threshold {
+		return 0, nil, fmt.Errorf("share requires %d shares, but the secret has been split with a threshold of %d", threshold, c.threshold)
+	}
+	if x := int(share[5]); x > c.parts {
+		return 0, nil, fmt.Errorf("invalid share %d, the secret has been split into %d shares", x, c.parts)
+	}
+
+	// uploading the same share twice does not count
+	collected := c.shares[len(share)]
+	for _, other := range collected {
+		if other[5] == share[5] {
+			if !bytes.Equal(other, share) {
+				return 0, nil, errors.New("share conflicts with a previously uploaded share")
+			}
+			return c.remaining(), nil, nil
+		}
+	}
+
+	if c.shares == nil {
+		c.shares = make(map[int][][]byte)
+	}
+	collected = append(collected, append([]byte(nil), share...))
+	c.shares[len(share)] = collected
+	if len(collected) < c.threshold {
+		return c.remaining(), nil, nil
+	}
+
+	secret, err = Combine(collected)
+	if err != nil {
+		return 0, nil, err
+	}
+	return 0, secret, nil
+}
+
+// remaining returns the number of shares that are missing to restore the secret from the most complete collection.
+func (c *Collector) remaining() int {
+	max := 0
+	for _, collected := range c.shares {
+		if len(collected) > max {
+			max = len(collected)
+		}
+	}
+	return c.threshold - max
+}
+
+// Reset discards all collected shares.
+func (c *Collector) Reset() {
+	c.shares = nil
+}
@@ -0,0 +1,165 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package recovery implements the splitting of the Coordinator's state encryption key among multiple recovery key holders.
+//
+// The key is split using Shamir's secret sharing over GF(2^8), so that any threshold of shares is sufficient to restore it.
+package recovery
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// shareMagic prefixes every encoded share so shares can be distinguished from a plain encryption key.
+var shareMagic = []byte("MRSS")
+
+// share header: magic | threshold | x coordinate
+const headerLen = 6
+
+// Split splits secret into parts shares, of which threshold are required to restore the secret.
+func Split(secret []byte, parts, threshold int) ([][]byte, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("cannot split an empty secret")
+	}
+	if threshold < 2 || threshold > parts {
+		return nil, fmt.Errorf("invalid threshold %d for %d parts", threshold, parts)
+	}
+	if parts > 255 {
+		return nil, errors.New("cannot split into more than 255 parts")
+	}
+
+	shares := make([][]byte, parts)
+	for i := range shares {
+		share := make([]byte, headerLen+len(secret))
+		copy(share, shareMagic)
+		share[4] = byte(threshold)
+		share[5] = byte(i + 1) // x = 0 would reveal the secret
+		shares[i] = share
+	}
+
+	// for each byte of the secret, create a random polynomial with the byte as constant term and evaluate it at each x
+	coefficients := make([]byte, threshold)
+	for idx, b := range secret {
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, err
+		}
+		coefficients[0] = b
+		for _, share := range shares {
+			share[headerLen+idx] = evaluate(coefficients, share[5])
+		}
+	}
+	return shares, nil
+}
+
+// Combine restores the secret from the given shares.
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no shares given")
+	}
+	threshold, err := Threshold(shares[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(shares) < threshold {
+		return nil, fmt.Errorf("need %d shares, got %d", threshold, len(shares))
+	}
+	secretLen := len(shares[0]) - headerLen
+
+	xs := make([]byte, len(shares))
+	for i, share := range shares {
+		if _, err := Threshold(share); err != nil {
+			return nil, err
+		}
+		if len(share)-headerLen != secretLen {
+			return nil, errors.New("shares have different lengths")
+		}
+		xs[i] = share[5]
+		for j := 0; j < i; j++ {
+			if xs[j] == xs[i] {
+				return nil, errors.New("duplicate share")
+			}
+		}
+	}
+
+	// Lagrange interpolation at x = 0 for each byte of the secret
+	secret := make([]byte, secretLen)
+	ys := make([]byte, len(shares))
+	for idx := range secret {
+		for i, share := range shares {
+			ys[i] = share[headerLen+idx]
+		}
+		secret[idx] = interpolate(xs, ys)
+	}
+	return secret, nil
+}
+
+// IsShare returns true if data is an encoded share.
+func IsShare(data []byte) bool {
+	return len(data) > headerLen && bytes.Equal(data[:len(shareMagic)], shareMagic)
+}
+
+// Threshold returns the number of shares required to restore the secret the given share belongs to.
+func Threshold(share []byte) (int, error) {
+	if !IsShare(share) {
+		return 0, errors.New("invalid share")
+	}
+	if share[4] < 2 || share[5] == 0 {
+		return 0, errors.New("invalid share header")
+	}
+	return int(share[4]), nil
+}
+
+// evaluate evaluates the polynomial with the given coefficients at x using Horner's method.
+func evaluate(coefficients []byte, x byte) byte {
+	var result byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		result = mul(result, x) ^ coefficients[i]
+	}
+	return result
+}
+
+// interpolate returns the value at x = 0 of the polynomial defined by the given points.
+func interpolate(xs, ys []byte) byte {
+	var result byte
+	for i := range xs {
+		// basis = prod_{j != i} x_j / (x_j - x_i); subtraction is xor in GF(2^8)
+		basis := byte(1)
+		for j := range xs {
+			if i == j {
+				continue
+			}
+			basis = mul(basis, div(xs[j], xs[j]^xs[i]))
+		}
+		result ^= mul(ys[i], basis)
+	}
+	return result
+}
+
+// mul multiplies in GF(2^8) with the AES polynomial x^8 + x^4 + x^3 + x + 1.
+// It does not branch on secret data.
+func mul(a, b byte) byte {
+	var result byte
+	for i := 0; i < 8; i++ {
+		result ^= a & -(b & 1)
+		carry := -(a >> 7)
+		a = (a << 1) ^ (0x1b & carry)
+		b >>= 1
+	}
+	return result
+}
+
+// div divides in GF(2^8). b must not be zero.
+func div(a, b byte) byte {
+	// b^-1 = b^254
+	inv := b
+	for i := 0; i < 6; i++ {
+		inv = mul(mul(inv, inv), b)
+	}
+	return mul(a, mul(inv, inv))
+}
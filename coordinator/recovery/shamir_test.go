@@ -0,0 +1,139 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package recovery
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitCombine(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	secret := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+	shares, err := Split(secret, 5, 3)
+	require.NoError(err)
+	require.Len(shares, 5)
+	for _, share := range shares {
+		assert.True(IsShare(share))
+		threshold, err := Threshold(share)
+		assert.NoError(err)
+		assert.Equal(3, threshold)
+	}
+
+	// any 3 shares restore the secret
+	for _, subset := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		var parts [][]byte
+		for _, i := range subset {
+			parts = append(parts, shares[i])
+		}
+		restored, err := Combine(parts)
+		assert.NoError(err)
+		assert.Equal(secret, restored)
+	}
+
+	// 2 shares are not enough
+	_, err = Combine(shares[:2])
+	assert.Error(err)
+
+	// duplicate shares are rejected
+	_, err = Combine([][]byte{shares[0], shares[0], shares[1]})
+	assert.Error(err)
+
+	// invalid parameters
+	_, err = Split(secret, 2, 3)
+	assert.Error(err)
+	_, err = Split(secret, 3, 1)
+	assert.Error(err)
+	_, err = Split(nil, 3, 2)
+	assert.Error(err)
+
+	assert.False(IsShare(secret))
+}
+
+func TestCollector(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	secret := []byte{42, 43, 44, 45}
+	shares, err := Split(secret, 3, 2)
+	require.NoError(err)
+
+	collector := NewCollector(2, 3)
+	remaining, restored, err := collector.Add(shares[2])
+	require.NoError(err)
+	assert.Equal(1, remaining)
+	assert.Nil(restored)
+
+	// uploading the same share again does not count
+	remaining, restored, err = collector.Add(shares[2])
+	require.NoError(err)
+	assert.Equal(1, remaining)
+	assert.Nil(restored)
+
+	// shares of another secret are rejected
+	otherShares, err := Split(secret, 3, 3)
+	require.NoError(err)
+	_, _, err = collector.Add(otherShares[0])
+	assert.Error(err)
+
+	remaining, restored, err = collector.Add(shares[0])
+	require.NoError(err)
+	assert.Equal(0, remaining)
+	assert.Equal(secret, restored)
+
+	// a plain key is not a share
+	collector.Reset()
+	_, _, err = collector.Add(secret)
+	assert.Error(err)
+
+	// shares are rejected if the secret has not been split
+	_, _, err = NewCollector(0, 0).Add(shares[0])
+	assert.Error(err)
+}
+
+func TestCollectorPoisoning(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	secret := []byte{42, 43, 44, 45}
+	shares, err := Split(secret, 3, 2)
+	require.NoError(err)
+	collector := NewCollector(2, 3)
+
+	// a share with another threshold cannot change the expected threshold
+	forged, err := Split([]byte{1, 2, 3, 4}, 5, 5)
+	require.NoError(err)
+	_, _, err = collector.Add(forged[0])
+	assert.Error(err)
+
+	// a share with an index beyond the number of key holders is rejected
+	forged, err = Split([]byte{1, 2, 3, 4}, 4, 2)
+	require.NoError(err)
+	_, _, err = collector.Add(forged[3])
+	assert.Error(err)
+
+	// a share of another length does not lock out the key holders
+	forged, err = Split([]byte{1, 2, 3, 4, 5, 6, 7, 8}, 3, 2)
+	require.NoError(err)
+	remaining, _, err := collector.Add(forged[1])
+	require.NoError(err)
+	assert.Equal(1, remaining)
+
+	remaining, restored, err := collector.Add(shares[0])
+	require.NoError(err)
+	assert.Equal(1, remaining)
+	assert.Nil(restored)
+	remaining, restored, err = collector.Add(shares[1])
+	require.NoError(err)
+	assert.Equal(0, remaining)
+	assert.Equal(secret, restored)
+}
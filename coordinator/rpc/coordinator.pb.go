@@ -1,6 +1,6 @@
 // Code generated by protoc-gen-go. DO NOT EDIT.
 // versions:
-// 	protoc-gen-go v1.23.0
+// 	protoc-gen-go v1.25.0
 // 	protoc        v3.13.0
 // source: coordinator.proto
 
@@ -39,6 +39,14 @@ type ActivationReq struct {
 	CSR        []byte `protobuf:"bytes,2,opt,name=CSR,proto3" json:"CSR,omitempty"`
 	MarbleType string `protobuf:"bytes,3,opt,name=MarbleType,proto3" json:"MarbleType,omitempty"`
 	UUID       string `protobuf:"bytes,4,opt,name=UUID,proto3" json:"UUID,omitempty"`
+	// Highest version of the activation API the marble implements. 0 is treated as version 1.
+	APIVersion uint32 `protobuf:"varint,5,opt,name=APIVersion,proto3" json:"APIVersion,omitempty"`
+	// Nonce the marble got from the Coordinator. If it is set, the quote is issued over the nonce and the public key of the CSR
+	// instead of the marble's TLS certificate.
+	Nonce []byte `protobuf:"bytes,6,opt,name=Nonce,proto3" json:"Nonce,omitempty"`
+	// Token the Coordinator issued on an earlier activation of the marble with the same UUID. If it is valid, the activation
+	// doesn't count towards the MaxActivations of the marble type.
+	ResumptionToken []byte `protobuf:"bytes,7,opt,name=ResumptionToken,proto3" json:"ResumptionToken,omitempty"`
 }
 
 func (x *ActivationReq) Reset() {
@@ -101,12 +109,41 @@ func (x *ActivationReq) GetUUID() string {
 	return ""
 }
 
+func (x *ActivationReq) GetAPIVersion() uint32 {
+	if x != nil {
+		return x.APIVersion
+	}
+	return 0
+}
+
+func (x *ActivationReq) GetNonce() []byte {
+	if x != nil {
+		return x.Nonce
+	}
+	return nil
+}
+
+func (x *ActivationReq) GetResumptionToken() []byte {
+	if x != nil {
+		return x.ResumptionToken
+	}
+	return nil
+}
+
 type ActivationResp struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
 	Parameters *Parameters `protobuf:"bytes,1,opt,name=Parameters,proto3" json:"Parameters,omitempty"`
+	// Version of the activation API negotiated by the Coordinator. The marble must interpret the response according to this version.
+	APIVersion uint32 `protobuf:"varint,2,opt,name=APIVersion,proto3" json:"APIVersion,omitempty"`
+	// PEM-encoded certificate chain of the marble certificate and its package CA. The marble authenticates heartbeats with it.
+	Certificate string `protobuf:"bytes,3,opt,name=Certificate,proto3" json:"Certificate,omitempty"`
+	// PEM-encoded PKCS #8 private key of the marble certificate
+	PrivateKey string `protobuf:"bytes,4,opt,name=PrivateKey,proto3" json:"PrivateKey,omitempty"`
+	// Token with which the marble resumes its identity when it is activated again with the same UUID
+	ResumptionToken []byte `protobuf:"bytes,5,opt,name=ResumptionToken,proto3" json:"ResumptionToken,omitempty"`
 }
 
 func (x *ActivationResp) Reset() {
@@ -148,6 +185,34 @@ func (x *ActivationResp) GetParameters() *Parameters {
 	return nil
 }
 
+func (x *ActivationResp) GetAPIVersion() uint32 {
+	if x != nil {
+		return x.APIVersion
+	}
+	return 0
+}
+
+func (x *ActivationResp) GetCertificate() string {
+	if x != nil {
+		return x.Certificate
+	}
+	return ""
+}
+
+func (x *ActivationResp) GetPrivateKey() string {
+	if x != nil {
+		return x.PrivateKey
+	}
+	return ""
+}
+
+func (x *ActivationResp) GetResumptionToken() []byte {
+	if x != nil {
+		return x.ResumptionToken
+	}
+	return nil
+}
+
 type Parameters struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -156,6 +221,12 @@ type Parameters struct {
 	Files map[string]string `protobuf:"bytes,1,rep,name=Files,proto3" json:"Files,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
 	Env   map[string]string `protobuf:"bytes,2,rep,name=Env,proto3" json:"Env,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
 	Argv  []string          `protobuf:"bytes,3,rep,name=Argv,proto3" json:"Argv,omitempty"`
+	// Files with attributes. A path is either in Files or in FileSpecs.
+	FileSpecs map[string]*File `protobuf:"bytes,4,rep,name=FileSpecs,proto3" json:"FileSpecs,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// Host environment variables that the marble passes through to the application. All others are removed.
+	HostEnv []string `protobuf:"bytes,5,rep,name=HostEnv,proto3" json:"HostEnv,omitempty"`
+	// Whether the marble removes its configuration and secrets from its environment after the activation
+	ScrubEnv bool `protobuf:"varint,6,opt,name=ScrubEnv,proto3" json:"ScrubEnv,omitempty"`
 }
 
 func (x *Parameters) Reset() {
@@ -208,47 +279,765 @@ func (x *Parameters) GetArgv() []string {
 	if x != nil {
 		return x.Argv
 	}
-	return nil
+	return nil
+}
+
+func (x *Parameters) GetFileSpecs() map[string]*File {
+	if x != nil {
+		return x.FileSpecs
+	}
+	return nil
+}
+
+func (x *Parameters) GetHostEnv() []string {
+	if x != nil {
+		return x.HostEnv
+	}
+	return nil
+}
+
+func (x *Parameters) GetScrubEnv() bool {
+	if x != nil {
+		return x.ScrubEnv
+	}
+	return false
+}
+
+type RenewalReq struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	CSR []byte `protobuf:"bytes,1,opt,name=CSR,proto3" json:"CSR,omitempty"`
+}
+
+func (x *RenewalReq) Reset() {
+	*x = RenewalReq{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_coordinator_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RenewalReq) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RenewalReq) ProtoMessage() {}
+
+func (x *RenewalReq) ProtoReflect() protoreflect.Message {
+	mi := &file_coordinator_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RenewalReq.ProtoReflect.Descriptor instead.
+func (*RenewalReq) Descriptor() ([]byte, []int) {
+	return file_coordinator_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *RenewalReq) GetCSR() []byte {
+	if x != nil {
+		return x.CSR
+	}
+	return nil
+}
+
+type RenewalResp struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// PEM-encoded certificate chain of the marble certificate and its package CA
+	Certificate string `protobuf:"bytes,1,opt,name=Certificate,proto3" json:"Certificate,omitempty"`
+	// PEM-encoded PKCS #8 private key of the marble certificate
+	PrivateKey string `protobuf:"bytes,2,opt,name=PrivateKey,proto3" json:"PrivateKey,omitempty"`
+}
+
+func (x *RenewalResp) Reset() {
+	*x = RenewalResp{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_coordinator_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RenewalResp) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RenewalResp) ProtoMessage() {}
+
+func (x *RenewalResp) ProtoReflect() protoreflect.Message {
+	mi := &file_coordinator_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RenewalResp.ProtoReflect.Descriptor instead.
+func (*RenewalResp) Descriptor() ([]byte, []int) {
+	return file_coordinator_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *RenewalResp) GetCertificate() string {
+	if x != nil {
+		return x.Certificate
+	}
+	return ""
+}
+
+func (x *RenewalResp) GetPrivateKey() string {
+	if x != nil {
+		return x.PrivateKey
+	}
+	return ""
+}
+
+type HeartbeatReq struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *HeartbeatReq) Reset() {
+	*x = HeartbeatReq{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_coordinator_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *HeartbeatReq) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HeartbeatReq) ProtoMessage() {}
+
+func (x *HeartbeatReq) ProtoReflect() protoreflect.Message {
+	mi := &file_coordinator_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HeartbeatReq.ProtoReflect.Descriptor instead.
+func (*HeartbeatReq) Descriptor() ([]byte, []int) {
+	return file_coordinator_proto_rawDescGZIP(), []int{5}
+}
+
+type HeartbeatResp struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Seconds after which the Coordinator expects the next heartbeat
+	Interval uint32 `protobuf:"varint,1,opt,name=Interval,proto3" json:"Interval,omitempty"`
+}
+
+func (x *HeartbeatResp) Reset() {
+	*x = HeartbeatResp{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_coordinator_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *HeartbeatResp) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HeartbeatResp) ProtoMessage() {}
+
+func (x *HeartbeatResp) ProtoReflect() protoreflect.Message {
+	mi := &file_coordinator_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HeartbeatResp.ProtoReflect.Descriptor instead.
+func (*HeartbeatResp) Descriptor() ([]byte, []int) {
+	return file_coordinator_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *HeartbeatResp) GetInterval() uint32 {
+	if x != nil {
+		return x.Interval
+	}
+	return 0
+}
+
+type WatchParametersReq struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	CSR []byte `protobuf:"bytes,1,opt,name=CSR,proto3" json:"CSR,omitempty"`
+}
+
+func (x *WatchParametersReq) Reset() {
+	*x = WatchParametersReq{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_coordinator_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *WatchParametersReq) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchParametersReq) ProtoMessage() {}
+
+func (x *WatchParametersReq) ProtoReflect() protoreflect.Message {
+	mi := &file_coordinator_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchParametersReq.ProtoReflect.Descriptor instead.
+func (*WatchParametersReq) Descriptor() ([]byte, []int) {
+	return file_coordinator_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *WatchParametersReq) GetCSR() []byte {
+	if x != nil {
+		return x.CSR
+	}
+	return nil
+}
+
+type WatchParametersResp struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Parameters *Parameters `protobuf:"bytes,1,opt,name=Parameters,proto3" json:"Parameters,omitempty"`
+}
+
+func (x *WatchParametersResp) Reset() {
+	*x = WatchParametersResp{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_coordinator_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *WatchParametersResp) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchParametersResp) ProtoMessage() {}
+
+func (x *WatchParametersResp) ProtoReflect() protoreflect.Message {
+	mi := &file_coordinator_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchParametersResp.ProtoReflect.Descriptor instead.
+func (*WatchParametersResp) Descriptor() ([]byte, []int) {
+	return file_coordinator_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *WatchParametersResp) GetParameters() *Parameters {
+	if x != nil {
+		return x.Parameters
+	}
+	return nil
+}
+
+type NonceReq struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *NonceReq) Reset() {
+	*x = NonceReq{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_coordinator_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *NonceReq) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NonceReq) ProtoMessage() {}
+
+func (x *NonceReq) ProtoReflect() protoreflect.Message {
+	mi := &file_coordinator_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NonceReq.ProtoReflect.Descriptor instead.
+func (*NonceReq) Descriptor() ([]byte, []int) {
+	return file_coordinator_proto_rawDescGZIP(), []int{9}
+}
+
+type NonceResp struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Random nonce, which may be used for one activation within a few minutes
+	Nonce []byte `protobuf:"bytes,1,opt,name=Nonce,proto3" json:"Nonce,omitempty"`
+}
+
+func (x *NonceResp) Reset() {
+	*x = NonceResp{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_coordinator_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *NonceResp) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NonceResp) ProtoMessage() {}
+
+func (x *NonceResp) ProtoReflect() protoreflect.Message {
+	mi := &file_coordinator_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NonceResp.ProtoReflect.Descriptor instead.
+func (*NonceResp) Descriptor() ([]byte, []int) {
+	return file_coordinator_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *NonceResp) GetNonce() []byte {
+	if x != nil {
+		return x.Nonce
+	}
+	return nil
+}
+
+type WatchEventsReq struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Only events of these marble types and events that don't concern a single marble are sent. All events are sent if it is empty.
+	MarbleTypes []string `protobuf:"bytes,1,rep,name=MarbleTypes,proto3" json:"MarbleTypes,omitempty"`
+}
+
+func (x *WatchEventsReq) Reset() {
+	*x = WatchEventsReq{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_coordinator_proto_msgTypes[11]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *WatchEventsReq) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchEventsReq) ProtoMessage() {}
+
+func (x *WatchEventsReq) ProtoReflect() protoreflect.Message {
+	mi := &file_coordinator_proto_msgTypes[11]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchEventsReq.ProtoReflect.Descriptor instead.
+func (*WatchEventsReq) Descriptor() ([]byte, []int) {
+	return file_coordinator_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *WatchEventsReq) GetMarbleTypes() []string {
+	if x != nil {
+		return x.MarbleTypes
+	}
+	return nil
+}
+
+type Event struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Activation, ActivationFailure, Revocation or ManifestChange
+	Type string `protobuf:"bytes,1,opt,name=Type,proto3" json:"Type,omitempty"`
+	// RFC 3339 timestamp of the event
+	Time           string `protobuf:"bytes,2,opt,name=Time,proto3" json:"Time,omitempty"`
+	MarbleType     string `protobuf:"bytes,3,opt,name=MarbleType,proto3" json:"MarbleType,omitempty"`
+	UUID           string `protobuf:"bytes,4,opt,name=UUID,proto3" json:"UUID,omitempty"`
+	Infrastructure string `protobuf:"bytes,5,opt,name=Infrastructure,proto3" json:"Infrastructure,omitempty"`
+	// Reason of an activation failure, see the reasons of the activation errors
+	Reason  string `protobuf:"bytes,6,opt,name=Reason,proto3" json:"Reason,omitempty"`
+	Message string `protobuf:"bytes,7,opt,name=Message,proto3" json:"Message,omitempty"`
+}
+
+func (x *Event) Reset() {
+	*x = Event{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_coordinator_proto_msgTypes[12]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Event) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Event) ProtoMessage() {}
+
+func (x *Event) ProtoReflect() protoreflect.Message {
+	mi := &file_coordinator_proto_msgTypes[12]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event.ProtoReflect.Descriptor instead.
+func (*Event) Descriptor() ([]byte, []int) {
+	return file_coordinator_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *Event) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Event) GetTime() string {
+	if x != nil {
+		return x.Time
+	}
+	return ""
+}
+
+func (x *Event) GetMarbleType() string {
+	if x != nil {
+		return x.MarbleType
+	}
+	return ""
+}
+
+func (x *Event) GetUUID() string {
+	if x != nil {
+		return x.UUID
+	}
+	return ""
+}
+
+func (x *Event) GetInfrastructure() string {
+	if x != nil {
+		return x.Infrastructure
+	}
+	return ""
+}
+
+func (x *Event) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *Event) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+type File struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Content of the file. In the manifest, it is a template whose result is decoded according to the Encoding.
+	Data []byte `protobuf:"bytes,1,opt,name=Data,proto3" json:"Data,omitempty"`
+	// Encoding of the Data in the manifest: "string" (the default) or "base64". It is empty in the parameters sent to the marble.
+	Encoding string `protobuf:"bytes,2,opt,name=Encoding,proto3" json:"Encoding,omitempty"`
+	// Permission bits of the file. 0 means 0600.
+	Mode uint32 `protobuf:"varint,3,opt,name=Mode,proto3" json:"Mode,omitempty"`
+	// Name or numeric ID of the user and the group that own the file
+	User  string `protobuf:"bytes,4,opt,name=User,proto3" json:"User,omitempty"`
+	Group string `protobuf:"bytes,5,opt,name=Group,proto3" json:"Group,omitempty"`
+	// The file is only written to an in-memory file system.
+	NoDisk bool `protobuf:"varint,6,opt,name=NoDisk,proto3" json:"NoDisk,omitempty"`
+}
+
+func (x *File) Reset() {
+	*x = File{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_coordinator_proto_msgTypes[13]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *File) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*File) ProtoMessage() {}
+
+func (x *File) ProtoReflect() protoreflect.Message {
+	mi := &file_coordinator_proto_msgTypes[13]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use File.ProtoReflect.Descriptor instead.
+func (*File) Descriptor() ([]byte, []int) {
+	return file_coordinator_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *File) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *File) GetEncoding() string {
+	if x != nil {
+		return x.Encoding
+	}
+	return ""
+}
+
+func (x *File) GetMode() uint32 {
+	if x != nil {
+		return x.Mode
+	}
+	return 0
+}
+
+func (x *File) GetUser() string {
+	if x != nil {
+		return x.User
+	}
+	return ""
+}
+
+func (x *File) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *File) GetNoDisk() bool {
+	if x != nil {
+		return x.NoDisk
+	}
+	return false
 }
 
 var File_coordinator_proto protoreflect.FileDescriptor
 
 var file_coordinator_proto_rawDesc = []byte{
 	0x0a, 0x11, 0x63, 0x6f, 0x6f, 0x72, 0x64, 0x69, 0x6e, 0x61, 0x74, 0x6f, 0x72, 0x2e, 0x70, 0x72,
-	0x6f, 0x74, 0x6f, 0x12, 0x03, 0x72, 0x70, 0x63, 0x22, 0x6b, 0x0a, 0x0d, 0x41, 0x63, 0x74, 0x69,
-	0x76, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x71, 0x12, 0x14, 0x0a, 0x05, 0x51, 0x75, 0x6f,
-	0x74, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x51, 0x75, 0x6f, 0x74, 0x65, 0x12,
-	0x10, 0x0a, 0x03, 0x43, 0x53, 0x52, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x43, 0x53,
-	0x52, 0x12, 0x1e, 0x0a, 0x0a, 0x4d, 0x61, 0x72, 0x62, 0x6c, 0x65, 0x54, 0x79, 0x70, 0x65, 0x18,
-	0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x4d, 0x61, 0x72, 0x62, 0x6c, 0x65, 0x54, 0x79, 0x70,
-	0x65, 0x12, 0x12, 0x0a, 0x04, 0x55, 0x55, 0x49, 0x44, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52,
-	0x04, 0x55, 0x55, 0x49, 0x44, 0x22, 0x41, 0x0a, 0x0e, 0x41, 0x63, 0x74, 0x69, 0x76, 0x61, 0x74,
-	0x69, 0x6f, 0x6e, 0x52, 0x65, 0x73, 0x70, 0x12, 0x2f, 0x0a, 0x0a, 0x50, 0x61, 0x72, 0x61, 0x6d,
-	0x65, 0x74, 0x65, 0x72, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x72, 0x70,
-	0x63, 0x2e, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x65, 0x74, 0x65, 0x72, 0x73, 0x52, 0x0a, 0x50, 0x61,
-	0x72, 0x61, 0x6d, 0x65, 0x74, 0x65, 0x72, 0x73, 0x22, 0xf0, 0x01, 0x0a, 0x0a, 0x50, 0x61, 0x72,
-	0x61, 0x6d, 0x65, 0x74, 0x65, 0x72, 0x73, 0x12, 0x30, 0x0a, 0x05, 0x46, 0x69, 0x6c, 0x65, 0x73,
-	0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x50, 0x61, 0x72,
-	0x61, 0x6d, 0x65, 0x74, 0x65, 0x72, 0x73, 0x2e, 0x46, 0x69, 0x6c, 0x65, 0x73, 0x45, 0x6e, 0x74,
-	0x72, 0x79, 0x52, 0x05, 0x46, 0x69, 0x6c, 0x65, 0x73, 0x12, 0x2a, 0x0a, 0x03, 0x45, 0x6e, 0x76,
-	0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x18, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x50, 0x61, 0x72,
-	0x61, 0x6d, 0x65, 0x74, 0x65, 0x72, 0x73, 0x2e, 0x45, 0x6e, 0x76, 0x45, 0x6e, 0x74, 0x72, 0x79,
-	0x52, 0x03, 0x45, 0x6e, 0x76, 0x12, 0x12, 0x0a, 0x04, 0x41, 0x72, 0x67, 0x76, 0x18, 0x03, 0x20,
-	0x03, 0x28, 0x09, 0x52, 0x04, 0x41, 0x72, 0x67, 0x76, 0x1a, 0x38, 0x0a, 0x0a, 0x46, 0x69, 0x6c,
-	0x65, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01,
-	0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c,
-	0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a,
-	0x02, 0x38, 0x01, 0x1a, 0x36, 0x0a, 0x08, 0x45, 0x6e, 0x76, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12,
+	0x6f, 0x74, 0x6f, 0x12, 0x03, 0x72, 0x70, 0x63, 0x22, 0xcb, 0x01, 0x0a, 0x0d, 0x41, 0x63, 0x74,
+	0x69, 0x76, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x71, 0x12, 0x14, 0x0a, 0x05, 0x51, 0x75,
+	0x6f, 0x74, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x51, 0x75, 0x6f, 0x74, 0x65,
+	0x12, 0x10, 0x0a, 0x03, 0x43, 0x53, 0x52, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x43,
+	0x53, 0x52, 0x12, 0x1e, 0x0a, 0x0a, 0x4d, 0x61, 0x72, 0x62, 0x6c, 0x65, 0x54, 0x79, 0x70, 0x65,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x4d, 0x61, 0x72, 0x62, 0x6c, 0x65, 0x54, 0x79,
+	0x70, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x55, 0x55, 0x49, 0x44, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x04, 0x55, 0x55, 0x49, 0x44, 0x12, 0x1e, 0x0a, 0x0a, 0x41, 0x50, 0x49, 0x56, 0x65, 0x72,
+	0x73, 0x69, 0x6f, 0x6e, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0a, 0x41, 0x50, 0x49, 0x56,
+	0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x14, 0x0a, 0x05, 0x4e, 0x6f, 0x6e, 0x63, 0x65, 0x18,
+	0x06, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x4e, 0x6f, 0x6e, 0x63, 0x65, 0x12, 0x28, 0x0a, 0x0f,
+	0x52, 0x65, 0x73, 0x75, 0x6d, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x18,
+	0x07, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0f, 0x52, 0x65, 0x73, 0x75, 0x6d, 0x70, 0x74, 0x69, 0x6f,
+	0x6e, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x22, 0xcd, 0x01, 0x0a, 0x0e, 0x41, 0x63, 0x74, 0x69, 0x76,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x73, 0x70, 0x12, 0x2f, 0x0a, 0x0a, 0x50, 0x61, 0x72,
+	0x61, 0x6d, 0x65, 0x74, 0x65, 0x72, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0f, 0x2e,
+	0x72, 0x70, 0x63, 0x2e, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x65, 0x74, 0x65, 0x72, 0x73, 0x52, 0x0a,
+	0x50, 0x61, 0x72, 0x61, 0x6d, 0x65, 0x74, 0x65, 0x72, 0x73, 0x12, 0x1e, 0x0a, 0x0a, 0x41, 0x50,
+	0x49, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0a,
+	0x41, 0x50, 0x49, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x20, 0x0a, 0x0b, 0x43, 0x65,
+	0x72, 0x74, 0x69, 0x66, 0x69, 0x63, 0x61, 0x74, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x0b, 0x43, 0x65, 0x72, 0x74, 0x69, 0x66, 0x69, 0x63, 0x61, 0x74, 0x65, 0x12, 0x1e, 0x0a, 0x0a,
+	0x50, 0x72, 0x69, 0x76, 0x61, 0x74, 0x65, 0x4b, 0x65, 0x79, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x0a, 0x50, 0x72, 0x69, 0x76, 0x61, 0x74, 0x65, 0x4b, 0x65, 0x79, 0x12, 0x28, 0x0a, 0x0f,
+	0x52, 0x65, 0x73, 0x75, 0x6d, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x18,
+	0x05, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0f, 0x52, 0x65, 0x73, 0x75, 0x6d, 0x70, 0x74, 0x69, 0x6f,
+	0x6e, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x22, 0xad, 0x03, 0x0a, 0x0a, 0x50, 0x61, 0x72, 0x61, 0x6d,
+	0x65, 0x74, 0x65, 0x72, 0x73, 0x12, 0x30, 0x0a, 0x05, 0x46, 0x69, 0x6c, 0x65, 0x73, 0x18, 0x01,
+	0x20, 0x03, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x50, 0x61, 0x72, 0x61, 0x6d,
+	0x65, 0x74, 0x65, 0x72, 0x73, 0x2e, 0x46, 0x69, 0x6c, 0x65, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79,
+	0x52, 0x05, 0x46, 0x69, 0x6c, 0x65, 0x73, 0x12, 0x2a, 0x0a, 0x03, 0x45, 0x6e, 0x76, 0x18, 0x02,
+	0x20, 0x03, 0x28, 0x0b, 0x32, 0x18, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x50, 0x61, 0x72, 0x61, 0x6d,
+	0x65, 0x74, 0x65, 0x72, 0x73, 0x2e, 0x45, 0x6e, 0x76, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x03,
+	0x45, 0x6e, 0x76, 0x12, 0x12, 0x0a, 0x04, 0x41, 0x72, 0x67, 0x76, 0x18, 0x03, 0x20, 0x03, 0x28,
+	0x09, 0x52, 0x04, 0x41, 0x72, 0x67, 0x76, 0x12, 0x3c, 0x0a, 0x09, 0x46, 0x69, 0x6c, 0x65, 0x53,
+	0x70, 0x65, 0x63, 0x73, 0x18, 0x04, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1e, 0x2e, 0x72, 0x70, 0x63,
+	0x2e, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x65, 0x74, 0x65, 0x72, 0x73, 0x2e, 0x46, 0x69, 0x6c, 0x65,
+	0x53, 0x70, 0x65, 0x63, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x09, 0x46, 0x69, 0x6c, 0x65,
+	0x53, 0x70, 0x65, 0x63, 0x73, 0x12, 0x18, 0x0a, 0x07, 0x48, 0x6f, 0x73, 0x74, 0x45, 0x6e, 0x76,
+	0x18, 0x05, 0x20, 0x03, 0x28, 0x09, 0x52, 0x07, 0x48, 0x6f, 0x73, 0x74, 0x45, 0x6e, 0x76, 0x12,
+	0x1a, 0x0a, 0x08, 0x53, 0x63, 0x72, 0x75, 0x62, 0x45, 0x6e, 0x76, 0x18, 0x06, 0x20, 0x01, 0x28,
+	0x08, 0x52, 0x08, 0x53, 0x63, 0x72, 0x75, 0x62, 0x45, 0x6e, 0x76, 0x1a, 0x38, 0x0a, 0x0a, 0x46,
+	0x69, 0x6c, 0x65, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76,
+	0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75,
+	0x65, 0x3a, 0x02, 0x38, 0x01, 0x1a, 0x36, 0x0a, 0x08, 0x45, 0x6e, 0x76, 0x45, 0x6e, 0x74, 0x72,
+	0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03,
+	0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x1a, 0x47, 0x0a,
+	0x0e, 0x46, 0x69, 0x6c, 0x65, 0x53, 0x70, 0x65, 0x63, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12,
 	0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65,
-	0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09,
-	0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x32, 0x3d, 0x0a, 0x06, 0x4d,
-	0x61, 0x72, 0x62, 0x6c, 0x65, 0x12, 0x33, 0x0a, 0x08, 0x41, 0x63, 0x74, 0x69, 0x76, 0x61, 0x74,
-	0x65, 0x12, 0x12, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x41, 0x63, 0x74, 0x69, 0x76, 0x61, 0x74, 0x69,
-	0x6f, 0x6e, 0x52, 0x65, 0x71, 0x1a, 0x13, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x41, 0x63, 0x74, 0x69,
-	0x76, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x73, 0x70, 0x42, 0x26, 0x5a, 0x24, 0x67, 0x69,
-	0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x65, 0x64, 0x67, 0x65, 0x6c, 0x65, 0x73,
-	0x73, 0x73, 0x79, 0x73, 0x2f, 0x6d, 0x61, 0x72, 0x62, 0x6c, 0x65, 0x72, 0x75, 0x6e, 0x2f, 0x72,
-	0x70, 0x63, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x79, 0x12, 0x1f, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x09, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x46, 0x69, 0x6c, 0x65, 0x52, 0x05, 0x76, 0x61, 0x6c,
+	0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x1e, 0x0a, 0x0a, 0x52, 0x65, 0x6e, 0x65, 0x77, 0x61,
+	0x6c, 0x52, 0x65, 0x71, 0x12, 0x10, 0x0a, 0x03, 0x43, 0x53, 0x52, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x03, 0x43, 0x53, 0x52, 0x22, 0x4f, 0x0a, 0x0b, 0x52, 0x65, 0x6e, 0x65, 0x77, 0x61,
+	0x6c, 0x52, 0x65, 0x73, 0x70, 0x12, 0x20, 0x0a, 0x0b, 0x43, 0x65, 0x72, 0x74, 0x69, 0x66, 0x69,
+	0x63, 0x61, 0x74, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x43, 0x65, 0x72, 0x74,
+	0x69, 0x66, 0x69, 0x63, 0x61, 0x74, 0x65, 0x12, 0x1e, 0x0a, 0x0a, 0x50, 0x72, 0x69, 0x76, 0x61,
+	0x74, 0x65, 0x4b, 0x65, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x50, 0x72, 0x69,
+	0x76, 0x61, 0x74, 0x65, 0x4b, 0x65, 0x79, 0x22, 0x0e, 0x0a, 0x0c, 0x48, 0x65, 0x61, 0x72, 0x74,
+	0x62, 0x65, 0x61, 0x74, 0x52, 0x65, 0x71, 0x22, 0x2b, 0x0a, 0x0d, 0x48, 0x65, 0x61, 0x72, 0x74,
+	0x62, 0x65, 0x61, 0x74, 0x52, 0x65, 0x73, 0x70, 0x12, 0x1a, 0x0a, 0x08, 0x49, 0x6e, 0x74, 0x65,
+	0x72, 0x76, 0x61, 0x6c, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x08, 0x49, 0x6e, 0x74, 0x65,
+	0x72, 0x76, 0x61, 0x6c, 0x22, 0x26, 0x0a, 0x12, 0x57, 0x61, 0x74, 0x63, 0x68, 0x50, 0x61, 0x72,
+	0x61, 0x6d, 0x65, 0x74, 0x65, 0x72, 0x73, 0x52, 0x65, 0x71, 0x12, 0x10, 0x0a, 0x03, 0x43, 0x53,
+	0x52, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x43, 0x53, 0x52, 0x22, 0x46, 0x0a, 0x13,
+	0x57, 0x61, 0x74, 0x63, 0x68, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x65, 0x74, 0x65, 0x72, 0x73, 0x52,
+	0x65, 0x73, 0x70, 0x12, 0x2f, 0x0a, 0x0a, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x65, 0x74, 0x65, 0x72,
+	0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x50, 0x61,
+	0x72, 0x61, 0x6d, 0x65, 0x74, 0x65, 0x72, 0x73, 0x52, 0x0a, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x65,
+	0x74, 0x65, 0x72, 0x73, 0x22, 0x0a, 0x0a, 0x08, 0x4e, 0x6f, 0x6e, 0x63, 0x65, 0x52, 0x65, 0x71,
+	0x22, 0x21, 0x0a, 0x09, 0x4e, 0x6f, 0x6e, 0x63, 0x65, 0x52, 0x65, 0x73, 0x70, 0x12, 0x14, 0x0a,
+	0x05, 0x4e, 0x6f, 0x6e, 0x63, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x4e, 0x6f,
+	0x6e, 0x63, 0x65, 0x22, 0x32, 0x0a, 0x0e, 0x57, 0x61, 0x74, 0x63, 0x68, 0x45, 0x76, 0x65, 0x6e,
+	0x74, 0x73, 0x52, 0x65, 0x71, 0x12, 0x20, 0x0a, 0x0b, 0x4d, 0x61, 0x72, 0x62, 0x6c, 0x65, 0x54,
+	0x79, 0x70, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x0b, 0x4d, 0x61, 0x72, 0x62,
+	0x6c, 0x65, 0x54, 0x79, 0x70, 0x65, 0x73, 0x22, 0xbd, 0x01, 0x0a, 0x05, 0x45, 0x76, 0x65, 0x6e,
+	0x74, 0x12, 0x12, 0x0a, 0x04, 0x54, 0x79, 0x70, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x04, 0x54, 0x79, 0x70, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x54, 0x69, 0x6d, 0x65, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x04, 0x54, 0x69, 0x6d, 0x65, 0x12, 0x1e, 0x0a, 0x0a, 0x4d, 0x61, 0x72,
+	0x62, 0x6c, 0x65, 0x54, 0x79, 0x70, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x4d,
+	0x61, 0x72, 0x62, 0x6c, 0x65, 0x54, 0x79, 0x70, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x55, 0x55, 0x49,
+	0x44, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x55, 0x55, 0x49, 0x44, 0x12, 0x26, 0x0a,
+	0x0e, 0x49, 0x6e, 0x66, 0x72, 0x61, 0x73, 0x74, 0x72, 0x75, 0x63, 0x74, 0x75, 0x72, 0x65, 0x18,
+	0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0e, 0x49, 0x6e, 0x66, 0x72, 0x61, 0x73, 0x74, 0x72, 0x75,
+	0x63, 0x74, 0x75, 0x72, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x52, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x18,
+	0x06, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x52, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x12, 0x18, 0x0a,
+	0x07, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07,
+	0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x22, 0x8c, 0x01, 0x0a, 0x04, 0x46, 0x69, 0x6c, 0x65,
+	0x12, 0x12, 0x0a, 0x04, 0x44, 0x61, 0x74, 0x61, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04,
+	0x44, 0x61, 0x74, 0x61, 0x12, 0x1a, 0x0a, 0x08, 0x45, 0x6e, 0x63, 0x6f, 0x64, 0x69, 0x6e, 0x67,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x45, 0x6e, 0x63, 0x6f, 0x64, 0x69, 0x6e, 0x67,
+	0x12, 0x12, 0x0a, 0x04, 0x4d, 0x6f, 0x64, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x04,
+	0x4d, 0x6f, 0x64, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x55, 0x73, 0x65, 0x72, 0x18, 0x04, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x04, 0x55, 0x73, 0x65, 0x72, 0x12, 0x14, 0x0a, 0x05, 0x47, 0x72, 0x6f, 0x75,
+	0x70, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x47, 0x72, 0x6f, 0x75, 0x70, 0x12, 0x16,
+	0x0a, 0x06, 0x4e, 0x6f, 0x44, 0x69, 0x73, 0x6b, 0x18, 0x06, 0x20, 0x01, 0x28, 0x08, 0x52, 0x06,
+	0x4e, 0x6f, 0x44, 0x69, 0x73, 0x6b, 0x32, 0x8d, 0x02, 0x0a, 0x06, 0x4d, 0x61, 0x72, 0x62, 0x6c,
+	0x65, 0x12, 0x33, 0x0a, 0x08, 0x41, 0x63, 0x74, 0x69, 0x76, 0x61, 0x74, 0x65, 0x12, 0x12, 0x2e,
+	0x72, 0x70, 0x63, 0x2e, 0x41, 0x63, 0x74, 0x69, 0x76, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x65,
+	0x71, 0x1a, 0x13, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x41, 0x63, 0x74, 0x69, 0x76, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x52, 0x65, 0x73, 0x70, 0x12, 0x2a, 0x0a, 0x05, 0x52, 0x65, 0x6e, 0x65, 0x77, 0x12,
+	0x0f, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x52, 0x65, 0x6e, 0x65, 0x77, 0x61, 0x6c, 0x52, 0x65, 0x71,
+	0x1a, 0x10, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x52, 0x65, 0x6e, 0x65, 0x77, 0x61, 0x6c, 0x52, 0x65,
+	0x73, 0x70, 0x12, 0x32, 0x0a, 0x09, 0x48, 0x65, 0x61, 0x72, 0x74, 0x62, 0x65, 0x61, 0x74, 0x12,
+	0x11, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x48, 0x65, 0x61, 0x72, 0x74, 0x62, 0x65, 0x61, 0x74, 0x52,
+	0x65, 0x71, 0x1a, 0x12, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x48, 0x65, 0x61, 0x72, 0x74, 0x62, 0x65,
+	0x61, 0x74, 0x52, 0x65, 0x73, 0x70, 0x12, 0x46, 0x0a, 0x0f, 0x57, 0x61, 0x74, 0x63, 0x68, 0x50,
+	0x61, 0x72, 0x61, 0x6d, 0x65, 0x74, 0x65, 0x72, 0x73, 0x12, 0x17, 0x2e, 0x72, 0x70, 0x63, 0x2e,
+	0x57, 0x61, 0x74, 0x63, 0x68, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x65, 0x74, 0x65, 0x72, 0x73, 0x52,
+	0x65, 0x71, 0x1a, 0x18, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x57, 0x61, 0x74, 0x63, 0x68, 0x50, 0x61,
+	0x72, 0x61, 0x6d, 0x65, 0x74, 0x65, 0x72, 0x73, 0x52, 0x65, 0x73, 0x70, 0x30, 0x01, 0x12, 0x26,
+	0x0a, 0x05, 0x4e, 0x6f, 0x6e, 0x63, 0x65, 0x12, 0x0d, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x4e, 0x6f,
+	0x6e, 0x63, 0x65, 0x52, 0x65, 0x71, 0x1a, 0x0e, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x4e, 0x6f, 0x6e,
+	0x63, 0x65, 0x52, 0x65, 0x73, 0x70, 0x32, 0x3a, 0x0a, 0x06, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x73,
+	0x12, 0x30, 0x0a, 0x0b, 0x57, 0x61, 0x74, 0x63, 0x68, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x73, 0x12,
+	0x13, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x57, 0x61, 0x74, 0x63, 0x68, 0x45, 0x76, 0x65, 0x6e, 0x74,
+	0x73, 0x52, 0x65, 0x71, 0x1a, 0x0a, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x45, 0x76, 0x65, 0x6e, 0x74,
+	0x30, 0x01, 0x42, 0x26, 0x5a, 0x24, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d,
+	0x2f, 0x65, 0x64, 0x67, 0x65, 0x6c, 0x65, 0x73, 0x73, 0x73, 0x79, 0x73, 0x2f, 0x6d, 0x61, 0x72,
+	0x62, 0x6c, 0x65, 0x72, 0x75, 0x6e, 0x2f, 0x72, 0x70, 0x63, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x33,
 }
 
 var (
@@ -263,25 +1052,50 @@ func file_coordinator_proto_rawDescGZIP() []byte {
 	return file_coordinator_proto_rawDescData
 }
 
-var file_coordinator_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_coordinator_proto_msgTypes = make([]protoimpl.MessageInfo, 17)
 var file_coordinator_proto_goTypes = []interface{}{
-	(*ActivationReq)(nil),  // 0: rpc.ActivationReq
-	(*ActivationResp)(nil), // 1: rpc.ActivationResp
-	(*Parameters)(nil),     // 2: rpc.Parameters
-	nil,                    // 3: rpc.Parameters.FilesEntry
-	nil,                    // 4: rpc.Parameters.EnvEntry
+	(*ActivationReq)(nil),       // 0: rpc.ActivationReq
+	(*ActivationResp)(nil),      // 1: rpc.ActivationResp
+	(*Parameters)(nil),          // 2: rpc.Parameters
+	(*RenewalReq)(nil),          // 3: rpc.RenewalReq
+	(*RenewalResp)(nil),         // 4: rpc.RenewalResp
+	(*HeartbeatReq)(nil),        // 5: rpc.HeartbeatReq
+	(*HeartbeatResp)(nil),       // 6: rpc.HeartbeatResp
+	(*WatchParametersReq)(nil),  // 7: rpc.WatchParametersReq
+	(*WatchParametersResp)(nil), // 8: rpc.WatchParametersResp
+	(*NonceReq)(nil),            // 9: rpc.NonceReq
+	(*NonceResp)(nil),           // 10: rpc.NonceResp
+	(*WatchEventsReq)(nil),      // 11: rpc.WatchEventsReq
+	(*Event)(nil),               // 12: rpc.Event
+	(*File)(nil),                // 13: rpc.File
+	nil,                         // 14: rpc.Parameters.FilesEntry
+	nil,                         // 15: rpc.Parameters.EnvEntry
+	nil,                         // 16: rpc.Parameters.FileSpecsEntry
 }
 var file_coordinator_proto_depIdxs = []int32{
-	2, // 0: rpc.ActivationResp.Parameters:type_name -> rpc.Parameters
-	3, // 1: rpc.Parameters.Files:type_name -> rpc.Parameters.FilesEntry
-	4, // 2: rpc.Parameters.Env:type_name -> rpc.Parameters.EnvEntry
-	0, // 3: rpc.Marble.Activate:input_type -> rpc.ActivationReq
-	1, // 4: rpc.Marble.Activate:output_type -> rpc.ActivationResp
-	4, // [4:5] is the sub-list for method output_type
-	3, // [3:4] is the sub-list for method input_type
-	3, // [3:3] is the sub-list for extension type_name
-	3, // [3:3] is the sub-list for extension extendee
-	0, // [0:3] is the sub-list for field type_name
+	2,  // 0: rpc.ActivationResp.Parameters:type_name -> rpc.Parameters
+	14, // 1: rpc.Parameters.Files:type_name -> rpc.Parameters.FilesEntry
+	15, // 2: rpc.Parameters.Env:type_name -> rpc.Parameters.EnvEntry
+	16, // 3: rpc.Parameters.FileSpecs:type_name -> rpc.Parameters.FileSpecsEntry
+	2,  // 4: rpc.WatchParametersResp.Parameters:type_name -> rpc.Parameters
+	13, // 5: rpc.Parameters.FileSpecsEntry.value:type_name -> rpc.File
+	0,  // 6: rpc.Marble.Activate:input_type -> rpc.ActivationReq
+	3,  // 7: rpc.Marble.Renew:input_type -> rpc.RenewalReq
+	5,  // 8: rpc.Marble.Heartbeat:input_type -> rpc.HeartbeatReq
+	7,  // 9: rpc.Marble.WatchParameters:input_type -> rpc.WatchParametersReq
+	9,  // 10: rpc.Marble.Nonce:input_type -> rpc.NonceReq
+	11, // 11: rpc.Events.WatchEvents:input_type -> rpc.WatchEventsReq
+	1,  // 12: rpc.Marble.Activate:output_type -> rpc.ActivationResp
+	4,  // 13: rpc.Marble.Renew:output_type -> rpc.RenewalResp
+	6,  // 14: rpc.Marble.Heartbeat:output_type -> rpc.HeartbeatResp
+	8,  // 15: rpc.Marble.WatchParameters:output_type -> rpc.WatchParametersResp
+	10, // 16: rpc.Marble.Nonce:output_type -> rpc.NonceResp
+	12, // 17: rpc.Events.WatchEvents:output_type -> rpc.Event
+	12, // [12:18] is the sub-list for method output_type
+	6,  // [6:12] is the sub-list for method input_type
+	6,  // [6:6] is the sub-list for extension type_name
+	6,  // [6:6] is the sub-list for extension extendee
+	0,  // [0:6] is the sub-list for field type_name
 }
 
 func init() { file_coordinator_proto_init() }
@@ -326,6 +1140,138 @@ func file_coordinator_proto_init() {
 				return nil
 			}
 		}
+		file_coordinator_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RenewalReq); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_coordinator_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RenewalResp); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_coordinator_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*HeartbeatReq); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_coordinator_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*HeartbeatResp); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_coordinator_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*WatchParametersReq); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_coordinator_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*WatchParametersResp); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_coordinator_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*NonceReq); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_coordinator_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*NonceResp); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_coordinator_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*WatchEventsReq); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_coordinator_proto_msgTypes[12].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Event); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_coordinator_proto_msgTypes[13].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*File); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -333,9 +1279,9 @@ func file_coordinator_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_coordinator_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   5,
+			NumMessages:   17,
 			NumExtensions: 0,
-			NumServices:   1,
+			NumServices:   2,
 		},
 		GoTypes:           file_coordinator_proto_goTypes,
 		DependencyIndexes: file_coordinator_proto_depIdxs,
@@ -361,6 +1307,17 @@ const _ = grpc.SupportPackageIsVersion6
 type MarbleClient interface {
 	// Activate activates a marble in the mesh.
 	Activate(ctx context.Context, in *ActivationReq, opts ...grpc.CallOption) (*ActivationResp, error)
+	// Renew issues a new certificate to an activated marble, which authenticates with its current marble certificate.
+	// It does not count as an activation.
+	Renew(ctx context.Context, in *RenewalReq, opts ...grpc.CallOption) (*RenewalResp, error)
+	// Heartbeat reports that an activated marble is still alive. The marble authenticates with its marble certificate.
+	Heartbeat(ctx context.Context, in *HeartbeatReq, opts ...grpc.CallOption) (*HeartbeatResp, error)
+	// WatchParameters streams the marble's parameters whenever they change after a manifest update or a change of secrets.
+	// The marble authenticates with its marble certificate. Each update contains a new marble certificate for the CSR, it does not count as an activation.
+	WatchParameters(ctx context.Context, in *WatchParametersReq, opts ...grpc.CallOption) (Marble_WatchParametersClient, error)
+	// Nonce returns a nonce that the marble binds its quote to for its next activation.
+	// The marble must activate on the same connection, because the Coordinator keeps the nonce only in memory.
+	Nonce(ctx context.Context, in *NonceReq, opts ...grpc.CallOption) (*NonceResp, error)
 }
 
 type marbleClient struct {
@@ -380,10 +1337,80 @@ func (c *marbleClient) Activate(ctx context.Context, in *ActivationReq, opts ...
 	return out, nil
 }
 
+func (c *marbleClient) Renew(ctx context.Context, in *RenewalReq, opts ...grpc.CallOption) (*RenewalResp, error) {
+	out := new(RenewalResp)
+	err := c.cc.Invoke(ctx, "/rpc.Marble/Renew", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *marbleClient) Heartbeat(ctx context.Context, in *HeartbeatReq, opts ...grpc.CallOption) (*HeartbeatResp, error) {
+	out := new(HeartbeatResp)
+	err := c.cc.Invoke(ctx, "/rpc.Marble/Heartbeat", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *marbleClient) WatchParameters(ctx context.Context, in *WatchParametersReq, opts ...grpc.CallOption) (Marble_WatchParametersClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Marble_serviceDesc.Streams[0], "/rpc.Marble/WatchParameters", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &marbleWatchParametersClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+func (c *marbleClient) Nonce(ctx context.Context, in *NonceReq, opts ...grpc.CallOption) (*NonceResp, error) {
+	out := new(NonceResp)
+	err := c.cc.Invoke(ctx, "/rpc.Marble/Nonce", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+type Marble_WatchParametersClient interface {
+	Recv() (*WatchParametersResp, error)
+	grpc.ClientStream
+}
+
+type marbleWatchParametersClient struct {
+	grpc.ClientStream
+}
+
+func (x *marbleWatchParametersClient) Recv() (*WatchParametersResp, error) {
+	m := new(WatchParametersResp)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // MarbleServer is the server API for Marble service.
 type MarbleServer interface {
 	// Activate activates a marble in the mesh.
 	Activate(context.Context, *ActivationReq) (*ActivationResp, error)
+	// Renew issues a new certificate to an activated marble, which authenticates with its current marble certificate.
+	// It does not count as an activation.
+	Renew(context.Context, *RenewalReq) (*RenewalResp, error)
+	// Heartbeat reports that an activated marble is still alive. The marble authenticates with its marble certificate.
+	Heartbeat(context.Context, *HeartbeatReq) (*HeartbeatResp, error)
+	// WatchParameters streams the marble's parameters whenever they change after a manifest update or a change of secrets.
+	// The marble authenticates with its marble certificate. Each update contains a new marble certificate for the CSR, it does not count as an activation.
+	WatchParameters(*WatchParametersReq, Marble_WatchParametersServer) error
+	// Nonce returns a nonce that the marble binds its quote to for its next activation.
+	// The marble must activate on the same connection, because the Coordinator keeps the nonce only in memory.
+	Nonce(context.Context, *NonceReq) (*NonceResp, error)
 }
 
 // UnimplementedMarbleServer can be embedded to have forward compatible implementations.
@@ -393,6 +1420,18 @@ type UnimplementedMarbleServer struct {
 func (*UnimplementedMarbleServer) Activate(context.Context, *ActivationReq) (*ActivationResp, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Activate not implemented")
 }
+func (*UnimplementedMarbleServer) Renew(context.Context, *RenewalReq) (*RenewalResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Renew not implemented")
+}
+func (*UnimplementedMarbleServer) Heartbeat(context.Context, *HeartbeatReq) (*HeartbeatResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Heartbeat not implemented")
+}
+func (*UnimplementedMarbleServer) WatchParameters(*WatchParametersReq, Marble_WatchParametersServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchParameters not implemented")
+}
+func (*UnimplementedMarbleServer) Nonce(context.Context, *NonceReq) (*NonceResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Nonce not implemented")
+}
 
 func RegisterMarbleServer(s *grpc.Server, srv MarbleServer) {
 	s.RegisterService(&_Marble_serviceDesc, srv)
@@ -416,6 +1455,81 @@ func _Marble_Activate_Handler(srv interface{}, ctx context.Context, dec func(int
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Marble_Renew_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RenewalReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MarbleServer).Renew(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.Marble/Renew",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MarbleServer).Renew(ctx, req.(*RenewalReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Marble_Heartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HeartbeatReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MarbleServer).Heartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.Marble/Heartbeat",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MarbleServer).Heartbeat(ctx, req.(*HeartbeatReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Marble_WatchParameters_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchParametersReq)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MarbleServer).WatchParameters(m, &marbleWatchParametersServer{stream})
+}
+
+type Marble_WatchParametersServer interface {
+	Send(*WatchParametersResp) error
+	grpc.ServerStream
+}
+
+type marbleWatchParametersServer struct {
+	grpc.ServerStream
+}
+
+func (x *marbleWatchParametersServer) Send(m *WatchParametersResp) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Marble_Nonce_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NonceReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MarbleServer).Nonce(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.Marble/Nonce",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MarbleServer).Nonce(ctx, req.(*NonceReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _Marble_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "rpc.Marble",
 	HandlerType: (*MarbleServer)(nil),
@@ -424,7 +1538,128 @@ var _Marble_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Activate",
 			Handler:    _Marble_Activate_Handler,
 		},
+		{
+			MethodName: "Renew",
+			Handler:    _Marble_Renew_Handler,
+		},
+		{
+			MethodName: "Heartbeat",
+			Handler:    _Marble_Heartbeat_Handler,
+		},
+		{
+			MethodName: "Nonce",
+			Handler:    _Marble_Nonce_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchParameters",
+			Handler:       _Marble_WatchParameters_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "coordinator.proto",
+}
+
+// EventsClient is the client API for Events service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type EventsClient interface {
+	// WatchEvents streams the activations, activation failures and revocations of marbles and the changes of the manifest as they happen.
+	// The client authenticates with the certificate of a user who is permitted to list the marbles.
+	WatchEvents(ctx context.Context, in *WatchEventsReq, opts ...grpc.CallOption) (Events_WatchEventsClient, error)
+}
+
+type eventsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewEventsClient(cc grpc.ClientConnInterface) EventsClient {
+	return &eventsClient{cc}
+}
+
+func (c *eventsClient) WatchEvents(ctx context.Context, in *WatchEventsReq, opts ...grpc.CallOption) (Events_WatchEventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Events_serviceDesc.Streams[0], "/rpc.Events/WatchEvents", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &eventsWatchEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Events_WatchEventsClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type eventsWatchEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *eventsWatchEventsClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// EventsServer is the server API for Events service.
+type EventsServer interface {
+	// WatchEvents streams the activations, activation failures and revocations of marbles and the changes of the manifest as they happen.
+	// The client authenticates with the certificate of a user who is permitted to list the marbles.
+	WatchEvents(*WatchEventsReq, Events_WatchEventsServer) error
+}
+
+// UnimplementedEventsServer can be embedded to have forward compatible implementations.
+type UnimplementedEventsServer struct {
+}
+
+func (*UnimplementedEventsServer) WatchEvents(*WatchEventsReq, Events_WatchEventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchEvents not implemented")
+}
+
+func RegisterEventsServer(s *grpc.Server, srv EventsServer) {
+	s.RegisterService(&_Events_serviceDesc, srv)
+}
+
+func _Events_WatchEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchEventsReq)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(EventsServer).WatchEvents(m, &eventsWatchEventsServer{stream})
+}
+
+type Events_WatchEventsServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type eventsWatchEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *eventsWatchEventsServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _Events_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "rpc.Events",
+	HandlerType: (*EventsServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchEvents",
+			Handler:       _Events_WatchEvents_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "coordinator.proto",
 }
@@ -0,0 +1,84 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package rpc
+
+import (
+	"fmt"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorDomain is the domain of the ErrorInfo details of the errors returned by the Marble service.
+const ErrorDomain = "marblerun.coordinator"
+
+// Reasons of failed activations. They are reported in the ErrorInfo details of the status returned by Activate.
+const (
+	// ReasonQuoteInvalid means that the marble's quote isn't valid for any infrastructure of the manifest.
+	ReasonQuoteInvalid = "QUOTE_INVALID"
+	// ReasonTCBRejected means that the marble's quote is genuine, but the platform's TCB isn't accepted by any infrastructure.
+	ReasonTCBRejected = "TCB_REJECTED"
+	// ReasonMarbleTypeUnknown means that the manifest doesn't define the requested marble type.
+	ReasonMarbleTypeUnknown = "MARBLE_TYPE_UNKNOWN"
+	// ReasonPackageUnknown means that the manifest doesn't define the package of the requested marble type.
+	ReasonPackageUnknown = "PACKAGE_UNKNOWN"
+	// ReasonMaxActivationsReached means that the marble type has reached its MaxActivations or InfrastructureMaxActivations.
+	ReasonMaxActivationsReached = "MAX_ACTIVATIONS_REACHED"
+	// ReasonManifestMissing means that the Coordinator hasn't been given a manifest yet.
+	ReasonManifestMissing = "MANIFEST_MISSING"
+	// ReasonMarbleRevoked means that the marble's UUID has been revoked.
+	ReasonMarbleRevoked = "MARBLE_REVOKED"
+	// ReasonMaintenance means that the operators have paused the activations, e.g., while they back up the Coordinator's state.
+	ReasonMaintenance = "MAINTENANCE"
+	// ReasonHandedOff means that the Coordinator has handed off its state to a new Coordinator, which the marble must activate with instead.
+	ReasonHandedOff = "HANDED_OFF"
+)
+
+// ActivationError returns a status error with the code and the formatted message. The reason and the metadata are attached as ErrorInfo details.
+func ActivationError(code codes.Code, reason string, metadata map[string]string, format string, a ...interface{}) error {
+	st := status.New(code, fmt.Sprintf(format, a...))
+	withDetails, err := st.WithDetails(&errdetails.ErrorInfo{Reason: reason, Domain: ErrorDomain, Metadata: metadata})
+	if err != nil {
+		// the code and message are still meaningful
+		return st.Err()
+	}
+	return withDetails.Err()
+}
+
+// ErrorReason returns the reason and the metadata of an error returned by the Marble service.
+// The reason is empty if the error doesn't carry ErrorInfo details of the ErrorDomain.
+func ErrorReason(err error) (string, map[string]string) {
+	st, ok := status.FromError(err)
+	if !ok {
+		return "", nil
+	}
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.GetDomain() == ErrorDomain {
+			return info.GetReason(), info.GetMetadata()
+		}
+	}
+	return "", nil
+}
+
+// IsRetryable returns true if an activation that failed with err may succeed if it is retried unchanged,
+// e.g., because the Coordinator is unavailable, busy, in maintenance, still waiting for its manifest or being replaced by a new Coordinator.
+func IsRetryable(err error) bool {
+	reason, _ := ErrorReason(err)
+	switch reason {
+	case ReasonManifestMissing, ReasonMaintenance, ReasonHandedOff:
+		return true
+	case "":
+	default:
+		return false
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
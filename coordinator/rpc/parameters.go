@@ -0,0 +1,125 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+const (
+	// EncodingString is the Encoding of a File whose Data is used as is.
+	EncodingString = "string"
+	// EncodingBase64 is the Encoding of a File whose Data is decoded from standard base64.
+	EncodingBase64 = "base64"
+)
+
+// DefaultFileMode is the mode of files that don't set one.
+const DefaultFileMode = 0600
+
+// HostEnvPlaceholder returns the placeholder that the Coordinator substitutes for a reference to the host environment variable
+// in the templates of the Parameters. The marble replaces it with the value of the variable, because only it knows its host environment.
+func HostEnvPlaceholder(name string) string {
+	return "${hostenv:" + name + "}"
+}
+
+// parametersJSON is the JSON encoding of Parameters in the manifest.
+type parametersJSON struct {
+	Files    map[string]json.RawMessage `json:",omitempty"`
+	Env      map[string]string          `json:",omitempty"`
+	Argv     []string                   `json:",omitempty"`
+	HostEnv  []string                   `json:",omitempty"`
+	ScrubEnv bool                       `json:",omitempty"`
+}
+
+// fileJSON is the JSON encoding of a File in the manifest. The Mode is an octal string like "0755".
+type fileJSON struct {
+	Data     string
+	Encoding string `json:",omitempty"`
+	Mode     string `json:",omitempty"`
+	User     string `json:",omitempty"`
+	Group    string `json:",omitempty"`
+	NoDisk   bool   `json:",omitempty"`
+}
+
+// MarshalJSON encodes the FileSpecs as objects in the Files of the JSON document, next to the Files without attributes, which are strings.
+func (x *Parameters) MarshalJSON() ([]byte, error) {
+	encoded := parametersJSON{Env: x.Env, Argv: x.Argv, HostEnv: x.HostEnv, ScrubEnv: x.ScrubEnv}
+	if len(x.Files)+len(x.FileSpecs) > 0 {
+		encoded.Files = make(map[string]json.RawMessage, len(x.Files)+len(x.FileSpecs))
+	}
+	for path, data := range x.Files {
+		value, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		encoded.Files[path] = value
+	}
+	for path, file := range x.FileSpecs {
+		if _, ok := x.Files[path]; ok {
+			return nil, fmt.Errorf("file %v is defined in Files and FileSpecs", path)
+		}
+		spec := fileJSON{Data: string(file.GetData()), Encoding: file.GetEncoding(), User: file.GetUser(), Group: file.GetGroup(), NoDisk: file.GetNoDisk()}
+		if file.GetMode() != 0 {
+			spec.Mode = fmt.Sprintf("%04o", file.GetMode())
+		}
+		value, err := json.Marshal(spec)
+		if err != nil {
+			return nil, err
+		}
+		encoded.Files[path] = value
+	}
+	return json.Marshal(encoded)
+}
+
+// UnmarshalJSON decodes the Files of the JSON document. Strings are decoded into the Files, objects into the FileSpecs.
+func (x *Parameters) UnmarshalJSON(data []byte) error {
+	var decoded parametersJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	x.Files = nil
+	x.FileSpecs = nil
+	x.Env = decoded.Env
+	x.Argv = decoded.Argv
+	x.HostEnv = decoded.HostEnv
+	x.ScrubEnv = decoded.ScrubEnv
+
+	for path, value := range decoded.Files {
+		if trimmed := bytes.TrimSpace(value); len(trimmed) == 0 || trimmed[0] != '{' {
+			var content string
+			if err := json.Unmarshal(value, &content); err != nil {
+				return fmt.Errorf("file %v: %v", path, err)
+			}
+			if x.Files == nil {
+				x.Files = make(map[string]string)
+			}
+			x.Files[path] = content
+			continue
+		}
+
+		var spec fileJSON
+		if err := json.Unmarshal(value, &spec); err != nil {
+			return fmt.Errorf("file %v: %v", path, err)
+		}
+		file := &File{Data: []byte(spec.Data), Encoding: spec.Encoding, User: spec.User, Group: spec.Group, NoDisk: spec.NoDisk}
+		if spec.Mode != "" {
+			mode, err := strconv.ParseUint(spec.Mode, 8, 32)
+			if err != nil {
+				return fmt.Errorf("file %v: invalid mode %q, the mode must be octal like \"0755\"", path, spec.Mode)
+			}
+			file.Mode = uint32(mode)
+		}
+		if x.FileSpecs == nil {
+			x.FileSpecs = make(map[string]*File)
+		}
+		x.FileSpecs[path] = file
+	}
+	return nil
+}
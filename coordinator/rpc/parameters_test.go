@@ -0,0 +1,57 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package rpc
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParametersJSON(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// files are strings or objects with attributes
+	rawParams := `{
+		"Files": {
+			"/config": "plain",
+			"/run.sh": {"Data": "#!/bin/sh", "Mode": "0755"},
+			"/key": {"Data": "{{ base64 .Secrets.key }}", "Encoding": "base64", "User": "app", "Group": "1000", "NoDisk": true}
+		},
+		"Env": {"KEY": "value"},
+		"Argv": ["./marble"],
+		"HostEnv": ["OMP_NUM_THREADS"],
+		"ScrubEnv": true
+	}`
+	var params Parameters
+	require.NoError(json.Unmarshal([]byte(rawParams), &params))
+	assert.Equal(map[string]string{"/config": "plain"}, params.Files)
+	assert.Equal(map[string]*File{
+		"/run.sh": {Data: []byte("#!/bin/sh"), Mode: 0755},
+		"/key":    {Data: []byte("{{ base64 .Secrets.key }}"), Encoding: EncodingBase64, User: "app", Group: "1000", NoDisk: true},
+	}, params.FileSpecs)
+	assert.Equal(map[string]string{"KEY": "value"}, params.Env)
+	assert.Equal([]string{"./marble"}, params.Argv)
+	assert.Equal([]string{"OMP_NUM_THREADS"}, params.HostEnv)
+	assert.True(params.ScrubEnv)
+
+	// the parameters survive a round trip
+	encoded, err := json.Marshal(&params)
+	require.NoError(err)
+	var decoded Parameters
+	require.NoError(json.Unmarshal(encoded, &decoded))
+	assert.Equal(params.Files, decoded.Files)
+	assert.Equal(params.FileSpecs, decoded.FileSpecs)
+	assert.Equal(params.HostEnv, decoded.HostEnv)
+	assert.True(decoded.ScrubEnv)
+
+	assert.Error(json.Unmarshal([]byte(`{"Files": {"/run.sh": {"Data": "", "Mode": "rwx"}}}`), &params))
+	assert.Error(json.Unmarshal([]byte(`{"Files": {"/run.sh": 1}}`), &params))
+}
@@ -0,0 +1,25 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package rpc
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+)
+
+// QuoteMessage returns the message a marble issues its quote over if it activates with a nonce of the Coordinator:
+// the nonce followed by the SHA-256 hash of the public key of the marble's CSR.
+//
+// The quote thus can't be used for another activation or for a certificate of another key.
+func QuoteMessage(nonce []byte, rawCSR []byte) ([]byte, error) {
+	csr, err := x509.ParseCertificateRequest(rawCSR)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(csr.RawSubjectPublicKeyInfo)
+	return append(append([]byte(nil), nonce...), hash[:]...), nil
+}
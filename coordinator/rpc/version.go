@@ -0,0 +1,15 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package rpc
+
+// ActivationAPIVersion is the highest version of the activation API implemented by this release.
+//
+// Increase it when the meaning of existing fields of the Marble service changes. Adding fields doesn't require a new version.
+const ActivationAPIVersion = 1
+
+// MinActivationAPIVersion is the lowest version of the activation API the Coordinator supports.
+const MinActivationAPIVersion = 1
@@ -0,0 +1,57 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package server
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+
+	"go.uber.org/zap"
+)
+
+// StartDebugServer starts a HTTP server with the runtime profiles of net/http/pprof on /debug/pprof/ and the variables of expvar on /debug/vars,
+// which runs until it is shut down or ctx is done.
+//
+// The endpoints aren't authenticated and profiling slows the Coordinator down, so the address must be a loopback address.
+func StartDebugServer(ctx context.Context, address string, zapLogger *zap.Logger) (*Server, error) {
+	if err := checkLoopback(address); err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	zapLogger.Warn("starting debug http server", zap.String("address", listener.Addr().String()))
+	serve, shutdown := serveHTTP(&http.Server{Handler: mux}, false)
+	return newServer(ctx, listener, serve, shutdown, zapLogger), nil
+}
+
+// checkLoopback returns an error if the host of the address isn't a loopback address or localhost.
+func checkLoopback(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("the debug server must listen on a loopback address, not on %q", address)
+	}
+	return nil
+}
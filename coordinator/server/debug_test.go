@@ -0,0 +1,46 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestStartDebugServer(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// the debug endpoints must not be reachable from outside the host
+	for _, address := range []string{":0", "0.0.0.0:0", "[::]:0", "10.0.0.1:0", "coordinator:0", "localhost"} {
+		_, err := StartDebugServer(context.Background(), address, zap.NewNop())
+		assert.Error(err, address)
+	}
+
+	server, err := StartDebugServer(context.Background(), "127.0.0.1:0", zap.NewNop())
+	require.NoError(err)
+	defer server.Shutdown(context.Background())
+
+	resp, err := http.Get("http://" + server.Addr() + "/debug/vars")
+	require.NoError(err)
+	defer resp.Body.Close()
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	var vars map[string]interface{}
+	require.NoError(json.NewDecoder(resp.Body).Decode(&vars))
+	assert.Contains(vars, "memstats")
+
+	resp, err = http.Get("http://" + server.Addr() + "/debug/pprof/goroutine?debug=1")
+	require.NoError(err)
+	resp.Body.Close()
+	assert.Equal(http.StatusOK, resp.StatusCode)
+}
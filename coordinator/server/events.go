@@ -0,0 +1,97 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+	"github.com/edgelesssys/marblerun/coordinator/core"
+)
+
+// eventsPath is the route of the client API that streams the events of the mesh as server-sent events.
+const eventsPath = clientapi.BasePath + "/events"
+
+// eventKeepAliveInterval is the interval of the comments that keep idle event streams from being closed by proxies.
+const eventKeepAliveInterval = 15 * time.Second
+
+// handleEvents registers the stream of the events of the mesh, see core.Core.SubscribeEvents.
+//
+// The events are sent as server-sent events, whose names are the types of the events and whose data are the JSON-encoded clientapi.Events.
+// The query parameter marbleType, which may be repeated, filters the events by the types of the marbles. If the client doesn't receive the
+// events fast enough, the stream ends with an error event.
+func handleEvents(mux *http.ServeMux, cc core.ClientCore) {
+	mux.HandleFunc(eventsPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed(r))
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeError(w, http.StatusInternalServerError, errors.New("the server doesn't support streaming"))
+			return
+		}
+		events, unsubscribe, err := cc.SubscribeEvents(r.Context(), r.URL.Query()["marbleType"], getClientCert(r))
+		if err != nil {
+			writeClientError(w, err)
+			return
+		}
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+		keepAlive := time.NewTicker(eventKeepAliveInterval)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-streamsDone(r.Context()):
+				return
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+			case event, ok := <-events:
+				if !ok {
+					fmt.Fprintf(w, "event: error\ndata: %v\n\n", core.ErrEventsDropped)
+					flusher.Flush()
+					return
+				}
+				data, err := json.Marshal(event)
+				if err != nil {
+					return
+				}
+				fmt.Fprintf(w, "event: %v\ndata: %s\n\n", event.Type, data)
+			}
+			flusher.Flush()
+		}
+	})
+}
+
+// isEventStream returns true if the request watches the events of the mesh, which lasts as long as the client watches.
+func isEventStream(r *http.Request) bool {
+	return r.URL.Path == eventsPath
+}
+
+type streamsDoneKey struct{}
+
+// withStreams returns a context from which the event streams learn that the server shuts down. They don't end on their own otherwise.
+func withStreams(ctx context.Context, streams context.Context) context.Context {
+	return context.WithValue(ctx, streamsDoneKey{}, streams.Done())
+}
+
+// streamsDone returns a channel that is closed when the server shuts down, or nil if ctx has no such channel.
+func streamsDone(ctx context.Context) <-chan struct{} {
+	done, _ := ctx.Value(streamsDoneKey{}).(<-chan struct{})
+	return done
+}
@@ -0,0 +1,79 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/edgelesssys/marblerun/coordinator/core"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Paths of the health probes, which are served outside of the versioned client API.
+const (
+	livenessPath  = "/healthz"
+	readinessPath = "/readyz"
+)
+
+// handleProbes registers the liveness and readiness probes of the Coordinator, e.g., for Kubernetes and load balancers.
+//
+// The Coordinator is live as long as it serves requests. It is ready once its manifest is set and its state is unsealed, i.e., when it accepts marbles.
+// The probes respond in plain text and don't require a client certificate.
+func handleProbes(mux *http.ServeMux, cc core.ClientCore) {
+	mux.HandleFunc(livenessPath, func(w http.ResponseWriter, r *http.Request) {
+		writeProbe(w, r, true, "ok")
+	})
+	mux.HandleFunc(readinessPath, func(w http.ResponseWriter, r *http.Request) {
+		ready, status := cc.IsReady(r.Context())
+		writeProbe(w, r, ready, status)
+	})
+}
+
+// writeProbe writes the result of a probe. Failed probes are answered with 503 Service Unavailable.
+func writeProbe(w http.ResponseWriter, r *http.Request, ok bool, message string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, errMethodNotAllowed(r).Error(), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	fmt.Fprintln(w, message)
+}
+
+// isProbe returns true if the request is a health probe, which are requested every few seconds.
+func isProbe(r *http.Request) bool {
+	return r.URL.Path == livenessPath || r.URL.Path == readinessPath
+}
+
+// marbleServiceName is the name of the Marble service in the gRPC health checking protocol.
+const marbleServiceName = "rpc.Marble"
+
+// newMarbleHealthServer creates a server of the gRPC health checking protocol. The server as a whole is serving as long as it runs,
+// the Marble service only while the Coordinator accepts marbles, i.e., once its manifest is set and its state is unsealed.
+func newMarbleHealthServer(core *core.Core) *health.Server {
+	healthServer := health.NewServer()
+	core.SetReadinessListener(func(ready bool) {
+		status := healthpb.HealthCheckResponse_NOT_SERVING
+		if ready {
+			status = healthpb.HealthCheckResponse_SERVING
+		}
+		healthServer.SetServingStatus(marbleServiceName, status)
+	})
+	return healthServer
+}
+
+// logDecider omits the health checks from the request logs of the marble server, as they are sent every few seconds.
+func logDecider(fullMethodName string, err error) bool {
+	return !strings.HasPrefix(fullMethodName, "/grpc.health.v1.Health/") || err != nil
+}
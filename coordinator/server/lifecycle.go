@@ -0,0 +1,132 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// ShutdownTimeout is the time a server waits for in-flight requests when its context is done.
+const ShutdownTimeout = 30 * time.Second
+
+// Server is a running server of the Coordinator that has been started by one of the Start functions.
+type Server struct {
+	addr     string
+	shutdown func(context.Context) error
+	done     chan struct{}
+	err      error
+}
+
+// newServer serves the listener in the background until the server is shut down, which it is as soon as ctx is done.
+//
+// serve must return nil after shutdown has been called.
+func newServer(ctx context.Context, listener net.Listener, serve func(net.Listener) error, shutdown func(context.Context) error, zapLogger *zap.Logger) *Server {
+	s := &Server{addr: listener.Addr().String(), shutdown: shutdown, done: make(chan struct{})}
+	go func() {
+		s.err = serve(listener)
+		close(s.done)
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+			defer cancel()
+			if err := s.Shutdown(shutdownCtx); err != nil {
+				zapLogger.Warn("server did not shut down gracefully", zap.String("address", s.addr), zap.Error(err))
+			}
+		case <-s.done:
+		}
+	}()
+	return s
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() string {
+	return s.addr
+}
+
+// Done returns a channel that is closed when the server has stopped.
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the error that has stopped the server, or nil if it has been shut down. It must only be called after Done has been closed.
+func (s *Server) Err() error {
+	return s.err
+}
+
+// Shutdown closes the listener of the server and waits until the in-flight requests have been served.
+// If ctx is done before, the remaining connections are closed and the error of ctx is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.shutdown(ctx)
+	<-s.done
+	return err
+}
+
+// FirstDone returns a channel that receives the first of the servers that stops, e.g., to shut down the others when one fails.
+func FirstDone(servers ...*Server) <-chan *Server {
+	// buffered, so that the servers that stop later don't block
+	done := make(chan *Server, len(servers))
+	for _, s := range servers {
+		go func(s *Server) {
+			<-s.done
+			done <- s
+		}(s)
+	}
+	return done
+}
+
+// serveHTTP returns the serve and shutdown functions of the HTTP server for newServer.
+func serveHTTP(server *http.Server, useTLS bool) (func(net.Listener) error, func(context.Context) error) {
+	serve := func(listener net.Listener) error {
+		var err error
+		if useTLS {
+			err = server.ServeTLS(listener, "", "")
+		} else {
+			err = server.Serve(listener)
+		}
+		if err == http.ErrServerClosed {
+			return nil
+		}
+		return err
+	}
+	shutdown := func(ctx context.Context) error {
+		if err := server.Shutdown(ctx); err != nil {
+			server.Close()
+			return err
+		}
+		return nil
+	}
+	return serve, shutdown
+}
+
+// serveGRPC returns the serve and shutdown functions of the gRPC server for newServer.
+//
+// Streams, e.g., of marbles watching their parameters, don't end on their own, so they are closed when ctx is done.
+func serveGRPC(server *grpc.Server) (func(net.Listener) error, func(context.Context) error) {
+	shutdown := func(ctx context.Context) error {
+		stopped := make(chan struct{})
+		go func() {
+			server.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+			return nil
+		case <-ctx.Done():
+			server.Stop()
+			return ctx.Err()
+		}
+	}
+	return server.Serve, shutdown
+}
@@ -0,0 +1,127 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+func TestServerShutdown(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(err)
+	serve, shutdown := serveHTTP(&http.Server{Handler: handler}, false)
+	server := newServer(context.Background(), listener, serve, shutdown, zap.NewNop())
+
+	respErr := make(chan error)
+	go func() {
+		resp, err := http.Get("http://" + server.Addr())
+		if err == nil {
+			resp.Body.Close()
+		}
+		respErr <- err
+	}()
+	<-started
+
+	// the in-flight request is drained
+	shutdownErr := make(chan error)
+	go func() { shutdownErr <- server.Shutdown(context.Background()) }()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	assert.NoError(<-respErr)
+	assert.NoError(<-shutdownErr)
+	<-server.Done()
+	assert.NoError(server.Err())
+
+	// the listener has been closed
+	_, err = http.Get("http://" + server.Addr())
+	assert.Error(err)
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	})
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(err)
+	serve, shutdown := serveHTTP(&http.Server{Handler: handler}, false)
+	server := newServer(context.Background(), listener, serve, shutdown, zap.NewNop())
+
+	go http.Get("http://" + server.Addr())
+	<-started
+
+	// the connection of a request that doesn't finish is closed
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(context.DeadlineExceeded, server.Shutdown(ctx))
+	<-server.Done()
+}
+
+func TestServerContext(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	serve, shutdown := serveGRPC(grpc.NewServer())
+	server := newServer(ctx, listener, serve, shutdown, zap.NewNop())
+
+	// the server is shut down when its context is done
+	cancel()
+	select {
+	case <-server.Done():
+	case <-time.After(time.Second):
+		t.Fatal("server has not been shut down")
+	}
+	assert.NoError(server.Err())
+}
+
+func TestFirstDone(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var servers []*Server
+	for i := 0; i < 2; i++ {
+		listener, err := net.Listen("tcp", "localhost:0")
+		require.NoError(err)
+		serve, shutdown := serveHTTP(&http.Server{}, false)
+		servers = append(servers, newServer(context.Background(), listener, serve, shutdown, zap.NewNop()))
+	}
+	done := FirstDone(servers...)
+
+	require.NoError(servers[1].Shutdown(context.Background()))
+	select {
+	case s := <-done:
+		assert.Equal(servers[1], s)
+	case <-time.After(time.Second):
+		t.Fatal("stopped server has not been received")
+	}
+	require.NoError(servers[0].Shutdown(context.Background()))
+}
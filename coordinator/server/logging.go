@@ -0,0 +1,110 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/edgelesssys/marblerun/util"
+	"github.com/google/uuid"
+	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// requestIDHeader is the HTTP header that carries the request ID in responses of the client API.
+const requestIDHeader = "X-Request-Id"
+
+// requestIDUnaryInterceptor assigns an ID to each gRPC request.
+//
+// The ID is added to the request's tags, so that it is included in the log entries of the gRPC server, and to the context, so that the Core can include it in its own log entries.
+// It must be chained after grpc_ctxtags.
+func requestIDUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	id := uuid.New().String()
+	grpc_ctxtags.Extract(ctx).Set("request_id", id)
+	return handler(util.WithRequestID(ctx, id), req)
+}
+
+// maxRequestIDLength is the maximum length of a request ID that a client passes in the X-Request-Id header.
+const maxRequestIDLength = 128
+
+// assignRequestIDs assigns an ID to each request of the client API, which is returned in the X-Request-Id header.
+//
+// A request ID passed by the client, e.g., by a reverse proxy that has already logged the request, is kept, so that the
+// log entries of both can be correlated. Otherwise, a new one is generated. The ID and the client address are added to the context.
+func assignRequestIDs(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.New().String()
+		}
+		w.Header().Set(requestIDHeader, id)
+		handler.ServeHTTP(w, r.WithContext(util.WithClientAddr(util.WithRequestID(r.Context(), id), r.RemoteAddr)))
+	})
+}
+
+// validRequestID returns whether a request ID passed by a client can be safely logged and returned.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.ContainsRune("-_.:", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// logRequests logs each request of the client API with its request ID and user. It must be chained after assignRequestIDs and authenticateUsers.
+func logRequests(zapLogger *zap.Logger) middleware {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			handler.ServeHTTP(recorder, r)
+			log := zapLogger.Info
+			if isProbe(r) {
+				// probes would drown the other requests
+				log = zapLogger.Debug
+			}
+			fields := []zap.Field{
+				zap.String("request_id", util.RequestID(r.Context())),
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.Int("status", recorder.status),
+				zap.String("remote_addr", r.RemoteAddr),
+				zap.Duration("duration", time.Since(start)),
+			}
+			if user := util.User(r.Context()); user != "" {
+				fields = append(fields, zap.String("user", user))
+			}
+			log("handled client API request", fields...)
+		})
+	}
+}
+
+// statusRecorder records the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(statusCode int) {
+	r.status = statusCode
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Flush sends buffered data to the client, so that streaming handlers work through the recorder.
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
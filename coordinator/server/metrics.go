@@ -0,0 +1,46 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package server
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc/stats"
+)
+
+var openConnections = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: "marblerun",
+	Subsystem: "coordinator",
+	Name:      "grpc_open_connections",
+	Help:      "Number of open connections to the marble server.",
+})
+
+// connectionCounter is a gRPC stats handler that tracks the number of open connections.
+type connectionCounter struct {
+	gauge prometheus.Gauge
+}
+
+func (c connectionCounter) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (c connectionCounter) HandleRPC(context.Context, stats.RPCStats) {}
+
+func (c connectionCounter) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (c connectionCounter) HandleConn(_ context.Context, s stats.ConnStats) {
+	switch s.(type) {
+	case *stats.ConnBegin:
+		c.gauge.Inc()
+	case *stats.ConnEnd:
+		c.gauge.Dec()
+	}
+}
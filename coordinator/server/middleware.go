@@ -0,0 +1,156 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+
+	"github.com/edgelesssys/marblerun/util"
+	"go.uber.org/zap"
+)
+
+// corsMaxAge is the time in seconds that browsers may cache the result of a CORS preflight request.
+const corsMaxAge = 600
+
+// middleware wraps a handler of the client API with functionality that is shared by all routes.
+type middleware func(http.Handler) http.Handler
+
+// chain wraps the handler with the middlewares. The first middleware sees a request first.
+func chain(handler http.Handler, middlewares ...middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// clientMiddlewares returns the middlewares of the client server in the order in which they handle a request.
+func clientMiddlewares(opts ListenerOptions, zapLogger *zap.Logger) []middleware {
+	return []middleware{
+		assignRequestIDs,
+		recoverPanics(zapLogger),
+		authenticateUsers(opts.Authenticate, zapLogger),
+		logRequests(zapLogger),
+		allowCORS(opts.CORSOrigins),
+	}
+}
+
+// recoverPanics answers requests whose handler panics with an internal error instead of closing the connection, and logs the panic.
+func recoverPanics(zapLogger *zap.Logger) middleware {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				p := recover()
+				if p == nil {
+					return
+				}
+				// the handler aborts on purpose, e.g., when the client has gone away
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+				zapLogger.Error("client API handler panicked",
+					zap.String("request_id", util.RequestID(r.Context())),
+					zap.String("method", r.Method),
+					zap.String("path", r.URL.Path),
+					zap.Any("panic", p),
+					zap.ByteString("stack", debug.Stack()),
+				)
+				writeError(w, http.StatusInternalServerError, errors.New("internal error"))
+			}()
+			handler.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Authenticator returns the name of the user that the client certificate of a request belongs to, or an empty string if it belongs to none.
+//
+// It is the hook through which the client server learns the users of the client API, e.g., from the manifest.
+type Authenticator func(ctx context.Context, clientCert *x509.Certificate) (string, error)
+
+// authenticateUsers adds the user that the client certificate of a request belongs to to the request's context, see util.User.
+//
+// Requests without a known client certificate are passed on without a user, as many routes are public. The routes that are
+// restricted to users still authorize the client themselves.
+func authenticateUsers(authenticate Authenticator, zapLogger *zap.Logger) middleware {
+	return func(handler http.Handler) http.Handler {
+		if authenticate == nil {
+			return handler
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			clientCert := getClientCert(r)
+			if clientCert == nil {
+				handler.ServeHTTP(w, r)
+				return
+			}
+			user, err := authenticate(r.Context(), clientCert)
+			if err != nil {
+				zapLogger.Warn("cannot authenticate the client", zap.String("request_id", util.RequestID(r.Context())), zap.Error(err))
+			}
+			if user != "" {
+				r = r.WithContext(util.WithUser(r.Context(), user))
+			}
+			handler.ServeHTTP(w, r)
+		})
+	}
+}
+
+// allowCORS allows web applications served by the origins, e.g., a dashboard, to call the client API from a browser.
+//
+// An origin of "*" allows all origins. Browsers only send the client certificate of the user to origins that are listed explicitly.
+// Preflight requests of allowed origins are answered directly.
+func allowCORS(origins []string) middleware {
+	return func(handler http.Handler) http.Handler {
+		if len(origins) == 0 {
+			return handler
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				handler.ServeHTTP(w, r)
+				return
+			}
+			header := w.Header()
+			header.Add("Vary", "Origin")
+			listed := contains(origins, origin)
+			if !listed && !contains(origins, "*") {
+				handler.ServeHTTP(w, r)
+				return
+			}
+			header.Set("Access-Control-Allow-Origin", origin)
+			if listed {
+				header.Set("Access-Control-Allow-Credentials", "true")
+			}
+			header.Set("Access-Control-Expose-Headers", requestIDHeader)
+
+			method := r.Header.Get("Access-Control-Request-Method")
+			if r.Method != http.MethodOptions || method == "" {
+				handler.ServeHTTP(w, r)
+				return
+			}
+			// the routes check the method and the headers of the actual request
+			header.Set("Access-Control-Allow-Methods", method)
+			if requestHeaders := r.Header.Get("Access-Control-Request-Headers"); requestHeaders != "" {
+				header.Set("Access-Control-Allow-Headers", requestHeaders)
+			}
+			header.Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+	"github.com/edgelesssys/marblerun/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestAssignRequestIDs(t *testing.T) {
+	assert := assert.New(t)
+
+	var requestID string
+	handler := assignRequestIDs(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID = util.RequestID(r.Context())
+	}))
+
+	// the ID of a reverse proxy is kept
+	req := httptest.NewRequest(http.MethodGet, "/status", nil)
+	req.Header.Set(requestIDHeader, "proxy-1234")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assert.Equal("proxy-1234", requestID)
+	assert.Equal("proxy-1234", resp.Header().Get(requestIDHeader))
+
+	// IDs that can't be logged safely are replaced
+	for _, id := range []string{"line\nbreak", strings.Repeat("a", maxRequestIDLength+1)} {
+		req := httptest.NewRequest(http.MethodGet, "/status", nil)
+		req.Header.Set(requestIDHeader, id)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		assert.NotEqual(id, requestID)
+		assert.NotEmpty(requestID)
+		assert.Equal(requestID, resp.Header().Get(requestIDHeader))
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	core, logs := observer.New(zap.ErrorLevel)
+	handler := chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("handler bug")
+	}), assignRequestIDs, recoverPanics(zap.New(core)))
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/status", nil))
+	assert.Equal(http.StatusInternalServerError, resp.Code)
+	var body clientapi.Response
+	require.NoError(json.Unmarshal(resp.Body.Bytes(), &body))
+	assert.Equal(clientapi.ErrorInternal, body.Error.Code)
+	// the panic is logged with the request ID, but not returned to the client
+	assert.NotContains(resp.Body.String(), "handler bug")
+	require.Equal(1, logs.Len())
+	assert.Equal(resp.Header().Get(requestIDHeader), logs.All()[0].ContextMap()["request_id"])
+}
+
+func TestAuthenticateUsers(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	adminCert := &x509.Certificate{Raw: []byte("admin")}
+	authenticate := func(ctx context.Context, clientCert *x509.Certificate) (string, error) {
+		if clientCert == adminCert {
+			return "admin", nil
+		}
+		return "", nil
+	}
+	core, logs := observer.New(zap.InfoLevel)
+	var user string
+	handler := chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = util.User(r.Context())
+	}), assignRequestIDs, authenticateUsers(authenticate, zap.NewNop()), logRequests(zap.New(core)))
+
+	// the user is passed to the handler and logged
+	req := httptest.NewRequest(http.MethodGet, "/status", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{adminCert}}
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal("admin", user)
+	require.Equal(1, logs.Len())
+	assert.Equal("admin", logs.All()[0].ContextMap()["user"])
+
+	// clients without a known certificate are passed on anonymously
+	req = httptest.NewRequest(http.MethodGet, "/status", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Raw: []byte("other")}}}
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Empty(user)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/status", nil))
+	assert.Empty(user)
+}
+
+func TestAllowCORS(t *testing.T) {
+	assert := assert.New(t)
+
+	called := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true })
+	handler := allowCORS([]string{"https://dashboard.example.com"})(next)
+
+	// requests of listed origins may read the response with credentials
+	req := httptest.NewRequest(http.MethodGet, "/status", nil)
+	req.Header.Set("Origin", "https://dashboard.example.com")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assert.True(called)
+	assert.Equal("https://dashboard.example.com", resp.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal("true", resp.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(requestIDHeader, resp.Header().Get("Access-Control-Expose-Headers"))
+
+	// preflight requests are answered directly
+	called = false
+	req = httptest.NewRequest(http.MethodOptions, "/manifest", nil)
+	req.Header.Set("Origin", "https://dashboard.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	req.Header.Set("Access-Control-Request-Headers", "Content-Type")
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assert.False(called)
+	assert.Equal(http.StatusNoContent, resp.Code)
+	assert.Equal(http.MethodPost, resp.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal("Content-Type", resp.Header().Get("Access-Control-Allow-Headers"))
+
+	// other origins get no CORS headers
+	req = httptest.NewRequest(http.MethodGet, "/status", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assert.True(called)
+	assert.Empty(resp.Header().Get("Access-Control-Allow-Origin"))
+
+	// all origins may read responses without credentials
+	handler = allowCORS([]string{"*"})(next)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assert.Equal("https://evil.example.com", resp.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(resp.Header().Get("Access-Control-Allow-Credentials"))
+}
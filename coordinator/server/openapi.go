@@ -0,0 +1,254 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package server
+
+import (
+	"encoding"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+)
+
+// openAPISpec is an OpenAPI 3 document describing the client API.
+//
+// It is generated from the endpoints registered with handle, so it cannot diverge from the served routes.
+type openAPISpec struct {
+	OpenAPI    string                                 `json:"openapi"`
+	Info       openAPIInfo                            `json:"info"`
+	Servers    []openAPIServer                        `json:"servers"`
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components openAPIComponents                      `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIServer struct {
+	URL string `json:"url"`
+}
+
+type openAPIOperation struct {
+	Summary     string                     `json:"summary"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name     string `json:"name"`
+	In       string `json:"in"`
+	Required bool   `json:"required"`
+	Schema   schema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIMediaType struct {
+	Schema schema `json:"schema"`
+}
+
+type openAPIComponents struct {
+	Schemas map[string]schema `json:"schemas"`
+}
+
+// schema is a JSON schema as used by OpenAPI 3.
+type schema map[string]interface{}
+
+// errorResponseRef references the schema of all error responses.
+var errorResponseRef = schema{"$ref": "#/components/schemas/ErrorResponse"}
+
+func newOpenAPISpec() *openAPISpec {
+	return &openAPISpec{
+		OpenAPI: "3.0.3",
+		Info:    openAPIInfo{Title: "Marblerun Coordinator client API", Version: "1"},
+		Servers: []openAPIServer{{URL: clientapi.BasePath}},
+		Paths:   map[string]map[string]openAPIOperation{},
+		Components: openAPIComponents{Schemas: map[string]schema{
+			"ErrorResponse": {
+				"type": "object",
+				"properties": map[string]interface{}{
+					"Status": schema{"type": "string", "enum": []string{clientapi.StatusError}},
+					"Data":   schema{"nullable": true},
+					"Error":  schemaOf(reflect.TypeOf(clientapi.Error{}), false),
+				},
+				"required": []string{"Status", "Data", "Error"},
+			},
+		}},
+	}
+}
+
+// add describes the endpoint of the given route and method.
+func (s *openAPISpec) add(route, method string, e endpoint) {
+	op := openAPIOperation{
+		Summary: e.summary,
+		Responses: map[string]openAPIResponse{
+			"200": {
+				Description: "Success",
+				Content: map[string]openAPIMediaType{clientapi.ContentType: {Schema: schema{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"Status": schema{"type": "string", "enum": []string{clientapi.StatusSuccess}},
+						"Data":   dataSchema(e.response),
+					},
+					"required": []string{"Status", "Data"},
+				}}},
+			},
+			"default": {
+				Description: "Error",
+				Content:     map[string]openAPIMediaType{clientapi.ContentType: {Schema: errorResponseRef}},
+			},
+		},
+	}
+	for _, name := range e.query {
+		op.Parameters = append(op.Parameters, openAPIParameter{
+			Name:     name,
+			In:       "query",
+			Required: true,
+			Schema:   schema{"type": "array", "items": schema{"type": "string"}},
+		})
+	}
+	if e.request != nil {
+		op.RequestBody = &openAPIRequestBody{Required: true, Content: requestContent(e.request)}
+	}
+
+	if s.Paths[route] == nil {
+		s.Paths[route] = map[string]openAPIOperation{}
+	}
+	s.Paths[route][strings.ToLower(method)] = op
+}
+
+// serveHTTP serves the document. It is not wrapped in a clientapi.Response, so that it can be consumed by OpenAPI tools directly.
+func (s *openAPISpec) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed(r))
+		return
+	}
+	w.Header().Set("Content-Type", clientapi.ContentType)
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+// dataSchema returns the schema of the data of a successful response. A nil value means that the data is always null.
+func dataSchema(response interface{}) schema {
+	if response == nil {
+		return schema{"nullable": true}
+	}
+	return schemaOf(reflect.TypeOf(response), false)
+}
+
+// requestContent returns the content of a request body. A []byte value means that the body is sent as is.
+func requestContent(request interface{}) map[string]openAPIMediaType {
+	if _, ok := request.([]byte); ok {
+		return map[string]openAPIMediaType{"application/octet-stream": {Schema: schema{"type": "string", "format": "binary"}}}
+	}
+	return map[string]openAPIMediaType{clientapi.ContentType: {Schema: schemaOf(reflect.TypeOf(request), true)}}
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// schemaOf returns the schema of the JSON encoding of values of type t.
+//
+// Fields of objects are required unless they are tagged with omitempty. Request bodies may omit any field.
+func schemaOf(t reflect.Type, request bool) schema {
+	if t.Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(jsonMarshalerType) {
+		// custom encoding that cannot be described
+		return schema{}
+	}
+	if t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType) {
+		return schema{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := schemaOf(t.Elem(), request)
+		s["nullable"] = true
+		return s
+	case reflect.Bool:
+		return schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return schema{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return schema{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return schema{"type": "number"}
+	case reflect.String:
+		return schema{"type": "string"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return schema{"type": "string", "format": "byte", "nullable": true}
+		}
+		return schema{"type": "array", "items": schemaOf(t.Elem(), request), "nullable": true}
+	case reflect.Array:
+		return schema{"type": "array", "items": schemaOf(t.Elem(), request)}
+	case reflect.Map:
+		return schema{"type": "object", "additionalProperties": schemaOf(t.Elem(), request), "nullable": true}
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				// unexported
+				continue
+			}
+			name, omitEmpty := jsonFieldName(field)
+			if name == "" {
+				continue
+			}
+			properties[name] = schemaOf(field.Type, request)
+			if !omitEmpty && !request {
+				required = append(required, name)
+			}
+		}
+		s := schema{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			sort.Strings(required)
+			s["required"] = required
+		}
+		return s
+	}
+	// interfaces and other kinds may be anything
+	return schema{}
+}
+
+// jsonFieldName returns the name of the field in the JSON encoding, or an empty string if the field is not encoded.
+func jsonFieldName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	options := strings.Split(tag, ",")
+	name := options[0]
+	if name == "" {
+		name = field.Name
+	}
+	omitEmpty := false
+	for _, option := range options[1:] {
+		if option == "omitempty" {
+			omitEmpty = true
+		}
+	}
+	return name, omitEmpty
+}
@@ -0,0 +1,203 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/edgelesssys/marblerun/coordinator/core"
+	"github.com/edgelesssys/marblerun/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenAPI(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c := core.NewCoreWithMocks()
+	mux := CreateServeMux(c)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/openapi.json", nil)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	require.Equal(http.StatusOK, resp.Code)
+	assert.Equal("application/json", resp.Header().Get("Content-Type"))
+
+	var spec map[string]interface{}
+	require.NoError(json.Unmarshal(resp.Body.Bytes(), &spec))
+	assert.Equal("3.0.3", spec["openapi"])
+	paths := spec["paths"].(map[string]interface{})
+	for _, route := range []string{"/status", "/marbles", "/manifest", "/update", "/quote", "/recover", "/secrets", "/secrets/rotate"} {
+		assert.Contains(paths, route)
+	}
+	assert.Contains(paths["/manifest"], "post")
+	assert.NotContains(paths["/status"], "post")
+
+	// every response must conform to the specification
+	requests := []struct {
+		method string
+		route  string
+		body   string
+		admin  bool
+	}{
+		{http.MethodGet, "/status", "", false},
+		{http.MethodGet, "/marbles", "", false},
+		{http.MethodGet, "/manifest", "", false},
+		{http.MethodGet, "/quote", "", false},
+		{http.MethodPost, "/manifest", test.ManifestJSON, false},
+		{http.MethodPost, "/manifest", test.ManifestJSON, false},
+		{http.MethodGet, "/status", "", false},
+		{http.MethodGet, "/marbles", "", false},
+		{http.MethodGet, "/manifest", "", false},
+		{http.MethodGet, "/update", "", true},
+		{http.MethodPost, "/update", test.UpdateManifestJSON, false},
+		{http.MethodDelete, "/update", "", true},
+		{http.MethodPost, "/secrets", `{"symmetric_key_shared": {"Private": "AAECAwQFBgcICQoLDA0ODw=="}}`, true},
+		{http.MethodGet, "/secrets?s=symmetric_key_shared", "", true},
+		{http.MethodPost, "/secrets/rotate", `["symmetric_key_shared"]`, true},
+		{http.MethodPost, "/recover", "key", false},
+		{http.MethodPut, "/status", "", false},
+	}
+	for _, r := range requests {
+		name := r.method + " " + r.route
+		var body io.Reader
+		if r.body != "" {
+			body = strings.NewReader(r.body)
+		}
+		req := httptest.NewRequest(r.method, "/api/v1"+r.route, body)
+		if r.admin {
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{test.AdminCert}}
+		}
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+
+		path := strings.Split(r.route, "?")[0]
+		responses, ok := lookup(paths, path, strings.ToLower(r.method), "responses").(map[string]interface{})
+		if !ok {
+			// undocumented methods must be rejected
+			assert.Equal(http.StatusMethodNotAllowed, resp.Code, name)
+			responses = lookup(paths, path, "get", "responses").(map[string]interface{})
+		}
+		response, ok := responses[strconv.Itoa(resp.Code)]
+		if !ok {
+			response = responses["default"]
+		}
+		responseSchema := lookup(response, "content", "application/json", "schema")
+		require.NotNil(responseSchema, name)
+
+		var value interface{}
+		require.NoError(json.Unmarshal(resp.Body.Bytes(), &value), name)
+		assert.NoError(validate(spec, responseSchema, value, ""), "%v: %v", name, resp.Body.String())
+	}
+}
+
+// lookup returns the value at the given path of nested JSON objects, or nil if it does not exist.
+func lookup(value interface{}, path ...string) interface{} {
+	for _, key := range path {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
+
+// validate checks that value conforms to the subset of JSON schema that is generated for the client API.
+func validate(spec map[string]interface{}, s interface{}, value interface{}, path string) error {
+	schema, ok := s.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%v: invalid schema", path)
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		return validate(spec, lookup(spec, strings.Split(strings.TrimPrefix(ref, "#/"), "/")...), value, path)
+	}
+	if value == nil {
+		if schema["nullable"] == true {
+			return nil
+		}
+		return fmt.Errorf("%v: must not be null", path)
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, v := range enum {
+			found = found || v == value
+		}
+		if !found {
+			return fmt.Errorf("%v: %v is not one of %v", path, value, enum)
+		}
+	}
+
+	switch schema["type"] {
+	case nil:
+		return nil
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%v: must be a boolean", path)
+		}
+	case "integer", "number":
+		number, ok := value.(float64)
+		if !ok || (schema["type"] == "integer" && number != float64(int64(number))) {
+			return fmt.Errorf("%v: must be an %v", path, schema["type"])
+		}
+		if minimum, ok := schema["minimum"].(float64); ok && number < minimum {
+			return fmt.Errorf("%v: must be at least %v", path, minimum)
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%v: must be a string", path)
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%v: must be an array", path)
+		}
+		for i, item := range items {
+			if err := validate(spec, schema["items"], item, fmt.Sprintf("%v[%v]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%v: must be an object", path)
+		}
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := object[name.(string)]; !ok {
+				return fmt.Errorf("%v: missing %v", path, name)
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for name, v := range object {
+			propertySchema, ok := properties[name]
+			if !ok {
+				propertySchema, ok = schema["additionalProperties"]
+			}
+			if !ok {
+				return fmt.Errorf("%v: undocumented property %v", path, name)
+			}
+			if err := validate(spec, propertySchema, v, path+"."+name); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%v: unknown type %v", path, schema["type"])
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edgelesssys/marblerun/util"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+// ListenerOptions configure the listeners of the marble server and the client server. The zero value keeps the defaults.
+type ListenerOptions struct {
+	// MinTLSVersion is the minimum TLS version that is accepted, TLS 1.2 by default
+	MinTLSVersion uint16
+	// CipherSuites are the TLS 1.2 cipher suites that are accepted. Go's default suites are accepted if it is empty.
+	// The cipher suites of TLS 1.3 can't be configured.
+	CipherSuites []uint16
+	// MaxConnections is the maximum number of open connections of each server. Further connections wait until one is closed. 0 means unlimited.
+	MaxConnections int
+	// ProxyProtocol requires each connection to start with a PROXY protocol header, whose client address is used instead of the
+	// address of the load balancer, e.g., for the audit log and rate limiting
+	ProxyProtocol bool
+
+	// MaxMessageSize is the maximum size of a message that the marble server receives, 4 MiB by default
+	MaxMessageSize int
+	// KeepaliveTime is the time after which the marble server pings an idle connection, 2 hours by default
+	KeepaliveTime time.Duration
+	// KeepaliveTimeout is the time after which the marble server closes a connection whose ping has not been answered, 20 seconds by default
+	KeepaliveTimeout time.Duration
+
+	// MaxRequestBodySize is the maximum size of a request body that the client server reads, e.g., of a manifest. 0 means unlimited.
+	MaxRequestBodySize int64
+	// IdleTimeout is the time after which the client server closes an idle keep-alive connection. 0 means no timeout.
+	IdleTimeout time.Duration
+	// CORSOrigins are the origins of web applications that may call the client API from a browser, see ParseCORSOrigins. None by default.
+	CORSOrigins []string
+	// Authenticate resolves the client certificates of the client server's requests to users, which are logged with the requests. No users are resolved if it is nil.
+	Authenticate Authenticator
+}
+
+// ParseTLSVersion parses a TLS version like "1.2".
+func ParseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version: %v", version)
+}
+
+// ParseCipherSuites parses a comma-separated list of cipher suite names like TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256.
+//
+// Only cipher suites without known security issues are accepted.
+func ParseCipherSuites(names string) ([]uint16, error) {
+	var ids []uint16
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, suite := range tls.CipherSuites() {
+			if suite.Name == name {
+				ids = append(ids, suite.ID)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unsupported cipher suite: %v", name)
+		}
+	}
+	return ids, nil
+}
+
+// ParseCORSOrigins parses a comma-separated list of origins like https://dashboard.example.com, or "*" for all origins.
+func ParseCORSOrigins(origins string) ([]string, error) {
+	var parsed []string
+	for _, origin := range strings.Split(origins, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "*" {
+			parsed = append(parsed, origin)
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+			return nil, fmt.Errorf("invalid origin: %q", origin)
+		}
+		parsed = append(parsed, u.Scheme+"://"+u.Host)
+	}
+	return parsed, nil
+}
+
+// tlsConfig returns a copy of config with the TLS options applied.
+func (o ListenerOptions) tlsConfig(config *tls.Config) *tls.Config {
+	config = config.Clone()
+	config.MinVersion = tls.VersionTLS12
+	if o.MinTLSVersion != 0 {
+		config.MinVersion = o.MinTLSVersion
+	}
+	if len(o.CipherSuites) > 0 {
+		config.CipherSuites = o.CipherSuites
+	}
+	return config
+}
+
+// WithTLS returns a copy of the options with the minimum TLS version and the cipher suites of a single server,
+// which replace the shared ones if they are set. This allows, e.g., to only accept TLS 1.3 on the client API.
+func (o ListenerOptions) WithTLS(minTLSVersion uint16, cipherSuites []uint16) ListenerOptions {
+	if minTLSVersion != 0 {
+		o.MinTLSVersion = minTLSVersion
+	}
+	if len(cipherSuites) > 0 {
+		o.CipherSuites = cipherSuites
+	}
+	return o
+}
+
+// grpcOptions returns the options of the marble server.
+func (o ListenerOptions) grpcOptions() []grpc.ServerOption {
+	var opts []grpc.ServerOption
+	if o.MaxMessageSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(o.MaxMessageSize))
+	}
+	if o.KeepaliveTime > 0 || o.KeepaliveTimeout > 0 {
+		opts = append(opts, grpc.KeepaliveParams(keepalive.ServerParameters{Time: o.KeepaliveTime, Timeout: o.KeepaliveTimeout}))
+	}
+	return opts
+}
+
+// limitRequestBody limits the size of the request bodies read by the handler.
+func (o ListenerOptions) limitRequestBody(handler http.Handler) http.Handler {
+	if o.MaxRequestBodySize <= 0 {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, o.MaxRequestBodySize)
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// listen listens on the address and limits the number of open connections of the listener.
+//
+// Besides TCP addresses, Unix sockets and vsock addresses are supported, see util.Listen.
+func (o ListenerOptions) listen(addr string) (net.Listener, error) {
+	listener, err := util.Listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	if o.ProxyProtocol {
+		listener = proxyProtocolListener{listener}
+	}
+	if o.MaxConnections <= 0 {
+		return listener, nil
+	}
+	return &limitListener{Listener: listener, slots: make(chan struct{}, o.MaxConnections), closed: make(chan struct{})}, nil
+}
+
+// limitListener is a listener that accepts at most cap(slots) open connections.
+type limitListener struct {
+	net.Listener
+	slots     chan struct{}
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	select {
+	case l.slots <- struct{}{}:
+	case <-l.closed:
+		// the error of the closed listener
+		return l.Listener.Accept()
+	}
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		<-l.slots
+		return nil, err
+	}
+	return &limitConn{Conn: conn, release: func() { <-l.slots }}, nil
+}
+
+func (l *limitListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return l.Listener.Close()
+}
+
+// limitConn releases its slot of the limitListener when it is closed.
+type limitConn struct {
+	net.Conn
+	releaseOnce sync.Once
+	release     func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.releaseOnce.Do(c.release)
+	return err
+}
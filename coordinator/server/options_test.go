@@ -0,0 +1,127 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package server
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseListenerOptions(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	version, err := ParseTLSVersion("1.3")
+	require.NoError(err)
+	assert.EqualValues(tls.VersionTLS13, version)
+	_, err = ParseTLSVersion("1.0")
+	assert.Error(err)
+
+	suites, err := ParseCipherSuites("TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384")
+	require.NoError(err)
+	assert.Equal([]uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}, suites)
+	// insecure cipher suites are rejected
+	_, err = ParseCipherSuites("TLS_RSA_WITH_RC4_128_SHA")
+	assert.Error(err)
+
+	origins, err := ParseCORSOrigins("https://dashboard.example.com/, http://localhost:8080")
+	require.NoError(err)
+	assert.Equal([]string{"https://dashboard.example.com", "http://localhost:8080"}, origins)
+	for _, origin := range []string{"dashboard.example.com", "https://dashboard.example.com/app", "ftp://example.com"} {
+		_, err = ParseCORSOrigins(origin)
+		assert.Error(err, origin)
+	}
+
+	config := ListenerOptions{}.tlsConfig(&tls.Config{})
+	assert.EqualValues(tls.VersionTLS12, config.MinVersion)
+	assert.Nil(config.CipherSuites)
+	config = ListenerOptions{MinTLSVersion: tls.VersionTLS13, CipherSuites: suites}.tlsConfig(&tls.Config{})
+	assert.EqualValues(tls.VersionTLS13, config.MinVersion)
+	assert.Equal(suites, config.CipherSuites)
+
+	// the TLS options of a single server replace the shared ones
+	shared := ListenerOptions{MinTLSVersion: tls.VersionTLS12, CipherSuites: suites, MaxConnections: 5}
+	opts := shared.WithTLS(tls.VersionTLS13, nil)
+	assert.EqualValues(tls.VersionTLS13, opts.MinTLSVersion)
+	assert.Equal(suites, opts.CipherSuites)
+	assert.Equal(5, opts.MaxConnections)
+	opts = shared.WithTLS(0, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384})
+	assert.EqualValues(tls.VersionTLS12, opts.MinTLSVersion)
+	assert.Equal([]uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}, opts.CipherSuites)
+	assert.Equal(suites, shared.CipherSuites)
+}
+
+func TestLimitRequestBody(t *testing.T) {
+	assert := assert.New(t)
+
+	handler := ListenerOptions{MaxRequestBodySize: 4}.limitRequestBody(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := ioutil.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("1234")))
+	assert.Equal(http.StatusOK, resp.Code)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("12345")))
+	assert.Equal(http.StatusBadRequest, resp.Code)
+}
+
+func TestMaxConnections(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	listener, err := ListenerOptions{MaxConnections: 1}.listen("localhost:0")
+	require.NoError(err)
+	defer listener.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	client1, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(err)
+	defer client1.Close()
+	conn1 := <-accepted
+
+	// the second connection is only accepted after the first one has been closed
+	client2, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(err)
+	defer client2.Close()
+	select {
+	case <-accepted:
+		t.Fatal("connection accepted beyond the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.NoError(conn1.Close())
+	conn2 := <-accepted
+	assert.NotNil(conn2)
+	conn2.Close()
+
+	// a closed listener doesn't accept connections
+	listener.Close()
+	_, ok := <-accepted
+	assert.False(ok)
+}
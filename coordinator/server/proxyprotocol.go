@@ -0,0 +1,167 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyHeaderTimeout is the time in which a client must send the PROXY protocol header.
+const proxyHeaderTimeout = 10 * time.Second
+
+// proxyV2Signature starts a binary header of version 2 of the PROXY protocol.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyProtocolListener accepts connections that start with a PROXY protocol header, as sent by load balancers like HAProxy or AWS NLB,
+// and reports the client address of the header as their remote address.
+//
+// The header is read on first use of the connection, so that a slow client doesn't block the accept loop.
+// Connections without a valid header fail, so the listener must only be reachable through the load balancer.
+type proxyProtocolListener struct {
+	net.Listener
+}
+
+func (l proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// proxyConn is a connection whose PROXY protocol header is read on first use.
+type proxyConn struct {
+	net.Conn
+	reader    *bufio.Reader
+	once      sync.Once
+	remote    net.Addr
+	headerErr error
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	if err := c.readHeader(); err != nil {
+		return 0, err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if err := c.readHeader(); err != nil || c.remote == nil {
+		return c.Conn.RemoteAddr()
+	}
+	return c.remote
+}
+
+func (c *proxyConn) readHeader() error {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		c.remote, c.headerErr = readProxyHeader(c.reader)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.headerErr != nil {
+			c.headerErr = fmt.Errorf("invalid PROXY protocol header: %v", c.headerErr)
+			c.Conn.Close()
+		}
+	})
+	return c.headerErr
+}
+
+// readProxyHeader reads a header of version 1 or 2 of the PROXY protocol and returns the source address.
+// The address is nil if the header doesn't contain one, e.g., for health checks of the load balancer.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	signature, err := r.Peek(len(proxyV2Signature))
+	if err == nil && bytes.Equal(signature, proxyV2Signature) {
+		return readProxyHeaderV2(r)
+	}
+	return readProxyHeaderV1(r)
+}
+
+// readProxyHeaderV1 reads a header like "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n".
+func readProxyHeaderV1(r *bufio.Reader) (net.Addr, error) {
+	// a header has at most 107 bytes
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("header too long")
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if fields[0] != "PROXY" || len(fields) < 2 {
+		return nil, errors.New("missing header")
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, fmt.Errorf("unsupported protocol: %v", fields[1])
+	}
+	if len(fields) != 6 {
+		return nil, errors.New("invalid number of fields")
+	}
+	ip := net.ParseIP(fields[2])
+	if ip == nil {
+		return nil, fmt.Errorf("invalid source address: %v", fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source port: %v", fields[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyHeaderV2 reads a binary header.
+func readProxyHeaderV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, len(proxyV2Signature)+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	versionCommand, family := header[12], header[13]
+	length := binary.BigEndian.Uint16(header[14:])
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	if versionCommand>>4 != 2 {
+		return nil, fmt.Errorf("unsupported version: %v", versionCommand>>4)
+	}
+	// the LOCAL command is used by the load balancer itself, e.g., for health checks
+	if versionCommand&0xf == 0 {
+		return nil, nil
+	}
+	switch family {
+	case 0x11: // TCP over IPv4: source and destination address, source and destination port
+		if len(payload) < 12 {
+			return nil, errors.New("header too short")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(payload) < 36 {
+			return nil, errors.New("header too short")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:]))}, nil
+	}
+	// other families, e.g., Unix sockets, don't carry an IP address
+	return nil, nil
+}
@@ -0,0 +1,116 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package server
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	v2Header := func(command byte, family byte, payload []byte) string {
+		header := append([]byte{}, proxyV2Signature...)
+		header = append(header, 0x20|command, family, 0, byte(len(payload)))
+		return string(append(header, payload...))
+	}
+	ipv6 := net.ParseIP("2001:db8::1")
+
+	testCases := map[string]struct {
+		header  string
+		addr    string
+		wantErr bool
+	}{
+		"v1 TCP4": {
+			header: "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n",
+			addr:   "192.0.2.1:56324",
+		},
+		"v1 TCP6": {
+			header: "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n",
+			addr:   "[2001:db8::1]:56324",
+		},
+		"v1 unknown": {
+			header: "PROXY UNKNOWN\r\n",
+		},
+		"v1 invalid address": {
+			header:  "PROXY TCP4 foo 192.0.2.2 56324 443\r\n",
+			wantErr: true,
+		},
+		"v1 too long": {
+			header:  "PROXY TCP4 " + strings.Repeat("1", 200) + "\r\n",
+			wantErr: true,
+		},
+		"no header": {
+			header:  "GET / HTTP/1.1\r\n",
+			wantErr: true,
+		},
+		"v2 TCP4": {
+			header: v2Header(1, 0x11, []byte{192, 0, 2, 1, 192, 0, 2, 2, 0xdc, 0x04, 0x01, 0xbb}),
+			addr:   "192.0.2.1:56324",
+		},
+		"v2 TCP6": {
+			header: v2Header(1, 0x21, append(append(append([]byte{}, ipv6...), ipv6...), 0xdc, 0x04, 0x01, 0xbb)),
+			addr:   "[2001:db8::1]:56324",
+		},
+		"v2 local": {
+			header: v2Header(0, 0, nil),
+		},
+		"v2 too short": {
+			header:  v2Header(1, 0x11, []byte{192, 0, 2, 1}),
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			addr, err := readProxyHeader(bufio.NewReader(strings.NewReader(tc.header)))
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			if tc.addr == "" {
+				assert.Nil(addr)
+				return
+			}
+			assert.Equal(tc.addr, addr.String())
+		})
+	}
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	listener, err := ListenerOptions{ProxyProtocol: true}.listen("localhost:0")
+	require.NoError(err)
+	defer listener.Close()
+
+	go func() {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\nhello"))
+		conn.Close()
+	}()
+
+	conn, err := listener.Accept()
+	require.NoError(err)
+	defer conn.Close()
+	assert.Equal("192.0.2.1:56324", conn.RemoteAddr().String())
+	data, err := ioutil.ReadAll(conn)
+	assert.NoError(err)
+	assert.Equal("hello", string(data))
+}
@@ -8,26 +8,35 @@
 package server
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
-	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
 	"github.com/edgelesssys/marblerun/coordinator/core"
 	"github.com/edgelesssys/marblerun/coordinator/rpc"
-	"github.com/gorilla/handlers"
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_zap "github.com/grpc-ecosystem/go-grpc-middleware/logging/zap"
 	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
 	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 type certQuoteResp struct {
@@ -40,156 +49,958 @@ type statusResp struct {
 }
 type manifestSignatureResp struct {
 	ManifestSignature string
+	// Signer is the name of the trusted signer that signed the manifest, if any
+	Signer string `json:",omitempty"`
 }
 
-// Contains RSA-encrypted AES state sealing key with public key specified by user in manifest
+// Contains the activation statistics of each Marble type
+type marbleStatusResp struct {
+	Marbles map[string]clientapi.MarbleStatus
+}
+
+// Contains the activated Marbles
+type marbleInventoryResp struct {
+	Marbles []clientapi.Marble
+}
+
+// Contains RSA-encrypted AES state sealing key for each public key specified by user in manifest
 type recoveryDataResp struct {
-	EncryptionKey string
+	EncryptionKeys map[string]string
+}
+
+// Contains the PEM-encoded CA certificate, optionally followed by its issuers, and the PEM-encoded private key of the Coordinator's root CA
+type rootCAReq struct {
+	Certificate string
+	Key         string
+}
+
+// Contains the number of acknowledgements that are still required for a manifest update to take effect
+type updateResp struct {
+	Remaining int
+}
+
+// Contains the manifest update that is waiting for acknowledgements
+type pendingUpdateResp struct {
+	Update         string
+	AcknowledgedBy []string
+	Remaining      int
+}
+
+// Contains the number of recovery shares that are still required to recover the state
+type recoverResp struct {
+	Remaining int
+}
+
+// Contains a backup exported by a Coordinator and the backup key, or a share of it, decrypted with a recovery key
+type restoreReq struct {
+	Backup clientapi.Backup
+	Key    []byte
+}
+
+// Contains the number of backup key shares that are still required to restore the backup and, once it has been restored,
+// the new state encryption key encrypted with each of the manifest's RecoveryKeys
+type restoreResp struct {
+	Remaining      int
+	EncryptionKeys map[string]string `json:",omitempty"`
+}
+
+// Contains the values of the requested secrets
+type secretsResp struct {
+	Secrets map[string]clientapi.Secret
+}
+
+// Contains the new version of each rotated secret
+type rotateSecretsResp struct {
+	Versions map[string]uint
+}
+
+// Contains the entries of the audit log
+type auditLogResp struct {
+	Entries []clientapi.AuditEntry
+}
+
+// Contains the versions of the manifest in the order they have been set and updated
+type manifestHistoryResp struct {
+	Versions []clientapi.ManifestVersion
+}
+
+// Contains the changes between two versions of the manifest, sorted by path
+type manifestDiffResp struct {
+	Changes []clientapi.ManifestChange
+}
+
+// Identifies the marble or the marble type whose certificates are revoked
+type revokeReq struct {
+	MarbleType string
+	UUID       string
+}
+
+// Contains the number of revoked certificates
+type revokeResp struct {
+	Revoked int
+}
+
+// Identifies the activation counter of the marble type, or of its activations on the infrastructure, and its new value
+type resetActivationsReq struct {
+	MarbleType     string
+	Infrastructure string
+	Activations    uint
+}
+
+// Pauses or resumes the activations of Marbles, optionally with the reason for the pause
+type maintenanceReq struct {
+	Paused bool
+	Reason string `json:",omitempty"`
+}
+
+// Contains the PEM-encoded CSR for one of the manifest's ExternalServices and the requested validity in seconds, 0 for the service's maximum
+type issueCertificateReq struct {
+	Service  string
+	CSR      string
+	ValidFor uint64
+}
+
+// Contains the PEM-encoded certificate, followed by the certificates that issued the root certificate if it has been provided by the operator,
+// and the PEM-encoded root certificate
+type issueCertificateResp struct {
+	Certificate string
+	CA          string
+}
+
+// Contains the user data that a quote is requested for, see clientapi.UserDataQuoteMessage
+type quoteUserDataReq struct {
+	UserData []byte
 }
 
-// RunMarbleServer starts a gRPC with the given Coordinator core.
-// `address` is the desired TCP address like "localhost:0".
-// The effective TCP address is returned via `addrChan`.
-func RunMarbleServer(core *core.Core, addr string, addrChan chan string, errChan chan error, zapLogger *zap.Logger) {
-	tlsConfig := tls.Config{
+// Contains the quote of the Coordinator over the user data
+type quoteUserDataResp struct {
+	Quote []byte
+}
+
+// Contains the latest activations of marbles, the most recent first
+type recentActivationsResp struct {
+	Activations []clientapi.Activation
+}
+
+// StartMarbleServer starts a gRPC server with the given Coordinator core, which runs until it is shut down or ctx is done.
+// `addr` is the desired TCP address like "localhost:0", the effective address is returned by Server.Addr.
+func StartMarbleServer(ctx context.Context, core *core.Core, addr string, opts ListenerOptions, zapLogger *zap.Logger) (*Server, error) {
+	tlsConfig := opts.tlsConfig(&tls.Config{
 		GetCertificate: core.GetTLSCertificate,
 		// NOTE: we'll verify the cert later using the given quote
 		ClientAuth: tls.RequireAnyClientCert,
-	}
-	creds := credentials.NewTLS(&tlsConfig)
+	})
+	creds := credentials.NewTLS(tlsConfig)
 
 	// Make sure that log statements internal to gRPC library are logged using the zapLogger as well.
 	grpc_zap.ReplaceGrpcLoggerV2(zapLogger)
 
-	grpcServer := grpc.NewServer(
+	grpcServer := grpc.NewServer(append(opts.grpcOptions(),
 		grpc.Creds(creds),
+		grpc.StatsHandler(connectionCounter{gauge: openConnections}),
 		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(
 			grpc_ctxtags.StreamServerInterceptor(),
-			grpc_zap.StreamServerInterceptor(zapLogger),
+			otelgrpc.StreamServerInterceptor(),
+			grpc_zap.StreamServerInterceptor(zapLogger, grpc_zap.WithDecider(logDecider)),
 			grpc_prometheus.StreamServerInterceptor,
 		)),
 		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
 			grpc_ctxtags.UnaryServerInterceptor(),
-			grpc_zap.UnaryServerInterceptor(zapLogger),
+			otelgrpc.UnaryServerInterceptor(),
+			requestIDUnaryInterceptor,
+			grpc_zap.UnaryServerInterceptor(zapLogger, grpc_zap.WithDecider(logDecider)),
 			grpc_prometheus.UnaryServerInterceptor,
 		)),
-	)
+	)...)
 
 	rpc.RegisterMarbleServer(grpcServer, core)
-	socket, err := net.Listen("tcp", addr)
+	rpc.RegisterEventsServer(grpcServer, core)
+	healthServer := newMarbleHealthServer(core)
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	grpc_prometheus.Register(grpcServer)
+	socket, err := opts.listen(addr)
 	if err != nil {
-		errChan <- err
-		return
+		return nil, err
 	}
-	addrChan <- socket.Addr().String()
-	err = grpcServer.Serve(socket)
-	if err != nil {
-		errChan <- err
+	serve, stop := serveGRPC(grpcServer)
+	// health checks fail during the graceful stop, so that load balancers stop sending new requests
+	shutdown := func(ctx context.Context) error {
+		healthServer.Shutdown()
+		return stop(ctx)
 	}
+	return newServer(ctx, socket, serve, shutdown, zapLogger), nil
 }
 
 // CreateServeMux creates a mux that serves the client API.
+//
+// All routes are prefixed with clientapi.BasePath and respond with a clientapi.Response in JSON format.
 func CreateServeMux(cc core.ClientCore) *http.ServeMux {
 	mux := http.NewServeMux()
+	spec := newOpenAPISpec()
 
-	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			statusCode, status, err := cc.GetStatus(r.Context())
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			writeJSON(w, statusResp{statusCode, status})
-		default:
-			http.Error(w, "", http.StatusMethodNotAllowed)
-		}
+	handle(mux, spec, "/status", methodHandlers{
+		http.MethodGet: {
+			summary:  "Get the state of the Coordinator",
+			response: statusResp{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				statusCode, status, err := cc.GetStatus(r.Context())
+				if err != nil {
+					writeError(w, http.StatusInternalServerError, err)
+					return
+				}
+				writeJSON(w, statusResp{statusCode, status})
+			},
+		},
 	})
 
-	mux.HandleFunc("/manifest", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			signature := cc.GetManifestSignature(r.Context())
-			writeJSON(w, manifestSignatureResp{hex.EncodeToString(signature)})
-		case http.MethodPost:
-			manifest, err := ioutil.ReadAll(r.Body)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			recoveryDataBytes, err := cc.SetManifest(r.Context(), manifest)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
-			// If a recovery key has been set, include recovery data as response. If not, leave response empty.
-			if recoveryDataBytes != nil {
-				encodedRecoveryData := base64.StdEncoding.EncodeToString(recoveryDataBytes)
+	handle(mux, spec, "/marbles", methodHandlers{
+		http.MethodGet: {
+			summary:  "Get the activation statistics of each Marble type",
+			response: marbleStatusResp{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				marbles, err := cc.GetMarbleStatus(r.Context())
+				if err != nil {
+					writeError(w, http.StatusInternalServerError, err)
+					return
+				}
+				writeJSON(w, marbleStatusResp{marbles})
+			},
+		},
+	})
+
+	handle(mux, spec, "/marbles/inventory", methodHandlers{
+		http.MethodGet: {
+			summary:  "Get the activated Marbles, or only the Marble with the UUID given by the query parameter uuid",
+			query:    []string{"uuid"},
+			response: marbleInventoryResp{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				marbles, err := cc.GetActivatedMarbles(r.Context(), r.URL.Query().Get("uuid"), getClientCert(r))
+				if err == core.ErrUnknownMarble {
+					writeError(w, http.StatusNotFound, err)
+					return
+				}
+				if err != nil {
+					writeClientError(w, err)
+					return
+				}
+				writeJSON(w, marbleInventoryResp{marbles})
+			},
+		},
+	})
+
+	handle(mux, spec, "/marbles/activations", methodHandlers{
+		http.MethodPost: {
+			summary: "Set the activation counter of a Marble type, or of its activations on the Infrastructure if it is given. 0 resets the counter",
+			request: resetActivationsReq{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				var req resetActivationsReq
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					writeError(w, http.StatusBadRequest, err)
+					return
+				}
+				if err := cc.ResetActivations(r.Context(), req.MarbleType, req.Infrastructure, req.Activations, getClientCert(r)); err != nil {
+					writeClientError(w, err)
+					return
+				}
+				writeJSON(w, nil)
+			},
+		},
+	})
+
+	handle(mux, spec, "/maintenance", methodHandlers{
+		http.MethodGet: {
+			summary:  "Get whether the activations of Marbles are paused",
+			response: clientapi.Maintenance{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, cc.GetMaintenance(r.Context()))
+			},
+		},
+		http.MethodPost: {
+			summary: "Pause or resume the activations of Marbles. The client API and the activated Marbles are not affected",
+			request: maintenanceReq{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				var req maintenanceReq
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					writeError(w, http.StatusBadRequest, err)
+					return
+				}
+				if err := cc.SetMaintenance(r.Context(), req.Paused, req.Reason, getClientCert(r)); err != nil {
+					writeClientError(w, err)
+					return
+				}
+				writeJSON(w, nil)
+			},
+		},
+	})
+
+	handle(mux, spec, "/manifest", methodHandlers{
+		http.MethodGet: {
+			summary:  "Get the signature of the active manifest",
+			response: manifestSignatureResp{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				signature, signer := cc.GetManifestSignature(r.Context())
+				writeJSON(w, manifestSignatureResp{hex.EncodeToString(signature), signer})
+			},
+		},
+		http.MethodPost: {
+			summary:  "Set the manifest in JSON, YAML or TOML. Returns the recovery data if the manifest defines RecoveryKeys. The base64-encoded detached signature of the manifest is passed in the " + clientapi.ManifestSignatureHeader + " header",
+			request:  core.Manifest{},
+			response: (*recoveryDataResp)(nil),
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				manifest, err := ioutil.ReadAll(r.Body)
+				if err != nil {
+					writeError(w, http.StatusInternalServerError, err)
+					return
+				}
+				if manifest, err = clientapi.CanonicalManifest(manifest, r.Header.Get("Content-Type")); err != nil {
+					writeError(w, http.StatusBadRequest, err)
+					return
+				}
+				var signature []byte
+				if encodedSignature := r.Header.Get(clientapi.ManifestSignatureHeader); encodedSignature != "" {
+					if signature, err = base64.StdEncoding.DecodeString(encodedSignature); err != nil {
+						writeError(w, http.StatusBadRequest, fmt.Errorf("invalid manifest signature: %v", err))
+						return
+					}
+				}
+				recoveryData, err := cc.SetManifest(r.Context(), manifest, signature)
+				if errors.Is(err, core.ErrManifestSignature) {
+					writeError(w, http.StatusUnauthorized, err)
+					return
+				}
+				if err != nil {
+					writeError(w, http.StatusBadRequest, err)
+					return
+				}
+				// If recovery keys have been set, include recovery data as response. If not, leave data empty.
+				if recoveryData == nil {
+					writeJSON(w, nil)
+					return
+				}
+				encodedRecoveryData := make(map[string]string, len(recoveryData))
+				for name, data := range recoveryData {
+					encodedRecoveryData[name] = base64.StdEncoding.EncodeToString(data)
+				}
 				writeJSON(w, recoveryDataResp{encodedRecoveryData})
-			}
-		default:
-			http.Error(w, "", http.StatusMethodNotAllowed)
+			},
+		},
+	})
+
+	handle(mux, spec, "/root-ca", methodHandlers{
+		http.MethodPost: {
+			summary: "Replace the self-generated root certificate with the given CA. Only possible before the manifest is set, which must pin the CA in its RootCA",
+			request: rootCAReq{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				var req rootCAReq
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					writeError(w, http.StatusBadRequest, err)
+					return
+				}
+				if err := cc.SetRootCA(r.Context(), []byte(req.Certificate), []byte(req.Key)); err != nil {
+					writeError(w, http.StatusBadRequest, err)
+					return
+				}
+				writeJSON(w, nil)
+			},
+		},
+	})
+
+	handle(mux, spec, "/update", methodHandlers{
+		http.MethodGet: {
+			summary:  "Get the manifest update that is waiting for acknowledgements",
+			response: pendingUpdateResp{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				update, acknowledgedBy, remaining, err := cc.GetPendingUpdate(r.Context(), getClientCert(r))
+				if err != nil {
+					writeClientError(w, err)
+					return
+				}
+				writeJSON(w, pendingUpdateResp{string(update), acknowledgedBy, remaining})
+			},
+		},
+		http.MethodPost: {
+			summary:  "Propose or acknowledge a manifest update in JSON, YAML or TOML",
+			request:  core.Manifest{},
+			response: updateResp{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				update, err := ioutil.ReadAll(r.Body)
+				if err != nil {
+					writeError(w, http.StatusInternalServerError, err)
+					return
+				}
+				if update, err = clientapi.CanonicalManifest(update, r.Header.Get("Content-Type")); err != nil {
+					writeError(w, http.StatusBadRequest, err)
+					return
+				}
+				remaining, err := cc.UpdateManifest(r.Context(), update, getClientCert(r))
+				if err != nil {
+					writeClientError(w, err)
+					return
+				}
+				writeJSON(w, updateResp{remaining})
+			},
+		},
+		http.MethodDelete: {
+			summary: "Cancel the manifest update that is waiting for acknowledgements",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if err := cc.CancelPendingUpdate(r.Context(), getClientCert(r)); err != nil {
+					writeClientError(w, err)
+					return
+				}
+				writeJSON(w, nil)
+			},
+		},
+	})
+
+	handle(mux, spec, "/quote", methodHandlers{
+		http.MethodGet: {
+			summary:  "Get the root certificate and the quote of the Coordinator",
+			response: certQuoteResp{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				cert, quote, err := cc.GetCertQuote(r.Context())
+				if err != nil {
+					writeError(w, http.StatusInternalServerError, err)
+					return
+				}
+				writeJSON(w, certQuoteResp{cert, quote})
+			},
+		},
+	})
+
+	handle(mux, spec, "/quote/userdata", methodHandlers{
+		http.MethodPost: {
+			summary:  "Get a quote of the Coordinator over user data, e.g., a session public key, to bind it to the Coordinator's enclave",
+			request:  quoteUserDataReq{},
+			response: quoteUserDataResp{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				var req quoteUserDataReq
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					writeError(w, http.StatusBadRequest, err)
+					return
+				}
+				userDataQuote, err := cc.QuoteUserData(r.Context(), req.UserData, getClientCert(r))
+				if err != nil {
+					writeClientError(w, err)
+					return
+				}
+				writeJSON(w, quoteUserDataResp{userDataQuote})
+			},
+		},
+	})
+
+	handle(mux, spec, "/backup", methodHandlers{
+		http.MethodGet: {
+			summary:  "Export an encrypted backup of the state, whose key is encrypted with the manifest's RecoveryKeys",
+			response: clientapi.Backup{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				backup, err := cc.ExportBackup(r.Context(), getClientCert(r))
+				if err != nil {
+					writeClientError(w, err)
+					return
+				}
+				writeJSON(w, backup)
+			},
+		},
+	})
+
+	handle(mux, spec, "/backup/restore", methodHandlers{
+		http.MethodPost: {
+			summary:  "Restore a backup into a Coordinator that has no manifest or is in recovery mode, with the decrypted backup key or a share of it",
+			request:  restoreReq{},
+			response: restoreResp{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				var req restoreReq
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					writeError(w, http.StatusBadRequest, err)
+					return
+				}
+				remaining, recoveryData, err := cc.RestoreBackup(r.Context(), req.Backup, req.Key, getClientCert(r))
+				if err != nil {
+					writeClientError(w, err)
+					return
+				}
+				resp := restoreResp{Remaining: remaining}
+				if recoveryData != nil {
+					resp.EncryptionKeys = make(map[string]string, len(recoveryData))
+					for name, data := range recoveryData {
+						resp.EncryptionKeys[name] = base64.StdEncoding.EncodeToString(data)
+					}
+				}
+				writeJSON(w, resp)
+			},
+		},
+	})
+
+	handle(mux, spec, "/handoff", methodHandlers{
+		http.MethodPost: {
+			summary:  "Hand off the state to a new Coordinator that has been attested by its quote over the handoff key, e.g., to upgrade the Coordinator",
+			request:  clientapi.HandoffRequest{},
+			response: clientapi.Handoff{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				var req clientapi.HandoffRequest
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					writeError(w, http.StatusBadRequest, err)
+					return
+				}
+				handoff, err := cc.HandOff(r.Context(), req, getClientCert(r))
+				if err != nil {
+					writeClientError(w, err)
+					return
+				}
+				writeJSON(w, handoff)
+			},
+		},
+	})
+
+	handle(mux, spec, "/recover", methodHandlers{
+		http.MethodPost: {
+			summary:  "Recover the sealed state with a recovery key or a recovery share. With acceptRollback=1, a state that is older than the rollback counter is accepted",
+			query:    []string{"acceptRollback"},
+			request:  []byte{},
+			response: recoverResp{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				key, err := ioutil.ReadAll(r.Body)
+				if err != nil {
+					writeError(w, http.StatusInternalServerError, err)
+					return
+				}
+				recoverState := cc.Recover
+				if r.URL.Query().Get("acceptRollback") == "1" {
+					recoverState = cc.AcceptRollback
+				}
+				remaining, err := recoverState(r.Context(), key, getClientCert(r))
+				if err == core.ErrNotAuthorized {
+					writeError(w, http.StatusUnauthorized, err)
+					return
+				}
+				if err != nil {
+					writeError(w, http.StatusInternalServerError, err)
+					return
+				}
+				writeJSON(w, recoverResp{remaining})
+			},
+		},
+	})
+
+	handle(mux, spec, "/secrets", methodHandlers{
+		http.MethodGet: {
+			summary:  "Get the values of the secrets named by the query parameter s",
+			query:    []string{"s"},
+			response: secretsResp{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				names := r.URL.Query()["s"]
+				if len(names) == 0 {
+					writeError(w, http.StatusBadRequest, errors.New("no secrets requested"))
+					return
+				}
+				secrets, err := cc.ReadSecrets(r.Context(), names, getClientCert(r))
+				if err != nil {
+					writeClientError(w, err)
+					return
+				}
+				writeJSON(w, secretsResp{secrets})
+			},
+		},
+		http.MethodPost: {
+			summary: "Set the values of user-defined secrets",
+			request: map[string]clientapi.Secret{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				var secrets map[string]clientapi.Secret
+				if err := json.NewDecoder(r.Body).Decode(&secrets); err != nil {
+					writeError(w, http.StatusBadRequest, err)
+					return
+				}
+				if err := cc.WriteSecrets(r.Context(), secrets, getClientCert(r)); err != nil {
+					writeClientError(w, err)
+					return
+				}
+				writeJSON(w, nil)
+			},
+		},
+	})
+
+	handle(mux, spec, "/secrets/rotate", methodHandlers{
+		http.MethodPost: {
+			summary:  "Rotate the named secrets. Marbles receive the new versions on their next activation",
+			request:  []string{},
+			response: rotateSecretsResp{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				var names []string
+				if err := json.NewDecoder(r.Body).Decode(&names); err != nil {
+					writeError(w, http.StatusBadRequest, err)
+					return
+				}
+				if len(names) == 0 {
+					writeError(w, http.StatusBadRequest, errors.New("no secrets to rotate"))
+					return
+				}
+				versions, err := cc.RotateSecrets(r.Context(), names, getClientCert(r))
+				if err != nil {
+					writeClientError(w, err)
+					return
+				}
+				writeJSON(w, rotateSecretsResp{versions})
+			},
+		},
+	})
+
+	handle(mux, spec, "/audit", methodHandlers{
+		http.MethodGet: {
+			summary:  "Get the hash-chained audit log of manifest changes, activations, secret reads and recoveries",
+			response: auditLogResp{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				entries, err := cc.GetAuditLog(r.Context(), getClientCert(r))
+				if err != nil {
+					writeClientError(w, err)
+					return
+				}
+				writeJSON(w, auditLogResp{entries})
+			},
+		},
+	})
+
+	handle(mux, spec, "/manifest/history", methodHandlers{
+		http.MethodGet: {
+			summary:  "Get the versions of the manifest with their time, hash, signer and the Users who acknowledged them",
+			response: manifestHistoryResp{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				versions, err := cc.GetManifestHistory(r.Context(), getClientCert(r))
+				if err != nil {
+					writeClientError(w, err)
+					return
+				}
+				writeJSON(w, manifestHistoryResp{versions})
+			},
+		},
+	})
+
+	handle(mux, spec, "/manifest/diff", methodHandlers{
+		http.MethodGet: {
+			summary:  "Get the changes between the versions of the manifest given by the query parameters from and to",
+			query:    []string{"from", "to"},
+			response: manifestDiffResp{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				var versions [2]uint
+				for i, param := range []string{"from", "to"} {
+					version, err := strconv.ParseUint(r.URL.Query().Get(param), 10, 0)
+					if err != nil {
+						writeError(w, http.StatusBadRequest, fmt.Errorf("invalid version %v: %v", param, err))
+						return
+					}
+					versions[i] = uint(version)
+				}
+				changes, err := cc.GetManifestDiff(r.Context(), versions[0], versions[1], getClientCert(r))
+				if err == core.ErrUnknownManifestVersion {
+					writeError(w, http.StatusNotFound, err)
+					return
+				}
+				if err != nil {
+					writeClientError(w, err)
+					return
+				}
+				writeJSON(w, manifestDiffResp{changes})
+			},
+		},
+	})
+
+	handle(mux, spec, "/revoke", methodHandlers{
+		http.MethodPost: {
+			summary:  "Revoke the certificates of the marble with the UUID, or of all marbles of the type if no UUID is given",
+			request:  revokeReq{},
+			response: revokeResp{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				var req revokeReq
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					writeError(w, http.StatusBadRequest, err)
+					return
+				}
+				revoked, err := cc.RevokeMarble(r.Context(), req.MarbleType, req.UUID, getClientCert(r))
+				if err != nil {
+					writeClientError(w, err)
+					return
+				}
+				writeJSON(w, revokeResp{revoked})
+			},
+		},
+	})
+
+	handle(mux, spec, "/certificates", methodHandlers{
+		http.MethodPost: {
+			summary:  "Issue a certificate for one of the manifest's ExternalServices, e.g., to a cert-manager issuer",
+			request:  issueCertificateReq{},
+			response: issueCertificateResp{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				var req issueCertificateReq
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					writeError(w, http.StatusBadRequest, err)
+					return
+				}
+				cert, ca, err := cc.IssueCertificate(r.Context(), req.Service, []byte(req.CSR), time.Duration(req.ValidFor)*time.Second, getClientCert(r))
+				if err != nil {
+					writeClientError(w, err)
+					return
+				}
+				writeJSON(w, issueCertificateResp{string(cert), string(ca)})
+			},
+		},
+	})
+
+	// describes the routes above
+	mux.HandleFunc(clientapi.BasePath+"/openapi.json", spec.serveHTTP)
+
+	// the CRLs are served in DER format for the clients that check the CRL distribution points of the marble certificates
+	mux.HandleFunc(clientapi.BasePath+"/crl", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed(r))
+			return
 		}
+		crl, err := cc.GetCRL(r.Context(), r.URL.Query().Get("package"))
+		if err == core.ErrUnknownPackage {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pkix-crl")
+		w.Write(crl)
 	})
 
-	mux.HandleFunc("/quote", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			cert, quote, err := cc.GetCertQuote(r.Context())
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			writeJSON(w, certQuoteResp{cert, quote})
-		default:
-			http.Error(w, "", http.StatusMethodNotAllowed)
+	handleProbes(mux, cc)
+	handleEvents(mux, cc)
+
+	// unknown routes of the client API
+	mux.HandleFunc(clientapi.BasePath+"/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown route %v", r.URL.Path))
+	})
+
+	handleDashboard(mux, spec, cc)
+
+	return mux
+}
+
+// handleDashboard registers the read-only routes for a dashboard, which are restricted to Users who are permitted to view the dashboard.
+func handleDashboard(mux *http.ServeMux, spec *openAPISpec, cc core.ClientCore) {
+	handle(mux, spec, "/dashboard/topology", methodHandlers{
+		http.MethodGet: {
+			summary:  "Get the marble types of the mesh with their activations and the TLS tags that connect them",
+			response: clientapi.Topology{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				topology, err := cc.GetTopology(r.Context(), getClientCert(r))
+				if err != nil {
+					writeClientError(w, err)
+					return
+				}
+				writeJSON(w, topology)
+			},
+		},
+	})
+
+	handle(mux, spec, "/dashboard/activations", methodHandlers{
+		http.MethodGet: {
+			summary:  "Get the latest activations, the most recent first. The query parameter limit sets their maximum number, 50 by default",
+			query:    []string{"limit"},
+			response: recentActivationsResp{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				var limit uint64
+				if rawLimit := r.URL.Query().Get("limit"); rawLimit != "" {
+					var err error
+					if limit, err = strconv.ParseUint(rawLimit, 10, 0); err != nil {
+						writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit: %v", err))
+						return
+					}
+				}
+				activations, err := cc.GetRecentActivations(r.Context(), uint(limit), getClientCert(r))
+				if err != nil {
+					writeClientError(w, err)
+					return
+				}
+				writeJSON(w, recentActivationsResp{activations})
+			},
+		},
+	})
+
+	handle(mux, spec, "/dashboard/manifest", methodHandlers{
+		http.MethodGet: {
+			summary:  "Get a summary of the active manifest with the measurements of its packages, without parameters and secret values",
+			response: clientapi.ManifestSummary{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				summary, err := cc.GetManifestSummary(r.Context(), getClientCert(r))
+				if err != nil {
+					writeClientError(w, err)
+					return
+				}
+				writeJSON(w, summary)
+			},
+		},
+	})
+
+	handle(mux, spec, "/dashboard/tcb", methodHandlers{
+		http.MethodGet: {
+			summary:  "Get the TCB requirements of the infrastructures, the activations rejected because of their TCB, and the status of the collateral",
+			response: clientapi.TCBStatus{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				status, err := cc.GetTCBStatus(r.Context(), getClientCert(r))
+				if err != nil {
+					writeClientError(w, err)
+					return
+				}
+				writeJSON(w, status)
+			},
+		},
+	})
+}
+
+// endpoint handles requests with a specific method to a route of the client API.
+type endpoint struct {
+	summary string
+	// query contains the names of the query parameters, which may be repeated
+	query []string
+	// request is a value of the type of the request body, or nil if there is none. A []byte value means that the body is sent as is.
+	request interface{}
+	// response is a value of the type of the response data, or nil if the data is always null
+	response interface{}
+	handler  http.HandlerFunc
+}
+
+// methodHandlers maps HTTP methods to the endpoints of a route.
+type methodHandlers map[string]endpoint
+
+// handle registers the endpoints of a client API route and describes them in spec.
+//
+// Requests with other methods or that do not accept a JSON response are rejected.
+func handle(mux *http.ServeMux, spec *openAPISpec, route string, handlers methodHandlers) {
+	for method, e := range handlers {
+		spec.add(route, method, e)
+	}
+	mux.HandleFunc(clientapi.BasePath+route, func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsJSON(r) {
+			writeError(w, http.StatusNotAcceptable, fmt.Errorf("responses are only available as %v", clientapi.ContentType))
+			return
+		}
+		e, ok := handlers[r.Method]
+		if !ok {
+			writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed(r))
+			return
 		}
+		e.handler(w, r)
 	})
+}
 
-	mux.HandleFunc("/recover", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			key, err := ioutil.ReadAll(r.Body)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			if err = cc.Recover(r.Context(), key); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
+func errMethodNotAllowed(r *http.Request) error {
+	return fmt.Errorf("method %v is not allowed for %v", r.Method, r.URL.Path)
+}
+
+// acceptsJSON returns true if the Accept header of the request allows a JSON response.
+func acceptsJSON(r *http.Request) bool {
+	accept := r.Header.Values("Accept")
+	if len(accept) == 0 {
+		return true
+	}
+	for _, value := range accept {
+		for _, mediaRange := range strings.Split(value, ",") {
+			mediaType := strings.TrimSpace(strings.Split(mediaRange, ";")[0])
+			switch mediaType {
+			case clientapi.ContentType, "application/*", "*/*":
+				return true
 			}
-		default:
-			http.Error(w, "", http.StatusMethodNotAllowed)
 		}
-	})
+	}
+	return false
+}
 
-	return mux
+// getClientCert returns the TLS client certificate of the request, or nil if there is none.
+func getClientCert(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return r.TLS.PeerCertificates[0]
 }
 
-func writeJSON(w http.ResponseWriter, v interface{}) {
-	if err := json.NewEncoder(w).Encode(v); err != nil {
+// writeClientError writes an error of a request that requires client authentication.
+func writeClientError(w http.ResponseWriter, err error) {
+	if err == core.ErrNotAuthorized {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	writeError(w, http.StatusBadRequest, err)
+}
+
+// errorCodes maps HTTP status codes to the error codes of the client API.
+var errorCodes = map[int]string{
+	http.StatusBadRequest:          clientapi.ErrorBadRequest,
+	http.StatusUnauthorized:        clientapi.ErrorUnauthorized,
+	http.StatusNotFound:            clientapi.ErrorNotFound,
+	http.StatusMethodNotAllowed:    clientapi.ErrorMethodNotAllowed,
+	http.StatusNotAcceptable:       clientapi.ErrorNotAcceptable,
+	http.StatusInternalServerError: clientapi.ErrorInternal,
+}
+
+// writeJSON writes a successful response with the given data.
+func writeJSON(w http.ResponseWriter, data interface{}) {
+	writeResponse(w, http.StatusOK, clientapi.Response{Status: clientapi.StatusSuccess, Data: data})
+}
+
+// writeError writes an error response with the given HTTP status code.
+func writeError(w http.ResponseWriter, statusCode int, err error) {
+	writeResponse(w, statusCode, clientapi.Response{
+		Status: clientapi.StatusError,
+		Error:  &clientapi.Error{Code: errorCodes[statusCode], Message: err.Error()},
+	})
+}
+
+func writeResponse(w http.ResponseWriter, statusCode int, resp clientapi.Response) {
+	body, err := json.Marshal(resp)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	w.Header().Set("Content-Type", clientapi.ContentType)
+	w.WriteHeader(statusCode)
+	w.Write(body)
 }
 
-// RunClientServer runs a HTTP server serving mux.
-func RunClientServer(mux *http.ServeMux, address string, tlsConfig *tls.Config, zapLogger *zap.Logger) {
-	loggedRouter := handlers.LoggingHandler(os.Stdout, mux)
-	server := http.Server{
-		Addr:      address,
-		Handler:   loggedRouter,
-		TLSConfig: tlsConfig,
+// StartClientServer starts a HTTPS server serving mux, which runs until it is shut down or ctx is done.
+//
+// The requests pass the middlewares of the client API before they reach mux: they are assigned a request ID, recovered from panics,
+// authenticated with opts.Authenticate, logged and checked against opts.CORSOrigins.
+func StartClientServer(ctx context.Context, mux *http.ServeMux, address string, tlsConfig *tls.Config, opts ListenerOptions, zapLogger *zap.Logger) (*Server, error) {
+	middlewares := clientMiddlewares(opts, zapLogger)
+	loggedRouter := otelhttp.NewHandler(chain(mux, append(middlewares, opts.limitRequestBody)...), "clientapi")
+	// event streams are not traced, as they last as long as the client watches. They are ended when the server shuts down.
+	streamRouter := chain(mux, middlewares...)
+	streams, endStreams := context.WithCancel(context.Background())
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isEventStream(r) {
+				streamRouter.ServeHTTP(w, r.WithContext(withStreams(r.Context(), streams)))
+				return
+			}
+			loggedRouter.ServeHTTP(w, r)
+		}),
+		TLSConfig:   opts.tlsConfig(tlsConfig),
+		IdleTimeout: opts.IdleTimeout,
 	}
-	zapLogger.Info("starting client https server", zap.String("address", address))
-	err := server.ListenAndServeTLS("", "")
-	zapLogger.Warn(err.Error())
+	server.RegisterOnShutdown(endStreams)
+	listener, err := opts.listen(address)
+	if err != nil {
+		return nil, err
+	}
+	zapLogger.Info("starting client https server", zap.String("address", listener.Addr().String()))
+	serve, shutdown := serveHTTP(server, true)
+	return newServer(ctx, listener, serve, shutdown, zapLogger), nil
 }
 
-// RunPrometheusServer runs a HTTP server handling the prometheus metrics endpoint
-func RunPrometheusServer(address string, zapLogger *zap.Logger) {
+// StartPrometheusServer starts a HTTP server handling the prometheus metrics endpoint, which runs until it is shut down or ctx is done.
+func StartPrometheusServer(ctx context.Context, address string, zapLogger *zap.Logger) (*Server, error) {
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", promhttp.Handler())
-	zapLogger.Info("starting prometheus /metrics endpoint", zap.String("address", address))
-	err := http.ListenAndServe(address, mux)
-	zapLogger.Warn(err.Error())
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	zapLogger.Info("starting prometheus /metrics endpoint", zap.String("address", listener.Addr().String()))
+	serve, shutdown := serveHTTP(&http.Server{Handler: mux}, false)
+	return newServer(ctx, listener, serve, shutdown, zapLogger), nil
 }
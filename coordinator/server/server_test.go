@@ -7,23 +7,42 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"crypto"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"sync"
 	"testing"
 
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
 	"github.com/edgelesssys/marblerun/coordinator/core"
+	"github.com/edgelesssys/marblerun/coordinator/quote/quotetest"
 	"github.com/edgelesssys/marblerun/test"
+	"github.com/edgelesssys/marblerun/util"
+	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/stats"
+	"gopkg.in/yaml.v2"
 )
 
 func TestQuote(t *testing.T) {
@@ -31,7 +50,7 @@ func TestQuote(t *testing.T) {
 
 	mux := CreateServeMux(core.NewCoreWithMocks())
 
-	req := httptest.NewRequest(http.MethodGet, "/quote", nil)
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/quote", nil)
 	resp := httptest.NewRecorder()
 	mux.ServeHTTP(resp, req)
 	assert.Equal(http.StatusOK, resp.Code)
@@ -45,25 +64,258 @@ func TestManifest(t *testing.T) {
 	mux := CreateServeMux(c)
 
 	// set manifest
-	req := httptest.NewRequest(http.MethodPost, "/manifest", strings.NewReader(test.ManifestJSON))
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/manifest", strings.NewReader(test.ManifestJSON))
 	resp := httptest.NewRecorder()
 	mux.ServeHTTP(resp, req)
 	require.Equal(http.StatusOK, resp.Code)
 
 	// get manifest signature
-	req = httptest.NewRequest(http.MethodGet, "/manifest", nil)
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/manifest", nil)
 	resp = httptest.NewRecorder()
 	mux.ServeHTTP(resp, req)
 	require.Equal(http.StatusOK, resp.Code)
 
-	sig := hex.EncodeToString(c.GetManifestSignature(context.TODO()))
-	assert.JSONEq(`{"ManifestSignature":"`+sig+`"}`, resp.Body.String())
+	signature, _ := c.GetManifestSignature(context.TODO())
+	sig := hex.EncodeToString(signature)
+	assert.JSONEq(`{"Status":"success","Data":{"ManifestSignature":"`+sig+`"}}`, resp.Body.String())
+	assert.Equal("application/json", resp.Header().Get("Content-Type"))
 
 	// try setting manifest again, should fail
-	req = httptest.NewRequest(http.MethodPost, "/manifest", strings.NewReader(test.ManifestJSON))
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/manifest", strings.NewReader(test.ManifestJSON))
 	resp = httptest.NewRecorder()
 	mux.ServeHTTP(resp, req)
 	require.Equal(http.StatusBadRequest, resp.Code)
+	var errResp clientapi.Response
+	require.NoError(json.Unmarshal(resp.Body.Bytes(), &errResp))
+	assert.Equal(clientapi.StatusError, errResp.Status)
+	assert.Nil(errResp.Data)
+	require.NotNil(errResp.Error)
+	assert.Equal(clientapi.ErrorBadRequest, errResp.Error.Code)
+	assert.NotEmpty(errResp.Error.Message)
+}
+
+func TestYAMLManifest(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var manifest interface{}
+	require.NoError(json.Unmarshal([]byte(test.ManifestJSON), &manifest))
+	yamlManifest, err := yaml.Marshal(manifest)
+	require.NoError(err)
+	canonicalManifest, err := json.Marshal(manifest)
+	require.NoError(err)
+
+	c := core.NewCoreWithMocks()
+	mux := CreateServeMux(c)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/manifest", bytes.NewReader(yamlManifest))
+	req.Header.Set("Content-Type", "application/yaml")
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	require.Equal(http.StatusOK, resp.Code, resp.Body.String())
+
+	// the manifest is hashed in its canonical JSON form
+	signature, _ := c.GetManifestSignature(context.TODO())
+	assert.Equal(clientapi.ManifestSignature(canonicalManifest, nil), signature)
+
+	c = core.NewCoreWithMocks()
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/manifest", strings.NewReader("Packages: ["))
+	req.Header.Set("Content-Type", "application/yaml")
+	resp = httptest.NewRecorder()
+	CreateServeMux(c).ServeHTTP(resp, req)
+	assert.Equal(http.StatusBadRequest, resp.Code)
+}
+
+func TestSignedManifest(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c := core.NewCoreWithMocks()
+	signerKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(err)
+	encodedKey, err := x509.MarshalPKIXPublicKey(&signerKey.PublicKey)
+	require.NoError(err)
+	require.NoError(c.SetManifestSigners(map[string]string{"release": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: encodedKey}))}))
+	mux := CreateServeMux(c)
+	setManifest := func(signature string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/manifest", strings.NewReader(test.ManifestJSON))
+		if signature != "" {
+			req.Header.Set(clientapi.ManifestSignatureHeader, signature)
+		}
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	assert.Equal(http.StatusUnauthorized, setManifest(""))
+	assert.Equal(http.StatusBadRequest, setManifest("not base64"))
+	assert.Equal(http.StatusUnauthorized, setManifest(base64.StdEncoding.EncodeToString([]byte("invalid"))))
+
+	hash := sha256.Sum256([]byte(test.ManifestJSON))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, signerKey, crypto.SHA256, hash[:])
+	require.NoError(err)
+	require.Equal(http.StatusOK, setManifest(base64.StdEncoding.EncodeToString(signature)))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/manifest", nil)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	require.Equal(http.StatusOK, resp.Code)
+	assert.JSONEq(`{"Status":"success","Data":{"ManifestSignature":"`+hex.EncodeToString(hash[:])+`","Signer":"release"}}`, resp.Body.String())
+}
+
+func TestRootCA(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	mux := CreateServeMux(core.NewCoreWithMocks())
+	setRootCA := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/root-ca", strings.NewReader(body))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+
+	assert.Equal(http.StatusBadRequest, setRootCA("invalid").Code)
+	assert.Equal(http.StatusBadRequest, setRootCA(`{"Certificate":"cert","Key":"key"}`).Code)
+
+	cert, key := quotetest.MustCreateCert(t, elliptic.P256(), "Marblerun CA", true, nil, nil)
+	rawKey, err := x509.MarshalECPrivateKey(key)
+	require.NoError(err)
+	body, err := json.Marshal(rootCAReq{string(quotetest.ToPEM(cert)), string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rawKey}))})
+	require.NoError(err)
+	resp := setRootCA(string(body))
+	require.Equal(http.StatusOK, resp.Code, resp.Body.String())
+	assert.JSONEq(`{"Status":"success","Data":null}`, resp.Body.String())
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/quote", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	require.Equal(http.StatusOK, resp.Code)
+	var certQuote certQuoteResp
+	decodeData(t, resp.Body.Bytes(), &certQuote)
+	assert.Equal(string(quotetest.ToPEM(cert)), certQuote.Cert)
+}
+
+func TestMarbles(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	mux := CreateServeMux(core.NewCoreWithMocks())
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/marbles", nil)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	require.Equal(http.StatusOK, resp.Code)
+	assert.JSONEq(`{"Status":"success","Data":{"Marbles":{}}}`, resp.Body.String())
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/manifest", strings.NewReader(test.ManifestJSON))
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	require.Equal(http.StatusOK, resp.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/marbles", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	require.Equal(http.StatusOK, resp.Code)
+	var marbles marbleStatusResp
+	decodeData(t, resp.Body.Bytes(), &marbles)
+	assert.Equal(clientapi.MarbleStatus{Activations: 0, MaxActivations: 1, Remaining: 1}, marbles.Marbles["backend_first"])
+	assert.Equal(clientapi.MarbleStatus{Activations: 0, MaxActivations: 0, Remaining: -1}, marbles.Marbles["frontend"])
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/marbles", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusMethodNotAllowed, resp.Code)
+	assert.Contains(resp.Body.String(), clientapi.ErrorMethodNotAllowed)
+
+	// the inventory requires a client certificate
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/marbles/inventory", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusUnauthorized, resp.Code)
+}
+
+func TestAuditLog(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	mux := CreateServeMux(core.NewCoreWithMocks())
+
+	var manifest core.Manifest
+	require.NoError(json.Unmarshal([]byte(test.ManifestJSON), &manifest))
+	manifest.Users = map[string]core.User{"auditor": {Certificate: test.CertPEM(test.AdminCert), Roles: []string{"auditor"}}}
+	manifest.Roles = map[string]core.Role{"auditor": {ResourceType: "AuditLog", Actions: []string{"ReadAuditLog"}}}
+	rawManifest, err := json.Marshal(manifest)
+	require.NoError(err)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/manifest", bytes.NewReader(rawManifest))
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	require.Equal(http.StatusOK, resp.Code)
+
+	// the audit log requires a client certificate
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/audit", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusUnauthorized, resp.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/audit", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{test.AdminCert}}
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	require.Equal(http.StatusOK, resp.Code)
+	var auditLog auditLogResp
+	decodeData(t, resp.Body.Bytes(), &auditLog)
+	require.Len(auditLog.Entries, 1)
+	assert.Equal(clientapi.AuditEventSetManifest, auditLog.Entries[0].Event)
+	assert.NoError(clientapi.VerifyAuditLog(auditLog.Entries))
+}
+
+func TestDashboard(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	mux := CreateServeMux(core.NewCoreWithMocks())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/manifest", strings.NewReader(test.ManifestJSON))
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	require.Equal(http.StatusOK, resp.Code)
+
+	// the dashboard requires a client certificate
+	for _, route := range []string{"/api/v1/dashboard/topology", "/api/v1/dashboard/activations", "/api/v1/dashboard/manifest", "/api/v1/dashboard/tcb"} {
+		req = httptest.NewRequest(http.MethodGet, route, nil)
+		resp = httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		assert.Equal(http.StatusUnauthorized, resp.Code, route)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/dashboard/activations?limit=-1", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusBadRequest, resp.Code)
+}
+
+func TestQuoteUserData(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	mux := CreateServeMux(core.NewCoreWithMocks())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/manifest", strings.NewReader(test.ManifestJSON))
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	require.Equal(http.StatusOK, resp.Code)
+
+	// quotes over user data require a client certificate
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/quote/userdata", strings.NewReader(`{"UserData": "a2V5"}`))
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusUnauthorized, resp.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/quote/userdata", strings.NewReader("invalid"))
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusBadRequest, resp.Code)
 }
 
 func TestManifestWithRecoveryKey(t *testing.T) {
@@ -73,15 +325,16 @@ func TestManifestWithRecoveryKey(t *testing.T) {
 	mux := CreateServeMux(c)
 
 	// set manifest
-	req := httptest.NewRequest(http.MethodPost, "/manifest", strings.NewReader(test.ManifestJSONWithRecoveryKey))
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/manifest", strings.NewReader(test.ManifestJSONWithRecoveryKey))
 	resp := httptest.NewRecorder()
 	mux.ServeHTTP(resp, req)
 	require.Equal(http.StatusOK, resp.Code)
 
 	// Decode JSON response from server
 	var b64EncryptedRecoveryData recoveryDataResp
-	require.NoError(json.Unmarshal(resp.Body.Bytes(), &b64EncryptedRecoveryData))
-	encryptedRecoveryData, err := base64.StdEncoding.DecodeString(b64EncryptedRecoveryData.EncryptionKey)
+	decodeData(t, resp.Body.Bytes(), &b64EncryptedRecoveryData)
+	require.Len(b64EncryptedRecoveryData.EncryptionKeys, 1)
+	encryptedRecoveryData, err := base64.StdEncoding.DecodeString(b64EncryptedRecoveryData.EncryptionKeys["testRecKey1"])
 	require.NoError(err)
 
 	// Decrypt recovery data and see if it matches the key used by the mock sealer
@@ -99,7 +352,7 @@ func TestConcurrent(t *testing.T) {
 	var wg sync.WaitGroup
 
 	getQuote := func() {
-		req := httptest.NewRequest(http.MethodGet, "/quote", nil)
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/quote", nil)
 		resp := httptest.NewRecorder()
 		mux.ServeHTTP(resp, req)
 		assert.Equal(http.StatusOK, resp.Code)
@@ -107,7 +360,7 @@ func TestConcurrent(t *testing.T) {
 	}
 
 	getManifest := func() {
-		req := httptest.NewRequest(http.MethodGet, "/manifest", nil)
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/manifest", nil)
 		resp := httptest.NewRecorder()
 		mux.ServeHTTP(resp, req)
 		assert.Equal(http.StatusOK, resp.Code)
@@ -115,7 +368,7 @@ func TestConcurrent(t *testing.T) {
 	}
 
 	postManifest := func() {
-		req := httptest.NewRequest(http.MethodPost, "/manifest", strings.NewReader(test.ManifestJSON))
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/manifest", strings.NewReader(test.ManifestJSON))
 		resp := httptest.NewRecorder()
 		mux.ServeHTTP(resp, req)
 		wg.Done()
@@ -130,3 +383,312 @@ func TestConcurrent(t *testing.T) {
 	go postManifest()
 	wg.Wait()
 }
+
+func TestUpdateManifest(t *testing.T) {
+	require := require.New(t)
+
+	c := core.NewCoreWithMocks()
+	mux := CreateServeMux(c)
+
+	// set manifest with admin
+	var manifest core.Manifest
+	require.NoError(json.Unmarshal([]byte(test.ManifestJSON), &manifest))
+	manifest.Users = map[string]core.User{"admin": {Certificate: test.CertPEM(test.AdminCert), Roles: []string{"updater"}}}
+	manifest.Roles = map[string]core.Role{"updater": {ResourceType: "Manifest", Actions: []string{"ProposeUpdate", "AcknowledgeUpdate", "CancelUpdate"}}}
+	rawManifest, err := json.Marshal(manifest)
+	require.NoError(err)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/manifest", bytes.NewReader(rawManifest))
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	require.Equal(http.StatusOK, resp.Code)
+
+	// update without client certificate
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/update", strings.NewReader(test.UpdateManifestJSON))
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	require.Equal(http.StatusUnauthorized, resp.Code)
+
+	// update with wrong client certificate
+	otherCert, _, _ := util.MustGenerateTestMarbleCredentials()
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/update", strings.NewReader(test.UpdateManifestJSON))
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{otherCert}}
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	require.Equal(http.StatusUnauthorized, resp.Code)
+
+	// update with admin certificate
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/update", strings.NewReader(test.UpdateManifestJSON))
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{test.AdminCert}}
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	require.Equal(http.StatusOK, resp.Code)
+	require.JSONEq(`{"Status":"success","Data":{"Remaining":0}}`, resp.Body.String())
+
+	// no update is pending anymore
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/update", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{test.AdminCert}}
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	require.Equal(http.StatusOK, resp.Code)
+	require.JSONEq(`{"Status":"success","Data":{"Update":"","AcknowledgedBy":null,"Remaining":0}}`, resp.Body.String())
+
+	// invalid update
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/update", strings.NewReader(`{"Marbles": {"newmarble": {"Package": "newpackage"}}}`))
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{test.AdminCert}}
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	require.Equal(http.StatusBadRequest, resp.Code)
+}
+
+func TestSecrets(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c := core.NewCoreWithMocks()
+	mux := CreateServeMux(c)
+
+	var manifest core.Manifest
+	require.NoError(json.Unmarshal([]byte(test.ManifestJSON), &manifest))
+	manifest.Secrets["api_token"] = core.Secret{Type: "plain", UserDefined: true}
+	manifest.Users = map[string]core.User{
+		"admin":  {Certificate: test.CertPEM(test.AdminCert), Roles: []string{"tokens"}},
+		"admin2": {Certificate: test.CertPEM(test.SecondAdminCert), Roles: []string{"rotator"}},
+	}
+	manifest.Roles = map[string]core.Role{
+		"tokens":  {ResourceType: "Secrets", ResourceNames: []string{"api_token"}, Actions: []string{"ReadSecret", "WriteSecret"}},
+		"rotator": {ResourceType: "Secrets", Actions: []string{"RotateSecret"}},
+	}
+	rawManifest, err := json.Marshal(manifest)
+	require.NoError(err)
+	_, err = c.SetManifest(context.TODO(), rawManifest, nil)
+	require.NoError(err)
+
+	request := func(method, target, body string, clientCert *x509.Certificate) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if clientCert != nil {
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{clientCert}}
+		}
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := request(http.MethodPost, "/api/v1/secrets", `{"api_token": {"Private": "dG9rZW4="}}`, nil)
+	assert.Equal(http.StatusUnauthorized, resp.Code)
+	resp = request(http.MethodPost, "/api/v1/secrets", `{"api_token": `, test.AdminCert)
+	assert.Equal(http.StatusBadRequest, resp.Code)
+	resp = request(http.MethodPost, "/api/v1/secrets", `{"api_token": {"Private": "dG9rZW4="}}`, test.AdminCert)
+	require.Equal(http.StatusOK, resp.Code, resp.Body.String())
+
+	resp = request(http.MethodGet, "/api/v1/secrets", "", test.AdminCert)
+	assert.Equal(http.StatusBadRequest, resp.Code)
+	resp = request(http.MethodGet, "/api/v1/secrets?s=api_token", "", test.AdminCert)
+	require.Equal(http.StatusOK, resp.Code)
+	assert.JSONEq(`{"Status":"success","Data":{"Secrets":{"api_token":{"Type":"plain","Private":"dG9rZW4=","Public":"dG9rZW4=","Version":1}}}}`, resp.Body.String())
+
+	resp = request(http.MethodPost, "/api/v1/secrets/rotate", `[]`, test.AdminCert)
+	assert.Equal(http.StatusBadRequest, resp.Code)
+	resp = request(http.MethodPost, "/api/v1/secrets/rotate", `["symmetric_key_shared"]`, test.AdminCert)
+	assert.Equal(http.StatusUnauthorized, resp.Code)
+	resp = request(http.MethodPost, "/api/v1/secrets/rotate", `["symmetric_key_shared"]`, test.SecondAdminCert)
+	require.Equal(http.StatusOK, resp.Code, resp.Body.String())
+	assert.JSONEq(`{"Status":"success","Data":{"Versions":{"symmetric_key_shared":2}}}`, resp.Body.String())
+}
+
+func TestRevoke(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	mux := CreateServeMux(core.NewCoreWithMocks())
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// no manifest has been set yet
+	assert.Equal(http.StatusBadRequest, serve(http.MethodGet, "/api/v1/crl?package=frontend", "").Code)
+	require.Equal(http.StatusOK, serve(http.MethodPost, "/api/v1/manifest", test.ManifestJSON).Code)
+
+	resp := serve(http.MethodGet, "/api/v1/crl?package=frontend", "")
+	assert.Equal(http.StatusNotFound, resp.Code)
+	assert.Contains(resp.Body.String(), clientapi.ErrorNotFound)
+	assert.Equal(http.StatusMethodNotAllowed, serve(http.MethodPost, "/api/v1/crl", "").Code)
+
+	assert.Equal(http.StatusBadRequest, serve(http.MethodPost, "/api/v1/revoke", "invalid").Code)
+	resp = serve(http.MethodPost, "/api/v1/revoke", `{"MarbleType":"frontend"}`)
+	assert.Equal(http.StatusUnauthorized, resp.Code)
+}
+
+func TestCertificates(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	mux := CreateServeMux(core.NewCoreWithMocks())
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+	require.Equal(http.StatusOK, serve(http.MethodPost, "/api/v1/manifest", test.ManifestJSON).Code)
+
+	assert.Equal(http.StatusBadRequest, serve(http.MethodPost, "/api/v1/certificates", "invalid").Code)
+	assert.Equal(http.StatusMethodNotAllowed, serve(http.MethodGet, "/api/v1/certificates", "").Code)
+	// the request is not authenticated as a User
+	assert.Equal(http.StatusUnauthorized, serve(http.MethodPost, "/api/v1/certificates", `{"Service":"web","CSR":""}`).Code)
+}
+
+func TestContentNegotiation(t *testing.T) {
+	assert := assert.New(t)
+
+	mux := CreateServeMux(core.NewCoreWithMocks())
+
+	for _, accept := range []string{"", "application/json", "*/*", "text/html, application/json;q=0.9", "application/*"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/status", nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		assert.Equal(http.StatusOK, resp.Code, accept)
+		assert.Equal("application/json", resp.Header().Get("Content-Type"), accept)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/status", nil)
+	req.Header.Set("Accept", "text/html")
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusNotAcceptable, resp.Code)
+	assert.JSONEq(`{"Status":"error","Data":null,"Error":{"Code":"NotAcceptable","Message":"responses are only available as application/json"}}`, resp.Body.String())
+}
+
+func TestUnknownRoute(t *testing.T) {
+	assert := assert.New(t)
+
+	mux := CreateServeMux(core.NewCoreWithMocks())
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/unknown", nil)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusNotFound, resp.Code)
+	assert.JSONEq(`{"Status":"error","Data":null,"Error":{"Code":"NotFound","Message":"unknown route /api/v1/unknown"}}`, resp.Body.String())
+
+	// unversioned routes are not served anymore
+	req = httptest.NewRequest(http.MethodGet, "/status", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusNotFound, resp.Code)
+}
+
+func TestProbes(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c := core.NewCoreWithMocks()
+	mux := CreateServeMux(c)
+	probe := func(method string, path string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, httptest.NewRequest(method, path, nil))
+		return resp
+	}
+
+	// the Coordinator is live, but not ready until its manifest is set
+	assert.Equal(http.StatusOK, probe(http.MethodGet, "/healthz").Code)
+	resp := probe(http.MethodGet, "/readyz")
+	assert.Equal(http.StatusServiceUnavailable, resp.Code)
+	assert.Contains(resp.Body.String(), "ready to accept a manifest")
+
+	_, err := c.SetManifest(context.TODO(), []byte(test.ManifestJSON), nil)
+	require.NoError(err)
+	assert.Equal(http.StatusOK, probe(http.MethodGet, "/healthz").Code)
+	resp = probe(http.MethodGet, "/readyz")
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal("text/plain; charset=utf-8", resp.Header().Get("Content-Type"))
+	assert.Equal(http.StatusOK, probe(http.MethodHead, "/readyz").Code)
+	assert.Equal(http.StatusMethodNotAllowed, probe(http.MethodPost, "/readyz").Code)
+}
+
+func TestMarbleHealth(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c := core.NewCoreWithMocks()
+	healthServer := newMarbleHealthServer(c)
+	check := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := healthServer.Check(context.TODO(), &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(err)
+		return resp.Status
+	}
+
+	// the server is serving, but the Marble service only once the manifest is set
+	assert.Equal(healthpb.HealthCheckResponse_SERVING, check(""))
+	assert.Equal(healthpb.HealthCheckResponse_NOT_SERVING, check(marbleServiceName))
+	_, err := c.SetManifest(context.TODO(), []byte(test.ManifestJSON), nil)
+	require.NoError(err)
+	assert.Equal(healthpb.HealthCheckResponse_SERVING, check(marbleServiceName))
+
+	// health checks are only logged if they fail
+	assert.False(logDecider("/grpc.health.v1.Health/Check", nil))
+	assert.True(logDecider("/grpc.health.v1.Health/Check", errors.New("failed")))
+	assert.True(logDecider("/rpc.Marble/Activate", nil))
+}
+
+// decodeData decodes the data of a successful client API response into v.
+func decodeData(t *testing.T, body []byte, v interface{}) {
+	resp := clientapi.Response{Data: v}
+	require.NoError(t, json.Unmarshal(body, &resp))
+	require.Equal(t, clientapi.StatusSuccess, resp.Status)
+}
+
+func TestConnectionCounter(t *testing.T) {
+	assert := assert.New(t)
+
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test"})
+	counter := connectionCounter{gauge: gauge}
+	counter.HandleConn(context.TODO(), &stats.ConnBegin{})
+	counter.HandleConn(context.TODO(), &stats.ConnBegin{})
+	assert.EqualValues(2, testutil.ToFloat64(gauge))
+	counter.HandleConn(context.TODO(), &stats.ConnEnd{})
+	assert.EqualValues(1, testutil.ToFloat64(gauge))
+}
+
+func TestLogRequests(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	core, logs := observer.New(zap.InfoLevel)
+	var requestID string
+	handler := chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID = util.RequestID(r.Context())
+		w.WriteHeader(http.StatusTeapot)
+	}), assignRequestIDs, logRequests(zap.New(core)))
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+	// the request ID is passed to the handler, returned to the client and logged
+	assert.NotEmpty(requestID)
+	assert.Equal(requestID, resp.Header().Get(requestIDHeader))
+	require.Equal(1, logs.Len())
+	fields := logs.All()[0].ContextMap()
+	assert.Equal(requestID, fields["request_id"])
+	assert.Equal("/status", fields["path"])
+	assert.EqualValues(http.StatusTeapot, fields["status"])
+}
+
+func TestRequestIDUnaryInterceptor(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	ctx := grpc_ctxtags.SetInContext(context.Background(), grpc_ctxtags.NewTags())
+	var requestID string
+	_, err := requestIDUnaryInterceptor(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		requestID = util.RequestID(ctx)
+		return nil, nil
+	})
+	require.NoError(err)
+	assert.NotEmpty(requestID)
+	assert.Equal(requestID, grpc_ctxtags.Extract(ctx).Values()["request_id"])
+}
@@ -0,0 +1,60 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"time"
+
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/propagators"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/credentials"
+)
+
+// SetupTracing exports the traces of the Coordinator to the OpenTelemetry collector at address via OTLP.
+//
+// The connection to the collector uses TLS unless insecure is set.
+// Requests are traced if the caller's trace is sampled or if they don't belong to a trace.
+// The returned function flushes the remaining spans and must be called before the Coordinator exits.
+func SetupTracing(address string, insecure bool, zapLogger *zap.Logger) (func(), error) {
+	options := []otlp.ExporterOption{otlp.WithAddress(address)}
+	if insecure {
+		options = append(options, otlp.WithInsecure())
+	} else {
+		options = append(options, otlp.WithTLSCredentials(credentials.NewTLS(&tls.Config{})))
+	}
+	exporter, err := otlp.NewExporter(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	processor := sdktrace.NewBatchSpanProcessor(exporter)
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithConfig(sdktrace.Config{DefaultSampler: sdktrace.ParentBased(sdktrace.AlwaysSample())}),
+		sdktrace.WithResource(resource.New(semconv.ServiceNameKey.String("marblerun-coordinator"))),
+		sdktrace.WithSpanProcessor(processor),
+	)
+	global.SetTracerProvider(provider)
+	global.SetTextMapPropagator(propagators.TraceContext{})
+	zapLogger.Info("exporting traces", zap.String("address", address))
+
+	return func() {
+		// flushes the queued spans
+		processor.Shutdown()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := exporter.Shutdown(ctx); err != nil {
+			zapLogger.Warn("failed to shut down trace exporter", zap.Error(err))
+		}
+	}, nil
+}
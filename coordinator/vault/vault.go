@@ -0,0 +1,186 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package vault implements a secrets backend of the Coordinator that fetches secrets from HashiCorp Vault.
+//
+// The Coordinator authenticates with the TLS certificate auth method of Vault. Its client certificate is signed by the Coordinator's root certificate,
+// so Vault is configured to trust the root certificate after the Coordinator has been attested, and the Coordinator needs no further credentials.
+package vault
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenRenewalMargin is the time before the expiry of the Vault token at which the Coordinator logs in again.
+const tokenRenewalMargin = 30 * time.Second
+
+// Vault fetches secrets from the KV secrets engine (version 1 or 2) of HashiCorp Vault.
+type Vault struct {
+	addr      string
+	authMount string
+	role      string
+	client    *http.Client
+
+	mux         sync.Mutex
+	token       string
+	tokenExpiry time.Time
+	now         func() time.Time
+}
+
+type loginResp struct {
+	Auth struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int64  `json:"lease_duration"`
+	} `json:"auth"`
+}
+
+type secretResp struct {
+	Data map[string]interface{} `json:"data"`
+}
+
+type errorResp struct {
+	Errors []string `json:"errors"`
+}
+
+// New creates a backend for the Vault server at addr, e.g., https://vault.example.com:8200.
+//
+// authMount is the path at which the TLS certificate auth method is mounted, "cert" by default. role is the name of the certificate role to log in with; if it is empty, Vault tries all roles.
+// rootCAs verify Vault's certificate; the system roots are used if it is nil. getClientCertificate returns the Coordinator's client certificate, see core.Core.GetClientCertificate.
+func New(addr string, authMount string, role string, rootCAs *x509.CertPool, getClientCertificate func(*tls.CertificateRequestInfo) (*tls.Certificate, error)) *Vault {
+	if authMount == "" {
+		authMount = "cert"
+	}
+	return &Vault{
+		addr:      strings.TrimSuffix(addr, "/"),
+		authMount: strings.Trim(authMount, "/"),
+		role:      role,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: rootCAs, GetClientCertificate: getClientCertificate},
+			},
+		},
+		now: time.Now,
+	}
+}
+
+// FetchSecret implements the core.SecretsBackend interface.
+//
+// The secret at path must contain a string value for key. Secrets of the KV secrets engine version 2 are read from their data, e.g., at the path "secret/data/myapp".
+func (v *Vault) FetchSecret(ctx context.Context, path string, key string) (string, error) {
+	token, err := v.getToken(ctx, false)
+	if err != nil {
+		return "", err
+	}
+	var resp secretResp
+	status, err := v.do(ctx, http.MethodGet, "/v1/"+strings.TrimPrefix(path, "/"), token, nil, &resp)
+	if status == http.StatusForbidden {
+		// the token may have been revoked
+		if token, err = v.getToken(ctx, true); err != nil {
+			return "", err
+		}
+		_, err = v.do(ctx, http.MethodGet, "/v1/"+strings.TrimPrefix(path, "/"), token, nil, &resp)
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot read secret %s: %v", path, err)
+	}
+
+	data := resp.Data
+	// KV version 2 wraps the data together with its metadata
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+	raw, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no key %s", path, key)
+	}
+	value, ok := raw.(string)
+	if !ok {
+		return "", fmt.Errorf("value of key %s of secret %s is not a string", key, path)
+	}
+	return value, nil
+}
+
+// getToken returns the current Vault token and logs in if there is none, it is about to expire, or renew is set.
+func (v *Vault) getToken(ctx context.Context, renew bool) (string, error) {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	if !renew && v.token != "" && (v.tokenExpiry.IsZero() || v.now().Before(v.tokenExpiry)) {
+		return v.token, nil
+	}
+
+	req := map[string]string{}
+	if v.role != "" {
+		req["name"] = v.role
+	}
+	var resp loginResp
+	if _, err := v.do(ctx, http.MethodPost, "/v1/auth/"+v.authMount+"/login", "", req, &resp); err != nil {
+		return "", fmt.Errorf("cannot log in to Vault: %v", err)
+	}
+	if resp.Auth.ClientToken == "" {
+		return "", errors.New("cannot log in to Vault: no token received")
+	}
+	v.token = resp.Auth.ClientToken
+	v.tokenExpiry = time.Time{}
+	// a lease duration of 0 means that the token doesn't expire
+	if resp.Auth.LeaseDuration > 0 {
+		v.tokenExpiry = v.now().Add(time.Duration(resp.Auth.LeaseDuration)*time.Second - tokenRenewalMargin)
+	}
+	return v.token, nil
+}
+
+// do sends a request with an optional JSON body to Vault and decodes the JSON response into resp. It returns the status code of the response.
+func (v *Vault) do(ctx context.Context, method string, path string, token string, req interface{}, resp interface{}) (int, error) {
+	var body []byte
+	if req != nil {
+		var err error
+		if body, err = json.Marshal(req); err != nil {
+			return 0, err
+		}
+	}
+	httpReq, err := http.NewRequest(method, v.addr+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	httpReq = httpReq.WithContext(ctx)
+	if req != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		httpReq.Header.Set("X-Vault-Token", token)
+	}
+
+	httpResp, err := v.client.Do(httpReq)
+	if err != nil {
+		return 0, err
+	}
+	defer httpResp.Body.Close()
+	respBody, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return httpResp.StatusCode, err
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		var vaultErr errorResp
+		if json.Unmarshal(respBody, &vaultErr) == nil && len(vaultErr.Errors) > 0 {
+			return httpResp.StatusCode, fmt.Errorf("%v: %v", httpResp.Status, strings.Join(vaultErr.Errors, ", "))
+		}
+		return httpResp.StatusCode, fmt.Errorf("%v: %s", httpResp.Status, bytes.TrimSpace(respBody))
+	}
+	return httpResp.StatusCode, json.Unmarshal(respBody, resp)
+}
@@ -0,0 +1,98 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package vault
+
+import (
+	"context"
+	"crypto/elliptic"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote/quotetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVault(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	clientCert, clientKey := quotetest.MustCreateCert(t, elliptic.P256(), "Coordinator", false, nil, nil)
+	logins := 0
+	token := "token1"
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Len(r.TLS.PeerCertificates, 1)
+		assert.Equal(clientCert.Raw, r.TLS.PeerCertificates[0].Raw)
+		switch r.URL.Path {
+		case "/v1/auth/cert/login":
+			var req map[string]string
+			require.NoError(json.NewDecoder(r.Body).Decode(&req))
+			assert.Equal("marblerun", req["name"])
+			logins++
+			w.Write([]byte(`{"auth": {"client_token": "` + token + `", "lease_duration": 3600}}`))
+		case "/v1/secret/data/app":
+			if r.Header.Get("X-Vault-Token") != token {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"errors": ["permission denied"]}`))
+				return
+			}
+			w.Write([]byte(`{"data": {"data": {"password": "secret", "port": 8080}, "metadata": {"version": 1}}}`))
+		case "/v1/kv/app":
+			w.Write([]byte(`{"data": {"password": "kv1"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors": []}`))
+		}
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(server.Certificate())
+	getClientCertificate := func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return &tls.Certificate{Certificate: [][]byte{clientCert.Raw}, PrivateKey: clientKey}, nil
+	}
+	v := New(server.URL, "", "marblerun", rootCAs, getClientCertificate)
+	now := time.Now()
+	v.now = func() time.Time { return now }
+
+	// KV version 2
+	value, err := v.FetchSecret(context.Background(), "secret/data/app", "password")
+	require.NoError(err)
+	assert.Equal("secret", value)
+	// KV version 1
+	value, err = v.FetchSecret(context.Background(), "kv/app", "password")
+	require.NoError(err)
+	assert.Equal("kv1", value)
+	assert.Equal(1, logins)
+
+	_, err = v.FetchSecret(context.Background(), "secret/data/app", "missing")
+	assert.Error(err)
+	_, err = v.FetchSecret(context.Background(), "secret/data/app", "port")
+	assert.Error(err)
+	_, err = v.FetchSecret(context.Background(), "secret/data/missing", "password")
+	assert.Error(err)
+
+	// a revoked token is replaced
+	token = "token2"
+	value, err = v.FetchSecret(context.Background(), "secret/data/app", "password")
+	require.NoError(err)
+	assert.Equal("secret", value)
+	assert.Equal(2, logins)
+
+	// an expiring token is replaced
+	now = now.Add(time.Hour)
+	_, err = v.FetchSecret(context.Background(), "kv/app", "password")
+	require.NoError(err)
+	assert.Equal(3, logins)
+}
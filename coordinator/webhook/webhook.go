@@ -0,0 +1,156 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package webhook posts the Coordinator's notifications to HTTP webhooks, e.g., to alert an on-call rotation.
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/core"
+	"go.uber.org/zap"
+)
+
+// Config configures a webhook.
+type Config struct {
+	// URL receives the notifications as POST requests with a core.Notification in JSON format
+	URL string
+	// AuthHeader is sent as value of the Authorization header, if set
+	AuthHeader string
+	// Events restricts the webhook to the given core.Notification* events. If it is empty, the webhook receives all events.
+	Events []string
+}
+
+// queueSize is the number of notifications that are buffered while the webhook is slow or unreachable.
+const queueSize = 64
+
+// maxAttempts is the number of times a notification is sent before it is dropped.
+const maxAttempts = 3
+
+// retryInterval is the time between two attempts to send a notification.
+var retryInterval = 2 * time.Second
+
+var knownEvents = []string{core.NotificationActivationFailures, core.NotificationManifestChanged, core.NotificationRecoveryMode}
+
+// ParseConfig parses a list of webhook configurations in JSON format.
+func ParseConfig(rawConfig []byte) ([]Config, error) {
+	var configs []Config
+	if err := json.Unmarshal(rawConfig, &configs); err != nil {
+		return nil, fmt.Errorf("invalid webhook configuration: %v", err)
+	}
+	return configs, nil
+}
+
+// Webhook posts notifications to a URL in the background. It implements the core.Notifier interface.
+type Webhook struct {
+	config Config
+	client *http.Client
+	logger *zap.Logger
+
+	notifications chan core.Notification
+	done          chan struct{}
+}
+
+// New creates a webhook with the given configuration.
+func New(config Config, logger *zap.Logger) (*Webhook, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook URL: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme of webhook URL: %q, expected http or https", u.Scheme)
+	}
+	for _, event := range config.Events {
+		if !contains(knownEvents, event) {
+			return nil, fmt.Errorf("unknown webhook event: %q", event)
+		}
+	}
+
+	w := &Webhook{
+		config:        config,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		logger:        logger.With(zap.String("webhook", u.Host)),
+		notifications: make(chan core.Notification, queueSize),
+		done:          make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+// Notify queues the notification for sending if the webhook is configured for its event. It does not block.
+func (w *Webhook) Notify(notification core.Notification) {
+	if len(w.config.Events) > 0 && !contains(w.config.Events, notification.Event) {
+		return
+	}
+	select {
+	case w.notifications <- notification:
+	default:
+		w.logger.Warn("webhook queue is full, dropping notification", zap.String("event", notification.Event))
+	}
+}
+
+// Close sends the queued notifications and stops the webhook.
+//
+// Notify must not be called after Close.
+func (w *Webhook) Close() {
+	close(w.notifications)
+	<-w.done
+}
+
+func (w *Webhook) run() {
+	defer close(w.done)
+	for notification := range w.notifications {
+		body, err := json.Marshal(notification)
+		if err != nil {
+			w.logger.Error("failed to encode notification", zap.Error(err))
+			continue
+		}
+		for attempt := 1; ; attempt++ {
+			err = w.post(body)
+			if err == nil || attempt == maxAttempts {
+				break
+			}
+			time.Sleep(retryInterval)
+		}
+		if err != nil {
+			w.logger.Error("failed to send notification", zap.String("event", notification.Event), zap.Error(err))
+		}
+	}
+}
+
+func (w *Webhook) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.config.AuthHeader != "" {
+		req.Header.Set("Authorization", w.config.AuthHeader)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %v", resp.Status)
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
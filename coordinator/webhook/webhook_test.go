@@ -0,0 +1,89 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestParseConfig(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	configs, err := ParseConfig([]byte(`[{"URL": "https://alerts.example.com", "AuthHeader": "Bearer token", "Events": ["RecoveryMode"]}]`))
+	require.NoError(err)
+	assert.Equal([]Config{{URL: "https://alerts.example.com", AuthHeader: "Bearer token", Events: []string{core.NotificationRecoveryMode}}}, configs)
+
+	_, err = ParseConfig([]byte(`{"URL": "https://alerts.example.com"}`))
+	assert.Error(err)
+}
+
+func TestNew(t *testing.T) {
+	assert := assert.New(t)
+
+	invalid := []Config{
+		{URL: "alerts.example.com"},
+		{URL: "ftp://alerts.example.com"},
+		{URL: "https://alerts.example.com", Events: []string{"Unknown"}},
+	}
+	for _, config := range invalid {
+		_, err := New(config, zap.NewNop())
+		assert.Error(err, config)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	defer func(interval time.Duration) { retryInterval = interval }(retryInterval)
+	retryInterval = time.Millisecond
+	var mux sync.Mutex
+	var received []core.Notification
+	failures := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(http.MethodPost, r.Method)
+		assert.Equal("Bearer token", r.Header.Get("Authorization"))
+		assert.Equal("application/json", r.Header.Get("Content-Type"))
+		mux.Lock()
+		defer mux.Unlock()
+		// the first attempt fails and is retried
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var notification core.Notification
+		assert.NoError(json.NewDecoder(r.Body).Decode(&notification))
+		received = append(received, notification)
+	}))
+	defer server.Close()
+
+	w, err := New(Config{URL: server.URL, AuthHeader: "Bearer token", Events: []string{core.NotificationManifestChanged}}, zap.NewNop())
+	require.NoError(err)
+	w.Notify(core.Notification{Event: core.NotificationRecoveryMode})
+	w.Notify(core.Notification{Event: core.NotificationManifestChanged, Message: "changed", Details: map[string]string{"ManifestSignature": "abcd"}})
+	w.Close()
+
+	mux.Lock()
+	defer mux.Unlock()
+	// only the configured events are sent
+	require.Len(received, 1)
+	assert.Equal(core.NotificationManifestChanged, received[0].Event)
+	assert.Equal("changed", received[0].Message)
+	assert.Equal("abcd", received[0].Details["ManifestSignature"])
+}
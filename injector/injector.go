@@ -0,0 +1,236 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package injector implements a Kubernetes mutating admission webhook that prepares pods to run as Marbles.
+//
+// Pods with the MarbleTypeLabel get the environment variables of the premain, a volume for the Marble's UUID file
+// and the SGX resources they need, so that users don't have to edit their pod specs manually.
+// Settings that are already present in a pod spec are kept.
+package injector
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/edgelesssys/marblerun/marble/config"
+	"go.uber.org/zap"
+)
+
+// MarbleTypeLabel is the label of pods that run as Marbles. Its value is the Marble's type in the manifest.
+const MarbleTypeLabel = "marblerun/marbletype"
+
+// uuidVolume is the name of the volume that holds the Marble's UUID file.
+const uuidVolume = "marblerun-uuid"
+
+// uuidDir is the path at which the UUID volume is mounted.
+const uuidDir = "/var/run/marblerun"
+
+// DefaultSGXResources are the resources of the Intel SGX device plugin that are requested for each container.
+var DefaultSGXResources = map[string]string{
+	"sgx.intel.com/epc":       "10Mi",
+	"sgx.intel.com/enclave":   "1",
+	"sgx.intel.com/provision": "1",
+}
+
+// Injector mutates the specs of Marble pods.
+type Injector struct {
+	// CoordinatorAddr is the address of the Coordinator's marble API, e.g., coordinator-mesh-api.marblerun:2001
+	CoordinatorAddr string
+	// SGXResources are the resource limits that are set for each container, see DefaultSGXResources
+	SGXResources map[string]string
+	// DNSDomain is the domain of the cluster, which is used for the DNS names of the Marbles' certificates. Defaults to cluster.local.
+	DNSDomain string
+}
+
+// ParseResources parses comma-separated resources like "sgx.intel.com/epc=10Mi,sgx.intel.com/enclave=1".
+func ParseResources(resources string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, resource := range strings.Split(resources, ",") {
+		parts := strings.SplitN(strings.TrimSpace(resource), "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid resource: %v", resource)
+		}
+		result[parts[0]] = parts[1]
+	}
+	return result, nil
+}
+
+type admissionReview struct {
+	APIVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Request    *admissionRequest  `json:"request,omitempty"`
+	Response   *admissionResponse `json:"response,omitempty"`
+}
+
+type admissionRequest struct {
+	UID       string          `json:"uid"`
+	Namespace string          `json:"namespace"`
+	Object    json.RawMessage `json:"object"`
+}
+
+type admissionResponse struct {
+	UID       string           `json:"uid"`
+	Allowed   bool             `json:"allowed"`
+	Patch     []byte           `json:"patch,omitempty"`
+	PatchType string           `json:"patchType,omitempty"`
+	Result    *admissionStatus `json:"status,omitempty"`
+}
+
+type admissionStatus struct {
+	Message string `json:"message"`
+}
+
+type pod struct {
+	Metadata struct {
+		Namespace string            `json:"namespace"`
+		Labels    map[string]string `json:"labels"`
+	} `json:"metadata"`
+	Spec struct {
+		Containers []container        `json:"containers"`
+		Volumes    []*json.RawMessage `json:"volumes"`
+	} `json:"spec"`
+}
+
+type container struct {
+	Env          []envVar           `json:"env"`
+	VolumeMounts []*json.RawMessage `json:"volumeMounts"`
+	Resources    *struct {
+		Limits map[string]string `json:"limits"`
+	} `json:"resources"`
+}
+
+type envVar struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// Handler returns the handler of the webhook, which responds to AdmissionReview requests of pods.
+//
+// Pods that are not labeled as Marbles are admitted unchanged.
+func (i *Injector) Handler(zapLogger *zap.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var review admissionReview
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil || review.Request == nil {
+			http.Error(w, "invalid AdmissionReview", http.StatusBadRequest)
+			return
+		}
+
+		response := &admissionResponse{UID: review.Request.UID, Allowed: true}
+		patch, err := i.patch(review.Request)
+		if err != nil {
+			// the pod would not be able to run as Marble
+			zapLogger.Warn("rejected pod", zap.String("namespace", review.Request.Namespace), zap.Error(err))
+			response.Allowed = false
+			response.Result = &admissionStatus{Message: err.Error()}
+		} else if len(patch) > 0 {
+			if response.Patch, err = json.Marshal(patch); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			response.PatchType = "JSONPatch"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(admissionReview{APIVersion: review.APIVersion, Kind: review.Kind, Response: response})
+	})
+}
+
+// patch returns the JSON patch that prepares the pod of the request to run as Marble.
+func (i *Injector) patch(req *admissionRequest) ([]patchOperation, error) {
+	var p pod
+	if err := json.Unmarshal(req.Object, &p); err != nil {
+		return nil, fmt.Errorf("invalid pod: %v", err)
+	}
+	marbleType, ok := p.Metadata.Labels[MarbleTypeLabel]
+	if !ok {
+		return nil, nil
+	}
+	if marbleType == "" {
+		return nil, fmt.Errorf("label %v must not be empty", MarbleTypeLabel)
+	}
+	// the namespace of the object is empty if the pod is created by a controller
+	namespace := p.Metadata.Namespace
+	if namespace == "" {
+		namespace = req.Namespace
+	}
+	dnsDomain := i.DNSDomain
+	if dnsDomain == "" {
+		dnsDomain = "cluster.local"
+	}
+
+	env := []envVar{
+		{config.CoordinatorAddr, i.CoordinatorAddr},
+		{config.Type, marbleType},
+		{config.DNSNames, strings.Join([]string{marbleType, marbleType + "." + namespace, marbleType + "." + namespace + ".svc." + dnsDomain}, ",")},
+		{config.UUIDFile, uuidDir + "/uuid"},
+	}
+	resourceNames := make([]string, 0, len(i.SGXResources))
+	for name := range i.SGXResources {
+		resourceNames = append(resourceNames, name)
+	}
+	sort.Strings(resourceNames)
+
+	var ops []patchOperation
+	if p.Spec.Volumes == nil {
+		ops = append(ops, patchOperation{"add", "/spec/volumes", []interface{}{}})
+	}
+	ops = append(ops, patchOperation{"add", "/spec/volumes/-", map[string]interface{}{"name": uuidVolume, "emptyDir": map[string]interface{}{}}})
+
+	for idx, c := range p.Spec.Containers {
+		path := fmt.Sprintf("/spec/containers/%d", idx)
+
+		if c.Env == nil {
+			ops = append(ops, patchOperation{"add", path + "/env", []interface{}{}})
+		}
+		for _, v := range env {
+			if !hasEnv(c.Env, v.Name) {
+				ops = append(ops, patchOperation{"add", path + "/env/-", v})
+			}
+		}
+
+		if c.VolumeMounts == nil {
+			ops = append(ops, patchOperation{"add", path + "/volumeMounts", []interface{}{}})
+		}
+		ops = append(ops, patchOperation{"add", path + "/volumeMounts/-", map[string]string{"name": uuidVolume, "mountPath": uuidDir}})
+
+		if c.Resources == nil {
+			ops = append(ops, patchOperation{"add", path + "/resources", map[string]interface{}{}})
+		}
+		if c.Resources == nil || c.Resources.Limits == nil {
+			ops = append(ops, patchOperation{"add", path + "/resources/limits", map[string]interface{}{}})
+		}
+		for _, name := range resourceNames {
+			if c.Resources != nil && c.Resources.Limits[name] != "" {
+				continue
+			}
+			ops = append(ops, patchOperation{"add", path + "/resources/limits/" + escapeJSONPointer(name), i.SGXResources[name]})
+		}
+	}
+	return ops, nil
+}
+
+func hasEnv(env []envVar, name string) bool {
+	for _, v := range env {
+		if v.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// escapeJSONPointer escapes a token of a JSON pointer as defined in RFC 6901, e.g., a resource name like sgx.intel.com/epc.
+func escapeJSONPointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,104 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package injector
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const marblePod = `{
+	"metadata": {"namespace": "emojivoto", "labels": {"marblerun/marbletype": "web"}},
+	"spec": {"containers": [
+		{"name": "web", "env": [{"name": "EDG_MARBLE_TYPE", "value": "custom"}], "resources": {"limits": {"sgx.intel.com/epc": "20Mi"}}},
+		{"name": "sidecar"}
+	]}
+}`
+
+func review(t *testing.T, handler http.Handler, pod string) *admissionResponse {
+	body, err := json.Marshal(admissionReview{
+		APIVersion: "admission.k8s.io/v1",
+		Kind:       "AdmissionReview",
+		Request:    &admissionRequest{UID: "uid", Namespace: "emojivoto", Object: json.RawMessage(pod)},
+	})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var result admissionReview
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, "admission.k8s.io/v1", result.APIVersion)
+	require.NotNil(t, result.Response)
+	assert.Equal(t, "uid", result.Response.UID)
+	return result.Response
+}
+
+func TestHandler(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	inj := &Injector{CoordinatorAddr: "coordinator-mesh-api.marblerun:2001", SGXResources: DefaultSGXResources}
+	handler := inj.Handler(zap.NewNop())
+
+	response := review(t, handler, marblePod)
+	assert.True(response.Allowed)
+	assert.Equal("JSONPatch", response.PatchType)
+	var patch []patchOperation
+	require.NoError(json.Unmarshal(response.Patch, &patch))
+
+	paths := make(map[string][]interface{})
+	for _, op := range patch {
+		assert.Equal("add", op.Op)
+		paths[op.Path] = append(paths[op.Path], op.Value)
+	}
+	assert.Len(paths["/spec/volumes"], 1)
+	assert.Len(paths["/spec/volumes/-"], 1)
+
+	// existing settings are kept
+	assert.NotContains(paths, "/spec/containers/0/env")
+	assert.Len(paths["/spec/containers/0/env/-"], 3)
+	assert.Contains(paths["/spec/containers/0/env/-"], map[string]interface{}{"name": "EDG_MARBLE_DNS_NAMES", "value": "web,web.emojivoto,web.emojivoto.svc.cluster.local"})
+	assert.NotContains(paths, "/spec/containers/0/resources/limits/sgx.intel.com~1epc")
+	assert.Equal([]interface{}{"1"}, paths["/spec/containers/0/resources/limits/sgx.intel.com~1enclave"])
+
+	// all containers are prepared
+	assert.Len(paths["/spec/containers/1/env/-"], 4)
+	assert.Contains(paths["/spec/containers/1/env/-"], map[string]interface{}{"name": "EDG_MARBLE_TYPE", "value": "web"})
+	assert.Len(paths["/spec/containers/1/volumeMounts/-"], 1)
+	assert.Len(paths["/spec/containers/1/resources"], 1)
+	assert.Equal([]interface{}{"10Mi"}, paths["/spec/containers/1/resources/limits/sgx.intel.com~1epc"])
+
+	// other pods are not changed
+	response = review(t, handler, `{"metadata": {"labels": {"app": "web"}}, "spec": {"containers": [{"name": "web"}]}}`)
+	assert.True(response.Allowed)
+	assert.Empty(response.Patch)
+
+	// an empty Marble type is rejected
+	response = review(t, handler, `{"metadata": {"labels": {"marblerun/marbletype": ""}}, "spec": {"containers": [{"name": "web"}]}}`)
+	assert.False(response.Allowed)
+}
+
+func TestParseResources(t *testing.T) {
+	assert := assert.New(t)
+
+	resources, err := ParseResources("sgx.intel.com/epc=10Mi, sgx.intel.com/enclave=1")
+	assert.NoError(err)
+	assert.Equal(map[string]string{"sgx.intel.com/epc": "10Mi", "sgx.intel.com/enclave": "1"}, resources)
+
+	_, err = ParseResources("sgx.intel.com/epc")
+	assert.Error(err)
+	_, err = ParseResources("sgx.intel.com/epc=")
+	assert.Error(err)
+}
@@ -0,0 +1,194 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package issuer implements a cert-manager external issuer whose certificates are issued by the Coordinator.
+//
+// CertificateRequests whose issuerRef has the Group and Kind of the issuer reference one of the manifest's ExternalServices by their name.
+// The issuer forwards their CSRs to the Coordinator, authenticated as a User who is permitted to issue certificates for the service,
+// so that conventional pods can obtain certificates that marbles trust. Only approved requests are signed.
+package issuer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+	"github.com/edgelesssys/marblerun/coordinator/kubernetes"
+	"go.uber.org/zap"
+)
+
+// Group is the default group of the issuerRef of CertificateRequests that are handled by the issuer.
+const Group = "marblerun.edgeless.systems"
+
+// Kind is the default kind of the issuerRef of CertificateRequests that are handled by the issuer.
+const Kind = "ExternalService"
+
+// PollInterval is the interval in which the issuer lists the CertificateRequests.
+const PollInterval = 10 * time.Second
+
+// Coordinator issues the certificates, see api.Client.
+type Coordinator interface {
+	IssueCertificate(service string, csr []byte, validFor time.Duration) (cert []byte, ca []byte, err error)
+}
+
+// Issuer signs the CertificateRequests of cert-manager with the Coordinator.
+type Issuer struct {
+	client      *kubernetes.Client
+	coordinator Coordinator
+	// namespace restricts the issuer to the CertificateRequests of a namespace. All namespaces are handled if it is empty.
+	namespace string
+	group     string
+	kind      string
+	zapLogger *zap.Logger
+}
+
+// New creates an Issuer for the CertificateRequests in namespace, or in all namespaces if it is empty, whose issuerRef has the group and kind.
+func New(client *kubernetes.Client, coordinator Coordinator, namespace string, group string, kind string, zapLogger *zap.Logger) *Issuer {
+	return &Issuer{client: client, coordinator: coordinator, namespace: namespace, group: group, kind: kind, zapLogger: zapLogger}
+}
+
+type certificateRequestList struct {
+	Items []certificateRequest `json:"items"`
+}
+
+type certificateRequest struct {
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+	Spec struct {
+		Request   []byte `json:"request"`
+		Duration  string `json:"duration"`
+		IssuerRef struct {
+			Name  string `json:"name"`
+			Kind  string `json:"kind"`
+			Group string `json:"group"`
+		} `json:"issuerRef"`
+	} `json:"spec"`
+	Status certificateRequestStatus `json:"status"`
+}
+
+type certificateRequestStatus struct {
+	Conditions  []condition `json:"conditions,omitempty"`
+	Certificate []byte      `json:"certificate,omitempty"`
+	CA          []byte      `json:"ca,omitempty"`
+	FailureTime string      `json:"failureTime,omitempty"`
+}
+
+type condition struct {
+	Type               string `json:"type"`
+	Status             string `json:"status"`
+	Reason             string `json:"reason,omitempty"`
+	Message            string `json:"message,omitempty"`
+	LastTransitionTime string `json:"lastTransitionTime,omitempty"`
+}
+
+// condition returns the condition of the type, or nil if the request doesn't have it.
+func (r certificateRequest) condition(conditionType string) *condition {
+	for i := range r.Status.Conditions {
+		if r.Status.Conditions[i].Type == conditionType {
+			return &r.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// Run handles the CertificateRequests in PollInterval until ctx is done.
+func (i *Issuer) Run(ctx context.Context) {
+	ticker := time.NewTicker(PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := i.Sync(); err != nil {
+			i.zapLogger.Warn("cannot list the CertificateRequests", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync handles the pending CertificateRequests of the issuer.
+//
+// Failures of single requests are logged. Requests the Coordinator rejects are marked as failed, all others are retried by the next Sync.
+func (i *Issuer) Sync() error {
+	path := "/apis/cert-manager.io/v1/certificaterequests"
+	if i.namespace != "" {
+		path = fmt.Sprintf("/apis/cert-manager.io/v1/namespaces/%s/certificaterequests", i.namespace)
+	}
+	var list certificateRequestList
+	if _, err := i.client.Request(http.MethodGet, path, "", nil, &list); err != nil {
+		return err
+	}
+	for _, req := range list.Items {
+		if req.Spec.IssuerRef.Group != i.group || req.Spec.IssuerRef.Kind != i.kind {
+			continue
+		}
+		if err := i.handle(req); err != nil {
+			i.zapLogger.Warn("cannot handle CertificateRequest", zap.String("namespace", req.Metadata.Namespace), zap.String("name", req.Metadata.Name), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// handle signs the request if it is approved and hasn't been handled yet.
+func (i *Issuer) handle(req certificateRequest) error {
+	if ready := req.condition("Ready"); ready != nil && (ready.Status == "True" || ready.Reason == "Failed" || ready.Reason == "Denied") {
+		return nil
+	}
+	if denied := req.condition("Denied"); denied != nil && denied.Status == "True" {
+		return i.updateStatus(req, certificateRequestStatus{FailureTime: now()}, "Denied", "The CertificateRequest was denied by an approval controller")
+	}
+	if approved := req.condition("Approved"); approved == nil || approved.Status != "True" {
+		return nil
+	}
+
+	var validFor time.Duration
+	if req.Spec.Duration != "" {
+		var err error
+		if validFor, err = time.ParseDuration(req.Spec.Duration); err != nil {
+			return i.updateStatus(req, certificateRequestStatus{FailureTime: now()}, "Failed", fmt.Sprintf("invalid duration: %v", err))
+		}
+	}
+	cert, ca, err := i.coordinator.IssueCertificate(req.Spec.IssuerRef.Name, req.Spec.Request, validFor)
+	var apiErr *clientapi.Error
+	if errors.As(err, &apiErr) && (apiErr.Code == clientapi.ErrorBadRequest || apiErr.Code == clientapi.ErrorUnauthorized) {
+		// the Coordinator won't issue the certificate if the request is retried
+		return i.updateStatus(req, certificateRequestStatus{FailureTime: now()}, "Failed", apiErr.Message)
+	}
+	if err != nil {
+		return err
+	}
+	i.zapLogger.Info("issued certificate", zap.String("namespace", req.Metadata.Namespace), zap.String("name", req.Metadata.Name), zap.String("service", req.Spec.IssuerRef.Name))
+	return i.updateStatus(req, certificateRequestStatus{Certificate: cert, CA: ca}, "Issued", "Certificate issued by the MarbleRun Coordinator")
+}
+
+// updateStatus sets the status of the request with its Ready condition set to True for reason Issued and False otherwise.
+func (i *Issuer) updateStatus(req certificateRequest, status certificateRequestStatus, reason string, message string) error {
+	ready := condition{Type: "Ready", Status: "False", Reason: reason, Message: message, LastTransitionTime: now()}
+	if reason == "Issued" {
+		ready.Status = "True"
+	}
+	// a merge patch replaces the conditions, so the others are kept by sending them along
+	for _, c := range req.Status.Conditions {
+		if c.Type != "Ready" {
+			status.Conditions = append(status.Conditions, c)
+		}
+	}
+	status.Conditions = append(status.Conditions, ready)
+
+	path := fmt.Sprintf("/apis/cert-manager.io/v1/namespaces/%s/certificaterequests/%s/status", req.Metadata.Namespace, req.Metadata.Name)
+	_, err := i.client.Request(http.MethodPatch, path, "application/merge-patch+json", map[string]interface{}{"status": status}, nil)
+	return err
+}
+
+func now() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
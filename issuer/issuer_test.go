@@ -0,0 +1,123 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package issuer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/clientapi"
+	"github.com/edgelesssys/marblerun/coordinator/kubernetes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type fakeCoordinator struct {
+	services map[string]bool
+	down     bool
+	validFor time.Duration
+}
+
+func (c *fakeCoordinator) IssueCertificate(service string, csr []byte, validFor time.Duration) ([]byte, []byte, error) {
+	if c.down {
+		return nil, nil, errors.New("connection refused")
+	}
+	if !c.services[service] {
+		return nil, nil, fmt.Errorf("issuing certificate failed: %w", &clientapi.Error{Code: clientapi.ErrorUnauthorized, Message: "client is not authorized"})
+	}
+	c.validFor = validFor
+	return append([]byte("cert for "), csr...), []byte("ca"), nil
+}
+
+const certificateRequests = `{"items": [
+	{"metadata": {"name": "web", "namespace": "default"},
+	 "spec": {"request": "Y3Ny", "duration": "24h", "issuerRef": {"name": "web", "kind": "ExternalService", "group": "marblerun.edgeless.systems"}},
+	 "status": {"conditions": [{"type": "Approved", "status": "True", "reason": "cert-manager.io"}]}},
+	{"metadata": {"name": "pending", "namespace": "default"},
+	 "spec": {"request": "Y3Ny", "issuerRef": {"name": "web", "kind": "ExternalService", "group": "marblerun.edgeless.systems"}}},
+	{"metadata": {"name": "unknown", "namespace": "default"},
+	 "spec": {"request": "Y3Ny", "issuerRef": {"name": "unknown", "kind": "ExternalService", "group": "marblerun.edgeless.systems"}},
+	 "status": {"conditions": [{"type": "Approved", "status": "True"}]}},
+	{"metadata": {"name": "denied", "namespace": "default"},
+	 "spec": {"request": "Y3Ny", "issuerRef": {"name": "web", "kind": "ExternalService", "group": "marblerun.edgeless.systems"}},
+	 "status": {"conditions": [{"type": "Denied", "status": "True"}]}},
+	{"metadata": {"name": "done", "namespace": "default"},
+	 "spec": {"request": "Y3Ny", "issuerRef": {"name": "web", "kind": "ExternalService", "group": "marblerun.edgeless.systems"}},
+	 "status": {"conditions": [{"type": "Approved", "status": "True"}, {"type": "Ready", "status": "True", "reason": "Issued"}]}},
+	{"metadata": {"name": "other", "namespace": "default"},
+	 "spec": {"request": "Y3Ny", "issuerRef": {"name": "ca", "kind": "ClusterIssuer", "group": "cert-manager.io"}},
+	 "status": {"conditions": [{"type": "Approved", "status": "True"}]}}
+]}`
+
+func TestIssuer(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	tokenFile, err := ioutil.TempFile("", "")
+	require.NoError(err)
+	defer os.Remove(tokenFile.Name())
+	tokenFile.Close()
+
+	var mux sync.Mutex
+	statuses := make(map[string]certificateRequestStatus)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/apis/cert-manager.io/v1/certificaterequests":
+			w.Write([]byte(certificateRequests))
+		case r.Method == http.MethodPatch && strings.HasSuffix(r.URL.Path, "/status"):
+			assert.Equal("application/merge-patch+json", r.Header.Get("Content-Type"))
+			var patch struct{ Status certificateRequestStatus }
+			require.NoError(json.NewDecoder(r.Body).Decode(&patch))
+			statuses[strings.TrimPrefix(r.URL.Path, "/apis/cert-manager.io/v1/namespaces/")] = patch.Status
+			w.Write([]byte("{}"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	coordinator := &fakeCoordinator{down: true, services: map[string]bool{"web": true}}
+	issuer := New(kubernetes.NewClient(server.URL, tokenFile.Name(), nil), coordinator, "", Group, Kind, zap.NewNop())
+
+	// requests are retried while the Coordinator is unavailable, but denied requests fail
+	require.NoError(issuer.Sync())
+	assert.Len(statuses, 1)
+	denied := statuses["default/certificaterequests/denied/status"]
+	assert.Equal("Denied", denied.Conditions[len(denied.Conditions)-1].Reason)
+	assert.NotEmpty(denied.FailureTime)
+
+	coordinator.down = false
+	require.NoError(issuer.Sync())
+	assert.Len(statuses, 3)
+
+	issued := statuses["default/certificaterequests/web/status"]
+	assert.Equal([]byte("cert for csr"), issued.Certificate)
+	assert.Equal([]byte("ca"), issued.CA)
+	assert.Equal(24*time.Hour, coordinator.validFor)
+	// the Approved condition is kept
+	require.Len(issued.Conditions, 2)
+	assert.Equal("Approved", issued.Conditions[0].Type)
+	assert.Equal(condition{Type: "Ready", Status: "True", Reason: "Issued", Message: issued.Conditions[1].Message, LastTransitionTime: issued.Conditions[1].LastTransitionTime}, issued.Conditions[1])
+
+	// requests the Coordinator rejects fail
+	rejected := statuses["default/certificaterequests/unknown/status"]
+	assert.Empty(rejected.Certificate)
+	assert.Equal("Failed", rejected.Conditions[len(rejected.Conditions)-1].Reason)
+	assert.Equal("False", rejected.Conditions[len(rejected.Conditions)-1].Status)
+}
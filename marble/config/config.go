@@ -7,7 +7,8 @@
 // Package config defines the environment variables expected by the Marble for configuration settings.
 package config
 
-// CoordinatorAddr is the marble's addr to connect to the coordinator via gRPC
+// CoordinatorAddr is the marble's addr to connect to the coordinator via gRPC: a TCP address, a Unix socket like unix:///run/marblerun.sock
+// or a vsock address like vsock://<CID>:<port>
 const CoordinatorAddr = "EDG_MARBLE_COORDINATOR_ADDR"
 
 // Type is the marble's type used for attestation with the coordinator
@@ -18,3 +19,60 @@ const DNSNames = "EDG_MARBLE_DNS_NAMES"
 
 // UUIDFile is the file path to store the marble's uuid
 const UUIDFile = "EDG_MARBLE_UUID_FILE"
+
+// DevMode enables human-readable and more verbose logging if set to "1"
+const DevMode = "EDG_MARBLE_DEV_MODE"
+
+// LogLevel is the minimum level of log messages: debug, info, warn or error
+const LogLevel = "EDG_MARBLE_LOG_LEVEL"
+
+// CoordinatorRootCA is the PEM-encoded root certificate of the Coordinator, e.g., from a previous activation. If set, the marble only activates with a Coordinator
+// whose certificate chains up to it. Otherwise, the marble doesn't verify the Coordinator.
+const CoordinatorRootCA = "EDG_MARBLE_COORDINATOR_ROOT_CA"
+
+// Simulation explicitly disables the generation of quotes if set to "1". Never use this in production.
+const Simulation = "EDG_MARBLE_SIMULATION"
+
+// ActivationTimeout is the deadline within which the marble must be activated, e.g., "10m". It defaults to 5 minutes.
+// The marble retries its activation with exponential backoff as long as the Coordinator is unreachable or responds with a retryable error.
+const ActivationTimeout = "EDG_MARBLE_ACTIVATION_TIMEOUT"
+
+// ActivationRetries is the maximum number of retries of the activation. "0" disables retries. By default, the marble retries until the ActivationTimeout.
+const ActivationRetries = "EDG_MARBLE_ACTIVATION_RETRIES"
+
+// TTLSConfig is the JSON-encoded transparent TLS configuration of the marble, which is set by the Coordinator on activation.
+const TTLSConfig = "EDG_MARBLE_TTLS_CONFIG"
+
+// SDSAddr is the address on which the premain serves the marble certificate and the Coordinator's root certificate to a sidecar Envoy
+// over the Secret Discovery Service: a TCP address, a Unix socket like unix:///run/marblerun/sds.sock or a vsock address. The SDS is disabled if unset.
+const SDSAddr = "EDG_MARBLE_SDS_ADDR"
+
+// WorkloadAPIAddr is the address on which the premain serves the X.509-SVID of the marble over the SPIFFE Workload API, e.g., unix:///run/marblerun/workload.sock.
+// The manifest must set a SPIFFETrustDomain. The Workload API is disabled if unset.
+const WorkloadAPIAddr = "EDG_MARBLE_WORKLOAD_API_ADDR"
+
+// TTLS describes connections of a marble that are transparently wrapped in mTLS with the marble's certificate.
+type TTLS struct {
+	// Outgoing connections are accepted in plaintext from the application and forwarded over mTLS.
+	Outgoing []TTLSOutgoing `json:",omitempty"`
+	// Incoming connections are accepted over mTLS and forwarded in plaintext to the application.
+	Incoming []TTLSIncoming `json:",omitempty"`
+}
+
+// TTLSOutgoing describes a connection from the application to another marble.
+type TTLSOutgoing struct {
+	// Port is the local port the application connects to.
+	Port uint16
+	// Addr is the address of the other marble, e.g., "backend.svc:8080". Its certificate must be valid for the host.
+	Addr string
+}
+
+// TTLSIncoming describes connections from other marbles to the application.
+type TTLSIncoming struct {
+	// Port is the port on which mTLS connections are accepted.
+	Port uint16
+	// Target is the plaintext address of the application, e.g., "localhost:8080".
+	Target string
+	// DisableClientAuth also accepts clients without a certificate issued by the Coordinator, e.g., from outside the mesh.
+	DisableClientAuth bool `json:",omitempty"`
+}
@@ -0,0 +1,118 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package identity encodes the identity of a Marble in the certificates the Coordinator issues to it,
+// so that peers and other verifiers can authorize the Marble by its type and package.
+package identity
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// OIDMarbleIdentity is the object identifier of the X.509 extension that holds the Identity of a Marble.
+var OIDMarbleIdentity = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+
+// ErrNoIdentity occurs if a certificate doesn't contain the identity of a Marble,
+// e.g., because it has been issued by a Coordinator that doesn't encode it yet.
+var ErrNoIdentity = errors.New("the certificate does not contain the identity of a marble")
+
+// Identity is the identity of a Marble as defined by the manifest.
+type Identity struct {
+	MarbleType string
+	Package    string
+	// ProductID is the ProductID of the Marble's package. It is nil if the manifest doesn't set one.
+	ProductID *uint64
+	UUID      string
+}
+
+// asn1Identity is the ASN.1 encoding of an Identity: SEQUENCE { marbleType UTF8String, package UTF8String, uuid UTF8String, productID INTEGER OPTIONAL }
+type asn1Identity struct {
+	MarbleType string   `asn1:"utf8"`
+	Package    string   `asn1:"utf8"`
+	UUID       string   `asn1:"utf8"`
+	ProductID  *big.Int `asn1:"optional"`
+}
+
+// Extension returns the non-critical X.509 extension that holds the identity.
+func (id Identity) Extension() (pkix.Extension, error) {
+	value := asn1Identity{MarbleType: id.MarbleType, Package: id.Package, UUID: id.UUID}
+	if id.ProductID != nil {
+		value.ProductID = new(big.Int).SetUint64(*id.ProductID)
+	}
+	encoded, err := asn1.Marshal(value)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: OIDMarbleIdentity, Value: encoded}, nil
+}
+
+// FromCertificate returns the identity of the Marble the certificate has been issued to. It returns ErrNoIdentity if the certificate doesn't contain one.
+//
+// The identity can only be trusted if the certificate has been verified against the root certificate of the Coordinator.
+func FromCertificate(cert *x509.Certificate) (Identity, error) {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(OIDMarbleIdentity) {
+			return parseExtension(ext.Value)
+		}
+	}
+	return Identity{}, ErrNoIdentity
+}
+
+// FromChain returns the identity of the Marble from its verified chain, i.e., its certificate, the package CA and the root certificate.
+//
+// For certificates of Coordinators that don't encode the identity yet, the package is the one whose CA issued the certificate and the type is
+// taken from the Marble's SPIFFE ID. The type is empty if the certificate doesn't contain a SPIFFE ID either.
+func FromChain(chain []*x509.Certificate) (Identity, error) {
+	if len(chain) == 0 {
+		return Identity{}, errors.New("empty certificate chain")
+	}
+	leaf := chain[0]
+	if id, err := FromCertificate(leaf); err != ErrNoIdentity {
+		return id, err
+	}
+
+	id := Identity{UUID: leaf.Subject.CommonName}
+	// the Coordinator issues marble certificates with the CA of the marble's package, which is named after it
+	if len(chain) > 2 {
+		id.Package = chain[1].Subject.CommonName
+	}
+	// the SPIFFE ID is spiffe://<trust domain>/<marble type>/<uuid>
+	for _, uri := range leaf.URIs {
+		if uri.Scheme != "spiffe" {
+			continue
+		}
+		if segments := strings.Split(strings.TrimPrefix(uri.Path, "/"), "/"); len(segments) == 2 {
+			id.MarbleType = segments[0]
+		}
+	}
+	return id, nil
+}
+
+func parseExtension(value []byte) (Identity, error) {
+	var parsed asn1Identity
+	rest, err := asn1.Unmarshal(value, &parsed)
+	if err != nil {
+		return Identity{}, fmt.Errorf("invalid marble identity: %v", err)
+	}
+	if len(rest) > 0 {
+		return Identity{}, errors.New("invalid marble identity: trailing data")
+	}
+	id := Identity{MarbleType: parsed.MarbleType, Package: parsed.Package, UUID: parsed.UUID}
+	if parsed.ProductID != nil {
+		if parsed.ProductID.Sign() < 0 || !parsed.ProductID.IsUint64() {
+			return Identity{}, errors.New("invalid marble identity: ProductID is out of range")
+		}
+		productID := parsed.ProductID.Uint64()
+		id.ProductID = &productID
+	}
+	return id, nil
+}
@@ -0,0 +1,47 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package identity
+
+import (
+	"crypto/elliptic"
+	"math"
+	"testing"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote/quotetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentity(t *testing.T) {
+	productID := uint64(math.MaxUint64)
+	testCases := map[string]Identity{
+		"with ProductID":    {MarbleType: "backend", Package: "backend_package", ProductID: &productID, UUID: "uuid"},
+		"without ProductID": {MarbleType: "backend", Package: "backend_package", UUID: "uuid"},
+	}
+
+	for name, id := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			ext, err := id.Extension()
+			require.NoError(err)
+			assert.False(ext.Critical)
+			cert, _ := quotetest.MustCreateCert(t, elliptic.P256(), "uuid", false, nil, nil, ext)
+			parsed, err := FromCertificate(cert)
+			require.NoError(err)
+			assert.Equal(id, parsed)
+		})
+	}
+
+	cert, _ := quotetest.MustCreateCert(t, elliptic.P256(), "uuid", false, nil, nil)
+	_, err := FromCertificate(cert)
+	assert.Equal(t, ErrNoIdentity, err)
+
+	_, err = parseExtension([]byte{0x30, 0x01})
+	assert.Error(t, err)
+}
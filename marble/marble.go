@@ -0,0 +1,190 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package marble provides the activation and TLS configurations for Go applications that run as Marbles.
+//
+// PreMain activates the application with the Coordinator. The TLS configurations use the certificate the Marble received on activation and renew it from the Coordinator before it expires.
+package marble
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/edgelesssys/ertgolib/marble"
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/edgelesssys/marblerun/marble/config"
+	"github.com/edgelesssys/marblerun/marble/premain"
+	"github.com/edgelesssys/marblerun/util"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// renewalThreshold is the fraction of a certificate's lifetime after which it is renewed.
+const renewalThreshold = 2.0 / 3
+
+// renewalRetryInterval is the time to wait before a failed renewal is retried.
+const renewalRetryInterval = time.Minute
+
+// GetServerTLSConfig returns a TLS configuration for servers of the Marble.
+//
+// Clients must present a certificate issued by the Coordinator, i.e., only other Marbles are accepted.
+// Set ClientAuth to tls.NoClientCert to accept clients from outside the mesh.
+func GetServerTLSConfig() (*tls.Config, error) {
+	source, err := newCertificateSource(premain.Getenv, renewRPC)
+	if err != nil {
+		return nil, err
+	}
+	return source.serverTLSConfig(), nil
+}
+
+// GetClientTLSConfig returns a TLS configuration for clients of the Marble.
+//
+// Only servers with certificates issued by the Coordinator are accepted. The Marble's certificate is presented to servers that request it.
+func GetClientTLSConfig() (*tls.Config, error) {
+	source, err := newCertificateSource(premain.Getenv, renewRPC)
+	if err != nil {
+		return nil, err
+	}
+	return source.clientTLSConfig(), nil
+}
+
+type renewFunc func(req *rpc.RenewalReq, coordAddr string, tlsCredentials credentials.TransportCredentials) (*rpc.RenewalResp, error)
+
+// certificateSource provides the Marble's certificate and renews it before it expires.
+type certificateSource struct {
+	mux        sync.Mutex
+	cert       *tls.Certificate
+	roots      *x509.CertPool
+	coordAddr  string
+	renew      renewFunc
+	retryAfter time.Time
+}
+
+// newCertificateSource creates a certificateSource from the activation parameters that are set as environment variables.
+func newCertificateSource(getenv func(string) string, renew renewFunc) (*certificateSource, error) {
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(getenv(marble.MarbleEnvironmentRootCA))) {
+		return nil, errors.New("failed to parse root certificate of the Coordinator")
+	}
+	cert, err := parseCertificate(getenv(marble.MarbleEnvironmentCertificate), getenv(marble.MarbleEnvironmentPrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse marble certificate: %v", err)
+	}
+	return &certificateSource{
+		cert:      cert,
+		roots:     roots,
+		coordAddr: getenv(config.CoordinatorAddr),
+		renew:     renew,
+	}, nil
+}
+
+func parseCertificate(certPEM string, keyPEM string) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return nil, err
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+func (s *certificateSource) serverTLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return s.getCertificate()
+		},
+		ClientCAs:  s.roots,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	}
+}
+
+func (s *certificateSource) clientTLSConfig() *tls.Config {
+	return &tls.Config{
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return s.getCertificate()
+		},
+		RootCAs: s.roots,
+	}
+}
+
+// getCertificate returns the Marble's certificate. It is renewed first if it has reached the renewal threshold of its lifetime.
+//
+// If the renewal fails, the current certificate is returned as long as it is valid, and the renewal is retried after renewalRetryInterval.
+// The error is returned once the certificate has expired.
+func (s *certificateSource) getCertificate() (*tls.Certificate, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	now := time.Now()
+	leaf := s.cert.Leaf
+	lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+	renewAt := leaf.NotBefore.Add(time.Duration(float64(lifetime) * renewalThreshold))
+	if now.Before(renewAt) || now.Before(s.retryAfter) {
+		if now.After(leaf.NotAfter) {
+			return nil, errors.New("marble certificate has expired")
+		}
+		return s.cert, nil
+	}
+
+	cert, err := s.renewCertificate()
+	if err != nil {
+		s.retryAfter = now.Add(renewalRetryInterval)
+		if now.After(leaf.NotAfter) {
+			return nil, fmt.Errorf("marble certificate has expired and could not be renewed: %v", err)
+		}
+		return s.cert, nil
+	}
+	s.cert = cert
+	return cert, nil
+}
+
+// renewCertificate requests a new certificate from the Coordinator, authenticating with the current certificate.
+func (s *certificateSource) renewCertificate() (*tls.Certificate, error) {
+	// the Coordinator generates the key of the new certificate, the CSR only requests the names
+	csrKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := util.GenerateCSR(s.cert.Leaf.DNSNames, csrKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// the Coordinator's certificate is verified against the root certificate, but not its name, because the Coordinator may be reached by any address
+	tlsConfig := &tls.Config{
+		Certificates:       []tls.Certificate{*s.cert},
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return util.VerifyCoordinatorCertificate(rawCerts, s.roots)
+		},
+	}
+	resp, err := s.renew(&rpc.RenewalReq{CSR: csr.Raw}, s.coordAddr, credentials.NewTLS(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	return parseCertificate(resp.GetCertificate(), resp.GetPrivateKey())
+}
+
+func renewRPC(req *rpc.RenewalReq, coordAddr string, tlsCredentials credentials.TransportCredentials) (*rpc.RenewalResp, error) {
+	connection, err := grpc.Dial(coordAddr, grpc.WithTransportCredentials(tlsCredentials), grpc.WithContextDialer(util.Dial))
+	if err != nil {
+		return nil, err
+	}
+	defer connection.Close()
+
+	client := rpc.NewMarbleClient(connection)
+	return client.Renew(context.Background(), req)
+}
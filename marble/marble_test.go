@@ -0,0 +1,202 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package marble
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/ertgolib/marble"
+	"github.com/edgelesssys/marblerun/coordinator/quote/quotetest"
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/edgelesssys/marblerun/marble/config"
+	"github.com/edgelesssys/marblerun/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/credentials"
+)
+
+// testCA issues marble certificates like the Coordinator.
+type testCA struct {
+	cert  *x509.Certificate
+	privk *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	cert, privk := quotetest.MustCreateCert(t, elliptic.P256(), "Test Coordinator", true, nil, nil)
+	return &testCA{cert: cert, privk: privk}
+}
+
+// issue returns the PEM-encoded certificate and key of a marble certificate with the given validity.
+func (ca *testCA) issue(t *testing.T, notBefore time.Time, notAfter time.Time) (string, string) {
+	require := require.New(t)
+
+	privk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+	serialNumber, err := util.GenerateCertificateSerialNumber()
+	require.NoError(err)
+	template := x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: "marble"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		DNSNames:              []string{"localhost"},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyAgreement,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	certRaw, err := x509.CreateCertificate(rand.Reader, &template, ca.cert, &privk.PublicKey, ca.privk)
+	require.NoError(err)
+	encodedPrivk, err := x509.MarshalPKCS8PrivateKey(privk)
+	require.NoError(err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certRaw})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: encodedPrivk}))
+}
+
+// env returns the activation parameters of a marble with the given certificate.
+func (ca *testCA) env(certPEM string, keyPEM string) func(string) string {
+	values := map[string]string{
+		marble.MarbleEnvironmentRootCA:      string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})),
+		marble.MarbleEnvironmentCertificate: certPEM,
+		marble.MarbleEnvironmentPrivateKey:  keyPEM,
+		config.CoordinatorAddr:              "addr",
+	}
+	return func(key string) string { return values[key] }
+}
+
+func TestCertificateSource(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	ca := newTestCA(t)
+	now := time.Now()
+
+	// These are returned by the renew mock function and will be set to different values to test different scenarios.
+	var renewedCert, renewedKey string
+	var renewError error
+	renewals := 0
+
+	// Mocks the coordinator.
+	renew := func(req *rpc.RenewalReq, coordAddr string, tlsCredentials credentials.TransportCredentials) (*rpc.RenewalResp, error) {
+		assert.Equal("addr", coordAddr)
+		assert.NotNil(tlsCredentials)
+		csr, err := x509.ParseCertificateRequest(req.CSR)
+		require.NoError(err)
+		assert.NoError(csr.CheckSignature())
+		assert.Equal([]string{"localhost"}, csr.DNSNames)
+		renewals++
+		return &rpc.RenewalResp{Certificate: renewedCert, PrivateKey: renewedKey}, renewError
+	}
+
+	// invalid activation parameters
+	_, err := newCertificateSource(func(string) string { return "" }, renew)
+	assert.Error(err)
+	certPEM, _ := ca.issue(t, now.Add(-time.Hour), now.Add(time.Hour))
+	_, keyPEM := ca.issue(t, now.Add(-time.Hour), now.Add(time.Hour))
+	_, err = newCertificateSource(ca.env(certPEM, keyPEM), renew)
+	assert.Error(err, "key does not match the certificate")
+
+	// certificates are not renewed before the threshold
+	certPEM, keyPEM = ca.issue(t, now.Add(-time.Hour), now.Add(time.Hour))
+	source, err := newCertificateSource(ca.env(certPEM, keyPEM), renew)
+	require.NoError(err)
+	cert, err := source.getCertificate()
+	require.NoError(err)
+	assert.Equal(source.cert, cert)
+	assert.Zero(renewals)
+
+	// certificates are renewed after the threshold
+	certPEM, keyPEM = ca.issue(t, now.Add(-time.Hour), now.Add(time.Minute))
+	source, err = newCertificateSource(ca.env(certPEM, keyPEM), renew)
+	require.NoError(err)
+	oldCert := source.cert
+	renewedCert, renewedKey = ca.issue(t, now, now.Add(time.Hour))
+	cert, err = source.getCertificate()
+	require.NoError(err)
+	assert.Equal(1, renewals)
+	assert.NotEqual(oldCert.Leaf.SerialNumber, cert.Leaf.SerialNumber)
+	assert.Equal(cert, source.cert)
+	_, err = source.getCertificate()
+	require.NoError(err)
+	assert.Equal(1, renewals, "the renewed certificate must be used until its threshold")
+
+	// the current certificate is used as long as it is valid if the renewal fails
+	renewals = 0
+	renewError = errors.New("test")
+	source, err = newCertificateSource(ca.env(certPEM, keyPEM), renew)
+	require.NoError(err)
+	cert, err = source.getCertificate()
+	require.NoError(err)
+	assert.Equal(oldCert.Leaf.SerialNumber, cert.Leaf.SerialNumber)
+	assert.Equal(1, renewals)
+	_, err = source.getCertificate()
+	require.NoError(err)
+	assert.Equal(1, renewals, "failed renewals must not be retried immediately")
+
+	// expired certificates that cannot be renewed are not used
+	certPEM, keyPEM = ca.issue(t, now.Add(-time.Hour), now.Add(-time.Minute))
+	source, err = newCertificateSource(ca.env(certPEM, keyPEM), renew)
+	require.NoError(err)
+	_, err = source.getCertificate()
+	assert.Error(err)
+	_, err = source.getCertificate()
+	assert.Error(err)
+}
+
+func TestTLSConfig(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	ca := newTestCA(t)
+	renew := func(*rpc.RenewalReq, string, credentials.TransportCredentials) (*rpc.RenewalResp, error) {
+		return nil, errors.New("unexpected renewal")
+	}
+	serverSource, err := newCertificateSource(ca.env(ca.issue(t, time.Now(), time.Now().Add(time.Hour))), renew)
+	require.NoError(err)
+	clientSource, err := newCertificateSource(ca.env(ca.issue(t, time.Now(), time.Now().Add(time.Hour))), renew)
+	require.NoError(err)
+
+	serverConfig := serverSource.serverTLSConfig()
+	clientConfig := clientSource.clientTLSConfig()
+	clientConfig.ServerName = "localhost"
+
+	// marbles authenticate each other with the certificates issued by the Coordinator
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+	server := tls.Server(serverConn, serverConfig)
+	client := tls.Client(clientConn, clientConfig)
+	errChan := make(chan error)
+	go func() { errChan <- server.Handshake() }()
+	assert.NoError(client.Handshake())
+	assert.NoError(<-errChan)
+	assert.Equal("marble", server.ConnectionState().PeerCertificates[0].Subject.CommonName)
+}
+
+func TestVerifyCoordinatorCertificate(t *testing.T) {
+	assert := assert.New(t)
+
+	ca := newTestCA(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	certPEM, _ := ca.issue(t, time.Now(), time.Now().Add(time.Hour))
+	block, _ := pem.Decode([]byte(certPEM))
+
+	assert.NoError(util.VerifyCoordinatorCertificate([][]byte{block.Bytes, ca.cert.Raw}, roots))
+	assert.Error(util.VerifyCoordinatorCertificate(nil, roots))
+	otherCA := newTestCA(t)
+	assert.Error(util.VerifyCoordinatorCertificate([][]byte{otherCA.cert.Raw}, roots))
+}
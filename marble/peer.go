@@ -0,0 +1,93 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package marble
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/edgelesssys/marblerun/marble/identity"
+)
+
+// PeerPolicy restricts the Marbles that a TLS configuration accepts as peers to those of certain types and packages of the manifest.
+//
+// The type and the package of a peer are taken from the identity the Coordinator encodes in its certificate, see the identity package.
+// For certificates of Coordinators that don't encode it yet, the package is the one whose CA issued the certificate and the type is
+// taken from the peer's SPIFFE ID, so the manifest must set a SPIFFETrustDomain to restrict the MarbleTypes.
+type PeerPolicy struct {
+	// MarbleTypes are the accepted types of the peers. Any type is accepted if it is empty.
+	MarbleTypes []string
+	// Packages are the accepted packages of the peers. Any package is accepted if it is empty.
+	Packages []string
+}
+
+// GetServerTLSConfigForPeers is like GetServerTLSConfig, but only accepts clients that satisfy the policy.
+func GetServerTLSConfigForPeers(policy PeerPolicy) (*tls.Config, error) {
+	tlsConfig, err := GetServerTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.VerifyPeerCertificate = policy.VerifyPeerCertificate
+	return tlsConfig, nil
+}
+
+// GetClientTLSConfigForPeers is like GetClientTLSConfig, but only accepts servers that satisfy the policy.
+func GetClientTLSConfigForPeers(policy PeerPolicy) (*tls.Config, error) {
+	tlsConfig, err := GetClientTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.VerifyPeerCertificate = policy.VerifyPeerCertificate
+	return tlsConfig, nil
+}
+
+// VerifyPeerCertificate verifies that the peer satisfies the policy. It can be set as the VerifyPeerCertificate of a tls.Config
+// that verifies the peer's certificate against the Coordinator's root certificate, e.g., one returned by GetServerTLSConfig or
+// GetClientTLSConfig. The peer is rejected if its certificate hasn't been verified.
+func (p PeerPolicy) VerifyPeerCertificate(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+	if len(verifiedChains) == 0 {
+		return errors.New("the peer's certificate has not been verified")
+	}
+	var err error
+	for _, chain := range verifiedChains {
+		if err = p.verifyChain(chain); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// verifyChain verifies that the Marble that the chain has been issued to satisfies the policy.
+func (p PeerPolicy) verifyChain(chain []*x509.Certificate) error {
+	peer, err := identity.FromChain(chain)
+	if err != nil {
+		return err
+	}
+	if len(p.MarbleTypes) > 0 {
+		if peer.MarbleType == "" {
+			return errors.New("the peer's certificate doesn't name its marble type: it contains neither an identity nor a SPIFFE ID")
+		}
+		if !contains(p.MarbleTypes, peer.MarbleType) {
+			return fmt.Errorf("peer %v has marble type %q, which is not accepted", peer.UUID, peer.MarbleType)
+		}
+	}
+	if len(p.Packages) > 0 && !contains(p.Packages, peer.Package) {
+		return fmt.Errorf("peer %v has package %q, which is not accepted", peer.UUID, peer.Package)
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
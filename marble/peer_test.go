@@ -0,0 +1,122 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package marble
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote/quotetest"
+	"github.com/edgelesssys/marblerun/marble/identity"
+	"github.com/edgelesssys/marblerun/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerPolicy(t *testing.T) {
+	require := require.New(t)
+
+	ca := newTestCA(t)
+	packageCA, packageKey := quotetest.MustCreateCert(t, elliptic.P256(), "backend_package", true, ca.cert, ca.privk)
+
+	// issueChain returns the verified chain of a marble certificate issued like the Coordinator does
+	issueChain := func(uris []*url.URL, extensions ...pkix.Extension) [][]*x509.Certificate {
+		privk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(err)
+		serialNumber, err := util.GenerateCertificateSerialNumber()
+		require.NoError(err)
+		template := x509.Certificate{
+			SerialNumber:    serialNumber,
+			Subject:         pkix.Name{CommonName: "uuid"},
+			NotBefore:       time.Now(),
+			NotAfter:        time.Now().Add(time.Hour),
+			ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			URIs:            uris,
+			ExtraExtensions: extensions,
+		}
+		certRaw, err := x509.CreateCertificate(rand.Reader, &template, packageCA, &privk.PublicKey, packageKey)
+		require.NoError(err)
+		cert, err := x509.ParseCertificate(certRaw)
+		require.NoError(err)
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.cert)
+		intermediates := x509.NewCertPool()
+		intermediates.AddCert(packageCA)
+		chains, err := cert.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+		require.NoError(err)
+		return chains
+	}
+	withSPIFFEID := issueChain([]*url.URL{{Scheme: "spiffe", Host: "example.org", Path: "/backend/uuid"}})
+	withoutSPIFFEID := issueChain(nil)
+	// the identity takes precedence over the SPIFFE ID and the package CA
+	idExtension, err := identity.Identity{MarbleType: "frontend", Package: "frontend_package", UUID: "uuid"}.Extension()
+	require.NoError(err)
+	withIdentity := issueChain([]*url.URL{{Scheme: "spiffe", Host: "example.org", Path: "/backend/uuid"}}, idExtension)
+
+	testCases := map[string]struct {
+		policy  PeerPolicy
+		chains  [][]*x509.Certificate
+		wantErr bool
+	}{
+		"any marble": {
+			chains: withoutSPIFFEID,
+		},
+		"accepted type and package": {
+			policy: PeerPolicy{MarbleTypes: []string{"frontend", "backend"}, Packages: []string{"backend_package"}},
+			chains: withSPIFFEID,
+		},
+		"other type": {
+			policy:  PeerPolicy{MarbleTypes: []string{"frontend"}},
+			chains:  withSPIFFEID,
+			wantErr: true,
+		},
+		"other package": {
+			policy:  PeerPolicy{Packages: []string{"frontend_package"}},
+			chains:  withSPIFFEID,
+			wantErr: true,
+		},
+		"type without SPIFFE ID": {
+			policy:  PeerPolicy{MarbleTypes: []string{"backend"}},
+			chains:  withoutSPIFFEID,
+			wantErr: true,
+		},
+		"package without SPIFFE ID": {
+			policy: PeerPolicy{Packages: []string{"backend_package"}},
+			chains: withoutSPIFFEID,
+		},
+		"accepted identity": {
+			policy: PeerPolicy{MarbleTypes: []string{"frontend"}, Packages: []string{"frontend_package"}},
+			chains: withIdentity,
+		},
+		"other identity": {
+			policy:  PeerPolicy{MarbleTypes: []string{"backend"}},
+			chains:  withIdentity,
+			wantErr: true,
+		},
+		"unverified certificate": {
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			err := tc.policy.VerifyPeerCertificate(nil, tc.chains)
+			if tc.wantErr {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+		})
+	}
+}
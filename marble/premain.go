@@ -0,0 +1,50 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package marble
+
+import (
+	"sync"
+
+	"github.com/edgelesssys/marblerun/marble/premain"
+)
+
+var (
+	preMainOnce sync.Once
+	preMainErr  error
+	// runPreMain is replaced in tests.
+	runPreMain = premain.PreMain
+)
+
+// PreMain activates the Marble with the Coordinator.
+//
+// Call it at the beginning of the main function of a Go application running inside an enclave built with Edgeless RT.
+// The files of the manifest are written to the in-enclave memory file system, the environment variables and the Argv are set in the process,
+// and the host file system is mounted under '/edg/hostfs'. Afterwards, GetServerTLSConfig and GetClientTLSConfig can be used.
+//
+// PreMain activates the Marble only once. Subsequent calls return the result of the first call.
+func PreMain() error {
+	preMainOnce.Do(func() {
+		preMainErr = runPreMain()
+	})
+	return preMainErr
+}
+
+// Parameters are the files and environment variables the manifest defines for the Marble.
+type Parameters struct {
+	Files map[string]string
+	Env   map[string]string
+}
+
+// OnParametersUpdate registers a callback that is called when the Coordinator pushes updated parameters after a manifest update or a change of secrets.
+//
+// The files have already been rewritten and the environment variables have already been set when the callback is called, e.g., to reload a configuration.
+// The parameters include a new certificate of the Marble. Callbacks should return quickly, as later updates are applied after they returned.
+func OnParametersUpdate(callback func(Parameters)) {
+	premain.OnParametersUpdate(func(files map[string]string, env map[string]string) {
+		callback(Parameters{Files: files, Env: env})
+	})
+}
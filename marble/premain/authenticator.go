@@ -0,0 +1,270 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package premain
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/coordinator/quote/ertvalidator"
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/edgelesssys/marblerun/util"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// Authenticator activates a marble with the Coordinator. PreMain and Run are built on it; use it directly for premains that apply the
+// activation differently, e.g., in runtimes that need their own setup.
+//
+// NewAuthenticator generates the marble's TLS credentials and the CSR of its marble certificate. Activate issues the marble's quote and
+// activates the marble. Afterwards, the accessors return the results of the activation.
+type Authenticator struct {
+	coordAddr  string
+	marbleType string
+	issuer     quote.Issuer
+	dial       func(ctx context.Context, addr string) (net.Conn, error)
+	// roots contains the root certificate the Coordinator is pinned to, if any
+	roots    *x509.CertPool
+	retry    retryPolicy
+	activate activateFunc
+	logger   *zap.Logger
+
+	cert  *x509.Certificate
+	privk *ecdsa.PrivateKey
+	csr   *x509.CertificateRequest
+
+	resp *rpc.ActivationResp
+}
+
+// AuthenticatorOption configures an Authenticator.
+type AuthenticatorOption func(*Authenticator) error
+
+// WithIssuer sets the issuer of the marble's quote. It defaults to the issuer of Edgeless RT.
+func WithIssuer(issuer quote.Issuer) AuthenticatorOption {
+	return func(a *Authenticator) error {
+		if issuer != nil {
+			a.issuer = issuer
+		}
+		return nil
+	}
+}
+
+// WithDialer sets the function that connects to the Coordinator's address. It defaults to util.Dial, which supports TCP, Unix socket and vsock addresses.
+func WithDialer(dial func(ctx context.Context, addr string) (net.Conn, error)) AuthenticatorOption {
+	return func(a *Authenticator) error {
+		a.dial = dial
+		return nil
+	}
+}
+
+// WithRootCA pins the Coordinator to the PEM-encoded root certificate, e.g., the MARBLE_PREDEFINED_ROOT_CA of an earlier activation.
+//
+// The Coordinator's certificate must chain up to it. Otherwise, the marble doesn't verify the Coordinator, which attests the marble, but not vice versa.
+func WithRootCA(rootCA string) AuthenticatorOption {
+	return func(a *Authenticator) error {
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM([]byte(rootCA)) {
+			return errors.New("failed to parse root certificate of the Coordinator")
+		}
+		a.roots = roots
+		return nil
+	}
+}
+
+// WithRetry sets how long and how often the activation is retried while the Coordinator is unreachable or responds with a retryable error.
+// retries is the maximum number of retries, or negative to retry until the timeout. By default, the activation is retried for 5 minutes.
+func WithRetry(timeout time.Duration, retries int) AuthenticatorOption {
+	return func(a *Authenticator) error {
+		if timeout <= 0 {
+			return errors.New("the activation timeout must be positive")
+		}
+		a.retry.timeout = timeout
+		a.retry.retries = retries
+		return nil
+	}
+}
+
+// WithLogger sets the logger of the activation. Nothing is logged by default.
+func WithLogger(logger *zap.Logger) AuthenticatorOption {
+	return func(a *Authenticator) error {
+		a.logger = logger
+		return nil
+	}
+}
+
+// withRetryPolicy sets the retry policy, e.g., the one of the environment.
+func withRetryPolicy(policy retryPolicy) AuthenticatorOption {
+	return func(a *Authenticator) error {
+		a.retry = policy
+		return nil
+	}
+}
+
+// withActivateFunc replaces the activation call, e.g., with a mock of the Coordinator.
+func withActivateFunc(activate activateFunc) AuthenticatorOption {
+	return func(a *Authenticator) error {
+		a.activate = activate
+		return nil
+	}
+}
+
+// NewAuthenticator creates an Authenticator for a marble of the type that activates with the Coordinator at coordAddr.
+//
+// It generates the marble's TLS credentials and the CSR of its marble certificate for the DNS names.
+func NewAuthenticator(coordAddr string, marbleType string, dnsNames []string, opts ...AuthenticatorOption) (*Authenticator, error) {
+	a := &Authenticator{
+		coordAddr:  coordAddr,
+		marbleType: marbleType,
+		dial:       util.Dial,
+		retry: retryPolicy{
+			timeout:        defaultActivationTimeout,
+			retries:        -1,
+			initialBackoff: initialActivationBackoff,
+			maxBackoff:     maxActivationBackoff,
+		},
+		logger: zap.NewNop(),
+	}
+	a.activate = a.activateRPC
+	for _, opt := range opts {
+		if err := opt(a); err != nil {
+			return nil, err
+		}
+	}
+	if a.issuer == nil {
+		a.issuer = ertvalidator.NewERTIssuer()
+	}
+
+	var err error
+	if a.cert, a.privk, err = util.GenerateCert(dnsNames, util.DefaultCertificateIPAddresses, false); err != nil {
+		return nil, err
+	}
+	if a.csr, err = util.GenerateCSR(dnsNames, a.privk); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// CSR returns the DER-encoded CSR of the marble certificate, which requests the DNS names of the marble.
+func (a *Authenticator) CSR() []byte {
+	return a.csr.Raw
+}
+
+// Quote issues the marble's quote for an activation with the nonce of the Coordinator.
+//
+// The quote is bound to the nonce and the public key of the CSR, or to the marble's TLS certificate if the nonce is empty because the
+// Coordinator doesn't issue nonces. If no quote can be issued, an empty quote is returned, which only a Coordinator in simulation mode accepts.
+func (a *Authenticator) Quote(nonce []byte) ([]byte, error) {
+	message := a.cert.Raw
+	if len(nonce) > 0 {
+		var err error
+		if message, err = rpc.QuoteMessage(nonce, a.csr.Raw); err != nil {
+			return nil, err
+		}
+	}
+	quote, err := a.issuer.Issue(message)
+	if err != nil {
+		a.logger.Warn("failed to get quote. Proceeding in simulation mode", zap.Error(err))
+		return []byte{}, nil
+	}
+	return quote, nil
+}
+
+// Activate activates the marble with the UUID. resumptionToken is the token of an earlier activation with the same UUID, if any.
+//
+// The activation is retried as configured by WithRetry until it succeeds, fails with a fatal error or ctx is done.
+// The error of a rejected activation carries the reason of the Coordinator, see rpc.ErrorReason.
+func (a *Authenticator) Activate(ctx context.Context, marbleUUID uuid.UUID, resumptionToken []byte) error {
+	tlsConfig := &tls.Config{
+		Certificates:       []tls.Certificate{*util.TLSCertFromDER(a.cert.Raw, a.privk)},
+		InsecureSkipVerify: true,
+	}
+	if a.roots != nil {
+		// the Coordinator's certificate is verified against the root certificate, but not its name, because the Coordinator may be reached by any address
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return util.VerifyCoordinatorCertificate(rawCerts, a.roots)
+		}
+	}
+	req := &rpc.ActivationReq{
+		CSR:             a.csr.Raw,
+		MarbleType:      a.marbleType,
+		UUID:            marbleUUID.String(),
+		APIVersion:      rpc.ActivationAPIVersion,
+		ResumptionToken: resumptionToken,
+	}
+	resp, err := activateWithRetry(ctx, a.retry, a.activate, req, a.Quote, a.coordAddr, credentials.NewTLS(tlsConfig), a.logger)
+	if err != nil {
+		return err
+	}
+	a.resp = resp
+	return nil
+}
+
+// Parameters returns the files, environment variables and Argv the manifest defines for the activated marble. It returns nil before the activation.
+func (a *Authenticator) Parameters() *rpc.Parameters {
+	return a.resp.GetParameters()
+}
+
+// Certificate returns the PEM-encoded certificate chain and private key of the activated marble's certificate.
+// They are empty before the activation and if the Coordinator doesn't issue marble certificates for heartbeats.
+func (a *Authenticator) Certificate() (certChain string, privKey string) {
+	return a.resp.GetCertificate(), a.resp.GetPrivateKey()
+}
+
+// ResumptionToken returns the token with which the marble resumes its activation when it is activated again with the same UUID.
+// It is empty if the Coordinator doesn't support resumption.
+func (a *Authenticator) ResumptionToken() []byte {
+	return a.resp.GetResumptionToken()
+}
+
+// APIVersion returns the version of the activation API the Coordinator has negotiated. Coordinators that don't negotiate a version implement version 1.
+func (a *Authenticator) APIVersion() uint32 {
+	if version := a.resp.GetAPIVersion(); version > 0 {
+		return version
+	}
+	return 1
+}
+
+// activateRPC activates the marble over the Coordinator's marble API.
+func (a *Authenticator) activateRPC(ctx context.Context, req *rpc.ActivationReq, issueQuote quoteFunc, coordAddr string, tlsCredentials credentials.TransportCredentials) (*rpc.ActivationResp, error) {
+	connection, err := grpc.Dial(coordAddr, grpc.WithTransportCredentials(tlsCredentials), grpc.WithContextDialer(a.dial))
+	if err != nil {
+		return nil, err
+	}
+	defer connection.Close()
+
+	client := rpc.NewMarbleClient(connection)
+	// the nonce is bound to the TLS certificate of this connection
+	nonceResp, err := client.Nonce(ctx, &rpc.NonceReq{})
+	if err != nil && status.Code(err) != codes.Unimplemented {
+		return nil, err
+	}
+	req.Nonce = nonceResp.GetNonce()
+	if req.Quote, err = issueQuote(req.Nonce); err != nil {
+		return nil, err
+	}
+	activationResp, err := client.Activate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	// Coordinators that don't negotiate a version implement version 1
+	if version := activationResp.GetAPIVersion(); version > rpc.ActivationAPIVersion {
+		return nil, fmt.Errorf("the Coordinator responded with unsupported activation API version %v", version)
+	}
+
+	return activationResp, nil
+}
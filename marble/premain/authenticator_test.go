@@ -0,0 +1,115 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package premain
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/edgelesssys/marblerun/util"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuthenticator(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	marbleUUID := uuid.New()
+	activate := func(ctx context.Context, req *rpc.ActivationReq, issueQuote quoteFunc, coordAddr string, tlsCredentials credentials.TransportCredentials) (*rpc.ActivationResp, error) {
+		assert.Equal("addr", coordAddr)
+		assert.Equal("type", req.MarbleType)
+		assert.Equal(marbleUUID.String(), req.UUID)
+		assert.Equal([]byte("token"), req.ResumptionToken)
+		var err error
+		req.Quote, err = issueQuote([]byte("nonce"))
+		require.NoError(err)
+		return &rpc.ActivationResp{
+			Parameters:      &rpc.Parameters{Env: map[string]string{"key": "value"}},
+			Certificate:     "cert",
+			PrivateKey:      "key",
+			ResumptionToken: []byte("new token"),
+		}, nil
+	}
+
+	authenticator, err := NewAuthenticator("addr", "type", []string{"dns"}, WithIssuer(quote.NewMockIssuer()), withActivateFunc(activate))
+	require.NoError(err)
+	csr, err := x509.ParseCertificateRequest(authenticator.CSR())
+	require.NoError(err)
+	assert.Equal([]string{"dns"}, csr.DNSNames)
+
+	// the quote is bound to the nonce and the CSR
+	marbleQuote, err := authenticator.Quote([]byte("nonce"))
+	require.NoError(err)
+	message, err := rpc.QuoteMessage([]byte("nonce"), authenticator.CSR())
+	require.NoError(err)
+	expectedQuote, err := quote.NewMockIssuer().Issue(message)
+	require.NoError(err)
+	assert.Equal(expectedQuote, marbleQuote)
+
+	assert.Nil(authenticator.Parameters())
+	require.NoError(authenticator.Activate(context.Background(), marbleUUID, []byte("token")))
+	assert.Equal("value", authenticator.Parameters().Env["key"])
+	certChain, privKey := authenticator.Certificate()
+	assert.Equal("cert", certChain)
+	assert.Equal("key", privKey)
+	assert.Equal([]byte("new token"), authenticator.ResumptionToken())
+	assert.EqualValues(1, authenticator.APIVersion())
+
+	_, err = NewAuthenticator("addr", "type", []string{"dns"}, WithRootCA("invalid"))
+	assert.Error(err)
+	_, err = NewAuthenticator("addr", "type", []string{"dns"}, WithRetry(0, 0))
+	assert.Error(err)
+}
+
+func TestAuthenticatorRootCA(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// the Coordinator doesn't implement the marble API, so the activation fails after the TLS handshake
+	cert, privk, err := util.GenerateCert([]string{"localhost"}, util.DefaultCertificateIPAddresses, false)
+	require.NoError(err)
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{Certificates: []tls.Certificate{*util.TLSCertFromDER(cert.Raw, privk)}})))
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(err)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	otherCert, _, err := util.GenerateCert([]string{"localhost"}, util.DefaultCertificateIPAddresses, false)
+	require.NoError(err)
+
+	testCases := map[string]struct {
+		rootCA   *x509.Certificate
+		wantCode codes.Code
+	}{
+		"pinned to the Coordinator": {rootCA: cert, wantCode: codes.Unimplemented},
+		"pinned to another root":    {rootCA: otherCert, wantCode: codes.Unavailable},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			rootCA := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tc.rootCA.Raw}))
+			authenticator, err := NewAuthenticator(listener.Addr().String(), "type", []string{"dns"}, WithIssuer(quote.NewMockIssuer()),
+				WithRootCA(rootCA), WithRetry(10*time.Second, 0))
+			require.NoError(err)
+			err = authenticator.Activate(context.Background(), uuid.New(), nil)
+			assert.Equal(tc.wantCode, status.Code(err))
+		})
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package premain
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/edgelesssys/marblerun/util"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// coordinatorDialer connects the activated marble to the Coordinator's marble API.
+type coordinatorDialer interface {
+	// connect returns a client of the marble API and a function that closes its connection.
+	connect() (rpc.MarbleClient, func(), error)
+	// setCertificate replaces the marble certificate with the PEM-encoded certificate chain and private key, e.g., the ones of updated parameters.
+	setCertificate(certChain string, privKey string) error
+}
+
+// renewalThreshold is the fraction of the marble certificate's lifetime after which it is renewed.
+const renewalThreshold = 2.0 / 3
+
+// renewalTimeout is the time after which a renewal of the marble certificate is given up.
+const renewalTimeout = 30 * time.Second
+
+// coordinatorConnector connects to the Coordinator with the marble certificate.
+//
+// The Coordinator's certificate is verified against the root certificate the marble received on activation,
+// so that nobody else can pose as the Coordinator and push parameters to the marble.
+// The marble certificate is renewed from the Coordinator before it expires, so that the marble stays authenticated for as long as it runs.
+type coordinatorConnector struct {
+	mux       sync.Mutex
+	cert      *tls.Certificate
+	roots     *x509.CertPool
+	coordAddr string
+	logger    *zap.Logger
+}
+
+// newCoordinatorConnector creates a coordinatorConnector from the PEM-encoded marble certificate chain, its private key and the root certificate of the Coordinator.
+func newCoordinatorConnector(certChain string, privKey string, rootCA string, coordAddr string, logger *zap.Logger) (*coordinatorConnector, error) {
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(rootCA)) {
+		return nil, errors.New("failed to parse root certificate of the Coordinator")
+	}
+	c := &coordinatorConnector{roots: roots, coordAddr: coordAddr, logger: logger}
+	if err := c.setCertificate(certChain, privKey); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *coordinatorConnector) connect() (rpc.MarbleClient, func(), error) {
+	cert, err := c.certificate()
+	if err != nil {
+		return nil, nil, err
+	}
+	connection, err := grpc.Dial(c.coordAddr, grpc.WithTransportCredentials(c.credentials(cert)), grpc.WithContextDialer(util.Dial))
+	if err != nil {
+		return nil, nil, err
+	}
+	return rpc.NewMarbleClient(connection), func() { connection.Close() }, nil
+}
+
+func (c *coordinatorConnector) setCertificate(certChain string, privKey string) error {
+	cert, err := parseMarbleCertificate(certChain, privKey)
+	if err != nil {
+		return err
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.cert = cert
+	return nil
+}
+
+// parseMarbleCertificate parses the PEM-encoded marble certificate chain and its private key.
+func parseMarbleCertificate(certChain string, privKey string) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair([]byte(certChain), []byte(privKey))
+	if err != nil {
+		return nil, err
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// certificate returns the marble certificate. It is renewed first if it has reached the renewal threshold of its lifetime.
+//
+// If the renewal fails, the current certificate is returned as long as it is valid, and the renewal is retried on the next call.
+func (c *coordinatorConnector) certificate() (tls.Certificate, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	now := time.Now()
+	leaf := c.cert.Leaf
+	lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+	if now.Before(leaf.NotBefore.Add(time.Duration(float64(lifetime) * renewalThreshold))) {
+		return *c.cert, nil
+	}
+
+	cert, err := c.renew()
+	if err != nil {
+		if now.After(leaf.NotAfter) {
+			return tls.Certificate{}, fmt.Errorf("marble certificate has expired and could not be renewed: %v", err)
+		}
+		c.logger.Warn("failed to renew marble certificate", zap.Error(err))
+		return *c.cert, nil
+	}
+	c.cert = cert
+	c.logger.Info("renewed marble certificate", zap.Time("NotAfter", cert.Leaf.NotAfter))
+	return *cert, nil
+}
+
+// renew requests a new marble certificate from the Coordinator, authenticating with the current one. The connector must be locked.
+func (c *coordinatorConnector) renew() (*tls.Certificate, error) {
+	// the Coordinator generates the key of the new certificate, the CSR only requests the names
+	csrKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := util.GenerateCSR(c.cert.Leaf.DNSNames, csrKey)
+	if err != nil {
+		return nil, err
+	}
+
+	connection, err := grpc.Dial(c.coordAddr, grpc.WithTransportCredentials(c.credentials(*c.cert)), grpc.WithContextDialer(util.Dial))
+	if err != nil {
+		return nil, err
+	}
+	defer connection.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), renewalTimeout)
+	defer cancel()
+	resp, err := rpc.NewMarbleClient(connection).Renew(ctx, &rpc.RenewalReq{CSR: csr.Raw})
+	if err != nil {
+		return nil, err
+	}
+	return parseMarbleCertificate(resp.GetCertificate(), resp.GetPrivateKey())
+}
+
+// credentials returns the transport credentials that present the certificate and verify the Coordinator.
+func (c *coordinatorConnector) credentials(cert tls.Certificate) credentials.TransportCredentials {
+	// the Coordinator's certificate is verified against the root certificate, but not its name, because the Coordinator may be reached by any address
+	return credentials.NewTLS(&tls.Config{
+		Certificates:       []tls.Certificate{cert},
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return util.VerifyCoordinatorCertificate(rawCerts, c.roots)
+		},
+	})
+}
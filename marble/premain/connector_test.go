@@ -0,0 +1,79 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package premain
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote/quotetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestCoordinatorConnectorCertificate(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	rootRaw, err := x509.CreateCertificate(rand.Reader, rootTemplate, rootTemplate, &rootKey.PublicKey, rootKey)
+	require.NoError(err)
+	rootCert, err := x509.ParseCertificate(rootRaw)
+	require.NoError(err)
+	issue := func(notBefore, notAfter time.Time) (string, string) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(err)
+		template := &x509.Certificate{SerialNumber: big.NewInt(2), Subject: pkix.Name{CommonName: "marble"}, NotBefore: notBefore, NotAfter: notAfter}
+		certRaw, err := x509.CreateCertificate(rand.Reader, template, rootCert, &key.PublicKey, rootKey)
+		require.NoError(err)
+		keyRaw, err := x509.MarshalPKCS8PrivateKey(key)
+		require.NoError(err)
+		return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certRaw})), string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyRaw}))
+	}
+
+	// the root certificate is required
+	certPEM, keyPEM := issue(time.Now().Add(-time.Minute), time.Now().Add(time.Hour))
+	_, err = newCoordinatorConnector(certPEM, keyPEM, "", "localhost:0", zap.NewNop())
+	assert.Error(err)
+
+	// a fresh certificate is used as it is
+	connector, err := newCoordinatorConnector(certPEM, keyPEM, string(quotetest.ToPEM(rootCert)), "localhost:0", zap.NewNop())
+	require.NoError(err)
+	cert, err := connector.certificate()
+	require.NoError(err)
+	assert.Equal(connector.cert.Certificate, cert.Certificate)
+
+	// a certificate that is due for renewal is used as long as it is valid if the Coordinator can't be reached
+	certPEM, keyPEM = issue(time.Now().Add(-time.Hour), time.Now().Add(time.Minute))
+	require.NoError(connector.setCertificate(certPEM, keyPEM))
+	_, err = connector.certificate()
+	assert.NoError(err)
+
+	// an expired certificate that can't be renewed is not used
+	certPEM, keyPEM = issue(time.Now().Add(-time.Hour), time.Now().Add(-time.Minute))
+	require.NoError(connector.setCertificate(certPEM, keyPEM))
+	_, err = connector.certificate()
+	assert.Error(err)
+}
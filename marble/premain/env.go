@@ -0,0 +1,77 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package premain
+
+import (
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/edgelesssys/ertgolib/marble"
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+)
+
+// configEnvPrefix is the prefix of the environment variables that configure the premain, see the config package.
+const configEnvPrefix = "EDG_MARBLE_"
+
+var (
+	scrubbedEnvMux sync.Mutex
+	// scrubbedEnv holds the values of the variables that have been removed from the environment
+	scrubbedEnv = make(map[string]string)
+)
+
+// Getenv returns the value of the environment variable like os.Getenv.
+//
+// Variables that the premain removed from the environment after the activation, because the manifest sets ScrubEnv,
+// are returned with the value they had, so that the marble package keeps working in the process.
+func Getenv(name string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	scrubbedEnvMux.Lock()
+	defer scrubbedEnvMux.Unlock()
+	return scrubbedEnv[name]
+}
+
+// scrubEnv removes the premain's configuration, the marble's certificate and key, and the variables of the parameters whose values
+// have also been written to a file from the environment, so that they don't linger in the environment of the application.
+func scrubEnv(params *rpc.Parameters) error {
+	files := fileData(params)
+	scrubbed := func(name string, value string) bool {
+		if strings.HasPrefix(name, configEnvPrefix) {
+			return true
+		}
+		switch name {
+		case marble.MarbleEnvironmentCertificate, marble.MarbleEnvironmentPrivateKey, marble.MarbleEnvironmentRootCA:
+			return true
+		}
+		// the application reads secrets that have been written to files from there
+		if _, ok := params.GetEnv()[name]; !ok || value == "" {
+			return false
+		}
+		for _, data := range files {
+			if data == value {
+				return true
+			}
+		}
+		return false
+	}
+
+	scrubbedEnvMux.Lock()
+	defer scrubbedEnvMux.Unlock()
+	for _, entry := range os.Environ() {
+		pair := strings.SplitN(entry, "=", 2)
+		if len(pair) != 2 || !scrubbed(pair[0], pair[1]) {
+			continue
+		}
+		if err := os.Unsetenv(pair[0]); err != nil {
+			return err
+		}
+		scrubbedEnv[pair[0]] = pair[1]
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package premain
+
+import (
+	"os"
+	"testing"
+
+	"github.com/edgelesssys/ertgolib/marble"
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/edgelesssys/marblerun/marble/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrubEnv(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	params := &rpc.Parameters{
+		Files:     map[string]string{"/key": "secret"},
+		FileSpecs: map[string]*rpc.File{"/token": {Data: []byte("token")}},
+		Env: map[string]string{
+			"KEY":                              "secret",
+			"TOKEN":                            "token",
+			"SETTING":                          "value",
+			marble.MarbleEnvironmentPrivateKey: "private key",
+		},
+		ScrubEnv: true,
+	}
+	env := map[string]string{config.CoordinatorAddr: "coordinator:2001", "HOME": "/root"}
+	for name, value := range params.Env {
+		env[name] = value
+	}
+	for name, value := range env {
+		require.NoError(os.Setenv(name, value))
+		defer os.Unsetenv(name)
+	}
+
+	require.NoError(scrubEnv(params))
+
+	// the configuration, the marble's key and the secrets that have been written to files are removed
+	for _, name := range []string{config.CoordinatorAddr, marble.MarbleEnvironmentPrivateKey, "KEY", "TOKEN"} {
+		_, ok := os.LookupEnv(name)
+		assert.False(ok, name)
+		// but the premain still knows them
+		assert.Equal(env[name], Getenv(name))
+	}
+	assert.Equal("value", os.Getenv("SETTING"))
+	assert.Equal("/root", os.Getenv("HOME"))
+}
@@ -0,0 +1,109 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package premain
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/spf13/afero"
+	"go.uber.org/zap"
+)
+
+// forwardedSignals are the signals that the launcher forwards to the application.
+var forwardedSignals = []os.Signal{syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGUSR1, syscall.SIGUSR2}
+
+// PreMainExec runs PreMain and subsequently launches the application as a child process.
+//
+// The first element of the Argv set in the manifest is the path of the application binary. The application inherits the environment, including the variables set in the manifest.
+// Signals received by the launcher are forwarded to the application.
+// Returns the exit code of the application, which the caller should exit with.
+func PreMainExec() (int, error) {
+	if err := PreMain(); err != nil {
+		return 0, err
+	}
+	return launch(os.Args)
+}
+
+// PreMainExecMock mocks the quoting and file system handling in the PreMainExec routine for testing.
+func PreMainExecMock() (int, error) {
+	hostfs := afero.NewOsFs()
+	return preMainExec(quote.NewFailIssuer(), nil, hostfs, hostfs)
+}
+
+// RunExec is like Run, but subsequently launches the application like PreMainExec.
+func RunExec(issuer quote.Issuer, hostfs, enclavefs afero.Fs) (int, error) {
+	return preMainExec(issuer, nil, hostfs, enclavefs)
+}
+
+func preMainExec(issuer quote.Issuer, activate activateFunc, hostfs, enclavefs afero.Fs) (int, error) {
+	if err := preMain(issuer, activate, hostfs, enclavefs); err != nil {
+		return 0, err
+	}
+	return launch(os.Args)
+}
+
+// launch starts the application given by argv, forwards signals to it until it exits and returns its exit code.
+func launch(argv []string) (int, error) {
+	logger, err := newLogger()
+	if err != nil {
+		return 0, err
+	}
+	defer logger.Sync()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+	return run(argv, signals, logger)
+}
+
+// run starts the application given by argv, forwards the signals received on the channel to it until it exits and returns its exit code.
+//
+// If the application is terminated by a signal, the exit code is 128 plus the signal number, like in a shell.
+func run(argv []string, signals <-chan os.Signal, logger *zap.Logger) (int, error) {
+	if len(argv) == 0 {
+		return 0, errors.New("no application to launch")
+	}
+
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	logger.Info("launching application", zap.String("path", argv[0]))
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				if err := cmd.Process.Signal(sig); err != nil {
+					logger.Error("failed to forward signal to application", zap.Stringer("signal", sig), zap.Error(err))
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	err := cmd.Wait()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return 0, err
+	}
+	if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal()), nil
+	}
+	return cmd.ProcessState.ExitCode(), nil
+}
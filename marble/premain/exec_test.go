@@ -0,0 +1,77 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package premain
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/edgelesssys/marblerun/marble/config"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/credentials"
+)
+
+func TestPreMainExec(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// The test will modify os.Args, restore it afterwards.
+	argsBackup := os.Args
+	defer func() { os.Args = argsBackup }()
+
+	// Mocks the coordinator. The application checks that it has been provisioned.
+	activate := func(ctx context.Context, req *rpc.ActivationReq, issueQuote quoteFunc, coordAddr string, tlsCredentials credentials.TransportCredentials) (*rpc.ActivationResp, error) {
+		return &rpc.ActivationResp{Parameters: &rpc.Parameters{
+			Env:  map[string]string{"EDG_TEST_EXEC": "env"},
+			Argv: []string{"sh", "-c", `test "$EDG_TEST_EXEC" = env && exit 3`},
+		}}, nil
+	}
+
+	require.NoError(os.Setenv(config.CoordinatorAddr, "addr"))
+	require.NoError(os.Setenv(config.Type, "type"))
+	require.NoError(os.Setenv(config.UUIDFile, "uuidfile"))
+	require.NoError(os.Setenv(config.DNSNames, "dns1,dns2"))
+	defer os.Unsetenv("EDG_TEST_EXEC")
+
+	fs := afero.NewMemMapFs()
+	exitCode, err := preMainExec(quote.NewMockIssuer(), activate, fs, fs)
+	require.NoError(err)
+	assert.Equal(3, exitCode)
+}
+
+func TestRun(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	signals := make(chan os.Signal, 1)
+
+	exitCode, err := run([]string{"sh", "-c", "exit 0"}, signals, zap.NewNop())
+	require.NoError(err)
+	assert.Equal(0, exitCode)
+
+	exitCode, err = run([]string{"sh", "-c", "exit 42"}, signals, zap.NewNop())
+	require.NoError(err)
+	assert.Equal(42, exitCode)
+
+	_, err = run(nil, signals, zap.NewNop())
+	assert.Error(err)
+	_, err = run([]string{"/does/not/exist"}, signals, zap.NewNop())
+	assert.Error(err)
+
+	// signals are forwarded to the application
+	signals <- syscall.SIGTERM
+	exitCode, err = run([]string{"sleep", "10"}, signals, zap.NewNop())
+	require.NoError(err)
+	assert.Equal(128+int(syscall.SIGTERM), exitCode)
+}
@@ -0,0 +1,126 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package premain
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/spf13/afero"
+)
+
+// inMemoryFs marks a file system whose files are kept in memory, e.g., the memfs of Edgeless RT.
+type inMemoryFs struct {
+	afero.Fs
+}
+
+// InMemory marks the file system as one whose files are kept in memory and never written to disk.
+// Files with NoDisk set in the manifest are only written to such file systems, see Run.
+func InMemory(fs afero.Fs) afero.Fs {
+	return inMemoryFs{fs}
+}
+
+// isInMemory returns whether the files of the file system are kept in memory.
+func isInMemory(fs afero.Fs) bool {
+	switch fs.(type) {
+	case inMemoryFs, *afero.MemMapFs:
+		return true
+	}
+	return false
+}
+
+// writeFile writes a file with the attributes of the manifest.
+func writeFile(fs afero.Fs, path string, file *rpc.File) error {
+	if file.GetNoDisk() && !isInMemory(fs) {
+		return fmt.Errorf("file %v must not be written to disk, but the file system isn't in memory", path)
+	}
+	uid, err := lookupID(file.GetUser(), func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	})
+	if err != nil {
+		return fmt.Errorf("file %v: %v", path, err)
+	}
+	gid, err := lookupID(file.GetGroup(), func(name string) (string, error) {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return "", err
+		}
+		return g.Gid, nil
+	})
+	if err != nil {
+		return fmt.Errorf("file %v: %v", path, err)
+	}
+
+	mode := os.FileMode(file.GetMode())
+	if mode == 0 {
+		mode = rpc.DefaultFileMode
+	}
+	if err := fs.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	// a file of an earlier activation or update may be read-only
+	if err := fs.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := afero.WriteFile(fs, path, file.GetData(), mode); err != nil {
+		return err
+	}
+	// the mode of WriteFile is subject to the umask
+	if err := fs.Chmod(path, mode); err != nil {
+		return err
+	}
+	if uid == -1 && gid == -1 {
+		return nil
+	}
+	return chown(fs, path, uid, gid)
+}
+
+// lookupID returns the numeric ID of a user or group given by name or ID, or -1 if it is empty.
+func lookupID(nameOrID string, lookup func(name string) (string, error)) (int, error) {
+	if nameOrID == "" {
+		return -1, nil
+	}
+	if id, err := strconv.Atoi(nameOrID); err == nil {
+		return id, nil
+	}
+	id, err := lookup(nameOrID)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+// chown changes the owner of a file. Only files on the OS file system have an owner.
+func chown(fs afero.Fs, path string, uid, gid int) error {
+	if _, ok := fs.(*afero.OsFs); !ok {
+		return fmt.Errorf("file %v: the file system doesn't support owners", path)
+	}
+	return os.Chown(path, uid, gid)
+}
+
+// fileData returns the data of the files of the parameters with and without attributes.
+func fileData(params *rpc.Parameters) map[string]string {
+	if len(params.GetFileSpecs()) == 0 {
+		return params.GetFiles()
+	}
+	files := make(map[string]string, len(params.GetFiles())+len(params.GetFileSpecs()))
+	for path, data := range params.GetFiles() {
+		files[path] = data
+	}
+	for path, file := range params.GetFileSpecs() {
+		files[path] = string(file.GetData())
+	}
+	return files
+}
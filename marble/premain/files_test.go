@@ -0,0 +1,67 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package premain
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFile(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// files are written with their mode, or 0600 by default
+	memfs := afero.NewMemMapFs()
+	require.NoError(writeFile(memfs, "/bin/run.sh", &rpc.File{Data: []byte("#!/bin/sh"), Mode: 0755, NoDisk: true}))
+	require.NoError(writeFile(memfs, "/key", &rpc.File{Data: []byte{0, 1, 2}}))
+	require.NoError(writeFile(memfs, "/readonly", &rpc.File{Data: []byte("old"), Mode: 0400}))
+	data, err := afero.ReadFile(memfs, "/bin/run.sh")
+	require.NoError(err)
+	assert.Equal([]byte("#!/bin/sh"), data)
+	info, err := memfs.Stat("/bin/run.sh")
+	require.NoError(err)
+	assert.Equal(os.FileMode(0755), info.Mode().Perm())
+	info, err = memfs.Stat("/key")
+	require.NoError(err)
+	assert.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	// only the OS file system has owners
+	assert.Error(writeFile(memfs, "/owned", &rpc.File{User: "0"}))
+
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	osfs := afero.NewOsFs()
+
+	// files that must not be written to disk are only written to file systems that are in memory
+	assert.Error(writeFile(osfs, filepath.Join(dir, "secret"), &rpc.File{Data: []byte("secret"), NoDisk: true}))
+	_, err = os.Stat(filepath.Join(dir, "secret"))
+	assert.True(os.IsNotExist(err))
+
+	// read-only files are replaced by updates
+	require.NoError(writeFile(osfs, filepath.Join(dir, "readonly"), &rpc.File{Data: []byte("old"), Mode: 0400}))
+	require.NoError(writeFile(osfs, filepath.Join(dir, "readonly"), &rpc.File{Data: []byte("new"), Mode: 0400}))
+	data, err = ioutil.ReadFile(filepath.Join(dir, "readonly"))
+	require.NoError(err)
+	assert.Equal([]byte("new"), data)
+
+	// files can be given to the current user
+	path := filepath.Join(dir, "owned")
+	require.NoError(writeFile(osfs, path, &rpc.File{Data: []byte("data"), User: strconv.Itoa(os.Getuid()), Group: strconv.Itoa(os.Getgid())}))
+	_, err = os.Stat(path)
+	assert.NoError(err)
+	assert.Error(writeFile(osfs, path, &rpc.File{User: "user-that-does-not-exist"}))
+}
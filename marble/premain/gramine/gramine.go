@@ -0,0 +1,77 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package gramine contains the PreMain routine for applications running in the Gramine libOS.
+//
+// Gramine only passes the environment variables to the application that are allowed by its manifest.
+// The EDG_MARBLE_* variables must be allowed with loader.env.<name> = { passthrough = true }.
+// The UUID file should be located in an encrypted mount, so that it can't be tampered with, and the files of the Marblerun manifest are created in the file system as seen by the application.
+package gramine
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/edgelesssys/marblerun/marble/config"
+	"github.com/edgelesssys/marblerun/marble/premain"
+	"github.com/spf13/afero"
+)
+
+// attestationDir is the pseudo file system that Gramine provides for remote attestation.
+const attestationDir = "/dev/attestation"
+
+// PreMain authenticates with the Coordinator and applies the parameters of the Marblerun manifest.
+func PreMain() error {
+	if err := checkEnv(); err != nil {
+		return err
+	}
+	fs := afero.NewOsFs()
+	return premain.Run(issuer{dir: attestationDir}, fs, fs)
+}
+
+// PreMainExec is like PreMain, but subsequently launches the application given by the Argv of the Marblerun manifest and returns its exit code.
+//
+// Each process is a new enclave in Gramine, so the application binary must be a trusted file of the Gramine manifest.
+func PreMainExec() (int, error) {
+	if err := checkEnv(); err != nil {
+		return 0, err
+	}
+	fs := afero.NewOsFs()
+	return premain.RunExec(issuer{dir: attestationDir}, fs, fs)
+}
+
+// checkEnv checks that the configuration has been passed through by Gramine.
+func checkEnv() error {
+	for _, name := range []string{config.CoordinatorAddr, config.Type, config.DNSNames, config.UUIDFile} {
+		if _, ok := os.LookupEnv(name); !ok {
+			return fmt.Errorf("environment variable %s is not set, it must be passed through in the Gramine manifest", name)
+		}
+	}
+	return nil
+}
+
+// issuer issues SGX DCAP quotes with Gramine's attestation pseudo file system.
+type issuer struct {
+	dir string
+}
+
+// Issue implements the Issuer interface
+func (i issuer) Issue(cert []byte) ([]byte, error) {
+	var reportData [64]byte
+	hash := sha256.Sum256(cert)
+	copy(reportData[:], hash[:])
+	if err := ioutil.WriteFile(filepath.Join(i.dir, "user_report_data"), reportData[:], 0); err != nil {
+		return nil, fmt.Errorf("failed to write report data: %v", err)
+	}
+	quote, err := ioutil.ReadFile(filepath.Join(i.dir, "quote"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quote: %v", err)
+	}
+	return quote, nil
+}
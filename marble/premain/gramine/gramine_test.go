@@ -0,0 +1,59 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package gramine
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/edgelesssys/marblerun/marble/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIssue(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// mock the attestation pseudo file system
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "quote"), []byte("quote"), 0600))
+
+	quote, err := issuer{dir: dir}.Issue([]byte("cert"))
+	require.NoError(err)
+	assert.Equal([]byte("quote"), quote)
+
+	// the report data is the hash of the certificate, padded to 64 bytes
+	reportData, err := ioutil.ReadFile(filepath.Join(dir, "user_report_data"))
+	require.NoError(err)
+	require.Len(reportData, 64)
+	hash := sha256.Sum256([]byte("cert"))
+	assert.Equal(hash[:], reportData[:32])
+	assert.Equal(make([]byte, 32), reportData[32:])
+
+	_, err = issuer{dir: filepath.Join(dir, "missing")}.Issue([]byte("cert"))
+	assert.Error(err)
+}
+
+func TestCheckEnv(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	require.NoError(os.Setenv(config.CoordinatorAddr, "addr"))
+	require.NoError(os.Setenv(config.Type, "type"))
+	require.NoError(os.Setenv(config.DNSNames, "dns"))
+	require.NoError(os.Setenv(config.UUIDFile, "uuidfile"))
+	assert.NoError(checkEnv())
+
+	require.NoError(os.Unsetenv(config.Type))
+	assert.Error(checkEnv())
+}
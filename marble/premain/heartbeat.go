@@ -0,0 +1,63 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package premain
+
+import (
+	"context"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultHeartbeatInterval is used until the Coordinator responds with its interval.
+const defaultHeartbeatInterval = 30 * time.Second
+
+// startHeartbeat starts to periodically report to the Coordinator that the marble is alive, so that its activation isn't released.
+//
+// The heartbeats are authenticated with the marble certificate, which the dialer renews before it expires. Each heartbeat uses a new connection,
+// so that it is authenticated with the current certificate. The heartbeats stop if the Coordinator rejects the certificate,
+// e.g., because it has been revoked, or if the Coordinator has already released the activation.
+func startHeartbeat(dialer coordinatorDialer, logger *zap.Logger) {
+	go heartbeat(dialer, defaultHeartbeatInterval, logger)
+}
+
+// heartbeat sends heartbeats to the Coordinator in the given interval, or in the one the Coordinator responds with, until the Coordinator rejects them.
+func heartbeat(dialer coordinatorDialer, interval time.Duration, logger *zap.Logger) {
+	for {
+		time.Sleep(interval)
+
+		resp, err := sendHeartbeat(dialer, interval)
+		switch status.Code(err) {
+		case codes.OK:
+		case codes.NotFound, codes.Unauthenticated:
+			logger.Error("Coordinator rejected heartbeat, stopping heartbeats", zap.Error(err))
+			return
+		default:
+			logger.Warn("failed to send heartbeat", zap.Error(err))
+			continue
+		}
+
+		if seconds := resp.GetInterval(); seconds > 0 {
+			interval = time.Duration(seconds) * time.Second
+		}
+	}
+}
+
+// sendHeartbeat sends a heartbeat on a new connection, which is given up after the timeout.
+func sendHeartbeat(dialer coordinatorDialer, timeout time.Duration) (*rpc.HeartbeatResp, error) {
+	client, closeConnection, err := dialer.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer closeConnection()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return client.Heartbeat(ctx, &rpc.HeartbeatReq{})
+}
@@ -0,0 +1,55 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package premain
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestHeartbeat(t *testing.T) {
+	assert := assert.New(t)
+
+	// the Coordinator fails once, then accepts two heartbeats and finally rejects the marble
+	client := &stubMarbleClient{errs: []error{errors.New("unavailable"), nil, nil, status.Error(codes.NotFound, "released")}}
+	done := make(chan struct{})
+	go func() {
+		heartbeat(&stubDialer{client: client}, time.Millisecond, zap.NewNop())
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("heartbeat didn't stop")
+	}
+	assert.Equal(4, client.calls)
+}
+
+type stubMarbleClient struct {
+	rpc.MarbleClient
+	errs  []error
+	calls int
+}
+
+func (c *stubMarbleClient) Heartbeat(ctx context.Context, in *rpc.HeartbeatReq, opts ...grpc.CallOption) (*rpc.HeartbeatResp, error) {
+	err := c.errs[c.calls]
+	c.calls++
+	if err != nil {
+		return nil, err
+	}
+	return &rpc.HeartbeatResp{}, nil
+}
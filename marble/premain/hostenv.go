@@ -0,0 +1,71 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package premain
+
+import (
+	"os"
+	"strings"
+
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+)
+
+// keptEnvPrefix is the prefix of the environment variables of Edgeless RT and the premain, which are never removed.
+const keptEnvPrefix = "EDG_"
+
+// scrubHostEnv removes the host environment variables that the parameters don't pass through.
+//
+// Manifests that don't list any HostEnv keep the whole host environment, like before the option existed.
+func scrubHostEnv(params *rpc.Parameters) error {
+	if len(params.GetHostEnv()) == 0 {
+		return nil
+	}
+	for _, entry := range os.Environ() {
+		name := strings.SplitN(entry, "=", 2)[0]
+		if strings.HasPrefix(name, keptEnvPrefix) || contains(params.GetHostEnv(), name) {
+			continue
+		}
+		if err := os.Unsetenv(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// substituteHostEnv replaces the placeholders of the host environment variables in the parameters with their values.
+// Variables that aren't set by the host are replaced by the empty string.
+func substituteHostEnv(params *rpc.Parameters) {
+	if len(params.GetHostEnv()) == 0 {
+		return
+	}
+	var replacements []string
+	for _, name := range params.GetHostEnv() {
+		replacements = append(replacements, rpc.HostEnvPlaceholder(name), os.Getenv(name))
+	}
+	replacer := strings.NewReplacer(replacements...)
+
+	for path, data := range params.Files {
+		params.Files[path] = replacer.Replace(data)
+	}
+	for _, file := range params.FileSpecs {
+		file.Data = []byte(replacer.Replace(string(file.Data)))
+	}
+	for name, value := range params.Env {
+		params.Env[name] = replacer.Replace(value)
+	}
+	for i, arg := range params.Argv {
+		params.Argv[i] = replacer.Replace(arg)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
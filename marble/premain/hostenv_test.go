@@ -0,0 +1,49 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package premain
+
+import (
+	"os"
+	"testing"
+
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostEnv(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	for name, value := range map[string]string{"OMP_NUM_THREADS": "4", "HOST_SECRET": "secret", "EDG_MARBLE_TEST": "1"} {
+		require.NoError(os.Setenv(name, value))
+		defer os.Unsetenv(name)
+	}
+
+	// the host environment is kept if no variable is passed through
+	require.NoError(scrubHostEnv(&rpc.Parameters{}))
+	assert.Equal("secret", os.Getenv("HOST_SECRET"))
+
+	params := &rpc.Parameters{
+		Files:     map[string]string{"/threads": rpc.HostEnvPlaceholder("OMP_NUM_THREADS")},
+		FileSpecs: map[string]*rpc.File{"/run.sh": {Data: []byte("export T=" + rpc.HostEnvPlaceholder("OMP_NUM_THREADS"))}},
+		Env:       map[string]string{"THREADS": rpc.HostEnvPlaceholder("OMP_NUM_THREADS"), "MISSING": rpc.HostEnvPlaceholder("UNSET")},
+		Argv:      []string{"./marble", "--threads=" + rpc.HostEnvPlaceholder("OMP_NUM_THREADS")},
+		HostEnv:   []string{"OMP_NUM_THREADS", "UNSET"},
+	}
+	require.NoError(scrubHostEnv(params))
+	assert.Equal("4", os.Getenv("OMP_NUM_THREADS"))
+	assert.Equal("1", os.Getenv("EDG_MARBLE_TEST"))
+	_, ok := os.LookupEnv("HOST_SECRET")
+	assert.False(ok)
+
+	substituteHostEnv(params)
+	assert.Equal("4", params.Files["/threads"])
+	assert.Equal([]byte("export T=4"), params.FileSpecs["/run.sh"].Data)
+	assert.Equal(map[string]string{"THREADS": "4", "MISSING": ""}, params.Env)
+	assert.Equal([]string{"./marble", "--threads=4"}, params.Argv)
+}
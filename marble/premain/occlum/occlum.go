@@ -0,0 +1,105 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package occlum contains the PreMain routine for applications running in the Occlum libOS.
+//
+// Occlum only passes the environment variables to the application that are allowed by the env.untrusted section of its Occlum.json.
+// The files of the Marblerun manifest are created in the file system of the enclave, while the UUID file is stored on the host below /host.
+package occlum
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/edgelesssys/marblerun/marble/config"
+	"github.com/edgelesssys/marblerun/marble/premain"
+	"github.com/spf13/afero"
+)
+
+const (
+	// sgxDevice is the device that Occlum provides for SGX functionality.
+	sgxDevice = "/dev/sgx"
+	// hostMount is the mount point of the host file system in Occlum's default configuration.
+	hostMount = "/host"
+
+	sgxiocGetDCAPQuoteSize = 0x80047307
+	sgxiocGenDCAPQuote     = 0xC0187308
+)
+
+// PreMain authenticates with the Coordinator and applies the parameters of the Marblerun manifest.
+func PreMain() error {
+	if err := checkEnv(); err != nil {
+		return err
+	}
+	return premain.Run(issuer{}, afero.NewBasePathFs(afero.NewOsFs(), hostMount), afero.NewOsFs())
+}
+
+// PreMainExec is like PreMain, but subsequently launches the application given by the Argv of the Marblerun manifest and returns its exit code.
+//
+// Occlum spawns the application in the same enclave, so the application binary must be part of the Occlum image.
+func PreMainExec() (int, error) {
+	if err := checkEnv(); err != nil {
+		return 0, err
+	}
+	return premain.RunExec(issuer{}, afero.NewBasePathFs(afero.NewOsFs(), hostMount), afero.NewOsFs())
+}
+
+// checkEnv checks that the configuration has been passed through by Occlum.
+func checkEnv() error {
+	for _, name := range []string{config.CoordinatorAddr, config.Type, config.DNSNames, config.UUIDFile} {
+		if _, ok := os.LookupEnv(name); !ok {
+			return fmt.Errorf("environment variable %s is not set, it must be passed through in Occlum.json", name)
+		}
+	}
+	return nil
+}
+
+// dcapQuoteArg is the argument of the SGXIOC_GEN_DCAP_QUOTE ioctl.
+type dcapQuoteArg struct {
+	reportData *[64]byte
+	quoteLen   *uint32
+	quoteBuf   *byte
+}
+
+// issuer issues SGX DCAP quotes with Occlum's SGX device.
+type issuer struct{}
+
+// Issue implements the Issuer interface
+func (issuer) Issue(cert []byte) ([]byte, error) {
+	dev, err := os.Open(sgxDevice)
+	if err != nil {
+		return nil, err
+	}
+	defer dev.Close()
+
+	var quoteLen uint32
+	if err := ioctl(dev.Fd(), sgxiocGetDCAPQuoteSize, unsafe.Pointer(&quoteLen)); err != nil {
+		return nil, fmt.Errorf("failed to get quote size: %v", err)
+	}
+	if quoteLen == 0 {
+		return nil, fmt.Errorf("invalid quote size")
+	}
+
+	var reportData [64]byte
+	hash := sha256.Sum256(cert)
+	copy(reportData[:], hash[:])
+	quote := make([]byte, quoteLen)
+	arg := dcapQuoteArg{reportData: &reportData, quoteLen: &quoteLen, quoteBuf: &quote[0]}
+	if err := ioctl(dev.Fd(), sgxiocGenDCAPQuote, unsafe.Pointer(&arg)); err != nil {
+		return nil, fmt.Errorf("failed to generate quote: %v", err)
+	}
+	return quote[:quoteLen], nil
+}
+
+func ioctl(fd uintptr, request uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -9,15 +9,13 @@ package premain
 
 import (
 	"context"
-	"crypto/ecdsa"
-	"crypto/x509"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
 	"syscall"
 
+	"github.com/edgelesssys/ertgolib/marble"
 	"github.com/edgelesssys/marblerun/coordinator/quote"
 	"github.com/edgelesssys/marblerun/coordinator/quote/ertvalidator"
 	"github.com/edgelesssys/marblerun/coordinator/rpc"
@@ -25,8 +23,9 @@ import (
 	"github.com/edgelesssys/marblerun/util"
 	"github.com/google/uuid"
 	"github.com/spf13/afero"
-	"google.golang.org/grpc"
+	"go.uber.org/zap"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 )
 
 // storeUUID stores the uuid to the fs
@@ -58,9 +57,9 @@ func readUUID(appFs afero.Fs, filename string) (*uuid.UUID, error) {
 }
 
 // getUUID loads or generates the uuid
-func getUUID(appFs afero.Fs, uuidFile string) (uuid.UUID, error) {
+func getUUID(appFs afero.Fs, uuidFile string, logger *zap.Logger) (uuid.UUID, error) {
 	// check if we have a uuid stored in the fs (means we are restarted)
-	log.Println("loading UUID")
+	logger.Info("loading UUID")
 	existingUUID, err := readUUID(appFs, uuidFile)
 	if err != nil {
 		return uuid.UUID{}, err
@@ -68,19 +67,36 @@ func getUUID(appFs afero.Fs, uuidFile string) (uuid.UUID, error) {
 
 	// generate new UUID if not present
 	if existingUUID == nil {
-		log.Println("UUID not found. Generating a new UUID")
+		logger.Info("UUID not found. Generating a new UUID")
 		return uuid.New(), nil
 	}
 
-	log.Println("found UUID:", existingUUID.String())
+	logger.Info("found UUID", zap.String("UUID", existingUUID.String()))
 	return *existingUUID, nil
 }
 
-func generateCertificate() (*x509.Certificate, *ecdsa.PrivateKey, error) {
-	marbleDNSNamesString := util.MustGetenv(config.DNSNames)
-	marbleDNSNames := strings.Split(marbleDNSNamesString, ",")
-	ipAddrs := util.DefaultCertificateIPAddresses
-	return util.GenerateCert(marbleDNSNames, ipAddrs, false)
+// resumptionTokenFile returns the file in which the resumption token of the marble is stored next to its UUID.
+func resumptionTokenFile(uuidFile string) string {
+	return uuidFile + ".token"
+}
+
+// readResumptionToken reads the token with which the marble resumes its activation. It returns nil if there is none.
+func readResumptionToken(appFs afero.Fs, uuidFile string) ([]byte, error) {
+	token, err := afero.ReadFile(appFs, resumptionTokenFile(uuidFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return token, err
+}
+
+// newLogger creates the logger of the premain, which is configured by environment variables.
+func newLogger() (*zap.Logger, error) {
+	// the launcher of PreMainExec creates its logger after the configuration may have been scrubbed
+	logger, err := util.NewLogger(Getenv(config.LogLevel), Getenv(config.DevMode) == "1")
+	if err != nil {
+		return nil, err
+	}
+	return logger.Named("premain"), nil
 }
 
 // PreMain runs before the App's actual main routine and authenticates with the Coordinator
@@ -93,141 +109,199 @@ func PreMain() error {
 	if err := syscall.Mount("/", "/", "edg_memfs", 0, ""); err != nil {
 		return err
 	}
-	enclavefs := afero.NewOsFs()
-	return preMain(ertvalidator.NewERTIssuer(), activateRPC, hostfs, enclavefs)
+	enclavefs := InMemory(afero.NewOsFs())
+	return preMain(ertvalidator.NewERTIssuer(), nil, hostfs, enclavefs)
 }
 
 // PreMainMock mocks the quoting and file system handling in the PreMain routine for testing.
 func PreMainMock() error {
 	hostfs := afero.NewOsFs()
-	return preMain(quote.NewFailIssuer(), activateRPC, hostfs, hostfs)
+	return preMain(quote.NewFailIssuer(), nil, hostfs, hostfs)
+}
+
+// Run runs the PreMain routine with the given quote issuer and file systems.
+//
+// It is the building block of premains for runtimes other than Edgeless RT, e.g., libOSes, which don't need the runtime-specific setup of PreMain.
+// The UUID file is stored in hostfs, the files of the manifest are created in enclavefs. Mark enclavefs with InMemory if its files are
+// kept in memory, so that the files of the manifest that must not be written to disk can be created.
+func Run(issuer quote.Issuer, hostfs, enclavefs afero.Fs) error {
+	return preMain(issuer, nil, hostfs, enclavefs)
 }
 
+// preMain activates the marble with an Authenticator and applies the result. activate replaces the activation call if it isn't nil.
 func preMain(issuer quote.Issuer, activate activateFunc, hostfs, enclavefs afero.Fs) error {
-	prefixBackup := log.Prefix()
-	defer log.SetPrefix(prefixBackup)
-	log.SetPrefix("[PreMain] ")
-	log.Println("starting PreMain")
+	logger, err := newLogger()
+	if err != nil {
+		return err
+	}
+	defer logger.Sync()
+	logger.Info("starting PreMain")
 
 	// get env variables
-	log.Println("fetching env variables")
+	logger.Info("fetching env variables")
 	coordAddr := util.MustGetenv(config.CoordinatorAddr)
 	marbleType := util.MustGetenv(config.Type)
 	marbleDNSNamesString := util.MustGetenv(config.DNSNames)
 	marbleDNSNames := strings.Split(marbleDNSNamesString, ",")
 	uuidFile := util.MustGetenv(config.UUIDFile)
-
-	cert, privk, err := generateCertificate()
+	logger = logger.With(zap.String("MarbleType", marbleType))
+	retry, err := retryPolicyFromEnv()
 	if err != nil {
 		return err
 	}
 
-	// Load TLS Credentials with InsecureSkipVerify enabled. (The coordinator verifies the marble, but not the other way round.)
-	log.Println("loading TLS Credentials")
-	tlsCredentials, err := util.LoadGRPCTLSCredentials(cert, privk, true)
-	if err != nil {
-		return err
+	if os.Getenv(config.Simulation) == "1" {
+		logger.Warn("running in simulation mode. The marble is not attested. DO NOT USE IN PRODUCTION!")
+		issuer = quote.NewSimulationIssuer()
+	}
+	opts := []AuthenticatorOption{WithIssuer(issuer), withRetryPolicy(retry), WithLogger(logger)}
+	if rootCA := os.Getenv(config.CoordinatorRootCA); rootCA != "" {
+		logger.Info("pinning the Coordinator's root certificate")
+		opts = append(opts, WithRootCA(rootCA))
+	}
+	if activate != nil {
+		opts = append(opts, withActivateFunc(activate))
 	}
 
-	// load or generate UUID
-	marbleUUID, err := getUUID(hostfs, uuidFile)
+	// generate the TLS credentials and the CSR
+	logger.Info("generating certificate and CSR")
+	authenticator, err := NewAuthenticator(coordAddr, marbleType, marbleDNSNames, opts...)
 	if err != nil {
 		return err
 	}
 
-	// generate CSR
-	log.Println("generating CSR")
-	csr, err := util.GenerateCSR(marbleDNSNames, privk)
+	// load or generate UUID
+	marbleUUID, err := getUUID(hostfs, uuidFile, logger)
 	if err != nil {
 		return err
 	}
+	logger = logger.With(zap.String("UUID", marbleUUID.String()))
 
-	// generate Quote
-	log.Println("generating quote")
-	if issuer == nil {
-		// default
-		issuer = ertvalidator.NewERTIssuer()
-	}
-	quote, err := issuer.Issue(cert.Raw)
+	// a restarted marble resumes its activation, so that it doesn't count towards the MaxActivations of its type again
+	resumptionToken, err := readResumptionToken(hostfs, uuidFile)
 	if err != nil {
-		log.Println("failed to get quote. Proceeding in simulation mode")
-		// If we run in SimulationMode we get an error here
-		// For testing purpose we do not want to just fail here
-		// Instead we store an empty quote that will only be accepted if the coordinator also runs in SimulationMode
-		quote = []byte{}
+		return err
 	}
 
 	// authenticate with Coordinator
-	req := &rpc.ActivationReq{
-		CSR:        csr.Raw,
-		MarbleType: marbleType,
-		Quote:      quote,
-		UUID:       marbleUUID.String(),
-	}
-	log.Println("activating marble of type", marbleType)
-	params, err := activate(req, coordAddr, tlsCredentials)
-	if err != nil {
+	logger.Info("activating marble")
+	if err := authenticator.Activate(context.Background(), marbleUUID, resumptionToken); err != nil {
+		logActivationError(logger, err)
 		return err
 	}
+	params := authenticator.Parameters()
 
 	// store UUID to file
-	log.Println("storing UUID")
+	logger.Info("storing UUID")
 	if err := storeUUID(hostfs, marbleUUID, uuidFile); err != nil {
 		return err
 	}
+	// Coordinators that don't support resumption don't send a token
+	if token := authenticator.ResumptionToken(); len(token) > 0 {
+		if err := afero.WriteFile(hostfs, resumptionTokenFile(uuidFile), token, 0600); err != nil {
+			return fmt.Errorf("failed to store resumption token to file: %v", err)
+		}
+	}
 
-	if err := applyParameters(params, enclavefs); err != nil {
+	if err := applyParameters(params, enclavefs, logger); err != nil {
 		return err
 	}
 
-	log.Println("done with PreMain")
+	if err := startTTLS(logger); err != nil {
+		return err
+	}
+	if err := startSDS(logger); err != nil {
+		return err
+	}
+	if err := startWorkloadAPI(logger); err != nil {
+		return err
+	}
+
+	// Coordinators that don't support heartbeats don't send the certificate
+	if marbleCert, marbleKey := authenticator.Certificate(); marbleCert != "" {
+		dialer, err := newCoordinatorConnector(marbleCert, marbleKey, params.Env[marble.MarbleEnvironmentRootCA], coordAddr, logger)
+		if err != nil {
+			return err
+		}
+		startHeartbeat(dialer, logger)
+		startWatch(dialer, authenticator.CSR(), enclavefs, logger)
+	}
+
+	// the premain's services have read their configuration, so it can be removed
+	if params.GetScrubEnv() {
+		logger.Info("removing configuration and secrets from env vars")
+		if err := scrubEnv(params); err != nil {
+			return err
+		}
+	}
+
+	logger.Info("done with PreMain")
 	return nil
 }
 
-type activateFunc func(req *rpc.ActivationReq, coordAddr string, tlsCredentials credentials.TransportCredentials) (*rpc.Parameters, error)
+// quoteFunc issues the marble's quote for an activation with the nonce of the Coordinator. The nonce is empty if the Coordinator doesn't issue nonces.
+type quoteFunc func(nonce []byte) ([]byte, error)
 
-func activateRPC(req *rpc.ActivationReq, coordAddr string, tlsCredentials credentials.TransportCredentials) (*rpc.Parameters, error) {
-	connection, err := grpc.Dial(coordAddr, grpc.WithTransportCredentials(tlsCredentials))
-	if err != nil {
-		return nil, err
+// activateFunc activates the marble. It sets the quote of the request.
+type activateFunc func(ctx context.Context, req *rpc.ActivationReq, issueQuote quoteFunc, coordAddr string, tlsCredentials credentials.TransportCredentials) (*rpc.ActivationResp, error)
+
+// logActivationError logs why the Coordinator rejected the activation, so that tooling can tell retryable from fatal failures.
+func logActivationError(logger *zap.Logger, err error) {
+	fields := []zap.Field{zap.Error(err), zap.Stringer("Code", status.Code(err)), zap.Bool("Retryable", rpc.IsRetryable(err))}
+	// older Coordinators don't report a reason
+	if reason, metadata := rpc.ErrorReason(err); reason != "" {
+		fields = append(fields, zap.String("Reason", reason), zap.Any("Metadata", metadata))
 	}
-	defer connection.Close()
+	logger.Error("activation failed", fields...)
+}
 
-	client := rpc.NewMarbleClient(connection)
-	activationResp, err := client.Activate(context.Background(), req)
-	if err != nil {
-		return nil, err
+func applyParameters(params *rpc.Parameters, fs afero.Fs, logger *zap.Logger) error {
+	if len(params.GetHostEnv()) > 0 {
+		logger.Info("removing host env vars that the manifest doesn't pass through", zap.Strings("HostEnv", params.GetHostEnv()))
+	}
+	if err := scrubHostEnv(params); err != nil {
+		return err
+	}
+	if err := applyFilesAndEnv(params, fs, logger); err != nil {
+		return err
 	}
 
-	return activationResp.GetParameters(), nil
+	// Set Args
+	if len(params.Argv) > 0 {
+		os.Args = params.Argv
+	} else {
+		os.Args = []string{"./marble"}
+	}
+
+	return nil
 }
 
-func applyParameters(params *rpc.Parameters, fs afero.Fs) error {
+// applyFilesAndEnv creates the files and sets the environment variables of the parameters.
+func applyFilesAndEnv(params *rpc.Parameters, fs afero.Fs, logger *zap.Logger) error {
+	substituteHostEnv(params)
+
 	// Store files in file system
-	log.Println("creating files from manifest")
+	logger.Info("creating files from manifest")
 	for path, data := range params.Files {
 		if err := fs.MkdirAll(filepath.Dir(path), 0700); err != nil {
 			return err
 		}
-		if err := afero.WriteFile(fs, path, []byte(data), 0600); err != nil {
+		if err := afero.WriteFile(fs, path, []byte(data), rpc.DefaultFileMode); err != nil {
+			return err
+		}
+	}
+	for path, file := range params.FileSpecs {
+		if err := writeFile(fs, path, file); err != nil {
 			return err
 		}
 	}
 
 	// Set environment variables
-	log.Println("setting env vars from manifest")
+	logger.Info("setting env vars from manifest")
 	for key, value := range params.Env {
 		if err := os.Setenv(key, value); err != nil {
 			return err
 		}
 	}
-
-	// Set Args
-	if len(params.Argv) > 0 {
-		os.Args = params.Argv
-	} else {
-		os.Args = []string{"./marble"}
-	}
-
 	return nil
 }
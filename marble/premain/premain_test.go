@@ -7,6 +7,7 @@
 package premain
 
 import (
+	"context"
 	"crypto/x509"
 	"errors"
 	"os"
@@ -33,14 +34,26 @@ func TestPreMain(t *testing.T) {
 	// These are returned by the activate mock function and will be set to different values to test different scenarios.
 	var parameters *rpc.Parameters
 	var activateError error
+	// lastReq is the last request the activate mock function received
+	var lastReq *rpc.ActivationReq
 
 	// Mocks the coordinator.
-	activate := func(req *rpc.ActivationReq, coordAddr string, tlsCredentials credentials.TransportCredentials) (*rpc.Parameters, error) {
+	activate := func(ctx context.Context, req *rpc.ActivationReq, issueQuote quoteFunc, coordAddr string, tlsCredentials credentials.TransportCredentials) (*rpc.ActivationResp, error) {
 		assert.Equal("addr", coordAddr)
 		assert.NotNil(tlsCredentials)
 		assert.Equal("type", req.MarbleType)
-		assert.NotEmpty(req.Quote)
-		_, err := uuid.Parse(req.UUID)
+
+		// the quote is issued over the nonce and the CSR's public key
+		nonce := []byte("nonce")
+		marbleQuote, err := issueQuote(nonce)
+		require.NoError(err)
+		message, err := rpc.QuoteMessage(nonce, req.CSR)
+		require.NoError(err)
+		expectedQuote, err := quote.NewMockIssuer().Issue(message)
+		require.NoError(err)
+		assert.Equal(expectedQuote, marbleQuote)
+		assert.EqualValues(rpc.ActivationAPIVersion, req.APIVersion)
+		_, err = uuid.Parse(req.UUID)
 		assert.NoError(err)
 
 		csr, err := x509.ParseCertificateRequest(req.CSR)
@@ -48,7 +61,8 @@ func TestPreMain(t *testing.T) {
 		assert.NoError(csr.CheckSignature())
 		assert.Equal([]string{"dns1", "dns2"}, csr.DNSNames)
 
-		return parameters, activateError
+		lastReq = req
+		return &rpc.ActivationResp{Parameters: parameters, ResumptionToken: []byte("token")}, activateError
 	}
 
 	issuer := quote.NewMockIssuer()
@@ -71,8 +85,15 @@ func TestPreMain(t *testing.T) {
 		savedUUID, err := afero.ReadFile(hostfs, "uuidfile")
 		assert.NoError(err)
 		assert.Len(savedUUID, len(uuid.UUID{}))
+		assert.Empty(lastReq.ResumptionToken)
 
 		assert.Equal([]string{"./marble"}, os.Args)
+
+		// a restarted marble resumes its activation with its UUID
+		firstUUID := lastReq.UUID
+		require.NoError(preMain(issuer, activate, hostfs, enclavefs))
+		assert.Equal(firstUUID, lastReq.UUID)
+		assert.Equal([]byte("token"), lastReq.ResumptionToken)
 	}
 	{
 		parameters = &rpc.Parameters{}
@@ -0,0 +1,93 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package premain
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/edgelesssys/marblerun/marble/config"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	defaultActivationTimeout = 5 * time.Minute
+	initialActivationBackoff = time.Second
+	maxActivationBackoff     = 30 * time.Second
+)
+
+// retryPolicy defines how often and how long a marble retries its activation.
+type retryPolicy struct {
+	// timeout is the deadline of all attempts together
+	timeout time.Duration
+	// retries is the maximum number of retries. It is negative if the marble retries until the timeout.
+	retries int
+	// the backoff starts at initialBackoff and doubles with each retry up to maxBackoff
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+// retryPolicyFromEnv returns the retry policy set by the environment variables.
+func retryPolicyFromEnv() (retryPolicy, error) {
+	policy := retryPolicy{
+		timeout:        defaultActivationTimeout,
+		retries:        -1,
+		initialBackoff: initialActivationBackoff,
+		maxBackoff:     maxActivationBackoff,
+	}
+	if timeout := os.Getenv(config.ActivationTimeout); timeout != "" {
+		var err error
+		if policy.timeout, err = time.ParseDuration(timeout); err != nil || policy.timeout <= 0 {
+			return retryPolicy{}, fmt.Errorf("%v: invalid duration %q", config.ActivationTimeout, timeout)
+		}
+	}
+	if retries := os.Getenv(config.ActivationRetries); retries != "" {
+		var err error
+		if policy.retries, err = strconv.Atoi(retries); err != nil || policy.retries < 0 {
+			return retryPolicy{}, fmt.Errorf("%v: invalid number of retries %q", config.ActivationRetries, retries)
+		}
+	}
+	return policy, nil
+}
+
+// activateWithRetry activates the marble and retries with exponential backoff as long as the Coordinator is unreachable
+// or responds with a retryable error, e.g., because it doesn't have a manifest yet. Fatal errors are returned at once.
+func activateWithRetry(ctx context.Context, policy retryPolicy, activate activateFunc, req *rpc.ActivationReq, issueQuote quoteFunc, coordAddr string,
+	tlsCredentials credentials.TransportCredentials, logger *zap.Logger) (*rpc.ActivationResp, error) {
+	ctx, cancel := context.WithTimeout(ctx, policy.timeout)
+	defer cancel()
+
+	backoff := policy.initialBackoff
+	for attempt := 1; ; attempt++ {
+		resp, err := activate(ctx, req, issueQuote, coordAddr, tlsCredentials)
+		if err == nil {
+			return resp, nil
+		}
+		if !rpc.IsRetryable(err) || policy.retries >= 0 && attempt > policy.retries {
+			return nil, err
+		}
+		logger.Warn("activation failed, retrying", zap.Error(err), zap.Int("Attempt", attempt), zap.Duration("Backoff", backoff))
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			// the last error tells why the marble couldn't be activated
+			logger.Warn("activation timed out", zap.Duration("Timeout", policy.timeout))
+			return nil, err
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > policy.maxBackoff {
+			backoff = policy.maxBackoff
+		}
+	}
+}
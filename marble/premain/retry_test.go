@@ -0,0 +1,92 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package premain
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/edgelesssys/marblerun/marble/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+func TestActivateWithRetry(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// the activate mock fails with the errors in turn, then succeeds
+	var errs []error
+	var attempts int
+	activate := func(ctx context.Context, req *rpc.ActivationReq, issueQuote quoteFunc, coordAddr string, tlsCredentials credentials.TransportCredentials) (*rpc.ActivationResp, error) {
+		_, hasDeadline := ctx.Deadline()
+		assert.True(hasDeadline)
+		attempts++
+		if attempts <= len(errs) {
+			return nil, errs[attempts-1]
+		}
+		return &rpc.ActivationResp{}, nil
+	}
+	run := func(policy retryPolicy, failures ...error) error {
+		errs, attempts = failures, 0
+		_, err := activateWithRetry(context.Background(), policy, activate, &rpc.ActivationReq{}, nil, "addr", nil, zap.NewNop())
+		return err
+	}
+	policy := retryPolicy{timeout: time.Minute, retries: -1, initialBackoff: time.Millisecond, maxBackoff: 2 * time.Millisecond}
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+	manifestMissing := rpc.ActivationError(codes.FailedPrecondition, rpc.ReasonManifestMissing, nil, "no manifest")
+
+	// the Coordinator is unreachable or has no manifest yet
+	assert.NoError(run(policy, unavailable, unavailable, manifestMissing))
+	assert.Equal(4, attempts)
+
+	// fatal errors aren't retried
+	quoteInvalid := rpc.ActivationError(codes.Unauthenticated, rpc.ReasonQuoteInvalid, nil, "invalid quote")
+	assert.Equal(quoteInvalid, run(policy, unavailable, quoteInvalid))
+	assert.Equal(2, attempts)
+
+	// the number of retries is limited
+	policy.retries = 1
+	assert.Equal(unavailable, run(policy, unavailable, unavailable))
+	assert.Equal(2, attempts)
+	policy.retries = 0
+	assert.Equal(unavailable, run(policy, unavailable))
+	assert.Equal(1, attempts)
+
+	// the marble gives up after the timeout
+	policy = retryPolicy{timeout: 10 * time.Millisecond, retries: -1, initialBackoff: time.Hour, maxBackoff: time.Hour}
+	assert.Equal(unavailable, run(policy, unavailable, unavailable))
+	assert.Equal(1, attempts)
+
+	// the policy is configured by the environment
+	defer os.Unsetenv(config.ActivationTimeout)
+	defer os.Unsetenv(config.ActivationRetries)
+	policy, err := retryPolicyFromEnv()
+	require.NoError(err)
+	assert.Equal(defaultActivationTimeout, policy.timeout)
+	assert.Equal(-1, policy.retries)
+	require.NoError(os.Setenv(config.ActivationTimeout, "10m"))
+	require.NoError(os.Setenv(config.ActivationRetries, "3"))
+	policy, err = retryPolicyFromEnv()
+	require.NoError(err)
+	assert.Equal(10*time.Minute, policy.timeout)
+	assert.Equal(3, policy.retries)
+	require.NoError(os.Setenv(config.ActivationRetries, "-1"))
+	_, err = retryPolicyFromEnv()
+	assert.Error(err)
+	require.NoError(os.Setenv(config.ActivationRetries, "3"))
+	require.NoError(os.Setenv(config.ActivationTimeout, "10"))
+	_, err = retryPolicyFromEnv()
+	assert.Error(err)
+}
@@ -0,0 +1,373 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package premain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/edgelesssys/ertgolib/marble"
+	"github.com/edgelesssys/marblerun/marble/config"
+	"github.com/edgelesssys/marblerun/util"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Names of the secrets the SDS server serves.
+const (
+	// SDSCertificateName is the marble certificate and its private key as tls_certificate
+	SDSCertificateName = "marblerun-cert"
+	// SDSRootCAName is the Coordinator's root certificate as validation_context
+	SDSRootCAName = "marblerun-ca"
+)
+
+// sdsSecretTypeURL is the type of the resources of the SDS.
+const sdsSecretTypeURL = "type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.Secret"
+
+// startSDS starts the Secret Discovery Service of Envoy on the address config.SDSAddr, if it is set.
+//
+// A sidecar Envoy obtains the marble certificate and the Coordinator's root certificate from it, so that it can terminate and originate mTLS with the marble's identity.
+// The server runs in the background for the lifetime of the process and pushes the certificates again when the Coordinator pushes updated parameters.
+func startSDS(logger *zap.Logger) error {
+	addr := os.Getenv(config.SDSAddr)
+	if addr == "" {
+		return nil
+	}
+	server := newSDSServer(logger)
+	if err := server.update(os.Getenv(marble.MarbleEnvironmentCertificate), os.Getenv(marble.MarbleEnvironmentPrivateKey), os.Getenv(marble.MarbleEnvironmentRootCA)); err != nil {
+		return err
+	}
+	OnParametersUpdate(func(_ map[string]string, env map[string]string) {
+		if err := server.update(env[marble.MarbleEnvironmentCertificate], env[marble.MarbleEnvironmentPrivateKey], env[marble.MarbleEnvironmentRootCA]); err != nil {
+			logger.Error("failed to update the secrets of the SDS", zap.Error(err))
+		}
+	})
+
+	listener, err := util.Listen(addr)
+	if err != nil {
+		return err
+	}
+	grpcServer := grpc.NewServer(grpc.CustomCodec(rawCodec{}))
+	grpcServer.RegisterService(&sdsServiceDesc, server)
+	logger.Info("starting the secret discovery service", zap.String("addr", listener.Addr().String()))
+	go func() {
+		if err := grpcServer.Serve(listener); err != nil {
+			logger.Error("secret discovery service stopped", zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+// sdsServer implements the StreamSecrets and FetchSecrets methods of Envoy's SecretDiscoveryService.
+//
+// The protos of Envoy are not vendored, the few messages of the SDS are encoded by hand. Unknown fields of requests, e.g., the node, are ignored.
+type sdsServer struct {
+	mux     sync.Mutex
+	version int
+	// secrets contains the encoded Secret messages by name
+	secrets map[string][]byte
+	// changed are notified when the secrets are updated
+	changed map[chan struct{}]struct{}
+	logger  *zap.Logger
+}
+
+// sdsHandler is the handler type of sdsServiceDesc.
+type sdsHandler interface {
+	fetchSecrets(req discoveryRequest) ([]byte, error)
+	streamSecrets(stream grpc.ServerStream) error
+}
+
+var sdsServiceDesc = grpc.ServiceDesc{
+	ServiceName: "envoy.service.secret.v3.SecretDiscoveryService",
+	HandlerType: (*sdsHandler)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "FetchSecrets",
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+			var in rawMessage
+			if err := dec(&in); err != nil {
+				return nil, err
+			}
+			req, err := decodeDiscoveryRequest(in)
+			if err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+			resp, err := srv.(sdsHandler).fetchSecrets(req)
+			if err != nil {
+				return nil, err
+			}
+			return (*rawMessage)(&resp), nil
+		},
+	}},
+	Streams: []grpc.StreamDesc{{
+		StreamName: "StreamSecrets",
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			return srv.(sdsHandler).streamSecrets(stream)
+		},
+		ServerStreams: true,
+		ClientStreams: true,
+	}},
+}
+
+func newSDSServer(logger *zap.Logger) *sdsServer {
+	return &sdsServer{changed: make(map[chan struct{}]struct{}), logger: logger}
+}
+
+// update replaces the served secrets with the PEM-encoded certificate chain, private key and root certificate.
+func (s *sdsServer) update(certChain, privk, rootCA string) error {
+	if certChain == "" || privk == "" || rootCA == "" {
+		return errors.New("the marble certificate, its private key or the root certificate is missing")
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.version++
+	s.secrets = map[string][]byte{
+		SDSCertificateName: encodeTLSCertificateSecret(SDSCertificateName, certChain, privk),
+		SDSRootCAName:      encodeValidationContextSecret(SDSRootCAName, rootCA),
+	}
+	for changed := range s.changed {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// response returns the DiscoveryResponse with the requested secrets that exist and the current version.
+func (s *sdsServer) response(names []string, nonce string) []byte {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	var resources [][]byte
+	for _, name := range names {
+		if secret, ok := s.secrets[name]; ok {
+			resources = append(resources, secret)
+		}
+	}
+	return encodeDiscoveryResponse(strconv.Itoa(s.version), resources, nonce)
+}
+
+func (s *sdsServer) fetchSecrets(req discoveryRequest) ([]byte, error) {
+	if req.typeURL != "" && req.typeURL != sdsSecretTypeURL {
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported type %v", req.typeURL)
+	}
+	return s.response(req.resourceNames, ""), nil
+}
+
+// streamSecrets sends the requested secrets whenever the requested names or the secrets change, until the stream breaks.
+func (s *sdsServer) streamSecrets(stream grpc.ServerStream) error {
+	changed := make(chan struct{}, 1)
+	s.mux.Lock()
+	s.changed[changed] = struct{}{}
+	s.mux.Unlock()
+	defer func() {
+		s.mux.Lock()
+		delete(s.changed, changed)
+		s.mux.Unlock()
+	}()
+
+	requests := make(chan discoveryRequest)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			var in rawMessage
+			if err := stream.RecvMsg(&in); err != nil {
+				errs <- err
+				return
+			}
+			req, err := decodeDiscoveryRequest(in)
+			if err != nil {
+				errs <- status.Error(codes.InvalidArgument, err.Error())
+				return
+			}
+			select {
+			case requests <- req:
+			case <-stream.Context().Done():
+				return
+			}
+		}
+	}()
+
+	var names []string
+	nonce := 0
+	send := func() error {
+		nonce++
+		resp := rawMessage(s.response(names, strconv.Itoa(nonce)))
+		return stream.SendMsg(&resp)
+	}
+	for {
+		select {
+		case err := <-errs:
+			return err
+		case req := <-requests:
+			if req.typeURL != sdsSecretTypeURL {
+				return status.Errorf(codes.InvalidArgument, "unsupported type %v", req.typeURL)
+			}
+			if req.errorMessage != "" {
+				s.logger.Warn("Envoy rejected the secrets", zap.String("version", req.versionInfo), zap.String("error", req.errorMessage))
+			}
+			// ACKs and NACKs of the latest response are only answered once the secrets change
+			if req.responseNonce == strconv.Itoa(nonce) && equalStrings(req.resourceNames, names) {
+				continue
+			}
+			names = req.resourceNames
+			if err := send(); err != nil {
+				return err
+			}
+		case <-changed:
+			if len(names) == 0 {
+				continue
+			}
+			if err := send(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// rawMessage is an encoded protobuf message.
+type rawMessage []byte
+
+// rawCodec passes rawMessages through as they are. It is used for gRPC services whose protos are not vendored.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(*rawMessage)
+	if !ok {
+		return nil, fmt.Errorf("unsupported message type %T", v)
+	}
+	return *msg, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(*rawMessage)
+	if !ok {
+		return fmt.Errorf("unsupported message type %T", v)
+	}
+	*msg = append((*msg)[:0], data...)
+	return nil
+}
+
+func (rawCodec) String() string {
+	return "proto"
+}
+
+// discoveryRequest contains the fields of an envoy.service.discovery.v3.DiscoveryRequest the SDS uses.
+type discoveryRequest struct {
+	versionInfo   string
+	resourceNames []string
+	typeURL       string
+	responseNonce string
+	// errorMessage is the message of the error_detail of a NACK
+	errorMessage string
+}
+
+func decodeDiscoveryRequest(data []byte) (discoveryRequest, error) {
+	var req discoveryRequest
+	err := decodeFields(data, func(num protowire.Number, value []byte) error {
+		switch num {
+		case 1:
+			req.versionInfo = string(value)
+		case 3:
+			req.resourceNames = append(req.resourceNames, string(value))
+		case 4:
+			req.typeURL = string(value)
+		case 5:
+			req.responseNonce = string(value)
+		case 6:
+			// google.rpc.Status
+			return decodeFields(value, func(num protowire.Number, value []byte) error {
+				if num == 2 {
+					req.errorMessage = string(value)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return req, err
+}
+
+// decodeFields calls handle for each length-delimited field of the message and skips the others.
+func decodeFields(data []byte, handle func(num protowire.Number, value []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			data = data[n:]
+			continue
+		}
+		value, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		if err := handle(num, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeDiscoveryResponse encodes an envoy.service.discovery.v3.DiscoveryResponse of Secrets.
+func encodeDiscoveryResponse(versionInfo string, secrets [][]byte, nonce string) []byte {
+	resp := appendBytesField(nil, 1, []byte(versionInfo))
+	for _, secret := range secrets {
+		// google.protobuf.Any
+		resource := appendBytesField(appendBytesField(nil, 1, []byte(sdsSecretTypeURL)), 2, secret)
+		resp = appendBytesField(resp, 2, resource)
+	}
+	resp = appendBytesField(resp, 4, []byte(sdsSecretTypeURL))
+	return appendBytesField(resp, 5, []byte(nonce))
+}
+
+// encodeTLSCertificateSecret encodes an envoy.extensions.transport_sockets.tls.v3.Secret with a TlsCertificate.
+func encodeTLSCertificateSecret(name string, certChain string, privk string) []byte {
+	tlsCertificate := appendBytesField(appendBytesField(nil, 1, inlineDataSource(certChain)), 2, inlineDataSource(privk))
+	return appendBytesField(appendBytesField(nil, 1, []byte(name)), 2, tlsCertificate)
+}
+
+// encodeValidationContextSecret encodes an envoy.extensions.transport_sockets.tls.v3.Secret with a CertificateValidationContext.
+func encodeValidationContextSecret(name string, trustedCA string) []byte {
+	validationContext := appendBytesField(nil, 1, inlineDataSource(trustedCA))
+	return appendBytesField(appendBytesField(nil, 1, []byte(name)), 4, validationContext)
+}
+
+// inlineDataSource encodes an envoy.config.core.v3.DataSource with inline_bytes.
+func inlineDataSource(data string) []byte {
+	return appendBytesField(nil, 2, []byte(data))
+}
+
+func appendBytesField(b []byte, num protowire.Number, value []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, value)
+}
@@ -0,0 +1,174 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package premain
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/marblerun/marble/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestSDS(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// without an address, nothing is started
+	os.Unsetenv(config.SDSAddr)
+	require.NoError(startSDS(zap.NewNop()))
+
+	server := newSDSServer(zap.NewNop())
+	assert.Error(server.update("cert", "", "ca"))
+	require.NoError(server.update("cert", "key", "ca"))
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(err)
+	grpcServer := grpc.NewServer(grpc.CustomCodec(rawCodec{}))
+	grpcServer.RegisterService(&sdsServiceDesc, server)
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure(), grpc.WithDefaultCallOptions(grpc.CallCustomCodec(rawCodec{})))
+	require.NoError(err)
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// fetch
+	var resp rawMessage
+	req := encodeTestDiscoveryRequest("", []string{SDSRootCAName}, "", "")
+	require.NoError(conn.Invoke(ctx, "/envoy.service.secret.v3.SecretDiscoveryService/FetchSecrets", &req, &resp))
+	version, secrets := decodeTestDiscoveryResponse(t, resp)
+	assert.Equal("1", version)
+	assert.Equal(map[string]testSecret{SDSRootCAName: {trustedCA: "ca"}}, secrets)
+
+	// stream
+	stream, err := conn.NewStream(ctx, &sdsServiceDesc.Streams[0], "/envoy.service.secret.v3.SecretDiscoveryService/StreamSecrets")
+	require.NoError(err)
+	req = encodeTestDiscoveryRequest("", []string{SDSCertificateName, SDSRootCAName, "unknown"}, "", "")
+	require.NoError(stream.SendMsg(&req))
+	require.NoError(stream.RecvMsg(&resp))
+	version, secrets = decodeTestDiscoveryResponse(t, resp)
+	assert.Equal("1", version)
+	assert.Equal(map[string]testSecret{
+		SDSCertificateName: {certificateChain: "cert", privateKey: "key"},
+		SDSRootCAName:      {trustedCA: "ca"},
+	}, secrets)
+
+	// the ACK is not answered, the next response is pushed on update
+	req = encodeTestDiscoveryRequest(version, []string{SDSCertificateName, SDSRootCAName, "unknown"}, "1", "")
+	require.NoError(stream.SendMsg(&req))
+	require.NoError(server.update("cert2", "key2", "ca2"))
+	require.NoError(stream.RecvMsg(&resp))
+	version, secrets = decodeTestDiscoveryResponse(t, resp)
+	assert.Equal("2", version)
+	assert.Equal(map[string]testSecret{
+		SDSCertificateName: {certificateChain: "cert2", privateKey: "key2"},
+		SDSRootCAName:      {trustedCA: "ca2"},
+	}, secrets)
+
+	// a NACK is not answered, a request for other names is
+	req = encodeTestDiscoveryRequest("1", []string{SDSCertificateName, SDSRootCAName, "unknown"}, "2", "invalid")
+	require.NoError(stream.SendMsg(&req))
+	req = encodeTestDiscoveryRequest(version, []string{SDSRootCAName}, "2", "")
+	require.NoError(stream.SendMsg(&req))
+	require.NoError(stream.RecvMsg(&resp))
+	version, secrets = decodeTestDiscoveryResponse(t, resp)
+	assert.Equal("2", version)
+	assert.Equal(map[string]testSecret{SDSRootCAName: {trustedCA: "ca2"}}, secrets)
+}
+
+type testSecret struct {
+	certificateChain string
+	privateKey       string
+	trustedCA        string
+}
+
+func encodeTestDiscoveryRequest(versionInfo string, names []string, nonce string, errorMessage string) rawMessage {
+	req := appendBytesField(nil, 1, []byte(versionInfo))
+	for _, name := range names {
+		req = appendBytesField(req, 3, []byte(name))
+	}
+	req = appendBytesField(req, 4, []byte(sdsSecretTypeURL))
+	req = appendBytesField(req, 5, []byte(nonce))
+	if errorMessage != "" {
+		status := protowire.AppendTag(nil, 1, protowire.VarintType)
+		status = protowire.AppendVarint(status, 3)
+		req = appendBytesField(req, 6, appendBytesField(status, 2, []byte(errorMessage)))
+	}
+	return req
+}
+
+func decodeTestDiscoveryResponse(t *testing.T, data []byte) (string, map[string]testSecret) {
+	require := require.New(t)
+	var version string
+	secrets := make(map[string]testSecret)
+	require.NoError(decodeFields(data, func(num protowire.Number, value []byte) error {
+		switch num {
+		case 1:
+			version = string(value)
+		case 2:
+			var typeURL string
+			var name string
+			var secret testSecret
+			require.NoError(decodeFields(value, func(num protowire.Number, value []byte) error {
+				if num == 1 {
+					typeURL = string(value)
+					return nil
+				}
+				return decodeFields(value, func(num protowire.Number, value []byte) error {
+					switch num {
+					case 1:
+						name = string(value)
+					case 2:
+						return decodeFields(value, func(num protowire.Number, value []byte) error {
+							if num == 1 {
+								secret.certificateChain = decodeTestDataSource(t, value)
+							} else if num == 2 {
+								secret.privateKey = decodeTestDataSource(t, value)
+							}
+							return nil
+						})
+					case 4:
+						return decodeFields(value, func(num protowire.Number, value []byte) error {
+							if num == 1 {
+								secret.trustedCA = decodeTestDataSource(t, value)
+							}
+							return nil
+						})
+					}
+					return nil
+				})
+			}))
+			require.Equal(sdsSecretTypeURL, typeURL)
+			secrets[name] = secret
+		case 4:
+			require.Equal(sdsSecretTypeURL, string(value))
+		}
+		return nil
+	}))
+	return version, secrets
+}
+
+func decodeTestDataSource(t *testing.T, data []byte) string {
+	var inline string
+	require.NoError(t, decodeFields(data, func(num protowire.Number, value []byte) error {
+		if num == 2 {
+			inline = string(value)
+		}
+		return nil
+	}))
+	return inline
+}
@@ -0,0 +1,130 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package premain
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/edgelesssys/ertgolib/marble"
+	"github.com/edgelesssys/marblerun/marble/config"
+	"go.uber.org/zap"
+)
+
+// startTTLS starts the proxies of the transparent TLS configuration that the Coordinator set on activation.
+//
+// The proxies run in the background for the lifetime of the process and use the certificate the marble received on activation.
+func startTTLS(logger *zap.Logger) error {
+	rawConfig := os.Getenv(config.TTLSConfig)
+	if rawConfig == "" {
+		return nil
+	}
+	var ttls config.TTLS
+	if err := json.Unmarshal([]byte(rawConfig), &ttls); err != nil {
+		return fmt.Errorf("failed to parse TLS configuration: %v", err)
+	}
+
+	cert, err := tls.X509KeyPair([]byte(os.Getenv(marble.MarbleEnvironmentCertificate)), []byte(os.Getenv(marble.MarbleEnvironmentPrivateKey)))
+	if err != nil {
+		return fmt.Errorf("failed to load marble certificate: %v", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(os.Getenv(marble.MarbleEnvironmentRootCA))) {
+		return errors.New("failed to parse root certificate of the Coordinator")
+	}
+
+	logger.Info("starting transparent TLS proxies")
+	for _, conn := range ttls.Outgoing {
+		listener, err := net.Listen("tcp", net.JoinHostPort("localhost", strconv.Itoa(int(conn.Port))))
+		if err != nil {
+			return err
+		}
+		tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: roots}
+		go serveOutgoing(listener, conn.Addr, tlsConfig, logger)
+	}
+	for _, conn := range ttls.Incoming {
+		tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, ClientCAs: roots, ClientAuth: tls.RequireAndVerifyClientCert}
+		if conn.DisableClientAuth {
+			tlsConfig.ClientAuth = tls.NoClientCert
+		}
+		listener, err := tls.Listen("tcp", net.JoinHostPort("", strconv.Itoa(int(conn.Port))), tlsConfig)
+		if err != nil {
+			return err
+		}
+		go serveIncoming(listener, conn.Target, logger)
+	}
+	return nil
+}
+
+// serveOutgoing accepts plaintext connections from the application and forwards them over mTLS to addr.
+func serveOutgoing(listener net.Listener, addr string, tlsConfig *tls.Config, logger *zap.Logger) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			logger.Error("transparent TLS proxy stopped", zap.Error(err))
+			return
+		}
+		go func() {
+			defer conn.Close()
+			remote, err := tls.Dial("tcp", addr, tlsConfig)
+			if err != nil {
+				logger.Error("failed to connect to marble", zap.String("address", addr), zap.Error(err))
+				return
+			}
+			defer remote.Close()
+			forward(conn, remote)
+		}()
+	}
+}
+
+// serveIncoming accepts mTLS connections and forwards them in plaintext to the application at target.
+func serveIncoming(listener net.Listener, target string, logger *zap.Logger) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			logger.Error("transparent TLS proxy stopped", zap.Error(err))
+			return
+		}
+		go func() {
+			defer conn.Close()
+			local, err := net.Dial("tcp", target)
+			if err != nil {
+				logger.Error("failed to connect to application", zap.String("address", target), zap.Error(err))
+				return
+			}
+			defer local.Close()
+			forward(conn, local)
+		}()
+	}
+}
+
+// forward copies data between the connections until both directions are done.
+func forward(a, b net.Conn) {
+	done := make(chan struct{})
+	go func() {
+		io.Copy(a, b)
+		closeWrite(a)
+		close(done)
+	}()
+	io.Copy(b, a)
+	closeWrite(b)
+	<-done
+}
+
+// closeWrite signals the end of the data to the peer of the connection.
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		c.CloseWrite()
+	}
+}
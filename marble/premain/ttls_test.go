@@ -0,0 +1,118 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package premain
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/ertgolib/marble"
+	"github.com/edgelesssys/marblerun/coordinator/quote/quotetest"
+	"github.com/edgelesssys/marblerun/marble/config"
+	"github.com/edgelesssys/marblerun/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestStartTTLS(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	defer os.Unsetenv(config.TTLSConfig)
+	defer os.Unsetenv(marble.MarbleEnvironmentRootCA)
+	defer os.Unsetenv(marble.MarbleEnvironmentCertificate)
+	defer os.Unsetenv(marble.MarbleEnvironmentPrivateKey)
+
+	// without a configuration, nothing is started
+	require.NoError(startTTLS(zap.NewNop()))
+
+	// the marble's credentials as set by the Coordinator
+	rootCert, rootKey := quotetest.MustCreateCert(t, elliptic.P256(), "Test Coordinator", true, nil, nil)
+	privk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+	serialNumber, err := util.GenerateCertificateSerialNumber()
+	require.NoError(err)
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkix.Name{CommonName: "marble"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	certRaw, err := x509.CreateCertificate(rand.Reader, &template, rootCert, &privk.PublicKey, rootKey)
+	require.NoError(err)
+	encodedPrivk, err := x509.MarshalPKCS8PrivateKey(privk)
+	require.NoError(err)
+	require.NoError(os.Setenv(marble.MarbleEnvironmentRootCA, string(quotetest.ToPEM(rootCert))))
+	require.NoError(os.Setenv(marble.MarbleEnvironmentCertificate, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certRaw}))))
+	require.NoError(os.Setenv(marble.MarbleEnvironmentPrivateKey, string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: encodedPrivk}))))
+
+	// plaintext echo server of the application
+	app, err := net.Listen("tcp", "localhost:0")
+	require.NoError(err)
+	defer app.Close()
+	go func() {
+		for {
+			conn, err := app.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	// the marble connects to itself: outgoing connections are wrapped in mTLS and unwrapped by the incoming proxy
+	incomingPort := freePort(t)
+	outgoingPort := freePort(t)
+	ttls := config.TTLS{
+		Outgoing: []config.TTLSOutgoing{{Port: outgoingPort, Addr: net.JoinHostPort("localhost", strconv.Itoa(int(incomingPort)))}},
+		Incoming: []config.TTLSIncoming{{Port: incomingPort, Target: app.Addr().String()}},
+	}
+	rawConfig, err := json.Marshal(ttls)
+	require.NoError(err)
+	require.NoError(os.Setenv(config.TTLSConfig, string(rawConfig)))
+	require.NoError(startTTLS(zap.NewNop()))
+
+	conn, err := net.Dial("tcp", net.JoinHostPort("localhost", strconv.Itoa(int(outgoingPort))))
+	require.NoError(err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(err)
+	require.NoError(conn.(*net.TCPConn).CloseWrite())
+	response, err := ioutil.ReadAll(conn)
+	require.NoError(err)
+	assert.Equal("hello", string(response))
+
+	require.NoError(os.Setenv(config.TTLSConfig, "invalid"))
+	assert.Error(startTTLS(zap.NewNop()))
+}
+
+// freePort returns a port that is currently not in use.
+func freePort(t *testing.T) uint16 {
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	return uint16(listener.Addr().(*net.TCPAddr).Port)
+}
@@ -0,0 +1,110 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package premain
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/edgelesssys/ertgolib/marble"
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/spf13/afero"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// watchRetryInterval is the time to wait before a broken parameter watch is reestablished.
+const watchRetryInterval = 10 * time.Second
+
+var (
+	updateHandlersMux sync.Mutex
+	updateHandlers    []func(files map[string]string, env map[string]string)
+)
+
+// OnParametersUpdate registers a handler that is called after updated parameters pushed by the Coordinator have been applied.
+//
+// The files have been rewritten and the environment variables have been set when the handler is called. The Argv of a running marble is not updated.
+// Applications launched by PreMainExec only see the updated files, as the environment of a running process cannot be changed.
+// Handlers are called one after another and should return quickly, so that later updates aren't delayed.
+func OnParametersUpdate(handler func(files map[string]string, env map[string]string)) {
+	updateHandlersMux.Lock()
+	defer updateHandlersMux.Unlock()
+	updateHandlers = append(updateHandlers, handler)
+}
+
+// startWatch starts to watch the marble's parameters, so that updates after a manifest update or a change of secrets are applied to the running marble.
+//
+// The watch is authenticated with the marble certificate. The Coordinator issues a new marble certificate for the CSR with each update, which replaces the one of the dialer.
+// It stops if the Coordinator rejects the certificate, if the Coordinator has released the activation, or if the Coordinator doesn't support watches.
+func startWatch(dialer coordinatorDialer, csr []byte, fs afero.Fs, logger *zap.Logger) {
+	go watchParameters(dialer, csr, fs, watchRetryInterval, logger)
+}
+
+// watchParameters applies the parameters the Coordinator pushes and reestablishes the watch after the given interval if it breaks, until the Coordinator rejects it.
+func watchParameters(dialer coordinatorDialer, csr []byte, fs afero.Fs, retryInterval time.Duration, logger *zap.Logger) {
+	for {
+		err := watchOnce(dialer, csr, fs, logger)
+		switch status.Code(err) {
+		case codes.Unimplemented:
+			logger.Info("Coordinator doesn't support pushing parameters, parameters are only updated on restart")
+			return
+		case codes.NotFound, codes.Unauthenticated:
+			logger.Error("Coordinator rejected parameter watch, stopping to watch parameters", zap.Error(err))
+			return
+		}
+		logger.Warn("parameter watch failed", zap.Error(err))
+		time.Sleep(retryInterval)
+	}
+}
+
+// watchOnce watches the parameters on a new connection until the stream breaks.
+func watchOnce(dialer coordinatorDialer, csr []byte, fs afero.Fs, logger *zap.Logger) error {
+	client, closeConnection, err := dialer.connect()
+	if err != nil {
+		return err
+	}
+	defer closeConnection()
+	stream, err := client.WatchParameters(context.Background(), &rpc.WatchParametersReq{CSR: csr})
+	if err != nil {
+		return err
+	}
+	return receiveParameters(stream, dialer, fs, logger)
+}
+
+// receiveParameters applies the parameters received on the stream until it breaks.
+func receiveParameters(stream rpc.Marble_WatchParametersClient, dialer coordinatorDialer, fs afero.Fs, logger *zap.Logger) error {
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		logger.Info("received updated parameters")
+		params := resp.GetParameters()
+		if err := applyFilesAndEnv(params, fs, logger); err != nil {
+			logger.Error("failed to apply updated parameters", zap.Error(err))
+			continue
+		}
+		if params.GetScrubEnv() {
+			if err := scrubEnv(params); err != nil {
+				logger.Error("failed to remove configuration and secrets from env vars", zap.Error(err))
+			}
+		}
+		// the watch and the heartbeats authenticate with the new certificate from now on
+		env := params.GetEnv()
+		if err := dialer.setCertificate(env[marble.MarbleEnvironmentCertificate], env[marble.MarbleEnvironmentPrivateKey]); err != nil {
+			logger.Error("failed to use updated marble certificate", zap.Error(err))
+		}
+
+		updateHandlersMux.Lock()
+		for _, handler := range updateHandlers {
+			handler(fileData(params), params.GetEnv())
+		}
+		updateHandlersMux.Unlock()
+	}
+}
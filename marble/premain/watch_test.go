@@ -0,0 +1,120 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package premain
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWatchParameters(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	handlersBackup := updateHandlers
+	defer func() { updateHandlers = handlersBackup }()
+	var updates []map[string]string
+	OnParametersUpdate(func(files map[string]string, env map[string]string) {
+		updates = append(updates, files)
+	})
+	defer os.Unsetenv("TEST_WATCH_PARAMETERS")
+
+	// the first stream breaks after an update, the second one is rejected
+	params := &rpc.Parameters{
+		Files: map[string]string{"/config/app.conf": "updated"},
+		Env:   map[string]string{"TEST_WATCH_PARAMETERS": "updated"},
+	}
+	client := &stubWatchClient{
+		streams: []*stubParametersStream{{resps: []*rpc.WatchParametersResp{{Parameters: params}}, err: errors.New("unavailable")}},
+		err:     status.Error(codes.NotFound, "released"),
+	}
+	dialer := &stubDialer{client: client}
+	fs := afero.NewMemMapFs()
+	done := make(chan struct{})
+	go func() {
+		watchParameters(dialer, []byte("csr"), fs, time.Millisecond, zap.NewNop())
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("watch didn't stop")
+	}
+	assert.Equal(2, client.calls)
+	content, err := afero.ReadFile(fs, "/config/app.conf")
+	require.NoError(err)
+	assert.Equal("updated", string(content))
+	assert.Equal("updated", os.Getenv("TEST_WATCH_PARAMETERS"))
+	assert.Equal([]map[string]string{params.Files}, updates)
+	// the connections have been closed and the certificate of the update is used
+	assert.Equal(2, dialer.connections)
+	assert.Zero(dialer.open)
+	assert.Equal(1, dialer.certificates)
+}
+
+type stubDialer struct {
+	client       rpc.MarbleClient
+	connections  int
+	open         int
+	certificates int
+}
+
+func (d *stubDialer) connect() (rpc.MarbleClient, func(), error) {
+	d.connections++
+	d.open++
+	return d.client, func() { d.open-- }, nil
+}
+
+func (d *stubDialer) setCertificate(certChain string, privKey string) error {
+	d.certificates++
+	return nil
+}
+
+type stubWatchClient struct {
+	rpc.MarbleClient
+	streams []*stubParametersStream
+	err     error
+	calls   int
+}
+
+func (c *stubWatchClient) WatchParameters(ctx context.Context, in *rpc.WatchParametersReq, opts ...grpc.CallOption) (rpc.Marble_WatchParametersClient, error) {
+	c.calls++
+	if len(c.streams) == 0 {
+		return nil, c.err
+	}
+	stream := c.streams[0]
+	c.streams = c.streams[1:]
+	return stream, nil
+}
+
+type stubParametersStream struct {
+	grpc.ClientStream
+	resps []*rpc.WatchParametersResp
+	err   error
+}
+
+func (s *stubParametersStream) Recv() (*rpc.WatchParametersResp, error) {
+	if len(s.resps) == 0 {
+		return nil, s.err
+	}
+	resp := s.resps[0]
+	s.resps = s.resps[1:]
+	return resp, nil
+}
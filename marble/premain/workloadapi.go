@@ -0,0 +1,246 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package premain
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/edgelesssys/ertgolib/marble"
+	"github.com/edgelesssys/marblerun/marble/config"
+	"github.com/edgelesssys/marblerun/util"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// spiffeEndpointSocket is the environment variable from which SPIFFE libraries like go-spiffe read the address of the Workload API.
+const spiffeEndpointSocket = "SPIFFE_ENDPOINT_SOCKET"
+
+// workloadAPIHeader is the metadata key that clients of the Workload API must send with the value "true".
+const workloadAPIHeader = "workload.spiffe.io"
+
+// startWorkloadAPI starts the X.509 part of the SPIFFE Workload API on the address config.WorkloadAPIAddr, if it is set.
+//
+// The marble certificate is served as X.509-SVID and the Coordinator's root certificate as the bundle of the trust domain, so that applications and proxies
+// that use a SPIFFE library consume the marble's identity unchanged. The manifest must set a SPIFFETrustDomain, otherwise the marble certificate has no SPIFFE ID.
+// If the address is a Unix socket and SPIFFE_ENDPOINT_SOCKET is not set, it is set to the address.
+func startWorkloadAPI(logger *zap.Logger) error {
+	addr := os.Getenv(config.WorkloadAPIAddr)
+	if addr == "" {
+		return nil
+	}
+	server := newWorkloadAPIServer()
+	if err := server.update(os.Getenv(marble.MarbleEnvironmentCertificate), os.Getenv(marble.MarbleEnvironmentPrivateKey), os.Getenv(marble.MarbleEnvironmentRootCA)); err != nil {
+		return err
+	}
+	OnParametersUpdate(func(_ map[string]string, env map[string]string) {
+		if err := server.update(env[marble.MarbleEnvironmentCertificate], env[marble.MarbleEnvironmentPrivateKey], env[marble.MarbleEnvironmentRootCA]); err != nil {
+			logger.Error("failed to update the X.509-SVID of the Workload API", zap.Error(err))
+		}
+	})
+
+	listener, err := util.Listen(addr)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(addr, "unix://") && os.Getenv(spiffeEndpointSocket) == "" {
+		if err := os.Setenv(spiffeEndpointSocket, addr); err != nil {
+			return err
+		}
+	}
+	grpcServer := grpc.NewServer(grpc.CustomCodec(rawCodec{}))
+	grpcServer.RegisterService(&workloadAPIServiceDesc, server)
+	logger.Info("starting the SPIFFE Workload API", zap.String("addr", listener.Addr().String()), zap.String("spiffeID", server.spiffeID))
+	go func() {
+		if err := grpcServer.Serve(listener); err != nil {
+			logger.Error("SPIFFE Workload API stopped", zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+// workloadAPIServer implements the FetchX509SVID and FetchX509Bundles methods of the SpiffeWorkloadAPI. The JWT methods are not implemented.
+type workloadAPIServer struct {
+	mux         sync.Mutex
+	spiffeID    string
+	trustDomain string
+	// certChain, privk and bundle are DER-encoded, certificates are concatenated
+	certChain []byte
+	privk     []byte
+	bundle    []byte
+	changed   map[chan struct{}]struct{}
+}
+
+// workloadAPIHandler is the handler type of workloadAPIServiceDesc.
+type workloadAPIHandler interface {
+	fetchX509SVID(stream grpc.ServerStream) error
+	fetchX509Bundles(stream grpc.ServerStream) error
+}
+
+var workloadAPIServiceDesc = grpc.ServiceDesc{
+	// the protos of the Workload API don't declare a package
+	ServiceName: "SpiffeWorkloadAPI",
+	HandlerType: (*workloadAPIHandler)(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "FetchX509SVID",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				return srv.(workloadAPIHandler).fetchX509SVID(stream)
+			},
+			ServerStreams: true,
+		},
+		{
+			StreamName: "FetchX509Bundles",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				return srv.(workloadAPIHandler).fetchX509Bundles(stream)
+			},
+			ServerStreams: true,
+		},
+	},
+}
+
+func newWorkloadAPIServer() *workloadAPIServer {
+	return &workloadAPIServer{changed: make(map[chan struct{}]struct{})}
+}
+
+// update replaces the served SVID with the PEM-encoded certificate chain, private key and root certificate.
+func (s *workloadAPIServer) update(certChainPEM, privkPEM, rootCAPEM string) error {
+	certChain, err := pemBlocks([]byte(certChainPEM), "CERTIFICATE")
+	if err != nil {
+		return fmt.Errorf("invalid marble certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(certChain[0])
+	if err != nil {
+		return fmt.Errorf("invalid marble certificate: %v", err)
+	}
+	var spiffeID string
+	var trustDomain string
+	for _, uri := range cert.URIs {
+		if uri.Scheme == "spiffe" {
+			spiffeID = uri.String()
+			trustDomain = "spiffe://" + uri.Host
+			break
+		}
+	}
+	if spiffeID == "" {
+		return errors.New("the marble certificate doesn't contain a SPIFFE ID, the manifest must set a SPIFFETrustDomain")
+	}
+	privk, err := pemBlocks([]byte(privkPEM), "PRIVATE KEY")
+	if err != nil {
+		return fmt.Errorf("invalid private key: %v", err)
+	}
+	bundle, err := pemBlocks([]byte(rootCAPEM), "CERTIFICATE")
+	if err != nil {
+		return fmt.Errorf("invalid root certificate: %v", err)
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.spiffeID = spiffeID
+	s.trustDomain = trustDomain
+	s.certChain = concat(certChain)
+	s.privk = privk[0]
+	s.bundle = concat(bundle)
+	for changed := range s.changed {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// fetchX509SVID streams the X509SVIDResponse with the marble's SVID whenever it changes.
+func (s *workloadAPIServer) fetchX509SVID(stream grpc.ServerStream) error {
+	return s.serve(stream, func() []byte {
+		svid := appendBytesField(nil, 1, []byte(s.spiffeID))
+		svid = appendBytesField(svid, 2, s.certChain)
+		svid = appendBytesField(svid, 3, s.privk)
+		svid = appendBytesField(svid, 4, s.bundle)
+		return appendBytesField(nil, 1, svid)
+	})
+}
+
+// fetchX509Bundles streams the X509BundlesResponse with the bundle of the trust domain whenever it changes.
+func (s *workloadAPIServer) fetchX509Bundles(stream grpc.ServerStream) error {
+	return s.serve(stream, func() []byte {
+		entry := appendBytesField(appendBytesField(nil, 1, []byte(s.trustDomain)), 2, s.bundle)
+		return appendBytesField(nil, 2, entry)
+	})
+}
+
+// serve checks the request of the stream and sends the encoded response initially and after each update, until the stream breaks.
+// encode is called with the server locked.
+func (s *workloadAPIServer) serve(stream grpc.ServerStream, encode func() []byte) error {
+	if md, _ := metadata.FromIncomingContext(stream.Context()); len(md.Get(workloadAPIHeader)) != 1 || md.Get(workloadAPIHeader)[0] != "true" {
+		return status.Errorf(codes.InvalidArgument, "security header %v is missing", workloadAPIHeader)
+	}
+	// the requests are empty
+	var req rawMessage
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+
+	changed := make(chan struct{}, 1)
+	s.mux.Lock()
+	s.changed[changed] = struct{}{}
+	s.mux.Unlock()
+	defer func() {
+		s.mux.Lock()
+		delete(s.changed, changed)
+		s.mux.Unlock()
+	}()
+
+	for {
+		s.mux.Lock()
+		resp := rawMessage(encode())
+		s.mux.Unlock()
+		if err := stream.SendMsg(&resp); err != nil {
+			return err
+		}
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-changed:
+		}
+	}
+}
+
+// pemBlocks returns the DER-encoded contents of the PEM blocks of the type. At least one block must exist.
+func pemBlocks(data []byte, blockType string) ([][]byte, error) {
+	var blocks [][]byte
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type == blockType {
+			blocks = append(blocks, block.Bytes)
+		}
+	}
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("no PEM block of type %v found", blockType)
+	}
+	return blocks, nil
+}
+
+func concat(blocks [][]byte) []byte {
+	var result []byte
+	for _, block := range blocks {
+		result = append(result, block...)
+	}
+	return result
+}
@@ -0,0 +1,157 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package premain
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote/quotetest"
+	"github.com/edgelesssys/marblerun/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestWorkloadAPI(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	rootCert, rootKey := quotetest.MustCreateCert(t, elliptic.P256(), "Test Coordinator", true, nil, nil)
+	rootPEM := string(quotetest.ToPEM(rootCert))
+	newMarbleCert := func(uris ...*url.URL) (string, string) {
+		privk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(err)
+		serialNumber, err := util.GenerateCertificateSerialNumber()
+		require.NoError(err)
+		template := x509.Certificate{
+			SerialNumber: serialNumber,
+			Subject:      pkix.Name{CommonName: "marble"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			URIs:         uris,
+		}
+		certRaw, err := x509.CreateCertificate(rand.Reader, &template, rootCert, &privk.PublicKey, rootKey)
+		require.NoError(err)
+		encodedPrivk, err := x509.MarshalPKCS8PrivateKey(privk)
+		require.NoError(err)
+		return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certRaw})), string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: encodedPrivk}))
+	}
+
+	server := newWorkloadAPIServer()
+	// the marble certificate must contain a SPIFFE ID
+	cert, privk := newMarbleCert()
+	assert.Error(server.update(cert, privk, rootPEM))
+	spiffeID := &url.URL{Scheme: "spiffe", Host: "marblerun.example.com", Path: "/frontend/1"}
+	cert, privk = newMarbleCert(spiffeID)
+	require.NoError(server.update(cert, privk, rootPEM))
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(err)
+	grpcServer := grpc.NewServer(grpc.CustomCodec(rawCodec{}))
+	grpcServer.RegisterService(&workloadAPIServiceDesc, server)
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure(), grpc.WithDefaultCallOptions(grpc.CallCustomCodec(rawCodec{})))
+	require.NoError(err)
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	fetch := func(ctx context.Context, method int) (grpc.ClientStream, error) {
+		desc := &workloadAPIServiceDesc.Streams[method]
+		stream, err := conn.NewStream(ctx, desc, "/SpiffeWorkloadAPI/"+desc.StreamName)
+		if err != nil {
+			return nil, err
+		}
+		req := rawMessage{}
+		if err := stream.SendMsg(&req); err != nil {
+			return nil, err
+		}
+		return stream, stream.CloseSend()
+	}
+
+	// the security header is required
+	stream, err := fetch(ctx, 0)
+	require.NoError(err)
+	var resp rawMessage
+	assert.Equal(codes.InvalidArgument, status.Code(stream.RecvMsg(&resp)))
+
+	ctx = metadata.AppendToOutgoingContext(ctx, workloadAPIHeader, "true")
+	stream, err = fetch(ctx, 0)
+	require.NoError(err)
+	require.NoError(stream.RecvMsg(&resp))
+	svid := decodeTestSVID(t, resp)
+	assert.Equal(spiffeID.String(), svid[1])
+	block, _ := pem.Decode([]byte(cert))
+	assert.Equal(string(block.Bytes), svid[2])
+	block, _ = pem.Decode([]byte(privk))
+	assert.Equal(string(block.Bytes), svid[3])
+	assert.Equal(string(rootCert.Raw), svid[4])
+
+	// updates are pushed
+	cert, privk = newMarbleCert(spiffeID)
+	require.NoError(server.update(cert, privk, rootPEM))
+	require.NoError(stream.RecvMsg(&resp))
+	svid = decodeTestSVID(t, resp)
+	block, _ = pem.Decode([]byte(cert))
+	assert.Equal(string(block.Bytes), svid[2])
+
+	stream, err = fetch(ctx, 1)
+	require.NoError(err)
+	require.NoError(stream.RecvMsg(&resp))
+	bundles := make(map[string]string)
+	require.NoError(decodeFields(resp, func(num protowire.Number, value []byte) error {
+		if num != 2 {
+			return nil
+		}
+		var key, bundle string
+		err := decodeFields(value, func(num protowire.Number, value []byte) error {
+			if num == 1 {
+				key = string(value)
+			} else if num == 2 {
+				bundle = string(value)
+			}
+			return nil
+		})
+		bundles[key] = bundle
+		return err
+	}))
+	assert.Equal(map[string]string{"spiffe://marblerun.example.com": string(rootCert.Raw)}, bundles)
+}
+
+// decodeTestSVID returns the fields of the single X509SVID of an X509SVIDResponse by number.
+func decodeTestSVID(t *testing.T, data []byte) map[protowire.Number]string {
+	require := require.New(t)
+	svid := make(map[protowire.Number]string)
+	count := 0
+	require.NoError(decodeFields(data, func(num protowire.Number, value []byte) error {
+		if num != 1 {
+			return nil
+		}
+		count++
+		return decodeFields(value, func(num protowire.Number, value []byte) error {
+			svid[num] = string(value)
+			return nil
+		})
+	}))
+	require.Equal(1, count)
+	return svid
+}
@@ -0,0 +1,32 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package marble
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreMain(t *testing.T) {
+	assert := assert.New(t)
+
+	runPreMainBackup := runPreMain
+	defer func() { runPreMain = runPreMainBackup }()
+
+	calls := 0
+	runPreMain = func() error {
+		calls++
+		return errors.New("test")
+	}
+
+	// the Marble is only activated once
+	assert.Error(PreMain())
+	assert.Error(PreMain())
+	assert.Equal(1, calls)
+}
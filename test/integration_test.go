@@ -220,28 +220,28 @@ func TestClientAPI(t *testing.T) {
 
 	// get certificate
 	client := http.Client{Transport: transportSkipVerify}
-	clientAPIURL := url.URL{Scheme: "https", Host: clientServerAddr, Path: "quote"}
+	clientAPIURL := url.URL{Scheme: "https", Host: clientServerAddr, Path: "api/v1/quote"}
 	resp, err := client.Get(clientAPIURL.String())
 	require.NoError(err)
 	require.Equal(http.StatusOK, resp.StatusCode)
 	quote, err := ioutil.ReadAll(resp.Body)
 	resp.Body.Close()
 	require.NoError(err)
-	cert := gjson.Get(string(quote), "Cert").String()
+	cert := gjson.Get(string(quote), "Data.Cert").String()
 	require.NotEmpty(cert)
 
 	// test with certificate
 	pool := x509.NewCertPool()
 	require.True(pool.AppendCertsFromPEM([]byte(cert)))
 	client = http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
-	clientAPIURL.Path = "manifest"
+	clientAPIURL.Path = "api/v1/manifest"
 	resp, err = client.Get(clientAPIURL.String())
 	require.NoError(err)
 	require.Equal(http.StatusOK, resp.StatusCode)
 	manifest, err := ioutil.ReadAll(resp.Body)
 	resp.Body.Close()
 	require.NoError(err)
-	assert.JSONEq(`{"ManifestSignature":""}`, string(manifest))
+	assert.JSONEq(`{"Status":"success","Data":{"ManifestSignature":""}}`, string(manifest))
 }
 
 func TestRecoveryRestoreKey(t *testing.T) {
@@ -273,14 +273,14 @@ func TestRecoveryRestoreKey(t *testing.T) {
 	// get certificate
 	log.Println("Save certificate before we try to recover.")
 	client := http.Client{Transport: transportSkipVerify}
-	clientAPIURL := url.URL{Scheme: "https", Host: clientServerAddr, Path: "quote"}
+	clientAPIURL := url.URL{Scheme: "https", Host: clientServerAddr, Path: "api/v1/quote"}
 	resp, err := client.Get(clientAPIURL.String())
 	require.NoError(err)
 	require.Equal(http.StatusOK, resp.StatusCode)
 	quote, err := ioutil.ReadAll(resp.Body)
 	resp.Body.Close()
 	require.NoError(err)
-	cert := gjson.Get(string(quote), "Cert").String()
+	cert := gjson.Get(string(quote), "Data.Cert").String()
 	require.NotEmpty(cert)
 
 	// simulate restart of coordinator
@@ -306,10 +306,10 @@ func TestRecoveryRestoreKey(t *testing.T) {
 	log.Println("Checking status...")
 	statusResponse, err := getStatus()
 	require.NoError(err)
-	assert.EqualValues(1, gjson.Get(statusResponse, "Code").Int(), "Server is not in recovery state, but should be.")
+	assert.EqualValues(1, gjson.Get(statusResponse, "Data.Code").Int(), "Server is not in recovery state, but should be.")
 
 	// Decode & Decrypt recovery data from when we set the manifest
-	key := gjson.Get(string(recoveryResponse), "EncryptionKey").String()
+	key := gjson.Get(string(recoveryResponse), "Data.EncryptionKeys.testRecKey1").String()
 	recoveryDataEncrypted, err := base64.StdEncoding.DecodeString(key)
 	require.NoError(err, "Failed to base64 decode recovery data.")
 	recoveryKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, RecoveryPrivateKey, recoveryDataEncrypted, nil)
@@ -320,14 +320,14 @@ func TestRecoveryRestoreKey(t *testing.T) {
 	log.Println("Performed recovery, now checking status again...")
 	statusResponse, err = getStatus()
 	require.NoError(err)
-	assert.EqualValues(3, gjson.Get(statusResponse, "Code").Int(), "Server is in wrong status after recovery.")
+	assert.EqualValues(3, gjson.Get(statusResponse, "Data.Code").Int(), "Server is in wrong status after recovery.")
 
 	// Test with certificate
 	log.Println("Verifying certificate after recovery, without a restart.")
 	pool := x509.NewCertPool()
 	require.True(pool.AppendCertsFromPEM([]byte(cert)))
 	client = http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
-	clientAPIURL.Path = "status"
+	clientAPIURL.Path = "api/v1/status"
 	resp, err = client.Get(clientAPIURL.String())
 	require.NoError(err)
 	resp.Body.Close()
@@ -348,7 +348,7 @@ func TestRecoveryRestoreKey(t *testing.T) {
 	log.Println("Restarted instance, now let's see if the state can be restored again successfully.")
 	statusResponse, err = getStatus()
 	require.NoError(err)
-	assert.EqualValues(3, gjson.Get(statusResponse, "Code").Int(), "Server is in wrong status after recovery.")
+	assert.EqualValues(3, gjson.Get(statusResponse, "Data.Code").Int(), "Server is in wrong status after recovery.")
 
 	// test with certificate
 	log.Println("Verifying certificate after restart.")
@@ -407,7 +407,7 @@ func TestRecoveryReset(t *testing.T) {
 	log.Println("Checking status...")
 	statusResponse, err := getStatus()
 	require.NoError(err)
-	assert.EqualValues(1, gjson.Get(statusResponse, "Code").Int(), "Server is not in recovery state, but should be.")
+	assert.EqualValues(1, gjson.Get(statusResponse, "Data.Code").Int(), "Server is not in recovery state, but should be.")
 
 	// Set manifest again
 	log.Println("Setting the Manifest")
@@ -418,7 +418,7 @@ func TestRecoveryReset(t *testing.T) {
 	log.Println("Check if the manifest was accepted and we are ready to accept Marbles")
 	statusResponse, err = getStatus()
 	require.NoError(err)
-	assert.EqualValues(3, gjson.Get(statusResponse, "Code").Int(), "Server is in wrong status after recovery.")
+	assert.EqualValues(3, gjson.Get(statusResponse, "Data.Code").Int(), "Server is in wrong status after recovery.")
 
 	// simulate restart of coordinator
 	log.Println("Simulating a restart of the coordinator enclave...")
@@ -435,7 +435,7 @@ func TestRecoveryReset(t *testing.T) {
 	log.Println("Restarted instance, now let's see if the new state can be decrypted successfully...")
 	statusResponse, err = getStatus()
 	require.NoError(err)
-	assert.EqualValues(3, gjson.Get(statusResponse, "Code").Int(), "Server is in wrong status after recovery.")
+	assert.EqualValues(3, gjson.Get(statusResponse, "Data.Code").Int(), "Server is in wrong status after recovery.")
 }
 
 type coordinatorConfig struct {
@@ -478,7 +478,7 @@ func startCoordinator(cfg coordinatorConfig) *os.Process {
 	output := startCommand(cmd)
 
 	client := http.Client{Transport: transportSkipVerify}
-	url := url.URL{Scheme: "https", Host: clientServerAddr, Path: "quote"}
+	url := url.URL{Scheme: "https", Host: clientServerAddr, Path: "api/v1/quote"}
 
 	log.Println("Coordinator starting ...")
 	for {
@@ -523,7 +523,7 @@ func setManifest(manifest core.Manifest) ([]byte, error) {
 	clientAPIURL := url.URL{
 		Scheme: "https",
 		Host:   clientServerAddr,
-		Path:   "manifest",
+		Path:   "api/v1/manifest",
 	}
 
 	manifestRaw, err := json.Marshal(manifest)
@@ -543,7 +543,7 @@ func setManifest(manifest core.Manifest) ([]byte, error) {
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("expected %v, but /manifest returned %v: %v", http.StatusOK, resp.Status, string(body))
+		return nil, fmt.Errorf("expected %v, but /api/v1/manifest returned %v: %v", http.StatusOK, resp.Status, string(body))
 	}
 
 	return body, nil
@@ -554,7 +554,7 @@ func setRecover(recoveryKey []byte) error {
 	clientAPIURL := url.URL{
 		Scheme: "https",
 		Host:   clientServerAddr,
-		Path:   "recover",
+		Path:   "api/v1/recover",
 	}
 
 	resp, err := client.Post(clientAPIURL.String(), "application/octet-stream", bytes.NewReader(recoveryKey))
@@ -569,7 +569,7 @@ func setRecover(recoveryKey []byte) error {
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("expected %v, but /recover returned %v: %v", http.StatusOK, resp.Status, string(body))
+		return fmt.Errorf("expected %v, but /api/v1/recover returned %v: %v", http.StatusOK, resp.Status, string(body))
 	}
 
 	return nil
@@ -580,7 +580,7 @@ func getStatus() (string, error) {
 	clientAPIURL := url.URL{
 		Scheme: "https",
 		Host:   clientServerAddr,
-		Path:   "status",
+		Path:   "api/v1/status",
 	}
 
 	resp, err := client.Get(clientAPIURL.String())
@@ -594,7 +594,7 @@ func getStatus() (string, error) {
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("expected %v, but /status returned %v: %v", http.StatusOK, resp.Status, string(body))
+		return "", fmt.Errorf("expected %v, but /api/v1/status returned %v: %v", http.StatusOK, resp.Status, string(body))
 	}
 
 	return string(body), nil
@@ -7,15 +7,24 @@
 package test
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/pem"
+	"math/big"
 	"strings"
+	"time"
 )
 
 var RecoveryPublicKey, RecoveryPrivateKey = generateTestRecoveryKey()
 
+// AdminCert and SecondAdminCert are certificates that can be added to the Users of a test manifest
+var AdminCert, AdminPrivateKey = generateTestUserCert("Marblerun Test Admin")
+var SecondAdminCert, SecondAdminPrivateKey = generateTestUserCert("Marblerun Second Test Admin")
+
 // ManifestJSON is a test manifest
 const ManifestJSON string = `{
 	"Packages": {
@@ -88,9 +97,6 @@ const ManifestJSON string = `{
 			}
 		}
 	},
-	"Clients": {
-		"owner": [9,9,9]
-	},
 	"Secrets": {
 		"symmetric_key_shared": {
 			"Size": 128,
@@ -128,6 +134,31 @@ const ManifestJSON string = `{
 	}
 }`
 
+// UpdateManifestJSON is a test update for ManifestJSON
+const UpdateManifestJSON string = `{
+	"Packages": {
+		"frontend": {
+			"SignerID": "1f1e1d1c1b1a191817161514131211100f0e0d0c0b0a09080706050403020100",
+			"ProductID": 44,
+			"SecurityVersion": 5,
+			"Debug": true
+		},
+		"newpackage": {
+			"UniqueID": "2f2e2d2c2b2a292827262524232221202f2e2d2c2b2a29282726252423222120"
+		}
+	},
+	"Marbles": {
+		"newmarble": {
+			"Package": "newpackage",
+			"Parameters": {
+				"Env": {
+					"SEAL_KEY": "{{ hex .Marblerun.SealKey }}"
+				}
+			}
+		}
+	}
+}`
+
 // ManifestJSONWithRecoveryKey is a test manifest with a dynamically generated RSA key
 var ManifestJSONWithRecoveryKey string = `{
 	"Packages": {
@@ -156,10 +187,9 @@ var ManifestJSONWithRecoveryKey string = `{
 			}
 		}
 	},
-	"Clients": {
-		"owner": [9,9,9]
-	},
-	"RecoveryKey": "` + strings.ReplaceAll(string(RecoveryPublicKey), "\n", "\\n") + `"
+	"RecoveryKeys": {
+		"testRecKey1": "` + strings.ReplaceAll(string(RecoveryPublicKey), "\n", "\\n") + `"
+	}
 }`
 
 // IntegrationManifestJSON is a test manifest
@@ -228,10 +258,9 @@ var IntegrationManifestJSON string = `{
 		}
 		}
 	},
-	"Clients": {
-		"owner": [9,9,9]
-	},
-	"RecoveryKey": "` + strings.ReplaceAll(string(RecoveryPublicKey), "\n", "\\n") + `"
+	"RecoveryKeys": {
+		"testRecKey1": "` + strings.ReplaceAll(string(RecoveryPublicKey), "\n", "\\n") + `"
+	}
 }`
 
 func generateTestRecoveryKey() (publicKeyPem []byte, privateKey *rsa.PrivateKey) {
@@ -252,3 +281,32 @@ func generateTestRecoveryKey() (publicKeyPem []byte, privateKey *rsa.PrivateKey)
 
 	return pem.EncodeToMemory(publicKeyBlock), key
 }
+
+func generateTestUserCert(commonName string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certRaw, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	cert, err := x509.ParseCertificate(certRaw)
+	if err != nil {
+		panic(err)
+	}
+	return cert, key
+}
+
+// CertPEM returns the PEM encoding of cert
+func CertPEM(cert *x509.Certificate) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+}
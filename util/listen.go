@@ -0,0 +1,93 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	unixScheme  = "unix://"
+	vsockScheme = "vsock://"
+)
+
+// Listen listens on the address, which is a TCP address like "localhost:2001", a Unix socket like "unix:///run/marblerun.sock"
+// or an AF_VSOCK address of the form "vsock://<CID>:<port>". vsock is only supported on Linux.
+//
+// A stale Unix socket of a previous listener is removed.
+func Listen(addr string) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(addr, unixScheme):
+		path := strings.TrimPrefix(addr, unixScheme)
+		if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(path); err != nil {
+				return nil, err
+			}
+		}
+		return net.Listen("unix", path)
+	case strings.HasPrefix(addr, vsockScheme):
+		cid, port, err := parseVsockAddr(addr)
+		if err != nil {
+			return nil, err
+		}
+		return listenVsock(cid, port)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// Dial connects to an address as accepted by Listen. It can be used as dialer of gRPC clients with grpc.WithContextDialer.
+func Dial(ctx context.Context, addr string) (net.Conn, error) {
+	switch {
+	case strings.HasPrefix(addr, unixScheme):
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, "unix", strings.TrimPrefix(addr, unixScheme))
+	case strings.HasPrefix(addr, vsockScheme):
+		cid, port, err := parseVsockAddr(addr)
+		if err != nil {
+			return nil, err
+		}
+		return dialVsock(ctx, cid, port)
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", addr)
+}
+
+// parseVsockAddr parses an address of the form "vsock://<CID>:<port>".
+func parseVsockAddr(addr string) (uint32, uint32, error) {
+	rawCID, rawPort, err := net.SplitHostPort(strings.TrimPrefix(addr, vsockScheme))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid vsock address %v: %v", addr, err)
+	}
+	cid, err := strconv.ParseUint(rawCID, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid CID of vsock address %v", addr)
+	}
+	port, err := strconv.ParseUint(rawPort, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port of vsock address %v", addr)
+	}
+	return uint32(cid), uint32(port), nil
+}
+
+// vsockAddr is the address of an AF_VSOCK socket.
+type vsockAddr struct {
+	cid  uint32
+	port uint32
+}
+
+func (a vsockAddr) Network() string {
+	return "vsock"
+}
+
+func (a vsockAddr) String() string {
+	return vsockScheme + strconv.FormatUint(uint64(a.cid), 10) + ":" + strconv.FormatUint(uint64(a.port), 10)
+}
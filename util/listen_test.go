@@ -0,0 +1,66 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package util
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenUnix(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	addr := "unix://" + filepath.Join(dir, "marblerun.sock")
+
+	listener, err := Listen(addr)
+	require.NoError(err)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("hello"))
+		conn.Close()
+	}()
+
+	conn, err := Dial(context.Background(), addr)
+	require.NoError(err)
+	data, err := ioutil.ReadAll(conn)
+	assert.NoError(err)
+	assert.Equal("hello", string(data))
+	conn.Close()
+
+	// the socket of a listener that hasn't been closed, e.g., because the process crashed, is replaced
+	listener, err = Listen(addr)
+	require.NoError(err)
+	listener.Close()
+}
+
+func TestParseVsockAddr(t *testing.T) {
+	assert := assert.New(t)
+
+	cid, port, err := parseVsockAddr("vsock://3:2001")
+	assert.NoError(err)
+	assert.EqualValues(3, cid)
+	assert.EqualValues(2001, port)
+	assert.Equal("vsock://3:2001", vsockAddr{cid: cid, port: port}.String())
+
+	_, _, err = parseVsockAddr("vsock://3")
+	assert.Error(err)
+	_, _, err = parseVsockAddr("vsock://host:2001")
+	assert.Error(err)
+}
@@ -0,0 +1,71 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package util
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// NewLogger creates a structured logger.
+//
+// It writes JSON by default. In development mode, it writes human-readable console output and shows stacktraces for warnings.
+// level is one of debug, info, warn and error. If it is empty, the level defaults to debug in development mode and info otherwise.
+func NewLogger(level string, development bool) (*zap.Logger, error) {
+	cfg := zap.NewProductionConfig()
+	if development {
+		cfg = zap.NewDevelopmentConfig()
+	}
+	if level != "" {
+		if err := cfg.Level.UnmarshalText([]byte(level)); err != nil {
+			return nil, err
+		}
+	}
+	cfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	return cfg.Build()
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx that carries the ID of the request that is being handled.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID of ctx or an empty string if it has none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+type clientAddrKey struct{}
+
+// WithClientAddr returns a copy of ctx that carries the address of the client whose request is being handled.
+func WithClientAddr(ctx context.Context, addr string) context.Context {
+	return context.WithValue(ctx, clientAddrKey{}, addr)
+}
+
+// ClientAddr returns the client address of ctx or an empty string if it has none.
+func ClientAddr(ctx context.Context) string {
+	addr, _ := ctx.Value(clientAddrKey{}).(string)
+	return addr
+}
+
+type userKey struct{}
+
+// WithUser returns a copy of ctx that carries the name of the authenticated user whose request is being handled.
+func WithUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// User returns the authenticated user of ctx or an empty string if it has none.
+func User(ctx context.Context) string {
+	user, _ := ctx.Value(userKey{}).(string)
+	return user
+}
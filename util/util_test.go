@@ -7,10 +7,13 @@
 package util
 
 import (
+	"context"
 	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
 )
 
 func TestDeriveKey(t *testing.T) {
@@ -30,3 +33,32 @@ func TestMustGetenv(t *testing.T) {
 	assert.Equal(value, MustGetenv(name))
 	assert.NoError(os.Unsetenv(name))
 }
+
+func TestNewLogger(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	logger, err := NewLogger("", false)
+	require.NoError(err)
+	assert.True(logger.Core().Enabled(zap.InfoLevel))
+	assert.False(logger.Core().Enabled(zap.DebugLevel))
+
+	logger, err = NewLogger("", true)
+	require.NoError(err)
+	assert.True(logger.Core().Enabled(zap.DebugLevel))
+
+	logger, err = NewLogger("warn", true)
+	require.NoError(err)
+	assert.False(logger.Core().Enabled(zap.InfoLevel))
+	assert.True(logger.Core().Enabled(zap.WarnLevel))
+
+	_, err = NewLogger("verbose", false)
+	assert.Error(err)
+}
+
+func TestRequestID(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Empty(RequestID(context.Background()))
+	assert.Equal("id", RequestID(WithRequestID(context.Background(), "id")))
+}
@@ -0,0 +1,152 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package util
+
+import (
+	"context"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// The net package doesn't support AF_VSOCK, so the sockets are created with raw syscalls and wrapped in os.File,
+// which uses the runtime's poller for nonblocking file descriptors.
+
+func listenVsock(cid uint32, port uint32) (net.Listener, error) {
+	fd, err := unix.Socket(unix.AF_VSOCK, unix.SOCK_STREAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrVM{CID: cid, Port: port}); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	if err := unix.Listen(fd, unix.SOMAXCONN); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("listen", err)
+	}
+	// the port may have been chosen by the kernel
+	addr := vsockAddr{cid: cid, port: port}
+	if sa, err := unix.Getsockname(fd); err == nil {
+		if vm, ok := sa.(*unix.SockaddrVM); ok {
+			addr.port = vm.Port
+		}
+	}
+	return &vsockListener{file: os.NewFile(uintptr(fd), addr.String()), addr: addr}, nil
+}
+
+type vsockListener struct {
+	file *os.File
+	addr vsockAddr
+}
+
+func (l *vsockListener) Accept() (net.Conn, error) {
+	raw, err := l.file.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var connFd int
+	var remote unix.Sockaddr
+	var acceptErr error
+	// Read waits until the socket is readable, i.e., a connection can be accepted, as long as the function returns false
+	if err := raw.Read(func(fd uintptr) bool {
+		connFd, remote, acceptErr = unix.Accept4(int(fd), unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC)
+		return acceptErr != unix.EAGAIN
+	}); err != nil {
+		return nil, err
+	}
+	if acceptErr != nil {
+		return nil, os.NewSyscallError("accept", acceptErr)
+	}
+	var remoteAddr vsockAddr
+	if vm, ok := remote.(*unix.SockaddrVM); ok {
+		remoteAddr = vsockAddr{cid: vm.CID, port: vm.Port}
+	}
+	return &vsockConn{File: os.NewFile(uintptr(connFd), remoteAddr.String()), local: l.addr, remote: remoteAddr}, nil
+}
+
+func (l *vsockListener) Close() error {
+	return l.file.Close()
+}
+
+func (l *vsockListener) Addr() net.Addr {
+	return l.addr
+}
+
+func dialVsock(ctx context.Context, cid uint32, port uint32) (net.Conn, error) {
+	fd, err := unix.Socket(unix.AF_VSOCK, unix.SOCK_STREAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	remote := vsockAddr{cid: cid, port: port}
+	connectErr := unix.Connect(fd, &unix.SockaddrVM{CID: cid, Port: port})
+	if connectErr != nil && connectErr != unix.EINPROGRESS {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("connect", connectErr)
+	}
+	file := os.NewFile(uintptr(fd), remote.String())
+
+	if connectErr == unix.EINPROGRESS {
+		// the socket becomes writable when the connection has been established or has failed
+		if deadline, ok := ctx.Deadline(); ok {
+			file.SetWriteDeadline(deadline)
+		}
+		raw, err := file.SyscallConn()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		var soErr int
+		var getsockoptErr error
+		if err := raw.Write(func(fd uintptr) bool {
+			soErr, getsockoptErr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_ERROR)
+			if getsockoptErr != nil || soErr != 0 {
+				return true
+			}
+			// the connection is still in progress if the socket has no peer yet
+			_, err := unix.Getpeername(int(fd))
+			return err != unix.ENOTCONN
+		}); err != nil {
+			file.Close()
+			return nil, err
+		}
+		if getsockoptErr != nil {
+			file.Close()
+			return nil, os.NewSyscallError("getsockopt", getsockoptErr)
+		}
+		if soErr != 0 {
+			file.Close()
+			return nil, os.NewSyscallError("connect", unix.Errno(soErr))
+		}
+		file.SetWriteDeadline(time.Time{})
+	}
+
+	var local vsockAddr
+	if sa, err := unix.Getsockname(fd); err == nil {
+		if vm, ok := sa.(*unix.SockaddrVM); ok {
+			local = vsockAddr{cid: vm.CID, port: vm.Port}
+		}
+	}
+	return &vsockConn{File: file, local: local, remote: remote}, nil
+}
+
+// vsockConn is a connection of an AF_VSOCK socket. Read, Write, Close and the deadlines are implemented by os.File.
+type vsockConn struct {
+	*os.File
+	local  vsockAddr
+	remote vsockAddr
+}
+
+func (c *vsockConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *vsockConn) RemoteAddr() net.Addr {
+	return c.remote
+}
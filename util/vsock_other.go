@@ -0,0 +1,25 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// +build !linux
+
+package util
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+var errVsockUnsupported = errors.New("vsock is only supported on Linux")
+
+func listenVsock(cid uint32, port uint32) (net.Listener, error) {
+	return nil, errVsockUnsupported
+}
+
+func dialVsock(ctx context.Context, cid uint32, port uint32) (net.Conn, error) {
+	return nil, errVsockUnsupported
+}
@@ -0,0 +1,241 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package verify verifies the certificates of Marbles for relying parties outside the mesh, e.g., API gateways.
+//
+// The relying party obtains the Coordinator's root certificate through the attested client API, e.g., with attestation.VerifyCoordinator,
+// and verifies the certificates that Marbles present against it. The Marbles are authorized by the type and package the Coordinator
+// encodes in their certificates, and revoked certificates are rejected with the CRLs the Coordinator serves.
+// The package doesn't depend on code that can only be built for enclaves.
+package verify
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/edgelesssys/marblerun/marble/identity"
+)
+
+// maxCRLSize limits the size of the CRLs that are fetched from the distribution points.
+const maxCRLSize = 10 << 20
+
+// Config restricts the Marbles a Verifier accepts and configures how revoked certificates are detected.
+type Config struct {
+	// MarbleTypes are the accepted types of the Marbles. Any type is accepted if it is empty.
+	MarbleTypes []string
+	// Packages are the accepted packages of the Marbles. Any package is accepted if it is empty.
+	Packages []string
+	// HTTPClient fetches the CRLs from the distribution points of the certificates. If it is nil, a client that trusts
+	// the root certificate is used, which suits the Coordinator's CRL endpoint.
+	HTTPClient *http.Client
+	// RequireCRL rejects certificates without a CRL distribution point, whose revocation can't be checked. The Coordinator only
+	// embeds a distribution point if EDG_COORDINATOR_CRL_URL is set.
+	RequireCRL bool
+	// SkipRevocationCheck accepts certificates without checking whether they have been revoked.
+	SkipRevocationCheck bool
+}
+
+// Verifier verifies the certificates of Marbles against the root certificate of a Coordinator. It is safe for concurrent use.
+//
+// The CRLs are cached until their next update.
+type Verifier struct {
+	roots  *x509.CertPool
+	config Config
+	client *http.Client
+
+	mux  sync.Mutex
+	crls map[string]*pkix.CertificateList
+
+	now func() time.Time
+}
+
+// NewVerifier returns a Verifier for the Marbles of the Coordinator with the given root certificate.
+//
+// The root certificate must have been verified through the Coordinator's quote.
+func NewVerifier(rootCert *x509.Certificate, config Config) *Verifier {
+	roots := x509.NewCertPool()
+	roots.AddCert(rootCert)
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}},
+		}
+	}
+	return &Verifier{
+		roots:  roots,
+		config: config,
+		client: client,
+		crls:   make(map[string]*pkix.CertificateList),
+		now:    time.Now,
+	}
+}
+
+// Verify verifies the certificate chain that a Marble presents, i.e., its certificate followed by the CA of its package,
+// and returns the Marble's identity.
+//
+// The chain must lead to the root certificate, the Marble must be of an accepted type and package, and its certificate must not be revoked.
+func (v *Verifier) Verify(chain []*x509.Certificate) (identity.Identity, error) {
+	if len(chain) == 0 {
+		return identity.Identity{}, errors.New("no certificate presented")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	verifiedChains, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         v.roots,
+		Intermediates: intermediates,
+		CurrentTime:   v.now(),
+		// marble certificates authenticate both servers and clients
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return identity.Identity{}, fmt.Errorf("the certificate has not been issued by the Coordinator: %v", err)
+	}
+
+	for _, verifiedChain := range verifiedChains {
+		var id identity.Identity
+		if id, err = v.verifyChain(verifiedChain); err == nil {
+			return id, nil
+		}
+	}
+	return identity.Identity{}, err
+}
+
+// VerifyPeerCertificate verifies the certificates that a Marble presents in a TLS handshake like Verify. It can be set as the
+// VerifyPeerCertificate of a tls.Config, as it doesn't rely on the chains that crypto/tls has verified: set ClientAuth to
+// tls.RequireAnyClientCert to authenticate Marbles as clients, or InsecureSkipVerify to connect to Marbles as a client.
+func (v *Verifier) VerifyPeerCertificate(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	chain := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		chain[i] = cert
+	}
+	_, err := v.Verify(chain)
+	return err
+}
+
+// verifyChain verifies that the Marble the verified chain has been issued to is accepted and that its certificate isn't revoked.
+func (v *Verifier) verifyChain(chain []*x509.Certificate) (identity.Identity, error) {
+	// the Coordinator issues marble certificates with the CA of the marble's package
+	if len(chain) < 3 {
+		return identity.Identity{}, errors.New("the certificate has not been issued by a package CA of the Coordinator")
+	}
+	id, err := identity.FromChain(chain)
+	if err != nil {
+		return identity.Identity{}, err
+	}
+	if len(v.config.MarbleTypes) > 0 {
+		if id.MarbleType == "" {
+			return identity.Identity{}, errors.New("the certificate doesn't name its marble type: it contains neither an identity nor a SPIFFE ID")
+		}
+		if !contains(v.config.MarbleTypes, id.MarbleType) {
+			return identity.Identity{}, fmt.Errorf("marble %v has marble type %q, which is not accepted", id.UUID, id.MarbleType)
+		}
+	}
+	if len(v.config.Packages) > 0 && !contains(v.config.Packages, id.Package) {
+		return identity.Identity{}, fmt.Errorf("marble %v has package %q, which is not accepted", id.UUID, id.Package)
+	}
+	if err := v.checkRevocation(chain[0], chain[1]); err != nil {
+		return identity.Identity{}, err
+	}
+	return id, nil
+}
+
+// checkRevocation checks that the certificate isn't listed in the CRL of its distribution points, which must be signed by its issuer.
+// A certificate is rejected if none of its CRLs can be obtained.
+func (v *Verifier) checkRevocation(cert *x509.Certificate, issuer *x509.Certificate) error {
+	if v.config.SkipRevocationCheck {
+		return nil
+	}
+	if len(cert.CRLDistributionPoints) == 0 {
+		if v.config.RequireCRL {
+			return errors.New("the certificate has no CRL distribution point, so its revocation can't be checked")
+		}
+		return nil
+	}
+
+	var err error
+	for _, crlURL := range cert.CRLDistributionPoints {
+		var crl *pkix.CertificateList
+		if crl, err = v.getCRL(crlURL, issuer); err != nil {
+			continue
+		}
+		for _, revoked := range crl.TBSCertList.RevokedCertificates {
+			if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return fmt.Errorf("the certificate of marble %v has been revoked at %v", cert.Subject.CommonName, revoked.RevocationTime)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("checking the revocation of the certificate failed: %v", err)
+}
+
+// getCRL returns the CRL of the distribution point, which must be signed by the issuer. It is fetched anew once it has expired.
+func (v *Verifier) getCRL(crlURL string, issuer *x509.Certificate) (*pkix.CertificateList, error) {
+	now := v.now()
+	v.mux.Lock()
+	crl, ok := v.crls[crlURL]
+	v.mux.Unlock()
+	if !ok || crl.HasExpired(now) {
+		var err error
+		if crl, err = v.fetchCRL(crlURL); err != nil {
+			return nil, err
+		}
+		if crl.HasExpired(now) {
+			return nil, fmt.Errorf("the CRL of %s has expired", crlURL)
+		}
+	}
+	// the CRL is only cached once its signature has been verified
+	if err := issuer.CheckCRLSignature(crl); err != nil {
+		return nil, fmt.Errorf("the CRL of %s has not been signed by the issuer of the certificate: %v", crlURL, err)
+	}
+	v.mux.Lock()
+	v.crls[crlURL] = crl
+	v.mux.Unlock()
+	return crl, nil
+}
+
+func (v *Verifier) fetchCRL(crlURL string) (*pkix.CertificateList, error) {
+	resp, err := v.client.Get(crlURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching the CRL of %s failed: %s", crlURL, resp.Status)
+	}
+	der, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxCRLSize))
+	if err != nil {
+		return nil, err
+	}
+	crl, err := x509.ParseDERCRL(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CRL of %s: %v", crlURL, err)
+	}
+	return crl, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package verify
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote/quotetest"
+	"github.com/edgelesssys/marblerun/marble/identity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	rootCert, rootKey := quotetest.MustCreateCert(t, elliptic.P256(), "Coordinator", true, nil, nil)
+	caCert, caKey := quotetest.MustCreateCert(t, elliptic.P256(), "backend_package", true, rootCert, rootKey)
+
+	var revoked []pkix.RevokedCertificate
+	crlRequests := 0
+	crlSigner, crlSignerKey := caCert, caKey
+	crlServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		crlRequests++
+		crl, err := crlSigner.CreateCRL(rand.Reader, crlSignerKey, revoked, time.Now(), time.Now().Add(time.Hour))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(crl)
+	}))
+	defer crlServer.Close()
+	crlURL := crlServer.URL + "/api/v1/crl?" + url.Values{"package": {"backend_package"}}.Encode()
+
+	serial := int64(0)
+	newMarbleCert := func(id identity.Identity, crlURLs ...string) *x509.Certificate {
+		serial++
+		ext, err := id.Extension()
+		require.NoError(err)
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(err)
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(serial),
+			Subject:               pkix.Name{CommonName: id.UUID},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			KeyUsage:              x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			BasicConstraintsValid: true,
+			CRLDistributionPoints: crlURLs,
+			ExtraExtensions:       []pkix.Extension{ext},
+		}
+		raw, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		require.NoError(err)
+		cert, err := x509.ParseCertificate(raw)
+		require.NoError(err)
+		return cert
+	}
+
+	backendID := identity.Identity{MarbleType: "backend", Package: "backend_package", UUID: "uuid-1"}
+	backendCert := newMarbleCert(backendID, crlURL)
+	verifier := NewVerifier(rootCert, Config{MarbleTypes: []string{"backend"}, HTTPClient: crlServer.Client()})
+
+	id, err := verifier.Verify([]*x509.Certificate{backendCert, caCert})
+	require.NoError(err)
+	assert.Equal(backendID, id)
+	assert.NoError(verifier.VerifyPeerCertificate([][]byte{backendCert.Raw, caCert.Raw}, nil))
+	// the CRL is cached
+	assert.Equal(1, crlRequests)
+
+	// other marble types are rejected
+	frontendCert := newMarbleCert(identity.Identity{MarbleType: "frontend", Package: "backend_package", UUID: "uuid-2"}, crlURL)
+	_, err = verifier.Verify([]*x509.Certificate{frontendCert, caCert})
+	assert.Error(err)
+	_, err = NewVerifier(rootCert, Config{Packages: []string{"frontend_package"}, HTTPClient: crlServer.Client()}).Verify([]*x509.Certificate{backendCert, caCert})
+	assert.Error(err)
+
+	// the chain must lead to the root certificate through a package CA
+	_, err = verifier.Verify([]*x509.Certificate{backendCert})
+	assert.Error(err)
+	otherRoot, _ := quotetest.MustCreateCert(t, elliptic.P256(), "Coordinator", true, nil, nil)
+	_, err = NewVerifier(otherRoot, Config{}).Verify([]*x509.Certificate{backendCert, caCert})
+	assert.Error(err)
+	_, err = NewVerifier(caCert, Config{SkipRevocationCheck: true}).Verify([]*x509.Certificate{backendCert})
+	assert.Error(err)
+	_, err = verifier.Verify(nil)
+	assert.Error(err)
+
+	// revoked certificates are rejected once the cached CRL has expired
+	revoked = append(revoked, pkix.RevokedCertificate{SerialNumber: backendCert.SerialNumber, RevocationTime: time.Now()})
+	_, err = verifier.Verify([]*x509.Certificate{backendCert, caCert})
+	assert.NoError(err)
+	verifier.crls[crlURL].TBSCertList.NextUpdate = time.Now().Add(-time.Minute)
+	_, err = verifier.Verify([]*x509.Certificate{backendCert, caCert})
+	require.Error(err)
+	assert.Contains(err.Error(), "revoked")
+	assert.Equal(2, crlRequests)
+
+	// a fresh verifier fetches the CRL right away
+	verifier = NewVerifier(rootCert, Config{HTTPClient: crlServer.Client()})
+	_, err = verifier.Verify([]*x509.Certificate{backendCert, caCert})
+	assert.Error(err)
+	_, err = NewVerifier(rootCert, Config{HTTPClient: crlServer.Client(), SkipRevocationCheck: true}).Verify([]*x509.Certificate{backendCert, caCert})
+	assert.NoError(err)
+
+	// certificates without a distribution point are only rejected if a CRL is required
+	noCRLCert := newMarbleCert(identity.Identity{MarbleType: "backend", Package: "backend_package", UUID: "uuid-3"})
+	_, err = verifier.Verify([]*x509.Certificate{noCRLCert, caCert})
+	assert.NoError(err)
+	_, err = NewVerifier(rootCert, Config{RequireCRL: true}).Verify([]*x509.Certificate{noCRLCert, caCert})
+	assert.Error(err)
+
+	// certificates are rejected if their CRL can't be obtained or hasn't been signed by their issuer
+	unreachableCert := newMarbleCert(backendID, crlServer.URL+"/missing")
+	_, err = verifier.Verify([]*x509.Certificate{unreachableCert, caCert})
+	assert.Error(err)
+	crlSigner, crlSignerKey = rootCert, rootKey
+	verifier = NewVerifier(rootCert, Config{HTTPClient: crlServer.Client()})
+	_, err = verifier.Verify([]*x509.Certificate{newMarbleCert(backendID, crlURL), caCert})
+	assert.Error(err)
+}